@@ -2,23 +2,41 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"websocket-demo/internal/client"
 	"websocket-demo/internal/config"
 	"websocket-demo/internal/db"
 	"websocket-demo/internal/hub"
 	"websocket-demo/internal/nats"
 	"websocket-demo/internal/repository"
+	"websocket-demo/internal/repository/memory"
 	"websocket-demo/internal/server"
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/validator"
 
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
+const (
+	// shutdownDrainTimeout bounds how long shutdown waits for client connections to close
+	shutdownDrainTimeout = 10 * time.Second
+	// memoryStoreSaveInterval is how often STORAGE=memory saves to STORAGE_FILE
+	memoryStoreSaveInterval = 30 * time.Second
+)
+
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "apply pending database migrations and exit")
+	flag.Parse()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -28,20 +46,67 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize database connection
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is required")
+	// Input limits, shared by the hub and server and also made the default
+	// for packages without a config of their own
+	inputValidator, err := validator.New(cfg.ValidatorConfig())
+	if err != nil {
+		log.Fatalf("Failed to configure input validation: %v", err)
 	}
+	validator.SetDefault(inputValidator)
+	validator.SetProfanityFilter(cfg.ProfanityWords, cfg.ProfanityAction)
+	validator.SetMessageContentType(cfg.MessageContentType)
+	client.SetWriteTimeout(cfg.WSWriteTimeout)
 
-	pool, err := db.NewPool(ctx, dbURL)
+	// Tracing is a no-op unless TRACING_ENABLE is set
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracingConfig())
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("Failed to set up tracing: %v", err)
 	}
-	defer pool.Close()
 
-	queries := db.New(pool)
-	repo := repository.NewRepository(queries)
+	// Initialize storage: Postgres, or an in-memory store for development
+	var (
+		repo     repository.Store
+		pgRepo   *repository.Repository // Nil with STORAGE=memory
+		pool     *pgxpool.Pool
+		memStore *memory.Store
+	)
+	if cfg.Storage == config.StorageMemory {
+		if *migrateOnly {
+			log.Println("STORAGE=memory has no database to migrate")
+			return
+		}
+		memStore, err = memory.Open(cfg.StorageFile)
+		if err != nil {
+			log.Fatalf("Failed to open in-memory store: %v", err)
+		}
+		if cfg.StorageFile != "" {
+			log.Printf("Using in-memory storage saved to %s", cfg.StorageFile)
+			go memStore.SaveEvery(ctx, memoryStoreSaveInterval)
+		} else {
+			log.Println("Using in-memory storage; data is lost on restart")
+		}
+		repo = memStore
+	} else {
+		pool, err = db.NewPool(ctx, cfg.DatabaseURL, cfg.DBPoolConfig())
+		if err != nil {
+			log.Fatalf("Failed to connect to database: %v", err)
+		}
+		defer pool.Close()
+
+		if *migrateOnly || cfg.DBAutoMigrate {
+			applied, err := db.Migrate(ctx, pool)
+			if err != nil {
+				log.Fatalf("Failed to migrate database: %v", err)
+			}
+			log.Printf("Database schema up to date (%d migrations applied)", applied)
+			if *migrateOnly {
+				return
+			}
+		}
+
+		pgRepo = repository.NewRepository(db.New(pool), pool)
+		repo = pgRepo
+	}
 
 	// Initialize NATS client if enabled
 	var natsClient *nats.Client
@@ -50,14 +115,9 @@ func main() {
 		maxRetries := 5
 		retryDelay := 2 * time.Second
 
+		natsCfg := cfg.NATSConfig()
+
 		for i := 0; i < maxRetries; i++ {
-			natsCfg := nats.Config{
-				URL:            cfg.NATSURL,
-				MaxReconnects:  10,
-				ReconnectWait:  2 * time.Second,
-				Timeout:        10 * time.Second,
-				EnableJetStream: false,
-			}
 			natsClient, err = nats.NewClient(natsCfg)
 			if err == nil {
 				log.Println("Successfully connected to NATS")
@@ -77,20 +137,45 @@ func main() {
 		}
 	}
 
-	hub := hub.NewHub(ctx, repo, natsClient)
-	hub.LoadRoomsFromDB()
-	go hub.Run()
+	chatHub := hub.NewHub(ctx, repo, natsClient)
+	chatHub.SetValidator(inputValidator)
+	if pgRepo != nil {
+		retryPolicy := cfg.DBRetryPolicy()
+		retryPolicy.OnRetry = chatHub.Metrics.IncrementDBRetries
+		pgRepo.SetRetryPolicy(retryPolicy)
+		if cfg.DBPoolStatsInterval > 0 {
+			go db.WatchPoolStats(ctx, pool, cfg.DBPoolStatsInterval, chatHub.Metrics)
+		}
+	}
+	chatHub.LoadRoomsFromDB()
+	go chatHub.Run()
 
-	srv := server.NewServer(hub, repo)
+	srv := server.NewServer(chatHub, repo, pool, cfg)
 	srv.SetupRoutes()
 
 	go func() {
 		addr := ":" + cfg.ServerPort
-		if err := srv.Start(addr); err != nil {
+		// Shutdown closes the server; exiting here would skip the rest of it
+		if err := srv.Start(addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Server failed to start: %v", err)
 		}
 	}()
 
+	// Reload the .env file and apply the hub settings that can change at runtime on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			log.Println("Received SIGHUP, reloading hub configuration")
+			if err := config.ReloadEnv(); err != nil {
+				log.Printf("Failed to reload .env file: %v", err)
+			}
+			if _, err := srv.ReloadHubConfig(ctx, hub.LoadHubConfig(), "", "SIGHUP", "", ""); err != nil {
+				log.Printf("Failed to reload hub configuration: %v", err)
+			}
+		}
+	}()
+
 	// Wait for interrupt signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -100,9 +185,30 @@ func main() {
 
 	cancel()
 
+	// Wait for the hub to drain client connections before exiting
+	select {
+	case <-chatHub.Done():
+	case <-time.After(shutdownDrainTimeout):
+		log.Printf("Hub did not finish draining within %s", shutdownDrainTimeout)
+	}
+
 	if err := srv.Shutdown(); err != nil {
 		log.Printf("Error during server shutdown: %v", err)
 	}
 
+	// Keep what the hub stored while shutting down
+	if memStore != nil {
+		if err := memStore.Save(); err != nil {
+			log.Printf("Failed to save in-memory store: %v", err)
+		}
+	}
+
+	// Export the spans still buffered
+	tracingCtx, tracingCancel := context.WithTimeout(context.Background(), shutdownDrainTimeout)
+	defer tracingCancel()
+	if err := shutdownTracing(tracingCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+
 	log.Println("Server stopped")
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -42,6 +43,13 @@ type TestStats struct {
 	MessagesReceived int32
 	RoomsLeft        int32
 	Errors           int32
+
+	// Server-initiated disconnects, categorized by close status
+	ClosedNormal          int32
+	ClosedGoingAway       int32
+	ClosedPolicyViolation int32
+	ClosedInternalError   int32
+	ClosedOther           int32
 }
 
 var stats TestStats
@@ -87,6 +95,12 @@ func printStats(duration time.Duration) {
 	log.Printf("Messages Received: %d", atomic.LoadInt32(&stats.MessagesReceived))
 	log.Printf("Rooms Left: %d", atomic.LoadInt32(&stats.RoomsLeft))
 	log.Printf("Errors: %d", atomic.LoadInt32(&stats.Errors))
+	log.Println("Disconnects by close status:")
+	log.Printf("  Normal: %d", atomic.LoadInt32(&stats.ClosedNormal))
+	log.Printf("  Going Away: %d", atomic.LoadInt32(&stats.ClosedGoingAway))
+	log.Printf("  Policy Violation: %d", atomic.LoadInt32(&stats.ClosedPolicyViolation))
+	log.Printf("  Internal Error: %d", atomic.LoadInt32(&stats.ClosedInternalError))
+	log.Printf("  Other: %d", atomic.LoadInt32(&stats.ClosedOther))
 
 	// Calculate success rate
 	totalOps := atomic.LoadInt32(&stats.SuccessfulLogins) + atomic.LoadInt32(&stats.RoomsCreated) +
@@ -296,10 +310,37 @@ func runClient(id int) {
 	log.Printf("[Client %d] Done", id)
 }
 
+// recordClose categorizes a read error caused by a close frame from the server
+// Errors without a close frame (e.g. our own context cancellation) are ignored
+func recordClose(clientID int, err error) {
+	var closeErr websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		return
+	}
+
+	switch closeErr.Code {
+	case websocket.StatusNormalClosure:
+		atomic.AddInt32(&stats.ClosedNormal, 1)
+	case websocket.StatusGoingAway:
+		atomic.AddInt32(&stats.ClosedGoingAway, 1)
+	case websocket.StatusPolicyViolation:
+		atomic.AddInt32(&stats.ClosedPolicyViolation, 1)
+	case websocket.StatusInternalError:
+		atomic.AddInt32(&stats.ClosedInternalError, 1)
+	default:
+		atomic.AddInt32(&stats.ClosedOther, 1)
+	}
+
+	if closeErr.Code != websocket.StatusNormalClosure {
+		log.Printf("[Client %d] Connection closed by server: status=%v reason=%q", clientID, closeErr.Code, closeErr.Reason)
+	}
+}
+
 func readMessages(clientID int, conn *websocket.Conn, ctx context.Context) {
 	for {
 		_, message, err := conn.Read(ctx)
 		if err != nil {
+			recordClose(clientID, err)
 			return
 		}
 
@@ -324,6 +365,7 @@ func readMessagesWithCount(clientID int, conn *websocket.Conn, ctx context.Conte
 	for {
 		_, message, err := conn.Read(ctx)
 		if err != nil {
+			recordClose(clientID, err)
 			if localCount > 0 {
 				countChan <- localCount
 			}
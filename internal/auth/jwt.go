@@ -12,10 +12,14 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+// DefaultLeeway is the clock skew tolerated unless SetLeeway changes it
+const DefaultLeeway = 30 * time.Second
+
 // Claims represents the JWT claims structure
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
+	UserID        string `json:"user_id"`
+	Username      string `json:"username"`
+	EmailVerified bool   `json:"email_verified"` // False until the user follows the link emailed at registration
 	jwt.RegisteredClaims
 }
 
@@ -23,6 +27,7 @@ type Claims struct {
 type JWTService struct {
 	secretKey      []byte
 	expiryDuration time.Duration
+	leeway         time.Duration // Clock skew tolerated on exp/nbf/iat checks
 }
 
 // NewJWTService creates a new JWT service instance
@@ -40,15 +45,22 @@ func NewJWTService(secret string, expiry string) (*JWTService, error) {
 	return &JWTService{
 		secretKey:      []byte(secret),
 		expiryDuration: duration,
+		leeway:         DefaultLeeway,
 	}, nil
 }
 
+// SetLeeway overrides the clock skew tolerated when validating tokens
+func (j *JWTService) SetLeeway(leeway time.Duration) {
+	j.leeway = leeway
+}
+
 // GenerateToken generates a new JWT token for a user
-func (j *JWTService) GenerateToken(userID, username string) (string, error) {
+func (j *JWTService) GenerateToken(userID, username string, emailVerified bool) (string, error) {
 	now := time.Now()
 	claims := Claims{
-		UserID:   userID,
-		Username: username,
+		UserID:        userID,
+		Username:      username,
+		EmailVerified: emailVerified,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(j.expiryDuration)),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -73,7 +85,7 @@ func (j *JWTService) ValidateToken(tokenString string) (*Claims, error) {
 			return nil, ErrInvalidToken
 		}
 		return j.secretKey, nil
-	})
+	}, jwt.WithLeeway(j.leeway))
 
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
@@ -98,7 +110,7 @@ func (j *JWTService) RefreshToken(tokenString string) (string, error) {
 	}
 
 	// Generate new token with same user info
-	return j.GenerateToken(claims.UserID, claims.Username)
+	return j.GenerateToken(claims.UserID, claims.Username, claims.EmailVerified)
 }
 
 // GetUserID extracts user ID from token without full validation (for performance)
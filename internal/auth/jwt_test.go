@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "test-secret-key-that-is-at-least-32-characters-long"
+
+// signClaims signs a token whose validity window is shifted by offset from now
+func signClaims(t *testing.T, offset time.Duration, lifetime time.Duration) string {
+	now := time.Now().Add(offset)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		UserID:   "user-1",
+		Username: "alice",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(lifetime)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
+	})
+	signed, err := token.SignedString([]byte(testSecret))
+	require.NoError(t, err)
+	return signed
+}
+
+func TestValidateTokenLeeway(t *testing.T) {
+	service, err := NewJWTService(testSecret, "24h")
+	require.NoError(t, err)
+
+	// Issued by a server whose clock runs 10s ahead
+	_, err = service.ValidateToken(signClaims(t, 10*time.Second, time.Hour))
+	assert.NoError(t, err)
+
+	// Expired 10s ago
+	_, err = service.ValidateToken(signClaims(t, -time.Hour-10*time.Second, time.Hour))
+	assert.NoError(t, err)
+
+	// Outside the window
+	_, err = service.ValidateToken(signClaims(t, -time.Hour-time.Minute, time.Hour))
+	assert.ErrorIs(t, err, ErrExpiredToken)
+
+	service.SetLeeway(0)
+	_, err = service.ValidateToken(signClaims(t, 10*time.Second, time.Hour))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestEmailVerifiedClaim(t *testing.T) {
+	service, err := NewJWTService(testSecret, "24h")
+	require.NoError(t, err)
+
+	for _, verified := range []bool{true, false} {
+		token, err := service.GenerateToken("user-1", "alice", verified)
+		require.NoError(t, err)
+		claims, err := service.ValidateToken(token)
+		require.NoError(t, err)
+		assert.Equal(t, verified, claims.EmailVerified)
+
+		refreshed, err := service.RefreshToken(token)
+		require.NoError(t, err)
+		claims, err = service.ValidateToken(refreshed)
+		require.NoError(t, err)
+		assert.Equal(t, verified, claims.EmailVerified, "refreshing keeps the claim")
+	}
+
+	// Tokens issued before the claim existed count as unverified
+	claims, err := service.ValidateToken(signClaims(t, 0, time.Hour))
+	require.NoError(t, err)
+	assert.False(t, claims.EmailVerified)
+}
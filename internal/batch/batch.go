@@ -3,25 +3,25 @@ package batch
 import (
 	"sync"
 	"time"
-
-	"websocket-demo/internal/types"
 )
 
-// MessageBatch handles batching of messages for performance optimization
-type MessageBatch struct {
-	Messages   []types.Message
+// MessageBatch handles batching of messages for performance optimization.
+// T is what gets batched, e.g. types.Message for delivery or rows to insert.
+type MessageBatch[T any] struct {
+	Messages   []T
 	MaxSize    int
 	FlushAfter time.Duration
 	Timer      *time.Timer
 	Mutex      sync.Mutex
-	FlushFunc  func([]types.Message)
+	FlushFunc  func([]T)
 	done       chan struct{}
+	stopOnce   sync.Once
 }
 
 // NewMessageBatch creates a new message batch
-func NewMessageBatch(maxSize int, flushAfter time.Duration, flushFunc func([]types.Message)) *MessageBatch {
-	b := &MessageBatch{
-		Messages:   make([]types.Message, 0, maxSize),
+func NewMessageBatch[T any](maxSize int, flushAfter time.Duration, flushFunc func([]T)) *MessageBatch[T] {
+	b := &MessageBatch[T]{
+		Messages:   make([]T, 0, maxSize),
 		MaxSize:    maxSize,
 		FlushAfter: flushAfter,
 		FlushFunc:  flushFunc,
@@ -33,7 +33,7 @@ func NewMessageBatch(maxSize int, flushAfter time.Duration, flushFunc func([]typ
 }
 
 // Add adds a message to the batch
-func (b *MessageBatch) Add(msg types.Message) {
+func (b *MessageBatch[T]) Add(msg T) {
 	b.Mutex.Lock()
 	defer b.Mutex.Unlock()
 
@@ -49,24 +49,34 @@ func (b *MessageBatch) Add(msg types.Message) {
 }
 
 // flush flushes the current batch
-func (b *MessageBatch) flush() {
-	if len(b.Messages) == 0 {
+func (b *MessageBatch[T]) flush() {
+	messages := b.take()
+	if len(messages) == 0 {
 		return
 	}
 
+	// Call flush function in goroutine to avoid blocking
+	go b.FlushFunc(messages)
+}
+
+// take returns a copy of the pending messages and clears the batch; callers
+// must hold b.Mutex
+func (b *MessageBatch[T]) take() []T {
+	if len(b.Messages) == 0 {
+		return nil
+	}
+
 	// Copy messages to avoid race conditions
-	messages := make([]types.Message, len(b.Messages))
+	messages := make([]T, len(b.Messages))
 	copy(messages, b.Messages)
 
 	// Clear batch
 	b.Messages = b.Messages[:0]
-
-	// Call flush function in goroutine to avoid blocking
-	go b.FlushFunc(messages)
+	return messages
 }
 
 // startTimer starts the flush timer
-func (b *MessageBatch) startTimer() {
+func (b *MessageBatch[T]) startTimer() {
 	for {
 		select {
 		case <-b.Timer.C:
@@ -79,26 +89,29 @@ func (b *MessageBatch) startTimer() {
 	}
 }
 
-// Stop stops the batch processor
-func (b *MessageBatch) Stop() {
-	b.Mutex.Lock()
-	defer b.Mutex.Unlock()
-
-	if b.Timer != nil {
-		b.Timer.Stop()
-	}
+// Stop stops the batch processor and flushes the remaining messages before
+// returning. Calling it again does nothing.
+func (b *MessageBatch[T]) Stop() {
+	b.stopOnce.Do(func() {
+		b.Mutex.Lock()
+		if b.Timer != nil {
+			b.Timer.Stop()
+		}
 
-	// Signal goroutine to exit
-	close(b.done)
+		// Signal goroutine to exit
+		close(b.done)
+		messages := b.take()
+		b.Mutex.Unlock()
 
-	// Flush remaining messages
-	if len(b.Messages) > 0 {
-		b.flush()
-	}
+		// Flush remaining messages synchronously, outside the lock
+		if len(messages) > 0 {
+			b.FlushFunc(messages)
+		}
+	})
 }
 
 // Size returns the current batch size
-func (b *MessageBatch) Size() int {
+func (b *MessageBatch[T]) Size() int {
 	b.Mutex.Lock()
 	defer b.Mutex.Unlock()
 	return len(b.Messages)
@@ -0,0 +1,213 @@
+package batch
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flushRecorder records every batch passed to FlushFunc
+type flushRecorder struct {
+	mu      sync.Mutex
+	batches [][]types.Message
+	times   []time.Time
+}
+
+func (r *flushRecorder) flush(messages []types.Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.batches = append(r.batches, messages)
+	r.times = append(r.times, time.Now())
+}
+
+func (r *flushRecorder) calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.batches)
+}
+
+func (r *flushRecorder) total() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, batch := range r.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+func (r *flushRecorder) batch(i int) []types.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.batches[i]
+}
+
+func message(i int) types.Message {
+	return types.Message{Content: []byte(fmt.Sprintf("message %d", i)), Type: types.MsgTypeRoomMessage}
+}
+
+func TestBatchWaitsForTimerBelowMaxSize(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(5, 200*time.Millisecond, recorder.flush)
+	defer b.Stop()
+
+	for i := 0; i < 4; i++ {
+		b.Add(message(i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, recorder.calls(), "a partial batch waits for the timer")
+
+	require.Eventually(t, func() bool { return recorder.calls() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Len(t, recorder.batch(0), 4)
+	assert.Equal(t, 0, b.Size())
+}
+
+func TestBatchFlushesAtMaxSize(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(5, time.Hour, recorder.flush)
+	defer b.Stop()
+
+	for i := 0; i < 5; i++ {
+		b.Add(message(i))
+	}
+	assert.Equal(t, 0, b.Size())
+	require.Eventually(t, func() bool { return recorder.calls() == 1 }, 100*time.Millisecond, time.Millisecond)
+	assert.Equal(t, []types.Message{message(0), message(1), message(2), message(3), message(4)}, recorder.batch(0))
+}
+
+func TestBatchStopFlushesSynchronously(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(10, time.Hour, recorder.flush)
+
+	b.Add(message(1))
+	b.Add(message(2))
+	b.Add(message(3))
+	b.Stop()
+
+	// No waiting: the flush has happened by the time Stop returns
+	require.Equal(t, 1, recorder.calls())
+	assert.Len(t, recorder.batch(0), 3)
+	assert.Equal(t, 0, b.Size())
+}
+
+func TestBatchConcurrentAdd(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(7, 5*time.Millisecond, recorder.flush)
+
+	const goroutines, perGoroutine = 100, 50
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				b.Add(message(g*perGoroutine + i))
+			}
+		}(g)
+	}
+	wg.Wait()
+	b.Stop()
+
+	// Flushes started before Stop may still be running
+	assert.Eventually(t, func() bool { return recorder.total() == goroutines*perGoroutine }, time.Second, 5*time.Millisecond)
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, batch := range recorder.batches {
+		assert.LessOrEqual(t, len(batch), 7)
+		for _, msg := range batch {
+			seen[string(msg.Content)] = true
+		}
+	}
+	assert.Len(t, seen, goroutines*perGoroutine, "every message is flushed exactly once")
+}
+
+func TestBatchSize(t *testing.T) {
+	b := NewMessageBatch(3, time.Hour, func([]types.Message) {})
+	defer b.Stop()
+
+	assert.Equal(t, 0, b.Size())
+	b.Add(message(1))
+	assert.Equal(t, 1, b.Size())
+	b.Add(message(2))
+	assert.Equal(t, 2, b.Size())
+	b.Add(message(3)) // Full, flushed
+	assert.Equal(t, 0, b.Size())
+	b.Add(message(4))
+	assert.Equal(t, 1, b.Size())
+}
+
+func TestBatchTimerResetsOnAdd(t *testing.T) {
+	const window = 100 * time.Millisecond
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(10, window, recorder.flush)
+	defer b.Stop()
+
+	start := time.Now()
+	b.Add(message(1))
+	time.Sleep(90 * time.Millisecond)
+	b.Add(message(2))
+
+	// The first window would have ended at ~100ms; the reset pushes it to ~190ms
+	time.Sleep(150*time.Millisecond - time.Since(start))
+	assert.Equal(t, 0, recorder.calls())
+
+	require.Eventually(t, func() bool { return recorder.calls() == 1 }, time.Second, time.Millisecond)
+	assert.Len(t, recorder.batch(0), 2)
+	recorder.mu.Lock()
+	assert.GreaterOrEqual(t, recorder.times[0].Sub(start), 180*time.Millisecond)
+	recorder.mu.Unlock()
+}
+
+func TestBatchStopTwice(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(10, time.Hour, recorder.flush)
+	b.Add(message(1))
+
+	assert.NotPanics(t, func() {
+		b.Stop()
+		b.Stop()
+	})
+	assert.Equal(t, 1, recorder.calls())
+}
+
+func TestBatchStopEmpty(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(10, 10*time.Millisecond, recorder.flush)
+
+	// Let the initial timer fire with nothing to flush
+	time.Sleep(30 * time.Millisecond)
+	b.Stop()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, 0, recorder.calls())
+}
+
+func TestBatchFlushesACopy(t *testing.T) {
+	recorder := &flushRecorder{}
+	b := NewMessageBatch(2, time.Hour, recorder.flush)
+	defer b.Stop()
+
+	b.Add(message(1))
+	b.Add(message(2))
+	require.Eventually(t, func() bool { return recorder.calls() == 1 }, time.Second, time.Millisecond)
+	first := recorder.batch(0)
+
+	// Refilling the batch reuses its internal slice; the flushed copy is untouched
+	b.Add(message(3))
+	b.Add(message(4))
+	require.Eventually(t, func() bool { return recorder.calls() == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []types.Message{message(1), message(2)}, first)
+	assert.Equal(t, []types.Message{message(3), message(4)}, recorder.batch(1))
+
+	// Changing a flushed batch doesn't reach the pending messages either
+	b.Add(message(5))
+	first[0] = message(99)
+	b.Stop()
+	assert.Equal(t, []types.Message{message(5)}, recorder.batch(2))
+}
@@ -1,29 +1,61 @@
 package client
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/coder/websocket"
+	"github.com/google/uuid"
 )
 
+// DefaultWriteTimeout is the per-write timeout used when WS_WRITE_TIMEOUT is
+// unset, long enough for slow mobile links
+const DefaultWriteTimeout = 5 * time.Second
+
+// writeTimeout holds the timeout set with SetWriteTimeout
+var writeTimeout atomic.Int64
+
+// ErrNoConnection is returned when writing to a client without a connection
+var ErrNoConnection = errors.New("client has no connection")
+
 // Client represents a WebSocket client connection
 type Client struct {
 	Conn           *websocket.Conn
 	Name           string
 	UserID         string
+	ID             string        // Per-connection UUID logged as conn_id; UserID is shared by all of a user's connections
+	RequestID      string        // X-Request-ID of the upgrade request, logged as request_id
+	TraceParent    string        // W3C traceparent of the upgrade request, if it sent a valid one
 	Registered     chan struct{} // Signal when this client is registered
 	Authenticated  bool          // Track if client is authenticated
+	Admin          bool          // User is listed in ADMIN_USER_IDS
 	CurrentRoom    interface{}   // Track current room (will be *room.Room)
+	JoinedAt       time.Time     // When the client joined its current room
 	RoomMutex      sync.RWMutex  // Thread safety for room tracking
 	RegisteredOnce sync.Once     // Ensure Registered channel is closed only once
+	Refused        bool          // Set before Registered closes when the hub turned the client away
+	WriteTimeout   time.Duration // Per-write timeout, DefaultWriteTimeout when zero
+
+	// Session details shown to the user when listing their connections
+	SessionID   string
+	RemoteAddr  string
+	UserAgent   string
+	ConnectedAt time.Time
 }
 
 // NewClient creates a new client instance
 func NewClient(conn *websocket.Conn, name string) *Client {
 	return &Client{
-		Conn:       conn,
-		Name:       name,
-		Registered: make(chan struct{}),
+		Conn:         conn,
+		Name:         name,
+		ID:           uuid.NewString(),
+		Registered:   make(chan struct{}),
+		WriteTimeout: GetWriteTimeout(),
+		SessionID:    uuid.NewString(),
+		ConnectedAt:  time.Now(),
 	}
 }
 
@@ -41,12 +73,78 @@ func (c *Client) SetCurrentRoom(room interface{}) {
 	c.CurrentRoom = room
 }
 
+// GetJoinedAt returns when the client joined its current room
+func (c *Client) GetJoinedAt() time.Time {
+	c.RoomMutex.RLock()
+	defer c.RoomMutex.RUnlock()
+	return c.JoinedAt
+}
+
+// SetJoinedAt records when the client joined its current room
+func (c *Client) SetJoinedAt(joinedAt time.Time) {
+	c.RoomMutex.Lock()
+	defer c.RoomMutex.Unlock()
+	c.JoinedAt = joinedAt
+}
+
 // GetID returns the user ID of the client
 func (c *Client) GetID() string {
 	return c.UserID
 }
 
+// GetConnID returns the client's per-connection ID
+func (c *Client) GetConnID() string {
+	return c.ID
+}
+
 // GetName returns the name of the client
 func (c *Client) GetName() string {
 	return c.Name
 }
+
+// GetWriteTimeout returns the per-write timeout given to new clients, set
+// with SetWriteTimeout or DefaultWriteTimeout
+func GetWriteTimeout() time.Duration {
+	if timeout := time.Duration(writeTimeout.Load()); timeout > 0 {
+		return timeout
+	}
+	return DefaultWriteTimeout
+}
+
+// SetWriteTimeout sets the per-write timeout given to new clients; zero
+// restores DefaultWriteTimeout. config.Load reads it from WS_WRITE_TIMEOUT.
+func SetWriteTimeout(timeout time.Duration) {
+	writeTimeout.Store(int64(timeout))
+}
+
+// WriteMessage writes a text message bounded by the client's write timeout
+func (c *Client) WriteMessage(ctx context.Context, msg []byte) error {
+	return c.write(ctx, websocket.MessageText, msg)
+}
+
+// WriteBinary writes a binary message bounded by the client's write timeout
+func (c *Client) WriteBinary(ctx context.Context, msg []byte) error {
+	return c.write(ctx, websocket.MessageBinary, msg)
+}
+
+func (c *Client) write(ctx context.Context, typ websocket.MessageType, msg []byte) error {
+	if c.Conn == nil {
+		return ErrNoConnection
+	}
+
+	timeout := c.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return c.Conn.Write(writeCtx, typ, msg)
+}
+
+// IsWriteTimeout reports whether a write failed by missing its deadline. The
+// websocket library closes the connection when that happens, so the client is
+// gone all the same.
+func IsWriteTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
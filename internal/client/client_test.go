@@ -1,9 +1,11 @@
 package client
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/coder/websocket"
 	"github.com/stretchr/testify/assert"
@@ -20,6 +22,15 @@ func TestNewClient(t *testing.T) {
 	assert.Nil(t, client.GetCurrentRoom())
 }
 
+func TestNewClientConnectionIDs(t *testing.T) {
+	first := NewClient(nil, "alice")
+	second := NewClient(nil, "alice")
+	first.UserID, second.UserID = "user-1", "user-1"
+
+	assert.NotEmpty(t, first.ID)
+	assert.NotEqual(t, first.ID, second.ID, "connections of the same user get their own IDs")
+}
+
 func TestSetCurrentRoom(t *testing.T) {
 	client := NewClient(nil, "TestUser")
 
@@ -47,4 +58,24 @@ func TestConcurrentRoomAccess(t *testing.T) {
 	}
 
 	wg.Wait()
-}
\ No newline at end of file
+}
+func TestGetWriteTimeout(t *testing.T) {
+	t.Cleanup(func() { SetWriteTimeout(0) })
+	assert.Equal(t, DefaultWriteTimeout, GetWriteTimeout())
+
+	SetWriteTimeout(5 * time.Second)
+	assert.Equal(t, 5*time.Second, GetWriteTimeout())
+	assert.Equal(t, 5*time.Second, NewClient(nil, "TestUser").WriteTimeout)
+
+	SetWriteTimeout(0)
+	assert.Equal(t, DefaultWriteTimeout, GetWriteTimeout())
+}
+
+func TestWriteMessageWithoutConnection(t *testing.T) {
+	client := NewClient(nil, "TestUser")
+
+	err := client.WriteMessage(context.Background(), []byte("hello"))
+	assert.ErrorIs(t, err, ErrNoConnection)
+	assert.False(t, IsWriteTimeout(err))
+	assert.True(t, IsWriteTimeout(fmt.Errorf("failed to write msg: %w", context.DeadlineExceeded)))
+}
@@ -2,20 +2,115 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/nats"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/validator"
 
 	"github.com/joho/godotenv"
 )
 
+// Storage backends selected with STORAGE
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// Config holds every setting read from the environment. Load validates it,
+// and packages receive their settings from it rather than reading variables
+// themselves; the hub's runtime-reloadable settings live in hub.HubConfig.
 type Config struct {
+	Storage     string // StoragePostgres or StorageMemory
+	StorageFile string // JSON file the memory store is saved to; empty keeps nothing across restarts
 	DatabaseURL string
 	ServerPort  string
 	ServerHost  string
 	JWTSecret   string
 	JWTExpiry   string
+	JWTLeeway   time.Duration // Clock skew tolerated on token time checks
 	TestMode    bool
-	NATSURL     string
+	NATSURL     string // One or more comma-separated server URLs
 	NATSEnable  bool
+
+	// NATS connection behaviour once connected
+	NATSMaxReconnects int           // -1 retries forever
+	NATSReconnectWait time.Duration // Delay between reconnect attempts
+	NATSTimeout       time.Duration // Dial timeout
+	NATSDrainTimeout  time.Duration // How long shutdown waits for pending NATS messages
+
+	// JetStream durable streams, opt-in via NATS_JETSTREAM
+	NATSJetStream      bool
+	NATSStreamMaxAge   time.Duration
+	NATSStreamMaxMsgs  int64
+	NATSStreamMaxBytes int64
+	NATSConsumerName   string
+
+	// NATS authentication and TLS; see nats.Config.Validate for allowed combinations
+	NATSToken        string
+	NATSUser         string
+	NATSPassword     string
+	NATSCredsFile    string
+	NATSNKeySeedFile string
+	NATSTLSCAFile    string
+	NATSTLSCertFile  string
+	NATSTLSKeyFile   string
+
+	// Origins allowed to open WebSocket connections besides the server's own host
+	WSAllowedOrigins []string
+
+	// Web app users are sent to after following their email verification link
+	FrontendURL string
+	// Where clients reach this server's API, used for links in emails
+	PublicURL string
+
+	// Database connection pool
+	DBMaxConns              int
+	DBMinConns              int
+	DBMaxConnLifetime       time.Duration
+	DBMaxConnIdleTime       time.Duration
+	DBHealthCheckPeriod     time.Duration
+	DBMaxConnLifetimeJitter time.Duration
+	DBStatementCacheSize    int
+	DBAutoMigrate           bool          // Apply pending migrations at startup
+	DBRetryAttempts         int           // Tries per hot-path write, including the first
+	DBRetryBackoff          time.Duration // Delay before the first retry, doubled per retry
+	DBSlowQueryThreshold    time.Duration // Queries at least this slow are logged and counted; 0 turns it off
+	DBPoolStatsInterval     time.Duration // How often pool statistics are sampled into metrics; 0 turns it off
+
+	AdminUserIDs      []string      // Users allowed to use /api/admin
+	WSMaxMessageSize  int           // Largest accepted WebSocket message, up to validator.MaxMessageSize
+	WSWriteTimeout    time.Duration // Per-write timeout for WebSocket clients
+	MaxBatchLines     int           // Messages allowed in one NDJSON frame
+	ReservedRoomNames []string      // Room names users can't create
+
+	// Account and room name rules; names are capped at the 50-character columns
+	MinPasswordLength int
+	MinPasswordScore  int // Strength score from 1 to 4 a new password must reach
+	UsernameMinLength int
+	UsernameMaxLength int
+	ReservedUsernames []string // Usernames nobody can register, nor look-alikes of them
+	MaxRoomNameLength int
+	WeakPasswordsFile string // Passwords rejected as too common, one per line; empty uses the bundled list
+
+	// Words blocked in chat messages and what happens to messages containing them
+	ProfanityWords  []string
+	ProfanityAction validator.ProfanityAction
+
+	// Links allowed in chat messages, from LINK_POLICY, LINK_ALLOWED_DOMAINS and LINK_DENIED_DOMAINS
+	LinkPolicy validator.LinkPolicy
+
+	// How chat messages are written, which picks the policy that sanitizes them
+	MessageContentType validator.ContentType
+
+	// OpenTelemetry tracing, exported as set by the standard OTEL_* variables
+	TracingEnable bool
 }
 
 // Load loads configuration from environment variables
@@ -27,6 +122,8 @@ func Load() (*Config, error) {
 	}
 
 	cfg := &Config{
+		Storage:     strings.ToLower(strings.TrimSpace(getEnv("STORAGE", StoragePostgres))),
+		StorageFile: getEnv("STORAGE_FILE", ""),
 		DatabaseURL: getEnv("DATABASE_URL", ""),
 		ServerPort:  getEnv("SERVER_PORT", "8080"),
 		ServerHost:  getEnv("SERVER_HOST", "0.0.0.0"),
@@ -34,12 +131,88 @@ func Load() (*Config, error) {
 		JWTExpiry:   getEnv("JWT_EXPIRATION", "24h"),
 		TestMode:    getEnv("TEST_MODE", "false") == "true",
 		NATSURL:     getEnv("NATS_URL", "nats://localhost:4222"),
-		NATSEnable:  getEnv("NATS_ENABLE", "true") == "true",
+
+		NATSConsumerName: getEnv("NATS_CONSUMER_NAME", hostname()),
+
+		NATSToken:        getEnv("NATS_TOKEN", ""),
+		NATSUser:         getEnv("NATS_USER", ""),
+		NATSPassword:     getEnv("NATS_PASSWORD", ""),
+		NATSCredsFile:    getEnv("NATS_CREDS_FILE", ""),
+		NATSNKeySeedFile: getEnv("NATS_NKEY_SEED_FILE", ""),
+		NATSTLSCAFile:    getEnv("NATS_TLS_CA_FILE", ""),
+		NATSTLSCertFile:  getEnv("NATS_TLS_CERT_FILE", ""),
+		NATSTLSKeyFile:   getEnv("NATS_TLS_KEY_FILE", ""),
 	}
 
-	// Validate required fields
-	if cfg.DatabaseURL == "" {
-		return nil, fmt.Errorf("DATABASE_URL environment variable is required")
+	var err error
+	if cfg.NATSEnable, err = strconv.ParseBool(getEnv("NATS_ENABLE", "true")); err != nil {
+		return nil, fmt.Errorf("invalid NATS_ENABLE: %w", err)
+	}
+	if cfg.TracingEnable, err = strconv.ParseBool(getEnv("TRACING_ENABLE", "false")); err != nil {
+		return nil, fmt.Errorf("invalid TRACING_ENABLE: %w", err)
+	}
+	if cfg.NATSJetStream, err = strconv.ParseBool(getEnv("NATS_JETSTREAM", "false")); err != nil {
+		return nil, fmt.Errorf("invalid NATS_JETSTREAM: %w", err)
+	}
+	if cfg.NATSMaxReconnects, err = strconv.Atoi(getEnv("NATS_MAX_RECONNECTS", "10")); err != nil || cfg.NATSMaxReconnects < -1 {
+		return nil, fmt.Errorf("invalid NATS_MAX_RECONNECTS: must be -1 or more")
+	}
+	if cfg.NATSReconnectWait, err = parsePositiveDuration(getEnv("NATS_RECONNECT_WAIT", "2s")); err != nil {
+		return nil, fmt.Errorf("invalid NATS_RECONNECT_WAIT: %w", err)
+	}
+	if cfg.NATSTimeout, err = parsePositiveDuration(getEnv("NATS_TIMEOUT", "10s")); err != nil {
+		return nil, fmt.Errorf("invalid NATS_TIMEOUT: %w", err)
+	}
+	if cfg.NATSDrainTimeout, err = parsePositiveDuration(getEnv("NATS_DRAIN_TIMEOUT", "5s")); err != nil {
+		return nil, fmt.Errorf("invalid NATS_DRAIN_TIMEOUT: %w", err)
+	}
+	if cfg.NATSStreamMaxAge, err = time.ParseDuration(getEnv("NATS_STREAM_MAX_AGE", "24h")); err != nil {
+		return nil, fmt.Errorf("invalid NATS_STREAM_MAX_AGE: %w", err)
+	}
+	if cfg.NATSStreamMaxMsgs, err = strconv.ParseInt(getEnv("NATS_STREAM_MAX_MSGS", "100000"), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid NATS_STREAM_MAX_MSGS: %w", err)
+	}
+	if cfg.NATSStreamMaxBytes, err = strconv.ParseInt(getEnv("NATS_STREAM_MAX_BYTES", "-1"), 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid NATS_STREAM_MAX_BYTES: %w", err)
+	}
+	if cfg.WSAllowedOrigins, err = ParseOriginPatterns(getEnv("WS_ALLOWED_ORIGINS", "")); err != nil {
+		return nil, fmt.Errorf("invalid WS_ALLOWED_ORIGINS: %w", err)
+	}
+	if cfg.FrontendURL, err = ParseFrontendURL(getEnv("FRONTEND_URL", "http://localhost:3000")); err != nil {
+		return nil, fmt.Errorf("invalid FRONTEND_URL: %w", err)
+	}
+	if cfg.PublicURL, err = ParseFrontendURL(getEnv("PUBLIC_URL", "http://localhost:"+cfg.ServerPort)); err != nil {
+		return nil, fmt.Errorf("invalid PUBLIC_URL: %w", err)
+	}
+	if err := cfg.loadServerSettings(); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadValidatorSettings(); err != nil {
+		return nil, err
+	}
+	if err := cfg.loadDBPoolSettings(); err != nil {
+		return nil, err
+	}
+
+	// Settings that only matter with NATS on are checked only then
+	if cfg.NATSEnable {
+		if err := ValidateNATSURL(cfg.NATSURL); err != nil {
+			return nil, fmt.Errorf("invalid NATS_URL: %w", err)
+		}
+		if err := cfg.NATSConfig().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid NATS configuration: %w", err)
+		}
+	}
+
+	// Validate required fields; the memory store needs no database
+	switch cfg.Storage {
+	case StoragePostgres:
+		if cfg.DatabaseURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL environment variable is required")
+		}
+	case StorageMemory:
+	default:
+		return nil, fmt.Errorf("invalid STORAGE: must be %s or %s", StoragePostgres, StorageMemory)
 	}
 
 	// Validate JWT secret
@@ -55,9 +228,346 @@ func Load() (*Config, error) {
 	return cfg, nil
 }
 
+// NATSConfig returns the NATS client configuration derived from cfg
+func (cfg *Config) NATSConfig() nats.Config {
+	return nats.Config{
+		URL:             cfg.NATSURL,
+		MaxReconnects:   cfg.NATSMaxReconnects,
+		ReconnectWait:   cfg.NATSReconnectWait,
+		Timeout:         cfg.NATSTimeout,
+		DrainTimeout:    cfg.NATSDrainTimeout,
+		EnableJetStream: cfg.NATSJetStream,
+		StreamMaxAge:    cfg.NATSStreamMaxAge,
+		StreamMaxMsgs:   cfg.NATSStreamMaxMsgs,
+		StreamMaxBytes:  cfg.NATSStreamMaxBytes,
+		ConsumerName:    cfg.NATSConsumerName,
+		Token:           cfg.NATSToken,
+		User:            cfg.NATSUser,
+		Password:        cfg.NATSPassword,
+		CredsFile:       cfg.NATSCredsFile,
+		NKeySeedFile:    cfg.NATSNKeySeedFile,
+		TLSCAFile:       cfg.NATSTLSCAFile,
+		TLSCertFile:     cfg.NATSTLSCertFile,
+		TLSKeyFile:      cfg.NATSTLSKeyFile,
+	}
+}
+
+// ValidateNATSURL checks a comma-separated list of NATS server URLs. Entries
+// without a scheme are taken as nats:// like the client library does.
+func ValidateNATSURL(raw string) error {
+	if strings.TrimSpace(raw) == "" {
+		return fmt.Errorf("no server URL given")
+	}
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if !strings.Contains(entry, "://") {
+			entry = "nats://" + entry
+		}
+		u, err := url.Parse(entry)
+		if err != nil {
+			return fmt.Errorf("server %q is not a valid URL", entry)
+		}
+		switch u.Scheme {
+		case "nats", "tls", "ws", "wss":
+		default:
+			return fmt.Errorf("server %q must use nats, tls, ws or wss", entry)
+		}
+		if u.Hostname() == "" {
+			return fmt.Errorf("server %q has no host", entry)
+		}
+		if port := u.Port(); port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return fmt.Errorf("server %q has an invalid port", entry)
+			}
+		}
+	}
+	return nil
+}
+
+// loadServerSettings reads the JWT, admin, WebSocket and message filter settings
+func (cfg *Config) loadServerSettings() error {
+	var err error
+	if _, err := parsePositiveDuration(cfg.JWTExpiry); err != nil {
+		return fmt.Errorf("invalid JWT_EXPIRATION: %w", err)
+	}
+	if cfg.JWTLeeway, err = time.ParseDuration(getEnv("JWT_LEEWAY", "30s")); err != nil || cfg.JWTLeeway < 0 {
+		return fmt.Errorf("invalid JWT_LEEWAY: must be a duration of 0 or more")
+	}
+	if cfg.WSMaxMessageSize, err = parseInt(getEnv("WS_MAX_MESSAGE_SIZE", strconv.Itoa(validator.MaxMessageSizeDefault)), 1); err != nil {
+		return fmt.Errorf("invalid WS_MAX_MESSAGE_SIZE: %w", err)
+	}
+	if cfg.WSMaxMessageSize > validator.MaxMessageSize {
+		return fmt.Errorf("invalid WS_MAX_MESSAGE_SIZE: must be at most %d", validator.MaxMessageSize)
+	}
+	if cfg.WSWriteTimeout, err = parsePositiveDuration(getEnv("WS_WRITE_TIMEOUT", "5s")); err != nil {
+		return fmt.Errorf("invalid WS_WRITE_TIMEOUT: %w", err)
+	}
+	if cfg.MaxBatchLines, err = parseInt(getEnv("MAX_BATCH_LINES", "50"), 1); err != nil {
+		return fmt.Errorf("invalid MAX_BATCH_LINES: %w", err)
+	}
+
+	cfg.AdminUserIDs = splitList(getEnv("ADMIN_USER_IDS", ""))
+	cfg.ReservedRoomNames = validator.DefaultReservedRoomNames
+	if raw := getEnv("RESERVED_ROOM_NAMES", ""); raw != "" {
+		cfg.ReservedRoomNames = splitList(raw)
+	}
+	cfg.ProfanityWords = splitList(getEnv("PROFANITY_WORDS", ""))
+	if cfg.ProfanityAction, err = validator.ParseProfanityAction(getEnv("PROFANITY_ACTION", string(validator.DefaultProfanityAction))); err != nil {
+		return fmt.Errorf("invalid PROFANITY_ACTION: %w", err)
+	}
+	if cfg.LinkPolicy, err = validator.ParseLinkPolicy(getEnv("LINK_POLICY", string(validator.DefaultLinkMode)),
+		splitList(getEnv("LINK_ALLOWED_DOMAINS", "")), splitList(getEnv("LINK_DENIED_DOMAINS", ""))); err != nil {
+		return fmt.Errorf("invalid LINK_POLICY: %w", err)
+	}
+	if cfg.MessageContentType, err = validator.ParseContentType(getEnv("MESSAGE_CONTENT_TYPE", string(validator.DefaultContentType))); err != nil {
+		return fmt.Errorf("invalid MESSAGE_CONTENT_TYPE: %w", err)
+	}
+	return nil
+}
+
+// maxNameColumnLength is the width of the users.username and rooms.name columns
+const maxNameColumnLength = 50
+
+// loadValidatorSettings reads the password, username and room name rules
+func (cfg *Config) loadValidatorSettings() error {
+	var err error
+	if cfg.MinPasswordLength, err = parseInt(getEnv("MIN_PASSWORD_LENGTH", strconv.Itoa(validator.DefaultMinPasswordLength)), 1); err != nil {
+		return fmt.Errorf("invalid MIN_PASSWORD_LENGTH: %w", err)
+	}
+	if cfg.MinPasswordScore, err = parseInt(getEnv("MIN_PASSWORD_SCORE", strconv.Itoa(validator.DefaultMinPasswordScore)), 1); err != nil {
+		return fmt.Errorf("invalid MIN_PASSWORD_SCORE: %w", err)
+	}
+	if cfg.MinPasswordScore > validator.MaxPasswordScore {
+		return fmt.Errorf("invalid MIN_PASSWORD_SCORE: must be at most %d", validator.MaxPasswordScore)
+	}
+	if cfg.UsernameMinLength, err = parseInt(getEnv("USERNAME_MIN_LENGTH", strconv.Itoa(validator.DefaultMinUsernameLength)), 1); err != nil {
+		return fmt.Errorf("invalid USERNAME_MIN_LENGTH: %w", err)
+	}
+	if cfg.UsernameMaxLength, err = parseInt(getEnv("USERNAME_MAX_LENGTH", strconv.Itoa(validator.DefaultMaxUsernameLength)), cfg.UsernameMinLength); err != nil {
+		return fmt.Errorf("invalid USERNAME_MAX_LENGTH: %w", err)
+	}
+	if cfg.UsernameMaxLength > maxNameColumnLength {
+		return fmt.Errorf("invalid USERNAME_MAX_LENGTH: must be at most %d", maxNameColumnLength)
+	}
+	if cfg.MaxRoomNameLength, err = parseInt(getEnv("MAX_ROOM_NAME_LENGTH", strconv.Itoa(validator.DefaultMaxRoomNameLength)), 2); err != nil {
+		return fmt.Errorf("invalid MAX_ROOM_NAME_LENGTH: %w", err)
+	}
+	if cfg.MaxRoomNameLength > maxNameColumnLength {
+		return fmt.Errorf("invalid MAX_ROOM_NAME_LENGTH: must be at most %d", maxNameColumnLength)
+	}
+	cfg.ReservedUsernames = validator.DefaultReservedUsernames
+	if raw := getEnv("RESERVED_USERNAMES", ""); raw != "" {
+		cfg.ReservedUsernames = splitList(raw)
+	}
+	cfg.WeakPasswordsFile = getEnv("WEAK_PASSWORDS_FILE", "")
+	return nil
+}
+
+// ValidatorConfig returns the input validation limits derived from cfg
+func (cfg *Config) ValidatorConfig() validator.Config {
+	return validator.Config{
+		MaxMessageSize:    cfg.WSMaxMessageSize,
+		MinPasswordLength: cfg.MinPasswordLength,
+		MinPasswordScore:  cfg.MinPasswordScore,
+		MinUsernameLength: cfg.UsernameMinLength,
+		MaxUsernameLength: cfg.UsernameMaxLength,
+		ReservedUsernames: cfg.ReservedUsernames,
+		MaxRoomNameLength: cfg.MaxRoomNameLength,
+		ReservedRoomNames: cfg.ReservedRoomNames,
+		WeakPasswordsFile: cfg.WeakPasswordsFile,
+		LinkPolicy:        cfg.LinkPolicy,
+	}
+}
+
+// loadDBPoolSettings reads the DB_* connection pool, retry and migration settings
+func (cfg *Config) loadDBPoolSettings() error {
+	defaults := db.DefaultPoolConfig()
+	var err error
+	if cfg.DBAutoMigrate, err = strconv.ParseBool(getEnv("DB_AUTO_MIGRATE", "false")); err != nil {
+		return fmt.Errorf("invalid DB_AUTO_MIGRATE: %w", err)
+	}
+	if cfg.DBMaxConns, err = parseInt(getEnv("DB_MAX_CONNECTIONS", strconv.Itoa(int(defaults.MaxConns))), 1); err != nil {
+		return fmt.Errorf("invalid DB_MAX_CONNECTIONS: %w", err)
+	}
+	if cfg.DBMinConns, err = parseInt(getEnv("DB_MIN_CONNECTIONS", strconv.Itoa(int(defaults.MinConns))), 0); err != nil {
+		return fmt.Errorf("invalid DB_MIN_CONNECTIONS: %w", err)
+	}
+	if cfg.DBMinConns > cfg.DBMaxConns {
+		return fmt.Errorf("DB_MIN_CONNECTIONS (%d) cannot be greater than DB_MAX_CONNECTIONS (%d)", cfg.DBMinConns, cfg.DBMaxConns)
+	}
+	if cfg.DBStatementCacheSize, err = parseInt(getEnv("DB_STATEMENT_CACHE_SIZE", strconv.Itoa(defaults.StatementCacheSize)), 0); err != nil {
+		return fmt.Errorf("invalid DB_STATEMENT_CACHE_SIZE: %w", err)
+	}
+	if cfg.DBRetryAttempts, err = parseInt(getEnv("DB_RETRY_ATTEMPTS", strconv.Itoa(repository.DefaultRetryPolicy.Attempts)), 1); err != nil {
+		return fmt.Errorf("invalid DB_RETRY_ATTEMPTS: %w", err)
+	}
+	if cfg.DBSlowQueryThreshold, err = time.ParseDuration(getEnv("DB_SLOW_QUERY_THRESHOLD", defaults.SlowQueryThreshold.String())); err != nil || cfg.DBSlowQueryThreshold < 0 {
+		return fmt.Errorf("invalid DB_SLOW_QUERY_THRESHOLD: must be a duration of 0 or more")
+	}
+	if cfg.DBPoolStatsInterval, err = time.ParseDuration(getEnv("DB_POOL_STATS_INTERVAL", db.DefaultPoolStatsInterval.String())); err != nil || cfg.DBPoolStatsInterval < 0 {
+		return fmt.Errorf("invalid DB_POOL_STATS_INTERVAL: must be a duration of 0 or more")
+	}
+
+	for _, d := range []struct {
+		key    string
+		target *time.Duration
+		value  time.Duration
+	}{
+		{"DB_MAX_CONN_LIFETIME", &cfg.DBMaxConnLifetime, defaults.MaxConnLifetime},
+		{"DB_MAX_CONN_IDLE_TIME", &cfg.DBMaxConnIdleTime, defaults.MaxConnIdleTime},
+		{"DB_HEALTH_CHECK_PERIOD", &cfg.DBHealthCheckPeriod, defaults.HealthCheckPeriod},
+		{"DB_MAX_CONN_LIFETIME_JITTER", &cfg.DBMaxConnLifetimeJitter, defaults.MaxConnLifetimeJitter},
+		{"DB_RETRY_BACKOFF", &cfg.DBRetryBackoff, repository.DefaultRetryPolicy.Backoff},
+	} {
+		if *d.target, err = parsePositiveDuration(getEnv(d.key, d.value.String())); err != nil {
+			return fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+	}
+	return nil
+}
+
+// DBPoolConfig returns the database pool settings derived from cfg
+func (cfg *Config) DBPoolConfig() db.PoolConfig {
+	return db.PoolConfig{
+		MaxConns:              int32(cfg.DBMaxConns),
+		MinConns:              int32(cfg.DBMinConns),
+		MaxConnLifetime:       cfg.DBMaxConnLifetime,
+		MaxConnIdleTime:       cfg.DBMaxConnIdleTime,
+		HealthCheckPeriod:     cfg.DBHealthCheckPeriod,
+		MaxConnLifetimeJitter: cfg.DBMaxConnLifetimeJitter,
+		StatementCacheSize:    cfg.DBStatementCacheSize,
+		SlowQueryThreshold:    cfg.DBSlowQueryThreshold,
+		Tracing:               cfg.TracingEnable,
+	}
+}
+
+// TracingConfig returns the tracing settings derived from cfg
+func (cfg *Config) TracingConfig() tracing.Config {
+	return tracing.Config{Enabled: cfg.TracingEnable}
+}
+
+// DBRetryPolicy returns how the repository retries writes after transient
+// database errors
+func (cfg *Config) DBRetryPolicy() repository.RetryPolicy {
+	policy := repository.DefaultRetryPolicy
+	policy.Attempts = cfg.DBRetryAttempts
+	policy.Backoff = cfg.DBRetryBackoff
+	return policy
+}
+
+// ParseOriginPatterns parses a comma-separated list of allowed origins. Each
+// entry is "*", a host such as "example.com:8080", a host with a "*."
+// wildcard prefix such as "*.example.com", or an http(s) origin URL such as
+// "https://*.example.com". Blank entries are skipped.
+func ParseOriginPatterns(raw string) ([]string, error) {
+	var patterns []string
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if err := validateOriginPattern(entry); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, entry)
+	}
+	return patterns, nil
+}
+
+// ParseFrontendURL checks an http(s) URL with a host, dropping any trailing
+// slash so paths can be appended; it also checks PUBLIC_URL
+func ParseFrontendURL(raw string) (string, error) {
+	raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q must be an http or https URL", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("%q must not have a query or fragment", raw)
+	}
+	return raw, nil
+}
+
+// validateOriginPattern checks a single origin pattern
+func validateOriginPattern(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+
+	host := pattern
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return fmt.Errorf("origin %q must use http or https", pattern)
+		}
+		host = rest
+	}
+
+	// Only a leading "*." wildcard is allowed
+	name := strings.TrimPrefix(host, "*.")
+	if strings.Contains(name, "*") {
+		return fmt.Errorf("origin %q may only use a leading \"*.\" wildcard", pattern)
+	}
+
+	u, err := url.Parse("http://" + name)
+	if err != nil || u.Host != name || u.Hostname() == "" {
+		return fmt.Errorf("origin %q is not a valid host", pattern)
+	}
+	return nil
+}
+
+// ReloadEnv re-reads the .env file, overriding variables already set, so
+// settings that can change at runtime pick up edits to it
+func ReloadEnv() error {
+	return godotenv.Overload()
+}
+
+// parseInt parses an integer that must be at least least
+func parseInt(value string, least int) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < least {
+		return 0, fmt.Errorf("must be at least %d", least)
+	}
+	return n, nil
+}
+
+// splitList splits a comma-separated list, dropping blank entries
+func splitList(raw string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parsePositiveDuration parses a duration that must be greater than zero
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// hostname returns the machine hostname, which stays stable across restarts
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"websocket-demo/migrations"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrationLockID is the advisory lock key held while migrating, so servers
+// started together apply each migration only once
+const migrationLockID int64 = 0x63686174786d6967 // "chatxmig"
+
+// Markers splitting a migration file into its up and down sections; the
+// files use goose's annotations so they can still be applied by hand
+const (
+	migrationUpMarker   = "-- +goose Up"
+	migrationDownMarker = "-- +goose Down"
+)
+
+// Migration is one numbered SQL file
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+}
+
+// LoadMigrations reads the NNNNN_name.sql files in fsys, sorted by version
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	seen := make(map[int64]string)
+	for _, file := range files {
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(path.Base(file), ".sql"), "_")
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: file name must be NNNNN_name.sql", file)
+		}
+		if other, dup := seen[version]; dup {
+			return nil, fmt.Errorf("migration %s: version %d already used by %s", file, version, other)
+		}
+		seen[version] = file
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		up, err := parseMigrationUp(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", file, err)
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, Up: up})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// parseMigrationUp returns the SQL between the up marker and the down marker
+// or the end of the file
+func parseMigrationUp(sql string) (string, error) {
+	_, up, ok := strings.Cut(sql, migrationUpMarker)
+	if !ok {
+		return "", fmt.Errorf("missing %q marker", migrationUpMarker)
+	}
+	up, _, _ = strings.Cut(up, migrationDownMarker)
+	up = strings.TrimSpace(up)
+	if up == "" {
+		return "", fmt.Errorf("empty up section")
+	}
+	return up, nil
+}
+
+// Migrate applies the embedded migrations that aren't yet recorded in
+// schema_migrations and returns how many it applied
+func Migrate(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	all, err := LoadMigrations(migrations.FS)
+	if err != nil {
+		return 0, err
+	}
+	return applyMigrations(ctx, pool, all)
+}
+
+// applyMigrations runs each pending migration in its own transaction while
+// holding the migration advisory lock on a single connection
+func applyMigrations(ctx context.Context, pool *pgxpool.Pool, migrations []Migration) (int, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return 0, fmt.Errorf("failed to take migration lock: %w", err)
+	}
+	defer func() {
+		// Unlock even when ctx is done; the lock is otherwise held until the
+		// pooled connection closes
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("Failed to release migration lock: %v", err)
+		}
+	}()
+
+	if _, err := conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`); err != nil {
+		return 0, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn.Conn(), migrations)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+			if _, err := tx.Exec(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+			return err
+		}); err != nil {
+			return count, fmt.Errorf("migration %05d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %05d_%s", m.Version, m.Name)
+		count++
+	}
+	return count, nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations. A
+// database previously migrated with the goose CLI has its goose_db_version
+// history copied over first so those migrations aren't run again.
+func appliedMigrations(ctx context.Context, conn *pgx.Conn, migrations []Migration) (map[int64]bool, error) {
+	applied, err := queryVersions(ctx, conn, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	if len(applied) > 0 {
+		return applied, nil
+	}
+
+	var hasGoose bool
+	if err := conn.QueryRow(ctx, "SELECT to_regclass('goose_db_version') IS NOT NULL").Scan(&hasGoose); err != nil {
+		return nil, fmt.Errorf("failed to check for goose_db_version: %w", err)
+	}
+	if !hasGoose {
+		return applied, nil
+	}
+
+	// goose appends a row per up or down, so the latest row for a version says
+	// whether it's applied
+	goose, err := queryVersions(ctx, conn, `SELECT version_id FROM (
+    SELECT DISTINCT ON (version_id) version_id, is_applied
+    FROM goose_db_version
+    ORDER BY version_id, id DESC
+) latest WHERE is_applied AND version_id > 0`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read goose_db_version: %w", err)
+	}
+	for _, m := range migrations {
+		if !goose[m.Version] {
+			continue
+		}
+		if _, err := conn.Exec(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+			return nil, fmt.Errorf("failed to record goose migration %d: %w", m.Version, err)
+		}
+		applied[m.Version] = true
+	}
+	if len(applied) > 0 {
+		log.Printf("Adopted %d migrations applied by goose", len(applied))
+	}
+	return applied, nil
+}
+
+// queryVersions collects the single BIGINT column returned by sql
+func queryVersions(ctx context.Context, conn *pgx.Conn, sql string) (map[int64]bool, error) {
+	rows, err := conn.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return nil, err
+	}
+
+	set := make(map[int64]bool, len(versions))
+	for _, v := range versions {
+		set[v] = true
+	}
+	return set, nil
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"websocket-demo/migrations"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrationsEmbedded(t *testing.T) {
+	all, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+	require.NotEmpty(t, all)
+
+	for i, m := range all {
+		assert.Equal(t, int64(i+1), m.Version, "migrations should be numbered without gaps")
+		assert.NotEmpty(t, m.Name)
+		assert.NotContains(t, m.Up, migrationDownMarker)
+	}
+	assert.Equal(t, "init_schema", all[0].Name)
+	assert.Contains(t, all[0].Up, "CREATE TABLE IF NOT EXISTS users")
+}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"00002_second.sql": {Data: []byte("-- +goose Up\nSELECT 2;\n-- +goose Down\nSELECT -2;\n")},
+		"00001_first.sql":  {Data: []byte("-- comment\n-- +goose Up\nSELECT 1;\n")},
+		"README.md":        {Data: []byte("not a migration")},
+	}
+
+	all, err := LoadMigrations(fsys)
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{
+		{Version: 1, Name: "first", Up: "SELECT 1;"},
+		{Version: 2, Name: "second", Up: "SELECT 2;"},
+	}, all)
+}
+
+func TestLoadMigrationsInvalid(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"bad name":       {"first.sql": {Data: []byte("-- +goose Up\nSELECT 1;")}},
+		"zero version":   {"00000_zero.sql": {Data: []byte("-- +goose Up\nSELECT 1;")}},
+		"duplicate":      {"00001_a.sql": {Data: []byte("-- +goose Up\nSELECT 1;")}, "001_b.sql": {Data: []byte("-- +goose Up\nSELECT 1;")}},
+		"missing marker": {"00001_a.sql": {Data: []byte("SELECT 1;")}},
+		"empty up":       {"00001_a.sql": {Data: []byte("-- +goose Up\n-- +goose Down\nSELECT 1;")}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadMigrations(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+// newMigrationPool connects to TEST_DATABASE_URL with search_path set to a
+// fresh schema, skipping when it is unset, so each test migrates from empty
+func newMigrationPool(tb testing.TB) *pgxpool.Pool {
+	tb.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	admin, err := pgxpool.New(ctx, url)
+	require.NoError(tb, err)
+	tb.Cleanup(admin.Close)
+
+	schema := "migrate_" + uuid.New().String()[:8]
+	_, err = admin.Exec(ctx, fmt.Sprintf("CREATE SCHEMA %s", schema))
+	require.NoError(tb, err)
+	tb.Cleanup(func() {
+		admin.Exec(ctx, fmt.Sprintf("DROP SCHEMA %s CASCADE", schema))
+	})
+
+	cfg, err := pgxpool.ParseConfig(url)
+	require.NoError(tb, err)
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema + ",public"
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	require.NoError(tb, err)
+	tb.Cleanup(pool.Close)
+	return pool
+}
+
+func TestMigrate(t *testing.T) {
+	pool := newMigrationPool(t)
+	ctx := context.Background()
+
+	all, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+
+	applied, err := Migrate(ctx, pool)
+	require.NoError(t, err)
+	assert.Equal(t, len(all), applied)
+
+	// Running again is a no-op
+	applied, err = Migrate(ctx, pool)
+	require.NoError(t, err)
+	assert.Zero(t, applied)
+
+	var count int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT count(*) FROM schema_migrations").Scan(&count))
+	assert.Equal(t, len(all), count)
+	_, err = pool.Exec(ctx, "SELECT id, message_id FROM message_outbox LIMIT 1")
+	assert.NoError(t, err, "the latest migration should have been applied")
+}
+
+func TestMigrateConcurrent(t *testing.T) {
+	pool := newMigrationPool(t)
+	ctx := context.Background()
+
+	all, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+
+	// Servers starting together each run Migrate; the advisory lock makes
+	// exactly one of them apply the migrations
+	var wg sync.WaitGroup
+	results := make([]int, 3)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = Migrate(ctx, pool)
+		}(i)
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range results {
+		require.NoError(t, errs[i])
+		total += results[i]
+	}
+	assert.Equal(t, len(all), total)
+}
+
+func TestMigrateAdoptsGoose(t *testing.T) {
+	pool := newMigrationPool(t)
+	ctx := context.Background()
+
+	all, err := LoadMigrations(migrations.FS)
+	require.NoError(t, err)
+
+	// A database migrated with the goose CLI up to version 2, with version 3
+	// applied and rolled back again
+	_, err = applyMigrations(ctx, pool, all[:2])
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, `DELETE FROM schema_migrations;
+CREATE TABLE goose_db_version (
+    id SERIAL PRIMARY KEY,
+    version_id BIGINT NOT NULL,
+    is_applied BOOLEAN NOT NULL
+);
+INSERT INTO goose_db_version (version_id, is_applied) VALUES (0, true), (1, true), (2, true), (3, true), (3, false)`)
+	require.NoError(t, err)
+
+	applied, err := Migrate(ctx, pool)
+	require.NoError(t, err)
+	assert.Equal(t, len(all)-2, applied)
+}
@@ -8,35 +8,111 @@ import (
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type EmailVerificationToken struct {
+	Token     string             `json:"token"`
+	UserID    pgtype.UUID        `json:"user_id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+type FlaggedMessage struct {
+	ID           pgtype.UUID        `json:"id"`
+	RoomID       pgtype.UUID        `json:"room_id"`
+	UserID       pgtype.UUID        `json:"user_id"`
+	Username     string             `json:"username"`
+	Content      string             `json:"content"`
+	MatchedWords []string           `json:"matched_words"`
+	FlaggedAt    pgtype.Timestamptz `json:"flagged_at"`
+}
+
 type Message struct {
+	ID              pgtype.UUID        `json:"id"`
+	RoomID          pgtype.UUID        `json:"room_id"`
+	UserID          pgtype.UUID        `json:"user_id"`
+	Content         string             `json:"content"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	ParentMessageID pgtype.UUID        `json:"parent_message_id"`
+}
+
+type MessageOutbox struct {
+	ID            int64              `json:"id"`
+	MessageID     pgtype.UUID        `json:"message_id"`
+	Subject       string             `json:"subject"`
+	Payload       []byte             `json:"payload"`
+	Attempts      int32              `json:"attempts"`
+	LastError     pgtype.Text        `json:"last_error"`
+	CreatedAt     pgtype.Timestamptz `json:"created_at"`
+	NextAttemptAt pgtype.Timestamptz `json:"next_attempt_at"`
+	SentAt        pgtype.Timestamptz `json:"sent_at"`
+}
+
+type PinnedMessage struct {
+	RoomID    pgtype.UUID        `json:"room_id"`
+	MessageID pgtype.UUID        `json:"message_id"`
+	PinnedBy  pgtype.UUID        `json:"pinned_by"`
+	PinnedAt  pgtype.Timestamptz `json:"pinned_at"`
+}
+
+type Poll struct {
 	ID        pgtype.UUID        `json:"id"`
 	RoomID    pgtype.UUID        `json:"room_id"`
-	UserID    pgtype.UUID        `json:"user_id"`
-	Content   string             `json:"content"`
+	CreatorID pgtype.UUID        `json:"creator_id"`
+	Question  string             `json:"question"`
+	Options   []string           `json:"options"`
+	EndsAt    pgtype.Timestamptz `json:"ends_at"`
 	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	Closed    bool               `json:"closed"`
+}
+
+type PollVote struct {
+	PollID      pgtype.UUID        `json:"poll_id"`
+	UserID      pgtype.UUID        `json:"user_id"`
+	OptionIndex int32              `json:"option_index"`
+	VotedAt     pgtype.Timestamptz `json:"voted_at"`
 }
 
 type Room struct {
-	ID           pgtype.UUID        `json:"id"`
-	Name         string             `json:"name"`
-	Private      pgtype.Bool        `json:"private"`
-	PasswordHash pgtype.Text        `json:"password_hash"`
-	CreatorID    pgtype.UUID        `json:"creator_id"`
-	CreatedAt    pgtype.Timestamptz `json:"created_at"`
+	ID                pgtype.UUID        `json:"id"`
+	Name              string             `json:"name"`
+	Private           pgtype.Bool        `json:"private"`
+	PasswordHash      pgtype.Text        `json:"password_hash"`
+	CreatorID         pgtype.UUID        `json:"creator_id"`
+	CreatedAt         pgtype.Timestamptz `json:"created_at"`
+	SuppressJoinLeave bool               `json:"suppress_join_leave"`
+	RetentionDays     pgtype.Int4        `json:"retention_days"`
+	DeletedAt         pgtype.Timestamptz `json:"deleted_at"`
+	MaxClients        pgtype.Int4        `json:"max_clients"`
+}
+
+type RoomInvite struct {
+	ID        pgtype.UUID        `json:"id"`
+	RoomID    pgtype.UUID        `json:"room_id"`
+	InviterID pgtype.UUID        `json:"inviter_id"`
+	InviteeID pgtype.UUID        `json:"invitee_id"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
 }
 
 type RoomMember struct {
-	RoomID   pgtype.UUID        `json:"room_id"`
-	UserID   pgtype.UUID        `json:"user_id"`
-	JoinedAt pgtype.Timestamptz `json:"joined_at"`
+	RoomID     pgtype.UUID        `json:"room_id"`
+	UserID     pgtype.UUID        `json:"user_id"`
+	JoinedAt   pgtype.Timestamptz `json:"joined_at"`
+	LastReadAt pgtype.Timestamptz `json:"last_read_at"`
+	Role       string             `json:"role"`
+}
+
+type StatsSample struct {
+	ServerID        string             `json:"server_id"`
+	SampledAt       pgtype.Timestamptz `json:"sampled_at"`
+	PeakConnections int32              `json:"peak_connections"`
 }
 
 type User struct {
-	ID           pgtype.UUID        `json:"id"`
-	Username     string             `json:"username"`
-	Email        string             `json:"email"`
-	PasswordHash string             `json:"password_hash"`
-	CreatedAt    pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
-	LastLogin    pgtype.Timestamptz `json:"last_login"`
+	ID               pgtype.UUID        `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	PasswordHash     string             `json:"password_hash"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+	LastLogin        pgtype.Timestamptz `json:"last_login"`
+	UsernameSkeleton string             `json:"username_skeleton"`
+	EmailVerified    bool               `json:"email_verified"`
 }
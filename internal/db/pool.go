@@ -4,63 +4,78 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 	"strconv"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-// getEnvInt reads an environment variable as an integer with a default value
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil && intValue > 0 {
-			return intValue
-		}
-		log.Printf("Invalid value for %s, using default: %d", key, defaultValue)
-	}
-	return defaultValue
+// PoolConfig holds the connection pool settings; see config.Config for the
+// environment variables they come from
+type PoolConfig struct {
+	MaxConns              int32
+	MinConns              int32
+	MaxConnLifetime       time.Duration
+	MaxConnIdleTime       time.Duration
+	HealthCheckPeriod     time.Duration
+	MaxConnLifetimeJitter time.Duration
+	StatementCacheSize    int
+	SlowQueryThreshold    time.Duration // Queries at least this slow are logged and counted; 0 turns it off
+	Tracing               bool          // Record queries made for a traced request as spans
 }
 
-// getEnvDuration reads an environment variable as a duration with a default value
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
-			return duration
-		}
-		log.Printf("Invalid value for %s, using default: %v", key, defaultValue)
+// DefaultPoolConfig returns the pool settings used when none are configured
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxConns:              25,
+		MinConns:              5,
+		MaxConnLifetime:       1 * time.Hour,
+		MaxConnIdleTime:       30 * time.Minute,
+		HealthCheckPeriod:     1 * time.Minute,
+		MaxConnLifetimeJitter: 5 * time.Minute,
+		StatementCacheSize:    100,
+		SlowQueryThreshold:    500 * time.Millisecond,
 	}
-	return defaultValue
 }
 
 // NewPool creates a new database connection pool with best practices
-func NewPool(ctx context.Context, connString string) (*pgxpool.Pool, error) {
+func NewPool(ctx context.Context, connString string, poolCfg PoolConfig) (*pgxpool.Pool, error) {
 	config, err := pgxpool.ParseConfig(connString)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse database config: %w", err)
 	}
 
-	// Configure connection pool with environment variables or defaults
-	config.MaxConns = int32(getEnvInt("DB_MAX_CONNECTIONS", 25))
-	config.MinConns = int32(getEnvInt("DB_MIN_CONNECTIONS", 5))
-	config.MaxConnLifetime = getEnvDuration("DB_MAX_CONN_LIFETIME", 1*time.Hour)
-	config.MaxConnIdleTime = getEnvDuration("DB_MAX_CONN_IDLE_TIME", 30*time.Minute)
-	config.HealthCheckPeriod = getEnvDuration("DB_HEALTH_CHECK_PERIOD", 1*time.Minute)
-	config.MaxConnLifetimeJitter = getEnvDuration("DB_MAX_CONN_LIFETIME_JITTER", 5*time.Minute)
-
-	// Ensure min connections don't exceed max connections
-	if config.MinConns > config.MaxConns {
-		log.Printf("DB_MIN_CONNECTIONS (%d) cannot be greater than DB_MAX_CONNECTIONS (%d), setting min to max", config.MinConns, config.MaxConns)
-		config.MinConns = config.MaxConns
-	}
+	config.MaxConns = poolCfg.MaxConns
+	config.MinConns = poolCfg.MinConns
+	config.MaxConnLifetime = poolCfg.MaxConnLifetime
+	config.MaxConnIdleTime = poolCfg.MaxConnIdleTime
+	config.HealthCheckPeriod = poolCfg.HealthCheckPeriod
+	config.MaxConnLifetimeJitter = poolCfg.MaxConnLifetimeJitter
 
 	log.Printf("Database pool configured - Min: %d, Max: %d, Max Lifetime: %v, Max Idle: %v",
 		config.MinConns, config.MaxConns, config.MaxConnLifetime, config.MaxConnIdleTime)
 
-	// Enable prepared statement cache for better performance (configurable)
-	statementCacheSize := getEnvInt("DB_STATEMENT_CACHE_SIZE", 100)
+	// Enable prepared statement cache for better performance
 	config.ConnConfig.RuntimeParams["statement_cache_mode"] = "prepare"
-	config.ConnConfig.RuntimeParams["statement_cache_size"] = strconv.Itoa(statementCacheSize)
+	config.ConnConfig.RuntimeParams["statement_cache_size"] = strconv.Itoa(poolCfg.StatementCacheSize)
+
+	var tracers []pgx.QueryTracer
+	if poolCfg.SlowQueryThreshold > 0 {
+		tracers = append(tracers, NewSlowQueryTracer(poolCfg.SlowQueryThreshold))
+		log.Printf("Logging database queries slower than %v", poolCfg.SlowQueryThreshold)
+	}
+	if poolCfg.Tracing {
+		tracers = append(tracers, NewSpanQueryTracer())
+	}
+	switch len(tracers) {
+	case 0:
+	case 1:
+		config.ConnConfig.Tracer = tracers[0]
+	default:
+		config.ConnConfig.Tracer = multitracer.New(tracers...)
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"websocket-demo/internal/metrics"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultPoolStatsInterval is how often pool statistics are sampled when
+// DB_POOL_STATS_INTERVAL is unset
+const DefaultPoolStatsInterval = 15 * time.Second
+
+// PoolStats is a snapshot of pgxpool.Stat
+type PoolStats struct {
+	TotalConns        int32         `json:"total_conns"`
+	IdleConns         int32         `json:"idle_conns"`
+	AcquiredConns     int32         `json:"acquired_conns"`
+	MaxConns          int32         `json:"max_conns"`
+	AcquireCount      int64         `json:"acquire_count"`
+	AcquireDuration   time.Duration `json:"acquire_duration_ns"` // Total time spent acquiring connections
+	EmptyAcquireCount int64         `json:"empty_acquire_count"` // Acquires that had to wait for a connection
+}
+
+// GetPoolStats returns pool's current statistics
+func GetPoolStats(pool *pgxpool.Pool) PoolStats {
+	stat := pool.Stat()
+	return PoolStats{
+		TotalConns:        stat.TotalConns(),
+		IdleConns:         stat.IdleConns(),
+		AcquiredConns:     stat.AcquiredConns(),
+		MaxConns:          stat.MaxConns(),
+		AcquireCount:      stat.AcquireCount(),
+		AcquireDuration:   stat.AcquireDuration(),
+		EmptyAcquireCount: stat.EmptyAcquireCount(),
+	}
+}
+
+// RecordPoolStats copies pool's current statistics, and its slow query count
+// if it has a SlowQueryTracer, into m
+func RecordPoolStats(pool *pgxpool.Pool, m *metrics.Metrics) {
+	stats := GetPoolStats(pool)
+	m.SetDBPoolStats(metrics.DBPoolStats{
+		AcquiredConns:   stats.AcquiredConns,
+		IdleConns:       stats.IdleConns,
+		TotalConns:      stats.TotalConns,
+		MaxConns:        stats.MaxConns,
+		EmptyAcquires:   stats.EmptyAcquireCount,
+		AcquireDuration: stats.AcquireDuration,
+	})
+	if tracer := findSlowQueryTracer(pool.Config().ConnConfig.Tracer); tracer != nil {
+		m.SetDBSlowQueries(tracer.SlowQueries())
+	}
+}
+
+// findSlowQueryTracer returns tracer, or the one among a multitracer's, if it
+// is a SlowQueryTracer
+func findSlowQueryTracer(tracer pgx.QueryTracer) *SlowQueryTracer {
+	switch t := tracer.(type) {
+	case *SlowQueryTracer:
+		return t
+	case *multitracer.Tracer:
+		for _, inner := range t.QueryTracers {
+			if slow, ok := inner.(*SlowQueryTracer); ok {
+				return slow
+			}
+		}
+	}
+	return nil
+}
+
+// WatchPoolStats records pool's statistics into m every interval until ctx
+// is done
+func WatchPoolStats(ctx context.Context, pool *pgxpool.Pool, interval time.Duration, m *metrics.Metrics) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	RecordPoolStats(pool, m)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			RecordPoolStats(pool, m)
+		}
+	}
+}
@@ -11,28 +11,124 @@ import (
 )
 
 type Querier interface {
+	// Existing members keep their role; SetRoomMemberRole changes it
 	AddRoomMember(ctx context.Context, arg AddRoomMemberParams) (RoomMember, error)
+	BulkCreateMessages(ctx context.Context, arg []BulkCreateMessagesParams) (int64, error)
+	ClaimOutboxEntries(ctx context.Context, arg ClaimOutboxEntriesParams) ([]MessageOutbox, error)
+	CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error
+	CreateFlaggedMessage(ctx context.Context, arg CreateFlaggedMessageParams) error
 	CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error)
+	CreateOutboxEntry(ctx context.Context, arg CreateOutboxEntryParams) (MessageOutbox, error)
+	ClosePoll(ctx context.Context, id pgtype.UUID) (int64, error)
+	CountMessagesByRoom(ctx context.Context, roomID pgtype.UUID) (int64, error)
+	CountMessagesPerDay(ctx context.Context, arg CountMessagesPerDayParams) ([]CountMessagesPerDayRow, error)
+	CountNewUsersPerDay(ctx context.Context, arg CountNewUsersPerDayParams) ([]CountNewUsersPerDayRow, error)
+	// Rooms not deleted, leaving out the named room such as the default room
+	CountRooms(ctx context.Context, excludedName string) (int64, error)
+	CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error)
+	CreatePoll(ctx context.Context, arg CreatePollParams) (Poll, error)
 	CreateRoom(ctx context.Context, arg CreateRoomParams) (Room, error)
+	// Inviting a user to a room again replaces their invite
+	CreateRoomInvite(ctx context.Context, arg CreateRoomInviteParams) (RoomInvite, error)
+	CreateStatsSample(ctx context.Context, arg CreateStatsSampleParams) error
 	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	// Deleting the token as it is read means a link can only be used once
+	DeleteEmailVerificationToken(ctx context.Context, token string) (EmailVerificationToken, error)
+	// Deletes up to batch_size messages older than cutoff, oldest first, from
+	// rooms without their own retention_days
+	DeleteExpiredMessages(ctx context.Context, arg DeleteExpiredMessagesParams) (int64, error)
+	// Deleting a message unpins it and detaches its replies
+	DeleteMessage(ctx context.Context, id pgtype.UUID) (int64, error)
 	DeleteMessagesByRoom(ctx context.Context, roomID pgtype.UUID) error
+	// Permanently deletes a room with its messages, members, pins and polls
 	DeleteRoom(ctx context.Context, id pgtype.UUID) error
+	// Removes entries published before cutoff
+	DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff pgtype.Timestamptz) (int64, error)
+	DeleteStatsSamplesBefore(ctx context.Context, sampledAt pgtype.Timestamptz) (int64, error)
+	// Deleting a user cascades to their messages, room memberships and poll
+	// votes; rooms, pins and polls they created are kept with no owner
+	DeleteUser(ctx context.Context, id pgtype.UUID) (int64, error)
+	// The most recently deleted room with the name, as several may share it
+	GetDeletedRoomByName(ctx context.Context, name string) (Room, error)
 	GetMessageByID(ctx context.Context, id pgtype.UUID) (Message, error)
+	GetPollByID(ctx context.Context, id pgtype.UUID) (Poll, error)
+	GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]GetPollVoteCountsRow, error)
 	GetRoomByID(ctx context.Context, id pgtype.UUID) (Room, error)
 	GetRoomByName(ctx context.Context, name string) (Room, error)
 	GetRoomMemberCount(ctx context.Context, roomID pgtype.UUID) (int64, error)
+	// Member counts of the given rooms; rooms without members are left out
+	GetRoomMemberCounts(ctx context.Context, roomIds []pgtype.UUID) ([]GetRoomMemberCountsRow, error)
+	GetRoomMemberRole(ctx context.Context, arg GetRoomMemberRoleParams) (string, error)
 	GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]GetRoomMembersRow, error)
+	GetRoomMembersWithRoles(ctx context.Context, roomID pgtype.UUID) ([]GetRoomMembersWithRolesRow, error)
+	// The rooms with any of the given names that aren't deleted
+	GetRoomsByNames(ctx context.Context, names []string) ([]Room, error)
 	GetUserByEmail(ctx context.Context, email string) (User, error)
 	GetUserByID(ctx context.Context, id pgtype.UUID) (User, error)
+	// Usernames are matched regardless of case.
 	GetUserByUsername(ctx context.Context, username string) (User, error)
+	// Inserts a small batch of messages in one statement; larger batches use
+	// BulkCreateMessages
+	InsertMessages(ctx context.Context, arg InsertMessagesParams) error
+	IsMessagePinned(ctx context.Context, arg IsMessagePinnedParams) (bool, error)
 	IsRoomMember(ctx context.Context, arg IsRoomMemberParams) (bool, error)
+	ListDeletedRooms(ctx context.Context) ([]Room, error)
+	ListEndedPolls(ctx context.Context) ([]Poll, error)
+	// The newest flagged messages first; room_name is empty for messages sent
+	// outside a room
+	ListFlaggedMessages(ctx context.Context, limit int32) ([]ListFlaggedMessagesRow, error)
+	// The newest message of each of the given rooms, for room list previews.
+	// Rooms without messages, and private rooms the viewer isn't a member of, are
+	// left out.
+	ListLatestMessagesByRooms(ctx context.Context, arg ListLatestMessagesByRoomsParams) ([]ListLatestMessagesByRoomsRow, error)
 	ListMessagesByRoom(ctx context.Context, arg ListMessagesByRoomParams) ([]ListMessagesByRoomRow, error)
+	ListMostActiveRooms(ctx context.Context, arg ListMostActiveRoomsParams) ([]ListMostActiveRoomsRow, error)
+	ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]ListPinnedMessagesRow, error)
 	ListRecentMessagesByRoom(ctx context.Context, arg ListRecentMessagesByRoomParams) ([]ListRecentMessagesByRoomRow, error)
+	// A page of a room's messages newest first, before the message at
+	// (before_created_at, before_id); 'infinity' starts from the newest one.
+	ListRoomExportPage(ctx context.Context, arg ListRoomExportPageParams) ([]ListRoomExportPageRow, error)
+	// A page of a room's messages oldest first, after the message at
+	// (after_created_at, after_id); '-infinity' starts from the first one.
+	ListRoomTranscript(ctx context.Context, arg ListRoomTranscriptParams) ([]ListRoomTranscriptRow, error)
 	ListRooms(ctx context.Context, arg ListRoomsParams) ([]Room, error)
 	ListRoomsByCreator(ctx context.Context, arg ListRoomsByCreatorParams) ([]Room, error)
+	// Replies to a message, oldest first.
+	ListThreadMessages(ctx context.Context, arg ListThreadMessagesParams) ([]ListThreadMessagesRow, error)
+	// The rooms a user is a member of with their member count, messages from
+	// others since the user last read the room and latest message, most recently
+	// active first
+	ListUserRoomSummaries(ctx context.Context, userID pgtype.UUID) ([]ListUserRoomSummariesRow, error)
 	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	MarkOutboxFailed(ctx context.Context, arg MarkOutboxFailedParams) error
+	MarkOutboxSent(ctx context.Context, id int64) error
+	// Records that the user has caught up with the room's messages
+	MarkRoomRead(ctx context.Context, arg MarkRoomReadParams) error
+	// The highest cluster-wide connection count of each day, adding up the
+	// servers' samples taken in the same minute
+	PeakConnectionsPerDay(ctx context.Context, arg PeakConnectionsPerDayParams) ([]PeakConnectionsPerDayRow, error)
+	PinMessage(ctx context.Context, arg PinMessageParams) (PinnedMessage, error)
+	// Permanently deletes rooms soft-deleted before cutoff
+	PurgeDeletedRooms(ctx context.Context, cutoff pgtype.Timestamptz) (int64, error)
 	RemoveRoomMember(ctx context.Context, arg RemoveRoomMemberParams) error
+	RestoreRoom(ctx context.Context, id pgtype.UUID) (Room, error)
+	// Users whose name matches an ILIKE pattern, in name order
+	SearchUsers(ctx context.Context, arg SearchUsersParams) ([]User, error)
+	SetRoomMemberRole(ctx context.Context, arg SetRoomMemberRoleParams) (int64, error)
+	SetUserEmailVerified(ctx context.Context, id pgtype.UUID) (User, error)
+	// Hides a room from listings and joins while keeping its messages until
+	// PurgeDeletedRooms removes it
+	SoftDeleteRoom(ctx context.Context, id pgtype.UUID) (int64, error)
+	// Takes an advisory lock held until the current transaction ends, without
+	// waiting if another session holds it
+	TryAdvisoryXactLock(ctx context.Context, lockID int64) (bool, error)
+	UnpinMessage(ctx context.Context, arg UnpinMessageParams) (int64, error)
 	UpdateRoom(ctx context.Context, arg UpdateRoomParams) (Room, error)
+	UpdateMessageContent(ctx context.Context, arg UpdateMessageContentParams) (Message, error)
+	UpdateRoomPassword(ctx context.Context, arg UpdateRoomPasswordParams) error
+	UpdateRoomRetentionDays(ctx context.Context, arg UpdateRoomRetentionDaysParams) error
+	UpdateRoomSuppressJoinLeave(ctx context.Context, arg UpdateRoomSuppressJoinLeaveParams) error
+	UpsertPollVote(ctx context.Context, arg UpsertPollVoteParams) (int64, error)
 	UpdateUserLastLogin(ctx context.Context, arg UpdateUserLastLoginParams) (User, error)
 	UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) (User, error)
 	// User profile management queries
@@ -12,38 +12,277 @@ import (
 )
 
 const addRoomMember = `-- name: AddRoomMember :one
-INSERT INTO room_members (room_id, user_id)
-VALUES ($1, $2)
-ON CONFLICT (room_id, user_id) DO UPDATE SET joined_at = EXCLUDED.joined_at
-RETURNING room_id, user_id, joined_at
+INSERT INTO room_members (room_id, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (room_id, user_id) DO UPDATE SET joined_at = EXCLUDED.joined_at, last_read_at = EXCLUDED.last_read_at
+RETURNING room_id, user_id, joined_at, last_read_at, role
 `
 
 type AddRoomMemberParams struct {
 	RoomID pgtype.UUID `json:"room_id"`
 	UserID pgtype.UUID `json:"user_id"`
+	Role   string      `json:"role"`
 }
 
+// Existing members keep their role; SetRoomMemberRole changes it
 func (q *Queries) AddRoomMember(ctx context.Context, arg AddRoomMemberParams) (RoomMember, error) {
-	row := q.db.QueryRow(ctx, addRoomMember, arg.RoomID, arg.UserID)
+	row := q.db.QueryRow(ctx, addRoomMember, arg.RoomID, arg.UserID, arg.Role)
 	var i RoomMember
-	err := row.Scan(&i.RoomID, &i.UserID, &i.JoinedAt)
+	err := row.Scan(
+		&i.RoomID,
+		&i.UserID,
+		&i.JoinedAt,
+		&i.LastReadAt,
+		&i.Role,
+	)
 	return i, err
 }
 
-const createMessage = `-- name: CreateMessage :one
-INSERT INTO messages (room_id, user_id, content)
+type BulkCreateMessagesParams struct {
+	ID              pgtype.UUID        `json:"id"`
+	RoomID          pgtype.UUID        `json:"room_id"`
+	UserID          pgtype.UUID        `json:"user_id"`
+	Content         string             `json:"content"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	ParentMessageID pgtype.UUID        `json:"parent_message_id"`
+}
+
+const claimOutboxEntries = `-- name: ClaimOutboxEntries :many
+UPDATE message_outbox
+SET next_attempt_at = NOW() + make_interval(secs => $1::float8)
+WHERE id IN (
+    SELECT id FROM message_outbox
+    WHERE sent_at IS NULL AND next_attempt_at <= NOW()
+    ORDER BY id
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING id, message_id, subject, payload, attempts, last_error, created_at, next_attempt_at, sent_at
+`
+
+type ClaimOutboxEntriesParams struct {
+	LeaseSeconds float64 `json:"lease_seconds"`
+	BatchSize    int32   `json:"batch_size"`
+}
+
+func (q *Queries) ClaimOutboxEntries(ctx context.Context, arg ClaimOutboxEntriesParams) ([]MessageOutbox, error) {
+	rows, err := q.db.Query(ctx, claimOutboxEntries, arg.LeaseSeconds, arg.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MessageOutbox
+	for rows.Next() {
+		var i MessageOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.MessageID,
+			&i.Subject,
+			&i.Payload,
+			&i.Attempts,
+			&i.LastError,
+			&i.CreatedAt,
+			&i.NextAttemptAt,
+			&i.SentAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const closePoll = `-- name: ClosePoll :execrows
+UPDATE polls
+SET closed = TRUE
+WHERE id = $1 AND NOT closed
+`
+
+func (q *Queries) ClosePoll(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, closePoll, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const countMessagesByRoom = `-- name: CountMessagesByRoom :one
+SELECT COUNT(*) as count
+FROM messages
+WHERE room_id = $1
+`
+
+func (q *Queries) CountMessagesByRoom(ctx context.Context, roomID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countMessagesByRoom, roomID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countMessagesPerDay = `-- name: CountMessagesPerDay :many
+SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS messages
+FROM messages
+WHERE created_at >= $1 AND created_at < $2
+GROUP BY day
+ORDER BY day
+`
+
+type CountMessagesPerDayParams struct {
+	FromTime pgtype.Timestamptz `json:"from_time"`
+	ToTime   pgtype.Timestamptz `json:"to_time"`
+}
+
+type CountMessagesPerDayRow struct {
+	Day      pgtype.Date `json:"day"`
+	Messages int64       `json:"messages"`
+}
+
+func (q *Queries) CountMessagesPerDay(ctx context.Context, arg CountMessagesPerDayParams) ([]CountMessagesPerDayRow, error) {
+	rows, err := q.db.Query(ctx, countMessagesPerDay, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountMessagesPerDayRow
+	for rows.Next() {
+		var i CountMessagesPerDayRow
+		if err := rows.Scan(&i.Day, &i.Messages); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countNewUsersPerDay = `-- name: CountNewUsersPerDay :many
+SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS users
+FROM users
+WHERE created_at >= $1 AND created_at < $2
+GROUP BY day
+ORDER BY day
+`
+
+type CountNewUsersPerDayParams struct {
+	FromTime pgtype.Timestamptz `json:"from_time"`
+	ToTime   pgtype.Timestamptz `json:"to_time"`
+}
+
+type CountNewUsersPerDayRow struct {
+	Day   pgtype.Date `json:"day"`
+	Users int64       `json:"users"`
+}
+
+func (q *Queries) CountNewUsersPerDay(ctx context.Context, arg CountNewUsersPerDayParams) ([]CountNewUsersPerDayRow, error) {
+	rows, err := q.db.Query(ctx, countNewUsersPerDay, arg.FromTime, arg.ToTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountNewUsersPerDayRow
+	for rows.Next() {
+		var i CountNewUsersPerDayRow
+		if err := rows.Scan(&i.Day, &i.Users); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countRooms = `-- name: CountRooms :one
+SELECT COUNT(*) AS count FROM rooms
+WHERE deleted_at IS NULL AND name <> $1
+`
+
+// Rooms not deleted, leaving out the named room such as the default room
+func (q *Queries) CountRooms(ctx context.Context, excludedName string) (int64, error) {
+	row := q.db.QueryRow(ctx, countRooms, excludedName)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countRoomsByCreator = `-- name: CountRoomsByCreator :one
+SELECT COUNT(*) AS count FROM rooms
+WHERE creator_id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error) {
+	row := q.db.QueryRow(ctx, countRoomsByCreator, creatorID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createEmailVerificationToken = `-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token, user_id, expires_at)
 VALUES ($1, $2, $3)
-RETURNING id, room_id, user_id, content, created_at
+`
+
+type CreateEmailVerificationTokenParams struct {
+	Token     string             `json:"token"`
+	UserID    pgtype.UUID        `json:"user_id"`
+	ExpiresAt pgtype.Timestamptz `json:"expires_at"`
+}
+
+func (q *Queries) CreateEmailVerificationToken(ctx context.Context, arg CreateEmailVerificationTokenParams) error {
+	_, err := q.db.Exec(ctx, createEmailVerificationToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	return err
+}
+
+const createFlaggedMessage = `-- name: CreateFlaggedMessage :exec
+INSERT INTO flagged_messages (room_id, user_id, username, content, matched_words)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateFlaggedMessageParams struct {
+	RoomID       pgtype.UUID `json:"room_id"`
+	UserID       pgtype.UUID `json:"user_id"`
+	Username     string      `json:"username"`
+	Content      string      `json:"content"`
+	MatchedWords []string    `json:"matched_words"`
+}
+
+func (q *Queries) CreateFlaggedMessage(ctx context.Context, arg CreateFlaggedMessageParams) error {
+	_, err := q.db.Exec(ctx, createFlaggedMessage,
+		arg.RoomID,
+		arg.UserID,
+		arg.Username,
+		arg.Content,
+		arg.MatchedWords,
+	)
+	return err
+}
+
+const createMessage = `-- name: CreateMessage :one
+INSERT INTO messages (room_id, user_id, content, parent_message_id)
+VALUES ($1, $2, $3, $4)
+RETURNING id, room_id, user_id, content, created_at, parent_message_id
 `
 
 type CreateMessageParams struct {
-	RoomID  pgtype.UUID `json:"room_id"`
-	UserID  pgtype.UUID `json:"user_id"`
-	Content string      `json:"content"`
+	RoomID          pgtype.UUID `json:"room_id"`
+	UserID          pgtype.UUID `json:"user_id"`
+	Content         string      `json:"content"`
+	ParentMessageID pgtype.UUID `json:"parent_message_id"`
 }
 
 func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (Message, error) {
-	row := q.db.QueryRow(ctx, createMessage, arg.RoomID, arg.UserID, arg.Content)
+	row := q.db.QueryRow(ctx, createMessage,
+		arg.RoomID,
+		arg.UserID,
+		arg.Content,
+		arg.ParentMessageID,
+	)
 	var i Message
 	err := row.Scan(
 		&i.ID,
@@ -51,21 +290,89 @@ func (q *Queries) CreateMessage(ctx context.Context, arg CreateMessageParams) (M
 		&i.UserID,
 		&i.Content,
 		&i.CreatedAt,
+		&i.ParentMessageID,
+	)
+	return i, err
+}
+
+const createOutboxEntry = `-- name: CreateOutboxEntry :one
+INSERT INTO message_outbox (message_id, subject, payload)
+VALUES ($1, $2, $3)
+RETURNING id, message_id, subject, payload, attempts, last_error, created_at, next_attempt_at, sent_at
+`
+
+type CreateOutboxEntryParams struct {
+	MessageID pgtype.UUID `json:"message_id"`
+	Subject   string      `json:"subject"`
+	Payload   []byte      `json:"payload"`
+}
+
+func (q *Queries) CreateOutboxEntry(ctx context.Context, arg CreateOutboxEntryParams) (MessageOutbox, error) {
+	row := q.db.QueryRow(ctx, createOutboxEntry, arg.MessageID, arg.Subject, arg.Payload)
+	var i MessageOutbox
+	err := row.Scan(
+		&i.ID,
+		&i.MessageID,
+		&i.Subject,
+		&i.Payload,
+		&i.Attempts,
+		&i.LastError,
+		&i.CreatedAt,
+		&i.NextAttemptAt,
+		&i.SentAt,
+	)
+	return i, err
+}
+
+const createPoll = `-- name: CreatePoll :one
+INSERT INTO polls (room_id, creator_id, question, options, ends_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, room_id, creator_id, question, options, ends_at, created_at, closed
+`
+
+type CreatePollParams struct {
+	RoomID    pgtype.UUID        `json:"room_id"`
+	CreatorID pgtype.UUID        `json:"creator_id"`
+	Question  string             `json:"question"`
+	Options   []string           `json:"options"`
+	EndsAt    pgtype.Timestamptz `json:"ends_at"`
+}
+
+func (q *Queries) CreatePoll(ctx context.Context, arg CreatePollParams) (Poll, error) {
+	row := q.db.QueryRow(ctx, createPoll,
+		arg.RoomID,
+		arg.CreatorID,
+		arg.Question,
+		arg.Options,
+		arg.EndsAt,
+	)
+	var i Poll
+	err := row.Scan(
+		&i.ID,
+		&i.RoomID,
+		&i.CreatorID,
+		&i.Question,
+		&i.Options,
+		&i.EndsAt,
+		&i.CreatedAt,
+		&i.Closed,
 	)
 	return i, err
 }
 
 const createRoom = `-- name: CreateRoom :one
-INSERT INTO rooms (name, private, password_hash, creator_id)
-VALUES ($1, $2, $3, $4)
-RETURNING id, name, private, password_hash, creator_id, created_at
+INSERT INTO rooms (name, private, password_hash, creator_id, suppress_join_leave, max_clients)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients
 `
 
 type CreateRoomParams struct {
-	Name         string      `json:"name"`
-	Private      pgtype.Bool `json:"private"`
-	PasswordHash pgtype.Text `json:"password_hash"`
-	CreatorID    pgtype.UUID `json:"creator_id"`
+	Name              string      `json:"name"`
+	Private           pgtype.Bool `json:"private"`
+	PasswordHash      pgtype.Text `json:"password_hash"`
+	CreatorID         pgtype.UUID `json:"creator_id"`
+	SuppressJoinLeave bool        `json:"suppress_join_leave"`
+	MaxClients        pgtype.Int4 `json:"max_clients"`
 }
 
 func (q *Queries) CreateRoom(ctx context.Context, arg CreateRoomParams) (Room, error) {
@@ -74,6 +381,8 @@ func (q *Queries) CreateRoom(ctx context.Context, arg CreateRoomParams) (Room, e
 		arg.Private,
 		arg.PasswordHash,
 		arg.CreatorID,
+		arg.SuppressJoinLeave,
+		arg.MaxClients,
 	)
 	var i Room
 	err := row.Scan(
@@ -83,24 +392,78 @@ func (q *Queries) CreateRoom(ctx context.Context, arg CreateRoomParams) (Room, e
 		&i.PasswordHash,
 		&i.CreatorID,
 		&i.CreatedAt,
+		&i.SuppressJoinLeave,
+		&i.RetentionDays,
+		&i.DeletedAt,
+		&i.MaxClients,
 	)
 	return i, err
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (username, email, password_hash)
+const createRoomInvite = `-- name: CreateRoomInvite :one
+INSERT INTO room_invites (room_id, inviter_id, invitee_id)
 VALUES ($1, $2, $3)
-RETURNING id, username, email, password_hash, created_at, updated_at, last_login
+ON CONFLICT (room_id, invitee_id) DO UPDATE SET inviter_id = EXCLUDED.inviter_id, created_at = CURRENT_TIMESTAMP
+RETURNING id, room_id, inviter_id, invitee_id, created_at
+`
+
+type CreateRoomInviteParams struct {
+	RoomID    pgtype.UUID `json:"room_id"`
+	InviterID pgtype.UUID `json:"inviter_id"`
+	InviteeID pgtype.UUID `json:"invitee_id"`
+}
+
+// Inviting a user to a room again replaces their invite
+func (q *Queries) CreateRoomInvite(ctx context.Context, arg CreateRoomInviteParams) (RoomInvite, error) {
+	row := q.db.QueryRow(ctx, createRoomInvite, arg.RoomID, arg.InviterID, arg.InviteeID)
+	var i RoomInvite
+	err := row.Scan(
+		&i.ID,
+		&i.RoomID,
+		&i.InviterID,
+		&i.InviteeID,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createStatsSample = `-- name: CreateStatsSample :exec
+INSERT INTO stats_samples (server_id, peak_connections)
+VALUES ($1, $2)
+ON CONFLICT (server_id, sampled_at) DO UPDATE
+SET peak_connections = GREATEST(stats_samples.peak_connections, EXCLUDED.peak_connections)
+`
+
+type CreateStatsSampleParams struct {
+	ServerID        string `json:"server_id"`
+	PeakConnections int32  `json:"peak_connections"`
+}
+
+func (q *Queries) CreateStatsSample(ctx context.Context, arg CreateStatsSampleParams) error {
+	_, err := q.db.Exec(ctx, createStatsSample, arg.ServerID, arg.PeakConnections)
+	return err
+}
+
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (username, email, password_hash, username_skeleton)
+VALUES ($1, $2, $3, $4)
+RETURNING id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified
 `
 
 type CreateUserParams struct {
-	Username     string `json:"username"`
-	Email        string `json:"email"`
-	PasswordHash string `json:"password_hash"`
+	Username         string `json:"username"`
+	Email            string `json:"email"`
+	PasswordHash     string `json:"password_hash"`
+	UsernameSkeleton string `json:"username_skeleton"`
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRow(ctx, createUser, arg.Username, arg.Email, arg.PasswordHash)
+	row := q.db.QueryRow(ctx, createUser,
+		arg.Username,
+		arg.Email,
+		arg.PasswordHash,
+		arg.UsernameSkeleton,
+	)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -110,10 +473,71 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
+const deleteEmailVerificationToken = `-- name: DeleteEmailVerificationToken :one
+DELETE FROM email_verification_tokens
+WHERE token = $1
+RETURNING token, user_id, expires_at
+`
+
+// Deleting the token as it is read means a link can only be used once
+func (q *Queries) DeleteEmailVerificationToken(ctx context.Context, token string) (EmailVerificationToken, error) {
+	row := q.db.QueryRow(ctx, deleteEmailVerificationToken, token)
+	var i EmailVerificationToken
+	err := row.Scan(
+		&i.Token,
+		&i.UserID,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
+const deleteExpiredMessages = `-- name: DeleteExpiredMessages :execrows
+DELETE FROM messages
+WHERE id IN (
+    SELECT m.id
+    FROM messages m
+    JOIN rooms r ON m.room_id = r.id
+    WHERE m.created_at < $1 AND r.retention_days IS NULL
+    ORDER BY m.created_at
+    LIMIT $2
+)
+`
+
+type DeleteExpiredMessagesParams struct {
+	Cutoff    pgtype.Timestamptz `json:"cutoff"`
+	BatchSize int32              `json:"batch_size"`
+}
+
+// Deletes up to batch_size messages older than cutoff, oldest first, from
+// rooms without their own retention_days
+func (q *Queries) DeleteExpiredMessages(ctx context.Context, arg DeleteExpiredMessagesParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteExpiredMessages, arg.Cutoff, arg.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteMessage = `-- name: DeleteMessage :execrows
+DELETE FROM messages
+WHERE id = $1
+`
+
+// Deleting a message unpins it and detaches its replies
+func (q *Queries) DeleteMessage(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteMessage, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const deleteMessagesByRoom = `-- name: DeleteMessagesByRoom :exec
 DELETE FROM messages
 WHERE room_id = $1
@@ -129,13 +553,82 @@ DELETE FROM rooms
 WHERE id = $1
 `
 
+// Permanently deletes a room with its messages, members, pins and polls
 func (q *Queries) DeleteRoom(ctx context.Context, id pgtype.UUID) error {
 	_, err := q.db.Exec(ctx, deleteRoom, id)
 	return err
 }
 
+const deleteSentOutboxEntriesBefore = `-- name: DeleteSentOutboxEntriesBefore :execrows
+DELETE FROM message_outbox
+WHERE sent_at < $1
+`
+
+// Removes entries published before cutoff
+func (q *Queries) DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteSentOutboxEntriesBefore, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteStatsSamplesBefore = `-- name: DeleteStatsSamplesBefore :execrows
+DELETE FROM stats_samples
+WHERE sampled_at < $1
+`
+
+func (q *Queries) DeleteStatsSamplesBefore(ctx context.Context, sampledAt pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteStatsSamplesBefore, sampledAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1
+`
+
+// Deleting a user cascades to their messages, room memberships and poll
+// votes; rooms, pins and polls they created are kept with no owner
+func (q *Queries) DeleteUser(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const getDeletedRoomByName = `-- name: GetDeletedRoomByName :one
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE name = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT 1
+`
+
+// The most recently deleted room with the name, as several may share it
+func (q *Queries) GetDeletedRoomByName(ctx context.Context, name string) (Room, error) {
+	row := q.db.QueryRow(ctx, getDeletedRoomByName, name)
+	var i Room
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Private,
+		&i.PasswordHash,
+		&i.CreatorID,
+		&i.CreatedAt,
+		&i.SuppressJoinLeave,
+		&i.RetentionDays,
+		&i.DeletedAt,
+		&i.MaxClients,
+	)
+	return i, err
+}
+
 const getMessageByID = `-- name: GetMessageByID :one
-SELECT id, room_id, user_id, content, created_at FROM messages
+SELECT id, room_id, user_id, content, created_at, parent_message_id FROM messages
 WHERE id = $1
 `
 
@@ -148,44 +641,107 @@ func (q *Queries) GetMessageByID(ctx context.Context, id pgtype.UUID) (Message,
 		&i.UserID,
 		&i.Content,
 		&i.CreatedAt,
+		&i.ParentMessageID,
 	)
 	return i, err
 }
 
-const getRoomByID = `-- name: GetRoomByID :one
-SELECT id, name, private, password_hash, creator_id, created_at FROM rooms
+const getPollByID = `-- name: GetPollByID :one
+SELECT id, room_id, creator_id, question, options, ends_at, created_at, closed FROM polls
 WHERE id = $1
 `
 
-func (q *Queries) GetRoomByID(ctx context.Context, id pgtype.UUID) (Room, error) {
-	row := q.db.QueryRow(ctx, getRoomByID, id)
-	var i Room
+func (q *Queries) GetPollByID(ctx context.Context, id pgtype.UUID) (Poll, error) {
+	row := q.db.QueryRow(ctx, getPollByID, id)
+	var i Poll
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Private,
-		&i.PasswordHash,
+		&i.RoomID,
 		&i.CreatorID,
+		&i.Question,
+		&i.Options,
+		&i.EndsAt,
 		&i.CreatedAt,
+		&i.Closed,
 	)
 	return i, err
 }
 
-const getRoomByName = `-- name: GetRoomByName :one
-SELECT id, name, private, password_hash, creator_id, created_at FROM rooms
-WHERE name = $1
+const getPollVoteCounts = `-- name: GetPollVoteCounts :many
+SELECT option_index, COUNT(*) as votes
+FROM poll_votes
+WHERE poll_id = $1
+GROUP BY option_index
+ORDER BY option_index
 `
 
-func (q *Queries) GetRoomByName(ctx context.Context, name string) (Room, error) {
-	row := q.db.QueryRow(ctx, getRoomByName, name)
-	var i Room
-	err := row.Scan(
-		&i.ID,
-		&i.Name,
-		&i.Private,
+type GetPollVoteCountsRow struct {
+	OptionIndex int32 `json:"option_index"`
+	Votes       int64 `json:"votes"`
+}
+
+func (q *Queries) GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]GetPollVoteCountsRow, error) {
+	rows, err := q.db.Query(ctx, getPollVoteCounts, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPollVoteCountsRow
+	for rows.Next() {
+		var i GetPollVoteCountsRow
+		if err := rows.Scan(&i.OptionIndex, &i.Votes); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRoomByID = `-- name: GetRoomByID :one
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) GetRoomByID(ctx context.Context, id pgtype.UUID) (Room, error) {
+	row := q.db.QueryRow(ctx, getRoomByID, id)
+	var i Room
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Private,
+		&i.PasswordHash,
+		&i.CreatorID,
+		&i.CreatedAt,
+		&i.SuppressJoinLeave,
+		&i.RetentionDays,
+		&i.DeletedAt,
+		&i.MaxClients,
+	)
+	return i, err
+}
+
+const getRoomByName = `-- name: GetRoomByName :one
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE name = $1 AND deleted_at IS NULL
+`
+
+func (q *Queries) GetRoomByName(ctx context.Context, name string) (Room, error) {
+	row := q.db.QueryRow(ctx, getRoomByName, name)
+	var i Room
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Private,
 		&i.PasswordHash,
 		&i.CreatorID,
 		&i.CreatedAt,
+		&i.SuppressJoinLeave,
+		&i.RetentionDays,
+		&i.DeletedAt,
+		&i.MaxClients,
 	)
 	return i, err
 }
@@ -203,8 +759,58 @@ func (q *Queries) GetRoomMemberCount(ctx context.Context, roomID pgtype.UUID) (i
 	return count, err
 }
 
+const getRoomMemberCounts = `-- name: GetRoomMemberCounts :many
+SELECT room_id, COUNT(*) as count
+FROM room_members
+WHERE room_id = ANY($1::uuid[])
+GROUP BY room_id
+`
+
+type GetRoomMemberCountsRow struct {
+	RoomID pgtype.UUID `json:"room_id"`
+	Count  int64       `json:"count"`
+}
+
+// Member counts of the given rooms; rooms without members are left out
+func (q *Queries) GetRoomMemberCounts(ctx context.Context, roomIds []pgtype.UUID) ([]GetRoomMemberCountsRow, error) {
+	rows, err := q.db.Query(ctx, getRoomMemberCounts, roomIds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRoomMemberCountsRow
+	for rows.Next() {
+		var i GetRoomMemberCountsRow
+		if err := rows.Scan(&i.RoomID, &i.Count); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRoomMemberRole = `-- name: GetRoomMemberRole :one
+SELECT role FROM room_members
+WHERE room_id = $1 AND user_id = $2
+`
+
+type GetRoomMemberRoleParams struct {
+	RoomID pgtype.UUID `json:"room_id"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) GetRoomMemberRole(ctx context.Context, arg GetRoomMemberRoleParams) (string, error) {
+	row := q.db.QueryRow(ctx, getRoomMemberRole, arg.RoomID, arg.UserID)
+	var role string
+	err := row.Scan(&role)
+	return role, err
+}
+
 const getRoomMembers = `-- name: GetRoomMembers :many
-SELECT u.id, u.username, u.email, u.password_hash, u.created_at, u.updated_at, u.last_login, rm.joined_at
+SELECT u.id, u.username, u.email, u.password_hash, u.created_at, u.updated_at, u.last_login, u.username_skeleton, u.email_verified, rm.joined_at
 FROM room_members rm
 JOIN users u ON rm.user_id = u.id
 WHERE rm.room_id = $1
@@ -212,14 +818,16 @@ ORDER BY rm.joined_at ASC
 `
 
 type GetRoomMembersRow struct {
-	ID           pgtype.UUID        `json:"id"`
-	Username     string             `json:"username"`
-	Email        string             `json:"email"`
-	PasswordHash string             `json:"password_hash"`
-	CreatedAt    pgtype.Timestamptz `json:"created_at"`
-	UpdatedAt    pgtype.Timestamptz `json:"updated_at"`
-	LastLogin    pgtype.Timestamptz `json:"last_login"`
-	JoinedAt     pgtype.Timestamptz `json:"joined_at"`
+	ID               pgtype.UUID        `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	PasswordHash     string             `json:"password_hash"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+	LastLogin        pgtype.Timestamptz `json:"last_login"`
+	UsernameSkeleton string             `json:"username_skeleton"`
+	EmailVerified    bool               `json:"email_verified"`
+	JoinedAt         pgtype.Timestamptz `json:"joined_at"`
 }
 
 func (q *Queries) GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]GetRoomMembersRow, error) {
@@ -239,7 +847,100 @@ func (q *Queries) GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]Get
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.LastLogin,
+			&i.UsernameSkeleton,
+			&i.EmailVerified,
+			&i.JoinedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRoomMembersWithRoles = `-- name: GetRoomMembersWithRoles :many
+SELECT u.id, u.username, u.email, u.password_hash, u.created_at, u.updated_at, u.last_login, u.username_skeleton, u.email_verified, rm.joined_at, rm.role
+FROM room_members rm
+JOIN users u ON rm.user_id = u.id
+WHERE rm.room_id = $1
+ORDER BY rm.joined_at ASC
+`
+
+type GetRoomMembersWithRolesRow struct {
+	ID               pgtype.UUID        `json:"id"`
+	Username         string             `json:"username"`
+	Email            string             `json:"email"`
+	PasswordHash     string             `json:"password_hash"`
+	CreatedAt        pgtype.Timestamptz `json:"created_at"`
+	UpdatedAt        pgtype.Timestamptz `json:"updated_at"`
+	LastLogin        pgtype.Timestamptz `json:"last_login"`
+	UsernameSkeleton string             `json:"username_skeleton"`
+	EmailVerified    bool               `json:"email_verified"`
+	JoinedAt         pgtype.Timestamptz `json:"joined_at"`
+	Role             string             `json:"role"`
+}
+
+func (q *Queries) GetRoomMembersWithRoles(ctx context.Context, roomID pgtype.UUID) ([]GetRoomMembersWithRolesRow, error) {
+	rows, err := q.db.Query(ctx, getRoomMembersWithRoles, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRoomMembersWithRolesRow
+	for rows.Next() {
+		var i GetRoomMembersWithRolesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Username,
+			&i.Email,
+			&i.PasswordHash,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LastLogin,
+			&i.UsernameSkeleton,
+			&i.EmailVerified,
 			&i.JoinedAt,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRoomsByNames = `-- name: GetRoomsByNames :many
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE name = ANY($1::text[]) AND deleted_at IS NULL
+`
+
+// The rooms with any of the given names that aren't deleted
+func (q *Queries) GetRoomsByNames(ctx context.Context, names []string) ([]Room, error) {
+	rows, err := q.db.Query(ctx, getRoomsByNames, names)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Room
+	for rows.Next() {
+		var i Room
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Private,
+			&i.PasswordHash,
+			&i.CreatorID,
+			&i.CreatedAt,
+			&i.SuppressJoinLeave,
+			&i.RetentionDays,
+			&i.DeletedAt,
+			&i.MaxClients,
 		); err != nil {
 			return nil, err
 		}
@@ -252,7 +953,7 @@ func (q *Queries) GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]Get
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, username, email, password_hash, created_at, updated_at, last_login FROM users
+SELECT id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified FROM users
 WHERE email = $1
 `
 
@@ -267,12 +968,14 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, username, email, password_hash, created_at, updated_at, last_login FROM users
+SELECT id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified FROM users
 WHERE id = $1
 `
 
@@ -287,15 +990,18 @@ func (q *Queries) GetUserByID(ctx context.Context, id pgtype.UUID) (User, error)
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
 const getUserByUsername = `-- name: GetUserByUsername :one
-SELECT id, username, email, password_hash, created_at, updated_at, last_login FROM users
-WHERE username = $1
+SELECT id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified FROM users
+WHERE lower(username) = lower($1)
 `
 
+// Usernames are matched regardless of case.
 func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	row := q.db.QueryRow(ctx, getUserByUsername, username)
 	var i User
@@ -307,10 +1013,66 @@ func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
+const insertMessages = `-- name: InsertMessages :exec
+INSERT INTO messages (id, room_id, user_id, content, created_at, parent_message_id)
+SELECT id, room_id, user_id, content, created_at, parent_message_id FROM unnest(
+    $1::uuid[],
+    $2::uuid[],
+    $3::uuid[],
+    $4::text[],
+    $5::timestamptz[],
+    $6::uuid[]
+) AS m(id, room_id, user_id, content, created_at, parent_message_id)
+`
+
+type InsertMessagesParams struct {
+	Ids              []pgtype.UUID        `json:"ids"`
+	RoomIds          []pgtype.UUID        `json:"room_ids"`
+	UserIds          []pgtype.UUID        `json:"user_ids"`
+	Contents         []string             `json:"contents"`
+	CreatedAts       []pgtype.Timestamptz `json:"created_ats"`
+	ParentMessageIds []pgtype.UUID        `json:"parent_message_ids"`
+}
+
+// Inserts a small batch of messages in one statement; larger batches use
+// BulkCreateMessages
+func (q *Queries) InsertMessages(ctx context.Context, arg InsertMessagesParams) error {
+	_, err := q.db.Exec(ctx, insertMessages,
+		arg.Ids,
+		arg.RoomIds,
+		arg.UserIds,
+		arg.Contents,
+		arg.CreatedAts,
+		arg.ParentMessageIds,
+	)
+	return err
+}
+
+const isMessagePinned = `-- name: IsMessagePinned :one
+SELECT EXISTS(
+    SELECT 1 FROM pinned_messages
+    WHERE room_id = $1 AND message_id = $2
+)
+`
+
+type IsMessagePinnedParams struct {
+	RoomID    pgtype.UUID `json:"room_id"`
+	MessageID pgtype.UUID `json:"message_id"`
+}
+
+func (q *Queries) IsMessagePinned(ctx context.Context, arg IsMessagePinnedParams) (bool, error) {
+	row := q.db.QueryRow(ctx, isMessagePinned, arg.RoomID, arg.MessageID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const isRoomMember = `-- name: IsRoomMember :one
 SELECT EXISTS(
     SELECT 1 FROM room_members
@@ -330,49 +1092,624 @@ func (q *Queries) IsRoomMember(ctx context.Context, arg IsRoomMemberParams) (boo
 	return exists, err
 }
 
-const listMessagesByRoom = `-- name: ListMessagesByRoom :many
-SELECT m.id, m.room_id, m.user_id, m.content, m.created_at, u.username, r.name as room_name
-FROM messages m
-JOIN users u ON m.user_id = u.id
-JOIN rooms r ON m.room_id = r.id
-WHERE m.room_id = $1
-ORDER BY m.created_at DESC
-LIMIT $2 OFFSET $3
+const listDeletedRooms = `-- name: ListDeletedRooms :many
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
 `
 
-type ListMessagesByRoomParams struct {
-	RoomID pgtype.UUID `json:"room_id"`
-	Limit  int32       `json:"limit"`
-	Offset int32       `json:"offset"`
+func (q *Queries) ListDeletedRooms(ctx context.Context) ([]Room, error) {
+	rows, err := q.db.Query(ctx, listDeletedRooms)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Room
+	for rows.Next() {
+		var i Room
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Private,
+			&i.PasswordHash,
+			&i.CreatorID,
+			&i.CreatedAt,
+			&i.SuppressJoinLeave,
+			&i.RetentionDays,
+			&i.DeletedAt,
+			&i.MaxClients,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-type ListMessagesByRoomRow struct {
-	ID        pgtype.UUID        `json:"id"`
+const listEndedPolls = `-- name: ListEndedPolls :many
+SELECT id, room_id, creator_id, question, options, ends_at, created_at, closed FROM polls
+WHERE NOT closed AND ends_at <= NOW()
+ORDER BY ends_at ASC
+`
+
+func (q *Queries) ListEndedPolls(ctx context.Context) ([]Poll, error) {
+	rows, err := q.db.Query(ctx, listEndedPolls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Poll
+	for rows.Next() {
+		var i Poll
+		if err := rows.Scan(
+			&i.ID,
+			&i.RoomID,
+			&i.CreatorID,
+			&i.Question,
+			&i.Options,
+			&i.EndsAt,
+			&i.CreatedAt,
+			&i.Closed,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listFlaggedMessages = `-- name: ListFlaggedMessages :many
+SELECT f.id, f.user_id, f.username, f.content, f.matched_words, f.flagged_at, COALESCE(r.name, '')::text AS room_name
+FROM flagged_messages f
+LEFT JOIN rooms r ON f.room_id = r.id
+ORDER BY f.flagged_at DESC
+LIMIT $1
+`
+
+type ListFlaggedMessagesRow struct {
+	ID           pgtype.UUID        `json:"id"`
+	UserID       pgtype.UUID        `json:"user_id"`
+	Username     string             `json:"username"`
+	Content      string             `json:"content"`
+	MatchedWords []string           `json:"matched_words"`
+	FlaggedAt    pgtype.Timestamptz `json:"flagged_at"`
+	RoomName     string             `json:"room_name"`
+}
+
+// The newest flagged messages first; room_name is empty for messages sent
+// outside a room
+func (q *Queries) ListFlaggedMessages(ctx context.Context, limit int32) ([]ListFlaggedMessagesRow, error) {
+	rows, err := q.db.Query(ctx, listFlaggedMessages, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFlaggedMessagesRow
+	for rows.Next() {
+		var i ListFlaggedMessagesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Username,
+			&i.Content,
+			&i.MatchedWords,
+			&i.FlaggedAt,
+			&i.RoomName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listLatestMessagesByRooms = `-- name: ListLatestMessagesByRooms :many
+SELECT r.id AS room_id, last.id AS message_id, last.content, last.created_at, last.username
+FROM unnest($1::uuid[]) AS r(id)
+JOIN rooms ON rooms.id = r.id
+JOIN LATERAL (
+    SELECT m.id, m.content, m.created_at, u.username
+    FROM messages m
+    JOIN users u ON m.user_id = u.id
+    WHERE m.room_id = r.id
+    ORDER BY m.created_at DESC
+    LIMIT 1
+) last ON TRUE
+WHERE rooms.private IS NOT TRUE OR EXISTS(
+    SELECT 1 FROM room_members rm
+    WHERE rm.room_id = r.id AND rm.user_id = $2
+)
+`
+
+type ListLatestMessagesByRoomsParams struct {
+	RoomIds  []pgtype.UUID `json:"room_ids"`
+	ViewerID pgtype.UUID   `json:"viewer_id"`
+}
+
+type ListLatestMessagesByRoomsRow struct {
 	RoomID    pgtype.UUID        `json:"room_id"`
-	UserID    pgtype.UUID        `json:"user_id"`
+	MessageID pgtype.UUID        `json:"message_id"`
 	Content   string             `json:"content"`
 	CreatedAt pgtype.Timestamptz `json:"created_at"`
 	Username  string             `json:"username"`
-	RoomName  string             `json:"room_name"`
 }
 
-func (q *Queries) ListMessagesByRoom(ctx context.Context, arg ListMessagesByRoomParams) ([]ListMessagesByRoomRow, error) {
-	rows, err := q.db.Query(ctx, listMessagesByRoom, arg.RoomID, arg.Limit, arg.Offset)
+// The newest message of each of the given rooms, for room list previews.
+// Rooms without messages, and private rooms the viewer isn't a member of, are
+// left out.
+func (q *Queries) ListLatestMessagesByRooms(ctx context.Context, arg ListLatestMessagesByRoomsParams) ([]ListLatestMessagesByRoomsRow, error) {
+	rows, err := q.db.Query(ctx, listLatestMessagesByRooms, arg.RoomIds, arg.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListLatestMessagesByRoomsRow
+	for rows.Next() {
+		var i ListLatestMessagesByRoomsRow
+		if err := rows.Scan(
+			&i.RoomID,
+			&i.MessageID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.Username,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMessagesByRoom = `-- name: ListMessagesByRoom :many
+SELECT m.id, m.room_id, m.user_id, m.content, m.created_at, m.parent_message_id, u.username, r.name as room_name
+FROM messages m
+JOIN users u ON m.user_id = u.id
+JOIN rooms r ON m.room_id = r.id
+WHERE m.room_id = $1
+ORDER BY m.created_at DESC
+LIMIT $2 OFFSET $3
+`
+
+type ListMessagesByRoomParams struct {
+	RoomID pgtype.UUID `json:"room_id"`
+	Limit  int32       `json:"limit"`
+	Offset int32       `json:"offset"`
+}
+
+type ListMessagesByRoomRow struct {
+	ID              pgtype.UUID        `json:"id"`
+	RoomID          pgtype.UUID        `json:"room_id"`
+	UserID          pgtype.UUID        `json:"user_id"`
+	Content         string             `json:"content"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	ParentMessageID pgtype.UUID        `json:"parent_message_id"`
+	Username        string             `json:"username"`
+	RoomName        string             `json:"room_name"`
+}
+
+func (q *Queries) ListMessagesByRoom(ctx context.Context, arg ListMessagesByRoomParams) ([]ListMessagesByRoomRow, error) {
+	rows, err := q.db.Query(ctx, listMessagesByRoom, arg.RoomID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMessagesByRoomRow
+	for rows.Next() {
+		var i ListMessagesByRoomRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.RoomID,
+			&i.UserID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.ParentMessageID,
+			&i.Username,
+			&i.RoomName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMostActiveRooms = `-- name: ListMostActiveRooms :many
+SELECT r.name, COUNT(*) AS messages
+FROM messages m
+JOIN rooms r ON m.room_id = r.id
+WHERE m.created_at >= $1 AND m.created_at < $2
+GROUP BY r.id, r.name
+ORDER BY messages DESC, r.name
+LIMIT $3
+`
+
+type ListMostActiveRoomsParams struct {
+	FromTime pgtype.Timestamptz `json:"from_time"`
+	ToTime   pgtype.Timestamptz `json:"to_time"`
+	RowLimit int32              `json:"row_limit"`
+}
+
+type ListMostActiveRoomsRow struct {
+	Name     string `json:"name"`
+	Messages int64  `json:"messages"`
+}
+
+func (q *Queries) ListMostActiveRooms(ctx context.Context, arg ListMostActiveRoomsParams) ([]ListMostActiveRoomsRow, error) {
+	rows, err := q.db.Query(ctx, listMostActiveRooms, arg.FromTime, arg.ToTime, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMostActiveRoomsRow
+	for rows.Next() {
+		var i ListMostActiveRoomsRow
+		if err := rows.Scan(&i.Name, &i.Messages); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listPinnedMessages = `-- name: ListPinnedMessages :many
+SELECT pm.message_id, pm.pinned_by, pm.pinned_at, m.content, m.created_at, u.username
+FROM pinned_messages pm
+JOIN messages m ON pm.message_id = m.id
+JOIN users u ON m.user_id = u.id
+WHERE pm.room_id = $1
+ORDER BY pm.pinned_at ASC
+`
+
+type ListPinnedMessagesRow struct {
+	MessageID pgtype.UUID        `json:"message_id"`
+	PinnedBy  pgtype.UUID        `json:"pinned_by"`
+	PinnedAt  pgtype.Timestamptz `json:"pinned_at"`
+	Content   string             `json:"content"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	Username  string             `json:"username"`
+}
+
+func (q *Queries) ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]ListPinnedMessagesRow, error) {
+	rows, err := q.db.Query(ctx, listPinnedMessages, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListPinnedMessagesRow
+	for rows.Next() {
+		var i ListPinnedMessagesRow
+		if err := rows.Scan(
+			&i.MessageID,
+			&i.PinnedBy,
+			&i.PinnedAt,
+			&i.Content,
+			&i.CreatedAt,
+			&i.Username,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRecentMessagesByRoom = `-- name: ListRecentMessagesByRoom :many
+SELECT m.id, m.room_id, m.user_id, m.content, m.created_at, m.parent_message_id, u.username, r.name as room_name
+FROM messages m
+JOIN users u ON m.user_id = u.id
+JOIN rooms r ON m.room_id = r.id
+WHERE m.room_id = $1
+ORDER BY m.created_at DESC
+LIMIT $2
+`
+
+type ListRecentMessagesByRoomParams struct {
+	RoomID pgtype.UUID `json:"room_id"`
+	Limit  int32       `json:"limit"`
+}
+
+type ListRecentMessagesByRoomRow struct {
+	ID              pgtype.UUID        `json:"id"`
+	RoomID          pgtype.UUID        `json:"room_id"`
+	UserID          pgtype.UUID        `json:"user_id"`
+	Content         string             `json:"content"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	ParentMessageID pgtype.UUID        `json:"parent_message_id"`
+	Username        string             `json:"username"`
+	RoomName        string             `json:"room_name"`
+}
+
+func (q *Queries) ListRecentMessagesByRoom(ctx context.Context, arg ListRecentMessagesByRoomParams) ([]ListRecentMessagesByRoomRow, error) {
+	rows, err := q.db.Query(ctx, listRecentMessagesByRoom, arg.RoomID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRecentMessagesByRoomRow
+	for rows.Next() {
+		var i ListRecentMessagesByRoomRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.RoomID,
+			&i.UserID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.ParentMessageID,
+			&i.Username,
+			&i.RoomName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoomExportPage = `-- name: ListRoomExportPage :many
+SELECT m.id, m.content, m.created_at, u.username
+FROM messages m
+JOIN users u ON m.user_id = u.id
+WHERE m.room_id = $1
+    AND (m.created_at, m.id) < ($2::timestamptz, $3::uuid)
+ORDER BY m.created_at DESC, m.id DESC
+LIMIT $4
+`
+
+type ListRoomExportPageParams struct {
+	RoomID          pgtype.UUID        `json:"room_id"`
+	BeforeCreatedAt pgtype.Timestamptz `json:"before_created_at"`
+	BeforeID        pgtype.UUID        `json:"before_id"`
+	PageSize        int32              `json:"page_size"`
+}
+
+type ListRoomExportPageRow struct {
+	ID        pgtype.UUID        `json:"id"`
+	Content   string             `json:"content"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	Username  string             `json:"username"`
+}
+
+// A page of a room's messages newest first, before the message at
+// (before_created_at, before_id); 'infinity' starts from the newest one.
+func (q *Queries) ListRoomExportPage(ctx context.Context, arg ListRoomExportPageParams) ([]ListRoomExportPageRow, error) {
+	rows, err := q.db.Query(ctx, listRoomExportPage,
+		arg.RoomID,
+		arg.BeforeCreatedAt,
+		arg.BeforeID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRoomExportPageRow
+	for rows.Next() {
+		var i ListRoomExportPageRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.Username,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoomTranscript = `-- name: ListRoomTranscript :many
+SELECT m.id, m.content, m.created_at, u.username
+FROM messages m
+JOIN users u ON m.user_id = u.id
+WHERE m.room_id = $1
+    AND (m.created_at, m.id) > ($2::timestamptz, $3::uuid)
+ORDER BY m.created_at, m.id
+LIMIT $4
+`
+
+type ListRoomTranscriptParams struct {
+	RoomID         pgtype.UUID        `json:"room_id"`
+	AfterCreatedAt pgtype.Timestamptz `json:"after_created_at"`
+	AfterID        pgtype.UUID        `json:"after_id"`
+	PageSize       int32              `json:"page_size"`
+}
+
+type ListRoomTranscriptRow struct {
+	ID        pgtype.UUID        `json:"id"`
+	Content   string             `json:"content"`
+	CreatedAt pgtype.Timestamptz `json:"created_at"`
+	Username  string             `json:"username"`
+}
+
+// A page of a room's messages oldest first, after the message at
+// (after_created_at, after_id); '-infinity' starts from the first one.
+func (q *Queries) ListRoomTranscript(ctx context.Context, arg ListRoomTranscriptParams) ([]ListRoomTranscriptRow, error) {
+	rows, err := q.db.Query(ctx, listRoomTranscript,
+		arg.RoomID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListRoomTranscriptRow
+	for rows.Next() {
+		var i ListRoomTranscriptRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Content,
+			&i.CreatedAt,
+			&i.Username,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRooms = `-- name: ListRooms :many
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE deleted_at IS NULL
+ORDER BY created_at DESC
+LIMIT $1 OFFSET $2
+`
+
+type ListRoomsParams struct {
+	Limit  int32 `json:"limit"`
+	Offset int32 `json:"offset"`
+}
+
+func (q *Queries) ListRooms(ctx context.Context, arg ListRoomsParams) ([]Room, error) {
+	rows, err := q.db.Query(ctx, listRooms, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Room
+	for rows.Next() {
+		var i Room
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Private,
+			&i.PasswordHash,
+			&i.CreatorID,
+			&i.CreatedAt,
+			&i.SuppressJoinLeave,
+			&i.RetentionDays,
+			&i.DeletedAt,
+			&i.MaxClients,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listRoomsByCreator = `-- name: ListRoomsByCreator :many
+SELECT id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients FROM rooms
+WHERE creator_id = $1 AND deleted_at IS NULL
+ORDER BY created_at DESC
+LIMIT $2 OFFSET $3
+`
+
+type ListRoomsByCreatorParams struct {
+	CreatorID pgtype.UUID `json:"creator_id"`
+	Limit     int32       `json:"limit"`
+	Offset    int32       `json:"offset"`
+}
+
+func (q *Queries) ListRoomsByCreator(ctx context.Context, arg ListRoomsByCreatorParams) ([]Room, error) {
+	rows, err := q.db.Query(ctx, listRoomsByCreator, arg.CreatorID, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Room
+	for rows.Next() {
+		var i Room
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Private,
+			&i.PasswordHash,
+			&i.CreatorID,
+			&i.CreatedAt,
+			&i.SuppressJoinLeave,
+			&i.RetentionDays,
+			&i.DeletedAt,
+			&i.MaxClients,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listThreadMessages = `-- name: ListThreadMessages :many
+SELECT m.id, m.room_id, m.user_id, m.content, m.created_at, m.parent_message_id, u.username
+FROM messages m
+JOIN users u ON m.user_id = u.id
+WHERE m.parent_message_id = $1
+ORDER BY m.created_at ASC
+LIMIT $2
+`
+
+type ListThreadMessagesParams struct {
+	ParentMessageID pgtype.UUID `json:"parent_message_id"`
+	Limit           int32       `json:"limit"`
+}
+
+type ListThreadMessagesRow struct {
+	ID              pgtype.UUID        `json:"id"`
+	RoomID          pgtype.UUID        `json:"room_id"`
+	UserID          pgtype.UUID        `json:"user_id"`
+	Content         string             `json:"content"`
+	CreatedAt       pgtype.Timestamptz `json:"created_at"`
+	ParentMessageID pgtype.UUID        `json:"parent_message_id"`
+	Username        string             `json:"username"`
+}
+
+// Replies to a message, oldest first.
+func (q *Queries) ListThreadMessages(ctx context.Context, arg ListThreadMessagesParams) ([]ListThreadMessagesRow, error) {
+	rows, err := q.db.Query(ctx, listThreadMessages, arg.ParentMessageID, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListMessagesByRoomRow
+	var items []ListThreadMessagesRow
 	for rows.Next() {
-		var i ListMessagesByRoomRow
+		var i ListThreadMessagesRow
 		if err := rows.Scan(
 			&i.ID,
 			&i.RoomID,
 			&i.UserID,
 			&i.Content,
 			&i.CreatedAt,
+			&i.ParentMessageID,
 			&i.Username,
-			&i.RoomName,
 		); err != nil {
 			return nil, err
 		}
@@ -384,48 +1721,63 @@ func (q *Queries) ListMessagesByRoom(ctx context.Context, arg ListMessagesByRoom
 	return items, nil
 }
 
-const listRecentMessagesByRoom = `-- name: ListRecentMessagesByRoom :many
-SELECT m.id, m.room_id, m.user_id, m.content, m.created_at, u.username, r.name as room_name
-FROM messages m
-JOIN users u ON m.user_id = u.id
-JOIN rooms r ON m.room_id = r.id
-WHERE m.room_id = $1
-ORDER BY m.created_at DESC
-LIMIT $2
+const listUserRoomSummaries = `-- name: ListUserRoomSummaries :many
+SELECT r.id, r.name, r.private, rm.last_read_at,
+    (SELECT COUNT(*) FROM room_members c WHERE c.room_id = r.id) AS member_count,
+    (SELECT COUNT(*) FROM messages m
+        WHERE m.room_id = r.id AND m.created_at > rm.last_read_at AND m.user_id <> rm.user_id) AS unread_count,
+    last.id AS last_message_id, last.content AS last_message_content,
+    last.created_at AS last_message_at, last.username AS last_message_sender
+FROM room_members rm
+JOIN rooms r ON rm.room_id = r.id
+LEFT JOIN LATERAL (
+    SELECT m.id, m.content, m.created_at, u.username
+    FROM messages m
+    JOIN users u ON m.user_id = u.id
+    WHERE m.room_id = r.id
+    ORDER BY m.created_at DESC
+    LIMIT 1
+) last ON TRUE
+WHERE rm.user_id = $1 AND r.deleted_at IS NULL
+ORDER BY COALESCE(last.created_at, rm.joined_at) DESC
 `
 
-type ListRecentMessagesByRoomParams struct {
-	RoomID pgtype.UUID `json:"room_id"`
-	Limit  int32       `json:"limit"`
-}
-
-type ListRecentMessagesByRoomRow struct {
-	ID        pgtype.UUID        `json:"id"`
-	RoomID    pgtype.UUID        `json:"room_id"`
-	UserID    pgtype.UUID        `json:"user_id"`
-	Content   string             `json:"content"`
-	CreatedAt pgtype.Timestamptz `json:"created_at"`
-	Username  string             `json:"username"`
-	RoomName  string             `json:"room_name"`
+type ListUserRoomSummariesRow struct {
+	ID                 pgtype.UUID        `json:"id"`
+	Name               string             `json:"name"`
+	Private            pgtype.Bool        `json:"private"`
+	LastReadAt         pgtype.Timestamptz `json:"last_read_at"`
+	MemberCount        int64              `json:"member_count"`
+	UnreadCount        int64              `json:"unread_count"`
+	LastMessageID      pgtype.UUID        `json:"last_message_id"`
+	LastMessageContent pgtype.Text        `json:"last_message_content"`
+	LastMessageAt      pgtype.Timestamptz `json:"last_message_at"`
+	LastMessageSender  pgtype.Text        `json:"last_message_sender"`
 }
 
-func (q *Queries) ListRecentMessagesByRoom(ctx context.Context, arg ListRecentMessagesByRoomParams) ([]ListRecentMessagesByRoomRow, error) {
-	rows, err := q.db.Query(ctx, listRecentMessagesByRoom, arg.RoomID, arg.Limit)
+// The rooms a user is a member of with their member count, messages from
+// others since the user last read the room and latest message, most recently
+// active first
+func (q *Queries) ListUserRoomSummaries(ctx context.Context, userID pgtype.UUID) ([]ListUserRoomSummariesRow, error) {
+	rows, err := q.db.Query(ctx, listUserRoomSummaries, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []ListRecentMessagesByRoomRow
+	var items []ListUserRoomSummariesRow
 	for rows.Next() {
-		var i ListRecentMessagesByRoomRow
+		var i ListUserRoomSummariesRow
 		if err := rows.Scan(
 			&i.ID,
-			&i.RoomID,
-			&i.UserID,
-			&i.Content,
-			&i.CreatedAt,
-			&i.Username,
-			&i.RoomName,
+			&i.Name,
+			&i.Private,
+			&i.LastReadAt,
+			&i.MemberCount,
+			&i.UnreadCount,
+			&i.LastMessageID,
+			&i.LastMessageContent,
+			&i.LastMessageAt,
+			&i.LastMessageSender,
 		); err != nil {
 			return nil, err
 		}
@@ -437,33 +1789,36 @@ func (q *Queries) ListRecentMessagesByRoom(ctx context.Context, arg ListRecentMe
 	return items, nil
 }
 
-const listRooms = `-- name: ListRooms :many
-SELECT id, name, private, password_hash, creator_id, created_at FROM rooms
+const listUsers = `-- name: ListUsers :many
+SELECT id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified FROM users
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2
 `
 
-type ListRoomsParams struct {
+type ListUsersParams struct {
 	Limit  int32 `json:"limit"`
 	Offset int32 `json:"offset"`
 }
 
-func (q *Queries) ListRooms(ctx context.Context, arg ListRoomsParams) ([]Room, error) {
-	rows, err := q.db.Query(ctx, listRooms, arg.Limit, arg.Offset)
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, listUsers, arg.Limit, arg.Offset)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Room
+	var items []User
 	for rows.Next() {
-		var i Room
+		var i User
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Private,
+			&i.Username,
+			&i.Email,
 			&i.PasswordHash,
-			&i.CreatorID,
 			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.LastLogin,
+			&i.UsernameSkeleton,
+			&i.EmailVerified,
 		); err != nil {
 			return nil, err
 		}
@@ -475,36 +1830,87 @@ func (q *Queries) ListRooms(ctx context.Context, arg ListRoomsParams) ([]Room, e
 	return items, nil
 }
 
-const listRoomsByCreator = `-- name: ListRoomsByCreator :many
-SELECT id, name, private, password_hash, creator_id, created_at FROM rooms
-WHERE creator_id = $1
-ORDER BY created_at DESC
-LIMIT $2 OFFSET $3
+const markOutboxFailed = `-- name: MarkOutboxFailed :exec
+UPDATE message_outbox
+SET attempts = attempts + 1,
+    last_error = $1,
+    next_attempt_at = NOW() + make_interval(secs => $2::float8)
+WHERE id = $3
 `
 
-type ListRoomsByCreatorParams struct {
-	CreatorID pgtype.UUID `json:"creator_id"`
-	Limit     int32       `json:"limit"`
-	Offset    int32       `json:"offset"`
+type MarkOutboxFailedParams struct {
+	LastError    pgtype.Text `json:"last_error"`
+	RetrySeconds float64     `json:"retry_seconds"`
+	ID           int64       `json:"id"`
 }
 
-func (q *Queries) ListRoomsByCreator(ctx context.Context, arg ListRoomsByCreatorParams) ([]Room, error) {
-	rows, err := q.db.Query(ctx, listRoomsByCreator, arg.CreatorID, arg.Limit, arg.Offset)
+func (q *Queries) MarkOutboxFailed(ctx context.Context, arg MarkOutboxFailedParams) error {
+	_, err := q.db.Exec(ctx, markOutboxFailed, arg.LastError, arg.RetrySeconds, arg.ID)
+	return err
+}
+
+const markOutboxSent = `-- name: MarkOutboxSent :exec
+UPDATE message_outbox
+SET sent_at = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkOutboxSent(ctx context.Context, id int64) error {
+	_, err := q.db.Exec(ctx, markOutboxSent, id)
+	return err
+}
+
+const markRoomRead = `-- name: MarkRoomRead :exec
+UPDATE room_members
+SET last_read_at = CURRENT_TIMESTAMP
+WHERE room_id = $1 AND user_id = $2
+`
+
+type MarkRoomReadParams struct {
+	RoomID pgtype.UUID `json:"room_id"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+// Records that the user has caught up with the room's messages
+func (q *Queries) MarkRoomRead(ctx context.Context, arg MarkRoomReadParams) error {
+	_, err := q.db.Exec(ctx, markRoomRead, arg.RoomID, arg.UserID)
+	return err
+}
+
+const peakConnectionsPerDay = `-- name: PeakConnectionsPerDay :many
+SELECT (minute AT TIME ZONE 'UTC')::date AS day, MAX(connections)::bigint AS peak_connections
+FROM (
+    SELECT date_trunc('minute', sampled_at) AS minute, SUM(peak_connections) AS connections
+    FROM stats_samples
+    WHERE sampled_at >= $1 AND sampled_at < $2
+    GROUP BY minute
+) per_minute
+GROUP BY day
+ORDER BY day
+`
+
+type PeakConnectionsPerDayParams struct {
+	FromTime pgtype.Timestamptz `json:"from_time"`
+	ToTime   pgtype.Timestamptz `json:"to_time"`
+}
+
+type PeakConnectionsPerDayRow struct {
+	Day             pgtype.Date `json:"day"`
+	PeakConnections int64       `json:"peak_connections"`
+}
+
+// The highest cluster-wide connection count of each day, adding up the
+// servers' samples taken in the same minute
+func (q *Queries) PeakConnectionsPerDay(ctx context.Context, arg PeakConnectionsPerDayParams) ([]PeakConnectionsPerDayRow, error) {
+	rows, err := q.db.Query(ctx, peakConnectionsPerDay, arg.FromTime, arg.ToTime)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Room
+	var items []PeakConnectionsPerDayRow
 	for rows.Next() {
-		var i Room
-		if err := rows.Scan(
-			&i.ID,
-			&i.Name,
-			&i.Private,
-			&i.PasswordHash,
-			&i.CreatorID,
-			&i.CreatedAt,
-		); err != nil {
+		var i PeakConnectionsPerDayRow
+		if err := rows.Scan(&i.Day, &i.PeakConnections); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -515,19 +1921,107 @@ func (q *Queries) ListRoomsByCreator(ctx context.Context, arg ListRoomsByCreator
 	return items, nil
 }
 
-const listUsers = `-- name: ListUsers :many
-SELECT id, username, email, password_hash, created_at, updated_at, last_login FROM users
-ORDER BY created_at DESC
-LIMIT $1 OFFSET $2
+const pinMessage = `-- name: PinMessage :one
+INSERT INTO pinned_messages (room_id, message_id, pinned_by)
+SELECT $1::uuid, $2::uuid, $3::uuid
+WHERE (SELECT COUNT(*) FROM pinned_messages WHERE room_id = $1::uuid) < $4::bigint
+ON CONFLICT (room_id, message_id) DO NOTHING
+RETURNING room_id, message_id, pinned_by, pinned_at
 `
 
-type ListUsersParams struct {
-	Limit  int32 `json:"limit"`
-	Offset int32 `json:"offset"`
+type PinMessageParams struct {
+	RoomID    pgtype.UUID `json:"room_id"`
+	MessageID pgtype.UUID `json:"message_id"`
+	PinnedBy  pgtype.UUID `json:"pinned_by"`
+	MaxPins   int64       `json:"max_pins"`
 }
 
-func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
-	rows, err := q.db.Query(ctx, listUsers, arg.Limit, arg.Offset)
+func (q *Queries) PinMessage(ctx context.Context, arg PinMessageParams) (PinnedMessage, error) {
+	row := q.db.QueryRow(ctx, pinMessage,
+		arg.RoomID,
+		arg.MessageID,
+		arg.PinnedBy,
+		arg.MaxPins,
+	)
+	var i PinnedMessage
+	err := row.Scan(
+		&i.RoomID,
+		&i.MessageID,
+		&i.PinnedBy,
+		&i.PinnedAt,
+	)
+	return i, err
+}
+
+const purgeDeletedRooms = `-- name: PurgeDeletedRooms :execrows
+DELETE FROM rooms
+WHERE deleted_at < $1
+`
+
+// Permanently deletes rooms soft-deleted before cutoff
+func (q *Queries) PurgeDeletedRooms(ctx context.Context, cutoff pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedRooms, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const removeRoomMember = `-- name: RemoveRoomMember :exec
+DELETE FROM room_members
+WHERE room_id = $1 AND user_id = $2
+`
+
+type RemoveRoomMemberParams struct {
+	RoomID pgtype.UUID `json:"room_id"`
+	UserID pgtype.UUID `json:"user_id"`
+}
+
+func (q *Queries) RemoveRoomMember(ctx context.Context, arg RemoveRoomMemberParams) error {
+	_, err := q.db.Exec(ctx, removeRoomMember, arg.RoomID, arg.UserID)
+	return err
+}
+
+const restoreRoom = `-- name: RestoreRoom :one
+UPDATE rooms
+SET deleted_at = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients
+`
+
+func (q *Queries) RestoreRoom(ctx context.Context, id pgtype.UUID) (Room, error) {
+	row := q.db.QueryRow(ctx, restoreRoom, id)
+	var i Room
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Private,
+		&i.PasswordHash,
+		&i.CreatorID,
+		&i.CreatedAt,
+		&i.SuppressJoinLeave,
+		&i.RetentionDays,
+		&i.DeletedAt,
+		&i.MaxClients,
+	)
+	return i, err
+}
+
+const searchUsers = `-- name: SearchUsers :many
+SELECT id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified FROM users
+WHERE username ILIKE $1
+ORDER BY username
+LIMIT $2
+`
+
+type SearchUsersParams struct {
+	Username string `json:"username"`
+	Limit    int32  `json:"limit"`
+}
+
+// Users whose name matches an ILIKE pattern, in name order
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]User, error) {
+	rows, err := q.db.Query(ctx, searchUsers, arg.Username, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -543,6 +2037,8 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, e
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.LastLogin,
+			&i.UsernameSkeleton,
+			&i.EmailVerified,
 		); err != nil {
 			return nil, err
 		}
@@ -554,26 +2050,102 @@ func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, e
 	return items, nil
 }
 
-const removeRoomMember = `-- name: RemoveRoomMember :exec
-DELETE FROM room_members
+const setRoomMemberRole = `-- name: SetRoomMemberRole :execrows
+UPDATE room_members
+SET role = $3
 WHERE room_id = $1 AND user_id = $2
 `
 
-type RemoveRoomMemberParams struct {
+type SetRoomMemberRoleParams struct {
 	RoomID pgtype.UUID `json:"room_id"`
 	UserID pgtype.UUID `json:"user_id"`
+	Role   string      `json:"role"`
 }
 
-func (q *Queries) RemoveRoomMember(ctx context.Context, arg RemoveRoomMemberParams) error {
-	_, err := q.db.Exec(ctx, removeRoomMember, arg.RoomID, arg.UserID)
-	return err
+func (q *Queries) SetRoomMemberRole(ctx context.Context, arg SetRoomMemberRoleParams) (int64, error) {
+	result, err := q.db.Exec(ctx, setRoomMemberRole, arg.RoomID, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const setUserEmailVerified = `-- name: SetUserEmailVerified :one
+UPDATE users
+SET email_verified = true
+WHERE id = $1
+RETURNING id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified
+`
+
+func (q *Queries) SetUserEmailVerified(ctx context.Context, id pgtype.UUID) (User, error) {
+	row := q.db.QueryRow(ctx, setUserEmailVerified, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Username,
+		&i.Email,
+		&i.PasswordHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
+	)
+	return i, err
+}
+
+const softDeleteRoom = `-- name: SoftDeleteRoom :execrows
+UPDATE rooms
+SET deleted_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+// Hides a room from listings and joins while keeping its messages until
+// PurgeDeletedRooms removes it
+func (q *Queries) SoftDeleteRoom(ctx context.Context, id pgtype.UUID) (int64, error) {
+	result, err := q.db.Exec(ctx, softDeleteRoom, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const tryAdvisoryXactLock = `-- name: TryAdvisoryXactLock :one
+SELECT pg_try_advisory_xact_lock($1::bigint) AS locked
+`
+
+// Takes an advisory lock held until the current transaction ends, without
+// waiting if another session holds it
+func (q *Queries) TryAdvisoryXactLock(ctx context.Context, lockID int64) (bool, error) {
+	row := q.db.QueryRow(ctx, tryAdvisoryXactLock, lockID)
+	var locked bool
+	err := row.Scan(&locked)
+	return locked, err
+}
+
+const unpinMessage = `-- name: UnpinMessage :execrows
+DELETE FROM pinned_messages
+WHERE room_id = $1 AND message_id = $2
+`
+
+type UnpinMessageParams struct {
+	RoomID    pgtype.UUID `json:"room_id"`
+	MessageID pgtype.UUID `json:"message_id"`
+}
+
+func (q *Queries) UnpinMessage(ctx context.Context, arg UnpinMessageParams) (int64, error) {
+	result, err := q.db.Exec(ctx, unpinMessage, arg.RoomID, arg.MessageID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
 }
 
 const updateRoom = `-- name: UpdateRoom :one
 UPDATE rooms
 SET name = $2, private = $3, password_hash = $4
 WHERE id = $1
-RETURNING id, name, private, password_hash, creator_id, created_at
+RETURNING id, name, private, password_hash, creator_id, created_at, suppress_join_leave, retention_days, deleted_at, max_clients
 `
 
 type UpdateRoomParams struct {
@@ -598,15 +2170,116 @@ func (q *Queries) UpdateRoom(ctx context.Context, arg UpdateRoomParams) (Room, e
 		&i.PasswordHash,
 		&i.CreatorID,
 		&i.CreatedAt,
+		&i.SuppressJoinLeave,
+		&i.RetentionDays,
+		&i.DeletedAt,
+		&i.MaxClients,
+	)
+	return i, err
+}
+
+const updateMessageContent = `-- name: UpdateMessageContent :one
+UPDATE messages
+SET content = $2
+WHERE id = $1
+RETURNING id, room_id, user_id, content, created_at, parent_message_id
+`
+
+type UpdateMessageContentParams struct {
+	ID      pgtype.UUID `json:"id"`
+	Content string      `json:"content"`
+}
+
+func (q *Queries) UpdateMessageContent(ctx context.Context, arg UpdateMessageContentParams) (Message, error) {
+	row := q.db.QueryRow(ctx, updateMessageContent, arg.ID, arg.Content)
+	var i Message
+	err := row.Scan(
+		&i.ID,
+		&i.RoomID,
+		&i.UserID,
+		&i.Content,
+		&i.CreatedAt,
+		&i.ParentMessageID,
 	)
 	return i, err
 }
 
+const updateRoomPassword = `-- name: UpdateRoomPassword :exec
+UPDATE rooms
+SET password_hash = $2
+WHERE id = $1
+`
+
+type UpdateRoomPasswordParams struct {
+	ID           pgtype.UUID `json:"id"`
+	PasswordHash pgtype.Text `json:"password_hash"`
+}
+
+func (q *Queries) UpdateRoomPassword(ctx context.Context, arg UpdateRoomPasswordParams) error {
+	_, err := q.db.Exec(ctx, updateRoomPassword, arg.ID, arg.PasswordHash)
+	return err
+}
+
+const updateRoomRetentionDays = `-- name: UpdateRoomRetentionDays :exec
+UPDATE rooms
+SET retention_days = $2
+WHERE id = $1
+`
+
+type UpdateRoomRetentionDaysParams struct {
+	ID            pgtype.UUID `json:"id"`
+	RetentionDays pgtype.Int4 `json:"retention_days"`
+}
+
+func (q *Queries) UpdateRoomRetentionDays(ctx context.Context, arg UpdateRoomRetentionDaysParams) error {
+	_, err := q.db.Exec(ctx, updateRoomRetentionDays, arg.ID, arg.RetentionDays)
+	return err
+}
+
+const updateRoomSuppressJoinLeave = `-- name: UpdateRoomSuppressJoinLeave :exec
+UPDATE rooms
+SET suppress_join_leave = $2
+WHERE id = $1
+`
+
+type UpdateRoomSuppressJoinLeaveParams struct {
+	ID                pgtype.UUID `json:"id"`
+	SuppressJoinLeave bool        `json:"suppress_join_leave"`
+}
+
+func (q *Queries) UpdateRoomSuppressJoinLeave(ctx context.Context, arg UpdateRoomSuppressJoinLeaveParams) error {
+	_, err := q.db.Exec(ctx, updateRoomSuppressJoinLeave, arg.ID, arg.SuppressJoinLeave)
+	return err
+}
+
+const upsertPollVote = `-- name: UpsertPollVote :execrows
+INSERT INTO poll_votes (poll_id, user_id, option_index)
+SELECT p.id, $1::uuid, $2::int
+FROM polls p
+WHERE p.id = $3::uuid AND NOT p.closed AND p.ends_at > NOW()
+ON CONFLICT (poll_id, user_id) DO UPDATE
+SET option_index = EXCLUDED.option_index, voted_at = CURRENT_TIMESTAMP
+`
+
+type UpsertPollVoteParams struct {
+	UserID      pgtype.UUID `json:"user_id"`
+	OptionIndex int32       `json:"option_index"`
+	PollID      pgtype.UUID `json:"poll_id"`
+}
+
+func (q *Queries) UpsertPollVote(ctx context.Context, arg UpsertPollVoteParams) (int64, error) {
+	result, err := q.db.Exec(ctx, upsertPollVote, arg.UserID, arg.OptionIndex, arg.PollID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
 const updateUserLastLogin = `-- name: UpdateUserLastLogin :one
 UPDATE users
 SET last_login = $2
 WHERE id = $1
-RETURNING id, username, email, password_hash, created_at, updated_at, last_login
+RETURNING id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified
 `
 
 type UpdateUserLastLoginParams struct {
@@ -625,6 +2298,8 @@ func (q *Queries) UpdateUserLastLogin(ctx context.Context, arg UpdateUserLastLog
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
 	)
 	return i, err
 }
@@ -633,7 +2308,7 @@ const updateUserPassword = `-- name: UpdateUserPassword :one
 UPDATE users
 SET password_hash = $2
 WHERE id = $1
-RETURNING id, username, email, password_hash, created_at, updated_at, last_login
+RETURNING id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified
 `
 
 type UpdateUserPasswordParams struct {
@@ -652,6 +2327,8 @@ func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPassword
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
 	)
 	return i, err
 }
@@ -659,19 +2336,20 @@ func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPassword
 const updateUserUsername = `-- name: UpdateUserUsername :one
 
 UPDATE users
-SET username = $2
+SET username = $2, username_skeleton = $3
 WHERE id = $1
-RETURNING id, username, email, password_hash, created_at, updated_at, last_login
+RETURNING id, username, email, password_hash, created_at, updated_at, last_login, username_skeleton, email_verified
 `
 
 type UpdateUserUsernameParams struct {
-	ID       pgtype.UUID `json:"id"`
-	Username string      `json:"username"`
+	ID               pgtype.UUID `json:"id"`
+	Username         string      `json:"username"`
+	UsernameSkeleton string      `json:"username_skeleton"`
 }
 
 // User profile management queries
 func (q *Queries) UpdateUserUsername(ctx context.Context, arg UpdateUserUsernameParams) (User, error) {
-	row := q.db.QueryRow(ctx, updateUserUsername, arg.ID, arg.Username)
+	row := q.db.QueryRow(ctx, updateUserUsername, arg.ID, arg.Username, arg.UsernameSkeleton)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -681,6 +2359,8 @@ func (q *Queries) UpdateUserUsername(ctx context.Context, arg UpdateUserUsername
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.LastLogin,
+		&i.UsernameSkeleton,
+		&i.EmailVerified,
 	)
 	return i, err
 }
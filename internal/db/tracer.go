@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"websocket-demo/internal/tracing"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxLoggedSQL caps how much of an unnamed statement is logged
+const maxLoggedSQL = 80
+
+// SlowQueryTracer is a pgx.QueryTracer that logs and counts queries taking
+// at least its threshold
+type SlowQueryTracer struct {
+	threshold time.Duration
+	count     atomic.Int64
+}
+
+// NewSlowQueryTracer returns a tracer for queries taking at least threshold
+func NewSlowQueryTracer(threshold time.Duration) *SlowQueryTracer {
+	return &SlowQueryTracer{threshold: threshold}
+}
+
+// slowQueryKey keys the query start in the context passed between trace calls
+type slowQueryKey struct{}
+
+// queryStart is a running query's SQL and start time
+type queryStart struct {
+	sql   string
+	start time.Time
+}
+
+// TraceQueryStart records when the query started
+func (t *SlowQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, slowQueryKey{}, queryStart{sql: data.SQL, start: time.Now()})
+}
+
+// TraceQueryEnd logs and counts the query if it took at least the threshold
+func (t *SlowQueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	started, ok := ctx.Value(slowQueryKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	duration := time.Since(started.start)
+	if duration < t.threshold {
+		return
+	}
+	t.count.Add(1)
+	log.Printf("Slow query %s took %s (threshold %s, error: %v)", QueryName(started.sql), duration, t.threshold, data.Err)
+}
+
+// SlowQueries returns how many slow queries the tracer has seen
+func (t *SlowQueryTracer) SlowQueries() int64 {
+	return t.count.Load()
+}
+
+// SpanQueryTracer is a pgx.QueryTracer that records each query as a span
+// named after it, a child of the span in the query's context. Queries run
+// outside a traced request, such as background polling, get no span.
+type SpanQueryTracer struct{}
+
+// NewSpanQueryTracer returns a tracer recording queries as spans
+func NewSpanQueryTracer() *SpanQueryTracer {
+	return &SpanQueryTracer{}
+}
+
+// querySpanKey keys the query's span in the context passed between trace calls
+type querySpanKey struct{}
+
+// TraceQueryStart starts the query's span when ctx is traced
+func (t *SpanQueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !tracing.Traced(ctx) {
+		return ctx
+	}
+	name := QueryName(data.SQL)
+	ctx, span := tracing.Tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemNamePostgreSQL, semconv.DBOperationName(name), semconv.DBQueryText(data.SQL)),
+	)
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+// TraceQueryEnd ends the query's span, recording its error
+func (t *SpanQueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	}
+	span.End()
+}
+
+// QueryName returns the sqlc name of a generated query, such as
+// "CreateMessage", or the start of the statement for other SQL
+func QueryName(sql string) string {
+	sql = strings.TrimSpace(sql)
+	if rest, ok := strings.CutPrefix(sql, "-- name: "); ok {
+		if name, _, found := strings.Cut(rest, " "); found {
+			return name
+		}
+	}
+	sql = strings.Join(strings.Fields(sql), " ")
+	if len(sql) > maxLoggedSQL {
+		sql = sql[:maxLoggedSQL] + "..."
+	}
+	return sql
+}
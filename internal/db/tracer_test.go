@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/metrics"
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/tracing/tracingtest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+)
+
+func TestQueryName(t *testing.T) {
+	assert.Equal(t, "CreateMessage", QueryName(createMessage))
+	assert.Equal(t, "SELECT pg_sleep($1)", QueryName("  SELECT\n\tpg_sleep($1)\n"))
+
+	long := QueryName("SELECT " + string(make([]byte, 200)))
+	assert.Len(t, long, maxLoggedSQL+len("..."))
+}
+
+func TestSlowQueryTracerCountsQueriesOverThreshold(t *testing.T) {
+	tracer := NewSlowQueryTracer(20 * time.Millisecond)
+	trace := func(sleep time.Duration) {
+		ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: createMessage})
+		time.Sleep(sleep)
+		tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	}
+
+	trace(0)
+	assert.Zero(t, tracer.SlowQueries())
+
+	trace(25 * time.Millisecond)
+	assert.Equal(t, int64(1), tracer.SlowQueries())
+
+	// An end without a matching start is ignored
+	tracer.TraceQueryEnd(context.Background(), nil, pgx.TraceQueryEndData{})
+	assert.Equal(t, int64(1), tracer.SlowQueries())
+}
+
+func TestSpanQueryTracer(t *testing.T) {
+	recorder := tracingtest.Record(t)
+	tracer := NewSpanQueryTracer()
+
+	// Queries outside a traced request get no span
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: createMessage})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+	assert.Empty(t, recorder.Ended())
+
+	parentCtx, parent := tracing.Tracer().Start(context.Background(), "ws.message")
+	ctx = tracer.TraceQueryStart(parentCtx, nil, pgx.TraceQueryStartData{SQL: createMessage})
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{Err: errors.New("connection reset")})
+	parent.End()
+
+	spans := tracingtest.Find(recorder, "CreateMessage")
+	require.Len(t, spans, 1)
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Attributes(), semconv.DBSystemNamePostgreSQL)
+
+	// The slow query count is still found when both tracers are installed
+	slow := NewSlowQueryTracer(time.Second)
+	assert.Same(t, slow, findSlowQueryTracer(multitracer.New(slow, tracer)))
+	assert.Nil(t, findSlowQueryTracer(tracer))
+}
+
+func TestRecordPoolStats(t *testing.T) {
+	// The pool connects lazily, so an unreachable address is fine for reading stats
+	cfg, err := pgxpool.ParseConfig("postgres://chatx@127.0.0.1:1/chatx")
+	require.NoError(t, err)
+	cfg.MaxConns = 7
+	cfg.MinConns = 0
+	tracer := NewSlowQueryTracer(time.Millisecond)
+	cfg.ConnConfig.Tracer = tracer
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	ctx := tracer.TraceQueryStart(context.Background(), nil, pgx.TraceQueryStartData{SQL: "SELECT 1"})
+	time.Sleep(2 * time.Millisecond)
+	tracer.TraceQueryEnd(ctx, nil, pgx.TraceQueryEndData{})
+
+	m := metrics.NewMetrics()
+	RecordPoolStats(pool, m)
+	stats := m.GetDBPoolStats()
+	assert.Equal(t, int32(7), stats.MaxConns)
+	assert.Zero(t, stats.AcquiredConns)
+	assert.Equal(t, int64(1), m.GetDBSlowQueries())
+}
+
+func TestPoolStatsAndSlowQueries(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	poolCfg := DefaultPoolConfig()
+	poolCfg.MinConns = 1
+	poolCfg.MaxConns = 2
+	poolCfg.SlowQueryThreshold = 50 * time.Millisecond
+	pool, err := NewPool(ctx, url, poolCfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Exec(ctx, "SELECT 1")
+	require.NoError(t, err)
+	_, err = pool.Exec(ctx, "SELECT pg_sleep(0.1)")
+	require.NoError(t, err)
+
+	conn, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	m := metrics.NewMetrics()
+	go WatchPoolStats(ctx, pool, 10*time.Millisecond, m)
+	require.Eventually(t, func() bool {
+		return m.GetDBPoolStats().AcquiredConns == 1 && m.GetDBSlowQueries() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	stats := m.GetDBPoolStats()
+	assert.Equal(t, int32(2), stats.MaxConns)
+	assert.GreaterOrEqual(t, stats.TotalConns, int32(1))
+}
+
+func TestGetPoolStats(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	poolCfg := DefaultPoolConfig()
+	poolCfg.MinConns = 0
+	poolCfg.MaxConns = 3
+	pool, err := NewPool(ctx, url, poolCfg)
+	require.NoError(t, err)
+	defer pool.Close()
+
+	_, err = pool.Exec(ctx, "SELECT 1")
+	require.NoError(t, err)
+	conn, err := pool.Acquire(ctx)
+	require.NoError(t, err)
+	defer conn.Release()
+
+	stats := GetPoolStats(pool)
+	assert.Equal(t, int32(3), stats.MaxConns)
+	assert.GreaterOrEqual(t, stats.TotalConns, int32(1))
+	assert.Equal(t, int32(1), stats.AcquiredConns)
+	assert.GreaterOrEqual(t, stats.AcquireCount, int64(2))
+	assert.Positive(t, stats.AcquireDuration)
+	assert.Equal(t, stats.TotalConns, stats.IdleConns+stats.AcquiredConns)
+}
@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+)
+
+// maxRoomAlertLength caps the characters in a room alert
+const maxRoomAlertLength = 500
+
+var (
+	// ErrNotRoomModerator is returned when someone other than an admin, the room's creator or a moderator changes its alert
+	ErrNotRoomModerator = errors.New("only admins, the room creator and moderators can change the room alert")
+	// ErrRoomAlertEmpty is returned when setting an alert without text
+	ErrRoomAlertEmpty = errors.New("room alert text is required")
+	// ErrRoomAlertTooLong is returned when an alert exceeds maxRoomAlertLength characters
+	ErrRoomAlertTooLong = errors.New("room alert must be at most 500 characters")
+)
+
+// SetRoomAlert shows text as a banner to every member of the named room until
+// it is cleared or replaced. Only admins, the room's creator and its
+// moderators may set it.
+func (h *Hub) SetRoomAlert(client *clientpkg.Client, roomName, text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ErrRoomAlertEmpty
+	}
+	if len([]rune(text)) > maxRoomAlertLength {
+		return ErrRoomAlertTooLong
+	}
+	return h.updateRoomAlert(client, roomName, &room.Alert{Text: text, SetBy: client.Name, SetAt: time.Now()})
+}
+
+// ClearRoomAlert removes the named room's banner; clearing a room without one
+// is not an error
+func (h *Hub) ClearRoomAlert(client *clientpkg.Client, roomName string) error {
+	return h.updateRoomAlert(client, roomName, nil)
+}
+
+// updateRoomAlert stores alert on the room, syncs it to the other servers and
+// tells the room's members
+func (h *Hub) updateRoomAlert(client *clientpkg.Client, roomName string, alert *room.Alert) error {
+	targetRoom, exists := h.GetRoom(roomName)
+
+	if !exists {
+		return ErrRoomNotFound
+	}
+	if !canModerateMessages(client, targetRoom) {
+		return ErrNotRoomModerator
+	}
+
+	targetRoom.SetAlert(alert)
+
+	// Other servers keep the alert for members joining there later
+	if h.NATSEnabled && h.NATS != nil {
+		if err := h.publishRoomSync(roomSyncUpdated, targetRoom); err != nil {
+			log.Printf("Failed to publish room sync to NATS: %v", err)
+		}
+	}
+
+	h.BroadcastToRoom(targetRoom, types.Message{Content: roomAlertFrame(targetRoom.Name, alert), Type: types.MsgTypeRoomAlert})
+	if alert == nil {
+		log.Printf("Alert of room %s cleared by %s conn_id=%s", roomName, client.Name, client.ID)
+	} else {
+		log.Printf("Alert of room %s set by %s conn_id=%s", roomName, client.Name, client.ID)
+	}
+	return nil
+}
+
+// sendRoomAlert sends a client that just joined targetRoom its alert, if any
+func (h *Hub) sendRoomAlert(client *clientpkg.Client, targetRoom *room.Room) {
+	alert := targetRoom.GetAlert()
+	if alert == nil || client.Conn == nil {
+		return
+	}
+	if err := client.WriteMessage(h.Ctx, roomAlertFrame(targetRoom.Name, alert)); err != nil {
+		log.Printf("Failed to send alert of room %s to %s: %v", targetRoom.Name, client.Name, err)
+	}
+}
+
+// roomAlertFrame builds the room_alert frame for alert; nil means cleared
+func roomAlertFrame(roomName string, alert *room.Alert) []byte {
+	dto := types.RoomAlertDTO{Type: types.MsgTypeRoomAlert, Room: roomName}
+	if alert != nil {
+		dto.Alert = &types.AlertDTO{
+			Text:  alert.Text,
+			SetBy: alert.SetBy,
+			SetAt: alert.SetAt.Format(time.RFC3339),
+		}
+	}
+	frame, _ := json.Marshal(dto)
+	return frame
+}
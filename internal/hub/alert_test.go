@@ -0,0 +1,54 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoomAlert(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	alice, alicePeer := newConnectedClient(t, "alice", "user-alice")
+	bob, bobPeer := newConnectedClient(t, "bob", "user-bob")
+	for _, c := range []*client.Client{alice, bob} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	lounge, err := hub.CreateRoomAs(alice, "lounge", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(alice, lounge, ""))
+	require.NoError(t, hub.JoinRoom(bob, lounge, ""))
+
+	// Only admins and the creator may set the alert
+	assert.ErrorIs(t, hub.SetRoomAlert(bob, "lounge", "bob was here"), ErrNotRoomModerator)
+	assert.ErrorIs(t, hub.SetRoomAlert(alice, "missing", "hello"), ErrRoomNotFound)
+	assert.ErrorIs(t, hub.SetRoomAlert(alice, "lounge", "   "), ErrRoomAlertEmpty)
+	assert.Nil(t, lounge.GetAlert())
+
+	require.NoError(t, hub.SetRoomAlert(alice, "lounge", "Be kind"))
+	assert.True(t, readUntil(bobPeer, `"type":"room_alert","room":"lounge","alert":{"text":"Be kind","set_by":"alice"`, 5*time.Second))
+	assert.True(t, readUntil(alicePeer, `"text":"Be kind"`, 5*time.Second))
+
+	// Members joining later see the current alert
+	carol, carolPeer := newConnectedClient(t, "carol", "user-carol")
+	hub.Register <- carol
+	<-carol.Registered
+	require.NoError(t, hub.JoinRoom(carol, lounge, ""))
+	assert.True(t, readUntil(carolPeer, `"text":"Be kind"`, 5*time.Second))
+
+	// An admin may replace or clear it
+	bob.Admin = true
+	require.NoError(t, hub.ClearRoomAlert(bob, "lounge"))
+	assert.True(t, readUntil(carolPeer, `"type":"room_alert","room":"lounge","alert":null`, 5*time.Second))
+	assert.Nil(t, lounge.GetAlert())
+}
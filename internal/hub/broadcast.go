@@ -0,0 +1,116 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/metrics"
+	"websocket-demo/internal/types"
+)
+
+// DefaultMaxBroadcastErrors is the failed-delivery budget of BroadcastToAll when MAX_BROADCAST_ERRORS is unset
+const DefaultMaxBroadcastErrors = 10
+
+// ErrBroadcastFailed is returned when too many clients could not receive a broadcast
+var ErrBroadcastFailed = errors.New("broadcast failed")
+
+// GetMaxBroadcastErrors reads the failed-delivery budget from environment or returns default
+func GetMaxBroadcastErrors() int {
+	if value := os.Getenv("MAX_BROADCAST_ERRORS"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_BROADCAST_ERRORS, using default: %d", DefaultMaxBroadcastErrors)
+	}
+	return DefaultMaxBroadcastErrors
+}
+
+// BroadcastToAll sends a message to every connected client and to every
+// member of every room, at most once per client. Unlike the Broadcast channel
+// it delivers synchronously, writing to all clients in parallel so one slow
+// consumer only costs its own write timeout. The message stays on this
+// server. Returns ErrBroadcastFailed when more than MAX_BROADCAST_ERRORS
+// clients could not be written to.
+func (h *Hub) BroadcastToAll(ctx context.Context, message types.Message) error {
+	delivered := make(map[*clientpkg.Client]bool)
+
+	h.Mutex.RLock()
+	for c := range h.Clients {
+		delivered[c] = true
+	}
+	for _, r := range h.Rooms {
+		for _, c := range r.GetClients() {
+			delivered[c] = true
+		}
+	}
+	h.Mutex.RUnlock()
+
+	var (
+		wg       sync.WaitGroup
+		failures atomic.Int64
+		mu       sync.Mutex
+		dropped  []*clientpkg.Client
+		delivery metrics.DeliveryStats
+	)
+	for c := range delivered {
+		if c.Conn == nil {
+			delivery.Record(metrics.DeliverySkipped)
+			continue
+		}
+		wg.Add(1)
+		go func(c *clientpkg.Client) {
+			defer wg.Done()
+			err := c.WriteMessage(ctx, message.Content)
+			if err == nil {
+				delivery.Record(metrics.DeliveryDelivered)
+				return
+			}
+			failures.Add(1)
+			log.Printf("BroadcastToAll: Error writing to client %s: %v", c.Name, err)
+			delivery.Record(writeFailure(err))
+			mu.Lock()
+			dropped = append(dropped, c)
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	h.Metrics.RecordDeliveries("", delivery.Snapshot())
+
+	// Unregister failed clients
+	for _, c := range dropped {
+		select {
+		case h.Unregister <- c:
+		case <-h.Ctx.Done():
+		}
+	}
+
+	h.Metrics.IncrementMessages()
+	if failed := failures.Load(); failed > int64(h.Config().MaxBroadcastErrors) {
+		return fmt.Errorf("%w: %d of %d clients not reached", ErrBroadcastFailed, failed, len(delivered))
+	}
+	return nil
+}
+
+// writeFailure classifies a failed broadcast write; either way the client is
+// unregistered
+func writeFailure(err error) metrics.DeliveryOutcome {
+	if clientpkg.IsWriteTimeout(err) {
+		return metrics.DeliveryDropped
+	}
+	return metrics.DeliveryFailed
+}
+
+// BroadcastSystemMessage announces a server notice to every local client
+func (h *Hub) BroadcastSystemMessage(ctx context.Context, text string) error {
+	timestamp := time.Now().Format("15:04:05")
+	content := []byte(fmt.Sprintf("[%s] System: %s", timestamp, text))
+	return h.BroadcastToAll(ctx, types.Message{Content: content, Type: types.MsgTypeSystem, Timestamp: time.Now()})
+}
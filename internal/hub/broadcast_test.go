@@ -0,0 +1,238 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/metrics"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcastToAllDeliversOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	red, err := hub.CreateRoom("red", false, "", 100)
+	require.NoError(t, err)
+	blue, err := hub.CreateRoom("blue", false, "", 100)
+	require.NoError(t, err)
+
+	peers := make([]*websocket.Conn, 6)
+	for i := range peers {
+		var c *client.Client
+		c, peers[i] = newConnectedClient(t, fmt.Sprintf("client-%d", i), "")
+		switch i % 3 {
+		case 0:
+			// Registered, in no room
+			hub.Register <- c
+			<-c.Registered
+		case 1:
+			// Registered and a member of both rooms
+			hub.Register <- c
+			<-c.Registered
+			red.AddClient(c)
+			blue.AddClient(c)
+		case 2:
+			// Only known as a room member
+			red.AddClient(c)
+		}
+	}
+
+	require.NoError(t, hub.BroadcastSystemMessage(context.Background(), "maintenance at noon"))
+
+	for i, peer := range peers {
+		readCtx, readCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, data, err := peer.Read(readCtx)
+		readCancel()
+		require.NoError(t, err, "client-%d did not receive the broadcast", i)
+		assert.Contains(t, string(data), "System: maintenance at noon")
+
+		readCtx, readCancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+		_, data, err = peer.Read(readCtx)
+		readCancel()
+		assert.Error(t, err, "client-%d received a second message: %s", i, data)
+	}
+}
+
+func TestBroadcastToAllErrorBudget(t *testing.T) {
+	t.Setenv("MAX_BROADCAST_ERRORS", "1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	for i := 0; i < 2; i++ {
+		c, peer := newConnectedClient(t, fmt.Sprintf("gone-%d", i), "")
+		peer.CloseNow()
+		c.Conn.CloseNow()
+		hub.Clients[c] = true
+	}
+
+	err := hub.BroadcastToAll(context.Background(), types.Message{Content: []byte("hello"), Type: types.MsgTypeSystem})
+	assert.ErrorIs(t, err, ErrBroadcastFailed)
+}
+
+func TestBroadcastDeliveryMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+	ops, err := hub.CreateRoom("ops", false, "", 100)
+	require.NoError(t, err)
+
+	alice, _ := newConnectedClient(t, "alice", "")
+	bob, bobPeer := newConnectedClient(t, "bob", "")
+	dave, _ := newConnectedClient(t, "dave", "")
+	dave.Conn.CloseNow()
+	carol := &client.Client{Name: "carol", Registered: make(chan struct{})}
+	for _, c := range []*client.Client{alice, bob, carol, dave} {
+		ops.AddClient(c)
+	}
+
+	hub.BroadcastToRoom(ops, types.Message{Content: []byte("deploy done"), Type: types.MsgTypeRoomMessage, Sender: alice})
+	assert.True(t, readUntil(bobPeer, "deploy done", 5*time.Second))
+
+	want := metrics.DeliveryStats{Attempted: 4, Delivered: 1, Skipped: 2, Failed: 1}
+	stats := hub.Metrics.GetRoomStats("ops")
+	assert.Equal(t, want, stats.Delivery)
+	assert.Equal(t, stats.Delivery.Attempted, stats.Delivery.Delivered+stats.Delivery.Skipped+stats.Delivery.Failed+stats.Delivery.Dropped)
+	assert.Equal(t, want, hub.Metrics.GetDeliveryStats())
+	assert.Equal(t, 0.5, hub.Metrics.GetSummary()["delivery_ratio"])
+
+	// BroadcastToAll counts into the global counters only
+	other := NewHub(ctx, nil, nil)
+	healthy, _ := newConnectedClient(t, "healthy", "")
+	broken, _ := newConnectedClient(t, "broken", "")
+	broken.Conn.CloseNow()
+	for _, c := range []*client.Client{healthy, broken, {Name: "no-conn"}} {
+		other.Clients[c] = true
+	}
+	require.NoError(t, other.BroadcastToAll(context.Background(), types.Message{Content: []byte("hello"), Type: types.MsgTypeSystem}))
+	assert.Equal(t, metrics.DeliveryStats{Attempted: 3, Delivered: 1, Skipped: 1, Failed: 1}, other.Metrics.GetDeliveryStats())
+	assert.Empty(t, other.Metrics.GetTopRooms(-1))
+}
+
+// benchmarkHub starts a hub with n registered clients whose peers discard everything they receive
+func benchmarkHub(b *testing.B, n int) *Hub {
+	b.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	accepted := make(chan *websocket.Conn, n)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}))
+	b.Cleanup(srv.Close)
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	for i := 0; i < n; i++ {
+		peer, _, err := websocket.Dial(context.Background(), url, nil)
+		require.NoError(b, err)
+		b.Cleanup(func() { peer.CloseNow() })
+		go func() {
+			for {
+				if _, _, err := peer.Read(context.Background()); err != nil {
+					return
+				}
+			}
+		}()
+
+		c := client.NewClient(<-accepted, fmt.Sprintf("bench-%d", i))
+		hub.Register <- c
+		<-c.Registered
+	}
+	return hub
+}
+
+// BenchmarkBroadcastChannel measures global delivery through the Broadcast channel
+func BenchmarkBroadcastChannel(b *testing.B) {
+	hub := benchmarkHub(b, 1000)
+	message := types.Message{Content: []byte("benchmark"), Type: types.MsgTypeSystem}
+	start := hub.Metrics.GetTotalMessages()
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < b.N; i += 10 {
+				hub.Broadcast <- message
+			}
+		}(g)
+	}
+	wg.Wait()
+	for hub.Metrics.GetTotalMessages()-start < int64(b.N) {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// BenchmarkBroadcastToAll measures direct delivery through BroadcastToAll
+func BenchmarkBroadcastToAll(b *testing.B) {
+	hub := benchmarkHub(b, 1000)
+	message := types.Message{Content: []byte("benchmark"), Type: types.MsgTypeSystem}
+
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for g := 0; g < 10; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < b.N; i += 10 {
+				if err := hub.BroadcastToAll(context.Background(), message); err != nil {
+					b.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// BenchmarkBroadcastToLargeRoom compares writing a 5000-client room from the
+// broadcasting goroutine with spreading it over the broadcast workers; the
+// workers only help with more than one core
+func BenchmarkBroadcastToLargeRoom(b *testing.B) {
+	hub := benchmarkHub(b, 5000)
+	large, err := hub.CreateRoom("large", false, "", 5000)
+	require.NoError(b, err)
+	hub.Mutex.RLock()
+	for c := range hub.Clients {
+		large.AddClient(c)
+	}
+	hub.Mutex.RUnlock()
+	message := types.Message{Content: []byte("benchmark"), Type: types.MsgTypeSystem}
+
+	for _, workers := range []int{0, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			hub.broadcastWorkers = nil
+			if workers > 0 {
+				hub.broadcastWorkers = newBroadcastWorkers(hub.Ctx, workers)
+			}
+			for b.Loop() {
+				hub.BroadcastToRoom(large, message)
+			}
+		})
+	}
+}
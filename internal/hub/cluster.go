@@ -0,0 +1,184 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/types"
+)
+
+const (
+	// clusterHeartbeatInterval is how often a server announces itself to its peers
+	clusterHeartbeatInterval = 5 * time.Second
+	// clusterPeerTTL is how long a peer is considered alive without a heartbeat
+	clusterPeerTTL = 3 * clusterHeartbeatInterval
+)
+
+// Version identifies the running build in cluster heartbeats; set it with
+// -ldflags "-X websocket-demo/internal/hub.Version=..."
+var Version = "dev"
+
+// clusterHeartbeat is the payload a server publishes on the heartbeat subject
+type clusterHeartbeat struct {
+	ServerID string `json:"server_id"`
+	Clients  int    `json:"clients"`
+	Rooms    int    `json:"rooms"`
+	Version  string `json:"version"`
+	Leaving  bool   `json:"leaving,omitempty"` // Sent once on shutdown so peers drop the server at once
+}
+
+// PeerInfo describes a server in the cluster as of its last heartbeat
+type PeerInfo struct {
+	ServerID string    `json:"server_id"`
+	Clients  int       `json:"clients"`
+	Rooms    int       `json:"rooms"`
+	Version  string    `json:"version"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// ClusterStats is this server's view of the cluster
+type ClusterStats struct {
+	Self         PeerInfo   `json:"self"`
+	Peers        []PeerInfo `json:"peers"`
+	TotalClients int        `json:"total_clients"`
+}
+
+// clusterTracker holds the last heartbeat of every other server
+type clusterTracker struct {
+	mu    sync.Mutex
+	peers map[string]PeerInfo // server ID -> last heartbeat
+}
+
+func newClusterTracker() *clusterTracker {
+	return &clusterTracker{peers: make(map[string]PeerInfo)}
+}
+
+// update records a heartbeat, reporting whether the peer was not known before
+func (c *clusterTracker) update(hb clusterHeartbeat, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hb.Leaving {
+		delete(c.peers, hb.ServerID)
+		return false
+	}
+	_, known := c.peers[hb.ServerID]
+	c.peers[hb.ServerID] = PeerInfo{
+		ServerID: hb.ServerID,
+		Clients:  hb.Clients,
+		Rooms:    hb.Rooms,
+		Version:  hb.Version,
+		LastSeen: now,
+	}
+	return !known
+}
+
+// alive returns the peers heard from within clusterPeerTTL sorted by server
+// ID, dropping the ones that missed their heartbeats
+func (c *clusterTracker) alive(now time.Time) []PeerInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	peers := make([]PeerInfo, 0, len(c.peers))
+	for id, peer := range c.peers {
+		if now.Sub(peer.LastSeen) >= clusterPeerTTL {
+			delete(c.peers, id)
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ServerID < peers[j].ServerID })
+	return peers
+}
+
+// localHeartbeat describes this server for its peers
+func (h *Hub) localHeartbeat() clusterHeartbeat {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+	return clusterHeartbeat{
+		ServerID: h.NATS.GetServerID(),
+		Clients:  len(h.Clients),
+		Rooms:    len(h.Rooms),
+		Version:  Version,
+	}
+}
+
+// publishClusterHeartbeat announces this server to its peers
+func (h *Hub) publishClusterHeartbeat(leaving bool) {
+	if !h.NATSEnabled || h.NATS == nil {
+		return
+	}
+
+	hb := h.localHeartbeat()
+	hb.Leaving = leaving
+	content, _ := json.Marshal(hb)
+	msg := types.Message{Content: content, Type: types.MsgTypeClusterHeartbeat}
+	if err := h.NATS.Publish(natsclient.SubjectClusterHeartbeat, msg); err != nil {
+		log.Printf("Failed to publish cluster heartbeat: %v", err)
+	}
+}
+
+// handleClusterHeartbeat records a heartbeat from another server and answers
+// newcomers straight away so they don't wait a full interval to see us
+func (h *Hub) handleClusterHeartbeat(msg types.Message) {
+	var hb clusterHeartbeat
+	if err := json.Unmarshal(msg.Content, &hb); err != nil {
+		log.Printf("Failed to unmarshal cluster heartbeat: %v", err)
+		return
+	}
+	if hb.ServerID == "" || hb.ServerID == h.NATS.GetServerID() {
+		return
+	}
+
+	if h.cluster.update(hb, time.Now()) {
+		log.Printf("Cluster peer %s joined (version %s)", hb.ServerID, hb.Version)
+		h.publishClusterHeartbeat(false)
+	}
+}
+
+// runClusterHeartbeat announces this server now and then on every interval
+func (h *Hub) runClusterHeartbeat() {
+	h.publishClusterHeartbeat(false)
+
+	ticker := time.NewTicker(clusterHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-ticker.C:
+			h.publishClusterHeartbeat(false)
+		}
+	}
+}
+
+// ClusterStats returns this server and the peers that are still sending
+// heartbeats; it is nil when NATS is disabled
+func (h *Hub) ClusterStats() *ClusterStats {
+	if !h.NATSEnabled || h.NATS == nil {
+		return nil
+	}
+
+	hb := h.localHeartbeat()
+	now := time.Now()
+	stats := &ClusterStats{
+		Self: PeerInfo{
+			ServerID: hb.ServerID,
+			Clients:  hb.Clients,
+			Rooms:    hb.Rooms,
+			Version:  hb.Version,
+			LastSeen: now,
+		},
+		Peers:        h.cluster.alive(now),
+		TotalClients: hb.Clients,
+	}
+	for _, peer := range stats.Peers {
+		stats.TotalClients += peer.Clients
+	}
+	return stats
+}
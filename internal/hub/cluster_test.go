@@ -0,0 +1,74 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClusterTracker(t *testing.T) {
+	tracker := newClusterTracker()
+	now := time.Now()
+
+	assert.True(t, tracker.update(clusterHeartbeat{ServerID: "server-b", Clients: 4, Rooms: 2, Version: "v2"}, now))
+	assert.True(t, tracker.update(clusterHeartbeat{ServerID: "server-a", Clients: 1, Rooms: 1, Version: "v1"}, now))
+	assert.False(t, tracker.update(clusterHeartbeat{ServerID: "server-b", Clients: 5, Rooms: 2, Version: "v2"}, now))
+
+	peers := tracker.alive(now)
+	require.Len(t, peers, 2)
+	assert.Equal(t, "server-a", peers[0].ServerID)
+	assert.Equal(t, PeerInfo{ServerID: "server-b", Clients: 5, Rooms: 2, Version: "v2", LastSeen: now}, peers[1])
+
+	// A peer that missed its heartbeats expires
+	tracker.update(clusterHeartbeat{ServerID: "server-a", Clients: 1, Rooms: 1, Version: "v1"}, now.Add(clusterPeerTTL))
+	peers = tracker.alive(now.Add(clusterPeerTTL))
+	require.Len(t, peers, 1)
+	assert.Equal(t, "server-a", peers[0].ServerID)
+
+	// A leaving peer drops out at once
+	tracker.update(clusterHeartbeat{ServerID: "server-a", Leaving: true}, now.Add(clusterPeerTTL))
+	assert.Empty(t, tracker.alive(now.Add(clusterPeerTTL)))
+}
+
+func TestClusterHeartbeatMembership(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctxA, cancelA := context.WithCancel(context.Background())
+	defer cancelA()
+	ctxB, cancelB := context.WithCancel(context.Background())
+	defer cancelB()
+
+	hubA := startClusterHub(t, ctxA, srv.ClientURL())
+	_, err := hubA.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	hubB := startClusterHub(t, ctxB, srv.ClientURL())
+
+	// Each server learns about the other without waiting a full interval
+	hasPeer := func(h *Hub, peer *Hub) bool {
+		for _, p := range h.ClusterStats().Peers {
+			if p.ServerID == peer.NATS.GetServerID() {
+				return true
+			}
+		}
+		return false
+	}
+	require.Eventually(t, func() bool { return hasPeer(hubA, hubB) && hasPeer(hubB, hubA) }, 2*time.Second, 10*time.Millisecond)
+
+	stats := hubB.ClusterStats()
+	assert.Equal(t, hubB.NATS.GetServerID(), stats.Self.ServerID)
+	require.Len(t, stats.Peers, 1)
+	assert.Equal(t, 1, stats.Peers[0].Rooms)
+	assert.Equal(t, Version, stats.Peers[0].Version)
+
+	// A server shutting down leaves its peers' view immediately
+	cancelB()
+	<-hubB.Done()
+	require.Eventually(t, func() bool { return !hasPeer(hubA, hubB) }, 2*time.Second, 10*time.Millisecond)
+
+	// Without NATS there is no cluster view
+	assert.Nil(t, NewHub(context.Background(), nil, nil).ClusterStats())
+}
@@ -0,0 +1,365 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/validator"
+)
+
+const (
+	// DefaultMaxRooms is the room limit when MAX_ROOMS is unset; 0 means unlimited
+	DefaultMaxRooms = 0
+	// DefaultMaxRoomsPerUser is each user's room quota when MAX_ROOMS_PER_USER is unset; 0 means unlimited
+	DefaultMaxRoomsPerUser = 0
+	// DefaultMaxClientsPerRoom is the capacity of rooms created without one when MAX_CLIENTS_PER_ROOM is unset
+	DefaultMaxClientsPerRoom = 100
+	// DefaultMaxRoomCapacity is the largest capacity a room may have when MAX_ROOM_CAPACITY is unset
+	DefaultMaxRoomCapacity = 1000
+	// DefaultBroadcastBufferSize is the Broadcast channel capacity when BROADCAST_BUFFER_SIZE is unset
+	DefaultBroadcastBufferSize = 100
+	// DefaultRoomOpTimeout is how long a room operation waits for a free slot when ROOM_OP_TIMEOUT is unset
+	DefaultRoomOpTimeout = 5 * time.Second
+	// DefaultJoinHistorySize is how many recent messages a client gets on joining a room when JOIN_HISTORY_SIZE is unset
+	DefaultJoinHistorySize = 50
+	// MaxJoinHistorySize caps JoinHistorySize so a join can't pull a whole busy room
+	MaxJoinHistorySize = 1000
+)
+
+// ErrMaxRoomsReached is returned when creating a room would exceed MaxRooms
+var ErrMaxRoomsReached = errors.New("room limit reached")
+
+// ErrRoomQuotaReached is returned when a user who already created
+// MaxRoomsPerUser rooms creates another
+var ErrRoomQuotaReached = errors.New("you have created as many rooms as allowed")
+
+// HubConfig holds the hub's tunables. MaxRooms, MaxRoomsPerUser, MaxClientsPerRoom,
+// MaxRoomCapacity, MaxBroadcastErrors, SuppressJoinLeaveDefault, AutoCreateRooms, RoomOpTimeout,
+// JoinHistorySize, MaxConnectionsPerUser, RoomIdleTimeout and the message edit, delete and dedup
+// windows can be changed at runtime with ReloadConfig; the rest size channels and worker pools and only
+// take effect on restart.
+type HubConfig struct {
+	MaxRooms                 int           `json:"max_rooms"`            // Across the cluster; 0 means unlimited and the default room doesn't count
+	MaxRoomsPerUser          int           `json:"max_rooms_per_user"`   // Rooms each user may have created; 0 means unlimited
+	MaxClientsPerRoom        int           `json:"max_clients_per_room"` // Capacity of rooms created without max_clients
+	MaxRoomCapacity          int           `json:"max_room_capacity"`    // Largest max_clients allowed; caps every room except the default room
+	MaxBroadcastErrors       int           `json:"max_broadcast_errors"`
+	SuppressJoinLeaveDefault bool          `json:"suppress_join_leave_default"`
+	AutoCreateRooms          bool          `json:"auto_create_rooms"`        // join_room creates a missing room instead of failing
+	RoomOpTimeout            time.Duration `json:"room_op_timeout"`          // A duration string such as "5s" in JSON
+	JoinHistorySize          int           `json:"join_history_size"`        // Recent messages sent on join; 0 turns it off
+	MessageEditWindow        time.Duration `json:"message_edit_window"`      // How long authors may edit a message; 0 means forever
+	MessageDeleteWindow      time.Duration `json:"message_delete_window"`    // How long authors may delete a message; 0 means forever
+	MessageDedupWindow       time.Duration `json:"message_dedup_window"`     // How long an identical message from the same user is dropped; 0 turns it off
+	MaxConnectionsPerUser    int           `json:"max_connections_per_user"` // Across the cluster; 0 means unlimited
+	RoomIdleTimeout          time.Duration `json:"room_idle_timeout"`        // How long a stored room stays in memory while empty; 0 keeps it forever
+
+	BroadcastBufferSize  int `json:"broadcast_buffer_size"`
+	BroadcastWorkers     int `json:"broadcast_workers"` // Goroutines writing large room broadcasts; 0 writes inline
+	UnregisterWorkers    int `json:"unregister_workers"`
+	MaxConcurrentRoomOps int `json:"max_concurrent_room_ops"`
+}
+
+// MarshalJSON writes the durations as duration strings
+func (c HubConfig) MarshalJSON() ([]byte, error) {
+	type plain HubConfig
+	return json.Marshal(struct {
+		plain
+		RoomOpTimeout       string `json:"room_op_timeout"`
+		MessageEditWindow   string `json:"message_edit_window"`
+		MessageDeleteWindow string `json:"message_delete_window"`
+		MessageDedupWindow  string `json:"message_dedup_window"`
+		RoomIdleTimeout     string `json:"room_idle_timeout"`
+	}{plain(c), c.RoomOpTimeout.String(), c.MessageEditWindow.String(), c.MessageDeleteWindow.String(), c.MessageDedupWindow.String(), c.RoomIdleTimeout.String()})
+}
+
+// UnmarshalJSON reads the durations as duration strings. Fields missing from
+// the JSON keep their current values, so a partial document updates c.
+func (c *HubConfig) UnmarshalJSON(data []byte) error {
+	type plain HubConfig
+	aux := struct {
+		*plain
+		RoomOpTimeout       string `json:"room_op_timeout"`
+		MessageEditWindow   string `json:"message_edit_window"`
+		MessageDeleteWindow string `json:"message_delete_window"`
+		MessageDedupWindow  string `json:"message_dedup_window"`
+		RoomIdleTimeout     string `json:"room_idle_timeout"`
+	}{plain: (*plain)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"room_op_timeout", aux.RoomOpTimeout, &c.RoomOpTimeout},
+		{"message_edit_window", aux.MessageEditWindow, &c.MessageEditWindow},
+		{"message_delete_window", aux.MessageDeleteWindow, &c.MessageDeleteWindow},
+		{"message_dedup_window", aux.MessageDedupWindow, &c.MessageDedupWindow},
+		{"room_idle_timeout", aux.RoomIdleTimeout, &c.RoomIdleTimeout},
+	} {
+		if field.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(field.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", field.name, err)
+		}
+		*field.dst = d
+	}
+	return nil
+}
+
+// Validate reports the first out of range field
+func (c HubConfig) Validate() error {
+	switch {
+	case c.MaxRooms < 0:
+		return errors.New("max_rooms must not be negative")
+	case c.MaxRoomsPerUser < 0:
+		return errors.New("max_rooms_per_user must not be negative")
+	case c.MaxClientsPerRoom < 1:
+		return errors.New("max_clients_per_room must be at least 1")
+	case c.MaxClientsPerRoom > c.MaxRoomCapacity:
+		return errors.New("max_clients_per_room must not exceed max_room_capacity")
+	case c.MaxBroadcastErrors < 0:
+		return errors.New("max_broadcast_errors must not be negative")
+	case c.RoomOpTimeout <= 0:
+		return errors.New("room_op_timeout must be positive")
+	case c.JoinHistorySize < 0 || c.JoinHistorySize > MaxJoinHistorySize:
+		return fmt.Errorf("join_history_size must be between 0 and %d", MaxJoinHistorySize)
+	case c.MessageEditWindow < 0:
+		return errors.New("message_edit_window must not be negative")
+	case c.MessageDeleteWindow < 0:
+		return errors.New("message_delete_window must not be negative")
+	case c.MessageDedupWindow < 0:
+		return errors.New("message_dedup_window must not be negative")
+	case c.MaxConnectionsPerUser < 0:
+		return errors.New("max_connections_per_user must not be negative")
+	case c.RoomIdleTimeout < 0:
+		return errors.New("room_idle_timeout must not be negative")
+	case c.BroadcastBufferSize < 1:
+		return errors.New("broadcast_buffer_size must be at least 1")
+	case c.BroadcastWorkers < 0:
+		return errors.New("broadcast_workers must not be negative")
+	case c.UnregisterWorkers < 1:
+		return errors.New("unregister_workers must be at least 1")
+	case c.MaxConcurrentRoomOps < 1:
+		return errors.New("max_concurrent_room_ops must be at least 1")
+	}
+	return nil
+}
+
+// LoadHubConfig reads the hub configuration from environment, using defaults
+// for unset or invalid values. MaxRoomCapacity is raised to MaxClientsPerRoom
+// when set below it.
+func LoadHubConfig() HubConfig {
+	cfg := HubConfig{
+		MaxRooms:                 GetMaxRooms(),
+		MaxRoomsPerUser:          GetMaxRoomsPerUser(),
+		MaxClientsPerRoom:        GetMaxClientsPerRoom(),
+		MaxRoomCapacity:          GetMaxRoomCapacity(),
+		MaxBroadcastErrors:       GetMaxBroadcastErrors(),
+		SuppressJoinLeaveDefault: GetSuppressJoinLeaveDefault(),
+		AutoCreateRooms:          GetAutoCreateRooms(),
+		RoomOpTimeout:            GetRoomOpTimeout(),
+		JoinHistorySize:          GetJoinHistorySize(),
+		MessageEditWindow:        GetMessageEditWindow(),
+		MessageDeleteWindow:      GetMessageDeleteWindow(),
+		MessageDedupWindow:       GetMessageDedupWindow(),
+		MaxConnectionsPerUser:    GetMaxConnectionsPerUser(),
+		RoomIdleTimeout:          GetRoomIdleTimeout(),
+		BroadcastBufferSize:      GetBroadcastBufferSize(),
+		BroadcastWorkers:         GetBroadcastWorkers(),
+		UnregisterWorkers:        GetUnregisterWorkers(),
+		MaxConcurrentRoomOps:     GetMaxConcurrentRoomOps(),
+	}
+	if cfg.MaxRoomCapacity < cfg.MaxClientsPerRoom {
+		log.Printf("MAX_ROOM_CAPACITY is below MAX_CLIENTS_PER_ROOM, using %d", cfg.MaxClientsPerRoom)
+		cfg.MaxRoomCapacity = cfg.MaxClientsPerRoom
+	}
+	return cfg
+}
+
+// GetMaxRooms reads the room limit from environment or returns default
+func GetMaxRooms() int {
+	if value := os.Getenv("MAX_ROOMS"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_ROOMS, using default: %d", DefaultMaxRooms)
+	}
+	return DefaultMaxRooms
+}
+
+// GetMaxRoomsPerUser reads each user's room quota from environment or returns default
+func GetMaxRoomsPerUser() int {
+	if value := os.Getenv("MAX_ROOMS_PER_USER"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_ROOMS_PER_USER, using default: %d", DefaultMaxRoomsPerUser)
+	}
+	return DefaultMaxRoomsPerUser
+}
+
+// GetMaxClientsPerRoom reads the per-room client limit from environment or returns default
+func GetMaxClientsPerRoom() int {
+	if value := os.Getenv("MAX_CLIENTS_PER_ROOM"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_CLIENTS_PER_ROOM, using default: %d", DefaultMaxClientsPerRoom)
+	}
+	return DefaultMaxClientsPerRoom
+}
+
+// GetMaxRoomCapacity reads the largest room capacity from environment or returns default
+func GetMaxRoomCapacity() int {
+	if value := os.Getenv("MAX_ROOM_CAPACITY"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_ROOM_CAPACITY, using default: %d", DefaultMaxRoomCapacity)
+	}
+	return DefaultMaxRoomCapacity
+}
+
+// GetBroadcastBufferSize reads the Broadcast channel capacity from environment or returns default
+func GetBroadcastBufferSize() int {
+	if value := os.Getenv("BROADCAST_BUFFER_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size > 0 {
+			return size
+		}
+		log.Printf("Invalid BROADCAST_BUFFER_SIZE, using default: %d", DefaultBroadcastBufferSize)
+	}
+	return DefaultBroadcastBufferSize
+}
+
+// GetRoomOpTimeout reads the room operation wait limit from environment or returns default
+func GetRoomOpTimeout() time.Duration {
+	if value := os.Getenv("ROOM_OP_TIMEOUT"); value != "" {
+		if timeout, err := time.ParseDuration(value); err == nil && timeout > 0 {
+			return timeout
+		}
+		log.Printf("Invalid ROOM_OP_TIMEOUT, using default: %s", DefaultRoomOpTimeout)
+	}
+	return DefaultRoomOpTimeout
+}
+
+// GetJoinHistorySize reads how many messages are sent on join from environment or returns default
+func GetJoinHistorySize() int {
+	if value := os.Getenv("JOIN_HISTORY_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size >= 0 && size <= MaxJoinHistorySize {
+			return size
+		}
+		log.Printf("Invalid JOIN_HISTORY_SIZE, using default: %d", DefaultJoinHistorySize)
+	}
+	return DefaultJoinHistorySize
+}
+
+// GetAutoCreateRooms reads whether joining a missing room creates it, defaulting to false
+func GetAutoCreateRooms() bool {
+	if value := os.Getenv("AUTO_CREATE_ROOMS"); value != "" {
+		if autoCreate, err := strconv.ParseBool(value); err == nil {
+			return autoCreate
+		}
+		log.Printf("Invalid AUTO_CREATE_ROOMS, using default: false")
+	}
+	return false
+}
+
+// ConfigChange is one field changed by ReloadConfig
+type ConfigChange struct {
+	Field   string      `json:"field"`
+	Old     interface{} `json:"old"`
+	New     interface{} `json:"new"`
+	Applied bool        `json:"applied"` // False for fields that only take effect on restart
+}
+
+// Config returns the hub's current configuration
+func (h *Hub) Config() HubConfig {
+	return h.config.Load().(HubConfig)
+}
+
+// Validator returns the input validator the hub checks room names and
+// message sizes with
+func (h *Hub) Validator() *validator.Validator {
+	return h.validator.Load()
+}
+
+// SetValidator replaces the hub's input validator, which defaults to
+// validator.Default
+func (h *Hub) SetValidator(v *validator.Validator) {
+	h.validator.Store(v)
+}
+
+// ReloadConfig updates the hub's configuration at runtime and returns the
+// fields that differed. Runtime fields apply from the next operation on;
+// changes to fields that need a restart are logged and left as they were.
+func (h *Hub) ReloadConfig(cfg HubConfig) ([]ConfigChange, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	h.configMutex.Lock()
+	defer h.configMutex.Unlock()
+
+	old := h.Config()
+	var changes []ConfigChange
+	diff := func(field string, before, after interface{}, applied bool) {
+		if before != after {
+			changes = append(changes, ConfigChange{Field: field, Old: before, New: after, Applied: applied})
+		}
+	}
+	diff("max_rooms", old.MaxRooms, cfg.MaxRooms, true)
+	diff("max_rooms_per_user", old.MaxRoomsPerUser, cfg.MaxRoomsPerUser, true)
+	diff("max_clients_per_room", old.MaxClientsPerRoom, cfg.MaxClientsPerRoom, true)
+	diff("max_room_capacity", old.MaxRoomCapacity, cfg.MaxRoomCapacity, true)
+	diff("max_broadcast_errors", old.MaxBroadcastErrors, cfg.MaxBroadcastErrors, true)
+	diff("suppress_join_leave_default", old.SuppressJoinLeaveDefault, cfg.SuppressJoinLeaveDefault, true)
+	diff("auto_create_rooms", old.AutoCreateRooms, cfg.AutoCreateRooms, true)
+	diff("room_op_timeout", old.RoomOpTimeout.String(), cfg.RoomOpTimeout.String(), true)
+	diff("join_history_size", old.JoinHistorySize, cfg.JoinHistorySize, true)
+	diff("message_edit_window", old.MessageEditWindow.String(), cfg.MessageEditWindow.String(), true)
+	diff("message_delete_window", old.MessageDeleteWindow.String(), cfg.MessageDeleteWindow.String(), true)
+	diff("message_dedup_window", old.MessageDedupWindow.String(), cfg.MessageDedupWindow.String(), true)
+	diff("max_connections_per_user", old.MaxConnectionsPerUser, cfg.MaxConnectionsPerUser, true)
+	diff("room_idle_timeout", old.RoomIdleTimeout.String(), cfg.RoomIdleTimeout.String(), true)
+	diff("broadcast_buffer_size", old.BroadcastBufferSize, cfg.BroadcastBufferSize, false)
+	diff("broadcast_workers", old.BroadcastWorkers, cfg.BroadcastWorkers, false)
+	diff("unregister_workers", old.UnregisterWorkers, cfg.UnregisterWorkers, false)
+	diff("max_concurrent_room_ops", old.MaxConcurrentRoomOps, cfg.MaxConcurrentRoomOps, false)
+
+	for _, change := range changes {
+		if change.Applied {
+			log.Printf("Hub config %s changed from %v to %v", change.Field, change.Old, change.New)
+		} else {
+			log.Printf("WARNING: hub config %s cannot change at runtime, keeping %v until restart (requested %v)", change.Field, change.Old, change.New)
+		}
+	}
+
+	// Sizes of existing channels and pools stay as they are
+	cfg.BroadcastBufferSize = old.BroadcastBufferSize
+	cfg.BroadcastWorkers = old.BroadcastWorkers
+	cfg.UnregisterWorkers = old.UnregisterWorkers
+	cfg.MaxConcurrentRoomOps = old.MaxConcurrentRoomOps
+	h.config.Store(cfg)
+	return changes, nil
+}
+
+// roomIsFull reports whether targetRoom has reached MaxRoomCapacity, which
+// applies to rooms created before it was lowered; the room's own limit is
+// enforced by AddClient. Callers must hold h.Mutex.
+func (h *Hub) roomIsFull(targetRoom *room.Room) bool {
+	if h.IsDefaultRoom(targetRoom.Name) {
+		return false
+	}
+	return targetRoom.GetClientCount() >= h.Config().MaxRoomCapacity
+}
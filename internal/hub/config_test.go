@@ -0,0 +1,131 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadHubConfig(t *testing.T) {
+	t.Setenv("MAX_ROOMS", "20")
+	t.Setenv("MAX_CLIENTS_PER_ROOM", "0")
+	t.Setenv("MAX_ROOM_CAPACITY", "500")
+	t.Setenv("BROADCAST_BUFFER_SIZE", "256")
+	t.Setenv("BROADCAST_WORKERS", "4")
+	t.Setenv("ROOM_OP_TIMEOUT", "2s")
+	t.Setenv("AUTO_CREATE_ROOMS", "true")
+
+	cfg := LoadHubConfig()
+	assert.Equal(t, 20, cfg.MaxRooms)
+	assert.Equal(t, DefaultMaxClientsPerRoom, cfg.MaxClientsPerRoom, "invalid values fall back to the default")
+	assert.Equal(t, 500, cfg.MaxRoomCapacity)
+	assert.Equal(t, 256, cfg.BroadcastBufferSize)
+	assert.Equal(t, 4, cfg.BroadcastWorkers)
+	assert.Equal(t, 2*time.Second, cfg.RoomOpTimeout)
+	assert.True(t, cfg.AutoCreateRooms)
+	assert.NoError(t, cfg.Validate())
+
+	hub := NewHub(context.Background(), nil, nil)
+	assert.Equal(t, 256, cap(hub.Broadcast))
+	require.NotNil(t, hub.broadcastWorkers)
+	assert.Len(t, hub.broadcastWorkers.queues, 4)
+}
+
+func TestLoadHubConfigRaisesRoomCapacity(t *testing.T) {
+	t.Setenv("MAX_CLIENTS_PER_ROOM", "200")
+	t.Setenv("MAX_ROOM_CAPACITY", "150")
+
+	cfg := LoadHubConfig()
+	assert.Equal(t, 200, cfg.MaxClientsPerRoom)
+	assert.Equal(t, 200, cfg.MaxRoomCapacity, "the capacity is never below the default room size")
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("MAX_ROOM_CAPACITY", "none")
+	assert.Equal(t, DefaultMaxRoomCapacity, LoadHubConfig().MaxRoomCapacity)
+}
+
+func TestHubConfigJSON(t *testing.T) {
+	cfg := LoadHubConfig()
+	data, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"room_op_timeout":"5s"`)
+	assert.Contains(t, string(data), `"message_edit_window":"15m0s"`)
+	assert.Contains(t, string(data), `"room_idle_timeout":"0s"`)
+
+	// A partial document only changes the fields it names
+	require.NoError(t, json.Unmarshal([]byte(`{"max_clients_per_room": 3, "room_op_timeout": "250ms", "message_delete_window": "0s", "room_idle_timeout": "30m"}`), &cfg))
+	assert.Equal(t, 30*time.Minute, cfg.RoomIdleTimeout)
+	assert.Equal(t, 3, cfg.MaxClientsPerRoom)
+	assert.Equal(t, 250*time.Millisecond, cfg.RoomOpTimeout)
+	assert.Zero(t, cfg.MessageDeleteWindow)
+	assert.Equal(t, DefaultMessageEditWindow, cfg.MessageEditWindow)
+	assert.Equal(t, DefaultBroadcastBufferSize, cfg.BroadcastBufferSize)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"room_op_timeout": "soon"}`), &cfg))
+	assert.Error(t, json.Unmarshal([]byte(`{"message_edit_window": "later"}`), &cfg))
+}
+
+func TestReloadConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lobby, err := hub.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(client.NewClient(nil, "alice"), lobby, ""))
+	require.NoError(t, hub.JoinRoom(client.NewClient(nil, "bob"), lobby, ""))
+
+	// Lowering the room capacity applies to the next join
+	cfg := hub.Config()
+	cfg.MaxClientsPerRoom = 2
+	cfg.MaxRoomCapacity = 2
+	cfg.MaxRooms = 1
+	cfg.BroadcastBufferSize = 1000
+	changes, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []ConfigChange{
+		{Field: "max_rooms", Old: 0, New: 1, Applied: true},
+		{Field: "max_clients_per_room", Old: DefaultMaxClientsPerRoom, New: 2, Applied: true},
+		{Field: "max_room_capacity", Old: DefaultMaxRoomCapacity, New: 2, Applied: true},
+		{Field: "broadcast_buffer_size", Old: DefaultBroadcastBufferSize, New: 1000, Applied: false},
+	}, changes)
+
+	assert.EqualError(t, hub.JoinRoom(client.NewClient(nil, "carol"), lobby, ""), "room is full")
+	assert.Equal(t, 2, lobby.GetClientCount())
+	_, err = hub.CreateRoom("second", false, "", 10)
+	assert.ErrorIs(t, err, ErrMaxRoomsReached)
+
+	// Buffer sizes only change on restart
+	assert.Equal(t, DefaultBroadcastBufferSize, hub.Config().BroadcastBufferSize)
+	assert.Equal(t, DefaultBroadcastBufferSize, cap(hub.Broadcast))
+
+	// Raising it again lets clients in
+	cfg = hub.Config()
+	cfg.MaxRoomCapacity = 3
+	_, err = hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	assert.NoError(t, hub.JoinRoom(client.NewClient(nil, "carol"), lobby, ""))
+
+	// Invalid configs are rejected as a whole
+	cfg.MaxClientsPerRoom = 0
+	cfg.MaxRooms = 5
+	_, err = hub.ReloadConfig(cfg)
+	assert.EqualError(t, err, "max_clients_per_room must be at least 1")
+	cfg.MaxClientsPerRoom = 4
+	_, err = hub.ReloadConfig(cfg)
+	assert.EqualError(t, err, "max_clients_per_room must not exceed max_room_capacity")
+	assert.Equal(t, 1, hub.Config().MaxRooms)
+
+	// Reloading the same config changes nothing
+	changes, err = hub.ReloadConfig(hub.Config())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
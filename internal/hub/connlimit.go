@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+const (
+	// DefaultMaxConnectionsPerUser leaves connections per user unlimited when MAX_CONNECTIONS_PER_USER is unset
+	DefaultMaxConnectionsPerUser = 0
+	// ConnectionLimitReason is the close reason sent to a connection refused by MaxConnectionsPerUser
+	ConnectionLimitReason = "too many connections for this account"
+)
+
+// GetMaxConnectionsPerUser reads the per-user connection limit from environment or returns default
+func GetMaxConnectionsPerUser() int {
+	if value := os.Getenv("MAX_CONNECTIONS_PER_USER"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit >= 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_CONNECTIONS_PER_USER, using default: %d", DefaultMaxConnectionsPerUser)
+	}
+	return DefaultMaxConnectionsPerUser
+}
+
+// UserConnectionCount returns how many connections a user has across the
+// cluster. Other servers' counts come from their presence announcements, so
+// they lag by up to one announcement.
+func (h *Hub) UserConnectionCount(userID string) int {
+	if userID == "" {
+		return 0
+	}
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+	return h.userConnectionCountLocked(userID)
+}
+
+// userConnectionCountLocked does the work of UserConnectionCount; callers
+// must hold h.Mutex
+func (h *Hub) userConnectionCountLocked(userID string) int {
+	return len(h.userSessions[userID]) + h.userPresence.connections(userID)
+}
+
+// ConnectionLimitReached reports whether userID already holds
+// MaxConnectionsPerUser connections. Always false while the limit is 0.
+// Registration enforces the limit itself, so this is only advisory.
+func (h *Hub) ConnectionLimitReached(userID string) bool {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+	return h.connectionLimitReachedLocked(userID)
+}
+
+// connectionLimitReachedLocked does the work of ConnectionLimitReached;
+// callers must hold h.Mutex
+func (h *Hub) connectionLimitReachedLocked(userID string) bool {
+	limit := h.Config().MaxConnectionsPerUser
+	if limit <= 0 || userID == "" {
+		return false
+	}
+	return h.userConnectionCountLocked(userID) >= limit
+}
@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMaxConnectionsPerUser(t *testing.T) {
+	assert.Equal(t, DefaultMaxConnectionsPerUser, GetMaxConnectionsPerUser())
+
+	t.Setenv("MAX_CONNECTIONS_PER_USER", "5")
+	assert.Equal(t, 5, GetMaxConnectionsPerUser())
+	assert.Equal(t, 5, LoadHubConfig().MaxConnectionsPerUser)
+
+	t.Setenv("MAX_CONNECTIONS_PER_USER", "-1")
+	assert.Equal(t, DefaultMaxConnectionsPerUser, GetMaxConnectionsPerUser(), "invalid values fall back to the default")
+}
+
+func TestConnectionLimitReached(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	register := func(name string) {
+		c := client.NewClient(nil, name)
+		c.UserID = "user-alice"
+		hub.Register <- c
+		select {
+		case <-c.Registered:
+		case <-time.After(time.Second):
+			t.Fatal("registration timed out")
+		}
+	}
+	register("alice")
+	register("alice")
+	assert.False(t, hub.ConnectionLimitReached("user-alice"), "no limit by default")
+
+	cfg := hub.Config()
+	cfg.MaxConnectionsPerUser = 3
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	assert.False(t, hub.ConnectionLimitReached("user-alice"))
+	assert.False(t, hub.ConnectionLimitReached(""), "anonymous connections aren't limited")
+
+	// Connections on other servers count toward the limit
+	hub.userPresence.update(userPresenceEvent{UserID: "user-alice", Name: "alice", ServerID: "server-b", Connections: 1})
+	assert.Equal(t, 3, hub.UserConnectionCount("user-alice"))
+	assert.True(t, hub.ConnectionLimitReached("user-alice"))
+	assert.False(t, hub.ConnectionLimitReached("user-bob"))
+
+	// Until that server stops refreshing them
+	hub.userPresence.mu.Lock()
+	hub.userPresence.users["user-alice"]["server-b"] = remoteUser{name: "alice", connections: 1, updatedAt: time.Now().Add(-DefaultPresenceTTL)}
+	hub.userPresence.mu.Unlock()
+	assert.Equal(t, 2, hub.UserConnectionCount("user-alice"))
+	assert.False(t, hub.ConnectionLimitReached("user-alice"))
+
+	cfg.MaxConnectionsPerUser = -1
+	_, err = hub.ReloadConfig(cfg)
+	assert.EqualError(t, err, "max_connections_per_user must not be negative")
+}
+
+func TestRegisterEnforcesConnectionLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	cfg := hub.Config()
+	cfg.MaxConnectionsPerUser = 2
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	go hub.Run()
+
+	// Connections arriving together are counted one at a time
+	clients := make([]*client.Client, 5)
+	for i := range clients {
+		clients[i] = client.NewClient(nil, "alice")
+		clients[i].UserID = "user-alice"
+		go func(c *client.Client) { hub.Register <- c }(clients[i])
+	}
+	refused := 0
+	for _, c := range clients {
+		select {
+		case <-c.Registered:
+		case <-time.After(time.Second):
+			t.Fatal("registration timed out")
+		}
+		if c.Refused {
+			refused++
+		}
+	}
+	assert.Equal(t, 3, refused)
+	assert.Equal(t, 2, hub.UserConnectionCount("user-alice"))
+	hub.Mutex.RLock()
+	assert.Len(t, hub.Clients, 2)
+	hub.Mutex.RUnlock()
+}
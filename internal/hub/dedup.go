@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+)
+
+// DefaultMessageDedupWindow turns duplicate suppression off when MESSAGE_DEDUP_WINDOW is unset
+const DefaultMessageDedupWindow = 0
+
+// GetMessageDedupWindow reads how long an identical message from the same
+// user is suppressed from environment or returns default
+func GetMessageDedupWindow() time.Duration {
+	return getMessageWindow("MESSAGE_DEDUP_WINDOW", DefaultMessageDedupWindow)
+}
+
+// dedupKey identifies a message for duplicate detection
+type dedupKey struct {
+	sender  string // UserID, or the connection ID of anonymous clients
+	room    string // Empty for global chat messages
+	content string // See normalizeMessage
+}
+
+// recentMessages remembers when each user last sent each message
+type recentMessages struct {
+	mu        sync.Mutex
+	sent      map[dedupKey]time.Time
+	lastSweep time.Time
+}
+
+func newRecentMessages() *recentMessages {
+	return &recentMessages{sent: make(map[dedupKey]time.Time)}
+}
+
+// seen reports whether key was recorded less than window ago, recording it
+// now if not. Expired entries are swept at most once per window.
+func (r *recentMessages) seen(key dedupKey, window time.Duration) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if sent, exists := r.sent[key]; exists && now.Sub(sent) < window {
+		return true
+	}
+	if now.Sub(r.lastSweep) >= window {
+		for k, sent := range r.sent {
+			if now.Sub(sent) >= window {
+				delete(r.sent, k)
+			}
+		}
+		r.lastSweep = now
+	}
+	r.sent[key] = now
+	return false
+}
+
+// normalizeMessage ignores case and differences in whitespace, so a resent
+// message matches even if the client trimmed or reflowed it
+func normalizeMessage(content string) string {
+	return strings.ToLower(strings.Join(strings.Fields(content), " "))
+}
+
+// IsDuplicateMessage reports whether client already sent content to roomName
+// (empty for global chat) within MessageDedupWindow. The first copy is
+// recorded, so callers should drop the message and re-send the original's ack
+// only when this returns true. Always false while the window is 0.
+func (h *Hub) IsDuplicateMessage(client *clientpkg.Client, roomName, content string) bool {
+	window := h.Config().MessageDedupWindow
+	if window <= 0 {
+		return false
+	}
+	sender := client.UserID
+	if sender == "" {
+		sender = client.ID
+	}
+	if !h.recentMessages.seen(dedupKey{sender: sender, room: roomName, content: normalizeMessage(content)}, window) {
+		return false
+	}
+	log.Printf("Suppressed duplicate message from %s in %q within %s conn_id=%s", client.Name, roomName, window, client.ID)
+	return true
+}
@@ -0,0 +1,48 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsDuplicateMessage(t *testing.T) {
+	hub := NewHub(context.Background(), nil, nil)
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = "alice-id"
+	aliceAgain := client.NewClient(nil, "alice")
+	aliceAgain.UserID = "alice-id"
+	guest := client.NewClient(nil, "guest")
+
+	assert.False(t, hub.IsDuplicateMessage(alice, "lounge", "hi"))
+	assert.False(t, hub.IsDuplicateMessage(alice, "lounge", "hi"), "off while the window is 0")
+
+	cfg := hub.Config()
+	cfg.MessageDedupWindow = 50 * time.Millisecond
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	assert.False(t, hub.IsDuplicateMessage(alice, "lounge", "Hello  there"))
+	assert.True(t, hub.IsDuplicateMessage(alice, "lounge", " hello there "), "case and whitespace are ignored")
+	assert.True(t, hub.IsDuplicateMessage(aliceAgain, "lounge", "hello there"), "keyed on the user, not the connection")
+	assert.False(t, hub.IsDuplicateMessage(alice, "attic", "hello there"), "other rooms are separate")
+	assert.False(t, hub.IsDuplicateMessage(guest, "lounge", "hello there"), "other users are separate")
+	assert.False(t, hub.IsDuplicateMessage(alice, "lounge", "hello again"))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, hub.IsDuplicateMessage(alice, "lounge", "hello there"), "the window has passed")
+	assert.True(t, hub.IsDuplicateMessage(alice, "lounge", "hello there"))
+}
+
+func TestRecentMessagesSweepsExpired(t *testing.T) {
+	recent := newRecentMessages()
+	recent.seen(dedupKey{sender: "a", content: "old"}, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	recent.seen(dedupKey{sender: "a", content: "new"}, time.Millisecond)
+	assert.Len(t, recent.sent, 1)
+}
@@ -0,0 +1,88 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/room"
+)
+
+const (
+	// DefaultRoomName is the protected fallback room when DEFAULT_ROOM_NAME is unset
+	DefaultRoomName = "default"
+	// defaultRoomMaxClients leaves room for every client evicted from a deleted room
+	defaultRoomMaxClients = 10000
+)
+
+// ErrDefaultRoomProtected is returned when deleting or renaming the default room
+var ErrDefaultRoomProtected = errors.New("the default room cannot be deleted or renamed")
+
+// GetDefaultRoomName reads the default room name from environment or returns default
+func GetDefaultRoomName() string {
+	if value := strings.TrimSpace(os.Getenv("DEFAULT_ROOM_NAME")); value != "" {
+		if len(value) <= 50 {
+			return value
+		}
+		log.Printf("Invalid DEFAULT_ROOM_NAME, using default: %s", DefaultRoomName)
+	}
+	return DefaultRoomName
+}
+
+// IsDefaultRoom reports whether name refers to the protected default room
+func (h *Hub) IsDefaultRoom(name string) bool {
+	return strings.EqualFold(strings.TrimSpace(name), h.defaultRoomName)
+}
+
+// GetDefaultRoom returns the default room, creating it exactly once unless it
+// already exists, e.g. after being loaded from the database. Later calls
+// return the cached room without a map lookup.
+func (h *Hub) GetDefaultRoom() (*room.Room, error) {
+	h.defaultRoomMutex.Lock()
+	defer h.defaultRoomMutex.Unlock()
+
+	if h.defaultRoom != nil {
+		return h.defaultRoom, nil
+	}
+
+	h.Mutex.RLock()
+	existing, exists := h.Rooms[h.defaultRoomName]
+	h.Mutex.RUnlock()
+	if !exists {
+		created, err := h.createRoom(nil, h.defaultRoomName, false, "", defaultRoomMaxClients)
+		switch {
+		case err == nil:
+			existing = created
+		case errors.Is(err, repository.ErrRoomExists):
+			// Stored by another server; createRoom adopted its row
+			if existing, exists = h.GetRoom(h.defaultRoomName); !exists {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	existing.Mutex.Lock()
+	existing.MaxClients = defaultRoomMaxClients
+	existing.Mutex.Unlock()
+	h.defaultRoom = existing
+	return existing, nil
+}
+
+// moveToDefaultRoom lands clients evicted from a deleted room in the default room
+func (h *Hub) moveToDefaultRoom(clients []*clientpkg.Client) {
+	fallback, err := h.GetDefaultRoom()
+	if err != nil {
+		log.Printf("Failed to get the default room: %v", err)
+		return
+	}
+	for _, c := range clients {
+		if err := h.joinRoom(c, fallback, ""); err != nil {
+			log.Printf("Failed to move %s to the default room: %v", c.Name, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultRoomRestoreWindow is how long a deleted room can be restored when ROOM_RESTORE_WINDOW is unset
+	DefaultRoomRestoreWindow = 7 * 24 * time.Hour
+	// deletedRoomPurgeInterval is how often rooms past the restore window are purged
+	deletedRoomPurgeInterval = time.Hour
+)
+
+var (
+	ErrRestoreNotAllowed    = errors.New("only admins can restore rooms")
+	ErrDeletedRoomNotFound  = errors.New("no deleted room with that name")
+	ErrRestoreWindowExpired = errors.New("room was deleted too long ago to restore")
+)
+
+// GetRoomRestoreWindow reads how long deleted rooms can be restored before
+// they are purged from environment or returns default; 0 keeps them forever
+func GetRoomRestoreWindow() time.Duration {
+	return getMessageWindow("ROOM_RESTORE_WINDOW", DefaultRoomRestoreWindow)
+}
+
+// RoomRestoreWindow returns how long deleted rooms can be restored; 0 means forever
+func (h *Hub) RoomRestoreWindow() time.Duration {
+	return h.roomRestoreWindow
+}
+
+// softDeleteRoom marks a room deleted in the database so it stays gone after
+// a restart while its messages are kept for admins
+func (h *Hub) softDeleteRoom(targetRoom *room.Room) error {
+	if h.Repo == nil {
+		return nil
+	}
+	var roomID pgtype.UUID
+	if err := roomID.Scan(targetRoom.GetID()); err != nil {
+		return nil // Never stored
+	}
+	if _, err := h.Repo.SoftDeleteRoom(context.Background(), roomID); err != nil {
+		return fmt.Errorf("failed to delete room: %w", err)
+	}
+	return nil
+}
+
+// RestoreRoom brings back the most recently deleted room named roomName with
+// its messages, if it was deleted within RoomRestoreWindow. Only admins may
+// restore rooms, and only while no other room has taken the name.
+func (h *Hub) RestoreRoom(client *clientpkg.Client, roomName string) (*room.Room, error) {
+	if !client.Admin {
+		return nil, ErrRestoreNotAllowed
+	}
+	if h.Repo == nil {
+		return nil, ErrDeletedRoomNotFound
+	}
+	if err := h.acquireRoomOp(); err != nil {
+		return nil, err
+	}
+	defer h.releaseRoomOp()
+
+	if _, exists := h.GetRoom(roomName); exists {
+		return nil, repository.ErrRoomExists
+	}
+
+	ctx := context.Background()
+	deleted, err := h.Repo.GetDeletedRoomByName(ctx, roomName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeletedRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if h.roomRestoreWindow > 0 && time.Since(deleted.DeletedAt.Time) > h.roomRestoreWindow {
+		return nil, ErrRestoreWindowExpired
+	}
+	dbRoom, err := h.Repo.RestoreRoom(ctx, deleted.ID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeletedRoomNotFound // Restored or purged concurrently
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	restored := h.roomFromDB(dbRoom)
+	h.Mutex.Lock()
+	if _, exists := h.Rooms[roomName]; !exists {
+		h.Rooms[roomName] = restored
+	}
+	h.Mutex.Unlock()
+
+	if h.NATSEnabled && h.NATS != nil {
+		if err := h.publishRoomSync(roomSyncCreated, restored); err != nil {
+			log.Printf("Failed to publish room restore to NATS: %v", err)
+		}
+	}
+
+	timestamp := time.Now().Format("15:04:05")
+	restoreMsg := []byte(fmt.Sprintf("[%s] Room '%s' has been restored by %s", timestamp, roomName, client.Name))
+	select {
+	case h.Broadcast <- types.Message{Content: restoreMsg, Type: types.MsgTypeRestoreRoom}:
+	case <-h.Ctx.Done():
+	}
+	log.Printf("Room %s restored by %s conn_id=%s", roomName, client.Name, client.ID)
+	return restored, nil
+}
+
+// runDeletedRoomPurge purges rooms past the restore window at startup and
+// then every deletedRoomPurgeInterval
+func (h *Hub) runDeletedRoomPurge() {
+	ticker := time.NewTicker(deletedRoomPurgeInterval)
+	defer ticker.Stop()
+
+	h.purgeDeletedRooms(h.Ctx)
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-ticker.C:
+			h.purgeDeletedRooms(h.Ctx)
+		}
+	}
+}
+
+// purgeDeletedRooms permanently deletes rooms deleted more than
+// RoomRestoreWindow ago, with their messages
+func (h *Hub) purgeDeletedRooms(ctx context.Context) {
+	purged, err := h.Repo.PurgeDeletedRooms(ctx, time.Now().Add(-h.roomRestoreWindow))
+	if err != nil {
+		log.Printf("Failed to purge deleted rooms: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d rooms deleted more than %s ago", purged, h.roomRestoreWindow)
+	}
+}
@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRoomRestoreWindow(t *testing.T) {
+	assert.Equal(t, DefaultRoomRestoreWindow, GetRoomRestoreWindow())
+	t.Setenv("ROOM_RESTORE_WINDOW", "48h")
+	assert.Equal(t, 48*time.Hour, GetRoomRestoreWindow())
+	t.Setenv("ROOM_RESTORE_WINDOW", "soon")
+	assert.Equal(t, DefaultRoomRestoreWindow, GetRoomRestoreWindow(), "invalid values fall back to the default")
+}
+
+func TestDeleteAndRestoreRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	owner, err := store.CreateUser(ctx, "owner", "owner@example.com", "hash")
+	require.NoError(t, err)
+	creator, _ := newConnectedClient(t, "owner", uuid.UUID(owner.ID.Bytes).String())
+	_, err = hub.CreateRoomAs(creator, "attic", false, "", 10)
+	require.NoError(t, err)
+	attic, err := store.GetRoomByName(ctx, "attic")
+	require.NoError(t, err)
+	_, err = store.CreateMessage(ctx, attic.ID, owner.ID, "old news")
+	require.NoError(t, err)
+
+	require.NoError(t, hub.DeleteRoom(creator, "attic"))
+	_, exists := hub.GetRoom("attic")
+	assert.False(t, exists)
+
+	// The room stays gone after a restart, but its messages are kept
+	restarted := NewHub(ctx, store, nil)
+	restarted.LoadRoomsFromDB()
+	_, exists = restarted.GetRoom("attic")
+	assert.False(t, exists)
+	count, err := store.CountMessagesByRoom(ctx, attic.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// Only admins may restore, and only rooms that were deleted
+	_, err = hub.RestoreRoom(creator, "attic")
+	assert.ErrorIs(t, err, ErrRestoreNotAllowed)
+	admin, adminPeer := newConnectedClient(t, "admin", "user-admin")
+	admin.Admin = true
+	hub.Register <- admin
+	<-admin.Registered
+	_, err = hub.RestoreRoom(admin, "cellar")
+	assert.ErrorIs(t, err, ErrDeletedRoomNotFound)
+
+	// A new room with the name blocks the restore until it is gone
+	reused, err := hub.CreateRoomAs(creator, "attic", false, "", 10)
+	require.NoError(t, err)
+	_, err = hub.RestoreRoom(admin, "attic")
+	assert.ErrorIs(t, err, repository.ErrRoomExists)
+	require.NoError(t, hub.DeleteRoom(creator, "attic"))
+
+	restored, err := hub.RestoreRoom(admin, "attic")
+	require.NoError(t, err)
+	assert.Equal(t, reused.ID, restored.ID, "the most recently deleted room comes back")
+	assert.True(t, readUntil(adminPeer, "has been restored by admin", 5*time.Second))
+	current, exists := hub.GetRoom("attic")
+	require.True(t, exists)
+	assert.Same(t, restored, current)
+	_, err = store.GetRoomByName(ctx, "attic")
+	assert.NoError(t, err)
+}
+
+func TestRestoreRoomWindow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	owner, err := store.CreateUser(ctx, "owner", "owner@example.com", "hash")
+	require.NoError(t, err)
+	creator, _ := newConnectedClient(t, "owner", uuid.UUID(owner.ID.Bytes).String())
+	_, err = hub.CreateRoomAs(creator, "attic", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.DeleteRoom(creator, "attic"))
+
+	// Pretend the restore window has already passed
+	hub.roomRestoreWindow = time.Nanosecond
+	admin, _ := newConnectedClient(t, "admin", "user-admin")
+	admin.Admin = true
+	_, err = hub.RestoreRoom(admin, "attic")
+	assert.ErrorIs(t, err, ErrRestoreWindowExpired)
+
+	// The janitor then removes it for good
+	hub.purgeDeletedRooms(ctx)
+	_, err = store.GetDeletedRoomByName(ctx, "attic")
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
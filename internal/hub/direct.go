@@ -0,0 +1,179 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/types"
+
+	"github.com/nats-io/nats.go"
+)
+
+// directMessageTimeout is how long a sender waits for another server to confirm delivery
+const directMessageTimeout = 2 * time.Second
+
+var (
+	// ErrDirectMessageUnauthenticated is returned when an anonymous client sends a direct message
+	ErrDirectMessageUnauthenticated = errors.New("direct messages require an authenticated user")
+	// ErrDirectMessageInvalid is returned when the recipient or content is missing
+	ErrDirectMessageInvalid = errors.New("direct message needs a recipient and content")
+)
+
+// SendDirectMessage delivers a message to every session of the recipient, on
+// this server and on any other server, and reports whether at least one
+// session received it
+func (h *Hub) SendDirectMessage(sender *clientpkg.Client, recipientID, content string) (bool, error) {
+	if sender.UserID == "" {
+		return false, ErrDirectMessageUnauthenticated
+	}
+	if recipientID == "" || content == "" {
+		return false, ErrDirectMessageInvalid
+	}
+	content, err := h.FilterMessage(sender, nil, content)
+	if err != nil {
+		return false, err
+	}
+
+	payload, err := json.Marshal(types.ChatMessage{
+		Type:      types.MsgTypeDirectMessage,
+		Timestamp: time.Now().Format("15:04:05"),
+		Sender:    sender.Name,
+		SenderID:  sender.UserID,
+		Content:   content,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal direct message: %w", err)
+	}
+
+	return h.sendToUser(natsclient.UserDMSubject(recipientID), recipientID, types.Message{
+		Content:    payload,
+		Type:       types.MsgTypeDirectMessage,
+		SenderID:   sender.UserID,
+		SenderName: sender.Name,
+		Timestamp:  time.Now(),
+	}), nil
+}
+
+// sendToUser delivers msg's content to every session of a user, on this
+// server and, through subject, on any other server, and reports whether at
+// least one session received it
+func (h *Hub) sendToUser(subject, userID string, msg types.Message) bool {
+	delivered := h.deliverToUser(userID, msg.Content)
+	if !h.NATSEnabled || h.NATS == nil {
+		return delivered
+	}
+
+	// The user may also be connected to other servers. Once delivery is
+	// confirmed locally there is nothing to wait for; otherwise wait for a
+	// server holding one of the user's sessions to confirm.
+	if delivered {
+		if err := h.NATS.Publish(subject, msg); err != nil {
+			log.Printf("Failed to publish %s to NATS: %v", msg.Type, err)
+		}
+		return true
+	}
+
+	delivered, err := h.NATS.Request(subject, msg, directMessageTimeout)
+	if err != nil {
+		log.Printf("Failed to send %s over NATS: %v", msg.Type, err)
+		return false
+	}
+	return delivered
+}
+
+// deliverToUser writes a payload to every local session of a user and reports whether any write succeeded
+func (h *Hub) deliverToUser(userID string, payload []byte) bool {
+	h.Mutex.RLock()
+	sessions := make([]*clientpkg.Client, 0, len(h.userSessions[userID]))
+	for c := range h.userSessions[userID] {
+		sessions = append(sessions, c)
+	}
+	h.Mutex.RUnlock()
+
+	delivered := false
+	for _, c := range sessions {
+		if err := c.WriteMessage(context.Background(), payload); err != nil {
+			log.Printf("Failed to deliver message to %s: %v", c.Name, err)
+			continue
+		}
+		delivered = true
+	}
+	return delivered
+}
+
+// ensureUserSubscription subscribes to a locally connected user's direct
+// message subject and to their user subject, which carries invites and
+// requests to disconnect the user
+func (h *Hub) ensureUserSubscription(userID string) {
+	h.userSubsMutex.Lock()
+	defer h.userSubsMutex.Unlock()
+
+	if subs, exists := h.userSubs[userID]; exists && subscriptionsValid(subs) {
+		return
+	}
+	for _, sub := range h.userSubs[userID] {
+		sub.Unsubscribe()
+	}
+	delete(h.userSubs, userID)
+
+	handler := func(msg types.Message) bool {
+		// This server already delivered its own messages locally
+		if msg.ServerID != "" && msg.ServerID == h.NATS.GetServerID() {
+			return false
+		}
+		if msg.Type == types.MsgTypeDisconnectUser {
+			h.disconnectLocalUser(userID, string(msg.Content))
+			return false
+		}
+		return h.deliverToUser(userID, msg.Content)
+	}
+	subs := make([]*nats.Subscription, 0, 2)
+	for _, subject := range []string{natsclient.UserSubject(userID), natsclient.UserDMSubject(userID)} {
+		sub, err := h.NATS.SubscribeRequests(subject, handler)
+		if err != nil {
+			log.Printf("Failed to subscribe to %s for user %s: %v", subject, userID, err)
+			for _, sub := range subs {
+				sub.Unsubscribe()
+			}
+			return
+		}
+		subs = append(subs, sub)
+	}
+	h.userSubs[userID] = subs
+}
+
+// subscriptionsValid reports whether every subscription is still active
+func subscriptionsValid(subs []*nats.Subscription) bool {
+	for _, sub := range subs {
+		if !sub.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// removeUserSubscription drops a user's direct message subscription once
+// their last local session is gone
+func (h *Hub) removeUserSubscription(userID string) {
+	h.userSubsMutex.Lock()
+	defer h.userSubsMutex.Unlock()
+
+	// The user may have reconnected since their last session was removed
+	h.Mutex.RLock()
+	connected := len(h.userSessions[userID]) > 0
+	h.Mutex.RUnlock()
+	if connected {
+		return
+	}
+
+	for _, sub := range h.userSubs[userID] {
+		sub.Unsubscribe()
+	}
+	delete(h.userSubs, userID)
+}
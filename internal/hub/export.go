@@ -0,0 +1,185 @@
+package hub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// exportChunkSize is how many messages go in one export_chunk frame
+	exportChunkSize = 100
+	// exportCooldown is how long after an export before the room can be exported again
+	exportCooldown = 10 * time.Minute
+	// exportAsyncThreshold is the message count above which an export is
+	// streamed from its own goroutine instead of the client's read loop
+	exportAsyncThreshold = 100000
+)
+
+var (
+	ErrExportUnavailable = errors.New("exporting rooms needs a database")
+	ErrExportNotAllowed  = errors.New("only the room creator or an admin can export a room")
+	ErrExportRateLimited = errors.New("this room was exported recently")
+)
+
+// exportStore is the subset of the repository used to export rooms
+type exportStore interface {
+	CountMessagesByRoom(ctx context.Context, roomID pgtype.UUID) (int64, error)
+	ListRoomExportPage(ctx context.Context, roomID pgtype.UUID, beforeCreatedAt time.Time, beforeID pgtype.UUID, pageSize int32) ([]db.ListRoomExportPageRow, error)
+}
+
+// exportTracker remembers when each room was last exported
+type exportTracker struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newExportTracker() *exportTracker {
+	return &exportTracker{last: make(map[string]time.Time)}
+}
+
+// reserve records an export of roomName now, or returns how long until the
+// room may be exported again
+func (t *exportTracker) reserve(roomName string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if last, exists := t.last[roomName]; exists {
+		if remaining := exportCooldown - now.Sub(last); remaining > 0 {
+			return remaining, false
+		}
+	}
+	for name, last := range t.last {
+		if now.Sub(last) >= exportCooldown {
+			delete(t.last, name)
+		}
+	}
+	t.last[roomName] = now
+	return 0, true
+}
+
+// ExportRoom sends client every stored message of roomName as gzipped
+// export_chunk binary frames of exportChunkSize messages, newest first,
+// followed by an export_complete text frame. Only the room's creator and
+// admins may export, and each room at most once per exportCooldown. Rooms with
+// more than exportAsyncThreshold messages are sent from a goroutine so the
+// client's other messages keep being handled.
+func (h *Hub) ExportRoom(client *clientpkg.Client, roomName string) error {
+	if h.exports == nil {
+		return ErrExportUnavailable
+	}
+
+	targetRoom, exists := h.GetRoom(roomName)
+	if !exists {
+		return ErrRoomNotFound
+	}
+	if !client.Admin && !targetRoom.IsCreator(client) {
+		return ErrExportNotAllowed
+	}
+	var roomID pgtype.UUID
+	if err := roomID.Scan(targetRoom.GetID()); err != nil {
+		return ErrExportUnavailable
+	}
+
+	total, err := h.exports.CountMessagesByRoom(h.Ctx, roomID)
+	if err != nil {
+		return fmt.Errorf("failed to count messages: %w", err)
+	}
+	if remaining, ok := h.exportTimes.reserve(roomName); !ok {
+		return fmt.Errorf("%w, try again in %s", ErrExportRateLimited, remaining.Round(time.Second))
+	}
+
+	totalChunks := int((total + exportChunkSize - 1) / exportChunkSize)
+	log.Printf("Exporting %d messages of room %s in %d chunks conn_id=%s", total, roomName, totalChunks, client.ID)
+	if total > exportAsyncThreshold {
+		go func() {
+			if err := h.streamExport(client, targetRoom, roomID, totalChunks); err != nil {
+				log.Printf("Failed to export room %s conn_id=%s: %v", roomName, client.ID, err)
+			}
+		}()
+		return nil
+	}
+	return h.streamExport(client, targetRoom, roomID, totalChunks)
+}
+
+// streamExport pages through a room's messages, writing each page to client
+// as a gzipped chunk, then writes export_complete. Pages continue from the
+// last message sent rather than an offset, so each page costs the same and
+// messages posted or deleted meanwhile don't shift rows between pages.
+func (h *Hub) streamExport(client *clientpkg.Client, targetRoom *room.Room, roomID pgtype.UUID, totalChunks int) error {
+	sentChunks, sentMessages := 0, 0
+	var beforeCreatedAt time.Time
+	var beforeID pgtype.UUID
+	for index := 0; index < totalChunks; index++ {
+		rows, err := h.exports.ListRoomExportPage(h.Ctx, roomID, beforeCreatedAt, beforeID, exportChunkSize)
+		if err != nil {
+			return fmt.Errorf("failed to load messages: %w", err)
+		}
+		if len(rows) == 0 {
+			break // Messages were deleted since counting
+		}
+		last := rows[len(rows)-1]
+		beforeCreatedAt, beforeID = last.CreatedAt.Time, last.ID
+
+		messages := make([]types.ExportMessageDTO, len(rows))
+		for i, row := range rows {
+			messages[i] = types.ExportMessageDTO{
+				MessageID: uuid.UUID(row.ID.Bytes).String(),
+				Username:  row.Username,
+				Content:   row.Content,
+				Timestamp: row.CreatedAt.Time.Format(time.RFC3339),
+			}
+		}
+		chunk, err := gzipJSON(types.ExportChunkDTO{
+			Type:        types.MsgTypeExportChunk,
+			Room:        targetRoom.Name,
+			ChunkIndex:  index,
+			TotalChunks: totalChunks,
+			Messages:    messages,
+		})
+		if err != nil {
+			return err
+		}
+		if err := client.WriteBinary(h.Ctx, chunk); err != nil {
+			return err
+		}
+		sentChunks++
+		sentMessages += len(messages)
+	}
+
+	complete, _ := json.Marshal(types.ExportCompleteDTO{
+		Type:          types.MsgTypeExportComplete,
+		Room:          targetRoom.Name,
+		TotalChunks:   sentChunks,
+		TotalMessages: sentMessages,
+	})
+	return client.WriteMessage(h.Ctx, complete)
+}
+
+// gzipJSON returns v encoded as gzip-compressed JSON
+func gzipJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(v); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+package hub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readExport reads export_chunk frames from conn until export_complete
+func readExport(t *testing.T, conn *websocket.Conn) ([]types.ExportChunkDTO, types.ExportCompleteDTO) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var chunks []types.ExportChunkDTO
+	for {
+		typ, data, err := conn.Read(ctx)
+		require.NoError(t, err)
+		if typ == websocket.MessageBinary {
+			zr, err := gzip.NewReader(bytes.NewReader(data))
+			require.NoError(t, err)
+			var chunk types.ExportChunkDTO
+			require.NoError(t, json.NewDecoder(zr).Decode(&chunk))
+			chunks = append(chunks, chunk)
+			continue
+		}
+		var complete types.ExportCompleteDTO
+		if json.Unmarshal(data, &complete) == nil && complete.Type == types.MsgTypeExportComplete {
+			return chunks, complete
+		}
+	}
+}
+
+func TestExportRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, clients := newMessageRoom(t, ctx)
+	for i := 0; i < 250; i++ {
+		mr.post(t, "alice", fmt.Sprintf("message %d", i), time.Duration(250-i)*time.Second)
+	}
+
+	assert.ErrorIs(t, mr.hub.ExportRoom(clients["alice"], "lounge"), ErrExportNotAllowed)
+	assert.ErrorIs(t, mr.hub.ExportRoom(clients["carol"], "attic"), ErrRoomNotFound)
+
+	exported := make(chan error, 1)
+	go func() { exported <- mr.hub.ExportRoom(clients["carol"], "lounge") }()
+	chunks, complete := readExport(t, mr.conns["carol"])
+	require.NoError(t, <-exported)
+
+	require.Len(t, chunks, 3)
+	seen := map[string]bool{}
+	for i, chunk := range chunks {
+		assert.Equal(t, i, chunk.ChunkIndex)
+		assert.Equal(t, 3, chunk.TotalChunks)
+		assert.Equal(t, "lounge", chunk.Room)
+		for _, msg := range chunk.Messages {
+			seen[msg.MessageID] = true
+		}
+	}
+	assert.Len(t, chunks[2].Messages, 50)
+	assert.Len(t, seen, 250)
+	assert.Equal(t, "message 249", chunks[0].Messages[0].Content, "newest first")
+	assert.Equal(t, types.ExportCompleteDTO{Type: types.MsgTypeExportComplete, Room: "lounge", TotalChunks: 3, TotalMessages: 250}, complete)
+
+	// One export per room per cooldown, whoever asks
+	clients["alice"].Admin = true
+	assert.ErrorIs(t, mr.hub.ExportRoom(clients["alice"], "lounge"), ErrExportRateLimited)
+}
@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	clientpkg "websocket-demo/internal/client"
+)
+
+// DefaultBroadcastWorkers is the number of room broadcast workers when
+// BROADCAST_WORKERS is unset; 0 writes from the broadcasting goroutine
+const DefaultBroadcastWorkers = 0
+
+// minShardedRecipients is the smallest room handed to the broadcast workers;
+// smaller rooms are written inline since the hand-off costs more than it saves
+const minShardedRecipients = 64
+
+// GetBroadcastWorkers reads the room broadcast worker count from environment or returns default
+func GetBroadcastWorkers() int {
+	if value := os.Getenv("BROADCAST_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers >= 0 {
+			return workers
+		}
+		log.Printf("Invalid BROADCAST_WORKERS, using default: %d", DefaultBroadcastWorkers)
+	}
+	return DefaultBroadcastWorkers
+}
+
+// broadcastWorkers writes a room broadcast from several goroutines. Clients
+// are partitioned by a hash of their connection ID, so each client is always
+// written by the same worker and sees messages in the order they were sent.
+type broadcastWorkers struct {
+	queues []chan func()
+}
+
+// newBroadcastWorkers starts n workers that run until ctx is done
+func newBroadcastWorkers(ctx context.Context, n int) *broadcastWorkers {
+	w := &broadcastWorkers{queues: make([]chan func(), n)}
+	for i := range w.queues {
+		// Unbuffered, so a send only succeeds while the worker is still running
+		w.queues[i] = make(chan func())
+		go w.run(ctx, w.queues[i])
+	}
+	return w
+}
+
+func (w *broadcastWorkers) run(ctx context.Context, queue chan func()) {
+	for {
+		select {
+		case job := <-queue:
+			job()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// shard returns the worker that writes to c
+func (w *broadcastWorkers) shard(c *clientpkg.Client) int {
+	h := fnv.New32a()
+	h.Write([]byte(c.ID))
+	return int(h.Sum32() % uint32(len(w.queues)))
+}
+
+// fanOut calls write for every client, spread over the workers, and returns
+// once all writes are done. write must be safe to call concurrently. After
+// ctx is done the remaining writes run on the calling goroutine.
+func (w *broadcastWorkers) fanOut(ctx context.Context, clients []*clientpkg.Client, write func(*clientpkg.Client)) {
+	if w == nil || len(clients) < minShardedRecipients {
+		for _, c := range clients {
+			write(c)
+		}
+		return
+	}
+
+	shards := make([][]*clientpkg.Client, len(w.queues))
+	for _, c := range clients {
+		i := w.shard(c)
+		shards[i] = append(shards[i], c)
+	}
+
+	var wg sync.WaitGroup
+	for i, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		wg.Add(1)
+		job := func() {
+			defer wg.Done()
+			for _, c := range shard {
+				write(c)
+			}
+		}
+		select {
+		case w.queues[i] <- job:
+		case <-ctx.Done():
+			job()
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,86 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcastWorkersFanOut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	workers := newBroadcastWorkers(ctx, 4)
+	clients := make([]*client.Client, 200)
+	for i := range clients {
+		clients[i] = client.NewClient(nil, fmt.Sprintf("client-%d", i))
+	}
+
+	// Every client is written once per message, in order, and always by
+	// the same worker
+	var mu sync.Mutex
+	received := make(map[*client.Client][]int)
+	for msg := 0; msg < 3; msg++ {
+		workers.fanOut(ctx, clients, func(c *client.Client) {
+			mu.Lock()
+			defer mu.Unlock()
+			received[c] = append(received[c], msg)
+		})
+	}
+	require.Len(t, received, len(clients))
+	for _, c := range clients {
+		assert.Equal(t, []int{0, 1, 2}, received[c], c.Name)
+		assert.Equal(t, workers.shard(c), workers.shard(c))
+	}
+
+	// Writes still happen, on the caller, once the workers have stopped
+	cancel()
+	var written atomic.Int64
+	workers.fanOut(ctx, clients, func(*client.Client) { written.Add(1) })
+	assert.Equal(t, int64(len(clients)), written.Load())
+
+	// Without workers everything is written inline
+	var inline *broadcastWorkers
+	written.Store(0)
+	inline.fanOut(ctx, clients, func(*client.Client) { written.Add(1) })
+	assert.Equal(t, int64(len(clients)), written.Load())
+}
+
+func TestBroadcastToRoomWithWorkers(t *testing.T) {
+	t.Setenv("BROADCAST_WORKERS", "4")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	require.NotNil(t, hub.broadcastWorkers)
+	big, err := hub.CreateRoom("big", false, "", 1000)
+	require.NoError(t, err)
+
+	peers := make([]*client.Client, 0, minShardedRecipients)
+	conns := make(map[*client.Client]func(string) bool)
+	for i := 0; i < minShardedRecipients; i++ {
+		c, peer := newConnectedClient(t, fmt.Sprintf("member-%d", i), "")
+		big.AddClient(c)
+		peers = append(peers, c)
+		conns[c] = func(want string) bool { return readUntil(peer, want, 2*time.Second) }
+	}
+
+	for i := 0; i < 3; i++ {
+		hub.BroadcastToRoom(big, types.Message{Content: []byte(fmt.Sprintf("message %d", i)), Type: types.MsgTypeSystem})
+	}
+	for _, c := range peers {
+		for i := 0; i < 3; i++ {
+			assert.True(t, conns[c](fmt.Sprintf("[big] message %d", i)), "%s missed message %d", c.Name, i)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// joinHistoryChunkSize caps the messages sent in one room_history frame
+const joinHistoryChunkSize = 20
+
+// historyStore is the subset of the repository used to send history on join
+type historyStore interface {
+	ListRecentMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit int32) ([]db.ListRecentMessagesByRoomRow, error)
+}
+
+// sendJoinHistory sends a client that just joined targetRoom the room's most
+// recent messages, oldest first, in room_history frames of at most
+// joinHistoryChunkSize messages. It runs after the join has finished so a
+// busy room doesn't slow the join down, and stops if the client moves on.
+func (h *Hub) sendJoinHistory(client *clientpkg.Client, targetRoom *room.Room) {
+	size := h.Config().JoinHistorySize
+	if size == 0 || h.history == nil || client.Conn == nil || targetRoom.GetID() == "" {
+		return
+	}
+	var roomID pgtype.UUID
+	if err := roomID.Scan(targetRoom.GetID()); err != nil {
+		return
+	}
+
+	rows, err := h.history.ListRecentMessagesByRoom(h.Ctx, roomID, int32(size))
+	if err != nil {
+		log.Printf("Failed to load history for room %s: %v", targetRoom.Name, err)
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	messages := make([]types.HistoryMessageDTO, len(rows))
+	for i, row := range rows {
+		// Rows come newest first
+		messages[len(rows)-1-i] = types.HistoryMessageDTO{
+			ID:        uuid.UUID(row.ID.Bytes).String(),
+			Username:  row.Username,
+			Content:   row.Content,
+			Timestamp: row.CreatedAt.Time.Format(time.RFC3339),
+			ReplyTo:   parentID(row.ParentMessageID),
+		}
+	}
+
+	for start := 0; start < len(messages); start += joinHistoryChunkSize {
+		if client.GetCurrentRoom() != targetRoom {
+			return
+		}
+		end := min(start+joinHistoryChunkSize, len(messages))
+		chunk, _ := json.Marshal(types.RoomHistoryDTO{
+			Type:     types.MsgTypeRoomHistory,
+			Room:     targetRoom.Name,
+			Messages: messages[start:end],
+			Done:     end == len(messages),
+		})
+		if err := client.WriteMessage(h.Ctx, chunk); err != nil {
+			return
+		}
+	}
+}
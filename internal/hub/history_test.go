@@ -0,0 +1,132 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeHistoryStore holds count messages and answers once release is closed
+type fakeHistoryStore struct {
+	count   int
+	release chan struct{}
+	limit   atomic.Int32 // Limit of the last call
+	calls   atomic.Int32
+}
+
+func (f *fakeHistoryStore) ListRecentMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit int32) ([]db.ListRecentMessagesByRoomRow, error) {
+	f.calls.Add(1)
+	f.limit.Store(limit)
+	<-f.release
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rows := make([]db.ListRecentMessagesByRoomRow, 0, limit)
+	for i := f.count; i > 0 && len(rows) < int(limit); i-- {
+		rows = append(rows, db.ListRecentMessagesByRoomRow{
+			Content:   fmt.Sprintf("message %d", i),
+			Username:  "alice",
+			CreatedAt: pgtype.Timestamptz{Time: start.Add(time.Duration(i) * time.Minute), Valid: true},
+		})
+	}
+	return rows, nil
+}
+
+// readHistory reads room_history frames from conn until the last one
+func readHistory(t *testing.T, conn *websocket.Conn) []types.RoomHistoryDTO {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var frames []types.RoomHistoryDTO
+	for {
+		_, data, err := conn.Read(ctx)
+		require.NoError(t, err)
+		var frame types.RoomHistoryDTO
+		if json.Unmarshal(data, &frame) != nil || frame.Type != types.MsgTypeRoomHistory {
+			continue
+		}
+		frames = append(frames, frame)
+		if frame.Done {
+			return frames
+		}
+	}
+}
+
+func TestJoinHistory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+	store := &fakeHistoryStore{count: 100, release: make(chan struct{})}
+	hub.history = store
+
+	cfg := hub.Config()
+	cfg.JoinHistorySize = 45
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	busy, err := hub.CreateRoom("busy", false, "", 10)
+	require.NoError(t, err)
+	busy.ID = uuid.NewString()
+
+	// The join finishes while the history query is still running
+	alice, alicePeer := newConnectedClient(t, "alice", "user-alice")
+	require.NoError(t, hub.JoinRoom(alice, busy, ""))
+	assert.True(t, busy.HasClient(alice))
+	require.Eventually(t, func() bool { return store.calls.Load() == 1 }, time.Second, 10*time.Millisecond)
+	close(store.release)
+
+	frames := readHistory(t, alicePeer)
+	assert.Equal(t, int32(45), store.limit.Load())
+	require.Len(t, frames, 3)
+	assert.Len(t, frames[0].Messages, joinHistoryChunkSize)
+	assert.Len(t, frames[1].Messages, joinHistoryChunkSize)
+	assert.Len(t, frames[2].Messages, 5)
+	assert.False(t, frames[0].Done)
+
+	// The 45 newest messages, oldest first
+	var contents []string
+	for _, frame := range frames {
+		assert.Equal(t, "busy", frame.Room)
+		for _, msg := range frame.Messages {
+			contents = append(contents, msg.Content)
+		}
+	}
+	assert.Equal(t, "message 56", contents[0])
+	assert.Equal(t, "message 100", contents[44])
+	assert.Equal(t, "2024-01-01T00:56:00Z", frames[0].Messages[0].Timestamp)
+}
+
+func TestJoinHistoryDisabled(t *testing.T) {
+	t.Setenv("JOIN_HISTORY_SIZE", "0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+	store := &fakeHistoryStore{count: 10, release: make(chan struct{})}
+	close(store.release)
+	hub.history = store
+
+	quiet, err := hub.CreateRoom("quiet", false, "", 10)
+	require.NoError(t, err)
+	quiet.ID = uuid.NewString()
+
+	bob, _ := newConnectedClient(t, "bob", "user-bob")
+	require.NoError(t, hub.JoinRoom(bob, quiet, ""))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(0), store.calls.Load())
+}
@@ -6,15 +6,22 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/bcrypt"
 
+	"websocket-demo/internal/batch"
 	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/metrics"
 	natsclient "websocket-demo/internal/nats"
 	"websocket-demo/internal/repository"
 	"websocket-demo/internal/room"
+	"websocket-demo/internal/tracing"
 	"websocket-demo/internal/types"
 	"websocket-demo/internal/validator"
 
@@ -24,6 +31,15 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
+// roomStore is the subset of the repository used to create rooms
+type roomStore interface {
+	GetRoomByName(ctx context.Context, name string) (db.Room, error)
+	CountRooms(ctx context.Context, excludedName string) (int64, error)
+	CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error)
+	CreateRoom(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error)
+	CreateRoomWithCreator(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error)
+}
+
 // Hub manages all WebSocket connections and broadcasts messages between clients
 // Uses the Hub pattern for efficient client management
 type Hub struct {
@@ -33,23 +49,86 @@ type Hub struct {
 	Broadcast   chan types.Message
 	Register    chan *clientpkg.Client
 	Unregister  chan *clientpkg.Client
-	Repo        *repository.Repository
+	Repo        repository.Store
 	Mutex       sync.RWMutex
 	Ctx         context.Context
 	UserCount   int
-	roomOpMutex sync.Mutex // Prevents concurrent room operations on the same client
+	roomOpMutex sync.Mutex    // Prevents concurrent room operations on the same client
+	roomOpSem   chan struct{} // Caps concurrent join/leave/create/delete operations
 	NATS        *natsclient.Client
 	NATSEnabled bool
+	Metrics     *metrics.Metrics
+
+	userSessions map[string]map[*clientpkg.Client]bool // Connections indexed by user ID, guarded by Mutex
+
+	roomSubs      map[string]*nats.Subscription
+	roomSubsMutex sync.Mutex
+	userSubs      map[string][]*nats.Subscription // User and direct message subjects of locally connected users
+	userSubsMutex sync.Mutex
+	presence      *presenceTracker
+	userPresence  *userPresenceTracker
+	cluster       *clusterTracker
+
+	rooms             roomStore
+	polls             pollStore
+	outbox            outboxStore
+	users             userStore
+	history           historyStore
+	threads           threadStore
+	userSearch        userSearchStore
+	invites           inviteStore
+	exports           exportStore
+	exportTimes       *exportTracker
+	retention         retentionStore
+	retentionDays     int // Messages older than this are deleted; 0 keeps them forever
+	flags             flagStore
+	stats             statsStore
+	profileLookups    *lookupLimiter
+	passwordAttempts  *passwordAttempts
+	outboxKick        chan struct{} // Wakes the outbox publisher after a write
+	outboxLease       time.Duration // How long a claimed outbox entry is held
+	seen              *seenMessages // Relayed room message IDs, for dedupe
+	recentMessages    *recentMessages
+	typing            *typingClients
+	replyCache        *replyCache
+	lookupReplyTarget func(ctx context.Context, id pgtype.UUID) (replyTarget, error)
+
+	// Room messages waiting to be stored; nil stores each as it is sent
+	messageBatch *batch.MessageBatch[repository.NewMessage]
+
+	// Writes large room broadcasts in parallel; nil writes them inline
+	broadcastWorkers *broadcastWorkers
+
+	// Deleted rooms older than this are purged; 0 keeps them forever
+	roomRestoreWindow time.Duration
+
+	evictedRooms      map[string]struct{} // Stored rooms the sweeper dropped from Rooms, guarded by Mutex
+	roomSweepInterval time.Duration       // How often idle rooms are looked for
+
+	// Protected fallback room, created once by GetDefaultRoom
+	defaultRoomName  string
+	defaultRoomMutex sync.Mutex // Serializes GetDefaultRoom
+	defaultRoom      *room.Room // Cached by GetDefaultRoom
+
+	config      atomic.Value  // HubConfig; see ReloadConfig
+	configMutex sync.Mutex    // Serializes ReloadConfig
+	presenceTTL time.Duration // How long remote presence lives without a refresh
+	validator   atomic.Pointer[validator.Validator]
+
+	done          chan struct{} // Closed when Run has finished shutting down
+	shutdownStats ShutdownStats
 }
 
 // NewHub creates and initializes a new Hub instance
-func NewHub(ctx context.Context, repo *repository.Repository, natsClient *natsclient.Client) *Hub {
+func NewHub(ctx context.Context, repo repository.Store, natsClient *natsclient.Client) *Hub {
 	natsEnabled := natsClient != nil && natsClient.IsConnected()
-	return &Hub{
+	presenceTTL := GetPresenceTTL()
+	cfg := LoadHubConfig()
+	h := &Hub{
 		Clients:     make(map[*clientpkg.Client]bool),
 		Rooms:       make(map[string]*room.Room),
 		ClientRooms: make(map[*clientpkg.Client]*room.Room),
-		Broadcast:   make(chan types.Message, 100),  // Buffered channel to avoid blocking
+		Broadcast:   make(chan types.Message, cfg.BroadcastBufferSize), // Buffered channel to avoid blocking
 		Register:    make(chan *clientpkg.Client, 100), // Buffered to prevent deadlocks
 		Unregister:  make(chan *clientpkg.Client, 100), // Buffered to prevent deadlocks
 		Repo:        repo,
@@ -57,86 +136,227 @@ func NewHub(ctx context.Context, repo *repository.Repository, natsClient *natscl
 		UserCount:   0,
 		NATS:        natsClient,
 		NATSEnabled: natsEnabled,
+		Metrics:     metrics.NewMetrics(),
+		roomSubs:    make(map[string]*nats.Subscription),
+		userSubs:    make(map[string][]*nats.Subscription),
+
+		userSessions: make(map[string]map[*clientpkg.Client]bool),
+		roomOpSem:    make(chan struct{}, cfg.MaxConcurrentRoomOps),
+		presence:     newPresenceTracker(presenceTTL),
+		userPresence: newUserPresenceTracker(presenceTTL),
+		cluster:      newClusterTracker(),
+		replyCache:   newReplyCache(),
+		outboxKick:   make(chan struct{}, 1),
+		outboxLease:  defaultOutboxLease,
+		seen:         newSeenMessages(seenMessagesCapacity),
+
+		profileLookups:   newLookupLimiter(),
+		passwordAttempts: newPasswordAttempts(GetRoomPasswordMaxAttempts(), GetRoomPasswordCooldown()),
+		exportTimes:      newExportTracker(),
+		recentMessages:   newRecentMessages(),
+		typing:           newTypingClients(),
+		retentionDays:    GetMessageRetentionDays(),
+
+		roomRestoreWindow: GetRoomRestoreWindow(),
+		defaultRoomName:   GetDefaultRoomName(),
+		evictedRooms:      make(map[string]struct{}),
+		roomSweepInterval: GetRoomSweepInterval(),
+
+		presenceTTL: presenceTTL,
+		done:        make(chan struct{}),
+	}
+	h.config.Store(cfg)
+	h.validator.Store(validator.Default())
+	if cfg.BroadcastWorkers > 0 {
+		h.broadcastWorkers = newBroadcastWorkers(ctx, cfg.BroadcastWorkers)
+	}
+	h.lookupReplyTarget = h.lookupReplyTargetFromRepo
+	if repo != nil {
+		h.rooms = repo
+		h.polls = repo
+		h.outbox = repo
+		h.users = repo
+		h.history = repo
+		h.threads = repo
+		h.userSearch = repo
+		h.invites = repo
+		h.exports = repo
+		h.retention = repo
+		h.flags = repo
+		h.stats = repo
+		if size := GetMessageBatchSize(); size > 0 {
+			h.messageBatch = batch.NewMessageBatch(size, messageBatchFlushAfter, h.flushMessageBatch)
+		}
+	}
+	return h
+}
+
+// Stats is a point-in-time snapshot of the hub's connections and rooms
+type Stats struct {
+	Clients       int            `json:"clients"`
+	Rooms         int            `json:"rooms"`
+	RoomOccupancy map[string]int `json:"room_occupancy"`
+}
+
+// Stats returns the current number of clients and the occupancy of each room
+func (h *Hub) Stats() Stats {
+	h.Mutex.RLock()
+	clientCount := len(h.Clients)
+	rooms := make([]*room.Room, 0, len(h.Rooms))
+	for _, r := range h.Rooms {
+		rooms = append(rooms, r)
+	}
+	h.Mutex.RUnlock()
+
+	occupancy := make(map[string]int, len(rooms))
+	for _, r := range rooms {
+		occupancy[r.Name] = r.GetClientCount()
+	}
+
+	return Stats{
+		Clients:       clientCount,
+		Rooms:         len(rooms),
+		RoomOccupancy: occupancy,
 	}
 }
 
 // CreateRoom creates a new room with the specified name and properties
 func (h *Hub) CreateRoom(name string, private bool, password string, maxClients int) (*room.Room, error) {
-	// Validate room name
-	if name == "" || len(name) > 50 {
-		return nil, errors.New("invalid room name")
+	return h.CreateRoomAs(nil, name, private, password, maxClients)
+}
+
+// CreateRoomAs creates a room owned by creator, storing the room and the
+// creator's membership together; a nil creator leaves the room unowned
+func (h *Hub) CreateRoomAs(creator *clientpkg.Client, name string, private bool, password string, maxClients int) (*room.Room, error) {
+	if err := h.checkNewRoomName(name); err != nil {
+		return nil, err
 	}
+	return h.createRoom(creator, name, private, password, maxClients)
+}
+
+// ErrRoomNameConfusable is returned when a new room's name looks like an
+// existing room's, such as "lounge" with a Cyrillic "о"
+var ErrRoomNameConfusable = errors.New("room name is too similar to an existing room")
+
+// checkNewRoomName rejects names users may not create rooms with
+func (h *Hub) checkNewRoomName(name string) error {
+	v := h.Validator()
+	if name == "" || utf8.RuneCountInString(name) > v.Config().MaxRoomNameLength {
+		return errors.New("invalid room name")
+	}
+	if v.IsReservedRoomName(name) || h.IsDefaultRoom(name) {
+		return errors.New("room name is reserved")
+	}
+	return nil
+}
+
+// createRoom creates a room without checking the name against reserved names
+func (h *Hub) createRoom(creator *clientpkg.Client, name string, private bool, password string, maxClients int) (*room.Room, error) {
+	if err := h.acquireRoomOp(); err != nil {
+		return nil, err
+	}
+	defer h.releaseRoomOp()
 
 	// Hold write lock during entire check-and-create operation to prevent race condition
 	h.Mutex.Lock()
 	defer h.Mutex.Unlock()
+	return h.createRoomLocked(creator, name, private, password, maxClients)
+}
 
+// createRoomLocked does the work of createRoom. Callers must hold h.Mutex and
+// a room operation slot.
+func (h *Hub) createRoomLocked(creator *clientpkg.Client, name string, private bool, password string, maxClients int) (*room.Room, error) {
 	// Check if room already exists in database
-	if h.Repo != nil {
+	if h.rooms != nil {
 		ctx := context.Background()
-		_, err := h.Repo.GetRoomByName(ctx, name)
+		_, err := h.rooms.GetRoomByName(ctx, name)
 		if err == nil {
-			return nil, errors.New("room already exists")
+			return nil, repository.ErrRoomExists
 		}
 	}
 
 	// Check if room already exists in memory
 	if _, exists := h.Rooms[name]; exists {
-		return nil, errors.New("room already exists")
+		return nil, repository.ErrRoomExists
+	}
+	// Names that only look like an existing room's are refused as well
+	if !h.IsDefaultRoom(name) {
+		for existing := range h.Rooms {
+			if validator.Confusable(existing, name) {
+				return nil, ErrRoomNameConfusable
+			}
+		}
+		for evicted := range h.evictedRooms {
+			if validator.Confusable(evicted, name) {
+				return nil, ErrRoomNameConfusable
+			}
+		}
+	}
+
+	if err := h.checkRoomLimitsLocked(creator, name); err != nil {
+		return nil, err
+	}
+
+	// Hash the password using bcrypt; only the hash is kept in memory, stored, or synced
+	passwordHash := pgtype.Text{Valid: false}
+	if private && password != "" {
+		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Failed to hash room password: %v", err)
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		passwordHash = pgtype.Text{String: string(hashedPassword), Valid: true}
 	}
 
 	// Create new room
-	newRoom := room.NewRoom(name, private, password, maxClients)
+	newRoom := room.NewRoom(name, private, passwordHash.String, maxClients)
+	newRoom.SuppressJoinLeaveMessages = h.Config().SuppressJoinLeaveDefault
+	if creator != nil {
+		newRoom.SetCreator(creator)
+	}
 
 	// Add to hub's rooms map
 	h.Rooms[name] = newRoom
 
 	// Persist room to database if repository is available
-	if h.Repo != nil {
+	if h.rooms != nil {
 		ctx := context.Background()
-		passwordHash := pgtype.Text{Valid: false}
-		if private && password != "" {
-			// Hash the password using bcrypt
-			hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-			if err != nil {
-				log.Printf("Failed to hash room password: %v", err)
-				return nil, fmt.Errorf("failed to hash password: %w", err)
-			}
-			passwordHash = pgtype.Text{String: string(hashedPassword), Valid: true}
-		}
-
 		creatorID := pgtype.UUID{Valid: false}
 		if newRoom.Creator != nil && newRoom.Creator.UserID != "" {
 			creatorID.Scan(newRoom.Creator.UserID)
 		}
 
-		dbRoom, err := h.Repo.CreateRoom(ctx, name, pgtype.Bool{Bool: private, Valid: true}, passwordHash, creatorID)
+		// An owned room is stored together with its creator's membership
+		createRoom := h.rooms.CreateRoom
+		if creatorID.Valid {
+			createRoom = h.rooms.CreateRoomWithCreator
+		}
+		capacity := pgtype.Int4{Int32: int32(maxClients), Valid: true}
+		dbRoom, err := createRoom(ctx, name, pgtype.Bool{Bool: private, Valid: true}, passwordHash, creatorID, newRoom.SuppressJoinLeaveMessages, capacity)
+		if errors.Is(err, repository.ErrRoomExists) {
+			// Another server inserted the room after our check; adopt its row
+			// instead of keeping a divergent in-memory copy
+			delete(h.Rooms, name)
+			if existing, err := h.rooms.GetRoomByName(ctx, name); err == nil {
+				h.Rooms[name] = h.roomFromDB(existing)
+			} else {
+				log.Printf("Failed to load existing room %s from database: %v", name, err)
+			}
+			return nil, repository.ErrRoomExists
+		}
 		if err != nil {
 			log.Printf("Failed to persist room %s to database: %v", name, err)
 			// Continue with in-memory room for now
 		} else {
 			// Store database ID in room for future reference
-			newRoom.ID = uuid.UUID(dbRoom.ID.Bytes).String()
-			log.Printf("Room %s persisted to database with ID %s", name, newRoom.ID)
+			newRoom.SetID(uuid.UUID(dbRoom.ID.Bytes).String())
+			log.Printf("Room %s persisted to database with ID %s", name, newRoom.GetID())
 		}
 	}
 
 	// Publish room creation to NATS for synchronization across servers
 	if h.NATSEnabled && h.NATS != nil {
-		roomData := map[string]interface{}{
-			"name":     name,
-			"private":  private,
-			"password": password,
-			"maxClients": maxClients,
-		}
-		roomDataJSON, _ := json.Marshal(roomData)
-
-		syncMsg := types.Message{
-			Content: roomDataJSON,
-			Type:    types.MsgTypeRoomSync,
-		}
-
-		if err := h.NATS.Publish(natsclient.SubjectRoomSync, syncMsg); err != nil {
+		if err := h.publishRoomSync(roomSyncCreated, newRoom); err != nil {
 			log.Printf("Failed to publish room sync to NATS: %v", err)
 		} else {
 			log.Printf("Published room sync to NATS: %s", name)
@@ -146,14 +366,143 @@ func (h *Hub) CreateRoom(name string, private bool, password string, maxClients
 	return newRoom, nil
 }
 
+// checkRoomLimitsLocked refuses a room over MaxRooms or over its creator's
+// MaxRoomsPerUser. Rooms are counted in the database, which every server
+// shares, or in memory without one; the default room counts toward neither
+// limit, and admins have no per-user quota. Callers must hold h.Mutex.
+func (h *Hub) checkRoomLimitsLocked(creator *clientpkg.Client, name string) error {
+	if h.IsDefaultRoom(name) {
+		return nil
+	}
+	cfg := h.Config()
+
+	if cfg.MaxRooms > 0 {
+		count := -1
+		if h.rooms != nil {
+			if n, err := h.rooms.CountRooms(context.Background(), h.defaultRoomName); err == nil {
+				count = int(n)
+			} else {
+				log.Printf("Failed to count rooms in database, counting this server's: %v", err)
+			}
+		}
+		if count < 0 {
+			count = len(h.Rooms)
+			if _, exists := h.Rooms[h.defaultRoomName]; exists {
+				count--
+			}
+		}
+		if count >= cfg.MaxRooms {
+			return ErrMaxRoomsReached
+		}
+	}
+
+	if cfg.MaxRoomsPerUser > 0 && creator != nil && creator.UserID != "" && !creator.Admin {
+		count := -1
+		var creatorID pgtype.UUID
+		if h.rooms != nil && creatorID.Scan(creator.UserID) == nil {
+			if n, err := h.rooms.CountRoomsByCreator(context.Background(), creatorID); err == nil {
+				count = int(n)
+			} else {
+				log.Printf("Failed to count rooms of user %s in database, counting this server's: %v", creator.UserID, err)
+			}
+		}
+		if count < 0 {
+			count = 0
+			for _, r := range h.Rooms {
+				if r.IsCreatedBy(creator.UserID) {
+					count++
+				}
+			}
+		}
+		if count >= cfg.MaxRoomsPerUser {
+			return ErrRoomQuotaReached
+		}
+	}
+	return nil
+}
+
+// ErrRoomNotFound is returned when a named room doesn't exist on this server
+var ErrRoomNotFound = errors.New("room does not exist")
+
 // JoinRoom adds a client to a room
 func (h *Hub) JoinRoom(client *clientpkg.Client, targetRoom *room.Room, password string) error {
+	if err := h.acquireRoomOp(); err != nil {
+		return err
+	}
+	defer h.releaseRoomOp()
+
+	return h.joinRoom(client, targetRoom, password)
+}
+
+// LookupAndJoinRoom adds a client to the named room, looking it up under the
+// same lock as the join so a concurrent DeleteRoom either removes the room
+// before the lookup or evicts the client after the join. With AutoCreateRooms
+// a missing room is created as a public room owned by client.
+func (h *Hub) LookupAndJoinRoom(client *clientpkg.Client, roomName, password string) error {
+	if err := h.acquireRoomOp(); err != nil {
+		return err
+	}
+	defer h.releaseRoomOp()
+
+	h.Mutex.Lock()
+	h.roomOpMutex.Lock()
+	targetRoom, exists := h.roomLocked(roomName)
+	if !exists && h.Config().AutoCreateRooms {
+		var err error
+		targetRoom, err = h.autoCreateRoomLocked(client, roomName)
+		if err != nil {
+			h.roomOpMutex.Unlock()
+			h.Mutex.Unlock()
+			return err
+		}
+		exists = true
+	}
+	if !exists {
+		h.roomOpMutex.Unlock()
+		h.Mutex.Unlock()
+		return ErrRoomNotFound
+	}
+	return h.joinRoomLocked(client, targetRoom, password)
+}
+
+// autoCreateRoomLocked creates the missing room roomName for client to join.
+// A room another server stored meanwhile is adopted and returned instead.
+// Callers must hold h.Mutex and a room operation slot.
+func (h *Hub) autoCreateRoomLocked(client *clientpkg.Client, roomName string) (*room.Room, error) {
+	if err := h.checkNewRoomName(roomName); err != nil {
+		return nil, err
+	}
+	newRoom, err := h.createRoomLocked(client, roomName, false, "", h.Config().MaxClientsPerRoom)
+	if errors.Is(err, repository.ErrRoomExists) {
+		if existing, ok := h.Rooms[roomName]; ok {
+			return existing, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Room %s created on join by %s conn_id=%s", roomName, client.Name, client.ID)
+	return newRoom, nil
+}
+
+// joinRoom adds a client to a room; callers must hold a room operation slot
+func (h *Hub) joinRoom(client *clientpkg.Client, targetRoom *room.Room, password string) error {
 	// Acquire locks in consistent order: h.Mutex first, then roomOpMutex
 	h.Mutex.Lock()
 	h.roomOpMutex.Lock()
+	return h.joinRoomLocked(client, targetRoom, password)
+}
 
-	// Set the creator if this is the first client
-	if targetRoom.Creator == nil {
+// joinRoomLocked does the work of joinRoom. Callers must hold h.Mutex and
+// h.roomOpMutex, which it releases before returning.
+func (h *Hub) joinRoomLocked(client *clientpkg.Client, targetRoom *room.Room, password string) error {
+	// Set the creator if this is the first client; the default room has no owner
+	if targetRoom.Creator == nil && !h.IsDefaultRoom(targetRoom.Name) {
+		targetRoom.SetCreator(client)
+	}
+	// A room created over the API has a creator without a connection until
+	// that user joins
+	if creator := targetRoom.Creator; creator != nil && creator.Conn == nil && creator.UserID != "" && creator.UserID == client.UserID {
 		targetRoom.SetCreator(client)
 	}
 	// Validate room is active
@@ -164,20 +513,29 @@ func (h *Hub) JoinRoom(client *clientpkg.Client, targetRoom *room.Room, password
 	}
 
 	// Check max clients
-	if !targetRoom.AddClient(client) {
+	if h.roomIsFull(targetRoom) || !targetRoom.AddClient(client) {
 		h.roomOpMutex.Unlock()
 		h.Mutex.Unlock()
 		return errors.New("room is full")
 	}
 
-	// Validate password for private rooms
+	// Validate password for private rooms, refusing blocked users before
+	// spending a bcrypt comparison on them
 	if targetRoom.Private {
+		if err := h.passwordAttempts.check(client, targetRoom.Name); err != nil {
+			targetRoom.RemoveClient(client) // Rollback
+			h.roomOpMutex.Unlock()
+			h.Mutex.Unlock()
+			return err
+		}
 		if !h.VerifyPassword(password, targetRoom.Password) {
 			targetRoom.RemoveClient(client) // Rollback
 			h.roomOpMutex.Unlock()
 			h.Mutex.Unlock()
+			h.passwordAttempts.fail(client, targetRoom.Name)
 			return errors.New("invalid password")
 		}
+		h.passwordAttempts.succeed(client, targetRoom.Name)
 	}
 
 	// Remove client from any existing room
@@ -192,61 +550,86 @@ func (h *Hub) JoinRoom(client *clientpkg.Client, targetRoom *room.Room, password
 	// Update hub's client-to-room mapping
 	h.ClientRooms[client] = targetRoom
 
-	// Persist room membership to database if repository is available
-	if h.Repo != nil && client.UserID != "" {
-		ctx := context.Background()
-		roomID := pgtype.UUID{}
-		userID := pgtype.UUID{}
+	h.addRoomMember(client, targetRoom)
 
-		if err := roomID.Scan(targetRoom.ID); err == nil {
-			if err := userID.Scan(client.UserID); err == nil {
-				if err := h.Repo.AddRoomMember(ctx, roomID, userID); err != nil {
-					log.Printf("Failed to persist room membership for user %s in room %s: %v", client.UserID, targetRoom.Name, err)
-				}
+	h.roomOpMutex.Unlock()
+	h.Mutex.Unlock()
+
+	h.announceJoin(client, targetRoom)
+	return nil
+}
+
+// addRoomMember persists room membership to database if repository is
+// available, and refreshes the member's role in case another server changed it
+func (h *Hub) addRoomMember(client *clientpkg.Client, targetRoom *room.Room) {
+	if h.Repo == nil || client.UserID == "" {
+		return
+	}
+	ctx := context.Background()
+	roomID := pgtype.UUID{}
+	userID := pgtype.UUID{}
+
+	if err := roomID.Scan(targetRoom.GetID()); err == nil {
+		if err := userID.Scan(client.UserID); err == nil {
+			if err := h.Repo.AddRoomMember(ctx, roomID, userID, repository.RoomRoleMember); err != nil {
+				log.Printf("Failed to persist room membership for user %s in room %s: %v", client.UserID, targetRoom.Name, err)
+			} else if role, err := h.Repo.GetRoomMemberRole(ctx, roomID, userID); err == nil {
+				targetRoom.SetMemberRole(client.UserID, role)
 			}
 		}
 	}
+}
 
-	h.roomOpMutex.Unlock()
-	h.Mutex.Unlock()
+// markRoomRead records that a client's user has seen everything in its room,
+// so the room's unread count restarts from now
+func (h *Hub) markRoomRead(client *clientpkg.Client) {
+	currentRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || currentRoom == nil || h.Repo == nil || client.UserID == "" {
+		return
+	}
+	roomID := pgtype.UUID{}
+	userID := pgtype.UUID{}
+	if err := roomID.Scan(currentRoom.GetID()); err != nil {
+		return
+	}
+	if err := userID.Scan(client.UserID); err != nil {
+		return
+	}
+	if err := h.Repo.MarkRoomRead(context.Background(), roomID, userID); err != nil {
+		log.Printf("Failed to mark room %s read for user %s: %v", currentRoom.Name, client.UserID, err)
+	}
+}
+
+// announceJoin records a join and tells the room and the client about it;
+// callers must have released h.Mutex
+func (h *Hub) announceJoin(client *clientpkg.Client, targetRoom *room.Room) {
+	h.Metrics.RecordRoomJoin(targetRoom.Name)
+	h.publishPresence(targetRoom)
 
 	// Subscribe to room-specific NATS subject if enabled
 	if h.NATSEnabled && h.NATS != nil {
-		subject := natsclient.RoomSubject(targetRoom.Name)
-		// Use regular subscription (not queue) so ALL servers receive every message
-		// Queue subscriptions are for load balancing (one consumer gets the message),
-		// but we need pub/sub (all consumers get the message) for cross-server distribution
-		_, err := h.NATS.Subscribe(subject, func(msg types.Message) {
-			// Skip messages that originated from this server to prevent duplicate delivery
-			if msg.ServerID != "" && msg.ServerID == h.NATS.GetServerID() {
-				log.Printf("Skipping message from own server %s", msg.ServerID)
-				return
-			}
-			// Forward NATS messages to BroadcastToRoom for consistent handling
-			// BroadcastToRoom will handle delivery to local clients
-			h.BroadcastToRoom(targetRoom, msg)
-		})
-		if err != nil {
-			log.Printf("Failed to subscribe to room NATS subject %s: %v", subject, err)
-		} else {
-			log.Printf("Subscribed to room NATS subject: %s", subject)
+		if err := h.ensureRoomSubscription(targetRoom); err != nil {
+			log.Printf("Failed to subscribe to room NATS subject %s: %v", natsclient.RoomSubject(targetRoom.Name), err)
 		}
 	}
 
-	// Broadcast room join notification
+	// Broadcast room join notification unless the room has them turned off
 	timestamp := time.Now().Format("15:04:05")
-	joinMsg := []byte(fmt.Sprintf("[%s] %s has joined the room", timestamp, client.Name))
-	// Add MessageID to prevent duplicate broadcasting via NATS
-	joinMessageID := fmt.Sprintf("join-%d-%s", time.Now().UnixNano(), client.UserID)
-	h.BroadcastToRoom(targetRoom, types.Message{MessageID: joinMessageID, Content: joinMsg, Sender: client, Type: types.MsgTypeRoomJoin})
+	if !targetRoom.SuppressesJoinLeave() {
+		joinMsg := []byte(fmt.Sprintf("[%s] %s has joined the room", timestamp, client.Name))
+		// Add MessageID to prevent duplicate broadcasting via NATS
+		joinMessageID := fmt.Sprintf("join-%d-%s", time.Now().UnixNano(), client.UserID)
+		h.BroadcastToRoom(targetRoom, types.Message{MessageID: joinMessageID, Content: joinMsg, Sender: client, Type: types.MsgTypeRoomJoin})
+	}
 
 	// Send room welcome message
 	welcomeMsg := []byte(fmt.Sprintf("[%s] Welcome to room '%s'!", timestamp, targetRoom.Name))
 	if client.Conn != nil {
-		client.Conn.Write(h.Ctx, websocket.MessageText, welcomeMsg)
+		client.WriteMessage(h.Ctx, welcomeMsg)
 	}
+	h.sendRoomAlert(client, targetRoom)
 
-	return nil
+	go h.sendJoinHistory(client, targetRoom)
 }
 
 // leaveRoomInternal removes a client from their current room (internal use, assumes h.Mutex and roomOpMutex are held)
@@ -271,13 +654,16 @@ func (h *Hub) leaveRoomInternal(client *clientpkg.Client) {
 	// Update hub's client-to-room mapping
 	delete(h.ClientRooms, client)
 
+	h.Metrics.RecordRoomLeave(room.Name)
+	h.publishPresence(room)
+
 	// Remove room membership from database if repository is available
 	if h.Repo != nil && client.UserID != "" {
 		ctx := context.Background()
 		roomID := pgtype.UUID{}
 		userID := pgtype.UUID{}
 
-		if err := roomID.Scan(room.ID); err == nil {
+		if err := roomID.Scan(room.GetID()); err == nil {
 			if err := userID.Scan(client.UserID); err == nil {
 				if err := h.Repo.RemoveRoomMember(ctx, roomID, userID); err != nil {
 					log.Printf("Failed to remove room membership for user %s from room %s: %v", client.UserID, room.Name, err)
@@ -286,13 +672,21 @@ func (h *Hub) leaveRoomInternal(client *clientpkg.Client) {
 		}
 	}
 
+	// Clear a typing indicator the leaving user was still showing
+	h.stopTyping(client)
+
 	// Send confirmation to the leaving user
 	leaveConfirmMsg := []byte(fmt.Sprintf("You have left the room \"%s\"", room.Name))
-	if err := client.Conn.Write(context.Background(), websocket.MessageText, leaveConfirmMsg); err != nil {
-		log.Printf("Failed to send leave confirmation to %s: %v", client.Name, err)
+	if client.Conn != nil {
+		if err := client.WriteMessage(context.Background(), leaveConfirmMsg); err != nil {
+			log.Printf("Failed to send leave confirmation to %s: %v", client.Name, err)
+		}
 	}
 
 	// Broadcast room leave notification to remaining room members
+	if room.SuppressesJoinLeave() {
+		return
+	}
 	timestamp := time.Now().Format("15:04:05")
 	leaveMsg := []byte(fmt.Sprintf("[%s] %s has left the room", timestamp, client.Name))
 	h.BroadcastToRoom(room, types.Message{Content: leaveMsg, Sender: nil, Type: types.MsgTypeRoomLeave})
@@ -300,6 +694,12 @@ func (h *Hub) leaveRoomInternal(client *clientpkg.Client) {
 
 // LeaveRoom removes a client from their current room
 func (h *Hub) LeaveRoom(client *clientpkg.Client) {
+	if err := h.acquireRoomOp(); err != nil {
+		log.Printf("LeaveRoom: %v for client %s", err, client.Name)
+		return
+	}
+	defer h.releaseRoomOp()
+
 	// Acquire locks in consistent order: h.Mutex first, then roomOpMutex
 	h.Mutex.Lock()
 	h.roomOpMutex.Lock()
@@ -308,14 +708,46 @@ func (h *Hub) LeaveRoom(client *clientpkg.Client) {
 	h.Mutex.Unlock()
 }
 
+// LeaveNamedRoom removes a client from the named room, failing if the client
+// is not in it. An empty name leaves the current room, like LeaveRoom.
+func (h *Hub) LeaveNamedRoom(client *clientpkg.Client, roomName string) error {
+	roomName = strings.TrimSpace(roomName)
+	if roomName == "" {
+		h.LeaveRoom(client)
+		return nil
+	}
+
+	if err := h.acquireRoomOp(); err != nil {
+		return err
+	}
+	defer h.releaseRoomOp()
+
+	h.Mutex.Lock()
+	h.roomOpMutex.Lock()
+	defer h.Mutex.Unlock()
+	defer h.roomOpMutex.Unlock()
+
+	current, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || current == nil || current.Name != roomName || !current.HasClient(client) {
+		return fmt.Errorf("you are not in room %q", roomName)
+	}
+	h.leaveRoomInternal(client)
+	return nil
+}
+
 // DeleteRoom deletes a room
 func (h *Hub) DeleteRoom(client *clientpkg.Client, roomName string) error {
-	h.Mutex.RLock()
-	targetRoom, exists := h.Rooms[roomName]
-	h.Mutex.RUnlock()
+	if err := h.acquireRoomOp(); err != nil {
+		return err
+	}
+	defer h.releaseRoomOp()
 
+	targetRoom, exists := h.GetRoom(roomName)
 	if !exists {
-		return errors.New("room does not exist")
+		return ErrRoomNotFound
+	}
+	if h.IsDefaultRoom(roomName) {
+		return ErrDefaultRoomProtected
 	}
 
 	// Check if client is the creator
@@ -323,15 +755,25 @@ func (h *Hub) DeleteRoom(client *clientpkg.Client, roomName string) error {
 		return errors.New("only the room creator can delete this room")
 	}
 
+	// Keep the room's messages so an admin can restore it
+	if err := h.softDeleteRoom(targetRoom); err != nil {
+		return err
+	}
+
 	// Broadcast room deletion notification globally
 	timestamp := time.Now().Format("15:04:05")
 	deleteMsg := []byte(fmt.Sprintf("[%s] Room '%s' has been deleted by %s", timestamp, roomName, client.Name))
 	h.Broadcast <- types.Message{Content: deleteMsg, Sender: nil, Type: types.MsgTypeDeleteRoom}
 
-	// Remove room from hub
-	h.Mutex.Lock()
-	delete(h.Rooms, roomName)
-	h.Mutex.Unlock()
+	// Remove room from hub and evict its members
+	h.removeRoomLocally(roomName)
+
+	// Tell the other servers to drop their copy
+	if h.NATSEnabled && h.NATS != nil {
+		if err := h.publishRoomSync(roomSyncDeleted, targetRoom); err != nil {
+			log.Printf("Failed to publish room deletion to NATS: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -339,61 +781,101 @@ func (h *Hub) DeleteRoom(client *clientpkg.Client, roomName string) error {
 // BroadcastToRoom sends a message to all clients in a specific room
 func (h *Hub) BroadcastToRoom(targetRoom *room.Room, message types.Message) {
 	clients := targetRoom.GetClients()
-	log.Printf("BroadcastToRoom: Room '%s', Message type '%s', MessageID: '%s', Total clients in room: %d", targetRoom.Name, message.Type, message.MessageID, len(clients))
+	senderConnID := ""
+	if sender, ok := message.Sender.(*clientpkg.Client); ok && sender != nil {
+		senderConnID = sender.ID
+	}
+	log.Printf("BroadcastToRoom: Room '%s', Message type '%s', MessageID: '%s', Total clients in room: %d conn_id=%s", targetRoom.Name, message.Type, message.MessageID, len(clients), senderConnID)
 
 	// Skip publishing to NATS if this message already has a MessageID (meaning it came from NATS)
 	// This prevents the infinite loop: NATS → BroadcastToRoom → NATS → BroadcastToRoom → ...
 	if h.NATSEnabled && h.NATS != nil && message.MessageID == "" {
 		subject := natsclient.RoomSubject(targetRoom.Name)
-		if err := h.NATS.Publish(subject, message); err != nil {
+		relayed := message
+		relayed.RoomName = targetRoom.Name
+		if err := h.NATS.Publish(subject, relayed); err != nil {
 			log.Printf("Failed to publish message to NATS subject %s: %v", subject, err)
 		} else {
 			log.Printf("Published message to NATS subject %s", subject)
 		}
 	}
 
-	clientsToRemove := make([]*clientpkg.Client, 0)
-	// Send to all clients in room
+	if message.Type == types.MsgTypeRoomMessage {
+		h.Metrics.RecordRoomMessage(targetRoom.Name)
+	}
+
+	// Validate message size once before broadcasting
+	if err := validator.ValidateMessageSize(len(message.Content), h.Validator().MaxMessageSize()); err != nil {
+		log.Printf("BroadcastToRoom: Dropping message to room %s due to size validation: %v conn_id=%s", targetRoom.Name, err, senderConnID)
+		return
+	}
+
+	// Format message with room prefix
+	roomPrefix := fmt.Sprintf("[%s] ", targetRoom.Name)
+	formattedContent := append([]byte(roomPrefix), message.Content...)
+
+	var delivery metrics.DeliveryStats
+	recipients := make([]*clientpkg.Client, 0, len(clients))
 	for _, client := range clients {
 		if client.Conn == nil {
-			log.Printf("BroadcastToRoom: Skipping client %s (nil connection)", client.Name)
+			log.Printf("BroadcastToRoom: Skipping client %s (nil connection) conn_id=%s", client.Name, client.ID)
+			delivery.Record(metrics.DeliverySkipped)
 			continue
 		}
 
-		// Don't send the message back to the sender (for room messages)
-		if message.Type == types.MsgTypeRoomMessage && message.Sender != nil && client == message.Sender {
-			log.Printf("BroadcastToRoom: Skipping sender %s", client.Name)
+		// Don't send room messages and typing indicators back to the sender
+		if isEchoSuppressed(message.Type) && isSender(client, message) {
+			log.Printf("BroadcastToRoom: Skipping sender %s conn_id=%s", client.Name, client.ID)
+			delivery.Record(metrics.DeliverySkipped)
 			continue
 		}
+		recipients = append(recipients, client)
+	}
 
-		// Validate message size before broadcasting
-		maxSize := validator.GetMaxMessageSize()
-		if err := validator.ValidateMessageSize(len(message.Content), maxSize); err != nil {
-			log.Printf("BroadcastToRoom: Skipping message to %s due to size validation: %v", client.Name, err)
-			return // Skip this message
-		}
-
-		// Format message with room prefix
-		roomPrefix := fmt.Sprintf("[%s] ", targetRoom.Name)
-		formattedContent := append([]byte(roomPrefix), message.Content...)
-
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-		err := client.Conn.Write(ctx, websocket.MessageText, formattedContent)
-		cancel()
+	var removeMutex sync.Mutex
+	clientsToRemove := make([]*clientpkg.Client, 0)
+	// Send to all recipients, spread over the broadcast workers in large rooms
+	h.broadcastWorkers.fanOut(h.Ctx, recipients, func(client *clientpkg.Client) {
+		err := client.WriteMessage(context.Background(), formattedContent)
 		if err != nil {
-			// Handle write error - client likely disconnected
-			log.Printf("BroadcastToRoom: Error writing to client %s: %v", client.Name, err)
+			// Handle write error - client disconnected or too slow
+			log.Printf("BroadcastToRoom: Error writing to client %s: %v conn_id=%s", client.Name, err, client.ID)
+			h.Metrics.RecordRoomError(targetRoom.Name)
+			delivery.Record(writeFailure(err))
+			removeMutex.Lock()
 			clientsToRemove = append(clientsToRemove, client)
+			removeMutex.Unlock()
 		} else {
-			log.Printf("BroadcastToRoom: Sent message to client %s: %s", client.Name, string(formattedContent))
+			log.Printf("BroadcastToRoom: Sent message to client %s: %s conn_id=%s", client.Name, string(formattedContent), client.ID)
+			delivery.Record(metrics.DeliveryDelivered)
 		}
-	}
+	})
+	h.Metrics.RecordDeliveries(targetRoom.Name, delivery.Snapshot())
 	// Unregister failed clients
 	for _, c := range clientsToRemove {
 		h.Unregister <- c
 	}
 }
 
+// isEchoSuppressed reports whether messages of msgType skip their sender
+func isEchoSuppressed(msgType string) bool {
+	switch msgType {
+	case types.MsgTypeRoomMessage, types.MsgTypeTypingStart, types.MsgTypeTypingStop:
+		return true
+	}
+	return false
+}
+
+// isSender reports whether client is the connection that sent the message,
+// falling back to the relayed SenderConnID for messages that arrived over
+// NATS without a Sender. The sender's other connections aren't the sender.
+func isSender(client *clientpkg.Client, message types.Message) bool {
+	if message.Sender != nil {
+		return client == message.Sender
+	}
+	return message.SenderConnID != "" && client.ID == message.SenderConnID
+}
+
 // VerifyPassword checks if the provided password matches the correct password
 func (h *Hub) VerifyPassword(inputPassword, correctPassword string) bool {
 	// Compare using bcrypt to verify hashed passwords
@@ -409,6 +891,10 @@ func (h *Hub) Run() {
 	// Set up NATS subscriptions if enabled
 	var globalChatSub *nats.Subscription
 	var roomSyncSub *nats.Subscription
+	var presenceSub *nats.Subscription
+	var userPresenceSub *nats.Subscription
+	var clusterSub *nats.Subscription
+	var userLookupSub *nats.Subscription
 	if h.NATSEnabled && h.NATS != nil {
 		// Subscribe to global chat
 		sub, err := h.NATS.Subscribe(natsclient.SubjectGlobalChat, func(msg types.Message) {
@@ -428,46 +914,52 @@ func (h *Hub) Run() {
 		}
 
 		// Subscribe to room sync for cross-server room creation
-		roomSyncSub, err = h.NATS.Subscribe(natsclient.SubjectRoomSync, func(msg types.Message) {
-			// Parse room data
-			var roomData map[string]interface{}
-			if err := json.Unmarshal(msg.Content, &roomData); err != nil {
-				log.Printf("Failed to unmarshal room sync data: %v", err)
-				return
-			}
-
-			name, _ := roomData["name"].(string)
-			private, _ := roomData["private"].(bool)
-			password, _ := roomData["password"].(string)
-			maxClients := 100
-			if mc, ok := roomData["maxClients"].(float64); ok {
-				maxClients = int(mc)
-			}
-
-			// Check if room already exists
-			h.Mutex.Lock()
-			if _, exists := h.Rooms[name]; !exists {
-				// Create room from sync data
-				newRoom := room.NewRoom(name, private, password, maxClients)
-				h.Rooms[name] = newRoom
-				log.Printf("Room %s synced from NATS", name)
-
-				// Try to load from database to get ID
-				if h.Repo != nil {
-					ctx := context.Background()
-					dbRoom, err := h.Repo.GetRoomByName(ctx, name)
-					if err == nil {
-						newRoom.ID = uuid.UUID(dbRoom.ID.Bytes).String()
-					}
-				}
-			}
-			h.Mutex.Unlock()
-		})
+		roomSyncSub, err = h.NATS.Subscribe(natsclient.SubjectRoomSync, h.handleRoomSync)
 		if err != nil {
 			log.Printf("Failed to subscribe to room sync: %v", err)
 		} else {
 			log.Println("Subscribed to NATS room sync subject")
 		}
+
+		// Track room occupancy announced by other servers
+		presenceSub, err = h.NATS.Subscribe(natsclient.SubjectPresencePrefix+".>", h.handlePresence)
+		if err != nil {
+			log.Printf("Failed to subscribe to presence: %v", err)
+		} else {
+			log.Println("Subscribed to NATS presence subjects")
+		}
+
+		// Track users connected to other servers
+		userPresenceSub, err = h.NATS.Subscribe(natsclient.SubjectUserPresence, h.handleUserPresence)
+		if err != nil {
+			log.Printf("Failed to subscribe to user presence: %v", err)
+		} else {
+			log.Println("Subscribed to NATS user presence subject")
+		}
+
+		// Track which servers are alive and how loaded they are
+		clusterSub, err = h.NATS.Subscribe(natsclient.SubjectClusterHeartbeat, h.handleClusterHeartbeat)
+		if err != nil {
+			log.Printf("Failed to subscribe to cluster heartbeats: %v", err)
+		} else {
+			log.Println("Subscribed to NATS cluster heartbeat subject")
+		}
+
+		// Answer other servers looking for users connected here
+		userLookupSub, err = h.NATS.SubscribeReplies(natsclient.SubjectUserLookup, h.handleUserLookup)
+		if err != nil {
+			log.Printf("Failed to subscribe to user lookups: %v", err)
+		} else {
+			log.Println("Subscribed to NATS user lookup subject")
+		}
+
+		// Restore room subscriptions and state after NATS outages
+		go h.watchNATSReconnects()
+		go h.runPresenceHeartbeat()
+		go h.runClusterHeartbeat()
+		if h.outbox != nil {
+			go h.runOutboxPublisher(h.Ctx)
+		}
 	}
 
 	defer func() {
@@ -477,32 +969,77 @@ func (h *Hub) Run() {
 		if roomSyncSub != nil {
 			roomSyncSub.Unsubscribe()
 		}
+		if presenceSub != nil {
+			presenceSub.Unsubscribe()
+		}
+		if userPresenceSub != nil {
+			userPresenceSub.Unsubscribe()
+		}
+		if clusterSub != nil {
+			clusterSub.Unsubscribe()
+		}
+		if userLookupSub != nil {
+			userLookupSub.Unsubscribe()
+		}
 	}()
 
+	h.startUnregisterWorkers()
+	if h.polls != nil {
+		go h.runPollCloser()
+	}
+	if h.retention != nil && h.retentionDays > 0 {
+		go h.runRetentionCleanup()
+	}
+	if h.stats != nil {
+		go h.runStatsSampler()
+	}
+	if h.Repo != nil && h.roomRestoreWindow > 0 {
+		go h.runDeletedRoomPurge()
+	}
+	if h.Repo != nil {
+		go h.runRoomSweeper()
+	}
+
 	for {
 		select {
 		case <-h.Ctx.Done():
 			// Context cancelled, close all connections and exit
-			h.Mutex.Lock()
-			for client := range h.Clients {
-				if client != nil && client.Conn != nil {
-					client.Conn.Close(websocket.StatusNormalClosure, "server shutting down")
-				}
-			}
-			h.Clients = make(map[*clientpkg.Client]bool)
-			h.Mutex.Unlock()
+			h.shutdown()
 			if h.NATS != nil {
-				h.NATS.Close()
+				h.publishLocalUserPresence(true)
+				h.publishClusterHeartbeat(true)
+				if err := h.NATS.DrainAndClose(h.NATS.DrainTimeout()); err != nil {
+					log.Printf("Shutdown: %v", err)
+				}
 			}
+			close(h.done)
 			return
 
 		case client := <-h.Register:
 			if client != nil {
 				h.Mutex.Lock()
+				if !h.addSession(client) {
+					connections := h.userConnectionCountLocked(client.UserID)
+					h.Mutex.Unlock()
+					log.Printf("Connection limit reached for user %s (%d connections) conn_id=%s", client.UserID, connections, client.ID)
+					// The handler closes the connection with the reason
+					client.Refused = true
+					client.RegisteredOnce.Do(func() {
+						close(client.Registered)
+					})
+					continue
+				}
 				h.Clients[client] = true
 				h.UserCount++
+				userCount := h.UserCount
+				connections := h.sessionCount(client)
 				h.Mutex.Unlock()
-				log.Printf("Client %s connected. Total clients: %d", client.Name, h.UserCount)
+				h.Metrics.IncrementActiveConnections()
+				log.Printf("Client %s connected. Total clients: %d", client.Name, userCount)
+				if h.NATSEnabled && h.NATS != nil && client.UserID != "" {
+					h.ensureUserSubscription(client.UserID)
+					h.publishUserPresence(client.UserID, client.Name, connections)
+				}
 
 				// Signal that this client's registration is complete FIRST
 				client.RegisteredOnce.Do(func() {
@@ -513,28 +1050,10 @@ func (h *Hub) Run() {
 				// Join notification removed
 			}
 
-		case client := <-h.Unregister:
-			if client != nil {
-				h.Mutex.Lock()
-				if _, ok := h.Clients[client]; ok {
-					delete(h.Clients, client)
-					h.UserCount--
-					if client.Conn != nil {
-						client.Conn.Close(websocket.StatusNormalClosure, "")
-					}
-				}
-				h.Mutex.Unlock()
-				log.Printf("Client %s disconnected. Total clients: %d", client.Name, h.UserCount)
-
-				// Broadcast leave notification to all remaining clients
-				timestamp := time.Now().Format("15:04:05")
-				leaveMsg := []byte(fmt.Sprintf("[%s] %s has left the chat", timestamp, client.Name))
-				h.Broadcast <- types.Message{Content: leaveMsg, Sender: nil, Type: types.MsgTypeLeave}
-			}
-
 		case message := <-h.Broadcast:
-			// Save chat messages to database
-			if (message.Type == types.MsgTypeChat || message.Type == types.MsgTypeRoomMessage) && message.Sender != nil {
+			broadcastStart := time.Now()
+			// Save global chat messages to database (room messages are persisted by the handler)
+			if (message.Type == types.MsgTypeChat || message.Type == types.MsgTypeRoomMessage) && message.Room == nil && message.Sender != nil {
 				if sender, ok := message.Sender.(*clientpkg.Client); ok && sender.Authenticated && sender.UserID != "" {
 					// Parse the message content to get chat content
 					var chatMsg types.ChatMessage
@@ -543,7 +1062,7 @@ func (h *Hub) Run() {
 						if h.Repo != nil {
 							var senderUUID pgtype.UUID
 							if err := senderUUID.Scan(sender.UserID); err == nil {
-								ctx := context.Background()
+								ctx := tracing.Extract(context.Background(), message.TraceContext)
 								// Save message to database (use null UUID for global chat - no room)
 								_, err := h.Repo.CreateMessage(ctx, pgtype.UUID{Valid: false}, senderUUID, chatMsg.Content)
 								if err != nil {
@@ -568,63 +1087,98 @@ func (h *Hub) Run() {
 				if room, ok := message.Room.(*room.Room); ok {
 					h.BroadcastToRoom(room, message)
 				}
+			} else if message.RoomName != "" {
+				// Relayed room message without a local room pointer
+				if room, ok := h.GetRoom(message.RoomName); ok {
+					h.BroadcastToRoom(room, message)
+				} else {
+					log.Printf("Dropping message for unknown room %s", message.RoomName)
+				}
 			} else {
 				h.Mutex.RLock()
 				log.Printf("Broadcasting message of type '%s' to %d clients", message.Type, len(h.Clients))
 				sentCount := 0
 				clientsToRemove := make([]*clientpkg.Client, 0)
+				var delivery metrics.DeliveryStats
 
 				for client := range h.Clients {
 					// Don't send the message back to the sender (for chat messages)
 					// But do send join/leave notifications to everyone including the sender
-					if message.Type == types.MsgTypeChat && message.Sender != nil && client == message.Sender {
+					if message.Type == types.MsgTypeChat && isSender(client, message) {
 						log.Printf("Skipping sender %s for chat message", client.Name)
+						delivery.Record(metrics.DeliverySkipped)
 						continue
 					}
 
 					// Check if client connection is nil before attempting to write
 					if client.Conn == nil {
 						log.Printf("Skipping client %s with nil connection", client.Name)
+						delivery.Record(metrics.DeliverySkipped)
 						continue
 					}
 
-					err := client.Conn.Write(h.Ctx, websocket.MessageText, message.Content)
+					err := client.WriteMessage(h.Ctx, message.Content)
 					if err != nil {
 						log.Printf("Error writing to client %s: %v", client.Name, err)
+						delivery.Record(writeFailure(err))
 						clientsToRemove = append(clientsToRemove, client)
 					} else {
 						sentCount++
 						log.Printf("Message sent to client %s", client.Name)
+						delivery.Record(metrics.DeliveryDelivered)
 					}
 				}
 				h.Mutex.RUnlock()
+				h.Metrics.RecordDeliveries("", delivery)
 
 				// Remove failed clients with write lock
 				if len(clientsToRemove) > 0 {
+					disconnectedUsers := make([]string, 0)
 					h.Mutex.Lock()
 					for _, client := range clientsToRemove {
 						if _, ok := h.Clients[client]; ok {
 							delete(h.Clients, client)
+							if h.removeSession(client) {
+								disconnectedUsers = append(disconnectedUsers, client.UserID)
+							}
 							h.UserCount--
+							h.Metrics.DecrementActiveConnections()
 							client.Conn.Close(websocket.StatusInternalError, "write error")
 							log.Printf("Removed failed client %s", client.Name)
 						}
 					}
 					h.Mutex.Unlock()
+					if h.NATS != nil {
+						for _, userID := range disconnectedUsers {
+							h.removeUserSubscription(userID)
+							h.publishUserPresence(userID, "", 0)
+						}
+					}
 				}
 
 				log.Printf("Broadcast complete: sent to %d clients", sentCount)
 			}
+
+			h.Metrics.IncrementMessages()
+			h.Metrics.RecordLatency(time.Since(broadcastStart))
 		}
 	}
 }
 
-// GetRoom returns a room by name
+// GetRoom returns a room by name, loading it from the database when the
+// sweeper evicted it
 func (h *Hub) GetRoom(name string) (*room.Room, bool) {
 	h.Mutex.RLock()
-	defer h.Mutex.RUnlock()
 	room, exists := h.Rooms[name]
-	return room, exists
+	_, evicted := h.evictedRooms[name]
+	h.Mutex.RUnlock()
+	if exists || !evicted {
+		return room, exists
+	}
+
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+	return h.roomLocked(name)
 }
 
 // GetRoomList returns a list of all rooms with their information
@@ -634,27 +1188,137 @@ func (h *Hub) GetRoomList(client *clientpkg.Client) []types.RoomDTO {
 	for name, r := range h.Rooms {
 		rooms[name] = r
 	}
+	evicted := make([]string, 0, len(h.evictedRooms))
+	for name := range h.evictedRooms {
+		evicted = append(evicted, name)
+	}
 	h.Mutex.RUnlock()
 
 	roomList := make([]types.RoomDTO, 0, len(rooms))
+	roomIDs := make([]pgtype.UUID, 0, len(rooms)) // Parallel to roomList; invalid for rooms without an ID
 	for name, room := range rooms {
 		room.Mutex.RLock()
 		clientCount := len(room.Clients)
 		isCreator := room.Creator == client
+		roomID := room.ID
+		maxClients := room.MaxClients
 		room.Mutex.RUnlock()
 
+		var roomUUID pgtype.UUID
+		if roomID != "" {
+			roomUUID.Scan(roomID)
+		}
 		roomInfo := types.RoomDTO{
-			Name:        name,
-			Private:     room.Private,
-			ClientCount: clientCount,
-			IsCreator:   isCreator,
+			Name:              name,
+			Private:           room.Private,
+			ClientCount:       clientCount,
+			MemberCount:       clientCount,
+			OnlineCount:       clientCount + h.presence.remoteCount(name),
+			MaxClients:        maxClients,
+			IsCreator:         isCreator,
+			SuppressJoinLeave: room.SuppressesJoinLeave(),
 		}
 		roomList = append(roomList, roomInfo)
+		roomIDs = append(roomIDs, roomUUID)
 	}
+	h.addMemberCounts(roomList, roomIDs)
+	evictedList, evictedIDs := h.evictedRoomList(client, evicted)
+	roomList = append(roomList, evictedList...)
+	roomIDs = append(roomIDs, evictedIDs...)
+	h.addLastMessages(client, roomList, roomIDs)
 	return roomList
 }
 
-// LoadRoomsFromDB loads all rooms from the database into memory
+// addMemberCounts replaces the local client counts in roomList with the
+// stored member counts, read with one query; roomIDs[i] is the ID of
+// roomList[i]. Rooms keep the local count when membership can't be read.
+func (h *Hub) addMemberCounts(roomList []types.RoomDTO, roomIDs []pgtype.UUID) {
+	if h.Repo == nil {
+		return
+	}
+	stored := make([]pgtype.UUID, 0, len(roomIDs))
+	for _, id := range roomIDs {
+		if id.Valid {
+			stored = append(stored, id)
+		}
+	}
+	counts, ok := h.memberCounts(stored)
+	if !ok {
+		return
+	}
+	for i, id := range roomIDs {
+		if id.Valid {
+			roomList[i].MemberCount = counts[id.Bytes]
+		}
+	}
+}
+
+// memberCounts reads the stored member counts of the given rooms with one
+// query, keyed by room ID; rooms without members are missing. ok is false
+// when the counts couldn't be read. Callers must check h.Repo.
+func (h *Hub) memberCounts(roomIDs []pgtype.UUID) (counts map[[16]byte]int, ok bool) {
+	if len(roomIDs) == 0 {
+		return nil, true
+	}
+	rows, err := h.Repo.GetRoomMemberCounts(context.Background(), roomIDs)
+	if err != nil {
+		log.Printf("Failed to get member counts for the room list: %v", err)
+		return nil, false
+	}
+	counts = make(map[[16]byte]int, len(rows))
+	for _, row := range rows {
+		counts[row.RoomID.Bytes] = int(row.Count)
+	}
+	return counts, true
+}
+
+// addLastMessages fills in the latest message of each listed room with one
+// query for all of them; roomIDs[i] is the ID of roomList[i]. Private rooms
+// only get a preview when client is a member.
+func (h *Hub) addLastMessages(client *clientpkg.Client, roomList []types.RoomDTO, roomIDs []pgtype.UUID) {
+	if h.Repo == nil {
+		return
+	}
+	stored := make([]pgtype.UUID, 0, len(roomIDs))
+	for _, id := range roomIDs {
+		if id.Valid {
+			stored = append(stored, id)
+		}
+	}
+	if len(stored) == 0 {
+		return
+	}
+	var viewerID pgtype.UUID
+	if client != nil {
+		viewerID.Scan(client.UserID) // Stays invalid, matching no membership, without a user ID
+	}
+	rows, err := h.Repo.ListLatestMessagesByRooms(context.Background(), stored, viewerID)
+	if err != nil {
+		log.Printf("Failed to get last messages for the room list: %v", err)
+		return
+	}
+
+	previews := make(map[[16]byte]*types.MessagePreviewDTO, len(rows))
+	for _, row := range rows {
+		previews[row.RoomID.Bytes] = &types.MessagePreviewDTO{
+			ID:             uuid.UUID(row.MessageID.Bytes).String(),
+			Sender:         row.Username,
+			ContentPreview: truncatePreview(row.Content, replyPreviewLength),
+			Timestamp:      row.CreatedAt.Time.Format(time.RFC3339),
+		}
+	}
+	for i, id := range roomIDs {
+		if id.Valid {
+			roomList[i].LastMessage = previews[id.Bytes]
+		}
+	}
+}
+
+// LoadRoomsFromDB loads all rooms from the database into memory and makes
+// sure the default room exists. Stored memberships are kept as they are:
+// room_members records who belongs to a room, with roles and read positions,
+// while who is connected is only tracked in memory and so starts out empty,
+// even after an unclean shutdown.
 func (h *Hub) LoadRoomsFromDB() {
 	if h.Repo == nil {
 		return
@@ -664,17 +1328,57 @@ func (h *Hub) LoadRoomsFromDB() {
 	dbRooms, err := h.Repo.GetAllRooms(ctx)
 	if err != nil {
 		log.Printf("Failed to load rooms from DB: %v", err)
-		return
+	} else {
+		loaded := make([]*room.Room, len(dbRooms))
+		for i, dbRoom := range dbRooms {
+			loaded[i] = h.roomFromDB(dbRoom)
+			h.loadMemberRoles(ctx, loaded[i], dbRoom.ID)
+		}
+
+		h.Mutex.Lock()
+		for _, r := range loaded {
+			// Keep a cached default room so GetDefaultRoom stays in sync with Rooms
+			if _, exists := h.Rooms[r.Name]; exists && h.IsDefaultRoom(r.Name) {
+				continue
+			}
+			h.Rooms[r.Name] = r
+		}
+		h.Mutex.Unlock()
+
+		log.Printf("Loaded %d rooms from database", len(dbRooms))
 	}
 
-	h.Mutex.Lock()
-	for _, dbRoom := range dbRooms {
-		room := room.NewRoom(dbRoom.Name, dbRoom.Private.Bool, dbRoom.PasswordHash.String, 100)
-		room.ID = uuid.UUID(dbRoom.ID.Bytes).String()
-		// Creator not loaded, set to nil
-		h.Rooms[dbRoom.Name] = room
+	if _, err := h.GetDefaultRoom(); err != nil {
+		log.Printf("Failed to create the default room: %v", err)
 	}
-	h.Mutex.Unlock()
+}
+
+// storedCapacity returns a stored room's max_clients, or MaxClientsPerRoom
+// for rooms stored before it was kept
+func (h *Hub) storedCapacity(dbRoom db.Room) int {
+	if dbRoom.MaxClients.Valid && dbRoom.MaxClients.Int32 > 0 {
+		return int(dbRoom.MaxClients.Int32)
+	}
+	return h.Config().MaxClientsPerRoom
+}
+
+// loadMemberRoles copies the roles of a room's stored members into r
+func (h *Hub) loadMemberRoles(ctx context.Context, r *room.Room, roomID pgtype.UUID) {
+	members, err := h.Repo.GetRoomMembersWithRoles(ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to load member roles of room %s: %v", r.Name, err)
+		return
+	}
+	for _, m := range members {
+		r.SetMemberRole(uuid.UUID(m.ID.Bytes).String(), m.Role)
+	}
+}
 
-	log.Printf("Loaded %d rooms from database", len(dbRooms))
+// roomFromDB builds an in-memory room from its database row
+func (h *Hub) roomFromDB(dbRoom db.Room) *room.Room {
+	r := room.NewRoom(dbRoom.Name, dbRoom.Private.Bool, dbRoom.PasswordHash.String, h.storedCapacity(dbRoom))
+	r.SetID(uuid.UUID(dbRoom.ID.Bytes).String())
+	r.SuppressJoinLeaveMessages = dbRoom.SuppressJoinLeave
+	// Creator not loaded, set to nil
+	return r
 }
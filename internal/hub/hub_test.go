@@ -3,14 +3,25 @@ package hub
 import (
 	"context"
 	"fmt"
+	"log"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/repository/repositorytest"
 	"websocket-demo/internal/room"
 	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
 
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -208,9 +219,25 @@ func TestHubShutdown(t *testing.T) {
 		}
 	}
 
+	lobby, err := hub.CreateRoom("shutdown-room", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(clients[0], lobby, ""))
+	require.NoError(t, hub.JoinRoom(clients[1], lobby, ""))
+
 	// Cancel context to shut down hub
 	cancel()
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-hub.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub did not finish shutting down")
+	}
+
+	stats := hub.ShutdownStats()
+	assert.Equal(t, 5, stats.OpenConnections)
+	assert.Equal(t, 5, stats.Drained)
+	assert.Equal(t, 0, stats.Failed)
+	assert.Equal(t, map[string]int{"shutdown-room": 2}, stats.RoomCounts)
+	assert.Equal(t, int64(0), hub.Metrics.GetActiveConnections())
 }
 
 // TestConcurrentMapAccess tests concurrent map access
@@ -299,6 +326,547 @@ func TestJoinRoom(t *testing.T) {
 	cancel()
 }
 
+func TestJoinRoomBlocksRepeatedWrongPasswords(t *testing.T) {
+	t.Setenv("ROOM_PASSWORD_MAX_ATTEMPTS", "3")
+	t.Setenv("ROOM_PASSWORD_COOLDOWN", "50ms")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	var lockouts []int
+	hub.SetPasswordLockoutHandler(func(c *client.Client, roomName string, failures int) {
+		assert.Equal(t, "vault", roomName)
+		lockouts = append(lockouts, failures)
+	})
+
+	vault, err := hub.CreateRoom("vault", true, "secret", 10)
+	require.NoError(t, err)
+	other, err := hub.CreateRoom("other", true, "secret", 10)
+	require.NoError(t, err)
+	guesser := &client.Client{Name: "guesser", UserID: uuid.NewString(), Registered: make(chan struct{})}
+
+	for i := 0; i < 3; i++ {
+		require.EqualError(t, hub.JoinRoom(guesser, vault, "wrong"), "invalid password")
+	}
+	assert.Equal(t, []int{3}, lockouts)
+
+	// Blocked, even with the right password
+	err = hub.JoinRoom(guesser, vault, "secret")
+	var cooldown *PasswordCooldownError
+	require.ErrorAs(t, err, &cooldown)
+	assert.Positive(t, cooldown.RetryAfter)
+	assert.Zero(t, vault.GetClientCount())
+
+	// Attempts are tracked per room
+	require.NoError(t, hub.JoinRoom(guesser, other, "secret"))
+
+	// The block lifts after the cooldown and a right password resets the count
+	time.Sleep(60 * time.Millisecond)
+	require.EqualError(t, hub.JoinRoom(guesser, vault, "wrong"), "invalid password")
+	require.NoError(t, hub.JoinRoom(guesser, vault, "secret"))
+	for i := 0; i < 2; i++ {
+		require.EqualError(t, hub.JoinRoom(guesser, vault, "wrong"), "invalid password")
+	}
+	require.NoError(t, hub.JoinRoom(guesser, vault, "secret"))
+	assert.Equal(t, []int{3}, lockouts)
+}
+
+func TestLookupAndJoinRoomRacingDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	owner := &client.Client{Name: "owner", Registered: make(chan struct{})}
+	for i := 0; i < 50; i++ {
+		racer := &client.Client{Name: fmt.Sprintf("racer-%d", i), Registered: make(chan struct{})}
+		_, err := hub.CreateRoomAs(owner, "racing-room", false, "", 10)
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		var joinErr, deleteErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			joinErr = hub.LookupAndJoinRoom(racer, "racing-room", "")
+		}()
+		go func() {
+			defer wg.Done()
+			deleteErr = hub.DeleteRoom(owner, "racing-room")
+		}()
+		wg.Wait()
+
+		require.NoError(t, deleteErr)
+		if joinErr != nil {
+			require.ErrorIs(t, joinErr, ErrRoomNotFound)
+		}
+		// Whichever ran first, the racer isn't left in the deleted room
+		if current, ok := racer.GetCurrentRoom().(*room.Room); ok {
+			assert.True(t, hub.IsDefaultRoom(current.Name), "racer is in %s", current.Name)
+		}
+		_, exists := hub.GetRoom("racing-room")
+		assert.False(t, exists)
+	}
+
+	assert.ErrorIs(t, hub.LookupAndJoinRoom(owner, "missing", ""), ErrRoomNotFound)
+}
+
+func TestLookupAndJoinRoomAutoCreates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	alice := &client.Client{Name: "alice", UserID: uuid.UUID(user.ID.Bytes).String(), Registered: make(chan struct{})}
+	bob := &client.Client{Name: "bob", Registered: make(chan struct{})}
+
+	// Off by default
+	assert.ErrorIs(t, hub.LookupAndJoinRoom(alice, "ad-hoc", ""), ErrRoomNotFound)
+
+	cfg := hub.Config()
+	cfg.AutoCreateRooms = true
+	_, err = hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, hub.LookupAndJoinRoom(alice, "ad-hoc", "ignored"))
+	adHoc, exists := hub.GetRoom("ad-hoc")
+	require.True(t, exists)
+	assert.False(t, adHoc.Private)
+	assert.True(t, adHoc.IsCreator(alice))
+	assert.Equal(t, hub.Config().MaxClientsPerRoom, adHoc.MaxClients)
+	assert.Equal(t, adHoc, alice.GetCurrentRoom())
+
+	dbRoom, err := store.GetRoomByName(ctx, "ad-hoc")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, dbRoom.CreatorID)
+
+	// Later joiners join the existing room
+	require.NoError(t, hub.LookupAndJoinRoom(bob, "ad-hoc", ""))
+	assert.False(t, adHoc.IsCreator(bob))
+	assert.Equal(t, 2, adHoc.GetClientCount())
+
+	// Names that can't be created still fail
+	assert.EqualError(t, hub.LookupAndJoinRoom(bob, "system", ""), "room name is reserved")
+	_, exists = hub.GetRoom("system")
+	assert.False(t, exists)
+}
+
+func TestCreateRoomAsStoresCreatorMembership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	alice := &client.Client{Name: "alice", UserID: uuid.UUID(user.ID.Bytes).String(), Registered: make(chan struct{})}
+
+	owned, err := hub.CreateRoomAs(alice, "owned", false, "", 10)
+	require.NoError(t, err)
+	assert.Equal(t, alice, owned.Creator)
+	dbRoom, err := store.GetRoomByName(ctx, "owned")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, dbRoom.CreatorID)
+	members, err := store.GetRoomMembers(ctx, dbRoom.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "alice", members[0].Username)
+
+	// A creator missing from the database stores neither the room nor a membership
+	ghost := &client.Client{Name: "ghost", UserID: uuid.NewString(), Registered: make(chan struct{})}
+	_, err = hub.CreateRoomAs(ghost, "haunted", false, "", 10)
+	require.NoError(t, err, "the room still works in memory")
+	_, err = store.GetRoomByName(ctx, "haunted")
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestDisconnectMarksRoomRead(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+
+	aliceClient := &client.Client{Name: "alice", UserID: uuid.UUID(alice.ID.Bytes).String(), Registered: make(chan struct{})}
+	hub.Register <- aliceClient
+	<-aliceClient.Registered
+	require.NoError(t, hub.JoinRoom(aliceClient, lounge, ""))
+
+	dbRoom, err := store.GetRoomByName(ctx, "lounge")
+	require.NoError(t, err)
+	_, err = store.CreateMessage(ctx, dbRoom.ID, bob.ID, "seen live")
+	require.NoError(t, err)
+	unread := func() int64 {
+		summaries, err := store.ListUserRoomSummaries(ctx, alice.ID)
+		require.NoError(t, err)
+		require.Len(t, summaries, 1)
+		return summaries[0].UnreadCount
+	}
+	assert.Equal(t, int64(1), unread())
+
+	hub.unregisterClient(aliceClient)
+	assert.Zero(t, unread(), "messages delivered while connected count as read")
+}
+
+func TestGetRoomListLastMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	for _, name := range []string{"lounge", "quiet"} {
+		_, err := hub.CreateRoom(name, false, "", 10)
+		require.NoError(t, err)
+	}
+	_, err = hub.CreateRoom("vault", true, "secret", 10)
+	require.NoError(t, err)
+
+	lounge, err := store.GetRoomByName(ctx, "lounge")
+	require.NoError(t, err)
+	vault, err := store.GetRoomByName(ctx, "vault")
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoomMember(ctx, vault.ID, bob.ID, repository.RoomRoleMember))
+	_, err = store.CreateMessage(ctx, lounge.ID, bob.ID, "first")
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	latest, err := store.CreateMessage(ctx, lounge.ID, bob.ID, strings.Repeat("long ", 20))
+	require.NoError(t, err)
+	_, err = store.CreateMessage(ctx, vault.ID, bob.ID, "members only")
+	require.NoError(t, err)
+
+	previews := func(c *client.Client) map[string]*types.MessagePreviewDTO {
+		byName := map[string]*types.MessagePreviewDTO{}
+		for _, r := range hub.GetRoomList(c) {
+			byName[r.Name] = r.LastMessage
+		}
+		return byName
+	}
+
+	aliceView := previews(&client.Client{Name: "alice", UserID: uuid.UUID(alice.ID.Bytes).String()})
+	require.NotNil(t, aliceView["lounge"])
+	assert.Equal(t, uuid.UUID(latest.ID.Bytes).String(), aliceView["lounge"].ID)
+	assert.Equal(t, "bob", aliceView["lounge"].Sender)
+	assert.Len(t, aliceView["lounge"].ContentPreview, replyPreviewLength)
+	assert.Nil(t, aliceView["quiet"], "no messages yet")
+	assert.Nil(t, aliceView["vault"], "private rooms are only previewed for members")
+
+	bobView := previews(&client.Client{Name: "bob", UserID: uuid.UUID(bob.ID.Bytes).String()})
+	require.NotNil(t, bobView["vault"])
+	assert.Equal(t, "members only", bobView["vault"].ContentPreview)
+}
+
+func TestJoinRoomPersistsMembership(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+
+	testRoom, err := hub.CreateRoom("persisted", false, "", 10)
+	require.NoError(t, err)
+	dbRoom, err := store.GetRoomByName(ctx, "persisted")
+	require.NoError(t, err)
+	assert.Equal(t, uuid.UUID(dbRoom.ID.Bytes).String(), testRoom.ID)
+
+	alice := &client.Client{
+		Name:       "alice",
+		UserID:     uuid.UUID(user.ID.Bytes).String(),
+		Registered: make(chan struct{}),
+	}
+	require.NoError(t, hub.JoinRoom(alice, testRoom, ""))
+
+	members, err := store.GetRoomMembers(ctx, dbRoom.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "alice", members[0].Username)
+
+	require.NoError(t, hub.LeaveNamedRoom(alice, "persisted"))
+	count, err := store.GetRoomMemberCount(ctx, dbRoom.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestMemberRolesSurviveReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	creator, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	user, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	dbRoom, err := store.CreateRoomWithCreator(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, creator.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	bobID := uuid.UUID(user.ID.Bytes).String()
+	bob := &client.Client{Name: "bob", UserID: bobID, Registered: make(chan struct{})}
+
+	hub.LoadRoomsFromDB()
+	lounge := hub.Rooms["lounge"]
+	require.NotNil(t, lounge)
+	alice := &client.Client{Name: "alice", UserID: uuid.UUID(creator.ID.Bytes).String(), Registered: make(chan struct{})}
+	require.NoError(t, hub.JoinRoom(alice, lounge, ""), "the first to join becomes the creator")
+	require.NoError(t, hub.JoinRoom(bob, lounge, ""))
+	assert.Equal(t, repository.RoomRoleMember, lounge.MemberRole(bobID))
+	assert.False(t, canModerateMessages(bob, lounge))
+
+	// A promotion made elsewhere is picked up when the rooms are reloaded
+	require.NoError(t, store.SetRoomMemberRole(ctx, dbRoom.ID, user.ID, repository.RoomRoleModerator))
+	reloaded := NewHub(ctx, store, nil)
+	reloaded.LoadRoomsFromDB()
+	assert.Equal(t, repository.RoomRoleModerator, reloaded.Rooms["lounge"].MemberRole(bobID))
+	assert.True(t, canModerateMessages(bob, reloaded.Rooms["lounge"]))
+
+	// and when the member joins again, without demoting them
+	require.NoError(t, hub.LeaveNamedRoom(bob, "lounge"))
+	require.NoError(t, store.AddRoomMember(ctx, dbRoom.ID, user.ID, repository.RoomRoleMember))
+	require.NoError(t, store.SetRoomMemberRole(ctx, dbRoom.ID, user.ID, repository.RoomRoleModerator))
+	require.NoError(t, hub.JoinRoom(bob, lounge, ""))
+	assert.Equal(t, repository.RoomRoleModerator, lounge.MemberRole(bobID))
+
+	// A demotion persists across a reload too
+	require.NoError(t, store.SetRoomMemberRole(ctx, dbRoom.ID, user.ID, repository.RoomRoleMember))
+	reloaded = NewHub(ctx, store, nil)
+	reloaded.LoadRoomsFromDB()
+	assert.Equal(t, repository.RoomRoleMember, reloaded.Rooms["lounge"].MemberRole(bobID))
+	assert.False(t, canModerateMessages(bob, reloaded.Rooms["lounge"]))
+}
+
+func TestRoomCapacitySurvivesReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	_, err := hub.CreateRoom("booth", false, "", 3)
+	require.NoError(t, err)
+	stored, err := store.GetRoomByName(ctx, "booth")
+	require.NoError(t, err)
+	assert.Equal(t, pgtype.Int4{Int32: 3, Valid: true}, stored.MaxClients)
+	// Rooms stored before capacities were kept have none
+	_, err = store.CreateRoom(ctx, "hall", pgtype.Bool{}, pgtype.Text{}, pgtype.UUID{}, false, pgtype.Int4{})
+	require.NoError(t, err)
+
+	t.Setenv("MAX_CLIENTS_PER_ROOM", "40")
+	restarted := NewHub(ctx, store, nil)
+	restarted.LoadRoomsFromDB()
+	booth, exists := restarted.GetRoom("booth")
+	require.True(t, exists)
+	assert.Equal(t, 3, booth.MaxClients)
+	hall, exists := restarted.GetRoom("hall")
+	require.True(t, exists)
+	assert.Equal(t, 40, hall.MaxClients)
+}
+
+func TestSaveRoomMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	testRoom, err := hub.CreateRoom("saved", false, "", 10)
+	require.NoError(t, err)
+
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = uuid.UUID(user.ID.Bytes).String()
+
+	// Anonymous clients' messages aren't stored
+	messageID, err := hub.SaveRoomMessage(context.Background(), alice, testRoom, pgtype.UUID{}, "not stored", nil)
+	require.NoError(t, err)
+	assert.Empty(t, messageID)
+	assert.Empty(t, store.Messages())
+
+	alice.Authenticated = true
+	messageID, err = hub.SaveRoomMessage(context.Background(), alice, testRoom, pgtype.UUID{}, "hello", nil)
+	require.NoError(t, err)
+	assert.Empty(t, messageID, "without NATS the broadcast is relayed directly")
+
+	messages := store.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, "hello", messages[0].Content)
+	assert.Equal(t, user.ID, messages[0].UserID)
+	assert.Equal(t, testRoom.ID, uuid.UUID(messages[0].RoomID.Bytes).String())
+	assert.Empty(t, store.Outbox())
+
+	// Replies keep their parent
+	_, err = hub.SaveRoomMessage(context.Background(), alice, testRoom, messages[0].ID, "hi back", nil)
+	require.NoError(t, err)
+	messages = store.Messages()
+	require.Len(t, messages, 2)
+	assert.Equal(t, messages[0].ID, messages[1].ParentMessageID)
+}
+
+func TestSaveRoomMessageBatched(t *testing.T) {
+	t.Setenv("MESSAGE_BATCH_SIZE", "3")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	testRoom, err := hub.CreateRoom("batched", false, "", 10)
+	require.NoError(t, err)
+
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = uuid.UUID(user.ID.Bytes).String()
+	alice.Authenticated = true
+
+	save := func(content string) {
+		messageID, err := hub.SaveRoomMessage(context.Background(), alice, testRoom, pgtype.UUID{}, content, nil)
+		require.NoError(t, err)
+		assert.Empty(t, messageID)
+	}
+	save("one")
+	save("two")
+	assert.Empty(t, store.Messages(), "a partial batch waits for more messages")
+
+	// A full batch is stored at once, IDs and all
+	save("three")
+	require.Eventually(t, func() bool { return len(store.Messages()) == 3 }, time.Second, 5*time.Millisecond)
+	for i, msg := range store.Messages() {
+		assert.Equal(t, []string{"one", "two", "three"}[i], msg.Content)
+		assert.True(t, msg.ID.Valid)
+	}
+
+	// Shutting down stores what is left
+	save("four")
+	cancel()
+	<-hub.done
+	messages := store.Messages()
+	require.Len(t, messages, 4)
+	assert.Equal(t, "four", messages[3].Content)
+}
+
+func TestLeaveNamedRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	red, err := hub.CreateRoom("red", false, "", 10)
+	require.NoError(t, err)
+	_, err = hub.CreateRoom("blue", false, "", 10)
+	require.NoError(t, err)
+
+	alice := client.NewClient(nil, "alice")
+	require.NoError(t, hub.JoinRoom(alice, red, ""))
+
+	// Naming a room the client is not in fails and leaves it where it was
+	assert.EqualError(t, hub.LeaveNamedRoom(alice, "blue"), `you are not in room "blue"`)
+	assert.EqualError(t, hub.LeaveNamedRoom(alice, "missing"), `you are not in room "missing"`)
+	assert.Same(t, red, alice.GetCurrentRoom())
+
+	require.NoError(t, hub.LeaveNamedRoom(alice, " red "))
+	assert.Nil(t, alice.GetCurrentRoom())
+	assert.Equal(t, 0, red.GetClientCount())
+
+	// Without a name the current room is left, as before
+	require.NoError(t, hub.JoinRoom(alice, red, ""))
+	require.NoError(t, hub.LeaveNamedRoom(alice, ""))
+	assert.Nil(t, alice.GetCurrentRoom())
+	assert.NoError(t, hub.LeaveNamedRoom(alice, ""), "leaving with no room stays a no-op")
+}
+
+func TestMoveUser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lobby, err := hub.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	support, err := hub.CreateRoom("support", true, "secret", 2)
+	require.NoError(t, err)
+
+	agent, _ := newConnectedClient(t, "agent", "user-agent")
+	bob, bobPeer := newConnectedClient(t, "bob", "user-bob")
+	watcher, watcherPeer := newConnectedClient(t, "watcher", "user-watcher")
+	for _, c := range []*client.Client{agent, bob, watcher} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	support.SetCreator(agent)
+	require.NoError(t, hub.JoinRoom(bob, lobby, ""))
+	require.NoError(t, hub.JoinRoom(watcher, lobby, ""))
+
+	// Only admins and the target room's creator may move users
+	_, err = hub.MoveUser(watcher, "user-bob", "support")
+	assert.ErrorIs(t, err, ErrMoveNotAllowed)
+	_, err = hub.MoveUser(agent, "user-nobody", "support")
+	assert.ErrorIs(t, err, ErrMoveUserNotConnected)
+	_, err = hub.MoveUser(agent, "user-bob", "missing")
+	assert.EqualError(t, err, "room does not exist")
+
+	// The move skips the password and notifies both rooms
+	moved, err := hub.MoveUser(agent, "user-bob", "support")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	assert.Same(t, support, bob.GetCurrentRoom())
+	assert.False(t, lobby.HasClient(bob))
+	assert.True(t, support.HasClient(bob))
+	hub.Mutex.RLock()
+	assert.Same(t, support, hub.ClientRooms[bob])
+	hub.Mutex.RUnlock()
+	assert.True(t, readUntil(watcherPeer, "bob has left the room", 5*time.Second))
+	assert.True(t, readUntil(bobPeer, "You were moved to room 'support' by agent", 5*time.Second))
+
+	_, err = hub.MoveUser(agent, "user-bob", "support")
+	assert.ErrorIs(t, err, ErrAlreadyInRoom)
+
+	// A full target leaves the user where they were
+	admin := client.NewClient(nil, "admin")
+	admin.Admin = true
+	filler := client.NewClient(nil, "filler")
+	require.NoError(t, hub.MoveClient(filler, "support"))
+	_, err = hub.MoveUser(admin, "user-watcher", "support")
+	assert.EqualError(t, err, "room is full")
+	assert.Same(t, lobby, watcher.GetCurrentRoom())
+	assert.True(t, lobby.HasClient(watcher))
+
+	// Admins may move users into rooms they didn't create
+	moved, err = hub.MoveUser(admin, "user-bob", "lobby")
+	require.NoError(t, err)
+	assert.Equal(t, 1, moved)
+	assert.Same(t, lobby, bob.GetCurrentRoom())
+}
+
 // TestBroadcastToRoom tests broadcasting to a room
 func TestBroadcastToRoom(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -336,3 +904,793 @@ func TestBroadcastToRoom(t *testing.T) {
 
 	cancel()
 }
+
+// TestRoomMetrics tests that room activity is recorded in the hub's metrics
+func TestRoomMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+
+	testRoom, err := hub.CreateRoom("metrics-room", false, "", 100)
+	require.NoError(t, err)
+
+	testClient := &client.Client{
+		Name:       "MetricsClient",
+		Registered: make(chan struct{}),
+	}
+
+	require.NoError(t, hub.JoinRoom(testClient, testRoom, ""))
+	hub.BroadcastToRoom(testRoom, types.Message{Content: []byte("hi"), Type: types.MsgTypeRoomMessage})
+	hub.LeaveRoom(testClient)
+
+	stats := hub.Metrics.GetRoomStats("metrics-room")
+	assert.Equal(t, int64(1), stats.Joins)
+	assert.Equal(t, int64(1), stats.Leaves)
+	assert.Equal(t, int64(1), stats.Messages)
+}
+
+func TestResolveReply(t *testing.T) {
+	hub := NewHub(context.Background(), nil, nil)
+
+	roomA := room.NewRoom("room-a", false, "", 10)
+	roomA.ID = "11111111-1111-1111-1111-111111111111"
+	roomB := room.NewRoom("room-b", false, "", 10)
+	roomB.ID = "22222222-2222-2222-2222-222222222222"
+
+	parentID := "33333333-3333-3333-3333-333333333333"
+	longContent := strings.Repeat("abcdefghij", 8)
+	lookups := 0
+	hub.lookupReplyTarget = func(ctx context.Context, id pgtype.UUID) (replyTarget, error) {
+		lookups++
+		return replyTarget{
+			ID:        id,
+			RoomID:    roomA.ID,
+			Sender:    "alice",
+			Content:   longContent,
+			CreatedAt: time.Now(),
+		}, nil
+	}
+
+	t.Run("rejects parent from another room", func(t *testing.T) {
+		_, preview, err := hub.ResolveReply(roomB, parentID)
+		assert.ErrorIs(t, err, ErrReplyNotInRoom)
+		assert.Nil(t, preview)
+	})
+
+	t.Run("truncates preview to 50 characters", func(t *testing.T) {
+		parentUUID, preview, err := hub.ResolveReply(roomA, parentID)
+		require.NoError(t, err)
+		require.NotNil(t, preview)
+		assert.True(t, parentUUID.Valid)
+		assert.Equal(t, parentID, preview.ID)
+		assert.Equal(t, "alice", preview.Sender)
+		assert.Equal(t, longContent[:50], preview.ContentPreview)
+	})
+
+	t.Run("caches parent per room", func(t *testing.T) {
+		before := lookups
+		_, _, err := hub.ResolveReply(roomA, parentID)
+		require.NoError(t, err)
+		assert.Equal(t, before, lookups)
+	})
+
+	t.Run("rejects invalid parent ID", func(t *testing.T) {
+		_, _, err := hub.ResolveReply(roomA, "not-a-uuid")
+		assert.Error(t, err)
+	})
+}
+
+func TestConcurrentJoinRoomSemaphore(t *testing.T) {
+	t.Setenv("MAX_CONCURRENT_ROOM_OPS", "8")
+	t.Setenv("MAX_CLIENTS_PER_ROOM", "500")
+	hub := NewHub(context.Background(), nil, nil)
+	assert.Equal(t, 8, cap(hub.roomOpSem))
+
+	testRoom, err := hub.CreateRoom("busy-room", false, "", 500)
+	require.NoError(t, err)
+
+	const numClients = 200
+	var wg sync.WaitGroup
+	errs := make(chan error, numClients)
+	for i := 0; i < numClients; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			errs <- hub.JoinRoom(client.NewClient(nil, fmt.Sprintf("client-%d", id)), testRoom, "")
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("concurrent joins deadlocked")
+	}
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, numClients, testRoom.GetClientCount())
+	assert.Equal(t, int64(0), hub.Metrics.GetRoomOpQueueDepth())
+	assert.Empty(t, hub.roomOpSem)
+}
+
+func TestPrivateRoomStoresPasswordHash(t *testing.T) {
+	hub := NewHub(context.Background(), nil, nil)
+
+	privateRoom, err := hub.CreateRoom("private-room", true, "open-sesame", 10)
+	require.NoError(t, err)
+	assert.NotEqual(t, "open-sesame", privateRoom.Password)
+
+	assert.Error(t, hub.JoinRoom(client.NewClient(nil, "mallory"), privateRoom, "wrong"))
+	assert.NoError(t, hub.JoinRoom(client.NewClient(nil, "alice"), privateRoom, "open-sesame"))
+}
+
+func TestConcurrentUnregister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	// Drain leave notifications so workers never block on Broadcast
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hub.Broadcast:
+			}
+		}
+	}()
+
+	const numClients = 100
+	clients := make([]*client.Client, numClients)
+	hub.Mutex.Lock()
+	for i := range clients {
+		clients[i] = client.NewClient(nil, fmt.Sprintf("client-%d", i))
+		hub.Clients[clients[i]] = true
+		hub.UserCount++
+	}
+	keeper := client.NewClient(nil, "keeper")
+	hub.Clients[keeper] = true
+	hub.UserCount++
+	hub.Mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		wg.Add(1)
+		go func(c *client.Client) {
+			defer wg.Done()
+			hub.Unregister <- c
+		}(c)
+	}
+	wg.Wait()
+
+	require.Eventually(t, func() bool {
+		hub.Mutex.RLock()
+		defer hub.Mutex.RUnlock()
+		return len(hub.Clients) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	hub.Mutex.RLock()
+	defer hub.Mutex.RUnlock()
+	assert.True(t, hub.Clients[keeper])
+	assert.Equal(t, 1, hub.UserCount)
+}
+
+func TestSuppressJoinLeaveMessages(t *testing.T) {
+	t.Setenv("SUPPRESS_JOIN_LEAVE_DEFAULT", "true")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	quiet, err := hub.CreateRoom("quiet-room", false, "", 100)
+	require.NoError(t, err)
+	policy, err := hub.GetRoomPolicy("quiet-room")
+	require.NoError(t, err)
+	assert.True(t, policy.SuppressJoinLeave)
+
+	clients := make([]*client.Client, 5)
+	peers := make([]*websocket.Conn, 5)
+	for i := range clients {
+		clients[i], peers[i] = newConnectedClient(t, fmt.Sprintf("member-%d", i), "")
+		require.NoError(t, hub.JoinRoom(clients[i], quiet, ""))
+	}
+
+	// Everything a member receives before the marker must be free of join notifications
+	hub.BroadcastToRoom(quiet, types.Message{Content: []byte("marker"), Type: types.MsgTypeRoomMessage})
+	for i, peer := range peers {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		for {
+			_, data, err := peer.Read(ctx)
+			require.NoError(t, err, "member-%d did not receive the marker", i)
+			assert.NotContains(t, string(data), "has joined the room")
+			if strings.Contains(string(data), "marker") {
+				break
+			}
+		}
+		cancel()
+	}
+
+	// Only the creator may turn notifications back on
+	assert.Error(t, hub.SetRoomSuppressJoinLeave(clients[1], "quiet-room", false))
+	require.NoError(t, hub.SetRoomSuppressJoinLeave(clients[0], "quiet-room", false))
+
+	late, _ := newConnectedClient(t, "late", "")
+	require.NoError(t, hub.JoinRoom(late, quiet, ""))
+	assert.True(t, readUntil(peers[0], "late has joined the room", 5*time.Second))
+}
+
+func TestChangeRoomPassword(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	owner, err := store.CreateUser(ctx, "owner", "owner@example.com", "hash")
+	require.NoError(t, err)
+	creator, creatorPeer := newConnectedClient(t, "owner", uuid.UUID(owner.ID.Bytes).String())
+	vault, err := hub.CreateRoomAs(creator, "vault", true, "old-secret", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(creator, vault, "old-secret"))
+	mallory, _ := newConnectedClient(t, "mallory", "user-mallory")
+
+	assert.ErrorIs(t, hub.ChangeRoomPassword(mallory, "vault", "old-secret", "new-secret"), ErrNotRoomCreator)
+	assert.ErrorIs(t, hub.ChangeRoomPassword(creator, "vault", "wrong", "new-secret"), ErrWrongRoomPassword)
+	assert.ErrorIs(t, hub.ChangeRoomPassword(creator, "vault", "old-secret", ""), ErrRoomPasswordRequired)
+	assert.Error(t, hub.ChangeRoomPassword(creator, "vault", "old-secret", "abc"), "too short")
+	assert.ErrorIs(t, hub.ChangeRoomPassword(creator, "attic", "old-secret", "new-secret"), ErrRoomNotFound)
+	_, err = hub.CreateRoomAs(creator, "open", false, "", 10)
+	require.NoError(t, err)
+	assert.ErrorIs(t, hub.ChangeRoomPassword(creator, "open", "", "new-secret"), ErrRoomNotPrivate)
+
+	require.NoError(t, hub.ChangeRoomPassword(creator, "vault", "old-secret", "new-secret"))
+	assert.True(t, readUntil(creatorPeer, `"type":"room_password_changed"`, 5*time.Second))
+
+	// Joins check the new password from now on
+	guest, _ := newConnectedClient(t, "guest", "user-guest")
+	assert.Error(t, hub.JoinRoom(guest, vault, "old-secret"))
+	require.NoError(t, hub.JoinRoom(guest, vault, "new-secret"))
+
+	// The new hash is stored, so it survives a restart
+	stored, err := store.GetRoomByName(ctx, "vault")
+	require.NoError(t, err)
+	assert.True(t, hub.VerifyPassword("new-secret", stored.PasswordHash.String))
+	assert.NotContains(t, stored.PasswordHash.String, "new-secret")
+}
+
+func TestUserSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	laptop, _ := newConnectedClient(t, "alice", "user-alice")
+	laptop.RemoteAddr = "10.0.0.1"
+	laptop.UserAgent = "Firefox"
+	phone, phonePeer := newConnectedClient(t, "alice", "user-alice")
+	phone.RemoteAddr = "10.0.0.2"
+	phone.UserAgent = "Mobile Safari"
+	phone.ConnectedAt = laptop.ConnectedAt.Add(time.Second)
+	mallory, _ := newConnectedClient(t, "mallory", "user-mallory")
+
+	for _, c := range []*client.Client{laptop, phone, mallory} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(phone, lounge, ""))
+
+	sessions := hub.ListSessions(laptop)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, laptop.SessionID, sessions[0].ID)
+	assert.Equal(t, laptop.ID, sessions[0].ConnID)
+	assert.True(t, sessions[0].Current)
+	assert.Equal(t, "Firefox", sessions[0].UserAgent)
+	assert.Equal(t, phone.SessionID, sessions[1].ID)
+	assert.False(t, sessions[1].Current)
+	assert.Equal(t, "10.0.0.2", sessions[1].IP)
+	assert.Equal(t, "lounge", sessions[1].Room)
+
+	// Users can only terminate their own other sessions
+	assert.ErrorIs(t, hub.TerminateSession(mallory, phone.SessionID), ErrSessionNotFound)
+	assert.ErrorIs(t, hub.TerminateSession(laptop, laptop.SessionID), ErrTerminateCurrentSession)
+	require.NoError(t, hub.TerminateSession(laptop, phone.SessionID))
+
+	readCtx, readCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readCancel()
+	var readErr error
+	for readErr == nil {
+		_, _, readErr = phonePeer.Read(readCtx)
+	}
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(readErr))
+	require.Eventually(t, func() bool { return len(hub.ListSessions(laptop)) == 1 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestListMembersJoinTimes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+
+	restored, _ := newConnectedClient(t, "alice", "user-alice")
+	fresh, _ := newConnectedClient(t, "bob", "user-bob")
+	for _, c := range []*client.Client{restored, fresh} {
+		hub.Register <- c
+		<-c.Registered
+	}
+
+	joinedAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	require.True(t, lounge.AddClientWithTimestamp(restored, joinedAt))
+	restored.SetCurrentRoom(lounge)
+	require.NoError(t, hub.JoinRoom(fresh, lounge, ""))
+
+	members, err := hub.ListMembers("lounge")
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "alice", members[0].Name)
+	assert.Equal(t, "2024-03-01T09:30:00Z", members[0].JoinedAt)
+	assert.Equal(t, "bob", members[1].Name)
+	assert.Equal(t, fresh.GetJoinedAt().Format(time.RFC3339), members[1].JoinedAt)
+}
+
+func TestListClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+
+	laptop, _ := newConnectedClient(t, "alice", "user-alice")
+	phone, _ := newConnectedClient(t, "alice", "user-alice")
+	bob, _ := newConnectedClient(t, "bob", "user-bob")
+	for _, c := range []*client.Client{laptop, phone, bob} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	joinedAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	require.True(t, lounge.AddClientWithTimestamp(phone, joinedAt))
+	phone.SetCurrentRoom(lounge)
+	require.NoError(t, hub.JoinRoom(laptop, lounge, ""))
+	require.NoError(t, hub.JoinRoom(bob, lounge, ""))
+	lounge.SetCreator(laptop)
+	lounge.SetMemberRole("user-bob", repository.RoomRoleModerator)
+
+	members, err := hub.ListClients("lounge")
+	require.NoError(t, err)
+	require.Len(t, members, 2, "one entry per user")
+	assert.Equal(t, types.RoomMemberDTO{UserID: "user-alice", Name: "alice", Status: types.UserStatusOnline, JoinedAt: joinedAt, IsCreator: true}, members[0])
+	assert.Equal(t, "bob", members[1].Name)
+	assert.True(t, members[1].IsModerator)
+	assert.False(t, members[1].IsCreator)
+
+	// A disconnected client leaves the list
+	hub.Unregister <- bob
+	require.Eventually(t, func() bool {
+		members, err := hub.ListClients("lounge")
+		return err == nil && len(members) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Nil(t, bob.GetCurrentRoom())
+
+	_, err = hub.ListClients("missing")
+	assert.ErrorIs(t, err, ErrRoomNotFound)
+}
+
+func TestRestartAfterCrashShowsMembersOffline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	userID := uuid.UUID(user.ID.Bytes).String()
+
+	// Alice is in the room, as a moderator, when the server dies without
+	// running any disconnect handling
+	crashedCtx, crash := context.WithCancel(ctx)
+	crashed := NewHub(crashedCtx, store, nil)
+	lounge, err := crashed.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+	alice := &client.Client{Name: "alice", UserID: userID, Registered: make(chan struct{})}
+	require.NoError(t, crashed.JoinRoom(alice, lounge, ""))
+	var roomID pgtype.UUID
+	require.NoError(t, roomID.Scan(lounge.ID))
+	require.NoError(t, store.SetRoomMemberRole(ctx, roomID, user.ID, repository.RoomRoleModerator))
+	crash()
+
+	// room_members is durable membership, so it survives the restart with
+	// its role; who is connected lives in memory and starts out empty
+	restarted := NewHub(ctx, store, nil)
+	restarted.LoadRoomsFromDB()
+	var listed *types.RoomDTO
+	for _, dto := range restarted.GetRoomList(nil) {
+		if dto.Name == "lounge" {
+			listed = &dto
+		}
+	}
+	require.NotNil(t, listed)
+	assert.Equal(t, 1, listed.MemberCount)
+	assert.Zero(t, listed.OnlineCount)
+	assert.Zero(t, listed.ClientCount)
+
+	members, err := restarted.ListMembers("lounge")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, "alice", members[0].Name)
+	assert.False(t, members[0].Online)
+
+	reloaded, ok := restarted.GetRoom("lounge")
+	require.True(t, ok)
+	assert.Equal(t, repository.RoomRoleModerator, reloaded.MemberRole(userID))
+}
+
+func TestCreateRoomRejectsReservedNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+
+	for _, name := range []string{"default", "Admin", " system "} {
+		_, err := hub.CreateRoom(name, false, "", 10)
+		assert.EqualError(t, err, "room name is reserved", name)
+	}
+
+	v, err := validator.New(validator.Config{ReservedRoomNames: []string{"staff", "ops"}})
+	require.NoError(t, err)
+	hub.SetValidator(v)
+	_, err = hub.CreateRoom("ops", false, "", 10)
+	assert.EqualError(t, err, "room name is reserved")
+	_, err = hub.CreateRoom("admin", false, "", 10)
+	assert.NoError(t, err, "configured list replaces the defaults")
+	_, err = hub.CreateRoom("default", false, "", 10)
+	assert.EqualError(t, err, "room name is reserved", "the default room stays protected")
+}
+
+func TestCreateRoomRejectsLookAlikeNames(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	_, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+
+	// Cyrillic о, fullwidth letters and a different case all read as "lounge"
+	for _, name := range []string{"lоunge", "ｌｏｕｎｇｅ", "Lounge"} {
+		_, err := hub.CreateRoom(name, false, "", 10)
+		assert.ErrorIs(t, err, ErrRoomNameConfusable, name)
+	}
+	_, err = hub.CreateRoom("lounge", false, "", 10)
+	assert.ErrorIs(t, err, repository.ErrRoomExists, "an exact match is still reported as existing")
+	_, err = hub.CreateRoom("休息室", false, "", 10)
+	assert.NoError(t, err)
+}
+
+func TestDefaultRoomIsProtected(t *testing.T) {
+	t.Setenv("DEFAULT_ROOM_NAME", "lobby")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lobby, err := hub.GetDefaultRoom()
+	require.NoError(t, err)
+	again, err := hub.GetDefaultRoom()
+	require.NoError(t, err)
+	assert.Same(t, lobby, again, "the default room is created once")
+
+	// It cannot be recreated under any casing
+	_, err = hub.CreateRoom("lobby", false, "", 10)
+	assert.Error(t, err)
+	_, err = hub.CreateRoom("Lobby", false, "", 10)
+	assert.Error(t, err)
+
+	// Joining does not make anyone its owner, and nobody can delete it
+	alice, alicePeer := newConnectedClient(t, "alice", "")
+	require.NoError(t, hub.JoinRoom(alice, lobby, ""))
+	assert.Nil(t, lobby.Creator)
+	assert.ErrorIs(t, hub.DeleteRoom(alice, "lobby"), ErrDefaultRoomProtected)
+
+	// Members of a deleted room land in the default room
+	side, err := hub.CreateRoom("side", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(alice, side, ""))
+	bob, _ := newConnectedClient(t, "bob", "")
+	require.NoError(t, hub.JoinRoom(bob, side, ""))
+
+	require.NoError(t, hub.DeleteRoom(alice, "side"))
+	assert.True(t, readUntil(alicePeer, "because it was deleted", 5*time.Second))
+	for _, c := range []*client.Client{alice, bob} {
+		current, ok := c.GetCurrentRoom().(*room.Room)
+		require.True(t, ok, "%s has no room", c.Name)
+		assert.Same(t, lobby, current)
+	}
+	_, exists := hub.GetRoom("lobby")
+	assert.True(t, exists)
+}
+
+func TestGetDefaultRoomConcurrent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+
+	const callers = 100
+	rooms := make([]*room.Room, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r, err := hub.GetDefaultRoom()
+			assert.NoError(t, err)
+			rooms[i] = r
+		}(i)
+	}
+	wg.Wait()
+
+	for _, r := range rooms {
+		assert.Same(t, rooms[0], r)
+	}
+	stored, err := store.GetAllRooms(ctx)
+	require.NoError(t, err)
+	count := 0
+	for _, r := range stored {
+		if r.Name == DefaultRoomName {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "the default room is created exactly once")
+
+	// Loading rooms afterwards keeps the cached default room
+	hub.LoadRoomsFromDB()
+	current, exists := hub.GetRoom(DefaultRoomName)
+	require.True(t, exists)
+	assert.Same(t, rooms[0], current)
+}
+
+// fakeRoomStore is an in-memory roomStore shared by several hubs, enforcing
+// unique room names like the database does
+type fakeRoomStore struct {
+	mu      sync.Mutex
+	rooms   map[string]db.Room
+	racers  int
+	checks  int
+	checked sync.WaitGroup // Holds the first existence checks until all racers have run one
+}
+
+func newFakeRoomStore(racers int) *fakeRoomStore {
+	f := &fakeRoomStore{rooms: make(map[string]db.Room), racers: racers}
+	f.checked.Add(racers)
+	return f
+}
+
+func (f *fakeRoomStore) GetRoomByName(ctx context.Context, name string) (db.Room, error) {
+	f.mu.Lock()
+	f.checks++
+	racing := f.checks <= f.racers
+	r, ok := f.rooms[name]
+	f.mu.Unlock()
+	if racing {
+		f.checked.Done()
+		f.checked.Wait()
+	}
+
+	if !ok {
+		return db.Room{}, pgx.ErrNoRows
+	}
+	return r, nil
+}
+
+func (f *fakeRoomStore) CreateRoom(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.rooms[name]; ok {
+		return db.Room{}, repository.ErrRoomExists
+	}
+	r := db.Room{
+		ID:                pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		Name:              name,
+		Private:           private,
+		PasswordHash:      passwordHash,
+		CreatorID:         creatorID,
+		SuppressJoinLeave: suppressJoinLeave,
+		MaxClients:        maxClients,
+	}
+	f.rooms[name] = r
+	return r, nil
+}
+
+func (f *fakeRoomStore) CountRooms(ctx context.Context, excludedName string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var count int64
+	for name := range f.rooms {
+		if name != excludedName {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeRoomStore) CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var count int64
+	for _, r := range f.rooms {
+		if r.CreatorID == creatorID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeRoomStore) CreateRoomWithCreator(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error) {
+	return f.CreateRoom(ctx, name, private, passwordHash, creatorID, suppressJoinLeave, maxClients)
+}
+
+func TestCreateRoomConcurrentAcrossServers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two servers sharing one database both pass the existence check
+	// before either inserts
+	store := newFakeRoomStore(2)
+	hubs := []*Hub{NewHub(ctx, nil, nil), NewHub(ctx, nil, nil)}
+	for _, h := range hubs {
+		h.rooms = store
+	}
+
+	rooms := make([]*room.Room, len(hubs))
+	errs := make([]error, len(hubs))
+	var wg sync.WaitGroup
+	for i, h := range hubs {
+		wg.Add(1)
+		go func(i int, h *Hub) {
+			defer wg.Done()
+			rooms[i], errs[i] = h.CreateRoom("race", false, "", 10)
+		}(i, h)
+	}
+	wg.Wait()
+
+	winner, loser := 0, 1
+	if errs[0] != nil {
+		winner, loser = 1, 0
+	}
+	require.NoError(t, errs[winner])
+	assert.ErrorIs(t, errs[loser], repository.ErrRoomExists)
+	assert.Nil(t, rooms[loser])
+
+	// The losing server adopts the stored room rather than keeping its own
+	stored := store.rooms["race"]
+	adopted, exists := hubs[loser].GetRoom("race")
+	require.True(t, exists)
+	assert.Equal(t, uuid.UUID(stored.ID.Bytes).String(), adopted.ID)
+	assert.Equal(t, rooms[winner].ID, adopted.ID)
+}
+
+func TestRoomLimitsAcrossServers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two servers sharing one database without NATS, so neither sees the
+	// other's rooms in memory
+	store := newFakeRoomStore(0)
+	hubs := []*Hub{NewHub(ctx, nil, nil), NewHub(ctx, nil, nil)}
+	for _, h := range hubs {
+		h.rooms = store
+		cfg := h.Config()
+		cfg.MaxRooms = 3
+		cfg.MaxRoomsPerUser = 2
+		_, err := h.ReloadConfig(cfg)
+		require.NoError(t, err)
+	}
+	_, err := hubs[0].GetDefaultRoom()
+	require.NoError(t, err)
+
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = uuid.NewString()
+	_, err = hubs[0].CreateRoomAs(alice, "first", false, "", 10)
+	require.NoError(t, err)
+	_, err = hubs[1].CreateRoomAs(alice, "second", false, "", 10)
+	require.NoError(t, err)
+	_, err = hubs[1].CreateRoomAs(alice, "third", false, "", 10)
+	assert.ErrorIs(t, err, ErrRoomQuotaReached, "rooms made on the other server count toward the quota")
+
+	admin := client.NewClient(nil, "root")
+	admin.UserID = uuid.NewString()
+	admin.Admin = true
+	_, err = hubs[0].CreateRoomAs(admin, "admin-1", false, "", 10)
+	assert.NoError(t, err, "admins have no quota")
+	_, err = hubs[0].CreateRoomAs(admin, "admin-2", false, "", 10)
+	assert.ErrorIs(t, err, ErrMaxRoomsReached, "but the global limit holds, leaving out the default room")
+	_, err = hubs[1].CreateRoom("lounge", false, "", 10)
+	assert.ErrorIs(t, err, ErrMaxRoomsReached, "on every server")
+}
+
+// logBuffer collects log output from any goroutine
+type logBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the logged lines containing want
+func (b *logBuffer) lines(want string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		if strings.Contains(line, want) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// captureLogs sends the standard logger's output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return logs
+}
+
+func TestBroadcastToRoomLogsConnectionIDs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := NewHub(ctx, nil, nil)
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+
+	alice, _ := newConnectedClient(t, "alice", "user-alice")
+	bob, _ := newConnectedClient(t, "bob", "user-bob")
+	require.NotEqual(t, alice.ID, bob.ID)
+	require.True(t, lounge.AddClient(alice))
+	require.True(t, lounge.AddClient(bob))
+
+	logs := captureLogs(t)
+	hub.BroadcastToRoom(lounge, types.Message{Content: []byte("hello"), Sender: alice, Type: types.MsgTypeRoomMessage, Room: lounge})
+
+	for _, tc := range []struct {
+		line   string
+		client *client.Client
+	}{
+		{"Total clients in room", alice},
+		{"Skipping sender alice", alice},
+		{"Sent message to client bob", bob},
+	} {
+		lines := logs.lines(tc.line)
+		require.Len(t, lines, 1, tc.line)
+		assert.True(t, strings.HasSuffix(lines[0], "conn_id="+tc.client.ID), lines[0])
+	}
+}
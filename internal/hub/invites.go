@@ -0,0 +1,100 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	// ErrInviteUnauthenticated is returned when an anonymous client sends an invite
+	ErrInviteUnauthenticated = errors.New("invites require an authenticated user")
+	// ErrInviteNotInRoom is returned when inviting someone to a room the inviter isn't in
+	ErrInviteNotInRoom = errors.New("you can only invite users to a room you are in")
+	// ErrInviteSelf is returned when a user invites themselves
+	ErrInviteSelf = errors.New("you cannot invite yourself")
+)
+
+// inviteStore is the subset of the repository used to record room invites
+type inviteStore interface {
+	GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error)
+	CreateRoomInvite(ctx context.Context, roomID, inviterID, inviteeID pgtype.UUID) (db.RoomInvite, error)
+}
+
+// SendInvite records an invite of inviteeID to the named room, which the
+// inviter must be in, and sends invite_received to the invitee's sessions on
+// every server. It reports whether any session received the notification.
+func (h *Hub) SendInvite(inviter *clientpkg.Client, roomName, inviteeID string) (bool, error) {
+	if inviter.UserID == "" {
+		return false, ErrInviteUnauthenticated
+	}
+	if h.invites == nil {
+		return false, errors.New("invites need a database")
+	}
+	if inviteeID == inviter.UserID {
+		return false, ErrInviteSelf
+	}
+
+	targetRoom, exists := h.GetRoom(roomName)
+	if !exists {
+		return false, ErrRoomNotFound
+	}
+	if !targetRoom.HasClient(inviter) {
+		return false, ErrInviteNotInRoom
+	}
+
+	ctx := context.Background()
+	var roomID, inviterID, invitee pgtype.UUID
+	if err := roomID.Scan(targetRoom.GetID()); err != nil {
+		return false, fmt.Errorf("room %s is not stored: %w", roomName, err)
+	}
+	if err := inviterID.Scan(inviter.UserID); err != nil {
+		return false, ErrInviteUnauthenticated
+	}
+	if err := invitee.Scan(inviteeID); err != nil {
+		return false, ErrUserNotFound
+	}
+	if _, err := h.invites.GetUserByID(ctx, invitee); errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrUserNotFound
+	} else if err != nil {
+		return false, err
+	}
+
+	invite, err := h.invites.CreateRoomInvite(ctx, roomID, inviterID, invitee)
+	if err != nil {
+		return false, fmt.Errorf("failed to save invite: %w", err)
+	}
+	log.Printf("%s invited user %s to room %s conn_id=%s", inviter.Name, inviteeID, roomName, inviter.ID)
+
+	payload, err := json.Marshal(types.InviteReceivedDTO{
+		Type:      types.MsgTypeInviteReceived,
+		InviteID:  uuid.UUID(invite.ID.Bytes).String(),
+		Room:      targetRoom.Name,
+		Private:   targetRoom.Private,
+		From:      inviter.Name,
+		FromID:    inviter.UserID,
+		InvitedAt: invite.CreatedAt.Time.Format(time.RFC3339),
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal invite: %w", err)
+	}
+	return h.sendToUser(natsclient.UserSubject(inviteeID), inviteeID, types.Message{
+		Content:    payload,
+		Type:       types.MsgTypeInviteReceived,
+		SenderID:   inviter.UserID,
+		SenderName: inviter.Name,
+		Timestamp:  time.Now(),
+	}), nil
+}
@@ -0,0 +1,63 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendInvite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	aliceUser, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bobUser, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	carolUser, err := store.CreateUser(ctx, "carol", "carol@example.com", "hash")
+	require.NoError(t, err)
+	bobID := uuid.UUID(bobUser.ID.Bytes).String()
+
+	alice, _ := newConnectedClient(t, "alice", uuid.UUID(aliceUser.ID.Bytes).String())
+	bob, bobPeer := newConnectedClient(t, "bob", bobID)
+	for _, c := range []*client.Client{alice, bob} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	vault, err := hub.CreateRoomAs(alice, "vault", true, "secret", 10)
+	require.NoError(t, err)
+
+	_, err = hub.SendInvite(alice, "vault", bobID)
+	assert.ErrorIs(t, err, ErrInviteNotInRoom, "only members of the room can invite")
+	require.NoError(t, hub.JoinRoom(alice, vault, "secret"))
+
+	delivered, err := hub.SendInvite(alice, "vault", bobID)
+	require.NoError(t, err)
+	assert.True(t, delivered)
+	assert.True(t, readUntil(bobPeer, `"type":"invite_received"`, 5*time.Second))
+
+	// Invites to offline users are still saved
+	delivered, err = hub.SendInvite(alice, "vault", uuid.UUID(carolUser.ID.Bytes).String())
+	require.NoError(t, err)
+	assert.False(t, delivered)
+
+	_, err = hub.SendInvite(alice, "vault", alice.UserID)
+	assert.ErrorIs(t, err, ErrInviteSelf)
+	_, err = hub.SendInvite(alice, "vault", uuid.NewString())
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = hub.SendInvite(alice, "missing", bobID)
+	assert.ErrorIs(t, err, ErrRoomNotFound)
+	_, err = hub.SendInvite(client.NewClient(nil, "guest"), "vault", bobID)
+	assert.ErrorIs(t, err, ErrInviteUnauthenticated)
+}
@@ -0,0 +1,181 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+)
+
+const (
+	// DefaultRoomPasswordMaxAttempts is how many wrong passwords a user may try
+	// on a room before being blocked when ROOM_PASSWORD_MAX_ATTEMPTS is unset
+	DefaultRoomPasswordMaxAttempts = 5
+	// DefaultRoomPasswordCooldown is how long a blocked user waits when
+	// ROOM_PASSWORD_COOLDOWN is unset
+	DefaultRoomPasswordCooldown = 5 * time.Minute
+	// maxPasswordAttemptEntries is how many (user, room) pairs are tracked before stale ones are pruned
+	maxPasswordAttemptEntries = 10000
+)
+
+// GetRoomPasswordMaxAttempts reads the wrong password limit from environment or returns default; 0 turns it off
+func GetRoomPasswordMaxAttempts() int {
+	if value := os.Getenv("ROOM_PASSWORD_MAX_ATTEMPTS"); value != "" {
+		if attempts, err := strconv.Atoi(value); err == nil && attempts >= 0 {
+			return attempts
+		}
+		log.Printf("Invalid ROOM_PASSWORD_MAX_ATTEMPTS, using default: %d", DefaultRoomPasswordMaxAttempts)
+	}
+	return DefaultRoomPasswordMaxAttempts
+}
+
+// GetRoomPasswordCooldown reads the wrong password cooldown from environment or returns default
+func GetRoomPasswordCooldown() time.Duration {
+	if value := os.Getenv("ROOM_PASSWORD_COOLDOWN"); value != "" {
+		if cooldown, err := time.ParseDuration(value); err == nil && cooldown > 0 {
+			return cooldown
+		}
+		log.Printf("Invalid ROOM_PASSWORD_COOLDOWN, using default: %s", DefaultRoomPasswordCooldown)
+	}
+	return DefaultRoomPasswordCooldown
+}
+
+// PasswordCooldownError is returned when joining a private room while
+// blocked for giving too many wrong passwords
+type PasswordCooldownError struct {
+	RetryAfter time.Duration
+}
+
+func (e *PasswordCooldownError) Error() string {
+	return fmt.Sprintf("too many wrong passwords, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// PasswordLockoutHandler is told when a user is blocked from a room for
+// giving too many wrong passwords
+type PasswordLockoutHandler func(client *clientpkg.Client, roomName string, failures int)
+
+// passwordAttempt is one user's wrong passwords for one room
+type passwordAttempt struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// passwordAttemptKey identifies a user's attempts on a room
+type passwordAttemptKey struct {
+	user string
+	room string
+}
+
+// passwordAttempts counts wrong room passwords per (user, room), so bcrypt
+// can't be brute-forced by repeating join_room
+type passwordAttempts struct {
+	mu          sync.Mutex
+	maxAttempts int // 0 turns the limit off
+	cooldown    time.Duration
+	entries     map[passwordAttemptKey]*passwordAttempt
+	onLockout   PasswordLockoutHandler
+}
+
+func newPasswordAttempts(maxAttempts int, cooldown time.Duration) *passwordAttempts {
+	return &passwordAttempts{
+		maxAttempts: maxAttempts,
+		cooldown:    cooldown,
+		entries:     make(map[passwordAttemptKey]*passwordAttempt),
+	}
+}
+
+// passwordAttemptUser identifies the user behind client; connections without
+// a user ID are tracked by address so reconnecting doesn't reset the count
+func passwordAttemptUser(client *clientpkg.Client) string {
+	if client.UserID != "" {
+		return client.UserID
+	}
+	if client.RemoteAddr != "" {
+		return client.RemoteAddr
+	}
+	return client.ID
+}
+
+// check returns a PasswordCooldownError if client is blocked from roomName
+func (p *passwordAttempts) check(client *clientpkg.Client, roomName string) error {
+	if p.maxAttempts == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, exists := p.entries[passwordAttemptKey{passwordAttemptUser(client), roomName}]
+	if !exists {
+		return nil
+	}
+	if remaining := time.Until(entry.blockedUntil); remaining > 0 {
+		return &PasswordCooldownError{RetryAfter: remaining}
+	}
+	return nil
+}
+
+// fail records a wrong password, blocking client from roomName for the
+// cooldown once it has given maxAttempts in a row
+func (p *passwordAttempts) fail(client *clientpkg.Client, roomName string) {
+	if p.maxAttempts == 0 {
+		return
+	}
+	key := passwordAttemptKey{passwordAttemptUser(client), roomName}
+	now := time.Now()
+
+	p.mu.Lock()
+	entry, exists := p.entries[key]
+	if !exists {
+		if len(p.entries) >= maxPasswordAttemptEntries {
+			p.prune(now)
+		}
+		entry = &passwordAttempt{}
+		p.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+	failures := entry.failures
+	lockedOut := failures%p.maxAttempts == 0
+	if lockedOut {
+		entry.blockedUntil = now.Add(p.cooldown)
+	}
+	onLockout := p.onLockout
+	p.mu.Unlock()
+
+	if lockedOut {
+		log.Printf("Blocked %s from room %s for %s after %d wrong passwords conn_id=%s", key.user, roomName, p.cooldown, failures, client.ID)
+		if onLockout != nil {
+			onLockout(client, roomName, failures)
+		}
+	}
+}
+
+// succeed forgets client's wrong passwords for roomName
+func (p *passwordAttempts) succeed(client *clientpkg.Client, roomName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entries, passwordAttemptKey{passwordAttemptUser(client), roomName})
+}
+
+// prune drops entries that are neither blocked nor failed within the last
+// cooldown; callers must hold p.mu
+func (p *passwordAttempts) prune(now time.Time) {
+	for key, entry := range p.entries {
+		if now.After(entry.blockedUntil) && now.Sub(entry.lastFailure) > p.cooldown {
+			delete(p.entries, key)
+		}
+	}
+}
+
+// SetPasswordLockoutHandler sets the function told when a user is blocked
+// from a room for giving too many wrong passwords, e.g. to audit it
+func (h *Hub) SetPasswordLockoutHandler(handler PasswordLockoutHandler) {
+	h.passwordAttempts.mu.Lock()
+	defer h.passwordAttempts.mu.Unlock()
+	h.passwordAttempts.onLockout = handler
+}
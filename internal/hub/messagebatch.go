@@ -0,0 +1,53 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"websocket-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultMessageBatchSize is the persistence batch size when MESSAGE_BATCH_SIZE
+	// is unset; 0 stores every message as it is sent
+	DefaultMessageBatchSize = 0
+	// messageBatchFlushAfter is how long a partial batch waits for more messages
+	messageBatchFlushAfter = 100 * time.Millisecond
+)
+
+// GetMessageBatchSize reads how many room messages are stored per insert from environment or returns default
+func GetMessageBatchSize() int {
+	if value := os.Getenv("MESSAGE_BATCH_SIZE"); value != "" {
+		if size, err := strconv.Atoi(value); err == nil && size >= 0 {
+			return size
+		}
+		log.Printf("Invalid MESSAGE_BATCH_SIZE, using default: %d", DefaultMessageBatchSize)
+	}
+	return DefaultMessageBatchSize
+}
+
+// queueRoomMessage adds a message to the persistence batch with a
+// client-generated ID, so it is known before the batch is flushed
+func (h *Hub) queueRoomMessage(roomID, userID, parentID pgtype.UUID, content string) {
+	h.messageBatch.Add(repository.NewMessage{
+		ID:              pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		RoomID:          roomID,
+		UserID:          userID,
+		Content:         content,
+		CreatedAt:       pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		ParentMessageID: parentID,
+	})
+}
+
+// flushMessageBatch stores a batch of queued room messages
+func (h *Hub) flushMessageBatch(msgs []repository.NewMessage) {
+	if err := h.Repo.CreateMessages(context.Background(), msgs); err != nil {
+		log.Printf("Failed to save %d batched room messages to database: %v", len(msgs), err)
+	}
+}
@@ -0,0 +1,172 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultMessageEditWindow is how long authors may edit a message when MESSAGE_EDIT_WINDOW is unset
+	DefaultMessageEditWindow = 15 * time.Minute
+	// DefaultMessageDeleteWindow is how long authors may delete a message when MESSAGE_DELETE_WINDOW is unset
+	DefaultMessageDeleteWindow = time.Hour
+)
+
+var (
+	ErrMessagesUnavailable = errors.New("editing messages is not available")
+	ErrMessageNotFound     = errors.New("message not found")
+	ErrMessageNotInRoom    = errors.New("you must be in the message's room")
+	ErrMessageEmpty        = errors.New("message cannot be empty")
+	ErrEditNotAllowed      = errors.New("you can only edit your own messages")
+	ErrDeleteNotAllowed    = errors.New("only the author, the room creator or an admin can delete a message")
+	ErrEditWindowExpired   = errors.New("edit window expired")
+	ErrDeleteWindowExpired = errors.New("delete window expired")
+)
+
+// GetMessageEditWindow reads how long messages stay editable from environment or returns default
+func GetMessageEditWindow() time.Duration {
+	return getMessageWindow("MESSAGE_EDIT_WINDOW", DefaultMessageEditWindow)
+}
+
+// GetMessageDeleteWindow reads how long messages stay deletable from environment or returns default
+func GetMessageDeleteWindow() time.Duration {
+	return getMessageWindow("MESSAGE_DELETE_WINDOW", DefaultMessageDeleteWindow)
+}
+
+func getMessageWindow(name string, def time.Duration) time.Duration {
+	if value := os.Getenv(name); value != "" {
+		if window, err := time.ParseDuration(value); err == nil && window >= 0 {
+			return window
+		}
+		log.Printf("Invalid %s, using default: %s", name, def)
+	}
+	return def
+}
+
+// EditMessage replaces the text of one of the client's messages in its
+// current room and tells the room. Past MessageEditWindow only admins and the
+// room's creator may still edit their messages.
+func (h *Hub) EditMessage(client *clientpkg.Client, messageID, content string) (types.MessageUpdateDTO, error) {
+	if strings.TrimSpace(content) == "" {
+		return types.MessageUpdateDTO{}, ErrMessageEmpty
+	}
+	msg, msgRoom, err := h.lookupMessage(client, messageID)
+	if err != nil {
+		return types.MessageUpdateDTO{}, err
+	}
+	if uuid.UUID(msg.UserID.Bytes).String() != client.UserID {
+		return types.MessageUpdateDTO{}, ErrEditNotAllowed
+	}
+	if !canModerateMessages(client, msgRoom) && windowExpired(msg, h.Config().MessageEditWindow) {
+		return types.MessageUpdateDTO{}, ErrEditWindowExpired
+	}
+	if content, err = h.FilterMessage(client, msgRoom, content); err != nil {
+		return types.MessageUpdateDTO{}, err
+	}
+
+	msg, err = h.Repo.UpdateMessageContent(context.Background(), msg.ID, content)
+	if err != nil {
+		return types.MessageUpdateDTO{}, err
+	}
+
+	dto := types.MessageUpdateDTO{Type: types.MsgTypeMessageEdited, MessageID: uuid.UUID(msg.ID.Bytes).String(), Room: msgRoom.Name, Content: msg.Content}
+	h.replyCache.remove(msgRoom.Name, dto.MessageID)
+	h.broadcastMessageUpdate(msgRoom, dto)
+	return dto, nil
+}
+
+// DeleteMessage removes a message from the client's current room and tells
+// the room. Authors may delete their own messages within
+// MessageDeleteWindow; admins and the room's creator may delete any message
+// at any time.
+func (h *Hub) DeleteMessage(client *clientpkg.Client, messageID string) error {
+	msg, msgRoom, err := h.lookupMessage(client, messageID)
+	if err != nil {
+		return err
+	}
+	if !canModerateMessages(client, msgRoom) {
+		if uuid.UUID(msg.UserID.Bytes).String() != client.UserID {
+			return ErrDeleteNotAllowed
+		}
+		if windowExpired(msg, h.Config().MessageDeleteWindow) {
+			return ErrDeleteWindowExpired
+		}
+	}
+
+	deleted, err := h.Repo.DeleteMessage(context.Background(), msg.ID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrMessageNotFound
+	}
+
+	key := uuid.UUID(msg.ID.Bytes).String()
+	h.replyCache.remove(msgRoom.Name, key)
+	h.broadcastMessageUpdate(msgRoom, types.MessageUpdateDTO{Type: types.MsgTypeMessageDeleted, MessageID: key, Room: msgRoom.Name})
+	return nil
+}
+
+// lookupMessage loads a stored message and checks the client is in its room
+func (h *Hub) lookupMessage(client *clientpkg.Client, messageID string) (db.Message, *room.Room, error) {
+	if h.Repo == nil {
+		return db.Message{}, nil, ErrMessagesUnavailable
+	}
+
+	var id pgtype.UUID
+	if err := id.Scan(messageID); err != nil {
+		return db.Message{}, nil, ErrMessageNotFound
+	}
+	msg, err := h.Repo.GetMessageByID(context.Background(), id)
+	if err != nil {
+		return db.Message{}, nil, ErrMessageNotFound
+	}
+
+	currentRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || currentRoom == nil || currentRoom.GetID() != uuid.UUID(msg.RoomID.Bytes).String() {
+		return db.Message{}, nil, ErrMessageNotInRoom
+	}
+	return msg, currentRoom, nil
+}
+
+// canModerateMessages reports whether a client is exempt from the edit and
+// delete windows in a room: an admin, the room's creator or a moderator
+func canModerateMessages(client *clientpkg.Client, targetRoom *room.Room) bool {
+	if client.Admin || targetRoom.IsCreator(client) {
+		return true
+	}
+	return client.UserID != "" && targetRoom.MemberRole(client.UserID) == repository.RoomRoleModerator
+}
+
+// windowExpired reports whether msg is older than window; a zero window never expires
+func windowExpired(msg db.Message, window time.Duration) bool {
+	return window > 0 && time.Since(msg.CreatedAt.Time) > window
+}
+
+// broadcastMessageUpdate sends an edit or delete notice to the members of a room
+func (h *Hub) broadcastMessageUpdate(targetRoom *room.Room, update types.MessageUpdateDTO) {
+	content, err := json.Marshal(update)
+	if err != nil {
+		log.Printf("Failed to marshal %s for message %s: %v", update.Type, update.MessageID, err)
+		return
+	}
+
+	select {
+	case h.Broadcast <- types.Message{Content: content, Type: update.Type, Room: targetRoom}:
+	case <-h.Ctx.Done():
+	}
+}
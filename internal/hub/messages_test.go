@@ -0,0 +1,163 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository/repositorytest"
+	"websocket-demo/internal/room"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// messageRoom is a room with stored messages and connected members
+type messageRoom struct {
+	hub   *Hub
+	store *repositorytest.Fake
+	room  *room.Room
+	users map[string]db.User
+	conns map[string]*websocket.Conn
+}
+
+// newMessageRoom starts a hub backed by a fake store and joins alice, bob
+// and carol, the room's creator, to a room
+func newMessageRoom(t *testing.T, ctx context.Context) (*messageRoom, map[string]*client.Client) {
+	t.Helper()
+
+	store := repositorytest.NewFake()
+	h := NewHub(ctx, store, nil)
+	go h.Run()
+
+	lounge, err := h.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+
+	mr := &messageRoom{hub: h, store: store, room: lounge, users: map[string]db.User{}, conns: map[string]*websocket.Conn{}}
+	clients := map[string]*client.Client{}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := store.CreateUser(ctx, name, name+"@example.com", "hash")
+		require.NoError(t, err)
+		c, conn := newConnectedClient(t, name, uuid.UUID(user.ID.Bytes).String())
+		c.Authenticated = true
+		h.Register <- c
+		<-c.Registered
+		require.NoError(t, h.JoinRoom(c, lounge, ""))
+		mr.users[name] = user
+		mr.conns[name] = conn
+		clients[name] = c
+	}
+	lounge.SetCreator(clients["carol"])
+	return mr, clients
+}
+
+// post stores a message from user, sent age ago
+func (mr *messageRoom) post(t *testing.T, user, content string, age time.Duration) string {
+	t.Helper()
+	var roomID pgtype.UUID
+	require.NoError(t, roomID.Scan(mr.room.ID))
+	msgs, err := mr.store.BulkCreateMessages(context.Background(), []db.BulkCreateMessagesParams{{
+		RoomID:    roomID,
+		UserID:    mr.users[user].ID,
+		Content:   content,
+		CreatedAt: pgtype.Timestamptz{Time: time.Now().Add(-age), Valid: true},
+	}})
+	require.NoError(t, err)
+	return uuid.UUID(msgs[0].ID.Bytes).String()
+}
+
+// content returns a stored message's text, or false once it is deleted
+func (mr *messageRoom) content(id string) (string, bool) {
+	for _, m := range mr.store.Messages() {
+		if uuid.UUID(m.ID.Bytes).String() == id {
+			return m.Content, true
+		}
+	}
+	return "", false
+}
+
+func TestEditMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, clients := newMessageRoom(t, ctx)
+
+	fresh := mr.post(t, "alice", "helo", time.Minute)
+	dto, err := mr.hub.EditMessage(clients["alice"], fresh, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, fresh, dto.MessageID)
+	assert.Equal(t, "lounge", dto.Room)
+	text, _ := mr.content(fresh)
+	assert.Equal(t, "hello", text)
+	assert.True(t, readUntil(mr.conns["bob"], `"type":"message_edited","message_id":"`+fresh+`","room":"lounge","content":"hello"`, time.Second))
+
+	_, err = mr.hub.EditMessage(clients["bob"], fresh, "not yours")
+	assert.ErrorIs(t, err, ErrEditNotAllowed)
+	_, err = mr.hub.EditMessage(clients["alice"], fresh, "  ")
+	assert.ErrorIs(t, err, ErrMessageEmpty)
+	_, err = mr.hub.EditMessage(clients["alice"], uuid.NewString(), "missing")
+	assert.ErrorIs(t, err, ErrMessageNotFound)
+
+	// Past the window only the room's creator may edit
+	old := mr.post(t, "alice", "old news", DefaultMessageEditWindow+time.Minute)
+	_, err = mr.hub.EditMessage(clients["alice"], old, "rewritten")
+	assert.EqualError(t, err, "edit window expired")
+	creatorOld := mr.post(t, "carol", "welcome", 24*time.Hour)
+	_, err = mr.hub.EditMessage(clients["carol"], creatorOld, "welcome, all")
+	assert.NoError(t, err)
+
+	// A zero window turns the limit off
+	cfg := mr.hub.Config()
+	cfg.MessageEditWindow = 0
+	_, err = mr.hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	_, err = mr.hub.EditMessage(clients["alice"], old, "rewritten")
+	assert.NoError(t, err)
+
+	// Editing needs the client to be in the message's room
+	require.NoError(t, mr.hub.LeaveNamedRoom(clients["alice"], "lounge"))
+	_, err = mr.hub.EditMessage(clients["alice"], fresh, "from outside")
+	assert.ErrorIs(t, err, ErrMessageNotInRoom)
+}
+
+func TestDeleteMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, clients := newMessageRoom(t, ctx)
+
+	fresh := mr.post(t, "alice", "oops", time.Minute)
+	old := mr.post(t, "alice", "old news", DefaultMessageDeleteWindow+time.Minute)
+
+	assert.ErrorIs(t, mr.hub.DeleteMessage(clients["bob"], fresh), ErrDeleteNotAllowed)
+	assert.EqualError(t, mr.hub.DeleteMessage(clients["alice"], old), "delete window expired")
+
+	require.NoError(t, mr.hub.DeleteMessage(clients["alice"], fresh))
+	_, exists := mr.content(fresh)
+	assert.False(t, exists)
+	assert.True(t, readUntil(mr.conns["bob"], `"type":"message_deleted","message_id":"`+fresh+`"`, time.Second))
+	assert.ErrorIs(t, mr.hub.DeleteMessage(clients["alice"], fresh), ErrMessageNotFound)
+
+	// The room's creator and admins may delete anyone's messages at any time
+	require.NoError(t, mr.hub.DeleteMessage(clients["carol"], old))
+	_, exists = mr.content(old)
+	assert.False(t, exists)
+
+	another := mr.post(t, "carol", "hello", 48*time.Hour)
+	clients["bob"].Admin = true
+	require.NoError(t, mr.hub.DeleteMessage(clients["bob"], another))
+}
+
+func TestMessageWindowsFromEnv(t *testing.T) {
+	t.Setenv("MESSAGE_EDIT_WINDOW", "5m")
+	t.Setenv("MESSAGE_DELETE_WINDOW", "-1h")
+	t.Setenv("MESSAGE_DEDUP_WINDOW", "3s")
+
+	cfg := LoadHubConfig()
+	assert.Equal(t, 5*time.Minute, cfg.MessageEditWindow)
+	assert.Equal(t, DefaultMessageDeleteWindow, cfg.MessageDeleteWindow, "invalid values fall back to the default")
+	assert.Equal(t, 3*time.Second, cfg.MessageDedupWindow)
+}
@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/room"
+)
+
+var (
+	ErrMoveNotAllowed       = errors.New("only an admin or the target room's creator can move users")
+	ErrMoveUserNotConnected = errors.New("user is not connected to this server")
+	ErrAlreadyInRoom        = errors.New("already in that room")
+)
+
+// MoveClient moves client from its current room into the named room in one
+// step, so it is never seen in both rooms or in neither. The old room gets a
+// leave notice and the target a join notice. Passwords are not checked; the
+// caller decides who may move clients.
+func (h *Hub) MoveClient(client *clientpkg.Client, targetRoomName string) error {
+	if err := h.acquireRoomOp(); err != nil {
+		return err
+	}
+	defer h.releaseRoomOp()
+
+	return h.moveClient(client, targetRoomName)
+}
+
+// moveClient moves a client between rooms; callers must hold a room operation slot
+func (h *Hub) moveClient(client *clientpkg.Client, targetRoomName string) error {
+	// Acquire locks in consistent order: h.Mutex first, then roomOpMutex
+	h.Mutex.Lock()
+	h.roomOpMutex.Lock()
+
+	targetRoom, exists := h.roomLocked(targetRoomName)
+	if !exists {
+		h.roomOpMutex.Unlock()
+		h.Mutex.Unlock()
+		return errors.New("room does not exist")
+	}
+	if !targetRoom.Active {
+		h.roomOpMutex.Unlock()
+		h.Mutex.Unlock()
+		return errors.New("room is not active")
+	}
+	if current, ok := client.GetCurrentRoom().(*room.Room); ok && current == targetRoom {
+		h.roomOpMutex.Unlock()
+		h.Mutex.Unlock()
+		return ErrAlreadyInRoom
+	}
+
+	// Check max clients before leaving so a full target leaves the client where it was
+	if h.roomIsFull(targetRoom) || !targetRoom.AddClient(client) {
+		h.roomOpMutex.Unlock()
+		h.Mutex.Unlock()
+		return errors.New("room is full")
+	}
+
+	h.leaveRoomInternal(client)
+	client.SetCurrentRoom(targetRoom)
+	h.ClientRooms[client] = targetRoom
+	h.addRoomMember(client, targetRoom)
+
+	h.roomOpMutex.Unlock()
+	h.Mutex.Unlock()
+
+	h.announceJoin(client, targetRoom)
+	return nil
+}
+
+// MoveUser moves every connection userID has on this server into the named
+// room on behalf of mover, an admin or the target room's creator. It returns
+// how many connections were moved.
+func (h *Hub) MoveUser(mover *clientpkg.Client, userID, targetRoomName string) (int, error) {
+	targetRoom, exists := h.GetRoom(targetRoomName)
+	if !exists {
+		return 0, errors.New("room does not exist")
+	}
+	if !mover.Admin && !targetRoom.IsCreator(mover) {
+		return 0, ErrMoveNotAllowed
+	}
+
+	h.Mutex.RLock()
+	sessions := make([]*clientpkg.Client, 0, len(h.userSessions[userID]))
+	for c := range h.userSessions[userID] {
+		sessions = append(sessions, c)
+	}
+	h.Mutex.RUnlock()
+	if len(sessions) == 0 {
+		return 0, ErrMoveUserNotConnected
+	}
+
+	if err := h.acquireRoomOp(); err != nil {
+		return 0, err
+	}
+	defer h.releaseRoomOp()
+
+	moved := 0
+	for _, c := range sessions {
+		if err := h.moveClient(c, targetRoomName); err != nil {
+			if errors.Is(err, ErrAlreadyInRoom) {
+				continue
+			}
+			return moved, err
+		}
+		moved++
+
+		notice := []byte(fmt.Sprintf("[%s] You were moved to room '%s' by %s", time.Now().Format("15:04:05"), targetRoomName, mover.Name))
+		if c.Conn != nil {
+			c.WriteMessage(context.Background(), notice)
+		}
+	}
+	if moved == 0 {
+		return 0, ErrAlreadyInRoom
+	}
+	return moved, nil
+}
@@ -0,0 +1,312 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ensureRoomSubscription subscribes to a room's NATS subject unless a valid subscription already exists
+func (h *Hub) ensureRoomSubscription(targetRoom *room.Room) error {
+	h.roomSubsMutex.Lock()
+	defer h.roomSubsMutex.Unlock()
+
+	if sub, exists := h.roomSubs[targetRoom.Name]; exists && sub.IsValid() {
+		return nil
+	}
+
+	subject := natsclient.RoomSubject(targetRoom.Name)
+	// Use regular subscription (not queue) so ALL servers receive every message
+	// Queue subscriptions are for load balancing (one consumer gets the message),
+	// but we need pub/sub (all consumers get the message) for cross-server distribution.
+	// With JetStream on this is a per-server durable consumer, so messages
+	// missed while the server was down are backfilled into the room.
+	sub, err := h.NATS.SubscribeDurable(subject, func(msg types.Message) {
+		// Skip messages that originated from this server to prevent duplicate delivery
+		if msg.ServerID != "" && msg.ServerID == h.NATS.GetServerID() {
+			log.Printf("Skipping message from own server %s", msg.ServerID)
+			return
+		}
+		// Drop repeats, e.g. an outbox entry published again after a failed MarkOutboxSent
+		if msg.MessageID != "" && !h.seen.add(msg.MessageID) {
+			log.Printf("Skipping duplicate message %s", msg.MessageID)
+			return
+		}
+		// Forward NATS messages to BroadcastToRoom for consistent handling
+		// BroadcastToRoom will handle delivery to local clients
+		h.BroadcastToRoom(targetRoom, msg)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.roomSubs[targetRoom.Name] = sub
+	log.Printf("Subscribed to room NATS subject: %s", subject)
+
+	// Drop the durable consumer left on the room's pre-escaping subject
+	if err := h.NATS.RemoveLegacyRoomConsumer(targetRoom.Name); err != nil {
+		log.Printf("Failed to remove legacy consumer for room %s: %v", targetRoom.Name, err)
+	}
+	return nil
+}
+
+// removeRoomSubscription drops the NATS subscription for a room
+func (h *Hub) removeRoomSubscription(roomName string) {
+	h.roomSubsMutex.Lock()
+	defer h.roomSubsMutex.Unlock()
+
+	if sub, exists := h.roomSubs[roomName]; exists {
+		sub.Unsubscribe()
+		delete(h.roomSubs, roomName)
+	}
+}
+
+// roomSyncVersion is the room sync schema this server understands
+const roomSyncVersion = 1
+
+// Room sync kinds
+const (
+	roomSyncCreated = "room_created"
+	roomSyncUpdated = "room_updated"
+	roomSyncDeleted = "room_deleted"
+)
+
+// roomSyncData is the room metadata shared between servers. Plaintext
+// passwords never leave the server the room was created on.
+type roomSyncData struct {
+	Version      int    `json:"version"`
+	Kind         string `json:"kind"`
+	Name         string `json:"name"`
+	Private      bool   `json:"private"`
+	PasswordHash string `json:"password_hash,omitempty"`
+	MaxClients   int    `json:"maxClients"`
+
+	SuppressJoinLeave bool `json:"suppress_join_leave"`
+
+	Alert      *room.Alert          `json:"alert,omitempty"`       // Moderation banner, kept in memory only
+	LinkPolicy *types.LinkPolicyDTO `json:"link_policy,omitempty"` // The room's own link policy, kept in memory only
+}
+
+// publishRoomSync announces a room change of the given kind to the other servers
+func (h *Hub) publishRoomSync(kind string, targetRoom *room.Room) error {
+	roomDataJSON, err := json.Marshal(roomSyncData{
+		Version:      roomSyncVersion,
+		Kind:         kind,
+		Name:         targetRoom.Name,
+		Private:      targetRoom.Private,
+		PasswordHash: targetRoom.Password,
+		MaxClients:   targetRoom.MaxClients,
+
+		SuppressJoinLeave: targetRoom.SuppressesJoinLeave(),
+
+		Alert:      targetRoom.GetAlert(),
+		LinkPolicy: linkPolicyDTO(targetRoom.GetLinkPolicy()),
+	})
+	if err != nil {
+		return err
+	}
+
+	syncMsg := types.Message{
+		Content: roomDataJSON,
+		Type:    types.MsgTypeRoomSync,
+	}
+
+	return h.NATS.Publish(natsclient.SubjectRoomSync, syncMsg)
+}
+
+// handleRoomSync applies room changes announced by other servers. Every kind
+// is idempotent: creating an existing room, updating it twice or deleting a
+// room that is already gone leaves the same state.
+func (h *Hub) handleRoomSync(msg types.Message) {
+	// The originating server already applied the change
+	if msg.ServerID != "" && msg.ServerID == h.NATS.GetServerID() {
+		return
+	}
+
+	var roomData roomSyncData
+	if err := json.Unmarshal(msg.Content, &roomData); err != nil {
+		log.Printf("Failed to unmarshal room sync data: %v", err)
+		return
+	}
+	if roomData.Name == "" {
+		return
+	}
+	if roomData.Version > roomSyncVersion {
+		log.Printf("Ignoring room sync for %s with unsupported version %d", roomData.Name, roomData.Version)
+		return
+	}
+	if roomData.MaxClients <= 0 {
+		roomData.MaxClients = h.Config().MaxClientsPerRoom
+	}
+
+	switch roomData.Kind {
+	case roomSyncDeleted:
+		if h.removeRoomLocally(roomData.Name) {
+			log.Printf("Room %s deleted via NATS", roomData.Name)
+		}
+	case roomSyncUpdated:
+		h.upsertSyncedRoom(roomData, true)
+	default:
+		// Creation, including messages from servers predating versioned sync
+		h.upsertSyncedRoom(roomData, false)
+	}
+}
+
+// upsertSyncedRoom creates a room from sync data, or applies the settings to
+// an existing room when update is set. Updates for rooms this server doesn't
+// know and rooms missing from the shared database are ignored, so a late
+// message can't bring back a deleted room.
+func (h *Hub) upsertSyncedRoom(roomData roomSyncData, update bool) {
+	// The shared database is authoritative for the ID and password hash
+	var roomID string
+	if h.Repo != nil {
+		dbRoom, err := h.Repo.GetRoomByName(context.Background(), roomData.Name)
+		switch {
+		case err == nil:
+			roomID = uuid.UUID(dbRoom.ID.Bytes).String()
+			roomData.Private = dbRoom.Private.Bool
+			roomData.PasswordHash = dbRoom.PasswordHash.String
+			roomData.SuppressJoinLeave = dbRoom.SuppressJoinLeave
+			roomData.MaxClients = h.storedCapacity(dbRoom)
+		case errors.Is(err, pgx.ErrNoRows):
+			log.Printf("Ignoring room sync for %s, which is not in the database", roomData.Name)
+			return
+		default:
+			log.Printf("Failed to look up synced room %s: %v", roomData.Name, err)
+		}
+	}
+
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+
+	existing, exists := h.roomLocked(roomData.Name)
+	if !exists && update {
+		log.Printf("Ignoring update for unknown room %s via NATS", roomData.Name)
+		return
+	}
+	if exists {
+		if !update {
+			return
+		}
+		// Read under h.Mutex or the room's lock, so both are held
+		existing.Mutex.Lock()
+		existing.Private = roomData.Private
+		existing.Password = roomData.PasswordHash
+		existing.MaxClients = roomData.MaxClients
+		existing.Mutex.Unlock()
+		existing.SetSuppressJoinLeave(roomData.SuppressJoinLeave)
+		existing.SetAlert(roomData.Alert)
+		existing.SetLinkPolicy(linkPolicyFromDTO(roomData.LinkPolicy))
+		log.Printf("Room %s updated via NATS", roomData.Name)
+		return
+	}
+
+	newRoom := room.NewRoom(roomData.Name, roomData.Private, roomData.PasswordHash, roomData.MaxClients)
+	newRoom.SetID(roomID)
+	newRoom.SuppressJoinLeaveMessages = roomData.SuppressJoinLeave
+	newRoom.SetAlert(roomData.Alert)
+	newRoom.SetLinkPolicy(linkPolicyFromDTO(roomData.LinkPolicy))
+	delete(h.evictedRooms, roomData.Name)
+	h.Rooms[roomData.Name] = newRoom
+	log.Printf("Room %s synced from NATS", roomData.Name)
+}
+
+// removeRoomLocally drops a room from this server and evicts its local
+// members with a notification, moving them to the default room. Returns
+// false if the room was not known or is the default room.
+func (h *Hub) removeRoomLocally(roomName string) bool {
+	if h.IsDefaultRoom(roomName) {
+		log.Printf("Refusing to remove the default room %s", roomName)
+		return false
+	}
+
+	h.Mutex.Lock()
+	delete(h.evictedRooms, roomName)
+	targetRoom, exists := h.Rooms[roomName]
+	if !exists {
+		h.Mutex.Unlock()
+		return false
+	}
+	delete(h.Rooms, roomName)
+	// Joins racing with the deletion through a stale pointer fail on this
+	targetRoom.Active = false
+
+	members := targetRoom.GetClients()
+	for _, member := range members {
+		targetRoom.RemoveClient(member)
+		if current, ok := member.GetCurrentRoom().(*room.Room); ok && current == targetRoom {
+			member.SetCurrentRoom(nil)
+			delete(h.ClientRooms, member)
+		}
+	}
+	h.Mutex.Unlock()
+
+	h.replyCache.removeRoom(roomName)
+	if h.NATS != nil {
+		h.removeRoomSubscription(roomName)
+	}
+
+	notice := []byte(fmt.Sprintf("You have been removed from room \"%s\" because it was deleted", roomName))
+	for _, member := range members {
+		if member.Conn == nil {
+			continue
+		}
+		if err := member.WriteMessage(context.Background(), notice); err != nil {
+			log.Printf("Failed to notify %s of room deletion: %v", member.Name, err)
+		}
+	}
+	h.moveToDefaultRoom(members)
+	return true
+}
+
+// watchNATSReconnects resynchronizes room state every time the NATS connection is re-established
+func (h *Hub) watchNATSReconnects() {
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-h.NATS.ReconnectChan():
+			log.Println("NATS reconnected, resynchronizing rooms")
+			h.resyncNATS()
+		}
+	}
+}
+
+// resyncNATS restores room and user subscriptions lost while NATS was down
+// and re-publishes a room sync snapshot so other servers converge
+func (h *Hub) resyncNATS() {
+	h.Mutex.RLock()
+	rooms := make([]*room.Room, 0, len(h.Rooms))
+	for _, r := range h.Rooms {
+		rooms = append(rooms, r)
+	}
+	userIDs := make([]string, 0, len(h.userSessions))
+	for userID := range h.userSessions {
+		userIDs = append(userIDs, userID)
+	}
+	h.Mutex.RUnlock()
+
+	for _, userID := range userIDs {
+		h.ensureUserSubscription(userID)
+	}
+
+	for _, r := range rooms {
+		if r.GetClientCount() > 0 {
+			if err := h.ensureRoomSubscription(r); err != nil {
+				log.Printf("Failed to restore NATS subscription for room %s: %v", r.Name, err)
+			}
+		}
+		if err := h.publishRoomSync(roomSyncUpdated, r); err != nil {
+			log.Printf("Failed to republish room sync for %s: %v", r.Name, err)
+		}
+	}
+}
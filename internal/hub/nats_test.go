@@ -0,0 +1,709 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/repository/repositorytest"
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/tracing/tracingtest"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
+
+	"github.com/coder/websocket"
+	"github.com/jackc/pgx/v5/pgtype"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startNATSServer runs an embedded NATS server on the given port (-1 picks a random one)
+func startNATSServer(t *testing.T, port int) *natsserver.Server {
+	t.Helper()
+
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second), "NATS server did not start")
+	return srv
+}
+
+// startClusterHub runs a hub connected to the given NATS server and waits for its subscriptions
+func startClusterHub(t *testing.T, ctx context.Context, url string) *Hub {
+	t.Helper()
+
+	natsClient, err := natsclient.NewClient(natsclient.Config{URL: url})
+	require.NoError(t, err)
+	h := NewHub(ctx, nil, natsClient)
+	go h.Run()
+
+	require.Eventually(t, func() bool { return natsClient.Stat().Subscriptions >= 6 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, natsClient.GetConn().Flush())
+	return h
+}
+
+func TestRoomSyncDoesNotPublishPlaintextPassword(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	defer observer.Close()
+	payloads := make(chan []byte, 10)
+	_, err = observer.Subscribe(natsclient.SubjectRoomSync, func(m *nats.Msg) { payloads <- m.Data })
+	require.NoError(t, err)
+	require.NoError(t, observer.Flush())
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	const password = "plaintext-s3cret"
+	_, err = hubA.CreateRoom("vault", true, password, 10)
+	require.NoError(t, err)
+
+	select {
+	case payload := <-payloads:
+		assert.NotContains(t, string(payload), password)
+		var msg natsclient.NATSMessage
+		require.NoError(t, json.Unmarshal(payload, &msg))
+		assert.NotContains(t, string(msg.Content), password)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a room sync message")
+	}
+
+	// The receiving server verifies joins against the synced hash
+	require.Eventually(t, func() bool {
+		_, ok := hubB.GetRoom("vault")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	synced, _ := hubB.GetRoom("vault")
+	assert.Error(t, hubB.JoinRoom(client.NewClient(nil, "mallory"), synced, "wrong"))
+	assert.NoError(t, hubB.JoinRoom(client.NewClient(nil, "alice"), synced, password))
+}
+
+func TestRoomUpdateAndDeleteSyncAcrossServers(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	alice := client.NewClient(nil, "alice")
+	shared, err := hubA.CreateRoom("shared", false, "", 10)
+	require.NoError(t, err)
+	shared.SetCreator(alice)
+
+	require.Eventually(t, func() bool {
+		_, ok := hubB.GetRoom("shared")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	synced, _ := hubB.GetRoom("shared")
+	bob, bobPeer := newConnectedClient(t, "bob", "user-bob")
+	require.NoError(t, hubB.JoinRoom(bob, synced, ""))
+
+	// Updates reach the other server
+	require.NoError(t, hubA.SetRoomSuppressJoinLeave(alice, "shared", true))
+	require.Eventually(t, synced.SuppressesJoinLeave, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, hubA.SetRoomLinkPolicy(alice, "shared", &types.LinkPolicyDTO{Mode: "none"}))
+	require.Eventually(t, func() bool {
+		policy := synced.GetLinkPolicy()
+		return policy != nil && policy.Mode == validator.LinkModeNone
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Deletion evicts remote members and stops new joins
+	require.NoError(t, hubA.DeleteRoom(alice, "shared"))
+	require.Eventually(t, func() bool {
+		_, ok := hubB.GetRoom("shared")
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.True(t, readUntil(bobPeer, "because it was deleted", 5*time.Second))
+	lobby, err := hubB.GetDefaultRoom()
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return bob.GetCurrentRoom() == lobby
+	}, 5*time.Second, 10*time.Millisecond, "evicted members land in the default room")
+	assert.Equal(t, 0, synced.GetClientCount())
+	assert.Error(t, hubB.JoinRoom(client.NewClient(nil, "carol"), synced, ""))
+
+	// Replays are harmless and newer schemas are ignored
+	deleted, _ := json.Marshal(roomSyncData{Version: roomSyncVersion, Kind: roomSyncDeleted, Name: "shared"})
+	hubB.handleRoomSync(types.Message{Content: deleted})
+	future, _ := json.Marshal(roomSyncData{Version: roomSyncVersion + 1, Kind: roomSyncCreated, Name: "from-the-future"})
+	hubB.handleRoomSync(types.Message{Content: future})
+	_, ok := hubB.GetRoom("from-the-future")
+	assert.False(t, ok)
+
+	// A late update doesn't bring the deleted room back
+	updated, _ := json.Marshal(roomSyncData{Version: roomSyncVersion, Kind: roomSyncUpdated, Name: "shared"})
+	hubB.handleRoomSync(types.Message{Content: updated})
+	_, ok = hubB.GetRoom("shared")
+	assert.False(t, ok)
+}
+
+func TestRoomSyncIgnoresRoomsMissingFromDatabase(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	h := NewHub(ctx, store, nil)
+	_, err := store.CreateRoom(ctx, "stored", pgtype.Bool{}, pgtype.Text{}, pgtype.UUID{}, false, pgtype.Int4{})
+	require.NoError(t, err)
+
+	for _, name := range []string{"stored", "gone"} {
+		created, _ := json.Marshal(roomSyncData{Version: roomSyncVersion, Kind: roomSyncCreated, Name: name, Private: true})
+		h.handleRoomSync(types.Message{Content: created})
+	}
+	stored, ok := h.GetRoom("stored")
+	require.True(t, ok)
+	assert.False(t, stored.Private, "the database wins over the sync data")
+	assert.NotEmpty(t, stored.GetID())
+	_, ok = h.GetRoom("gone")
+	assert.False(t, ok, "a room deleted before the message arrived isn't recreated")
+}
+
+func TestNATSReconnectRestoresRoomSubscriptions(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	port := srv.Addr().(*net.TCPAddr).Port
+	url := srv.ClientURL()
+
+	natsClient, err := natsclient.NewClient(natsclient.Config{
+		URL:           url,
+		MaxReconnects: 100,
+		ReconnectWait: time.Second,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, natsClient)
+	require.True(t, hub.NATSEnabled)
+	go hub.Run()
+
+	// Take NATS down and join a room during the outage
+	srv.Shutdown()
+	srv.WaitForShutdown()
+	require.Eventually(t, func() bool { return !natsClient.IsConnected() }, 5*time.Second, 10*time.Millisecond)
+
+	testRoom, err := hub.CreateRoom("outage-room", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(client.NewClient(nil, "alice"), testRoom, ""))
+	assert.False(t, hub.hasRoomSubscription("outage-room"), "subscription cannot be created while NATS is down")
+
+	// Bring NATS back on the same port and watch for the resync snapshot
+	srv = startNATSServer(t, port)
+	defer srv.Shutdown()
+
+	observer, err := nats.Connect(url)
+	require.NoError(t, err)
+	defer observer.Close()
+
+	syncs := make(chan natsclient.NATSMessage, 10)
+	_, err = observer.Subscribe(natsclient.SubjectRoomSync, func(m *nats.Msg) {
+		var msg natsclient.NATSMessage
+		if err := json.Unmarshal(m.Data, &msg); err == nil {
+			syncs <- msg
+		}
+	})
+	require.NoError(t, err)
+	require.NoError(t, observer.Flush())
+
+	require.Eventually(t, func() bool { return hub.hasRoomSubscription("outage-room") }, 10*time.Second, 20*time.Millisecond)
+
+	select {
+	case msg := <-syncs:
+		var roomData map[string]interface{}
+		require.NoError(t, json.Unmarshal(msg.Content, &roomData))
+		assert.Equal(t, "outage-room", roomData["name"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a room sync snapshot after reconnect")
+	}
+
+	// Messages from other servers reach the restored subscription
+	hub.roomSubsMutex.Lock()
+	sub := hub.roomSubs["outage-room"]
+	hub.roomSubsMutex.Unlock()
+	require.NoError(t, observer.Publish(natsclient.RoomSubject("outage-room"), []byte(`{"message_id":"remote-1","type":"room_message","server_id":"other"}`)))
+	require.NoError(t, observer.Flush())
+	require.Eventually(t, func() bool {
+		delivered, err := sub.Delivered()
+		return err == nil && delivered > 0
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestJetStreamBackfillsRoomAfterRestart(t *testing.T) {
+	srv, err := natsserver.NewServer(&natsserver.Options{
+		Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true,
+		JetStream: true, StoreDir: t.TempDir(),
+	})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second), "NATS server did not start")
+	defer srv.Shutdown()
+
+	jsConfig := func(consumer string) natsclient.Config {
+		return natsclient.Config{URL: srv.ClientURL(), EnableJetStream: true, StreamMaxMsgs: 500, ConsumerName: consumer}
+	}
+
+	// First run of server A creates its durable consumer for the room
+	ctxA, cancelA := context.WithCancel(context.Background())
+	natsA, err := natsclient.NewClient(jsConfig("server-a"))
+	require.NoError(t, err)
+	hubA := NewHub(ctxA, nil, natsA)
+	go hubA.Run()
+	lobby, err := hubA.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hubA.JoinRoom(client.NewClient(nil, "alice"), lobby, ""))
+	require.True(t, hubA.hasRoomSubscription("lobby"))
+
+	js, err := natsA.GetJetStream()
+	require.NoError(t, err)
+	info, err := js.StreamInfo(natsclient.DefaultStreamName)
+	require.NoError(t, err)
+	assert.Equal(t, int64(500), info.Config.MaxMsgs)
+	assert.ElementsMatch(t, []string{"chat.room.*", "chat.global"}, info.Config.Subjects)
+
+	cancelA()
+	<-hubA.Done()
+
+	// Server B keeps chatting while A is down
+	natsB, err := natsclient.NewClient(jsConfig("server-b"))
+	require.NoError(t, err)
+	defer natsB.Close()
+	require.NoError(t, natsB.Publish(natsclient.RoomSubject("lobby"), types.Message{
+		Type:       types.MsgTypeRoomMessage,
+		Content:    []byte("missed while down"),
+		SenderID:   "user-b",
+		SenderName: "bob",
+		RoomName:   "lobby",
+		Timestamp:  time.Now(),
+	}))
+
+	// Restarted server A resumes its durable consumer and backfills the room
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	natsA2, err := natsclient.NewClient(jsConfig("server-a"))
+	require.NoError(t, err)
+	hubA2 := NewHub(ctx, nil, natsA2)
+	go hubA2.Run()
+	lobby, err = hubA2.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+
+	alice, peer := newConnectedClient(t, "alice", "user-a")
+	require.NoError(t, hubA2.JoinRoom(alice, lobby, ""))
+	assert.True(t, readUntil(peer, "missed while down", 5*time.Second), "expected the missed message to be replayed")
+}
+
+func TestDirectMessageAcrossServers(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	register := func(h *Hub, c *client.Client) {
+		h.Register <- c
+		<-c.Registered
+		require.NoError(t, h.NATS.GetConn().Flush())
+	}
+
+	alice, _ := newConnectedClient(t, "alice", "user-alice")
+	register(hubA, alice)
+	bobRemote, bobRemotePeer := newConnectedClient(t, "bob", "user-bob")
+	register(hubB, bobRemote)
+
+	// Recipient only connected to the other server
+	delivered, err := hubA.SendDirectMessage(alice, "user-bob", "hello from A")
+	require.NoError(t, err)
+	assert.True(t, delivered)
+	assert.True(t, readUntil(bobRemotePeer, "hello from A", 5*time.Second))
+
+	// Nobody holds a session for this user
+	delivered, err = hubA.SendDirectMessage(alice, "user-nobody", "anyone?")
+	require.NoError(t, err)
+	assert.False(t, delivered)
+
+	// Recipient connected to both servers gets the message on every session
+	bobLocal, bobLocalPeer := newConnectedClient(t, "bob", "user-bob")
+	register(hubA, bobLocal)
+	delivered, err = hubA.SendDirectMessage(alice, "user-bob", "both devices")
+	require.NoError(t, err)
+	assert.True(t, delivered)
+	assert.True(t, readUntil(bobLocalPeer, "both devices", 5*time.Second))
+	assert.True(t, readUntil(bobRemotePeer, "both devices", 5*time.Second))
+
+	// Disconnecting drops the per-user subscription
+	hubB.Unregister <- bobRemote
+	hubA.Unregister <- bobLocal
+	require.Eventually(t, func() bool {
+		hubB.userSubsMutex.Lock()
+		defer hubB.userSubsMutex.Unlock()
+		_, subscribed := hubB.userSubs["user-bob"]
+		return !subscribed
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		delivered, err := hubA.SendDirectMessage(alice, "user-bob", "gone")
+		return err == nil && !delivered
+	}, 5*time.Second, 50*time.Millisecond)
+
+	_, err = hubA.SendDirectMessage(client.NewClient(nil, "anon"), "user-bob", "hi")
+	assert.ErrorIs(t, err, ErrDirectMessageUnauthenticated)
+}
+
+func TestDirectMessageByUsernameAcrossServers(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	alice, _ := newConnectedClient(t, "alice", "user-alice")
+	hubA.Register <- alice
+	<-alice.Registered
+	bob, bobPeer := newConnectedClient(t, "bob", "user-bob")
+	hubB.Register <- bob
+	<-bob.Registered
+	require.NoError(t, hubB.NATS.GetConn().Flush())
+
+	// Watch the subject the message is routed over
+	routed := make(chan []byte, 1)
+	watcher, err := hubA.NATS.GetConn().Subscribe(natsclient.UserDMSubject("user-bob"), func(m *nats.Msg) { routed <- m.Data })
+	require.NoError(t, err)
+	defer watcher.Unsubscribe()
+
+	// Server B answers for bob; server A answers locally for alice
+	reply, err := hubA.LookupUser("Bob")
+	require.NoError(t, err)
+	assert.Equal(t, types.UserLookupReply{ServerID: hubB.NATS.GetServerID(), UserID: "user-bob", Present: true}, reply)
+	reply, err = hubA.LookupUser("alice")
+	require.NoError(t, err)
+	assert.Equal(t, hubA.NATS.GetServerID(), reply.ServerID)
+	reply, err = hubA.LookupUser("nobody")
+	require.NoError(t, err)
+	assert.False(t, reply.Present)
+
+	delivered, err := hubA.SendDirectMessageToUsername(alice, "bob", "hello bob")
+	require.NoError(t, err)
+	assert.True(t, delivered)
+	assert.True(t, readUntil(bobPeer, "hello bob", 5*time.Second))
+	select {
+	case data := <-routed:
+		assert.Contains(t, string(data), `"type":"direct_message"`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("direct message was not routed over the user's DM subject")
+	}
+
+	delivered, err = hubA.SendDirectMessageToUsername(alice, "nobody", "anyone?")
+	require.NoError(t, err)
+	assert.False(t, delivered)
+	_, err = hubA.SendDirectMessageToUsername(client.NewClient(nil, "anon"), "bob", "hi")
+	assert.ErrorIs(t, err, ErrDirectMessageUnauthenticated)
+}
+
+func TestDisconnectUserAcrossServers(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	register := func(h *Hub, c *client.Client) {
+		h.Register <- c
+		<-c.Registered
+		require.NoError(t, h.NATS.GetConn().Flush())
+	}
+
+	bobLocal, bobLocalPeer := newConnectedClient(t, "bob", "user-bob")
+	register(hubA, bobLocal)
+	bobRemote, bobRemotePeer := newConnectedClient(t, "bob", "user-bob")
+	register(hubB, bobRemote)
+	alice, alicePeer := newConnectedClient(t, "alice", "user-alice")
+	register(hubB, alice)
+
+	assert.Equal(t, 1, hubA.DisconnectUser("user-bob", "account deleted"))
+
+	closeStatus := func(peer *websocket.Conn) websocket.StatusCode {
+		readCtx, readCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer readCancel()
+		var err error
+		for err == nil {
+			_, _, err = peer.Read(readCtx)
+		}
+		return websocket.CloseStatus(err)
+	}
+	assert.Equal(t, websocket.StatusPolicyViolation, closeStatus(bobLocalPeer))
+	assert.Equal(t, websocket.StatusPolicyViolation, closeStatus(bobRemotePeer))
+	require.Eventually(t, func() bool {
+		return len(hubA.ListSessions(bobLocal)) == 0 && len(hubB.ListSessions(bobRemote)) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Other users stay connected
+	assert.Len(t, hubB.ListSessions(alice), 1)
+	require.NoError(t, alice.WriteMessage(context.Background(), []byte("still here")))
+	assert.True(t, readUntil(alicePeer, "still here", 5*time.Second))
+}
+
+// hasRoomSubscription reports whether the hub holds a valid NATS subscription for a room
+func (h *Hub) hasRoomSubscription(roomName string) bool {
+	h.roomSubsMutex.Lock()
+	defer h.roomSubsMutex.Unlock()
+	sub, exists := h.roomSubs[roomName]
+	return exists && sub.IsValid()
+}
+
+// newConnectedClient returns a hub client backed by a real WebSocket connection
+// and the peer end the test reads from
+func newConnectedClient(t *testing.T, name, userID string) (*client.Client, *websocket.Conn) {
+	t.Helper()
+
+	accepted := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(srv.Close)
+
+	peer, _, err := websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { peer.CloseNow() })
+
+	c := client.NewClient(<-accepted, name)
+	c.UserID = userID
+	return c, peer
+}
+
+// readUntil reads from conn until a message containing want arrives or timeout passes
+func readUntil(conn *websocket.Conn, want string, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	for {
+		_, data, err := conn.Read(ctx)
+		if err != nil {
+			return false
+		}
+		if strings.Contains(string(data), want) {
+			return true
+		}
+	}
+}
+
+func TestNATSRelayPreservesSenderAndRoom(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	relayRoom, err := hubA.CreateRoom("relay", false, "", 10)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, ok := hubB.GetRoom("relay")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	remoteRoom, _ := hubB.GetRoom("relay")
+
+	// carol is a different user on server B; alice also has a second device there
+	carol, carolPeer := newConnectedClient(t, "carol", "user-carol")
+	aliceOther, alicePeer := newConnectedClient(t, "alice", "user-alice")
+	require.NoError(t, hubB.JoinRoom(carol, remoteRoom, ""))
+	require.NoError(t, hubB.JoinRoom(aliceOther, remoteRoom, ""))
+	require.NoError(t, hubA.NATS.GetConn().Flush())
+
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = "user-alice"
+	require.NoError(t, hubA.JoinRoom(alice, relayRoom, ""))
+
+	t.Run("room message reaches the sender's other devices", func(t *testing.T) {
+		hubA.BroadcastToRoom(relayRoom, types.Message{
+			Content: []byte("alice: hello from A"),
+			Sender:  alice,
+			Type:    types.MsgTypeRoomMessage,
+			Room:    relayRoom,
+		})
+
+		assert.True(t, readUntil(carolPeer, "hello from A", 5*time.Second))
+		assert.True(t, readUntil(alicePeer, "hello from A", 5*time.Second))
+	})
+
+	t.Run("global publish with a room name is routed to the room", func(t *testing.T) {
+		outsider, outsiderPeer := newConnectedClient(t, "dave", "user-dave")
+		hubB.Register <- outsider
+		<-outsider.Registered
+
+		require.NoError(t, hubA.NATS.Publish(natsclient.SubjectGlobalChat, types.Message{
+			Content:    []byte("routed to relay"),
+			Type:       types.MsgTypeRoomMessage,
+			SenderID:   "user-bob",
+			SenderName: "bob",
+			RoomName:   "relay",
+		}))
+
+		assert.True(t, readUntil(carolPeer, "routed to relay", 5*time.Second))
+		assert.False(t, readUntil(outsiderPeer, "routed to relay", 500*time.Millisecond))
+	})
+}
+
+func TestNATSRelayRoomsWithHostileNames(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	// "a" would have received "a.b"'s messages through a wildcard on raw names
+	names := []string{"my room", "a.b", "a"}
+	peers := make(map[string]*websocket.Conn)
+	for _, name := range names {
+		_, err := hubA.CreateRoom(name, false, "", 10)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			_, ok := hubB.GetRoom(name)
+			return ok
+		}, 5*time.Second, 10*time.Millisecond)
+
+		remoteRoom, _ := hubB.GetRoom(name)
+		member, peer := newConnectedClient(t, "member", "")
+		require.NoError(t, hubB.JoinRoom(member, remoteRoom, ""))
+		peers[name] = peer
+	}
+	require.NoError(t, hubB.NATS.GetConn().Flush())
+
+	for _, name := range names {
+		localRoom, _ := hubA.GetRoom(name)
+		hubA.BroadcastToRoom(localRoom, types.Message{Content: []byte("hello " + name), Type: types.MsgTypeRoomMessage})
+	}
+	for _, name := range names {
+		assert.True(t, readUntil(peers[name], "hello "+name, 5*time.Second), name)
+	}
+	assert.False(t, readUntil(peers["a"], "hello a.b", 500*time.Millisecond))
+}
+
+func TestJetStreamRemovesLegacyRoomConsumer(t *testing.T) {
+	srv, err := natsserver.NewServer(&natsserver.Options{
+		Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true,
+		JetStream: true, StoreDir: t.TempDir(),
+	})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second), "NATS server did not start")
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	natsClient, err := natsclient.NewClient(natsclient.Config{URL: srv.ClientURL(), EnableJetStream: true, ConsumerName: "server-a"})
+	require.NoError(t, err)
+	hub := NewHub(ctx, nil, natsClient)
+
+	// An older version subscribed on the raw room name
+	legacySubject := natsclient.SubjectRoomPrefix + ".café"
+	legacyDurable := natsClient.DurableName(legacySubject)
+	js, err := natsClient.GetJetStream()
+	require.NoError(t, err)
+	_, err = js.AddConsumer(natsclient.DefaultStreamName, &nats.ConsumerConfig{
+		Durable:       legacyDurable,
+		FilterSubject: legacySubject,
+		AckPolicy:     nats.AckExplicitPolicy,
+	})
+	require.NoError(t, err)
+
+	cafe, err := hub.CreateRoom("café", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.ensureRoomSubscription(cafe))
+
+	_, err = js.ConsumerInfo(natsclient.DefaultStreamName, legacyDurable)
+	assert.ErrorIs(t, err, nats.ErrConsumerNotFound)
+	_, err = js.ConsumerInfo(natsclient.DefaultStreamName, natsClient.DurableName(natsclient.RoomSubject("café")))
+	assert.NoError(t, err)
+}
+
+func TestTraceContinuesAcrossServers(t *testing.T) {
+	recorder := tracingtest.Record(t)
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	tracedRoom, err := hubA.CreateRoom("traced", false, "", 10)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, ok := hubB.GetRoom("traced")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+	remoteRoom, _ := hubB.GetRoom("traced")
+	carol, carolPeer := newConnectedClient(t, "carol", "user-carol")
+	require.NoError(t, hubB.JoinRoom(carol, remoteRoom, ""))
+	require.NoError(t, hubB.NATS.GetConn().Flush())
+
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = "user-alice"
+	require.NoError(t, hubA.JoinRoom(alice, tracedRoom, ""))
+
+	// Stands in for the span the server starts per inbound WebSocket message
+	msgCtx, root := tracing.Tracer().Start(context.Background(), "ws.message")
+	hubA.Broadcast <- types.Message{
+		Content:      []byte("alice: traced hello"),
+		Sender:       alice,
+		Type:         types.MsgTypeRoomMessage,
+		Room:         tracedRoom,
+		TraceContext: tracing.Inject(msgCtx),
+	}
+	root.End()
+	require.True(t, readUntil(carolPeer, "traced hello", 5*time.Second))
+
+	var publish, receive []sdktrace.ReadOnlySpan
+	require.Eventually(t, func() bool {
+		publish = tracingtest.Find(recorder, "nats.publish")
+		receive = tracingtest.Find(recorder, "nats.receive")
+		return len(publish) == 1 && len(receive) == 1
+	}, 5*time.Second, 10*time.Millisecond, "only the traced message gets spans")
+
+	traceID := root.SpanContext().TraceID()
+	assert.Equal(t, traceID, publish[0].SpanContext().TraceID())
+	assert.Equal(t, root.SpanContext().SpanID(), publish[0].Parent().SpanID())
+	assert.Equal(t, trace.SpanKindProducer, publish[0].SpanKind())
+
+	assert.Equal(t, traceID, receive[0].SpanContext().TraceID())
+	assert.Equal(t, publish[0].SpanContext().SpanID(), receive[0].Parent().SpanID())
+	assert.True(t, receive[0].Parent().IsRemote(), "server B continues server A's trace")
+	assert.Equal(t, trace.SpanKindConsumer, receive[0].SpanKind())
+}
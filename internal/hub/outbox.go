@@ -0,0 +1,271 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// outboxPollInterval is how often the publisher looks for due outbox entries
+	// when it isn't woken by a new message
+	outboxPollInterval = time.Second
+	// outboxBatchSize caps the entries claimed at once
+	outboxBatchSize = 100
+	// defaultOutboxLease is how long a claimed entry is held before another
+	// publisher may retry it, e.g. after this server died mid-publish
+	defaultOutboxLease = 30 * time.Second
+	// outboxRetryBase and outboxRetryMax bound the backoff after a failed publish
+	outboxRetryBase = time.Second
+	outboxRetryMax  = time.Minute
+	// outboxSentRetention is how long published entries are kept before the
+	// publisher deletes them
+	outboxSentRetention = time.Hour
+	// outboxPruneInterval is how often published entries past outboxSentRetention are deleted
+	outboxPruneInterval = 10 * time.Minute
+	// seenMessagesCapacity is how many relayed message IDs are remembered for dedupe
+	seenMessagesCapacity = 10000
+)
+
+// outboxStore is the subset of the repository used by the message outbox
+type outboxStore interface {
+	CreateMessageWithOutbox(ctx context.Context, params db.CreateMessageParams, subject string, payload func(db.Message) ([]byte, error)) (db.Message, error)
+	ClaimOutboxEntries(ctx context.Context, limit int32, lease time.Duration) ([]db.MessageOutbox, error)
+	MarkOutboxSent(ctx context.Context, id int64) error
+	MarkOutboxFailed(ctx context.Context, id int64, errText string, retry time.Duration) error
+	DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// outboxMessage is the room message stored in an outbox entry's payload
+type outboxMessage struct {
+	Content      []byte    `json:"content"`
+	SenderID     string    `json:"sender_id"`
+	SenderConnID string    `json:"sender_conn_id,omitempty"`
+	SenderName   string    `json:"sender_name"`
+	RoomName     string    `json:"room_name"`
+	Timestamp    time.Time `json:"timestamp"`
+
+	// Trace context of the request that sent the message, continued when publishing
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// outboxRetryDelay is the backoff before retrying an entry that has already
+// failed attempts times: outboxRetryBase doubled per attempt, up to outboxRetryMax
+func outboxRetryDelay(attempts int32) time.Duration {
+	delay := outboxRetryBase
+	for i := int32(0); i < attempts && delay < outboxRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > outboxRetryMax {
+		delay = outboxRetryMax
+	}
+	return delay
+}
+
+// seenMessages remembers the most recent relayed message IDs so a message
+// published more than once, e.g. retried from the outbox, is delivered once
+type seenMessages struct {
+	mu    sync.Mutex
+	ids   map[string]struct{}
+	order []string // Ring buffer of ids in insertion order
+	next  int
+}
+
+func newSeenMessages(capacity int) *seenMessages {
+	return &seenMessages{
+		ids:   make(map[string]struct{}, capacity),
+		order: make([]string, capacity),
+	}
+}
+
+// add records id, reporting whether it was not seen before. The oldest ID is
+// forgotten once the buffer is full.
+func (s *seenMessages) add(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, seen := s.ids[id]; seen {
+		return false
+	}
+	if old := s.order[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.order[s.next] = id
+	s.next = (s.next + 1) % len(s.order)
+	s.ids[id] = struct{}{}
+	return true
+}
+
+// SaveRoomMessage persists a room message sent by an authenticated client.
+// With NATS enabled the message and its outbox entry are written in one
+// transaction and the message ID is returned; setting it on the local
+// broadcast leaves relaying to the outbox publisher. Otherwise, or when the
+// message isn't persisted, the returned ID is empty and the broadcast is
+// relayed directly; with MESSAGE_BATCH_SIZE set such messages are queued and
+// stored a batch at a time. Queries run under ctx, tracing them with the
+// request that sent the message.
+func (h *Hub) SaveRoomMessage(ctx context.Context, client *clientpkg.Client, targetRoom *room.Room, parentID pgtype.UUID, content string, body []byte) (string, error) {
+	if !client.Authenticated || client.UserID == "" || targetRoom.GetID() == "" {
+		return "", nil
+	}
+	var senderUUID, roomUUID pgtype.UUID
+	if err := senderUUID.Scan(client.UserID); err != nil {
+		return "", nil
+	}
+	if err := roomUUID.Scan(targetRoom.GetID()); err != nil {
+		return "", nil
+	}
+
+	if h.outbox == nil || !h.NATSEnabled || h.NATS == nil {
+		if h.Repo == nil {
+			return "", nil
+		}
+		if h.messageBatch != nil {
+			h.queueRoomMessage(roomUUID, senderUUID, parentID, content)
+			return "", nil
+		}
+		var err error
+		if parentID.Valid {
+			_, err = h.Repo.CreateReplyMessage(ctx, roomUUID, senderUUID, parentID, content)
+		} else {
+			_, err = h.Repo.CreateMessage(ctx, roomUUID, senderUUID, content)
+		}
+		return "", err
+	}
+
+	params := db.CreateMessageParams{
+		RoomID:          roomUUID,
+		UserID:          senderUUID,
+		Content:         content,
+		ParentMessageID: parentID,
+	}
+	subject := natsclient.RoomSubject(targetRoom.Name)
+	msg, err := h.outbox.CreateMessageWithOutbox(ctx, params, subject, func(m db.Message) ([]byte, error) {
+		return json.Marshal(outboxMessage{
+			Content:      body,
+			SenderID:     client.UserID,
+			SenderConnID: client.ID,
+			SenderName:   client.Name,
+			RoomName:     targetRoom.Name,
+			Timestamp:    m.CreatedAt.Time,
+
+			TraceContext: tracing.Inject(ctx),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	messageID := uuid.UUID(msg.ID.Bytes).String()
+	h.seen.add(messageID)
+	h.kickOutbox()
+	return messageID, nil
+}
+
+// kickOutbox wakes the outbox publisher without waiting for its next poll
+func (h *Hub) kickOutbox() {
+	select {
+	case h.outboxKick <- struct{}{}:
+	default:
+	}
+}
+
+// runOutboxPublisher relays outbox entries to NATS until ctx is done. Entries
+// left unsent by a previous run, on this or another server, are picked up too.
+// Every outboxPruneInterval it deletes entries sent over outboxSentRetention ago.
+func (h *Hub) runOutboxPublisher(ctx context.Context) {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+	prune := time.NewTicker(outboxPruneInterval)
+	defer prune.Stop()
+
+	h.pruneOutbox(ctx)
+	for {
+		h.drainOutbox(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-h.outboxKick:
+		case <-prune.C:
+			h.pruneOutbox(ctx)
+		}
+	}
+}
+
+// pruneOutbox deletes entries sent over outboxSentRetention ago
+func (h *Hub) pruneOutbox(ctx context.Context) {
+	deleted, err := h.outbox.DeleteSentOutboxEntriesBefore(ctx, time.Now().Add(-outboxSentRetention))
+	if err != nil {
+		log.Printf("Failed to delete sent outbox entries: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d outbox entries sent over %s ago", deleted, outboxSentRetention)
+	}
+}
+
+// drainOutbox publishes due outbox entries until none are left
+func (h *Hub) drainOutbox(ctx context.Context) {
+	for ctx.Err() == nil {
+		entries, err := h.outbox.ClaimOutboxEntries(ctx, outboxBatchSize, h.outboxLease)
+		if err != nil {
+			log.Printf("Failed to claim outbox entries: %v", err)
+			return
+		}
+		for _, entry := range entries {
+			h.publishOutboxEntry(ctx, entry)
+		}
+		if len(entries) < outboxBatchSize {
+			return
+		}
+	}
+}
+
+// publishOutboxEntry relays one entry, marking it sent or scheduling a retry
+func (h *Hub) publishOutboxEntry(ctx context.Context, entry db.MessageOutbox) {
+	err := h.publishOutboxMessage(entry)
+	if err == nil {
+		if err := h.outbox.MarkOutboxSent(ctx, entry.ID); err != nil {
+			// The lease runs out and the entry is published again; consumers dedupe it
+			log.Printf("Failed to mark outbox entry %d sent: %v", entry.ID, err)
+		}
+		return
+	}
+
+	retry := outboxRetryDelay(entry.Attempts)
+	log.Printf("Failed to publish outbox entry %d (attempt %d), retrying in %s: %v", entry.ID, entry.Attempts+1, retry, err)
+	if err := h.outbox.MarkOutboxFailed(ctx, entry.ID, err.Error(), retry); err != nil {
+		log.Printf("Failed to record outbox entry %d failure: %v", entry.ID, err)
+	}
+}
+
+func (h *Hub) publishOutboxMessage(entry db.MessageOutbox) error {
+	var payload outboxMessage
+	if err := json.Unmarshal(entry.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid outbox payload: %w", err)
+	}
+	return h.NATS.Publish(entry.Subject, types.Message{
+		MessageID:    uuid.UUID(entry.MessageID.Bytes).String(),
+		Content:      payload.Content,
+		Type:         types.MsgTypeRoomMessage,
+		SenderID:     payload.SenderID,
+		SenderConnID: payload.SenderConnID,
+		SenderName:   payload.SenderName,
+		RoomName:     payload.RoomName,
+		Timestamp:    payload.Timestamp,
+
+		TraceContext: payload.TraceContext,
+	})
+}
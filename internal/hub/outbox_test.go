@@ -0,0 +1,295 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/room"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOutboxStore is an in-memory message outbox with the same claim and
+// lease semantics as the database
+type fakeOutboxStore struct {
+	mu       sync.Mutex
+	nextID   int64
+	entries  map[int64]*fakeOutboxEntry
+	failSent int // MarkOutboxSent calls left to fail, as if the server died after publishing
+}
+
+type fakeOutboxEntry struct {
+	row       db.MessageOutbox
+	nextAfter time.Time
+	sent      bool
+}
+
+func newFakeOutboxStore() *fakeOutboxStore {
+	return &fakeOutboxStore{entries: make(map[int64]*fakeOutboxEntry)}
+}
+
+func (f *fakeOutboxStore) CreateMessageWithOutbox(ctx context.Context, params db.CreateMessageParams, subject string, payload func(db.Message) ([]byte, error)) (db.Message, error) {
+	msg := db.Message{
+		ID:              pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		RoomID:          params.RoomID,
+		UserID:          params.UserID,
+		Content:         params.Content,
+		CreatedAt:       pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		ParentMessageID: params.ParentMessageID,
+	}
+	body, err := payload(msg)
+	if err != nil {
+		return db.Message{}, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	f.entries[f.nextID] = &fakeOutboxEntry{row: db.MessageOutbox{
+		ID:        f.nextID,
+		MessageID: msg.ID,
+		Subject:   subject,
+		Payload:   body,
+	}}
+	return msg, nil
+}
+
+func (f *fakeOutboxStore) ClaimOutboxEntries(ctx context.Context, limit int32, lease time.Duration) ([]db.MessageOutbox, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	var claimed []db.MessageOutbox
+	for _, entry := range f.entries {
+		if entry.sent || now.Before(entry.nextAfter) || len(claimed) == int(limit) {
+			continue
+		}
+		entry.nextAfter = now.Add(lease)
+		claimed = append(claimed, entry.row)
+	}
+	sort.Slice(claimed, func(i, j int) bool { return claimed[i].ID < claimed[j].ID })
+	return claimed, nil
+}
+
+func (f *fakeOutboxStore) MarkOutboxSent(ctx context.Context, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failSent > 0 {
+		f.failSent--
+		return errors.New("connection lost")
+	}
+	f.entries[id].sent = true
+	f.entries[id].row.SentAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	return nil
+}
+
+func (f *fakeOutboxStore) MarkOutboxFailed(ctx context.Context, id int64, errText string, retry time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry := f.entries[id]
+	entry.row.Attempts++
+	entry.row.LastError = pgtype.Text{String: errText, Valid: true}
+	entry.nextAfter = time.Now().Add(retry)
+	return nil
+}
+
+func (f *fakeOutboxStore) DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var deleted int64
+	for id, entry := range f.entries {
+		if entry.sent && entry.row.SentAt.Time.Before(cutoff) {
+			delete(f.entries, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (f *fakeOutboxStore) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, entry := range f.entries {
+		if !entry.sent {
+			n++
+		}
+	}
+	return n
+}
+
+// outboxCluster is a sending hub, whose outbox publisher the tests start and
+// stop, and a receiving hub with carol in the shared room
+type outboxCluster struct {
+	sender    *Hub
+	room      *room.Room
+	store     *fakeOutboxStore
+	alice     *client.Client
+	carolPeer *websocket.Conn
+	published *atomic.Int32 // Messages seen on the room subject
+}
+
+func newOutboxCluster(t *testing.T, ctx context.Context) *outboxCluster {
+	t.Helper()
+
+	srv := startNATSServer(t, -1)
+	t.Cleanup(srv.Shutdown)
+
+	natsClient, err := natsclient.NewClient(natsclient.Config{URL: srv.ClientURL()})
+	require.NoError(t, err)
+	t.Cleanup(natsClient.Close)
+	sender := NewHub(ctx, nil, natsClient)
+	store := newFakeOutboxStore()
+	sender.outbox = store
+	sender.outboxLease = 200 * time.Millisecond
+
+	receiver := startClusterHub(t, ctx, srv.ClientURL())
+	shared, err := receiver.CreateRoom("outbox", false, "", 10)
+	require.NoError(t, err)
+	carol, carolPeer := newConnectedClient(t, "carol", "user-carol")
+	require.NoError(t, receiver.JoinRoom(carol, shared, ""))
+	require.NoError(t, receiver.NATS.GetConn().Flush())
+
+	observer, err := nats.Connect(srv.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(observer.Close)
+	published := &atomic.Int32{}
+	_, err = observer.Subscribe(natsclient.RoomSubject("outbox"), func(*nats.Msg) { published.Add(1) })
+	require.NoError(t, err)
+	require.NoError(t, observer.Flush())
+
+	localRoom, err := sender.CreateRoom("outbox", false, "", 10)
+	require.NoError(t, err)
+	localRoom.ID = uuid.NewString()
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = uuid.NewString()
+	alice.Authenticated = true
+	return &outboxCluster{sender: sender, room: localRoom, store: store, alice: alice, carolPeer: carolPeer, published: published}
+}
+
+// send saves a message from alice as the handler does, returning its ID
+func (c *outboxCluster) send(t *testing.T, content string) string {
+	t.Helper()
+	messageID, err := c.sender.SaveRoomMessage(context.Background(), c.alice, c.room, pgtype.UUID{}, content, []byte("alice: "+content))
+	require.NoError(t, err)
+	return messageID
+}
+
+// countUntilQuiet counts the messages containing want that arrive on conn
+// until none has arrived for quiet. The timed out read closes conn.
+func countUntilQuiet(conn *websocket.Conn, want string, quiet time.Duration) int {
+	count := 0
+	for readUntil(conn, want, quiet) {
+		count++
+	}
+	return count
+}
+
+func TestOutboxDeliversAfterPublisherRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newOutboxCluster(t, ctx)
+
+	// The server stores the message and dies before its publisher runs
+	messageID := cluster.send(t, "stored before the crash")
+	require.NotEmpty(t, messageID)
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, cluster.store.pending())
+	assert.Equal(t, int32(0), cluster.published.Load())
+
+	// After a restart the publisher finds the entry and relays it once
+	go cluster.sender.runOutboxPublisher(ctx)
+	require.Eventually(t, func() bool { return cluster.store.pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, countUntilQuiet(cluster.carolPeer, "stored before the crash", time.Second))
+	assert.Equal(t, int32(1), cluster.published.Load())
+}
+
+func TestOutboxRepublishIsDeduplicated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newOutboxCluster(t, ctx)
+
+	// The first publish lands but the server dies before marking it sent
+	cluster.store.failSent = 1
+	cluster.send(t, "published twice")
+	go cluster.sender.runOutboxPublisher(ctx)
+
+	require.Eventually(t, func() bool { return cluster.store.pending() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return cluster.published.Load() == 2 }, time.Second, 10*time.Millisecond, "the entry is republished once its lease expires")
+	assert.Equal(t, 1, countUntilQuiet(cluster.carolPeer, "published twice", time.Second), "carol sees the message once")
+}
+
+func TestOutboxRetriesWithBackoff(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newOutboxCluster(t, ctx)
+
+	// Publishing fails while NATS is down
+	cluster.send(t, "sent after reconnect")
+	cluster.sender.NATS.Close()
+	cluster.sender.drainOutbox(ctx)
+
+	cluster.store.mu.Lock()
+	entry := cluster.store.entries[1]
+	assert.Equal(t, int32(1), entry.row.Attempts)
+	assert.Contains(t, entry.row.LastError.String, "not connected")
+	assert.WithinDuration(t, time.Now().Add(outboxRetryBase), entry.nextAfter, 200*time.Millisecond)
+	cluster.store.mu.Unlock()
+
+	// Nothing is retried before the backoff passes
+	cluster.sender.drainOutbox(ctx)
+	assert.Equal(t, int32(1), cluster.store.entries[1].row.Attempts)
+	assert.Equal(t, 1, cluster.store.pending())
+}
+
+func TestOutboxPrunesSentEntries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cluster := newOutboxCluster(t, ctx)
+
+	cluster.send(t, "old news")
+	cluster.send(t, "fresh news")
+	cluster.sender.drainOutbox(ctx)
+	cluster.send(t, "still pending")
+	cluster.store.mu.Lock()
+	cluster.store.entries[1].row.SentAt.Time = time.Now().Add(-outboxSentRetention - time.Minute)
+	cluster.store.mu.Unlock()
+
+	cluster.sender.pruneOutbox(ctx)
+	cluster.store.mu.Lock()
+	defer cluster.store.mu.Unlock()
+	assert.NotContains(t, cluster.store.entries, int64(1), "the entry sent long ago is deleted")
+	assert.Contains(t, cluster.store.entries, int64(2), "recently sent entries are kept")
+	assert.Contains(t, cluster.store.entries, int64(3), "unsent entries are kept")
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, outboxRetryDelay(0))
+	assert.Equal(t, 2*time.Second, outboxRetryDelay(1))
+	assert.Equal(t, 32*time.Second, outboxRetryDelay(5))
+	assert.Equal(t, outboxRetryMax, outboxRetryDelay(6))
+	assert.Equal(t, outboxRetryMax, outboxRetryDelay(1000))
+}
+
+func TestSeenMessages(t *testing.T) {
+	seen := newSeenMessages(2)
+	assert.True(t, seen.add("a"))
+	assert.False(t, seen.add("a"))
+	assert.True(t, seen.add("b"))
+	assert.True(t, seen.add("c")) // Evicts a
+	assert.True(t, seen.add("a"))
+	assert.False(t, seen.add("c"))
+}
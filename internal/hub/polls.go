@@ -0,0 +1,293 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// pollCloseInterval is how often the hub looks for polls past their end time
+const pollCloseInterval = 5 * time.Second
+
+var (
+	ErrPollsUnavailable    = errors.New("polls are not available")
+	ErrPollUnauthenticated = errors.New("you must be logged in to use polls")
+	ErrPollNotFound        = errors.New("poll not found")
+	ErrPollNotInRoom       = errors.New("you must be in the poll's room")
+	ErrPollInvalidOption   = errors.New("invalid poll option")
+)
+
+// pollStore is the subset of the repository used for polls
+type pollStore interface {
+	CreatePoll(ctx context.Context, roomID, creatorID pgtype.UUID, question string, options []string, endsAt pgtype.Timestamptz) (db.Poll, error)
+	GetPollByID(ctx context.Context, id pgtype.UUID) (db.Poll, error)
+	UpsertPollVote(ctx context.Context, pollID, userID pgtype.UUID, optionIndex int32) error
+	GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]db.GetPollVoteCountsRow, error)
+	ListEndedPolls(ctx context.Context) ([]db.Poll, error)
+	ClosePoll(ctx context.Context, id pgtype.UUID) (bool, error)
+}
+
+// CreatePoll starts a poll in the client's current room and announces it to the room
+func (h *Hub) CreatePoll(client *clientpkg.Client, question string, options []string, duration time.Duration) (types.PollDTO, error) {
+	if h.polls == nil {
+		return types.PollDTO{}, ErrPollsUnavailable
+	}
+	if err := validator.ValidatePoll(question, options, duration); err != nil {
+		return types.PollDTO{}, err
+	}
+
+	var creatorID pgtype.UUID
+	if err := creatorID.Scan(client.UserID); err != nil {
+		return types.PollDTO{}, ErrPollUnauthenticated
+	}
+	currentRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || currentRoom == nil {
+		return types.PollDTO{}, errors.New("you must join a room to create a poll")
+	}
+	var roomID pgtype.UUID
+	if err := roomID.Scan(currentRoom.GetID()); err != nil {
+		return types.PollDTO{}, errors.New("room is not persisted")
+	}
+
+	trimmed := make([]string, len(options))
+	for i, option := range options {
+		trimmed[i] = strings.TrimSpace(option)
+	}
+	endsAt := pgtype.Timestamptz{Time: time.Now().Add(duration), Valid: true}
+
+	poll, err := h.polls.CreatePoll(context.Background(), roomID, creatorID, strings.TrimSpace(question), trimmed, endsAt)
+	if err != nil {
+		return types.PollDTO{}, err
+	}
+
+	dto := pollDTO(poll, currentRoom.Name, nil)
+	h.broadcastPoll(currentRoom, types.MsgTypePollCreated, dto)
+	return dto, nil
+}
+
+// VotePoll records or changes a client's vote and broadcasts the new tallies
+func (h *Hub) VotePoll(client *clientpkg.Client, pollID string, optionIndex int) (types.PollDTO, error) {
+	poll, pollRoom, err := h.lookupPoll(client, pollID)
+	if err != nil {
+		return types.PollDTO{}, err
+	}
+	if optionIndex < 0 || optionIndex >= len(poll.Options) {
+		return types.PollDTO{}, ErrPollInvalidOption
+	}
+
+	var userID pgtype.UUID
+	if err := userID.Scan(client.UserID); err != nil {
+		return types.PollDTO{}, ErrPollUnauthenticated
+	}
+	if err := h.polls.UpsertPollVote(context.Background(), poll.ID, userID, int32(optionIndex)); err != nil {
+		return types.PollDTO{}, err
+	}
+
+	dto, err := h.pollResults(context.Background(), poll, pollRoom.Name)
+	if err != nil {
+		return types.PollDTO{}, err
+	}
+	h.broadcastPoll(pollRoom, types.MsgTypePollUpdated, dto)
+	return dto, nil
+}
+
+// GetPollResults returns a poll's vote counts per option
+func (h *Hub) GetPollResults(client *clientpkg.Client, pollID string) (types.PollDTO, error) {
+	poll, pollRoom, err := h.lookupPoll(client, pollID)
+	if err != nil {
+		return types.PollDTO{}, err
+	}
+	return h.pollResults(context.Background(), poll, pollRoom.Name)
+}
+
+// lookupPoll loads a poll and checks the client is in its room
+func (h *Hub) lookupPoll(client *clientpkg.Client, pollID string) (db.Poll, *room.Room, error) {
+	if h.polls == nil {
+		return db.Poll{}, nil, ErrPollsUnavailable
+	}
+
+	var id pgtype.UUID
+	if err := id.Scan(pollID); err != nil {
+		return db.Poll{}, nil, ErrPollNotFound
+	}
+	poll, err := h.polls.GetPollByID(context.Background(), id)
+	if err != nil {
+		return db.Poll{}, nil, ErrPollNotFound
+	}
+
+	currentRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || currentRoom == nil || currentRoom.GetID() != uuid.UUID(poll.RoomID.Bytes).String() {
+		return db.Poll{}, nil, ErrPollNotInRoom
+	}
+	return poll, currentRoom, nil
+}
+
+// pollResults builds a poll's DTO with its current tallies
+func (h *Hub) pollResults(ctx context.Context, poll db.Poll, roomName string) (types.PollDTO, error) {
+	counts, err := h.polls.GetPollVoteCounts(ctx, poll.ID)
+	if err != nil {
+		return types.PollDTO{}, err
+	}
+	return pollDTO(poll, roomName, counts), nil
+}
+
+// pollDTO converts a stored poll and its vote counts for clients
+func pollDTO(poll db.Poll, roomName string, counts []db.GetPollVoteCountsRow) types.PollDTO {
+	dto := types.PollDTO{
+		ID:       uuid.UUID(poll.ID.Bytes).String(),
+		Room:     roomName,
+		Question: poll.Question,
+		Options:  poll.Options,
+		Votes:    make([]int64, len(poll.Options)),
+		EndsAt:   poll.EndsAt.Time,
+		Closed:   poll.Closed,
+	}
+	if poll.CreatorID.Valid {
+		dto.CreatorID = uuid.UUID(poll.CreatorID.Bytes).String()
+	}
+	for _, count := range counts {
+		if count.OptionIndex >= 0 && int(count.OptionIndex) < len(dto.Votes) {
+			dto.Votes[count.OptionIndex] = count.Votes
+			dto.TotalVotes += count.Votes
+		}
+	}
+	return dto
+}
+
+// broadcastPoll sends a poll event to the members of a room
+func (h *Hub) broadcastPoll(targetRoom *room.Room, msgType string, poll types.PollDTO) {
+	poll.Type = msgType
+	content, err := json.Marshal(poll)
+	if err != nil {
+		log.Printf("Failed to marshal poll %s: %v", poll.ID, err)
+		return
+	}
+
+	select {
+	case h.Broadcast <- types.Message{Content: content, Type: msgType, Room: targetRoom}:
+	case <-h.Ctx.Done():
+	}
+}
+
+// runPollCloser periodically closes polls past their end time
+func (h *Hub) runPollCloser() {
+	ticker := time.NewTicker(pollCloseInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-ticker.C:
+			h.closeEndedPolls(h.Ctx)
+		}
+	}
+}
+
+// closeEndedPolls closes every poll past its end time and announces the final
+// results. ClosePoll only succeeds once per poll, so a single server
+// announces each poll even when several run the closer.
+func (h *Hub) closeEndedPolls(ctx context.Context) {
+	polls, err := h.polls.ListEndedPolls(ctx)
+	if err != nil {
+		log.Printf("Failed to list ended polls: %v", err)
+		return
+	}
+
+	for _, poll := range polls {
+		// Resolve the room before closing, so a poll whose room can't be
+		// looked up yet stays open for a later run instead of ending unannounced
+		pollRoom, roomName, ok := h.pollRoom(ctx, poll)
+		if !ok {
+			continue
+		}
+		closed, err := h.polls.ClosePoll(ctx, poll.ID)
+		if err != nil {
+			log.Printf("Failed to close poll %s: %v", uuid.UUID(poll.ID.Bytes).String(), err)
+			continue
+		}
+		if !closed || roomName == "" {
+			continue
+		}
+		poll.Closed = true
+
+		dto, err := h.pollResults(ctx, poll, roomName)
+		if err != nil {
+			log.Printf("Failed to load results for poll %s: %v", uuid.UUID(poll.ID.Bytes).String(), err)
+			continue
+		}
+		if pollRoom != nil {
+			h.broadcastPoll(pollRoom, types.MsgTypePollEnded, dto)
+		} else {
+			h.publishPoll(roomName, types.MsgTypePollEnded, dto)
+		}
+	}
+}
+
+// pollRoom finds the room a poll belongs to, loading it from the database
+// when the sweeper evicted it. The room is nil when it isn't in memory here,
+// and the name is empty when it no longer exists; ok is false when the
+// lookup failed and should be retried.
+func (h *Hub) pollRoom(ctx context.Context, poll db.Poll) (pollRoom *room.Room, roomName string, ok bool) {
+	if r := h.roomByID(uuid.UUID(poll.RoomID.Bytes).String()); r != nil {
+		return r, r.Name, true
+	}
+	if h.Repo == nil {
+		return nil, "", true
+	}
+	dbRoom, err := h.Repo.GetRoomByID(ctx, poll.RoomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, "", true
+	}
+	if err != nil {
+		log.Printf("Failed to look up room for poll %s: %v", uuid.UUID(poll.ID.Bytes).String(), err)
+		return nil, "", false
+	}
+	r, _ := h.GetRoom(dbRoom.Name)
+	return r, dbRoom.Name, true
+}
+
+// publishPoll announces a poll to a room other servers hold but this one
+// doesn't
+func (h *Hub) publishPoll(roomName, msgType string, poll types.PollDTO) {
+	if !h.NATSEnabled || h.NATS == nil {
+		return
+	}
+	poll.Type = msgType
+	content, err := json.Marshal(poll)
+	if err != nil {
+		log.Printf("Failed to marshal poll %s: %v", poll.ID, err)
+		return
+	}
+	subject := natsclient.RoomSubject(roomName)
+	message := types.Message{Content: content, Type: msgType, RoomName: roomName}
+	if err := h.NATS.Publish(subject, message); err != nil {
+		log.Printf("Failed to publish poll %s to NATS subject %s: %v", poll.ID, subject, err)
+	}
+}
+
+// roomByID returns the local room with the given database ID, if any
+func (h *Hub) roomByID(id string) *room.Room {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+	for _, r := range h.Rooms {
+		if r.GetID() == id {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePollStore is an in-memory pollStore
+type fakePollStore struct {
+	mu    sync.Mutex
+	polls map[pgtype.UUID]db.Poll
+	votes map[pgtype.UUID]map[pgtype.UUID]int32 // poll ID -> user ID -> option index
+}
+
+func newFakePollStore() *fakePollStore {
+	return &fakePollStore{
+		polls: make(map[pgtype.UUID]db.Poll),
+		votes: make(map[pgtype.UUID]map[pgtype.UUID]int32),
+	}
+}
+
+func (f *fakePollStore) CreatePoll(ctx context.Context, roomID, creatorID pgtype.UUID, question string, options []string, endsAt pgtype.Timestamptz) (db.Poll, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll := db.Poll{
+		ID:        pgtype.UUID{Bytes: uuid.New(), Valid: true},
+		RoomID:    roomID,
+		CreatorID: creatorID,
+		Question:  question,
+		Options:   options,
+		EndsAt:    endsAt,
+	}
+	f.polls[poll.ID] = poll
+	return poll, nil
+}
+
+func (f *fakePollStore) GetPollByID(ctx context.Context, id pgtype.UUID) (db.Poll, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll, ok := f.polls[id]
+	if !ok {
+		return db.Poll{}, errors.New("no rows")
+	}
+	return poll, nil
+}
+
+func (f *fakePollStore) UpsertPollVote(ctx context.Context, pollID, userID pgtype.UUID, optionIndex int32) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll := f.polls[pollID]
+	if poll.Closed || !poll.EndsAt.Time.After(time.Now()) {
+		return repository.ErrPollClosed
+	}
+	if f.votes[pollID] == nil {
+		f.votes[pollID] = make(map[pgtype.UUID]int32)
+	}
+	f.votes[pollID][userID] = optionIndex
+	return nil
+}
+
+func (f *fakePollStore) GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]db.GetPollVoteCountsRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[int32]int64)
+	for _, option := range f.votes[pollID] {
+		counts[option]++
+	}
+	rows := make([]db.GetPollVoteCountsRow, 0, len(counts))
+	for option, votes := range counts {
+		rows = append(rows, db.GetPollVoteCountsRow{OptionIndex: option, Votes: votes})
+	}
+	return rows, nil
+}
+
+func (f *fakePollStore) ListEndedPolls(ctx context.Context) ([]db.Poll, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ended []db.Poll
+	for _, poll := range f.polls {
+		if !poll.Closed && !poll.EndsAt.Time.After(time.Now()) {
+			ended = append(ended, poll)
+		}
+	}
+	return ended, nil
+}
+
+func (f *fakePollStore) ClosePoll(ctx context.Context, id pgtype.UUID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	poll, ok := f.polls[id]
+	if !ok || poll.Closed {
+		return false, nil
+	}
+	poll.Closed = true
+	f.polls[id] = poll
+	return true, nil
+}
+
+// expire moves a poll's end time into the past
+func (f *fakePollStore) expire(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var pollID pgtype.UUID
+	pollID.Scan(id)
+	poll := f.polls[pollID]
+	poll.EndsAt = pgtype.Timestamptz{Time: time.Now().Add(-time.Second), Valid: true}
+	f.polls[pollID] = poll
+}
+
+func TestPolls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := newFakePollStore()
+	hub := NewHub(ctx, nil, nil)
+	hub.polls = store
+	go hub.Run()
+
+	pollRoom, err := hub.CreateRoom("poll-room", false, "", 10)
+	require.NoError(t, err)
+	pollRoom.ID = uuid.NewString()
+
+	voters := make([]*client.Client, 3)
+	peers := make([]*websocket.Conn, 3)
+	for i := range voters {
+		voters[i], peers[i] = newConnectedClient(t, "voter", uuid.NewString())
+		require.NoError(t, hub.JoinRoom(voters[i], pollRoom, ""))
+	}
+
+	// Invalid polls are rejected before anything is stored
+	_, err = hub.CreatePoll(voters[0], "Lunch?", []string{"pizza"}, time.Hour)
+	assert.Error(t, err)
+	_, err = hub.CreatePoll(voters[0], strings.Repeat("q", 201), []string{"pizza", "sushi"}, time.Hour)
+	assert.Error(t, err)
+	_, err = hub.CreatePoll(voters[0], "Lunch?", []string{"pizza", "sushi"}, 25*time.Hour)
+	assert.Error(t, err)
+
+	poll, err := hub.CreatePoll(voters[0], "Lunch?", []string{"pizza", "sushi", "tacos"}, time.Hour)
+	require.NoError(t, err)
+	for _, peer := range peers {
+		assert.True(t, readUntil(peer, `"type":"poll_created"`, 5*time.Second))
+	}
+
+	// One vote per user, changeable while the poll is open
+	for i, option := range []int{0, 1, 1} {
+		_, err := hub.VotePoll(voters[i], poll.ID, option)
+		require.NoError(t, err)
+	}
+	updated, err := hub.VotePoll(voters[2], poll.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 1, 0}, updated.Votes)
+	assert.True(t, readUntil(peers[1], `"votes":[2,1,0]`, 5*time.Second))
+
+	results, err := hub.GetPollResults(voters[1], poll.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 1, 0}, results.Votes)
+	assert.Equal(t, int64(3), results.TotalVotes)
+
+	_, err = hub.VotePoll(voters[0], poll.ID, 3)
+	assert.ErrorIs(t, err, ErrPollInvalidOption)
+	outsider, _ := newConnectedClient(t, "outsider", uuid.NewString())
+	_, err = hub.VotePoll(outsider, poll.ID, 0)
+	assert.ErrorIs(t, err, ErrPollNotInRoom)
+
+	// Polls past their end time close once with the final results
+	store.expire(poll.ID)
+	hub.closeEndedPolls(ctx)
+	for _, peer := range peers {
+		assert.True(t, readUntil(peer, `"type":"poll_ended"`, 5*time.Second))
+	}
+	_, err = hub.VotePoll(voters[1], poll.ID, 2)
+	assert.ErrorIs(t, err, repository.ErrPollClosed)
+
+	results, err = hub.GetPollResults(voters[1], poll.ID)
+	require.NoError(t, err)
+	assert.True(t, results.Closed)
+	assert.Equal(t, []int64{2, 1, 0}, results.Votes)
+}
+
+func TestCloseEndedPollInEvictedRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+	cfg := hub.Config()
+	cfg.RoomIdleTimeout = time.Minute
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	_, err = hub.CreateRoom("quiet", false, "", cfg.MaxClientsPerRoom)
+	require.NoError(t, err)
+	stored, err := store.GetRoomByName(ctx, "quiet")
+	require.NoError(t, err)
+	ended := pgtype.Timestamptz{Time: time.Now().Add(-time.Second), Valid: true}
+	poll, err := store.CreatePoll(ctx, stored.ID, pgtype.UUID{}, "Lunch?", []string{"pizza", "sushi"}, ended)
+	require.NoError(t, err)
+	require.Equal(t, 1, hub.sweepIdleRooms(time.Now().Add(2*time.Minute)))
+
+	// The room is loaded again to announce the results
+	hub.closeEndedPolls(ctx)
+	hub.Mutex.RLock()
+	_, inMemory := hub.Rooms["quiet"]
+	hub.Mutex.RUnlock()
+	assert.True(t, inMemory)
+	closed, err := store.GetPollByID(ctx, poll.ID)
+	require.NoError(t, err)
+	assert.True(t, closed.Closed)
+}
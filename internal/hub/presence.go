@@ -0,0 +1,193 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+)
+
+// DefaultPresenceTTL is how long presence announced by another server is
+// trusted without a refresh when PRESENCE_TTL is unset
+const DefaultPresenceTTL = 45 * time.Second
+
+// presenceRefreshesPerTTL is how many times a server re-announces its presence
+// within one TTL, so a live server's entries survive a few lost heartbeats
+const presenceRefreshesPerTTL = 3
+
+// GetPresenceTTL reads the presence TTL from environment or returns default
+func GetPresenceTTL() time.Duration {
+	if value := os.Getenv("PRESENCE_TTL"); value != "" {
+		if ttl, err := time.ParseDuration(value); err == nil && ttl >= time.Second {
+			return ttl
+		}
+		log.Printf("Invalid PRESENCE_TTL, using default: %s", DefaultPresenceTTL)
+	}
+	return DefaultPresenceTTL
+}
+
+// presenceUpdate is the payload announcing a server's occupancy of a room
+type presenceUpdate struct {
+	Room  string `json:"room"`
+	Count int    `json:"count"`
+}
+
+// remotePresence is a remote server's last announced occupancy of a room
+type remotePresence struct {
+	count     int
+	updatedAt time.Time
+}
+
+// presenceTracker holds room occupancy reported by other servers
+type presenceTracker struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	rooms map[string]map[string]remotePresence // room name -> server ID -> occupancy
+}
+
+func newPresenceTracker(ttl time.Duration) *presenceTracker {
+	return &presenceTracker{ttl: ttl, rooms: make(map[string]map[string]remotePresence)}
+}
+
+// update records a remote server's occupancy of a room
+func (p *presenceTracker) update(serverID, roomName string, count int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	servers, exists := p.rooms[roomName]
+	if !exists {
+		servers = make(map[string]remotePresence)
+		p.rooms[roomName] = servers
+	}
+	if count <= 0 {
+		delete(servers, serverID)
+		return
+	}
+	servers[serverID] = remotePresence{count: count, updatedAt: time.Now()}
+}
+
+// remoteCount sums the fresh occupancy other servers reported for a room
+func (p *presenceTracker) remoteCount(roomName string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	total := 0
+	for _, presence := range p.rooms[roomName] {
+		if time.Since(presence.updatedAt) < p.ttl {
+			total += presence.count
+		}
+	}
+	return total
+}
+
+// sweep drops occupancy that was not refreshed within the TTL, such as that
+// of a crashed server, returning how many entries were removed
+func (p *presenceTracker) sweep(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for roomName, servers := range p.rooms {
+		for serverID, presence := range servers {
+			if now.Sub(presence.updatedAt) >= p.ttl {
+				delete(servers, serverID)
+				removed++
+			}
+		}
+		if len(servers) == 0 {
+			delete(p.rooms, roomName)
+		}
+	}
+	return removed
+}
+
+// size returns the number of remote occupancy entries held
+func (p *presenceTracker) size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	total := 0
+	for _, servers := range p.rooms {
+		total += len(servers)
+	}
+	return total
+}
+
+// publishPresence announces this server's occupancy of a room to the other servers
+func (h *Hub) publishPresence(targetRoom *room.Room) {
+	if !h.NATSEnabled || h.NATS == nil {
+		return
+	}
+
+	content, _ := json.Marshal(presenceUpdate{Room: targetRoom.Name, Count: targetRoom.GetClientCount()})
+	msg := types.Message{Content: content, Type: types.MsgTypePresence}
+	if err := h.NATS.Publish(natsclient.PresenceSubject(targetRoom.Name), msg); err != nil {
+		log.Printf("Failed to publish presence for room %s: %v", targetRoom.Name, err)
+	}
+}
+
+// handlePresence records occupancy announced by another server
+func (h *Hub) handlePresence(msg types.Message) {
+	if msg.ServerID == "" || msg.ServerID == h.NATS.GetServerID() {
+		return
+	}
+
+	var update presenceUpdate
+	if err := json.Unmarshal(msg.Content, &update); err != nil {
+		log.Printf("Failed to unmarshal presence update: %v", err)
+		return
+	}
+	h.presence.update(msg.ServerID, update.Room, update.Count)
+}
+
+// runPresenceHeartbeat periodically expires stale remote presence and
+// re-announces room occupancy and local users so our entries elsewhere stay fresh
+func (h *Hub) runPresenceHeartbeat() {
+	ticker := time.NewTicker(h.presenceTTL / presenceRefreshesPerTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-ticker.C:
+			h.sweepPresence(time.Now())
+
+			h.Mutex.RLock()
+			rooms := make([]*room.Room, 0, len(h.Rooms))
+			for _, r := range h.Rooms {
+				rooms = append(rooms, r)
+			}
+			h.Mutex.RUnlock()
+
+			for _, r := range rooms {
+				if r.GetClientCount() > 0 {
+					h.publishPresence(r)
+				}
+			}
+			h.publishLocalUserPresence(false)
+		}
+	}
+}
+
+// sweepPresence removes presence entries that missed their refreshes and
+// updates the presence metrics
+func (h *Hub) sweepPresence(now time.Time) {
+	rooms := h.presence.sweep(now)
+	users := h.userPresence.sweep(now)
+	if rooms > 0 || users > 0 {
+		log.Printf("Expired %d room and %d user presence entries from unresponsive servers", rooms, users)
+	}
+	h.updatePresenceMetrics()
+}
+
+// updatePresenceMetrics records the users online across the cluster and the
+// remote presence entries held
+func (h *Hub) updatePresenceMetrics() {
+	h.Metrics.SetPresenceCounts(int64(len(h.GetPresence())), int64(h.presence.size()+h.userPresence.size()))
+}
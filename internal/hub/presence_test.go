@@ -0,0 +1,226 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresenceTracker(t *testing.T) {
+	tracker := newPresenceTracker(DefaultPresenceTTL)
+
+	tracker.update("server-a", "lobby", 3)
+	tracker.update("server-b", "lobby", 2)
+	assert.Equal(t, 5, tracker.remoteCount("lobby"))
+	assert.Equal(t, 0, tracker.remoteCount("other"))
+
+	// A server reporting zero drops out
+	tracker.update("server-b", "lobby", 0)
+	assert.Equal(t, 3, tracker.remoteCount("lobby"))
+
+	// Stale reports are ignored
+	tracker.mu.Lock()
+	tracker.rooms["lobby"]["server-a"] = remotePresence{count: 3, updatedAt: time.Now().Add(-DefaultPresenceTTL)}
+	tracker.mu.Unlock()
+	assert.Equal(t, 0, tracker.remoteCount("lobby"))
+
+	// Sweeping removes stale reports and keeps fresh ones
+	tracker.update("server-b", "other", 1)
+	assert.Equal(t, 2, tracker.size())
+	assert.Equal(t, 1, tracker.sweep(time.Now()))
+	assert.Equal(t, 1, tracker.size())
+	assert.NotContains(t, tracker.rooms, "lobby")
+	assert.Equal(t, 1, tracker.remoteCount("other"))
+}
+
+func TestRoomListOnlineCountAcrossServers(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	lobby, err := hubA.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hubA.JoinRoom(client.NewClient(nil, "alice"), lobby, ""))
+	require.NoError(t, hubA.JoinRoom(client.NewClient(nil, "bob"), lobby, ""))
+
+	findRoom := func(h *Hub) (types.RoomDTO, bool) {
+		for _, r := range h.GetRoomList(nil) {
+			if r.Name == "lobby" {
+				return r, true
+			}
+		}
+		return types.RoomDTO{}, false
+	}
+
+	require.Eventually(t, func() bool {
+		r, ok := findRoom(hubB)
+		return ok && r.OnlineCount == 2
+	}, 5*time.Second, 20*time.Millisecond)
+
+	r, _ := findRoom(hubB)
+	assert.Equal(t, 0, r.ClientCount, "no members are connected to server B")
+	r, _ = findRoom(hubA)
+	assert.Equal(t, 2, r.ClientCount)
+	assert.Equal(t, 2, r.MemberCount)
+	assert.Equal(t, 2, r.OnlineCount)
+}
+
+func TestUserPresenceTracker(t *testing.T) {
+	tracker := newUserPresenceTracker(DefaultPresenceTTL)
+
+	tracker.update(userPresenceEvent{UserID: "user-alice", Name: "alice", ServerID: "server-a", Connections: 2})
+	tracker.update(userPresenceEvent{UserID: "user-alice", Name: "alice", ServerID: "server-b", Connections: 1})
+	users := tracker.snapshot()
+	assert.Equal(t, 3, users["user-alice"].Connections)
+	assert.Equal(t, 2, users["user-alice"].Servers)
+
+	// A server reporting zero connections drops out
+	tracker.update(userPresenceEvent{UserID: "user-alice", ServerID: "server-b", Connections: 0})
+	assert.Equal(t, 1, tracker.snapshot()["user-alice"].Servers)
+
+	// Entries from a server that stopped refreshing them expire
+	tracker.mu.Lock()
+	tracker.users["user-alice"]["server-a"] = remoteUser{name: "alice", connections: 2, updatedAt: time.Now().Add(-DefaultPresenceTTL)}
+	tracker.mu.Unlock()
+	assert.NotContains(t, tracker.snapshot(), "user-alice")
+
+	// Sweeping removes stale entries without waiting for a snapshot
+	tracker.update(userPresenceEvent{UserID: "user-bob", Name: "bob", ServerID: "server-a", Connections: 1})
+	tracker.update(userPresenceEvent{UserID: "user-carol", Name: "carol", ServerID: "server-b", Connections: 1})
+	tracker.mu.Lock()
+	tracker.users["user-bob"]["server-a"] = remoteUser{name: "bob", connections: 1, updatedAt: time.Now().Add(-DefaultPresenceTTL)}
+	tracker.mu.Unlock()
+	assert.Equal(t, 1, tracker.sweep(time.Now()))
+	assert.Equal(t, 1, tracker.size())
+	assert.NotContains(t, tracker.users, "user-bob")
+}
+
+func TestGetPresenceTTL(t *testing.T) {
+	t.Setenv("PRESENCE_TTL", "")
+	assert.Equal(t, DefaultPresenceTTL, GetPresenceTTL())
+
+	t.Setenv("PRESENCE_TTL", "10s")
+	assert.Equal(t, 10*time.Second, GetPresenceTTL())
+
+	for _, invalid := range []string{"soon", "-5s", "10ms"} {
+		t.Setenv("PRESENCE_TTL", invalid)
+		assert.Equal(t, DefaultPresenceTTL, GetPresenceTTL(), invalid)
+	}
+}
+
+func TestSweepPresenceUpdatesMetrics(t *testing.T) {
+	hub := NewHub(context.Background(), nil, nil)
+
+	hub.presence.update("server-b", "lobby", 2)
+	hub.userPresence.update(userPresenceEvent{UserID: "user-alice", Name: "alice", ServerID: "server-b", Connections: 1})
+	hub.userPresence.update(userPresenceEvent{UserID: "user-bob", Name: "bob", ServerID: "server-c", Connections: 1})
+
+	hub.sweepPresence(time.Now())
+	assert.Equal(t, int64(2), hub.Metrics.GetPresenceUsers())
+	assert.Equal(t, int64(3), hub.Metrics.GetPresenceEntries())
+
+	// Server B stops refreshing; only server C's user is left
+	hub.presence.mu.Lock()
+	hub.presence.rooms["lobby"]["server-b"] = remotePresence{count: 2, updatedAt: time.Now().Add(-DefaultPresenceTTL)}
+	hub.presence.mu.Unlock()
+	hub.userPresence.mu.Lock()
+	hub.userPresence.users["user-alice"]["server-b"] = remoteUser{name: "alice", connections: 1, updatedAt: time.Now().Add(-DefaultPresenceTTL)}
+	hub.userPresence.mu.Unlock()
+
+	hub.sweepPresence(time.Now())
+	assert.Equal(t, int64(1), hub.Metrics.GetPresenceUsers())
+	assert.Equal(t, int64(1), hub.Metrics.GetPresenceEntries())
+	assert.False(t, hub.IsUserOnline("user-alice"))
+	assert.True(t, hub.IsUserOnline("user-bob"))
+}
+
+func TestPresenceExpiresAfterServerCrash(t *testing.T) {
+	t.Setenv("PRESENCE_TTL", "1s")
+
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	alice, _ := newConnectedClient(t, "alice", "user-alice")
+	hubB.Register <- alice
+	<-alice.Registered
+	require.Eventually(t, func() bool { return hubA.IsUserOnline("user-alice") }, 5*time.Second, 20*time.Millisecond)
+
+	// Server B keeps refreshing its users while it is alive
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, hubA.IsUserOnline("user-alice"))
+
+	// Server B vanishes without announcing anyone offline
+	hubB.NATS.GetConn().Close()
+	require.Eventually(t, func() bool {
+		return !hubA.IsUserOnline("user-alice") && hubA.Metrics.GetPresenceEntries() == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestUserPresenceAcrossServers(t *testing.T) {
+	srv := startNATSServer(t, -1)
+	defer srv.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hubA := startClusterHub(t, ctx, srv.ClientURL())
+	hubB := startClusterHub(t, ctx, srv.ClientURL())
+
+	register := func(h *Hub, c *client.Client) {
+		h.Register <- c
+		<-c.Registered
+	}
+	connections := func(h *Hub, userID string) int {
+		for _, p := range h.GetPresence() {
+			if p.UserID == userID {
+				return p.Connections
+			}
+		}
+		return 0
+	}
+
+	// Same user connected to both servers
+	aliceA, _ := newConnectedClient(t, "alice", "user-alice")
+	register(hubA, aliceA)
+	aliceB, _ := newConnectedClient(t, "alice", "user-alice")
+	register(hubB, aliceB)
+
+	require.Eventually(t, func() bool {
+		return connections(hubA, "user-alice") == 2 && connections(hubB, "user-alice") == 2
+	}, 5*time.Second, 20*time.Millisecond)
+
+	lobby, err := hubB.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hubB.JoinRoom(aliceB, lobby, ""))
+	members, err := hubB.ListMembers("lobby")
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, types.MemberDTO{UserID: "user-alice", Name: "alice", Online: true, JoinedAt: aliceB.GetJoinedAt().Format(time.RFC3339)}, members[0])
+
+	// Dropping one connection keeps the user online elsewhere
+	hubB.Unregister <- aliceB
+	require.Eventually(t, func() bool { return connections(hubB, "user-alice") == 1 }, 5*time.Second, 20*time.Millisecond)
+	assert.True(t, hubB.IsUserOnline("user-alice"))
+
+	// Offline only once the last connection anywhere drops
+	hubA.Unregister <- aliceA
+	require.Eventually(t, func() bool { return !hubB.IsUserOnline("user-alice") }, 5*time.Second, 20*time.Millisecond)
+	assert.False(t, hubA.IsUserOnline("user-alice"))
+}
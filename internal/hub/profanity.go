@@ -0,0 +1,83 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/validator"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ErrMessageRejected is returned for a message containing blocked words when
+// PROFANITY_ACTION is reject
+var ErrMessageRejected = errors.New("message contains blocked words")
+
+// flagStore is the subset of the repository used to record flagged messages
+type flagStore interface {
+	CreateFlaggedMessage(ctx context.Context, roomID, userID pgtype.UUID, username, content string, matchedWords []string) error
+}
+
+// FilterMessage sanitizes content sent by client in targetRoom, which is nil
+// outside a room, checks its links against the server's and the room's link
+// policies, then applies the configured profanity action. It returns the
+// content to deliver: masked for mask, unchanged for flag after recording it
+// for moderators, or ErrMessageRejected for reject. Content left empty by
+// sanitizing is ErrMessageEmpty, and a refused link a validator.ValidationError
+// with Code validator.LinkReasonNotAllowed.
+func (h *Hub) FilterMessage(client *clientpkg.Client, targetRoom *room.Room, content string) (string, error) {
+	content = validator.SanitizeMessage(content)
+	if strings.TrimSpace(content) == "" {
+		return "", ErrMessageEmpty
+	}
+
+	var roomPolicy *validator.LinkPolicy
+	if targetRoom != nil {
+		roomPolicy = targetRoom.GetLinkPolicy()
+	}
+	if err := h.Validator().CheckLinks(content, roomPolicy); err != nil {
+		return "", err
+	}
+
+	matched := validator.FindProfanity(content)
+	if len(matched) == 0 {
+		return content, nil
+	}
+
+	switch validator.GetProfanityAction() {
+	case validator.ProfanityActionReject:
+		return "", ErrMessageRejected
+	case validator.ProfanityActionFlag:
+		h.flagMessage(client, targetRoom, content, matched)
+		return content, nil
+	default:
+		return validator.MaskProfanity(content), nil
+	}
+}
+
+// flagMessage records a message for moderator review. Without a database the
+// flag is only logged.
+func (h *Hub) flagMessage(client *clientpkg.Client, targetRoom *room.Room, content string, matched []string) {
+	roomName := ""
+	if targetRoom != nil {
+		roomName = targetRoom.Name
+	}
+	log.Printf("Flagged message from %s in room %q for %v conn_id=%s", client.Name, roomName, matched, client.ID)
+	if h.flags == nil {
+		return
+	}
+
+	// Unparseable IDs are stored as null: anonymous senders and the default room
+	var roomID, userID pgtype.UUID
+	if targetRoom != nil {
+		_ = roomID.Scan(targetRoom.GetID())
+	}
+	_ = userID.Scan(client.UserID)
+	if err := h.flags.CreateFlaggedMessage(context.Background(), roomID, userID, client.Name, content, matched); err != nil {
+		log.Printf("Failed to record flagged message conn_id=%s: %v", client.ID, err)
+	}
+}
@@ -0,0 +1,150 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterMessage(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, clients := newMessageRoom(t, ctx)
+	alice := clients["alice"]
+	t.Cleanup(func() { validator.SetProfanityFilter(nil, validator.DefaultProfanityAction) })
+
+	validator.SetProfanityFilter([]string{"darn"}, validator.ProfanityActionMask)
+	content, err := mr.hub.FilterMessage(alice, mr.room, "Darn it")
+	require.NoError(t, err)
+	assert.Equal(t, "**** it", content)
+
+	validator.SetProfanityFilter([]string{"darn"}, validator.ProfanityActionReject)
+	_, err = mr.hub.FilterMessage(alice, mr.room, "darn it")
+	assert.ErrorIs(t, err, ErrMessageRejected)
+	content, err = mr.hub.FilterMessage(alice, mr.room, "clean")
+	require.NoError(t, err)
+	assert.Equal(t, "clean", content)
+
+	validator.SetProfanityFilter([]string{"darn", "heck"}, validator.ProfanityActionFlag)
+	content, err = mr.hub.FilterMessage(alice, mr.room, "darn, what the heck")
+	require.NoError(t, err)
+	assert.Equal(t, "darn, what the heck", content, "flagged messages are delivered unchanged")
+	_, err = mr.hub.FilterMessage(alice, nil, "darn")
+	require.NoError(t, err)
+
+	flagged, err := mr.store.ListFlaggedMessages(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, flagged, 2)
+	assert.Equal(t, "", flagged[0].RoomName, "sent outside a room")
+	assert.Equal(t, "lounge", flagged[1].RoomName)
+	assert.Equal(t, "alice", flagged[1].Username)
+	assert.Equal(t, mr.users["alice"].ID, flagged[1].UserID)
+	assert.Equal(t, []string{"darn", "heck"}, flagged[1].MatchedWords)
+}
+
+func TestFilterMessageSanitizes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, clients := newMessageRoom(t, ctx)
+	alice := clients["alice"]
+	t.Cleanup(func() { validator.SetMessageContentType(validator.DefaultContentType) })
+
+	content, err := mr.hub.FilterMessage(alice, mr.room, `<img src=x onerror=alert(1)>use <Enter> to <b>send</b>`)
+	require.NoError(t, err)
+	assert.Equal(t, "use &lt;Enter&gt; to send", content)
+
+	_, err = mr.hub.FilterMessage(alice, mr.room, "<script>alert(1)</script>")
+	assert.ErrorIs(t, err, ErrMessageEmpty, "nothing is left to send")
+
+	// Bidi overrides go; Arabic and CJK text stays as written
+	content, err = mr.hub.FilterMessage(alice, mr.room, "invoice_\u202Efdp.exe مرحبا 你好")
+	require.NoError(t, err)
+	assert.Equal(t, "invoice_fdp.exe مرحبا 你好", content)
+
+	validator.SetMessageContentType(validator.ContentTypeMarkdown)
+	content, err = mr.hub.FilterMessage(alice, nil, `<b>send</b> <a href="javascript:alert(1)">now</a>`)
+	require.NoError(t, err)
+	assert.Equal(t, "<b>send</b> <a>now</a>", content)
+}
+
+func TestEditMessageFiltersProfanity(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mr, clients := newMessageRoom(t, ctx)
+	t.Cleanup(func() { validator.SetProfanityFilter(nil, validator.DefaultProfanityAction) })
+
+	id := mr.post(t, "alice", "hello", time.Minute)
+	validator.SetProfanityFilter([]string{"darn"}, validator.ProfanityActionReject)
+	_, err := mr.hub.EditMessage(clients["alice"], id, "darn")
+	assert.ErrorIs(t, err, ErrMessageRejected)
+	content, _ := mr.content(id)
+	assert.Equal(t, "hello", content)
+
+	validator.SetProfanityFilter([]string{"darn"}, validator.ProfanityActionMask)
+	dto, err := mr.hub.EditMessage(clients["alice"], id, "darn")
+	require.NoError(t, err)
+	assert.Equal(t, "****", dto.Content)
+}
+
+func TestRoomLinkPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+	v, err := validator.New(validator.Config{LinkPolicy: validator.LinkPolicy{DeniedDomains: []string{"evil.com"}}})
+	require.NoError(t, err)
+	hub.SetValidator(v)
+
+	alice, _ := newConnectedClient(t, "alice", "user-alice")
+	bob, _ := newConnectedClient(t, "bob", "user-bob")
+	for _, c := range []*client.Client{alice, bob} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	lounge, err := hub.CreateRoomAs(alice, "lounge", false, "", 10)
+	require.NoError(t, err)
+
+	linkCode := func(err error) string {
+		var validationErr validator.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		return validationErr.Code
+	}
+	_, err = hub.FilterMessage(bob, lounge, "see evil[.]com")
+	assert.Equal(t, validator.LinkReasonNotAllowed, linkCode(err), "the server's policy applies to every room")
+	_, err = hub.FilterMessage(bob, lounge, "see https://docs.example.com")
+	require.NoError(t, err)
+
+	// Only the creator may set a room policy, and it only adds restrictions
+	docsOnly := &types.LinkPolicyDTO{Mode: "allowlist", AllowedDomains: []string{"docs.example.com", "evil.com"}}
+	assert.Error(t, hub.SetRoomLinkPolicy(bob, "lounge", docsOnly))
+	assert.Error(t, hub.SetRoomLinkPolicy(alice, "lounge", &types.LinkPolicyDTO{Mode: "allowlist"}))
+	require.NoError(t, hub.SetRoomLinkPolicy(alice, "lounge", docsOnly))
+
+	content, err := hub.FilterMessage(bob, lounge, "see https://docs.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "see https://docs.example.com", content)
+	_, err = hub.FilterMessage(bob, lounge, "see other.example.com")
+	assert.Equal(t, validator.LinkReasonNotAllowed, linkCode(err))
+	_, err = hub.FilterMessage(bob, lounge, "see https://evil.com")
+	assert.Equal(t, validator.LinkReasonNotAllowed, linkCode(err))
+	_, err = hub.FilterMessage(bob, nil, "see other.example.com")
+	assert.NoError(t, err, "outside the room only the server's policy applies")
+
+	policy, err := hub.GetRoomPolicy("lounge")
+	require.NoError(t, err)
+	assert.Equal(t, &types.LinkPolicyDTO{Mode: "allowlist", AllowedDomains: []string{"docs.example.com", "evil.com"}}, policy.LinkPolicy)
+
+	// An empty policy removes the room's own
+	require.NoError(t, hub.SetRoomLinkPolicy(alice, "lounge", &types.LinkPolicyDTO{}))
+	assert.Nil(t, lounge.GetLinkPolicy())
+	_, err = hub.FilterMessage(bob, lounge, "see other.example.com")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,156 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// profileLookupsPerMinute caps get_user requests per requester
+	profileLookupsPerMinute = 10
+	// maxLookupLimiters is how many requesters are tracked before idle ones are pruned
+	maxLookupLimiters = 1000
+)
+
+var (
+	ErrUserNotFound           = errors.New("user not found")
+	ErrProfilesUnavailable    = errors.New("user profiles need a database")
+	ErrProfileLookupRateLimit = errors.New("too many profile lookups, try again later")
+)
+
+// userStore is the subset of the repository used to look up profiles
+type userStore interface {
+	GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error)
+	GetUserByUsername(ctx context.Context, username string) (db.User, error)
+}
+
+// lookupLimiter rate-limits profile lookups per requester
+type lookupLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newLookupLimiter() *lookupLimiter {
+	return &lookupLimiter{limiters: make(map[string]*rate.Limiter)}
+}
+
+// allow reports whether key may make another lookup now
+func (l *lookupLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiter, exists := l.limiters[key]
+	if !exists {
+		if len(l.limiters) >= maxLookupLimiters {
+			l.prune()
+		}
+		limiter = rate.NewLimiter(rate.Every(time.Minute/profileLookupsPerMinute), profileLookupsPerMinute)
+		l.limiters[key] = limiter
+	}
+	return limiter.Allow()
+}
+
+// prune drops limiters that have refilled, as they behave like new ones;
+// callers must hold l.mu
+func (l *lookupLimiter) prune() {
+	for key, limiter := range l.limiters {
+		if limiter.Tokens() >= profileLookupsPerMinute {
+			delete(l.limiters, key)
+		}
+	}
+}
+
+// GetUserProfile returns the public profile of the user with the given
+// username or user ID, as seen by requester. Status is "online" when the user
+// is connected to any server, and RoomsInCommon lists the rooms both users'
+// connections on this server are in.
+func (h *Hub) GetUserProfile(requester *clientpkg.Client, name string) (types.PublicUserDTO, error) {
+	key := requester.UserID
+	if key == "" {
+		key = requester.Name
+	}
+	if !h.profileLookups.allow(key) {
+		return types.PublicUserDTO{}, ErrProfileLookupRateLimit
+	}
+	if h.users == nil {
+		return types.PublicUserDTO{}, ErrProfilesUnavailable
+	}
+
+	user, err := h.findUser(name)
+	if err != nil {
+		return types.PublicUserDTO{}, err
+	}
+
+	userID := uuid.UUID(user.ID.Bytes).String()
+	profile := types.PublicUserDTO{
+		UserID:        userID,
+		Username:      user.Username,
+		Status:        types.UserStatusOffline,
+		RoomsInCommon: h.roomsInCommon(requester, userID),
+		MemberSince:   user.CreatedAt.Time,
+	}
+	if h.IsUserOnline(userID) {
+		profile.Status = types.UserStatusOnline
+	}
+	return profile, nil
+}
+
+// findUser looks name up as a user ID first, then as a username
+func (h *Hub) findUser(name string) (db.User, error) {
+	ctx := context.Background()
+
+	var user db.User
+	var err error
+	var id pgtype.UUID
+	if id.Scan(name) == nil {
+		user, err = h.users.GetUserByID(ctx, id)
+	} else {
+		user, err = h.users.GetUserByUsername(ctx, name)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return db.User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
+// roomsInCommon returns the sorted names of rooms that both the requester's
+// user and userID have a local connection in
+func (h *Hub) roomsInCommon(requester *clientpkg.Client, userID string) []string {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+
+	mine := make(map[*room.Room]bool)
+	if sessions := h.userSessions[requester.UserID]; requester.UserID != "" && len(sessions) > 0 {
+		for c := range sessions {
+			if r, ok := h.ClientRooms[c]; ok {
+				mine[r] = true
+			}
+		}
+	} else if r, ok := h.ClientRooms[requester]; ok {
+		mine[r] = true
+	}
+
+	common := make([]string, 0)
+	seen := make(map[*room.Room]bool)
+	for c := range h.userSessions[userID] {
+		if r, ok := h.ClientRooms[c]; ok && mine[r] && !seen[r] {
+			seen[r] = true
+			common = append(common, r.Name)
+		}
+	}
+	sort.Strings(common)
+	return common
+}
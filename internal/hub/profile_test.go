@@ -0,0 +1,140 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeUserStore looks users up in memory
+type fakeUserStore struct {
+	users []db.User
+}
+
+func (f *fakeUserStore) add(username string, createdAt time.Time) string {
+	id := uuid.New()
+	f.users = append(f.users, db.User{
+		ID:        pgtype.UUID{Bytes: id, Valid: true},
+		Username:  username,
+		CreatedAt: pgtype.Timestamptz{Time: createdAt, Valid: true},
+	})
+	return id.String()
+}
+
+func (f *fakeUserStore) GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error) {
+	for _, u := range f.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return db.User{}, pgx.ErrNoRows
+}
+
+func (f *fakeUserStore) GetUserByUsername(ctx context.Context, username string) (db.User, error) {
+	for _, u := range f.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return db.User{}, pgx.ErrNoRows
+}
+
+func TestGetUserProfile(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+	store := &fakeUserStore{}
+	hub.users = store
+
+	joined := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	aliceID := store.add("alice", joined)
+	bobID := store.add("bob", joined.Add(time.Hour))
+	store.add("carol", joined)
+
+	lobby, err := hub.CreateRoom("lobby", false, "", 10)
+	require.NoError(t, err)
+	games, err := hub.CreateRoom("games", false, "", 10)
+	require.NoError(t, err)
+	music, err := hub.CreateRoom("music", false, "", 10)
+	require.NoError(t, err)
+
+	// Alice and Bob each have two connections; they share lobby and games
+	alice1, _ := newConnectedClient(t, "alice", aliceID)
+	alice2, _ := newConnectedClient(t, "alice", aliceID)
+	bob1, _ := newConnectedClient(t, "bob", bobID)
+	bob2, _ := newConnectedClient(t, "bob", bobID)
+	for _, c := range []*client.Client{alice1, alice2, bob1, bob2} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	require.NoError(t, hub.JoinRoom(alice1, lobby, ""))
+	require.NoError(t, hub.JoinRoom(alice2, games, ""))
+	require.NoError(t, hub.JoinRoom(bob1, games, ""))
+	require.NoError(t, hub.JoinRoom(bob2, music, ""))
+
+	profile, err := hub.GetUserProfile(alice1, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, types.PublicUserDTO{
+		UserID:        bobID,
+		Username:      "bob",
+		Status:        types.UserStatusOnline,
+		RoomsInCommon: []string{"games"},
+		MemberSince:   joined.Add(time.Hour),
+	}, profile)
+
+	require.NoError(t, hub.JoinRoom(bob2, lobby, ""))
+	profile, err = hub.GetUserProfile(alice1, bobID)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"games", "lobby"}, profile.RoomsInCommon, "lookup by user ID sees every shared room")
+
+	// Offline users have nothing in common
+	profile, err = hub.GetUserProfile(alice1, "carol")
+	require.NoError(t, err)
+	assert.Equal(t, types.UserStatusOffline, profile.Status)
+	assert.Empty(t, profile.RoomsInCommon)
+
+	_, err = hub.GetUserProfile(alice1, "dave")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	_, err = hub.GetUserProfile(alice1, uuid.NewString())
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestGetUserProfileRateLimit(t *testing.T) {
+	hub := NewHub(context.Background(), nil, nil)
+	store := &fakeUserStore{}
+	hub.users = store
+	store.add("bob", time.Now())
+
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = "user-alice"
+	for i := 0; i < profileLookupsPerMinute; i++ {
+		_, err := hub.GetUserProfile(alice, "bob")
+		require.NoError(t, err)
+	}
+	_, err := hub.GetUserProfile(alice, "bob")
+	assert.ErrorIs(t, err, ErrProfileLookupRateLimit)
+
+	// The limit is per requester
+	carol := client.NewClient(nil, "carol")
+	carol.UserID = "user-carol"
+	_, err = hub.GetUserProfile(carol, "bob")
+	assert.NoError(t, err)
+}
+
+func TestGetUserProfileWithoutDatabase(t *testing.T) {
+	hub := NewHub(context.Background(), nil, nil)
+	_, err := hub.GetUserProfile(client.NewClient(nil, "alice"), "bob")
+	assert.ErrorIs(t, err, ErrProfilesUnavailable)
+}
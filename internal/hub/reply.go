@@ -0,0 +1,202 @@
+package hub
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var (
+	// ErrReplyNotInRoom is returned when the replied-to message belongs to a different room
+	ErrReplyNotInRoom = errors.New("reply target is not in this room")
+	// ErrReplyNotFound is returned when the replied-to message does not exist
+	ErrReplyNotFound = errors.New("reply target does not exist")
+)
+
+const (
+	// replyPreviewLength is the number of characters of the parent quoted in a reply
+	replyPreviewLength = 50
+	// replyCacheCapacity is the number of parent messages cached per room
+	replyCacheCapacity = 100
+	// replyCacheTTL is how long a cached parent message stays valid
+	replyCacheTTL = time.Minute
+)
+
+// replyTarget is the subset of a parent message needed to validate and quote a reply
+type replyTarget struct {
+	ID        pgtype.UUID
+	RoomID    string
+	Sender    string
+	Content   string
+	CreatedAt time.Time
+}
+
+// ResolveReply validates that parentID is a message in targetRoom and returns
+// the parsed parent ID along with a quoted preview for the broadcast
+func (h *Hub) ResolveReply(targetRoom *room.Room, parentID string) (pgtype.UUID, *types.ReplyPreview, error) {
+	var parentUUID pgtype.UUID
+	if err := parentUUID.Scan(parentID); err != nil {
+		return pgtype.UUID{}, nil, errors.New("invalid reply_to message ID")
+	}
+
+	parentKey := uuid.UUID(parentUUID.Bytes).String()
+	target, ok := h.replyCache.get(targetRoom.Name, parentKey)
+	if !ok {
+		var err error
+		target, err = h.lookupReplyTarget(context.Background(), parentUUID)
+		if err != nil {
+			return pgtype.UUID{}, nil, err
+		}
+		if target.RoomID != targetRoom.GetID() {
+			return pgtype.UUID{}, nil, ErrReplyNotInRoom
+		}
+		h.replyCache.put(targetRoom.Name, parentKey, target)
+	}
+
+	return parentUUID, &types.ReplyPreview{
+		ID:             parentKey,
+		Sender:         target.Sender,
+		ContentPreview: truncatePreview(target.Content, replyPreviewLength),
+		Timestamp:      target.CreatedAt.Format(time.RFC3339),
+	}, nil
+}
+
+// lookupReplyTargetFromRepo loads a parent message and its sender from the database
+func (h *Hub) lookupReplyTargetFromRepo(ctx context.Context, id pgtype.UUID) (replyTarget, error) {
+	if h.Repo == nil {
+		return replyTarget{}, errors.New("replies are not available without a database")
+	}
+
+	msg, err := h.Repo.GetMessageByID(ctx, id)
+	if err != nil {
+		return replyTarget{}, ErrReplyNotFound
+	}
+
+	sender := ""
+	if user, err := h.Repo.GetUserByID(ctx, msg.UserID); err == nil {
+		sender = user.Username
+	}
+
+	return replyTarget{
+		ID:        msg.ID,
+		RoomID:    uuid.UUID(msg.RoomID.Bytes).String(),
+		Sender:    sender,
+		Content:   msg.Content,
+		CreatedAt: msg.CreatedAt.Time,
+	}, nil
+}
+
+// truncatePreview returns at most n characters of content
+func truncatePreview(content string, n int) string {
+	runes := []rune(content)
+	if len(runes) <= n {
+		return content
+	}
+	return string(runes[:n])
+}
+
+// replyCache is a per-room LRU of recently replied-to parent messages
+type replyCache struct {
+	mu    sync.Mutex
+	rooms map[string]*roomReplyLRU
+}
+
+// roomReplyLRU holds the cached parents of a single room
+type roomReplyLRU struct {
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// replyCacheEntry is a cached parent message with its expiry
+type replyCacheEntry struct {
+	key       string
+	target    replyTarget
+	expiresAt time.Time
+}
+
+func newReplyCache() *replyCache {
+	return &replyCache{rooms: make(map[string]*roomReplyLRU)}
+}
+
+// get returns a cached parent for a room if present and not expired
+func (c *replyCache) get(roomName, key string) (replyTarget, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lru, exists := c.rooms[roomName]
+	if !exists {
+		return replyTarget{}, false
+	}
+	elem, exists := lru.entries[key]
+	if !exists {
+		return replyTarget{}, false
+	}
+
+	entry := elem.Value.(*replyCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		lru.order.Remove(elem)
+		delete(lru.entries, key)
+		return replyTarget{}, false
+	}
+
+	lru.order.MoveToFront(elem)
+	return entry.target, true
+}
+
+// put caches a parent for a room, evicting the least recently used entry when full
+func (c *replyCache) put(roomName, key string, target replyTarget) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lru, exists := c.rooms[roomName]
+	if !exists {
+		lru = &roomReplyLRU{order: list.New(), entries: make(map[string]*list.Element)}
+		c.rooms[roomName] = lru
+	}
+
+	expiresAt := time.Now().Add(replyCacheTTL)
+	if elem, exists := lru.entries[key]; exists {
+		entry := elem.Value.(*replyCacheEntry)
+		entry.target = target
+		entry.expiresAt = expiresAt
+		lru.order.MoveToFront(elem)
+		return
+	}
+
+	lru.entries[key] = lru.order.PushFront(&replyCacheEntry{key: key, target: target, expiresAt: expiresAt})
+	if lru.order.Len() > replyCacheCapacity {
+		oldest := lru.order.Back()
+		lru.order.Remove(oldest)
+		delete(lru.entries, oldest.Value.(*replyCacheEntry).key)
+	}
+}
+
+// remove drops a cached parent, e.g. after the message was edited or deleted
+func (c *replyCache) remove(roomName, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lru, exists := c.rooms[roomName]
+	if !exists {
+		return
+	}
+	if elem, exists := lru.entries[key]; exists {
+		lru.order.Remove(elem)
+		delete(lru.entries, key)
+	}
+}
+
+// removeRoom drops all cached parents for a room
+func (c *replyCache) removeRoom(roomName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.rooms, roomName)
+}
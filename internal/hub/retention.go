@@ -0,0 +1,69 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultMessageRetentionDays keeps messages forever when MESSAGE_RETENTION_DAYS is unset
+	DefaultMessageRetentionDays = 0
+	// retentionCleanupInterval is how often expired messages are deleted
+	retentionCleanupInterval = time.Hour
+	// retentionBatchSize is how many messages one delete statement removes
+	retentionBatchSize = 1000
+)
+
+// GetMessageRetentionDays reads how many days messages are kept from
+// environment or returns default; 0 keeps them forever
+func GetMessageRetentionDays() int {
+	if value := os.Getenv("MESSAGE_RETENTION_DAYS"); value != "" {
+		if days, err := strconv.Atoi(value); err == nil && days >= 0 {
+			return days
+		}
+		log.Printf("Invalid MESSAGE_RETENTION_DAYS, using default: %d", DefaultMessageRetentionDays)
+	}
+	return DefaultMessageRetentionDays
+}
+
+// retentionStore is the subset of the repository used to delete expired messages
+type retentionStore interface {
+	DeleteExpiredMessages(ctx context.Context, cutoff time.Time, batchSize int32) (deleted int64, ran bool, err error)
+}
+
+// runRetentionCleanup deletes expired messages at startup and then every
+// retentionCleanupInterval
+func (h *Hub) runRetentionCleanup() {
+	ticker := time.NewTicker(retentionCleanupInterval)
+	defer ticker.Stop()
+
+	h.deleteExpiredMessages(h.Ctx)
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-ticker.C:
+			h.deleteExpiredMessages(h.Ctx)
+		}
+	}
+}
+
+// deleteExpiredMessages deletes messages older than the retention period
+// from rooms without their own retention. The store's lock means one server
+// in the cluster does the work; the others skip the run.
+func (h *Hub) deleteExpiredMessages(ctx context.Context) {
+	cutoff := time.Now().AddDate(0, 0, -h.retentionDays)
+	deleted, ran, err := h.retention.DeleteExpiredMessages(ctx, cutoff, retentionBatchSize)
+	if deleted > 0 {
+		h.Metrics.AddRetentionDeletedMessages(deleted)
+		log.Printf("Deleted %d messages older than %d days", deleted, h.retentionDays)
+	}
+	if err != nil {
+		log.Printf("Failed to delete expired messages: %v", err)
+	} else if !ran {
+		log.Println("Skipping message retention cleanup, another server is running it")
+	}
+}
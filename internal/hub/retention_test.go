@@ -0,0 +1,78 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lockedRetentionStore behaves like another server holds the cleanup lock
+type lockedRetentionStore struct{}
+
+func (lockedRetentionStore) DeleteExpiredMessages(ctx context.Context, cutoff time.Time, batchSize int32) (int64, bool, error) {
+	return 0, false, nil
+}
+
+func TestDeleteExpiredMessages(t *testing.T) {
+	t.Setenv("MESSAGE_RETENTION_DAYS", "30")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	require.Equal(t, 30, hub.retentionDays)
+
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	for _, name := range []string{"lounge", "archive"} {
+		_, err := hub.CreateRoom(name, false, "", 10)
+		require.NoError(t, err)
+	}
+	lounge, err := store.GetRoomByName(ctx, "lounge")
+	require.NoError(t, err)
+	archive, err := store.GetRoomByName(ctx, "archive")
+	require.NoError(t, err)
+	require.NoError(t, store.UpdateRoomRetentionDays(ctx, archive.ID, pgtype.Int4{Int32: 365, Valid: true}))
+
+	at := func(age time.Duration) pgtype.Timestamptz {
+		return pgtype.Timestamptz{Time: time.Now().Add(-age), Valid: true}
+	}
+	day := 24 * time.Hour
+	_, err = store.BulkCreateMessages(ctx, []db.BulkCreateMessagesParams{
+		{RoomID: lounge.ID, UserID: alice.ID, Content: "ancient", CreatedAt: at(90 * day)},
+		{RoomID: lounge.ID, UserID: alice.ID, Content: "stale", CreatedAt: at(31 * day)},
+		{RoomID: lounge.ID, UserID: alice.ID, Content: "fresh", CreatedAt: at(29 * day)},
+		{RoomID: archive.ID, UserID: alice.ID, Content: "archived", CreatedAt: at(90 * day)},
+	})
+	require.NoError(t, err)
+
+	hub.deleteExpiredMessages(ctx)
+
+	var remaining []string
+	for _, m := range store.Messages() {
+		remaining = append(remaining, m.Content)
+	}
+	assert.ElementsMatch(t, []string{"fresh", "archived"}, remaining)
+	assert.Equal(t, int64(2), hub.Metrics.GetRetentionDeletedMessages())
+
+	// A run skipped because another server holds the lock counts nothing
+	hub.retention = lockedRetentionStore{}
+	hub.deleteExpiredMessages(ctx)
+	assert.Equal(t, int64(2), hub.Metrics.GetRetentionDeletedMessages())
+}
+
+func TestGetMessageRetentionDays(t *testing.T) {
+	t.Setenv("MESSAGE_RETENTION_DAYS", "")
+	assert.Equal(t, DefaultMessageRetentionDays, GetMessageRetentionDays())
+	t.Setenv("MESSAGE_RETENTION_DAYS", "-1")
+	assert.Equal(t, DefaultMessageRetentionDays, GetMessageRetentionDays())
+	t.Setenv("MESSAGE_RETENTION_DAYS", "7")
+	assert.Equal(t, 7, GetMessageRetentionDays())
+}
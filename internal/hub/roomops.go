@@ -0,0 +1,49 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+)
+
+const (
+	// DefaultMaxConcurrentRoomOps caps concurrent room mutations when MAX_CONCURRENT_ROOM_OPS is unset
+	DefaultMaxConcurrentRoomOps = 50
+)
+
+// ErrRoomOpTimeout is returned when a room operation could not start within HubConfig.RoomOpTimeout
+var ErrRoomOpTimeout = errors.New("server busy: room operation timed out")
+
+// GetMaxConcurrentRoomOps reads the room operation limit from environment or returns default
+func GetMaxConcurrentRoomOps() int {
+	if value := os.Getenv("MAX_CONCURRENT_ROOM_OPS"); value != "" {
+		if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+			return limit
+		}
+		log.Printf("Invalid MAX_CONCURRENT_ROOM_OPS, using default: %d", DefaultMaxConcurrentRoomOps)
+	}
+	return DefaultMaxConcurrentRoomOps
+}
+
+// acquireRoomOp waits for a free room operation slot; callers must releaseRoomOp on success
+func (h *Hub) acquireRoomOp() error {
+	h.Metrics.IncrementRoomOpQueueDepth()
+	defer h.Metrics.DecrementRoomOpQueueDepth()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.Config().RoomOpTimeout)
+	defer cancel()
+
+	select {
+	case h.roomOpSem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ErrRoomOpTimeout
+	}
+}
+
+// releaseRoomOp frees a slot taken by acquireRoomOp
+func (h *Hub) releaseRoomOp() {
+	<-h.roomOpSem
+}
@@ -0,0 +1,196 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// DefaultRoomIdleTimeout keeps empty rooms in memory when ROOM_IDLE_TIMEOUT is unset
+	DefaultRoomIdleTimeout = 0
+	// DefaultRoomSweepInterval is how often idle rooms are looked for when ROOM_SWEEP_INTERVAL is unset
+	DefaultRoomSweepInterval = time.Minute
+)
+
+// GetRoomIdleTimeout reads how long a stored room may stay empty before it
+// is evicted from memory from environment or returns default; 0 never evicts
+func GetRoomIdleTimeout() time.Duration {
+	return getMessageWindow("ROOM_IDLE_TIMEOUT", DefaultRoomIdleTimeout)
+}
+
+// GetRoomSweepInterval reads how often idle rooms are evicted from
+// environment or returns default
+func GetRoomSweepInterval() time.Duration {
+	if value := os.Getenv("ROOM_SWEEP_INTERVAL"); value != "" {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			return interval
+		}
+		log.Printf("Invalid ROOM_SWEEP_INTERVAL, using default: %s", DefaultRoomSweepInterval)
+	}
+	return DefaultRoomSweepInterval
+}
+
+// runRoomSweeper evicts idle rooms every roomSweepInterval while
+// RoomIdleTimeout is set
+func (h *Hub) runRoomSweeper() {
+	ticker := time.NewTicker(h.roomSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.sweepIdleRooms(now)
+		}
+	}
+}
+
+// sweepIdleRooms drops the rooms that have been empty for RoomIdleTimeout
+// from memory and returns how many it dropped. Only stored rooms whose
+// in-memory state the database can rebuild are dropped; roomLocked loads
+// them again when they are next used.
+func (h *Hub) sweepIdleRooms(now time.Time) int {
+	cfg := h.Config()
+	if cfg.RoomIdleTimeout <= 0 {
+		return 0
+	}
+	cutoff := now.Add(-cfg.RoomIdleTimeout)
+
+	h.Mutex.Lock()
+	var evicted []string
+	for name, r := range h.Rooms {
+		if !h.roomEvictable(r, cutoff) {
+			continue
+		}
+		delete(h.Rooms, name)
+		// Joins through a pointer taken before the eviction fail on this
+		r.Active = false
+		h.evictedRooms[name] = struct{}{}
+		// The subscription delivers to r, so a reloaded room needs a new one
+		if h.NATS != nil {
+			h.removeRoomSubscription(name)
+		}
+		evicted = append(evicted, name)
+	}
+	h.Mutex.Unlock()
+
+	for _, name := range evicted {
+		h.replyCache.removeRoom(name)
+	}
+	if len(evicted) > 0 {
+		log.Printf("Evicted %d rooms idle for %s from memory", len(evicted), cfg.RoomIdleTimeout)
+	}
+	return len(evicted)
+}
+
+// roomEvictable reports whether r has been empty since cutoff and reloading
+// it would give the same room: it is stored and has no alert, link policy or
+// creator without a user ID, which the database doesn't keep. Callers must
+// hold h.Mutex.
+func (h *Hub) roomEvictable(r *room.Room, cutoff time.Time) bool {
+	if h.IsDefaultRoom(r.Name) || r.GetID() == "" {
+		return false
+	}
+	if since, empty := r.EmptySince(); !empty || since.After(cutoff) {
+		return false
+	}
+	if r.GetAlert() != nil || r.GetLinkPolicy() != nil {
+		return false
+	}
+	return r.Creator == nil || r.Creator.UserID != ""
+}
+
+// roomLocked returns the named room, loading it from the database when the
+// sweeper evicted it. Callers must hold h.Mutex for writing.
+func (h *Hub) roomLocked(name string) (*room.Room, bool) {
+	if r, exists := h.Rooms[name]; exists {
+		return r, true
+	}
+	if _, evicted := h.evictedRooms[name]; !evicted || h.Repo == nil {
+		return nil, false
+	}
+
+	ctx := context.Background()
+	dbRoom, err := h.Repo.GetRoomByName(ctx, name)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			delete(h.evictedRooms, name) // Deleted meanwhile by another server
+		} else {
+			log.Printf("Failed to reload room %s from database: %v", name, err)
+		}
+		return nil, false
+	}
+	r := h.reloadRoom(ctx, dbRoom)
+	delete(h.evictedRooms, name)
+	h.Rooms[name] = r
+	log.Printf("Reloaded room %s from database", name)
+	return r, true
+}
+
+// reloadRoom rebuilds an evicted room from its database row. The stored
+// creator's oldest connection becomes the creator again, or a stand-in until
+// they join as for rooms created over the API, so the room isn't handed to
+// whoever joins first. Callers must hold h.Mutex.
+func (h *Hub) reloadRoom(ctx context.Context, dbRoom db.Room) *room.Room {
+	r := h.roomFromDB(dbRoom)
+	if dbRoom.CreatorID.Valid {
+		creatorID := uuid.UUID(dbRoom.CreatorID.Bytes).String()
+		creator := h.userClientLocked(creatorID)
+		if creator == nil {
+			creator = clientpkg.NewClient(nil, "")
+			creator.UserID = creatorID
+			creator.Authenticated = true
+		}
+		r.SetCreator(creator)
+	}
+	h.loadMemberRoles(ctx, r, dbRoom.ID)
+	return r
+}
+
+// evictedRoomList describes the evicted rooms for GetRoomList from their
+// database rows, returning the rooms and their IDs in the same order
+func (h *Hub) evictedRoomList(client *clientpkg.Client, evicted []string) ([]types.RoomDTO, []pgtype.UUID) {
+	if len(evicted) == 0 || h.Repo == nil {
+		return nil, nil
+	}
+
+	dbRooms, err := h.Repo.GetRoomsByNames(context.Background(), evicted)
+	if err != nil {
+		log.Printf("Failed to list evicted rooms: %v", err)
+		return nil, nil
+	}
+	roomIDs := make([]pgtype.UUID, len(dbRooms))
+	for i, dbRoom := range dbRooms {
+		roomIDs[i] = dbRoom.ID
+	}
+	memberCounts, _ := h.memberCounts(roomIDs)
+
+	roomList := make([]types.RoomDTO, len(dbRooms))
+	for i, dbRoom := range dbRooms {
+		isCreator := client != nil && client.UserID != "" && dbRoom.CreatorID.Valid &&
+			uuid.UUID(dbRoom.CreatorID.Bytes).String() == client.UserID
+		roomList[i] = types.RoomDTO{
+			Name:              dbRoom.Name,
+			Private:           dbRoom.Private.Bool,
+			MemberCount:       memberCounts[dbRoom.ID.Bytes],
+			OnlineCount:       h.presence.remoteCount(dbRoom.Name),
+			MaxClients:        h.storedCapacity(dbRoom),
+			IsCreator:         isCreator,
+			SuppressJoinLeave: dbRoom.SuppressJoinLeave,
+		}
+	}
+	return roomList, roomIDs
+}
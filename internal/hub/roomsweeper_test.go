@@ -0,0 +1,131 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRoomIdleTimeout(t *testing.T) {
+	assert.Equal(t, time.Duration(DefaultRoomIdleTimeout), GetRoomIdleTimeout())
+	assert.Equal(t, DefaultRoomSweepInterval, GetRoomSweepInterval())
+	t.Setenv("ROOM_IDLE_TIMEOUT", "30m")
+	t.Setenv("ROOM_SWEEP_INTERVAL", "10s")
+	assert.Equal(t, 30*time.Minute, GetRoomIdleTimeout())
+	assert.Equal(t, 10*time.Second, GetRoomSweepInterval())
+	t.Setenv("ROOM_IDLE_TIMEOUT", "-1m")
+	t.Setenv("ROOM_SWEEP_INTERVAL", "0s")
+	assert.Equal(t, time.Duration(DefaultRoomIdleTimeout), GetRoomIdleTimeout(), "invalid values fall back to the default")
+	assert.Equal(t, DefaultRoomSweepInterval, GetRoomSweepInterval())
+}
+
+func TestSweepIdleRooms(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Reloaded rooms keep their stored capacity rather than the server's default
+	t.Setenv("MAX_CLIENTS_PER_ROOM", "40")
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	owner, err := store.CreateUser(ctx, "owner", "owner@example.com", "hash")
+	require.NoError(t, err)
+	ownerID := uuid.UUID(owner.ID.Bytes).String()
+	creator := client.NewClient(nil, "owner")
+	creator.UserID = ownerID
+	capacity := hub.Config().MaxClientsPerRoom
+	require.Equal(t, 40, capacity)
+
+	attic, err := hub.CreateRoomAs(creator, "attic", false, "", capacity)
+	require.NoError(t, err)
+	_, err = hub.CreateRoomAs(creator, "vault", false, "", 10)
+	require.NoError(t, err)
+	busy, err := hub.CreateRoom("busy", false, "", capacity)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(client.NewClient(nil, "guest"), busy, ""))
+
+	// Nothing is evicted until a timeout is set
+	assert.Zero(t, hub.sweepIdleRooms(time.Now().Add(time.Hour)))
+	cfg := hub.Config()
+	cfg.RoomIdleTimeout = time.Minute
+	_, err = hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	assert.Zero(t, hub.sweepIdleRooms(time.Now()), "rooms empty for less than the timeout stay")
+
+	// Only the empty rooms are evicted
+	assert.Equal(t, 2, hub.sweepIdleRooms(time.Now().Add(2*time.Minute)))
+	hub.Mutex.RLock()
+	_, inMemory := hub.Rooms["attic"]
+	hub.Mutex.RUnlock()
+	assert.False(t, inMemory)
+	assert.False(t, attic.Active)
+
+	// They are still listed, and names that look like them are still refused
+	listed := map[string]int{}
+	for _, dto := range hub.GetRoomList(creator) {
+		if dto.Name == "attic" || dto.Name == "vault" {
+			listed[dto.Name] = dto.MaxClients
+			assert.True(t, dto.IsCreator)
+			assert.Equal(t, 1, dto.MemberCount)
+		}
+	}
+	assert.Equal(t, map[string]int{"attic": capacity, "vault": 10}, listed)
+	_, err = hub.CreateRoom("ATTIC", false, "", capacity)
+	assert.ErrorIs(t, err, ErrRoomNameConfusable)
+
+	// Joining loads it again, still owned by its stored creator
+	bob := client.NewClient(nil, "bob")
+	bob.UserID = uuid.NewString()
+	require.NoError(t, hub.LookupAndJoinRoom(bob, "attic", ""))
+	reloaded, exists := hub.GetRoom("attic")
+	require.True(t, exists)
+	assert.NotSame(t, attic, reloaded)
+	assert.Equal(t, 1, reloaded.GetClientCount())
+	assert.False(t, reloaded.IsCreator(bob), "joining first doesn't make bob the creator")
+	assert.True(t, reloaded.IsCreatedBy(ownerID))
+	require.NoError(t, hub.LookupAndJoinRoom(creator, "attic", ""))
+	assert.True(t, reloaded.IsCreator(creator))
+	assert.Equal(t, capacity, reloaded.MaxClients)
+	vault, exists := hub.GetRoom("vault")
+	require.True(t, exists)
+	assert.Equal(t, 10, vault.MaxClients)
+	assert.Equal(t, 1, hub.sweepIdleRooms(time.Now().Add(time.Hour)), "only the reloaded vault is empty")
+}
+
+func TestSweptRoomDeletedElsewhere(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	cfg := hub.Config()
+	cfg.RoomIdleTimeout = time.Minute
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	cellar, err := hub.CreateRoom("cellar", false, "", cfg.MaxClientsPerRoom)
+	require.NoError(t, err)
+	require.Equal(t, 1, hub.sweepIdleRooms(time.Now().Add(2*time.Minute)))
+
+	// Another server deletes the room while it is evicted here
+	stored, err := store.GetRoomByName(ctx, "cellar")
+	require.NoError(t, err)
+	_, err = store.SoftDeleteRoom(ctx, stored.ID)
+	require.NoError(t, err)
+
+	_, exists := hub.GetRoom("cellar")
+	assert.False(t, exists)
+	assert.Empty(t, hub.GetRoomList(nil))
+	hub.Mutex.RLock()
+	assert.Empty(t, hub.evictedRooms)
+	hub.Mutex.RUnlock()
+	assert.False(t, cellar.Active)
+}
@@ -0,0 +1,183 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+)
+
+var (
+	// ErrSessionNotFound is returned when the session does not exist or belongs to another user
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrTerminateCurrentSession is returned when a client tries to terminate its own connection
+	ErrTerminateCurrentSession = errors.New("cannot terminate the current session")
+)
+
+// addSession indexes a client under its user ID, or reports false without
+// indexing it when the user is at MaxConnectionsPerUser. Checking and adding
+// under the same lock keeps concurrent connections from all getting in;
+// callers must hold h.Mutex.
+func (h *Hub) addSession(client *clientpkg.Client) bool {
+	if client.UserID == "" {
+		return true
+	}
+	if h.connectionLimitReachedLocked(client.UserID) {
+		return false
+	}
+	sessions, ok := h.userSessions[client.UserID]
+	if !ok {
+		sessions = make(map[*clientpkg.Client]bool)
+		h.userSessions[client.UserID] = sessions
+	}
+	sessions[client] = true
+	return true
+}
+
+// removeSession drops a client from the user index and reports whether it was
+// the user's last local session; callers must hold h.Mutex
+func (h *Hub) removeSession(client *clientpkg.Client) bool {
+	sessions, ok := h.userSessions[client.UserID]
+	if !ok {
+		return false
+	}
+	delete(sessions, client)
+	if len(sessions) == 0 {
+		delete(h.userSessions, client.UserID)
+		return true
+	}
+	return false
+}
+
+// UserClient returns the oldest connection of userID on this server, or nil
+// when the user isn't connected here
+func (h *Hub) UserClient(userID string) *clientpkg.Client {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+	return h.userClientLocked(userID)
+}
+
+// userClientLocked does the work of UserClient; callers must hold h.Mutex
+func (h *Hub) userClientLocked(userID string) *clientpkg.Client {
+	var oldest *clientpkg.Client
+	for c := range h.userSessions[userID] {
+		if oldest == nil || c.ConnectedAt.Before(oldest.ConnectedAt) {
+			oldest = c
+		}
+	}
+	return oldest
+}
+
+// ListSessions returns the active connections of the client's user, oldest first
+func (h *Hub) ListSessions(client *clientpkg.Client) []types.SessionDTO {
+	h.Mutex.RLock()
+	clients := make([]*clientpkg.Client, 0, len(h.userSessions[client.UserID]))
+	if client.UserID != "" {
+		for c := range h.userSessions[client.UserID] {
+			clients = append(clients, c)
+		}
+	}
+	h.Mutex.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ConnectedAt.Before(clients[j].ConnectedAt) })
+
+	sessions := make([]types.SessionDTO, 0, len(clients))
+	for _, c := range clients {
+		session := types.SessionDTO{
+			ID:          c.SessionID,
+			ConnID:      c.ID,
+			IP:          c.RemoteAddr,
+			UserAgent:   c.UserAgent,
+			ConnectedAt: c.ConnectedAt.Format(time.RFC3339),
+			Current:     c == client,
+		}
+		if currentRoom, ok := c.GetCurrentRoom().(*room.Room); ok && currentRoom != nil {
+			session.Room = currentRoom.Name
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// TerminateSession closes another connection belonging to the client's user
+func (h *Hub) TerminateSession(client *clientpkg.Client, sessionID string) error {
+	if sessionID == client.SessionID {
+		return ErrTerminateCurrentSession
+	}
+
+	var target *clientpkg.Client
+	h.Mutex.RLock()
+	if client.UserID != "" {
+		for c := range h.userSessions[client.UserID] {
+			if c.SessionID == sessionID {
+				target = c
+				break
+			}
+		}
+	}
+	h.Mutex.RUnlock()
+
+	if target == nil {
+		return ErrSessionNotFound
+	}
+
+	h.closeSession(target, "session terminated")
+	return nil
+}
+
+// DisconnectUser closes every connection of a user, on this server and on any
+// other server, and returns how many were closed here
+func (h *Hub) DisconnectUser(userID, reason string) int {
+	if userID == "" {
+		return 0
+	}
+
+	closed := h.disconnectLocalUser(userID, reason)
+	if h.NATSEnabled && h.NATS != nil {
+		msg := types.Message{
+			Content:   []byte(reason),
+			Type:      types.MsgTypeDisconnectUser,
+			Timestamp: time.Now(),
+		}
+		if err := h.NATS.Publish(natsclient.UserSubject(userID), msg); err != nil {
+			log.Printf("Failed to publish disconnect for user %s: %v", userID, err)
+		}
+	}
+	return closed
+}
+
+// disconnectLocalUser closes the user's connections on this server
+func (h *Hub) disconnectLocalUser(userID, reason string) int {
+	h.Mutex.RLock()
+	targets := make([]*clientpkg.Client, 0, len(h.userSessions[userID]))
+	for c := range h.userSessions[userID] {
+		targets = append(targets, c)
+	}
+	h.Mutex.RUnlock()
+
+	for _, target := range targets {
+		h.closeSession(target, reason)
+	}
+	return len(targets)
+}
+
+// closeSession closes a client's connection and unregisters it
+func (h *Hub) closeSession(target *clientpkg.Client, reason string) {
+	// Close waits for the peer's close frame, so don't block the caller on it
+	go func() {
+		if target.Conn != nil {
+			target.Conn.Close(websocket.StatusPolicyViolation, reason)
+		}
+		select {
+		case h.Unregister <- target:
+		case <-h.Ctx.Done():
+		}
+	}()
+}
@@ -0,0 +1,214 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrNotRoomCreator       = errors.New("only the room creator can change the room password")
+	ErrRoomNotPrivate       = errors.New("only private rooms have a password")
+	ErrWrongRoomPassword    = errors.New("invalid password")
+	ErrRoomPasswordRequired = errors.New("new password cannot be empty")
+)
+
+// GetSuppressJoinLeaveDefault reads whether new rooms hide join/leave notifications, defaulting to false
+func GetSuppressJoinLeaveDefault() bool {
+	if value := os.Getenv("SUPPRESS_JOIN_LEAVE_DEFAULT"); value != "" {
+		if suppress, err := strconv.ParseBool(value); err == nil {
+			return suppress
+		}
+		log.Printf("Invalid SUPPRESS_JOIN_LEAVE_DEFAULT, using default: false")
+	}
+	return false
+}
+
+// SetRoomSuppressJoinLeave toggles join/leave notifications for a room; only the creator may change it
+func (h *Hub) SetRoomSuppressJoinLeave(client *clientpkg.Client, roomName string, suppress bool) error {
+	targetRoom, exists := h.GetRoom(roomName)
+
+	if !exists {
+		return errors.New("room does not exist")
+	}
+	if !targetRoom.IsCreator(client) {
+		return errors.New("only the room creator can change room settings")
+	}
+
+	targetRoom.SetSuppressJoinLeave(suppress)
+
+	if h.Repo != nil {
+		var roomID pgtype.UUID
+		if err := roomID.Scan(targetRoom.GetID()); err == nil {
+			if err := h.Repo.UpdateRoomSuppressJoinLeave(context.Background(), roomID, suppress); err != nil {
+				log.Printf("Failed to persist settings for room %s: %v", roomName, err)
+			}
+		}
+	}
+
+	// Let other servers pick up the new setting
+	if h.NATSEnabled && h.NATS != nil {
+		if err := h.publishRoomSync(roomSyncUpdated, targetRoom); err != nil {
+			log.Printf("Failed to publish room sync to NATS: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// SetRoomLinkPolicy replaces the links a room allows on top of the server's
+// link policy; only the creator may change it. A link must pass both, so the
+// room's policy can only be stricter. An empty policy removes it.
+func (h *Hub) SetRoomLinkPolicy(client *clientpkg.Client, roomName string, dto *types.LinkPolicyDTO) error {
+	targetRoom, exists := h.GetRoom(roomName)
+
+	if !exists {
+		return errors.New("room does not exist")
+	}
+	if !targetRoom.IsCreator(client) {
+		return errors.New("only the room creator can change room settings")
+	}
+	policy, err := validator.ParseLinkPolicy(dto.Mode, dto.AllowedDomains, dto.DeniedDomains)
+	if err != nil {
+		return fmt.Errorf("invalid link policy: %w", err)
+	}
+
+	if policy.IsZero() {
+		targetRoom.SetLinkPolicy(nil)
+	} else {
+		targetRoom.SetLinkPolicy(&policy)
+	}
+
+	// Let other servers pick up the new policy
+	if h.NATSEnabled && h.NATS != nil {
+		if err := h.publishRoomSync(roomSyncUpdated, targetRoom); err != nil {
+			log.Printf("Failed to publish room sync to NATS: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// linkPolicyDTO converts a room's link policy for clients and room sync
+func linkPolicyDTO(policy *validator.LinkPolicy) *types.LinkPolicyDTO {
+	if policy == nil {
+		return nil
+	}
+	return &types.LinkPolicyDTO{Mode: string(policy.Mode), AllowedDomains: policy.AllowedDomains, DeniedDomains: policy.DeniedDomains}
+}
+
+// linkPolicyFromDTO converts a link policy received in a room sync; the
+// sending server already checked it
+func linkPolicyFromDTO(dto *types.LinkPolicyDTO) *validator.LinkPolicy {
+	if dto == nil {
+		return nil
+	}
+	policy, err := validator.ParseLinkPolicy(dto.Mode, dto.AllowedDomains, dto.DeniedDomains)
+	if err != nil {
+		log.Printf("Ignoring invalid synced link policy: %v", err)
+		return nil
+	}
+	return &policy
+}
+
+// ChangeRoomPassword replaces a private room's password after checking the
+// current one; only the creator may change it. Wrong current passwords count
+// toward the same lockout as wrong passwords on join. The room is told the
+// password changed, without the password.
+func (h *Hub) ChangeRoomPassword(client *clientpkg.Client, roomName, oldPassword, newPassword string) error {
+	targetRoom, exists := h.GetRoom(roomName)
+	var currentHash string
+	if exists {
+		h.Mutex.RLock()
+		currentHash = targetRoom.Password
+		h.Mutex.RUnlock()
+	}
+
+	switch {
+	case !exists:
+		return ErrRoomNotFound
+	case !targetRoom.IsCreator(client):
+		return ErrNotRoomCreator
+	case !targetRoom.Private:
+		return ErrRoomNotPrivate
+	}
+	if newPassword == "" {
+		return ErrRoomPasswordRequired
+	}
+	if err := validator.ValidateRoomPassword(newPassword); err != nil {
+		return err
+	}
+	if err := h.passwordAttempts.check(client, roomName); err != nil {
+		return err
+	}
+	if !h.VerifyPassword(oldPassword, currentHash) {
+		h.passwordAttempts.fail(client, roomName)
+		return ErrWrongRoomPassword
+	}
+	h.passwordAttempts.succeed(client, roomName)
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	// Joins verify against the hash under h.Mutex
+	h.Mutex.Lock()
+	targetRoom.Password = string(hashedPassword)
+	h.Mutex.Unlock()
+
+	if h.Repo != nil {
+		var roomID pgtype.UUID
+		if err := roomID.Scan(targetRoom.GetID()); err == nil {
+			if err := h.Repo.UpdateRoomPassword(context.Background(), roomID, string(hashedPassword)); err != nil {
+				log.Printf("Failed to persist password for room %s: %v", roomName, err)
+			}
+		}
+	}
+
+	// Let other servers pick up the new hash
+	if h.NATSEnabled && h.NATS != nil {
+		if err := h.publishRoomSync(roomSyncUpdated, targetRoom); err != nil {
+			log.Printf("Failed to publish room sync to NATS: %v", err)
+		}
+	}
+
+	content, err := json.Marshal(types.RoomPasswordChangedDTO{Type: types.MsgTypeRoomPasswordChanged, Room: roomName, ChangedBy: client.Name})
+	if err != nil {
+		log.Printf("Failed to marshal password change for room %s: %v", roomName, err)
+		return nil
+	}
+	select {
+	case h.Broadcast <- types.Message{Content: content, Type: types.MsgTypeRoomPasswordChanged, Room: targetRoom}:
+	case <-h.Ctx.Done():
+	}
+	log.Printf("Password of room %s changed by %s conn_id=%s", roomName, client.Name, client.ID)
+	return nil
+}
+
+// GetRoomPolicy returns the settings of a room
+func (h *Hub) GetRoomPolicy(roomName string) (types.RoomPolicy, error) {
+	targetRoom, exists := h.GetRoom(roomName)
+
+	if !exists {
+		return types.RoomPolicy{}, errors.New("room does not exist")
+	}
+
+	return types.RoomPolicy{
+		Name:              targetRoom.Name,
+		Private:           targetRoom.Private,
+		MaxClients:        targetRoom.MaxClients,
+		SuppressJoinLeave: targetRoom.SuppressesJoinLeave(),
+		LinkPolicy:        linkPolicyDTO(targetRoom.GetLinkPolicy()),
+	}, nil
+}
@@ -0,0 +1,105 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+
+	"github.com/coder/websocket"
+)
+
+// ShutdownStats describes how the hub drained its connections on shutdown
+type ShutdownStats struct {
+	OpenConnections int            `json:"open_connections"`
+	Drained         int            `json:"drained"`
+	Failed          int            `json:"failed"`
+	Duration        time.Duration  `json:"duration"`
+	RoomCounts      map[string]int `json:"room_counts"`
+}
+
+// Done returns a channel that is closed once the hub has finished shutting down
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// ShutdownStats returns the drain stats recorded at shutdown; only valid after Done is closed
+func (h *Hub) ShutdownStats() ShutdownStats {
+	return h.shutdownStats
+}
+
+// shutdown closes every client connection, logs drain stats and flushes final metrics
+func (h *Hub) shutdown() {
+	start := time.Now()
+
+	h.Mutex.Lock()
+	clients := make([]*clientpkg.Client, 0, len(h.Clients))
+	for client := range h.Clients {
+		if client != nil {
+			clients = append(clients, client)
+		}
+	}
+	roomCounts := make(map[string]int, len(h.Rooms))
+	for name, r := range h.Rooms {
+		roomCounts[name] = r.GetClientCount()
+	}
+	h.Clients = make(map[*clientpkg.Client]bool)
+	h.UserCount = 0
+	h.Mutex.Unlock()
+
+	log.Printf("Shutdown: draining %d connections across %d rooms", len(clients), len(roomCounts))
+	roomNames := make([]string, 0, len(roomCounts))
+	for name := range roomCounts {
+		roomNames = append(roomNames, name)
+	}
+	sort.Strings(roomNames)
+	for _, name := range roomNames {
+		log.Printf("Shutdown: room=%s clients=%d", name, roomCounts[name])
+	}
+
+	// Close connections in parallel so one slow client doesn't hold up the rest
+	var drained, failed int64
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *clientpkg.Client) {
+			defer wg.Done()
+			defer h.Metrics.DecrementActiveConnections()
+			if client.Conn == nil {
+				atomic.AddInt64(&drained, 1)
+				return
+			}
+			if err := client.Conn.Close(websocket.StatusNormalClosure, "server shutting down"); err != nil {
+				log.Printf("Shutdown: client %s did not close cleanly: %v", client.Name, err)
+				atomic.AddInt64(&failed, 1)
+				return
+			}
+			atomic.AddInt64(&drained, 1)
+		}(client)
+	}
+	wg.Wait()
+
+	h.shutdownStats = ShutdownStats{
+		OpenConnections: len(clients),
+		Drained:         int(drained),
+		Failed:          int(failed),
+		Duration:        time.Since(start),
+		RoomCounts:      roomCounts,
+	}
+	log.Printf("Shutdown: open=%d drained=%d failed=%d duration=%s",
+		h.shutdownStats.OpenConnections, h.shutdownStats.Drained, h.shutdownStats.Failed, h.shutdownStats.Duration)
+
+	// Store room messages still waiting in the persistence batch
+	if h.messageBatch != nil {
+		h.messageBatch.Stop()
+	}
+
+	// Final metrics flush
+	if summary, err := json.Marshal(h.Metrics.GetSummary()); err == nil {
+		log.Printf("Shutdown: final metrics %s", summary)
+	}
+}
@@ -0,0 +1,75 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// statsSampleInterval is how often the peak connection count is recorded
+	statsSampleInterval = time.Minute
+	// statsSampleRetention is how long samples are kept; analytics never look further back
+	statsSampleRetention = 90 * 24 * time.Hour
+	// statsPruneEvery is how many samples are taken between deletes of expired ones
+	statsPruneEvery = 60
+)
+
+// statsStore is the subset of the repository used to sample usage statistics
+type statsStore interface {
+	CreateStatsSample(ctx context.Context, serverID string, peakConnections int32) error
+	DeleteStatsSamplesBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// runStatsSampler records this server's peak connection count every
+// statsSampleInterval and deletes samples older than statsSampleRetention
+// once an hour
+func (h *Hub) runStatsSampler() {
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+
+	h.pruneStatsSamples(h.Ctx)
+	for taken := 1; ; taken++ {
+		select {
+		case <-h.Ctx.Done():
+			return
+		case <-ticker.C:
+			h.recordStatsSample(h.Ctx)
+			if taken%statsPruneEvery == 0 {
+				h.pruneStatsSamples(h.Ctx)
+			}
+		}
+	}
+}
+
+// recordStatsSample stores the most connections open at once since the previous sample
+func (h *Hub) recordStatsSample(ctx context.Context) {
+	peak := h.Metrics.TakePeakConnections()
+	if err := h.stats.CreateStatsSample(ctx, h.statsServerID(), int32(peak)); err != nil {
+		log.Printf("Failed to record stats sample: %v", err)
+	}
+}
+
+// pruneStatsSamples deletes samples past statsSampleRetention
+func (h *Hub) pruneStatsSamples(ctx context.Context) {
+	deleted, err := h.stats.DeleteStatsSamplesBefore(ctx, time.Now().Add(-statsSampleRetention))
+	if err != nil {
+		log.Printf("Failed to delete old stats samples: %v", err)
+	} else if deleted > 0 {
+		log.Printf("Deleted %d stats samples older than %s", deleted, statsSampleRetention)
+	}
+}
+
+// statsServerID names this server in stats samples: its NATS server ID, or
+// the host name when NATS is off
+func (h *Hub) statsServerID() string {
+	if h.NATS != nil {
+		return h.NATS.GetServerID()
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return hostname
+}
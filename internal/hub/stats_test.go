@@ -0,0 +1,36 @@
+package hub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordStatsSample(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	for i := 0; i < 4; i++ {
+		hub.Metrics.IncrementActiveConnections()
+	}
+	hub.Metrics.DecrementActiveConnections()
+
+	hub.recordStatsSample(ctx)
+	hub.recordStatsSample(ctx)
+	samples := store.StatsSamples()
+	require.Len(t, samples, 2)
+	assert.Equal(t, int32(4), samples[0].PeakConnections)
+	assert.Equal(t, int32(3), samples[1].PeakConnections, "the peak restarts from the open connections")
+	assert.NotEmpty(t, samples[0].ServerID)
+
+	store.AddStatsSample("old-server", time.Now().Add(-statsSampleRetention-time.Hour), 10)
+	hub.pruneStatsSamples(ctx)
+	assert.Len(t, store.StatsSamples(), 2)
+}
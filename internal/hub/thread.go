@@ -0,0 +1,80 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// defaultThreadLimit is how many replies thread_messages returns without a limit
+	defaultThreadLimit = 50
+	// maxThreadLimit caps the limit of a thread_messages request
+	maxThreadLimit = 200
+)
+
+// threadStore is the subset of the repository used to fetch a message's replies
+type threadStore interface {
+	ListThreadMessages(ctx context.Context, parentID pgtype.UUID, limit int32) ([]db.ListThreadMessagesRow, error)
+}
+
+// ThreadMessages returns the replies to parentID, oldest first, along with a
+// preview of the parent. The parent must be a message in the client's current
+// room; limit defaults to defaultThreadLimit and is capped at maxThreadLimit.
+func (h *Hub) ThreadMessages(client *clientpkg.Client, parentID string, limit int) (*types.ThreadMessagesDTO, error) {
+	targetRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || targetRoom == nil {
+		return nil, errors.New("you must join a room first to read threads")
+	}
+	if h.threads == nil {
+		return nil, errors.New("threads are not available without a database")
+	}
+	if limit <= 0 {
+		limit = defaultThreadLimit
+	}
+	limit = min(limit, maxThreadLimit)
+
+	parentUUID, parent, err := h.ResolveReply(targetRoom, parentID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := h.threads.ListThreadMessages(context.Background(), parentUUID, int32(limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load thread: %w", err)
+	}
+
+	messages := make([]types.HistoryMessageDTO, len(rows))
+	for i, row := range rows {
+		messages[i] = types.HistoryMessageDTO{
+			ID:        uuid.UUID(row.ID.Bytes).String(),
+			Username:  row.Username,
+			Content:   row.Content,
+			Timestamp: row.CreatedAt.Time.Format(time.RFC3339),
+			ReplyTo:   parent.ID,
+		}
+	}
+	return &types.ThreadMessagesDTO{
+		Type:     types.MsgTypeThreadMessages,
+		Room:     targetRoom.Name,
+		Parent:   parent,
+		Messages: messages,
+	}, nil
+}
+
+// parentID formats a message's parent ID, or returns "" for messages that
+// aren't replies
+func parentID(id pgtype.UUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return uuid.UUID(id.Bytes).String()
+}
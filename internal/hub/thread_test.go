@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"context"
+	"testing"
+
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThreadMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+	go hub.Run()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	alice, _ := newConnectedClient(t, "alice", uuid.UUID(user.ID.Bytes).String())
+
+	_, err = hub.ThreadMessages(alice, uuid.NewString(), 0)
+	assert.Error(t, err, "a room must be joined first")
+
+	lounge, err := hub.CreateRoomAs(alice, "lounge", false, "", 10)
+	require.NoError(t, err)
+	other, err := hub.CreateRoomAs(alice, "other", false, "", 10)
+	require.NoError(t, err)
+	require.NoError(t, hub.JoinRoom(alice, lounge, ""))
+
+	var loungeID, otherID pgtype.UUID
+	require.NoError(t, loungeID.Scan(lounge.ID))
+	require.NoError(t, otherID.Scan(other.ID))
+	parent, err := store.CreateMessage(ctx, loungeID, user.ID, "what's for lunch?")
+	require.NoError(t, err)
+	for _, content := range []string{"pizza", "soup", "tacos"} {
+		_, err = store.CreateReplyMessage(ctx, loungeID, user.ID, parent.ID, content)
+		require.NoError(t, err)
+	}
+	_, err = store.CreateMessage(ctx, loungeID, user.ID, "unrelated")
+	require.NoError(t, err)
+
+	// Replies come back oldest first with the quoted parent
+	parentKey := uuid.UUID(parent.ID.Bytes).String()
+	thread, err := hub.ThreadMessages(alice, parentKey, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "lounge", thread.Room)
+	assert.Equal(t, parentKey, thread.Parent.ID)
+	assert.Equal(t, "what's for lunch?", thread.Parent.ContentPreview)
+	require.Len(t, thread.Messages, 3)
+	assert.Equal(t, "pizza", thread.Messages[0].Content)
+	assert.Equal(t, "tacos", thread.Messages[2].Content)
+	for _, msg := range thread.Messages {
+		assert.Equal(t, parentKey, msg.ReplyTo)
+		assert.NotEmpty(t, msg.ID)
+	}
+
+	thread, err = hub.ThreadMessages(alice, parentKey, 2)
+	require.NoError(t, err)
+	assert.Len(t, thread.Messages, 2)
+
+	// The parent must exist and be in the client's room
+	elsewhere, err := store.CreateMessage(ctx, otherID, user.ID, "elsewhere")
+	require.NoError(t, err)
+	_, err = hub.ThreadMessages(alice, uuid.UUID(elsewhere.ID.Bytes).String(), 0)
+	assert.ErrorIs(t, err, ErrReplyNotInRoom)
+	_, err = hub.ThreadMessages(alice, uuid.NewString(), 0)
+	assert.ErrorIs(t, err, ErrReplyNotFound)
+	_, err = hub.ThreadMessages(alice, "not-a-uuid", 0)
+	assert.Error(t, err)
+}
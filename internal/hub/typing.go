@@ -0,0 +1,84 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+)
+
+// typingClients remembers the room each client is showing a typing indicator in
+type typingClients struct {
+	mu    sync.Mutex
+	rooms map[*clientpkg.Client]*room.Room
+}
+
+func newTypingClients() *typingClients {
+	return &typingClients{rooms: make(map[*clientpkg.Client]*room.Room)}
+}
+
+// start records that client is typing in targetRoom
+func (t *typingClients) start(client *clientpkg.Client, targetRoom *room.Room) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rooms[client] = targetRoom
+}
+
+// take forgets client, returning the room it was typing in, if any
+func (t *typingClients) take(client *clientpkg.Client) (*room.Room, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, exists := t.rooms[client]
+	delete(t.rooms, client)
+	return current, exists
+}
+
+// SetTyping shows or clears client's typing indicator for the other members
+// of its current room
+func (h *Hub) SetTyping(client *clientpkg.Client, typing bool) error {
+	targetRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || targetRoom == nil {
+		return errors.New("you are not in a room")
+	}
+
+	// An indicator left in a previous room ends; one already showing in
+	// this room isn't announced again
+	previous, wasTyping := h.typing.take(client)
+	if wasTyping && previous != targetRoom {
+		h.broadcastTyping(client, previous, false)
+	}
+	alreadyTyping := wasTyping && previous == targetRoom
+	if typing {
+		h.typing.start(client, targetRoom)
+	}
+	if typing != alreadyTyping {
+		h.broadcastTyping(client, targetRoom, typing)
+	}
+	return nil
+}
+
+// stopTyping clears client's typing indicator, if it is showing one, so the
+// other members don't keep showing it after the client leaves or disconnects
+func (h *Hub) stopTyping(client *clientpkg.Client) {
+	if targetRoom, exists := h.typing.take(client); exists {
+		h.broadcastTyping(client, targetRoom, false)
+	}
+}
+
+// broadcastTyping sends a typing_start or typing_stop frame for client to targetRoom
+func (h *Hub) broadcastTyping(client *clientpkg.Client, targetRoom *room.Room, typing bool) {
+	msgType := types.MsgTypeTypingStop
+	if typing {
+		msgType = types.MsgTypeTypingStart
+	}
+	frame, _ := json.Marshal(types.TypingDTO{
+		Type:   msgType,
+		Room:   targetRoom.Name,
+		Sender: client.Name,
+	})
+	h.BroadcastToRoom(targetRoom, types.Message{Content: frame, Sender: client, Type: msgType})
+}
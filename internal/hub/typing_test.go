@@ -0,0 +1,84 @@
+package hub
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTypingStopOnDisconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+	alice, alicePeer := newConnectedClient(t, "alice", "user-alice")
+	bob, bobPeer := newConnectedClient(t, "bob", "user-bob")
+	for _, c := range []*client.Client{alice, bob} {
+		hub.Register <- c
+		<-c.Registered
+	}
+	require.NoError(t, hub.JoinRoom(alice, lounge, ""))
+	require.NoError(t, hub.JoinRoom(bob, lounge, ""))
+
+	require.NoError(t, hub.SetTyping(alice, true))
+	assert.True(t, readUntil(bobPeer, `"type":"typing_start","room":"lounge","sender":"alice"`, 5*time.Second))
+
+	// Alice's connection drops without a typing_stop or leave
+	alicePeer.CloseNow()
+	hub.Unregister <- alice
+	assert.True(t, readUntil(bobPeer, `"type":"typing_stop","room":"lounge","sender":"alice"`, 5*time.Second))
+}
+
+func TestTypingStopOnLeave(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	lounge, err := hub.CreateRoom("lounge", false, "", 10)
+	require.NoError(t, err)
+	alice, alicePeer := newConnectedClient(t, "alice", "user-alice")
+	bob, bobPeer := newConnectedClient(t, "bob", "user-bob")
+	require.NoError(t, hub.JoinRoom(alice, lounge, ""))
+	require.NoError(t, hub.JoinRoom(bob, lounge, ""))
+
+	assert.Error(t, hub.SetTyping(client.NewClient(nil, "carol"), true), "typing needs a room")
+
+	// Repeated typing_start is announced once, and the sender gets no echo
+	require.NoError(t, hub.SetTyping(alice, true))
+	require.NoError(t, hub.SetTyping(alice, true))
+	require.NoError(t, hub.SetTyping(alice, false))
+	require.NoError(t, hub.SetTyping(alice, false))
+	starts := 0
+	readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer readCancel()
+	for {
+		_, data, err := bobPeer.Read(readCtx)
+		require.NoError(t, err)
+		if strings.Contains(string(data), "typing_start") {
+			starts++
+		}
+		if strings.Contains(string(data), "typing_stop") {
+			break
+		}
+	}
+	assert.Equal(t, 1, starts)
+
+	require.NoError(t, hub.SetTyping(alice, true))
+	assert.True(t, readUntil(bobPeer, "typing_start", 5*time.Second))
+	hub.LeaveRoom(alice)
+	assert.True(t, readUntil(bobPeer, "typing_stop", 5*time.Second))
+	assert.True(t, readUntil(bobPeer, "alice has left the room", 5*time.Second), "typing_stop comes before the leave notice")
+	assert.False(t, readUntil(alicePeer, "typing_", 200*time.Millisecond))
+}
@@ -0,0 +1,115 @@
+package hub
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+)
+
+// DefaultUnregisterWorkers is the number of unregister workers when UNREGISTER_WORKERS is unset
+const DefaultUnregisterWorkers = 4
+
+// GetUnregisterWorkers reads the unregister worker count from environment or returns default
+func GetUnregisterWorkers() int {
+	if value := os.Getenv("UNREGISTER_WORKERS"); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			return workers
+		}
+		log.Printf("Invalid UNREGISTER_WORKERS, using default: %d", DefaultUnregisterWorkers)
+	}
+	return DefaultUnregisterWorkers
+}
+
+// startUnregisterWorkers spawns the UnregisterWorkers pool so slow connection
+// closes don't block the hub's main loop
+func (h *Hub) startUnregisterWorkers() {
+	for i := 0; i < h.Config().UnregisterWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-h.Ctx.Done():
+					return
+				case client := <-h.Unregister:
+					if client != nil {
+						h.unregisterClient(client)
+					}
+				}
+			}
+		}()
+	}
+}
+
+// unregisterClient removes a client from the hub, closes its connection and
+// announces the departure to the remaining clients
+func (h *Hub) unregisterClient(client *clientpkg.Client) {
+	h.Mutex.Lock()
+	_, ok := h.Clients[client]
+	lastSession := false
+	connections := 0
+	if ok {
+		delete(h.Clients, client)
+		lastSession = h.removeSession(client)
+		connections = h.sessionCount(client)
+		h.UserCount--
+	}
+	userCount := h.UserCount
+	h.Mutex.Unlock()
+
+	if !ok {
+		return
+	}
+	if h.NATS != nil {
+		if lastSession {
+			h.removeUserSubscription(client.UserID)
+		}
+		h.publishUserPresence(client.UserID, client.Name, connections)
+	}
+
+	// Messages delivered live while connected count as read
+	h.markRoomRead(client)
+	// The room would otherwise show the client typing forever
+	h.stopTyping(client)
+	// Or connected to it
+	h.dropFromRoom(client)
+
+	h.Metrics.DecrementActiveConnections()
+	if client.Conn != nil {
+		client.Conn.Close(websocket.StatusNormalClosure, "")
+	}
+	log.Printf("Client %s disconnected. Total clients: %d", client.Name, userCount)
+
+	// Broadcast leave notification to all remaining clients
+	timestamp := time.Now().Format("15:04:05")
+	leaveMsg := []byte(fmt.Sprintf("[%s] %s has left the chat", timestamp, client.Name))
+	select {
+	case h.Broadcast <- types.Message{Content: leaveMsg, Sender: nil, Type: types.MsgTypeLeave}:
+	case <-h.Ctx.Done():
+	}
+}
+
+// dropFromRoom takes a disconnected client out of its room on this server.
+// Unlike leaving the room, the user stays a stored member of it.
+func (h *Hub) dropFromRoom(client *clientpkg.Client) {
+	h.Mutex.Lock()
+	defer h.Mutex.Unlock()
+	h.roomOpMutex.Lock()
+	defer h.roomOpMutex.Unlock()
+
+	currentRoom, ok := client.GetCurrentRoom().(*room.Room)
+	if !ok || currentRoom == nil {
+		return
+	}
+	currentRoom.RemoveClient(client)
+	client.SetCurrentRoom(nil)
+	delete(h.ClientRooms, client)
+	h.Metrics.RecordRoomLeave(currentRoom.Name)
+	h.publishPresence(currentRoom)
+}
@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/types"
+)
+
+// LookupUser finds the server an authenticated user is connected to by
+// username, ignoring case: this server first, then any other server over
+// NATS. The reply's Present is false when no server holds a session.
+func (h *Hub) LookupUser(username string) (types.UserLookupReply, error) {
+	if reply, ok := h.lookupLocalUser(username); ok {
+		return reply, nil
+	}
+	if !h.NATSEnabled || h.NATS == nil {
+		return types.UserLookupReply{}, nil
+	}
+
+	payload, err := json.Marshal(types.UserLookupRequest{Username: username})
+	if err != nil {
+		return types.UserLookupReply{}, fmt.Errorf("failed to marshal user lookup: %w", err)
+	}
+	msg, err := h.NATS.RequestReply(h.Ctx, natsclient.SubjectUserLookup, types.Message{
+		Content:   payload,
+		Type:      types.MsgTypeUserLookup,
+		Timestamp: time.Now(),
+	}, directMessageTimeout)
+	if err != nil || msg == nil {
+		return types.UserLookupReply{}, err
+	}
+
+	var reply types.UserLookupReply
+	if err := json.Unmarshal(msg.Content, &reply); err != nil {
+		return types.UserLookupReply{}, fmt.Errorf("failed to unmarshal user lookup reply: %w", err)
+	}
+	return reply, nil
+}
+
+// SendDirectMessageToUsername looks up a user by username and sends them a
+// direct message, routed to the server holding their sessions
+func (h *Hub) SendDirectMessageToUsername(sender *clientpkg.Client, username, content string) (bool, error) {
+	if sender.UserID == "" {
+		return false, ErrDirectMessageUnauthenticated
+	}
+	username = strings.TrimSpace(username)
+	if username == "" || content == "" {
+		return false, ErrDirectMessageInvalid
+	}
+
+	reply, err := h.LookupUser(username)
+	if err != nil {
+		return false, err
+	}
+	if !reply.Present {
+		return false, nil
+	}
+	return h.SendDirectMessage(sender, reply.UserID, content)
+}
+
+// lookupLocalUser finds an authenticated session of username on this server
+func (h *Hub) lookupLocalUser(username string) (types.UserLookupReply, bool) {
+	h.Mutex.RLock()
+	defer h.Mutex.RUnlock()
+	for c := range h.Clients {
+		if c.UserID != "" && strings.EqualFold(c.Name, username) {
+			serverID := ""
+			if h.NATS != nil {
+				serverID = h.NATS.GetServerID()
+			}
+			return types.UserLookupReply{ServerID: serverID, UserID: c.UserID, Present: true}, true
+		}
+	}
+	return types.UserLookupReply{}, false
+}
+
+// handleUserLookup answers another server's UserLookupRequest when the user
+// is connected here; other servers stay silent
+func (h *Hub) handleUserLookup(msg types.Message) *types.Message {
+	if msg.ServerID == h.NATS.GetServerID() {
+		return nil
+	}
+	var req types.UserLookupRequest
+	if err := json.Unmarshal(msg.Content, &req); err != nil {
+		log.Printf("Failed to unmarshal user lookup: %v", err)
+		return nil
+	}
+	reply, ok := h.lookupLocalUser(req.Username)
+	if !ok {
+		return nil
+	}
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Failed to marshal user lookup reply: %v", err)
+		return nil
+	}
+	return &types.Message{Content: payload, Type: types.MsgTypeUserLookup, Timestamp: time.Now()}
+}
@@ -0,0 +1,347 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	clientpkg "websocket-demo/internal/client"
+	natsclient "websocket-demo/internal/nats"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// userPresenceEvent announces how many connections a user has on one server
+type userPresenceEvent struct {
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	ServerID    string    `json:"server_id"`
+	Connections int       `json:"connections"` // Connections on ServerID; zero means offline there
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// remoteUser is a remote server's last announced connections for a user
+type remoteUser struct {
+	name        string
+	connections int
+	updatedAt   time.Time
+}
+
+// userPresenceTracker holds user connections reported by other servers
+type userPresenceTracker struct {
+	mu    sync.RWMutex
+	ttl   time.Duration
+	users map[string]map[string]remoteUser // user ID -> server ID -> connections
+}
+
+func newUserPresenceTracker(ttl time.Duration) *userPresenceTracker {
+	return &userPresenceTracker{ttl: ttl, users: make(map[string]map[string]remoteUser)}
+}
+
+// update records a remote server's connections for a user
+func (p *userPresenceTracker) update(event userPresenceEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	servers, exists := p.users[event.UserID]
+	if !exists {
+		servers = make(map[string]remoteUser)
+		p.users[event.UserID] = servers
+	}
+	if event.Connections <= 0 {
+		delete(servers, event.ServerID)
+		if len(servers) == 0 {
+			delete(p.users, event.UserID)
+		}
+		return
+	}
+	servers[event.ServerID] = remoteUser{name: event.Name, connections: event.Connections, updatedAt: time.Now()}
+}
+
+// snapshot returns fresh remote connections per user, dropping entries from
+// servers that stopped refreshing them
+func (p *userPresenceTracker) snapshot() map[string]types.UserPresenceDTO {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users := make(map[string]types.UserPresenceDTO, len(p.users))
+	for userID, servers := range p.users {
+		for serverID, remote := range servers {
+			if time.Since(remote.updatedAt) >= p.ttl {
+				delete(servers, serverID)
+				continue
+			}
+			entry := users[userID]
+			entry.UserID = userID
+			entry.Name = remote.name
+			entry.Connections += remote.connections
+			entry.Servers++
+			users[userID] = entry
+		}
+		if len(servers) == 0 {
+			delete(p.users, userID)
+		}
+	}
+	return users
+}
+
+// connections returns a user's fresh connections on other servers
+func (p *userPresenceTracker) connections(userID string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	total := 0
+	for _, remote := range p.users[userID] {
+		if time.Since(remote.updatedAt) < p.ttl {
+			total += remote.connections
+		}
+	}
+	return total
+}
+
+// sweep drops connections that were not refreshed within the TTL, such as
+// those of a crashed server, returning how many entries were removed
+func (p *userPresenceTracker) sweep(now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	for userID, servers := range p.users {
+		for serverID, remote := range servers {
+			if now.Sub(remote.updatedAt) >= p.ttl {
+				delete(servers, serverID)
+				removed++
+			}
+		}
+		if len(servers) == 0 {
+			delete(p.users, userID)
+		}
+	}
+	return removed
+}
+
+// size returns the number of remote user entries held
+func (p *userPresenceTracker) size() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	total := 0
+	for _, servers := range p.users {
+		total += len(servers)
+	}
+	return total
+}
+
+// publishUserPresence announces a user's connection count on this server
+func (h *Hub) publishUserPresence(userID, name string, connections int) {
+	if !h.NATSEnabled || h.NATS == nil || userID == "" {
+		return
+	}
+
+	msgType := types.MsgTypeUserOnline
+	if connections <= 0 {
+		msgType = types.MsgTypeUserOffline
+	}
+	content, _ := json.Marshal(userPresenceEvent{
+		UserID:      userID,
+		Name:        name,
+		ServerID:    h.NATS.GetServerID(),
+		Connections: connections,
+		Timestamp:   time.Now(),
+	})
+	msg := types.Message{Content: content, Type: msgType}
+	if err := h.NATS.Publish(natsclient.SubjectUserPresence, msg); err != nil {
+		log.Printf("Failed to publish presence for user %s: %v", userID, err)
+	}
+}
+
+// publishLocalUserPresence re-announces every local user, or announces them
+// all offline when the server is shutting down
+func (h *Hub) publishLocalUserPresence(offline bool) {
+	type localUser struct {
+		name        string
+		connections int
+	}
+
+	h.Mutex.RLock()
+	users := make(map[string]localUser, len(h.userSessions))
+	for userID, sessions := range h.userSessions {
+		for c := range sessions {
+			users[userID] = localUser{name: c.Name, connections: len(sessions)}
+			break
+		}
+	}
+	h.Mutex.RUnlock()
+
+	for userID, user := range users {
+		if offline {
+			user.connections = 0
+		}
+		h.publishUserPresence(userID, user.name, user.connections)
+	}
+}
+
+// handleUserPresence records user connections announced by another server
+func (h *Hub) handleUserPresence(msg types.Message) {
+	var event userPresenceEvent
+	if err := json.Unmarshal(msg.Content, &event); err != nil {
+		log.Printf("Failed to unmarshal user presence: %v", err)
+		return
+	}
+	if event.UserID == "" || event.ServerID == "" || event.ServerID == h.NATS.GetServerID() {
+		return
+	}
+	h.userPresence.update(event)
+	h.updatePresenceMetrics()
+}
+
+// GetPresence returns every user connected anywhere in the cluster, sorted by name
+func (h *Hub) GetPresence() []types.UserPresenceDTO {
+	users := h.userPresence.snapshot()
+
+	h.Mutex.RLock()
+	for userID, sessions := range h.userSessions {
+		entry := users[userID]
+		entry.UserID = userID
+		entry.Connections += len(sessions)
+		entry.Servers++
+		for c := range sessions {
+			entry.Name = c.Name
+			break
+		}
+		users[userID] = entry
+	}
+	h.Mutex.RUnlock()
+
+	presence := make([]types.UserPresenceDTO, 0, len(users))
+	for _, entry := range users {
+		presence = append(presence, entry)
+	}
+	sort.Slice(presence, func(i, j int) bool {
+		if presence[i].Name != presence[j].Name {
+			return presence[i].Name < presence[j].Name
+		}
+		return presence[i].UserID < presence[j].UserID
+	})
+	return presence
+}
+
+// IsUserOnline reports whether a user has a connection on any server
+func (h *Hub) IsUserOnline(userID string) bool {
+	h.Mutex.RLock()
+	local := len(h.userSessions[userID]) > 0
+	h.Mutex.RUnlock()
+	if local {
+		return true
+	}
+	_, remote := h.userPresence.snapshot()[userID]
+	return remote
+}
+
+// ListMembers returns a room's members with their cluster-wide online status.
+// Membership comes from the database when available, otherwise from the
+// room's local clients.
+func (h *Hub) ListMembers(roomName string) ([]types.MemberDTO, error) {
+	targetRoom, exists := h.GetRoom(roomName)
+	if !exists {
+		return nil, errors.New("room does not exist")
+	}
+
+	members := make([]types.MemberDTO, 0)
+	seen := make(map[string]bool)
+	if h.Repo != nil && targetRoom.GetID() != "" {
+		var roomID pgtype.UUID
+		if err := roomID.Scan(targetRoom.GetID()); err == nil {
+			rows, err := h.Repo.GetRoomMembers(context.Background(), roomID)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				userID := uuid.UUID(row.ID.Bytes).String()
+				seen[userID] = true
+				member := types.MemberDTO{UserID: userID, Name: row.Username}
+				if row.JoinedAt.Valid {
+					member.JoinedAt = row.JoinedAt.Time.Format(time.RFC3339)
+				}
+				members = append(members, member)
+			}
+		}
+	}
+	for _, c := range targetRoom.GetClients() {
+		key := c.UserID
+		if key == "" {
+			key = c.Name
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		member := types.MemberDTO{UserID: c.UserID, Name: c.Name}
+		if joinedAt := c.GetJoinedAt(); !joinedAt.IsZero() {
+			member.JoinedAt = joinedAt.Format(time.RFC3339)
+		}
+		members = append(members, member)
+	}
+
+	for i := range members {
+		members[i].Online = members[i].UserID != "" && h.IsUserOnline(members[i].UserID)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members, nil
+}
+
+// ListClients returns the users connected to a room on this server, one
+// entry per user however many sessions they have in it, sorted by name.
+// Other servers only share how many clients their rooms have, so their
+// clients aren't listed.
+func (h *Hub) ListClients(roomName string) ([]types.RoomMemberDTO, error) {
+	targetRoom, exists := h.GetRoom(roomName)
+	if !exists {
+		return nil, ErrRoomNotFound
+	}
+
+	byUser := make(map[string]int)
+	members := make([]types.RoomMemberDTO, 0)
+	for _, c := range targetRoom.GetClients() {
+		key := c.UserID
+		if key == "" {
+			key = c.Name
+		}
+		joinedAt := c.GetJoinedAt()
+		if i, seen := byUser[key]; seen {
+			if joinedAt.Before(members[i].JoinedAt) {
+				members[i].JoinedAt = joinedAt
+			}
+			continue
+		}
+		byUser[key] = len(members)
+		members = append(members, types.RoomMemberDTO{
+			UserID:      c.UserID,
+			Name:        c.Name,
+			Status:      types.UserStatusOnline,
+			JoinedAt:    joinedAt,
+			IsModerator: c.UserID != "" && targetRoom.MemberRole(c.UserID) == repository.RoomRoleModerator,
+			IsCreator:   targetRoom.IsCreator(c) || targetRoom.IsCreatedBy(c.UserID),
+		})
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Name != members[j].Name {
+			return members[i].Name < members[j].Name
+		}
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+// sessionCount returns how many local connections a user has; callers must hold h.Mutex
+func (h *Hub) sessionCount(client *clientpkg.Client) int {
+	return len(h.userSessions[client.UserID])
+}
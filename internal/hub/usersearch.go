@@ -0,0 +1,70 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	clientpkg "websocket-demo/internal/client"
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultUserSearchLimit is how many users search_users returns without a limit
+	defaultUserSearchLimit = 10
+	// maxUserSearchLimit caps the limit of a search_users request
+	maxUserSearchLimit = 50
+)
+
+var (
+	// ErrUserSearchUnauthenticated is returned when an anonymous client searches for users
+	ErrUserSearchUnauthenticated = errors.New("searching users requires an authenticated user")
+	// ErrUserSearchEmpty is returned for a search without a query
+	ErrUserSearchEmpty = errors.New("search query is required")
+)
+
+// userSearchStore is the subset of the repository used to search users by name
+type userSearchStore interface {
+	SearchUsers(ctx context.Context, query string, limit int) ([]db.User, error)
+}
+
+// SearchUsers returns the users whose name contains query, ignoring case, in
+// name order. limit defaults to defaultUserSearchLimit and is capped at
+// maxUserSearchLimit.
+func (h *Hub) SearchUsers(client *clientpkg.Client, query string, limit int) (*types.UserSearchResultsDTO, error) {
+	if client.UserID == "" {
+		return nil, ErrUserSearchUnauthenticated
+	}
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, ErrUserSearchEmpty
+	}
+	if h.userSearch == nil {
+		return nil, errors.New("user search needs a database")
+	}
+	if limit <= 0 {
+		limit = defaultUserSearchLimit
+	}
+	limit = min(limit, maxUserSearchLimit)
+
+	users, err := h.userSearch.SearchUsers(context.Background(), query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	results := &types.UserSearchResultsDTO{
+		Type:  types.MsgTypeUserSearchResults,
+		Query: query,
+		Users: make([]types.UserSummaryDTO, len(users)),
+	}
+	for i, u := range users {
+		results.Users[i] = types.UserSummaryDTO{
+			ID:       uuid.UUID(u.ID.Bytes).String(),
+			Username: u.Username,
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,53 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchUsers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := NewHub(ctx, store, nil)
+
+	for i := 5; i >= 1; i-- {
+		_, err := store.CreateUser(ctx, fmt.Sprintf("alice%d", i), fmt.Sprintf("alice%d@example.com", i), "hash")
+		require.NoError(t, err)
+	}
+	_, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	searcher := client.NewClient(nil, "bob")
+	searcher.UserID = "user-bob"
+
+	results, err := hub.SearchUsers(searcher, "alic", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "user_search_results", results.Type)
+	assert.Equal(t, "alic", results.Query)
+	require.Len(t, results.Users, 5)
+	for i, u := range results.Users {
+		assert.Equal(t, fmt.Sprintf("alice%d", i+1), u.Username, "in name order")
+		assert.NotEmpty(t, u.ID)
+	}
+
+	results, err = hub.SearchUsers(searcher, "  ALICE ", 2)
+	require.NoError(t, err)
+	assert.Len(t, results.Users, 2, "the limit applies and case is ignored")
+
+	results, err = hub.SearchUsers(searcher, "carol", 0)
+	require.NoError(t, err)
+	assert.Empty(t, results.Users)
+
+	_, err = hub.SearchUsers(searcher, " ", 0)
+	assert.ErrorIs(t, err, ErrUserSearchEmpty)
+	_, err = hub.SearchUsers(client.NewClient(nil, "guest"), "alic", 0)
+	assert.ErrorIs(t, err, ErrUserSearchUnauthenticated)
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import "sync/atomic"
+
+// DeliveryOutcome is what became of writing a broadcast message to one client
+type DeliveryOutcome int
+
+const (
+	// DeliveryDelivered means the write succeeded
+	DeliveryDelivered DeliveryOutcome = iota
+	// DeliverySkipped means the client was the sender or had no connection
+	DeliverySkipped
+	// DeliveryFailed means the write failed and the client is unregistered
+	DeliveryFailed
+	// DeliveryDropped means the write timed out, which closes the client's
+	// connection, and the client is unregistered
+	DeliveryDropped
+)
+
+// DeliveryStats counts the outcomes of broadcast writes. Attempted is the
+// sum of the other counters.
+type DeliveryStats struct {
+	Attempted int64 `json:"attempted"`
+	Delivered int64 `json:"delivered"`
+	Skipped   int64 `json:"skipped"`
+	Failed    int64 `json:"failed"`
+	Dropped   int64 `json:"dropped"`
+}
+
+// Record counts one outcome; safe for concurrent use
+func (d *DeliveryStats) Record(outcome DeliveryOutcome) {
+	atomic.AddInt64(&d.Attempted, 1)
+	switch outcome {
+	case DeliveryDelivered:
+		atomic.AddInt64(&d.Delivered, 1)
+	case DeliverySkipped:
+		atomic.AddInt64(&d.Skipped, 1)
+	case DeliveryFailed:
+		atomic.AddInt64(&d.Failed, 1)
+	case DeliveryDropped:
+		atomic.AddInt64(&d.Dropped, 1)
+	}
+}
+
+// Ratio returns the share of the clients a message was written to that got
+// it, leaving out skipped clients; 1 when no write was attempted
+func (d DeliveryStats) Ratio() float64 {
+	written := d.Delivered + d.Failed + d.Dropped
+	if written == 0 {
+		return 1
+	}
+	return float64(d.Delivered) / float64(written)
+}
+
+// add adds other's counters to d atomically
+func (d *DeliveryStats) add(other DeliveryStats) {
+	atomic.AddInt64(&d.Attempted, other.Attempted)
+	atomic.AddInt64(&d.Delivered, other.Delivered)
+	atomic.AddInt64(&d.Skipped, other.Skipped)
+	atomic.AddInt64(&d.Failed, other.Failed)
+	atomic.AddInt64(&d.Dropped, other.Dropped)
+}
+
+// snapshot loads all counters atomically into a plain value
+func (d *DeliveryStats) snapshot() DeliveryStats {
+	return DeliveryStats{
+		Attempted: atomic.LoadInt64(&d.Attempted),
+		Delivered: atomic.LoadInt64(&d.Delivered),
+		Skipped:   atomic.LoadInt64(&d.Skipped),
+		Failed:    atomic.LoadInt64(&d.Failed),
+		Dropped:   atomic.LoadInt64(&d.Dropped),
+	}
+}
+
+// Snapshot returns a copy of d, for reading counters filled concurrently
+func (d *DeliveryStats) Snapshot() DeliveryStats {
+	return d.snapshot()
+}
+
+// RecordDeliveries adds the outcomes of one broadcast to the global counters
+// and, unless roomName is empty, to the room's
+func (m *Metrics) RecordDeliveries(roomName string, d DeliveryStats) {
+	m.Delivery.add(d)
+	if roomName != "" {
+		m.roomStats(roomName).Delivery.add(d)
+	}
+}
+
+// GetDeliveryStats returns a snapshot of the global delivery counters
+func (m *Metrics) GetDeliveryStats() DeliveryStats {
+	return m.Delivery.snapshot()
+}
@@ -1,6 +1,7 @@
 package metrics
 
 import (
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,16 +13,30 @@ type Metrics struct {
 	ActiveConnections   int64
 	TotalConnections    int64
 	Disconnections      int64
+	PeakConnections     int64 // most active connections since TakePeakConnections
 
 	// Message metrics
 	TotalMessages       int64
 	MessagesPerSecond   float64
 	MessageLatency      int64 // nanoseconds
 	MessageErrors       int64
+	Delivery            DeliveryStats // outcomes of broadcast writes to clients
 
 	// Room metrics
 	TotalRooms          int64
 	RoomOccupancy       map[string]int64
+	RoomMetrics         map[string]*RoomStats
+	RoomOpQueueDepth    int64 // goroutines waiting to start a room operation
+
+	// Presence metrics
+	PresenceUsers       int64 // users online anywhere in the cluster
+	PresenceEntries     int64 // presence records held for other servers
+
+	// Database metrics
+	DBRetries           int64 // writes retried after transient database errors
+	DBSlowQueries       int64 // queries over the slow query threshold
+	RetentionDeleted    int64 // messages deleted by the retention cleanup
+	dbPool              atomic.Pointer[DBPoolStats]
 
 	// Performance metrics
 	AverageLatency      int64
@@ -32,23 +47,48 @@ type Metrics struct {
 	StartTime           time.Time
 	LastReset           time.Time
 
+	// Recent latency samples (ring buffer) used for percentile calculation
+	latencySamples      []int64
+	latencyNext         int
+
 	// Thread safety
 	Mutex               sync.RWMutex
 }
 
+// RoomStats tracks activity counters for a single room
+type RoomStats struct {
+	RoomName string        `json:"room_name"`
+	Joins    int64         `json:"joins"`
+	Leaves   int64         `json:"leaves"`
+	Messages int64         `json:"messages"`
+	Errors   int64         `json:"errors"`
+	Delivery DeliveryStats `json:"delivery"`
+}
+
+// latencySampleSize is the number of recent latencies kept for percentiles
+const latencySampleSize = 1024
+
 // NewMetrics creates a new metrics instance
 func NewMetrics() *Metrics {
 	return &Metrics{
-		RoomOccupancy: make(map[string]int64),
-		StartTime:    time.Now(),
-		LastReset:    time.Now(),
+		RoomOccupancy:  make(map[string]int64),
+		RoomMetrics:    make(map[string]*RoomStats),
+		StartTime:      time.Now(),
+		LastReset:      time.Now(),
+		latencySamples: make([]int64, 0, latencySampleSize),
 	}
 }
 
 // IncrementActiveConnections increments the active connection count
 func (m *Metrics) IncrementActiveConnections() {
-	atomic.AddInt64(&m.ActiveConnections, 1)
+	active := atomic.AddInt64(&m.ActiveConnections, 1)
 	atomic.AddInt64(&m.TotalConnections, 1)
+	for {
+		peak := atomic.LoadInt64(&m.PeakConnections)
+		if active <= peak || atomic.CompareAndSwapInt64(&m.PeakConnections, peak, active) {
+			return
+		}
+	}
 }
 
 // DecrementActiveConnections decrements the active connection count
@@ -71,6 +111,37 @@ func (m *Metrics) IncrementMessageErrors() {
 func (m *Metrics) RecordLatency(latency time.Duration) {
 	latencyNanos := latency.Nanoseconds()
 	atomic.AddInt64(&m.MessageLatency, latencyNanos)
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	if len(m.latencySamples) < latencySampleSize {
+		m.latencySamples = append(m.latencySamples, latencyNanos)
+	} else {
+		m.latencySamples[m.latencyNext] = latencyNanos
+	}
+	m.latencyNext = (m.latencyNext + 1) % latencySampleSize
+}
+
+// GetLatencyPercentile returns the p-th percentile (0-100) of recent latencies
+func (m *Metrics) GetLatencyPercentile(p float64) time.Duration {
+	m.Mutex.RLock()
+	samples := make([]int64, len(m.latencySamples))
+	copy(samples, m.latencySamples)
+	m.Mutex.RUnlock()
+
+	if len(samples) == 0 {
+		return 0
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	idx := int(float64(len(samples)-1) * p / 100)
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+	return time.Duration(samples[idx])
 }
 
 // GetActiveConnections returns the current active connection count
@@ -78,6 +149,12 @@ func (m *Metrics) GetActiveConnections() int64 {
 	return atomic.LoadInt64(&m.ActiveConnections)
 }
 
+// TakePeakConnections returns the most active connections seen since the
+// last call and starts the next period from the current count
+func (m *Metrics) TakePeakConnections() int64 {
+	return atomic.SwapInt64(&m.PeakConnections, atomic.LoadInt64(&m.ActiveConnections))
+}
+
 // GetTotalConnections returns the total connection count
 func (m *Metrics) GetTotalConnections() int64 {
 	return atomic.LoadInt64(&m.TotalConnections)
@@ -148,6 +225,176 @@ func (m *Metrics) RemoveRoom(roomName string) {
 	m.Mutex.Lock()
 	defer m.Mutex.Unlock()
 	delete(m.RoomOccupancy, roomName)
+	delete(m.RoomMetrics, roomName)
+}
+
+// roomStats returns the stats entry for a room, creating it on first access
+func (m *Metrics) roomStats(roomName string) *RoomStats {
+	m.Mutex.RLock()
+	stats, exists := m.RoomMetrics[roomName]
+	m.Mutex.RUnlock()
+	if exists {
+		return stats
+	}
+
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+	if stats, exists = m.RoomMetrics[roomName]; !exists {
+		stats = &RoomStats{RoomName: roomName}
+		m.RoomMetrics[roomName] = stats
+	}
+	return stats
+}
+
+// RecordRoomJoin increments the join count for a room
+func (m *Metrics) RecordRoomJoin(roomName string) {
+	atomic.AddInt64(&m.roomStats(roomName).Joins, 1)
+}
+
+// RecordRoomLeave increments the leave count for a room
+func (m *Metrics) RecordRoomLeave(roomName string) {
+	atomic.AddInt64(&m.roomStats(roomName).Leaves, 1)
+}
+
+// RecordRoomMessage increments the message count for a room
+func (m *Metrics) RecordRoomMessage(roomName string) {
+	atomic.AddInt64(&m.roomStats(roomName).Messages, 1)
+}
+
+// RecordRoomError increments the error count for a room
+func (m *Metrics) RecordRoomError(roomName string) {
+	atomic.AddInt64(&m.roomStats(roomName).Errors, 1)
+}
+
+// GetRoomStats returns a snapshot of the counters for a room
+func (m *Metrics) GetRoomStats(roomName string) RoomStats {
+	m.Mutex.RLock()
+	stats, exists := m.RoomMetrics[roomName]
+	m.Mutex.RUnlock()
+	if !exists {
+		return RoomStats{RoomName: roomName}
+	}
+	return stats.snapshot()
+}
+
+// GetTopRooms returns up to n rooms sorted by message count, most active first
+func (m *Metrics) GetTopRooms(n int) []RoomStats {
+	m.Mutex.RLock()
+	rooms := make([]RoomStats, 0, len(m.RoomMetrics))
+	for _, stats := range m.RoomMetrics {
+		rooms = append(rooms, stats.snapshot())
+	}
+	m.Mutex.RUnlock()
+
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].Messages != rooms[j].Messages {
+			return rooms[i].Messages > rooms[j].Messages
+		}
+		return rooms[i].RoomName < rooms[j].RoomName
+	})
+
+	if n >= 0 && n < len(rooms) {
+		rooms = rooms[:n]
+	}
+	return rooms
+}
+
+// snapshot loads all counters atomically into a plain value
+func (s *RoomStats) snapshot() RoomStats {
+	return RoomStats{
+		RoomName: s.RoomName,
+		Joins:    atomic.LoadInt64(&s.Joins),
+		Leaves:   atomic.LoadInt64(&s.Leaves),
+		Messages: atomic.LoadInt64(&s.Messages),
+		Errors:   atomic.LoadInt64(&s.Errors),
+		Delivery: s.Delivery.snapshot(),
+	}
+}
+
+// IncrementRoomOpQueueDepth records a goroutine waiting to start a room operation
+func (m *Metrics) IncrementRoomOpQueueDepth() {
+	atomic.AddInt64(&m.RoomOpQueueDepth, 1)
+}
+
+// DecrementRoomOpQueueDepth records a waiting goroutine leaving the room operation queue
+func (m *Metrics) DecrementRoomOpQueueDepth() {
+	atomic.AddInt64(&m.RoomOpQueueDepth, -1)
+}
+
+// GetRoomOpQueueDepth returns the number of goroutines waiting to start a room operation
+func (m *Metrics) GetRoomOpQueueDepth() int64 {
+	return atomic.LoadInt64(&m.RoomOpQueueDepth)
+}
+
+// SetPresenceCounts records the users online across the cluster and the
+// presence records held for other servers
+func (m *Metrics) SetPresenceCounts(users, entries int64) {
+	atomic.StoreInt64(&m.PresenceUsers, users)
+	atomic.StoreInt64(&m.PresenceEntries, entries)
+}
+
+// GetPresenceUsers returns the users online across the cluster
+func (m *Metrics) GetPresenceUsers() int64 {
+	return atomic.LoadInt64(&m.PresenceUsers)
+}
+
+// GetPresenceEntries returns the presence records held for other servers
+func (m *Metrics) GetPresenceEntries() int64 {
+	return atomic.LoadInt64(&m.PresenceEntries)
+}
+
+// IncrementDBRetries counts a database write retried after a transient error
+func (m *Metrics) IncrementDBRetries() {
+	atomic.AddInt64(&m.DBRetries, 1)
+}
+
+// GetDBRetries returns how many database writes have been retried
+func (m *Metrics) GetDBRetries() int64 {
+	return atomic.LoadInt64(&m.DBRetries)
+}
+
+// DBPoolStats is a sample of the database connection pool's state
+type DBPoolStats struct {
+	AcquiredConns   int32         `json:"acquired_conns"`
+	IdleConns       int32         `json:"idle_conns"`
+	TotalConns      int32         `json:"total_conns"`
+	MaxConns        int32         `json:"max_conns"`
+	EmptyAcquires   int64         `json:"empty_acquires"` // Acquires that had to wait for a connection
+	AcquireDuration time.Duration `json:"acquire_duration_ns"`
+}
+
+// SetDBPoolStats records the latest database pool sample
+func (m *Metrics) SetDBPoolStats(stats DBPoolStats) {
+	m.dbPool.Store(&stats)
+}
+
+// GetDBPoolStats returns the latest database pool sample, or the zero value
+// if the pool hasn't been sampled
+func (m *Metrics) GetDBPoolStats() DBPoolStats {
+	if stats := m.dbPool.Load(); stats != nil {
+		return *stats
+	}
+	return DBPoolStats{}
+}
+
+// SetDBSlowQueries records how many queries have exceeded the slow query threshold
+func (m *Metrics) SetDBSlowQueries(count int64) {
+	atomic.StoreInt64(&m.DBSlowQueries, count)
+}
+
+// GetDBSlowQueries returns how many queries have exceeded the slow query threshold
+func (m *Metrics) GetDBSlowQueries() int64 {
+	return atomic.LoadInt64(&m.DBSlowQueries)
+}
+
+// AddRetentionDeletedMessages counts messages deleted by the retention cleanup
+func (m *Metrics) AddRetentionDeletedMessages(n int64) {
+	atomic.AddInt64(&m.RetentionDeleted, n)
+}
+
+// GetRetentionDeletedMessages returns how many messages the retention cleanup has deleted
+func (m *Metrics) GetRetentionDeletedMessages() int64 {
+	return atomic.LoadInt64(&m.RetentionDeleted)
 }
 
 // Reset resets the metrics (except total counters)
@@ -156,10 +403,14 @@ func (m *Metrics) Reset() {
 	defer m.Mutex.Unlock()
 
 	atomic.StoreInt64(&m.ActiveConnections, 0)
+	atomic.StoreInt64(&m.PeakConnections, 0)
 	atomic.StoreInt64(&m.Disconnections, 0)
 	atomic.StoreInt64(&m.MessageErrors, 0)
 	atomic.StoreInt64(&m.MessageLatency, 0)
 	m.RoomOccupancy = make(map[string]int64)
+	m.RoomMetrics = make(map[string]*RoomStats)
+	m.latencySamples = m.latencySamples[:0]
+	m.latencyNext = 0
 	m.LastReset = time.Now()
 }
 
@@ -176,9 +427,20 @@ func (m *Metrics) GetSummary() map[string]interface{} {
 		"disconnections":        atomic.LoadInt64(&m.Disconnections),
 		"total_messages":        m.GetTotalMessages(),
 		"message_errors":        m.GetMessageErrors(),
+		"delivery":              m.GetDeliveryStats(),
+		"delivery_ratio":        m.GetDeliveryStats().Ratio(),
 		"messages_per_second":   m.GetMessagesPerSecond(),
 		"average_latency_ms":    m.GetAverageLatency().Milliseconds(),
+		"p95_latency_ms":        m.GetLatencyPercentile(95).Milliseconds(),
+		"p99_latency_ms":        m.GetLatencyPercentile(99).Milliseconds(),
 		"room_occupancy":        m.GetAllRoomOccupancy(),
+		"room_op_queue_depth":   m.GetRoomOpQueueDepth(),
+		"presence_users":        m.GetPresenceUsers(),
+		"presence_entries":      m.GetPresenceEntries(),
+		"db_retries":            m.GetDBRetries(),
+		"db_slow_queries":       m.GetDBSlowQueries(),
+		"db_pool":               m.GetDBPoolStats(),
+		"retention_deleted":     m.GetRetentionDeletedMessages(),
 		"uptime_seconds":        m.GetUptime().Seconds(),
 	}
 }
\ No newline at end of file
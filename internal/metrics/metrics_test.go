@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoomStatsSession(t *testing.T) {
+	m := NewMetrics()
+
+	// Simulate a session: three joins, one leave, five messages, one error
+	for i := 0; i < 3; i++ {
+		m.RecordRoomJoin("lobby")
+	}
+	m.RecordRoomLeave("lobby")
+	for i := 0; i < 5; i++ {
+		m.RecordRoomMessage("lobby")
+	}
+	m.RecordRoomError("lobby")
+
+	stats := m.GetRoomStats("lobby")
+	assert.Equal(t, "lobby", stats.RoomName)
+	assert.Equal(t, int64(3), stats.Joins)
+	assert.Equal(t, int64(1), stats.Leaves)
+	assert.Equal(t, int64(5), stats.Messages)
+	assert.Equal(t, int64(1), stats.Errors)
+
+	// Unknown rooms return zero counts
+	assert.Equal(t, RoomStats{RoomName: "missing"}, m.GetRoomStats("missing"))
+}
+
+func TestGetTopRooms(t *testing.T) {
+	m := NewMetrics()
+
+	counts := map[string]int{"quiet": 1, "busy": 10, "medium": 5}
+	for room, n := range counts {
+		for i := 0; i < n; i++ {
+			m.RecordRoomMessage(room)
+		}
+	}
+
+	top := m.GetTopRooms(2)
+	assert.Len(t, top, 2)
+	assert.Equal(t, "busy", top[0].RoomName)
+	assert.Equal(t, "medium", top[1].RoomName)
+
+	assert.Len(t, m.GetTopRooms(10), 3)
+}
+
+func TestConcurrentRoomStats(t *testing.T) {
+	m := NewMetrics()
+
+	var wg sync.WaitGroup
+	const numGoroutines = 100
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.RecordRoomJoin("race")
+			m.RecordRoomMessage("race")
+		}()
+	}
+	wg.Wait()
+
+	stats := m.GetRoomStats("race")
+	assert.Equal(t, int64(numGoroutines), stats.Joins)
+	assert.Equal(t, int64(numGoroutines), stats.Messages)
+}
+
+func TestPrometheusRoomGauges(t *testing.T) {
+	m := NewMetrics()
+	m.RecordRoomJoin("lobby")
+	m.RecordRoomMessage("lobby")
+	m.RecordRoomMessage("lobby")
+
+	var buf bytes.Buffer
+	NewPrometheusExporter(m).Write(&buf)
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE chatx_room_messages gauge")
+	assert.Contains(t, out, `chatx_room_joins{room_name="lobby"} 1`)
+	assert.Contains(t, out, `chatx_room_messages{room_name="lobby"} 2`)
+}
+
+func TestPresenceGauges(t *testing.T) {
+	m := NewMetrics()
+	m.SetPresenceCounts(7, 3)
+
+	summary := m.GetSummary()
+	assert.Equal(t, int64(7), summary["presence_users"])
+	assert.Equal(t, int64(3), summary["presence_entries"])
+
+	var buf bytes.Buffer
+	NewPrometheusExporter(m).Write(&buf)
+	out := buf.String()
+
+	assert.Contains(t, out, "# TYPE chatx_presence_users gauge")
+	assert.Contains(t, out, "chatx_presence_users 7\n")
+	assert.Contains(t, out, "chatx_presence_entries 3\n")
+}
+
+func TestDBRetriesCounter(t *testing.T) {
+	m := NewMetrics()
+	m.IncrementDBRetries()
+	m.IncrementDBRetries()
+
+	assert.Equal(t, int64(2), m.GetSummary()["db_retries"])
+
+	var buf bytes.Buffer
+	NewPrometheusExporter(m).Write(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE chatx_db_retries_total counter")
+	assert.Contains(t, out, "chatx_db_retries_total 2\n")
+}
+
+func TestDBPoolMetrics(t *testing.T) {
+	m := NewMetrics()
+	assert.Equal(t, DBPoolStats{}, m.GetSummary()["db_pool"], "unsampled pools report zeros")
+
+	m.SetDBPoolStats(DBPoolStats{AcquiredConns: 3, IdleConns: 2, TotalConns: 5, MaxConns: 25, EmptyAcquires: 4, AcquireDuration: 1500 * time.Millisecond})
+	m.SetDBSlowQueries(6)
+
+	summary := m.GetSummary()
+	assert.Equal(t, int64(6), summary["db_slow_queries"])
+	assert.Equal(t, int32(3), summary["db_pool"].(DBPoolStats).AcquiredConns)
+
+	var buf bytes.Buffer
+	NewPrometheusExporter(m).Write(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "chatx_db_slow_queries_total 6\n")
+	assert.Contains(t, out, "chatx_db_pool_acquired_conns 3\n")
+	assert.Contains(t, out, "chatx_db_pool_idle_conns 2\n")
+	assert.Contains(t, out, "chatx_db_pool_total_conns 5\n")
+	assert.Contains(t, out, "chatx_db_pool_max_conns 25\n")
+	assert.Contains(t, out, "# TYPE chatx_db_pool_empty_acquires_total counter")
+	assert.Contains(t, out, "chatx_db_pool_empty_acquires_total 4\n")
+	assert.Contains(t, out, "chatx_db_pool_acquire_seconds_total 1.5\n")
+}
+
+func TestRetentionDeletedCounter(t *testing.T) {
+	m := NewMetrics()
+	m.AddRetentionDeletedMessages(1000)
+	m.AddRetentionDeletedMessages(234)
+
+	assert.Equal(t, int64(1234), m.GetSummary()["retention_deleted"])
+
+	var buf bytes.Buffer
+	NewPrometheusExporter(m).Write(&buf)
+	assert.Contains(t, buf.String(), "chatx_retention_deleted_messages_total 1234\n")
+}
+
+func TestTakePeakConnections(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < 3; i++ {
+		m.IncrementActiveConnections()
+	}
+	m.DecrementActiveConnections()
+	m.DecrementActiveConnections()
+
+	assert.Equal(t, int64(3), m.TakePeakConnections())
+	assert.Equal(t, int64(1), m.TakePeakConnections(), "the next period starts from the open connections")
+	m.IncrementActiveConnections()
+	assert.Equal(t, int64(2), m.TakePeakConnections())
+}
+
+func TestDeliveryCounters(t *testing.T) {
+	m := NewMetrics()
+	assert.Equal(t, 1.0, m.GetSummary()["delivery_ratio"], "no writes yet")
+
+	var lobby DeliveryStats
+	for _, outcome := range []DeliveryOutcome{DeliveryDelivered, DeliveryDelivered, DeliveryDelivered, DeliverySkipped, DeliveryFailed} {
+		lobby.Record(outcome)
+	}
+	m.RecordDeliveries("lobby", lobby)
+	var global DeliveryStats
+	global.Record(DeliveryDropped)
+	m.RecordDeliveries("", global)
+
+	assert.Equal(t, DeliveryStats{Attempted: 5, Delivered: 3, Skipped: 1, Failed: 1}, m.GetRoomStats("lobby").Delivery)
+	assert.Equal(t, DeliveryStats{Attempted: 6, Delivered: 3, Skipped: 1, Failed: 1, Dropped: 1}, m.GetDeliveryStats())
+	assert.Len(t, m.GetTopRooms(-1), 1, "global deliveries have no room")
+
+	summary := m.GetSummary()
+	assert.Equal(t, m.GetDeliveryStats(), summary["delivery"])
+	assert.Equal(t, 0.6, summary["delivery_ratio"], "skipped clients don't count against the ratio")
+
+	var buf bytes.Buffer
+	NewPrometheusExporter(m).Write(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE chatx_message_deliveries_total counter")
+	assert.Contains(t, out, `chatx_message_deliveries_total{outcome="attempted"} 6`)
+	assert.Contains(t, out, `chatx_message_deliveries_total{outcome="dropped"} 1`)
+	assert.Contains(t, out, "chatx_message_delivery_ratio 0.6\n")
+	assert.Contains(t, out, `chatx_room_deliveries{room_name="lobby",outcome="delivered"} 3`)
+	assert.Contains(t, out, `chatx_room_deliveries{room_name="lobby",outcome="failed"} 1`)
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// PrometheusExporter renders Metrics in the Prometheus text exposition format
+type PrometheusExporter struct {
+	metrics *Metrics
+}
+
+// NewPrometheusExporter creates an exporter for the given metrics instance
+func NewPrometheusExporter(m *Metrics) *PrometheusExporter {
+	return &PrometheusExporter{metrics: m}
+}
+
+// ServeHTTP writes the current metrics in Prometheus text format
+func (p *PrometheusExporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.Write(w)
+}
+
+// Write writes the current metrics in Prometheus text format
+func (p *PrometheusExporter) Write(w io.Writer) {
+	m := p.metrics
+
+	writeMetric(w, "chatx_active_connections", "gauge", "Currently open WebSocket connections", float64(m.GetActiveConnections()))
+	writeMetric(w, "chatx_connections_total", "counter", "WebSocket connections accepted", float64(m.GetTotalConnections()))
+	writeMetric(w, "chatx_disconnections_total", "counter", "WebSocket connections closed", float64(atomic.LoadInt64(&m.Disconnections)))
+	writeMetric(w, "chatx_messages_total", "counter", "Messages broadcast", float64(m.GetTotalMessages()))
+	writeMetric(w, "chatx_message_errors_total", "counter", "Message processing errors", float64(m.GetMessageErrors()))
+
+	delivery := m.GetDeliveryStats()
+	fmt.Fprintf(w, "# HELP chatx_message_deliveries_total Broadcast writes to clients by outcome\n# TYPE chatx_message_deliveries_total counter\n")
+	writeDeliveries(w, "chatx_message_deliveries_total", "", delivery)
+	writeMetric(w, "chatx_message_delivery_ratio", "gauge", "Share of broadcast writes that reached the client", delivery.Ratio())
+
+	writeMetric(w, "chatx_room_op_queue_depth", "gauge", "Goroutines waiting to start a room operation", float64(m.GetRoomOpQueueDepth()))
+	writeMetric(w, "chatx_presence_users", "gauge", "Users online anywhere in the cluster", float64(m.GetPresenceUsers()))
+	writeMetric(w, "chatx_presence_entries", "gauge", "Presence records held for other servers", float64(m.GetPresenceEntries()))
+	writeMetric(w, "chatx_db_retries_total", "counter", "Database writes retried after transient errors", float64(m.GetDBRetries()))
+	writeMetric(w, "chatx_db_slow_queries_total", "counter", "Database queries over the slow query threshold", float64(m.GetDBSlowQueries()))
+
+	pool := m.GetDBPoolStats()
+	writeMetric(w, "chatx_db_pool_acquired_conns", "gauge", "Database connections in use", float64(pool.AcquiredConns))
+	writeMetric(w, "chatx_db_pool_idle_conns", "gauge", "Idle database connections", float64(pool.IdleConns))
+	writeMetric(w, "chatx_db_pool_total_conns", "gauge", "Open database connections", float64(pool.TotalConns))
+	writeMetric(w, "chatx_db_pool_max_conns", "gauge", "Database connection pool size limit", float64(pool.MaxConns))
+	writeMetric(w, "chatx_db_pool_empty_acquires_total", "counter", "Database connection acquires that waited for a connection", float64(pool.EmptyAcquires))
+	writeMetric(w, "chatx_db_pool_acquire_seconds_total", "counter", "Time spent acquiring database connections", pool.AcquireDuration.Seconds())
+	writeMetric(w, "chatx_retention_deleted_messages_total", "counter", "Messages deleted by the retention cleanup", float64(m.GetRetentionDeletedMessages()))
+	writeMetric(w, "chatx_uptime_seconds", "gauge", "Process uptime", m.GetUptime().Seconds())
+
+	rooms := m.GetTopRooms(-1)
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomName < rooms[j].RoomName })
+
+	writeRoomMetric(w, "chatx_room_joins", "Joins per room", rooms, func(s RoomStats) int64 { return s.Joins })
+	writeRoomMetric(w, "chatx_room_leaves", "Leaves per room", rooms, func(s RoomStats) int64 { return s.Leaves })
+	writeRoomMetric(w, "chatx_room_messages", "Messages per room", rooms, func(s RoomStats) int64 { return s.Messages })
+	writeRoomMetric(w, "chatx_room_errors", "Delivery errors per room", rooms, func(s RoomStats) int64 { return s.Errors })
+
+	fmt.Fprintf(w, "# HELP chatx_room_deliveries Broadcast writes to clients per room by outcome\n# TYPE chatx_room_deliveries gauge\n")
+	for _, room := range rooms {
+		writeDeliveries(w, "chatx_room_deliveries", fmt.Sprintf("room_name=\"%s\",", escapeLabel(room.RoomName)), room.Delivery)
+	}
+}
+
+func writeMetric(w io.Writer, name, metricType, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, metricType, name, value)
+}
+
+func writeRoomMetric(w io.Writer, name, help string, rooms []RoomStats, value func(RoomStats) int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	for _, room := range rooms {
+		fmt.Fprintf(w, "%s{room_name=\"%s\"} %d\n", name, escapeLabel(room.RoomName), value(room))
+	}
+}
+
+// writeDeliveries writes one sample of name per delivery outcome, after the
+// labels in prefix
+func writeDeliveries(w io.Writer, name, prefix string, d DeliveryStats) {
+	for _, outcome := range []struct {
+		label string
+		value int64
+	}{
+		{"attempted", d.Attempted},
+		{"delivered", d.Delivered},
+		{"skipped", d.Skipped},
+		{"failed", d.Failed},
+		{"dropped", d.Dropped},
+	} {
+		fmt.Fprintf(w, "%s{%soutcome=\"%s\"} %d\n", name, prefix, outcome.label, outcome.value)
+	}
+}
+
+// escapeLabel escapes a label value per the Prometheus text format
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
@@ -3,9 +3,13 @@ package nats
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"websocket-demo/internal/types"
@@ -15,14 +19,38 @@ import (
 
 // NATSMessage is a simplified message structure that can be marshaled to JSON
 type NATSMessage struct {
-	MessageID  string    `json:"message_id"`  // Unique ID to prevent re-broadcasting
-	Content    []byte    `json:"content"`
-	Type       string    `json:"type"`
-	SenderID   string    `json:"sender_id"`
-	SenderName string    `json:"sender_name"`
-	RoomName   string    `json:"room_name,omitempty"`
-	ServerID   string    `json:"server_id"`   // ID of the server that sent the message
-	Timestamp  time.Time `json:"timestamp"`
+	SchemaVersion int       `json:"schema_version,omitempty"` // Missing in v1 payloads
+	MessageID     string    `json:"message_id"`               // Unique ID to prevent re-broadcasting
+	Content       []byte    `json:"content"`
+	Type          string    `json:"type"`
+	SenderID      string    `json:"sender_id"`
+	SenderConnID  string    `json:"sender_conn_id,omitempty"` // Connection that sent the message
+	SenderName    string    `json:"sender_name"`
+	RoomName      string    `json:"room_name,omitempty"`
+	ServerID      string    `json:"server_id"` // ID of the server that sent the message
+	Timestamp     time.Time `json:"timestamp"`
+
+	// Trace context of the publish span, continued by the receiving server
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// toMessage converts a NATSMessage back to a types.Message
+func (m NATSMessage) toMessage() types.Message {
+	return types.Message{
+		MessageID:    m.MessageID, // Pass message ID to prevent re-broadcasting
+		Content:      m.Content,
+		Type:         m.Type,
+		Timestamp:    m.Timestamp,
+		Sender:       nil,        // Sender is not available across servers
+		Room:         nil,        // Room is not available across servers
+		ServerID:     m.ServerID, // Pass server ID to identify origin
+		SenderID:     m.SenderID,
+		SenderConnID: m.SenderConnID,
+		SenderName:   m.SenderName,
+		RoomName:     m.RoomName,
+
+		TraceContext: m.TraceContext,
+	}
 }
 
 // Client wraps NATS connection and provides messaging functionality
@@ -35,6 +63,13 @@ type Client struct {
 	ctx           context.Context
 	cancel        context.CancelFunc
 	reconnectChan chan struct{}
+	consumerName  string
+	streamName    string
+	drainTimeout  time.Duration
+	closed        chan struct{} // Closed once the connection has closed
+	closedOnce    sync.Once
+
+	rejectedMessages atomic.Uint64 // Messages dropped for an unknown schema version
 }
 
 // Config holds NATS connection configuration
@@ -43,9 +78,99 @@ type Config struct {
 	MaxReconnects  int
 	ReconnectWait  time.Duration
 	Timeout        time.Duration
+	DrainTimeout   time.Duration // How long DrainAndClose waits for pending messages
 	EnableJetStream bool
+
+	// JetStream settings, ignored unless EnableJetStream is set
+	StreamName     string        // Stream capturing chat.room.* and chat.global
+	StreamMaxAge   time.Duration // Oldest message kept in the stream
+	StreamMaxMsgs  int64         // Maximum number of messages kept in the stream
+	StreamMaxBytes int64         // Maximum stream size in bytes, -1 for unlimited
+	ConsumerName   string        // Stable prefix for durable consumers, must survive restarts
+
+	// Authentication; set at most one of Token, User/Password, CredsFile or NKeySeedFile
+	Token        string
+	User         string
+	Password     string
+	CredsFile    string // .creds file holding a user JWT and nkey seed
+	NKeySeedFile string // File holding an nkey seed
+
+	// TLS; TLSCAFile verifies the server, TLSCertFile/TLSKeyFile enable mutual TLS
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// Validate reports contradictory authentication and TLS settings
+func (cfg Config) Validate() error {
+	methods := make([]string, 0, 4)
+	if cfg.Token != "" {
+		methods = append(methods, "token")
+	}
+	if cfg.User != "" || cfg.Password != "" {
+		methods = append(methods, "user/password")
+	}
+	if cfg.CredsFile != "" {
+		methods = append(methods, "creds file")
+	}
+	if cfg.NKeySeedFile != "" {
+		methods = append(methods, "nkey seed file")
+	}
+	if len(methods) > 1 {
+		return fmt.Errorf("NATS auth options are mutually exclusive, got %s", strings.Join(methods, " and "))
+	}
+
+	if (cfg.User == "") != (cfg.Password == "") {
+		return errors.New("NATS user and password must be set together")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return errors.New("NATS TLS certificate and key must be set together")
+	}
+	return nil
 }
 
+// securityOptions maps the authentication and TLS settings to connection options
+func (cfg Config) securityOptions() ([]nats.Option, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	var opts []nats.Option
+	switch {
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.User != "":
+		opts = append(opts, nats.UserInfo(cfg.User, cfg.Password))
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	case cfg.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(cfg.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load NATS nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	}
+
+	if cfg.TLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(cfg.TLSCAFile))
+	}
+	if cfg.TLSCertFile != "" {
+		opts = append(opts, nats.ClientCert(cfg.TLSCertFile, cfg.TLSKeyFile))
+	}
+	return opts, nil
+}
+
+// Defaults for the JetStream chat stream
+const (
+	DefaultStreamName     = "CHAT"
+	DefaultStreamMaxAge   = 24 * time.Hour
+	DefaultStreamMaxMsgs  = 100000
+	DefaultStreamMaxBytes = -1
+
+	// DefaultDrainTimeout is how long DrainAndClose waits when Config.DrainTimeout is unset
+	DefaultDrainTimeout = 5 * time.Second
+)
+
 // NewClient creates a new NATS client with the given configuration
 func NewClient(cfg Config) (*Client, error) {
 	if cfg.URL == "" {
@@ -60,6 +185,14 @@ func NewClient(cfg Config) (*Client, error) {
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 10 * time.Second
 	}
+	if cfg.DrainTimeout == 0 {
+		cfg.DrainTimeout = DefaultDrainTimeout
+	}
+
+	securityOpts, err := cfg.securityOptions()
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -71,6 +204,8 @@ func NewClient(cfg Config) (*Client, error) {
 		cancel:        cancel,
 		reconnectChan: make(chan struct{}, 1),
 		serverID:      serverID,
+		drainTimeout:  cfg.DrainTimeout,
+		closed:        make(chan struct{}),
 	}
 
 	opts := []nats.Option{
@@ -78,6 +213,7 @@ func NewClient(cfg Config) (*Client, error) {
 		nats.MaxReconnects(cfg.MaxReconnects),
 		nats.ReconnectWait(cfg.ReconnectWait),
 		nats.Timeout(cfg.Timeout),
+		nats.DrainTimeout(cfg.DrainTimeout),
 		nats.PingInterval(20 * time.Second),
 		nats.MaxPingsOutstanding(5),
 		nats.ReconnectHandler(func(nc *nats.Conn) {
@@ -101,11 +237,13 @@ func NewClient(cfg Config) (*Client, error) {
 			client.mu.Lock()
 			client.connected = false
 			client.mu.Unlock()
+			client.closedOnce.Do(func() { close(client.closed) })
 		}),
 		nats.ErrorHandler(func(nc *nats.Conn, sub *nats.Subscription, err error) {
 			log.Printf("NATS error: %v", err)
 		}),
 	}
+	opts = append(opts, securityOpts...)
 
 	// Connect to NATS
 	conn, err := nats.Connect(cfg.URL, opts...)
@@ -128,15 +266,68 @@ func NewClient(cfg Config) (*Client, error) {
 			client.Close()
 			return nil, fmt.Errorf("failed to initialize JetStream: %w", err)
 		}
+		if err := ensureStream(js, cfg); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("failed to set up JetStream stream: %w", err)
+		}
 		client.js = js
+		client.streamName = cfg.StreamName
+		if client.streamName == "" {
+			client.streamName = DefaultStreamName
+		}
+		client.consumerName = cfg.ConsumerName
+		if client.consumerName == "" {
+			client.consumerName = serverID
+		}
 		log.Println("NATS JetStream initialized")
 	}
 
 	return client, nil
 }
 
-// Publish publishes a message to a NATS subject
-func (c *Client) Publish(subject string, msg types.Message) error {
+// ensureStream creates the chat stream or updates its retention limits
+func ensureStream(js nats.JetStreamContext, cfg Config) error {
+	if cfg.StreamName == "" {
+		cfg.StreamName = DefaultStreamName
+	}
+	if cfg.StreamMaxAge == 0 {
+		cfg.StreamMaxAge = DefaultStreamMaxAge
+	}
+	if cfg.StreamMaxMsgs == 0 {
+		cfg.StreamMaxMsgs = DefaultStreamMaxMsgs
+	}
+	if cfg.StreamMaxBytes == 0 {
+		cfg.StreamMaxBytes = DefaultStreamMaxBytes
+	}
+
+	streamCfg := &nats.StreamConfig{
+		Name:      cfg.StreamName,
+		Subjects:  []string{SubjectRoomPrefix + ".*", SubjectGlobalChat},
+		Retention: nats.LimitsPolicy,
+		Storage:   nats.FileStorage,
+		MaxAge:    cfg.StreamMaxAge,
+		MaxMsgs:   cfg.StreamMaxMsgs,
+		MaxBytes:  cfg.StreamMaxBytes,
+	}
+
+	if _, err := js.StreamInfo(cfg.StreamName); err == nil {
+		_, err = js.UpdateStream(streamCfg)
+		return err
+	} else if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err := js.AddStream(streamCfg)
+	return err
+}
+
+// isStreamSubject reports whether a subject is captured by the chat stream
+func isStreamSubject(subject string) bool {
+	return subject == SubjectGlobalChat || strings.HasPrefix(subject, SubjectRoomPrefix+".")
+}
+
+// Publish publishes a message to a NATS subject. A message sent under a
+// trace is published in a span whose context travels with it.
+func (c *Client) Publish(subject string, msg types.Message) (err error) {
 	c.mu.RLock()
 	if !c.connected || c.conn == nil {
 		c.mu.RUnlock()
@@ -144,16 +335,25 @@ func (c *Client) Publish(subject string, msg types.Message) error {
 	}
 	c.mu.RUnlock()
 
-	// Generate unique message ID to prevent infinite loops
-	messageID := fmt.Sprintf("%d-%s", time.Now().UnixNano(), msg.Type)
+	// Keep the caller's message ID so consumers can dedupe retried publishes;
+	// otherwise generate a unique one to prevent infinite loops
+	messageID := msg.MessageID
+	if messageID == "" {
+		messageID = fmt.Sprintf("%d-%s", time.Now().UnixNano(), msg.Type)
+	}
 
 	// Convert types.Message to NATSMessage for JSON serialization
 	natsMsg := NATSMessage{
-		MessageID: messageID,
-		Content:   msg.Content,
-		Type:      msg.Type,
-		Timestamp: msg.Timestamp,
-		ServerID:  c.GetServerID(), // Add server ID to track origin
+		SchemaVersion: CurrentSchemaVersion,
+		MessageID:     messageID,
+		Content:       msg.Content,
+		Type:          msg.Type,
+		SenderID:      msg.SenderID,
+		SenderConnID:  msg.SenderConnID,
+		SenderName:    msg.SenderName,
+		RoomName:      msg.RoomName,
+		Timestamp:     msg.Timestamp,
+		ServerID:      c.GetServerID(), // Add server ID to track origin
 	}
 
 	// Extract sender information if available
@@ -162,6 +362,9 @@ func (c *Client) Publish(subject string, msg types.Message) error {
 		if sender, ok := msg.Sender.(interface{ GetID() string }); ok {
 			natsMsg.SenderID = sender.GetID()
 		}
+		if sender, ok := msg.Sender.(interface{ GetConnID() string }); ok {
+			natsMsg.SenderConnID = sender.GetConnID()
+		}
 		if sender, ok := msg.Sender.(interface{ GetName() string }); ok {
 			natsMsg.SenderName = sender.GetName()
 		}
@@ -169,17 +372,32 @@ func (c *Client) Publish(subject string, msg types.Message) error {
 
 	// Extract room name if available
 	if msg.Room != nil {
-		if room, ok := msg.Room.(interface{ GetName() string }); ok {
+		if room, ok := msg.Room.(types.Roomer); ok {
 			natsMsg.RoomName = room.GetName()
 		}
 	}
 
+	span := startPublishSpan(subject, &natsMsg, msg.TraceContext)
+	defer func() { endSpan(span, err) }()
+
 	// Serialize message to JSON
 	data, err := json.Marshal(natsMsg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
+	// Persist chat traffic in the stream when JetStream is on, using the
+	// message ID so retried publishes are deduplicated
+	c.mu.RLock()
+	js := c.js
+	c.mu.RUnlock()
+	if js != nil && isStreamSubject(subject) {
+		if _, err := js.Publish(subject, data, nats.MsgId(messageID)); err != nil {
+			return fmt.Errorf("failed to publish message to JetStream: %w", err)
+		}
+		return nil
+	}
+
 	// Publish message
 	if err := c.conn.Publish(subject, data); err != nil {
 		return fmt.Errorf("failed to publish message: %w", err)
@@ -198,24 +416,185 @@ func (c *Client) Subscribe(subject string, handler func(msg types.Message)) (*na
 	c.mu.RUnlock()
 
 	sub, err := c.conn.Subscribe(subject, func(m *nats.Msg) {
-		var natsMsg NATSMessage
-		if err := json.Unmarshal(m.Data, &natsMsg); err != nil {
-			log.Printf("Failed to unmarshal NATS message: %v", err)
+		msg, err := c.decodeMessage(m)
+		if err != nil {
+			if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+				log.Printf("Failed to unmarshal NATS message: %v", err)
+			}
 			return
 		}
 
-		// Convert NATSMessage back to types.Message
-		msg := types.Message{
-			MessageID: natsMsg.MessageID, // Pass message ID to prevent re-broadcasting
-			Content:   natsMsg.Content,
-			Type:      natsMsg.Type,
-			Timestamp: natsMsg.Timestamp,
-			Sender:    nil, // Sender is not available across servers
-			Room:      nil, // Room is not available across servers
-			ServerID:  natsMsg.ServerID, // Pass server ID to identify origin
+		span := c.startReceiveSpan(m.Subject, &msg)
+		handler(msg)
+		span.End()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	return sub, nil
+}
+
+// SubscribeDurable subscribes to a stream subject through a durable consumer so
+// messages published while this server was down are replayed on resubscribe.
+// Falls back to a core subscription when JetStream is disabled.
+func (c *Client) SubscribeDurable(subject string, handler func(msg types.Message)) (*nats.Subscription, error) {
+	c.mu.RLock()
+	if !c.connected || c.conn == nil {
+		c.mu.RUnlock()
+		return nil, fmt.Errorf("NATS not connected")
+	}
+	js := c.js
+	conn := c.conn
+	streamName := c.streamName
+	c.mu.RUnlock()
+
+	if js == nil || !isStreamSubject(subject) {
+		return c.Subscribe(subject, handler)
+	}
+
+	// A new durable starts at new messages; an existing one resumes after its
+	// last acknowledged sequence. The consumer is created here rather than by
+	// js.Subscribe, which would delete it again on Unsubscribe or Drain.
+	durable := c.DurableName(subject)
+	if _, err := js.ConsumerInfo(streamName, durable); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = js.AddConsumer(streamName, &nats.ConsumerConfig{
+			Durable:        durable,
+			DeliverSubject: conn.NewInbox(),
+			DeliverPolicy:  nats.DeliverNewPolicy,
+			AckPolicy:      nats.AckExplicitPolicy,
+			FilterSubject:  subject,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create durable consumer: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up durable consumer: %w", err)
+	}
+
+	// Messages are acked once the handler returns
+	sub, err := js.Subscribe(subject, func(m *nats.Msg) {
+		msg, err := c.decodeMessage(m)
+		if err != nil {
+			if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+				log.Printf("Failed to unmarshal NATS message: %v", err)
+			}
+			return
 		}
 
+		span := c.startReceiveSpan(m.Subject, &msg)
 		handler(msg)
+		span.End()
+	}, nats.Bind(streamName, durable))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create durable subscription: %w", err)
+	}
+
+	return sub, nil
+}
+
+// DurableName returns the durable consumer name this client uses for a subject
+func (c *Client) DurableName(subject string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return sanitizeConsumerName(c.consumerName + "_" + subject)
+}
+
+// RemoveLegacyRoomConsumer deletes the durable consumer an older version
+// created for a room on its unescaped subject. It is a no-op when JetStream is
+// off, the room name never needed escaping, or the consumer is already gone.
+func (c *Client) RemoveLegacyRoomConsumer(roomName string) error {
+	c.mu.RLock()
+	js := c.js
+	streamName := c.streamName
+	c.mu.RUnlock()
+
+	legacy := fmt.Sprintf("%s.%s", SubjectRoomPrefix, roomName)
+	if js == nil || legacy == RoomSubject(roomName) {
+		return nil
+	}
+	err := js.DeleteConsumer(streamName, c.DurableName(legacy))
+	if err != nil && !errors.Is(err, nats.ErrConsumerNotFound) {
+		return err
+	}
+	return nil
+}
+
+// sanitizeConsumerName replaces characters JetStream rejects in consumer names
+func sanitizeConsumerName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '/', '\\':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// deliveryReceipt is the reply a server sends after delivering a request locally
+type deliveryReceipt struct {
+	Delivered bool   `json:"delivered"`
+	ServerID  string `json:"server_id"`
+}
+
+// Request publishes a message and waits for a server to confirm delivery.
+// Returns false without an error when no server answered within timeout.
+func (c *Client) Request(subject string, msg types.Message, timeout time.Duration) (bool, error) {
+	c.mu.RLock()
+	if !c.connected || c.conn == nil {
+		c.mu.RUnlock()
+		return false, fmt.Errorf("NATS not connected")
+	}
+	conn := c.conn
+	c.mu.RUnlock()
+
+	data, err := c.encodeRequest(msg)
+	if err != nil {
+		return false, err
+	}
+
+	reply, err := conn.Request(subject, data, timeout)
+	if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, nats.ErrTimeout) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var receipt deliveryReceipt
+	if err := json.Unmarshal(reply.Data, &receipt); err != nil {
+		return false, fmt.Errorf("failed to unmarshal delivery receipt: %w", err)
+	}
+	return receipt.Delivered, nil
+}
+
+// SubscribeRequests subscribes to a subject and confirms delivery to the
+// requester whenever the handler reports the message was delivered. Servers
+// that could not deliver stay silent so another server can answer.
+func (c *Client) SubscribeRequests(subject string, handler func(msg types.Message) bool) (*nats.Subscription, error) {
+	c.mu.RLock()
+	if !c.connected || c.conn == nil {
+		c.mu.RUnlock()
+		return nil, fmt.Errorf("NATS not connected")
+	}
+	c.mu.RUnlock()
+
+	sub, err := c.conn.Subscribe(subject, func(m *nats.Msg) {
+		natsMsg, err := c.decodeNATSMessage(m.Data)
+		if err != nil {
+			if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+				log.Printf("Failed to unmarshal NATS message: %v", err)
+			}
+			return
+		}
+
+		if !handler(natsMsg.toMessage()) || m.Reply == "" {
+			return
+		}
+		receipt, _ := json.Marshal(deliveryReceipt{Delivered: true, ServerID: c.GetServerID()})
+		if err := m.Respond(receipt); err != nil {
+			log.Printf("Failed to send delivery receipt: %v", err)
+		}
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to subscribe: %w", err)
@@ -224,6 +603,102 @@ func (c *Client) Subscribe(subject string, handler func(msg types.Message)) (*na
 	return sub, nil
 }
 
+// RequestReply publishes a message and waits for the first server to answer
+// it, for synchronous calls across servers. Returns nil without an error when
+// no server answered within timeout.
+func (c *Client) RequestReply(ctx context.Context, subject string, msg types.Message, timeout time.Duration) (*types.Message, error) {
+	c.mu.RLock()
+	if !c.connected || c.conn == nil {
+		c.mu.RUnlock()
+		return nil, fmt.Errorf("NATS not connected")
+	}
+	conn := c.conn
+	c.mu.RUnlock()
+
+	data, err := c.encodeRequest(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	reply, err := conn.RequestWithContext(ctx, subject, data)
+	if errors.Is(err, nats.ErrNoResponders) || errors.Is(err, context.DeadlineExceeded) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	natsMsg, err := c.decodeNATSMessage(reply.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reply: %w", err)
+	}
+	replyMsg := natsMsg.toMessage()
+	return &replyMsg, nil
+}
+
+// SubscribeReplies subscribes to a subject and answers each request with the
+// message the handler returns. Servers whose handler returns nil stay silent
+// so another server can answer.
+func (c *Client) SubscribeReplies(subject string, handler func(msg types.Message) *types.Message) (*nats.Subscription, error) {
+	c.mu.RLock()
+	if !c.connected || c.conn == nil {
+		c.mu.RUnlock()
+		return nil, fmt.Errorf("NATS not connected")
+	}
+	c.mu.RUnlock()
+
+	sub, err := c.conn.Subscribe(subject, func(m *nats.Msg) {
+		natsMsg, err := c.decodeNATSMessage(m.Data)
+		if err != nil {
+			if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+				log.Printf("Failed to unmarshal NATS message: %v", err)
+			}
+			return
+		}
+
+		reply := handler(natsMsg.toMessage())
+		if reply == nil || m.Reply == "" {
+			return
+		}
+		data, err := c.encodeRequest(*reply)
+		if err != nil {
+			log.Printf("Failed to marshal reply: %v", err)
+			return
+		}
+		if err := m.Respond(data); err != nil {
+			log.Printf("Failed to send reply: %v", err)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	return sub, nil
+}
+
+// encodeRequest serializes a message sent outside the stream, such as a
+// request or its reply
+func (c *Client) encodeRequest(msg types.Message) ([]byte, error) {
+	data, err := json.Marshal(NATSMessage{
+		SchemaVersion: CurrentSchemaVersion,
+		MessageID:     fmt.Sprintf("%d-%s", time.Now().UnixNano(), msg.Type),
+		Content:       msg.Content,
+		Type:          msg.Type,
+		SenderID:      msg.SenderID,
+		SenderConnID:  msg.SenderConnID,
+		SenderName:    msg.SenderName,
+		RoomName:      msg.RoomName,
+		Timestamp:     msg.Timestamp,
+		ServerID:      c.GetServerID(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	return data, nil
+}
+
 // SubscribeQueue creates a queue subscription for load balancing
 func (c *Client) SubscribeQueue(subject, queue string, handler func(msg types.Message)) (*nats.Subscription, error) {
 	c.mu.RLock()
@@ -234,23 +709,17 @@ func (c *Client) SubscribeQueue(subject, queue string, handler func(msg types.Me
 	c.mu.RUnlock()
 
 	sub, err := c.conn.QueueSubscribe(subject, queue, func(m *nats.Msg) {
-		var natsMsg NATSMessage
-		if err := json.Unmarshal(m.Data, &natsMsg); err != nil {
-			log.Printf("Failed to unmarshal NATS message: %v", err)
+		msg, err := c.decodeMessage(m)
+		if err != nil {
+			if !errors.Is(err, ErrUnsupportedSchemaVersion) {
+				log.Printf("Failed to unmarshal NATS message: %v", err)
+			}
 			return
 		}
 
-		// Convert NATSMessage back to types.Message
-		msg := types.Message{
-			MessageID: natsMsg.MessageID, // Pass message ID to prevent re-broadcasting
-			Content:   natsMsg.Content,
-			Type:      natsMsg.Type,
-			Timestamp: natsMsg.Timestamp,
-			Sender:    nil, // Sender is not available across servers
-			Room:      nil, // Room is not available across servers
-		}
-
+		span := c.startReceiveSpan(m.Subject, &msg)
 		handler(msg)
+		span.End()
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create queue subscription: %w", err)
@@ -278,6 +747,41 @@ func (c *Client) Close() {
 	c.mu.Unlock()
 }
 
+// DrainAndClose stops new messages from arriving, lets subscription handlers
+// finish the ones already delivered and flushes pending publishes before
+// closing the connection. It waits at most timeout and then closes the
+// connection regardless. Calling it on a closed client does nothing.
+func (c *Client) DrainAndClose(timeout time.Duration) error {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	if conn == nil {
+		return nil
+	}
+	defer c.Close()
+
+	if err := conn.Drain(); err != nil {
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			return nil
+		}
+		return fmt.Errorf("failed to drain NATS connection: %w", err)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.closed:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("NATS connection did not drain within %s", timeout)
+	}
+}
+
+// DrainTimeout returns how long DrainAndClose should wait, from Config.DrainTimeout
+func (c *Client) DrainTimeout() time.Duration {
+	return c.drainTimeout
+}
+
 // ReconnectChan returns a channel that signals when reconnection occurs
 func (c *Client) ReconnectChan() <-chan struct{} {
 	return c.reconnectChan
@@ -300,6 +804,39 @@ func (c *Client) GetJetStream() (nats.JetStreamContext, error) {
 	return c.js, nil
 }
 
+// Stats holds a snapshot of the NATS connection state
+type Stats struct {
+	Connected     bool   `json:"connected"`
+	ServerID      string `json:"server_id"`
+	URL           string `json:"url,omitempty"`
+	Subscriptions int    `json:"subscriptions"`
+	InMsgs        uint64 `json:"in_msgs"`
+	OutMsgs       uint64 `json:"out_msgs"`
+	Reconnects    uint64 `json:"reconnects"`
+	Rejected      uint64 `json:"rejected_messages"` // Dropped for an unknown schema version
+}
+
+// Stat returns a snapshot of the connection state and traffic counters
+func (c *Client) Stat() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := Stats{
+		Connected: c.connected,
+		ServerID:  c.serverID,
+		Rejected:  c.rejectedMessages.Load(),
+	}
+	if c.conn != nil {
+		connStats := c.conn.Stats()
+		stats.URL = c.conn.ConnectedUrlRedacted()
+		stats.Subscriptions = c.conn.NumSubscriptions()
+		stats.InMsgs = connStats.InMsgs
+		stats.OutMsgs = connStats.OutMsgs
+		stats.Reconnects = connStats.Reconnects
+	}
+	return stats
+}
+
 // GetServerID returns the unique server ID
 func (c *Client) GetServerID() string {
 	c.mu.RLock()
@@ -309,18 +846,80 @@ func (c *Client) GetServerID() string {
 
 // Subject constants for NATS messaging
 const (
-	SubjectGlobalChat     = "chat.global"
-	SubjectRoomPrefix     = "chat.room"
-	SubjectPresencePrefix = "presence"
-	SubjectRoomSync       = "room.sync"  // For room synchronization across servers
+	SubjectGlobalChat       = "chat.global"
+	SubjectRoomPrefix       = "chat.room"
+	SubjectPresencePrefix   = "presence"
+	SubjectRoomSync         = "room.sync"         // For room synchronization across servers
+	SubjectUserPrefix       = "chat.user"         // Invites and disconnect requests for a user, wherever they are connected
+	SubjectUserDMPrefix     = "user.dm"           // Direct messages to a user, wherever they are connected
+	SubjectUserLookup       = "user.lookup"       // Requests for the server a user is connected to
+	SubjectUserPresence     = "user.presence"     // User online/offline events across servers
+	SubjectClusterHeartbeat = "cluster.heartbeat" // Server liveness and load for cluster membership
 )
 
-// RoomSubject returns the NATS subject for a specific room
+// RoomSubject returns the NATS subject for a specific room. The name is
+// escaped into a single token, so names with spaces, dots or wildcards still
+// give a valid subject that no other room shares.
 func RoomSubject(roomName string) string {
-	return fmt.Sprintf("%s.%s", SubjectRoomPrefix, roomName)
+	return fmt.Sprintf("%s.%s", SubjectRoomPrefix, escapeSubjectToken(roomName))
+}
+
+// RoomNameFromSubject recovers the room name from a subject built by RoomSubject
+func RoomNameFromSubject(subject string) (string, bool) {
+	token, ok := strings.CutPrefix(subject, SubjectRoomPrefix+".")
+	if !ok {
+		return "", false
+	}
+	return unescapeSubjectToken(token)
+}
+
+// UserSubject returns the NATS subject for invites and disconnect requests to a user
+func UserSubject(userID string) string {
+	return fmt.Sprintf("%s.%s", SubjectUserPrefix, userID)
+}
+
+// UserDMSubject returns the NATS subject for direct messages to a user
+func UserDMSubject(userID string) string {
+	return fmt.Sprintf("%s.%s", SubjectUserDMPrefix, userID)
 }
 
 // PresenceSubject returns the NATS subject for presence updates
 func PresenceSubject(roomName string) string {
-	return fmt.Sprintf("%s.%s", SubjectPresencePrefix, roomName)
+	return fmt.Sprintf("%s.%s", SubjectPresencePrefix, escapeSubjectToken(roomName))
+}
+
+// escapeSubjectToken encodes a name as one subject token. Letters, digits,
+// '-' and '_' are kept; every other byte becomes %XX. An empty name becomes
+// a lone "%", which no escaped name can produce.
+func escapeSubjectToken(name string) string {
+	if name == "" {
+		return "%"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9', ch == '-', ch == '_':
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+// unescapeSubjectToken reverses escapeSubjectToken
+func unescapeSubjectToken(token string) (string, bool) {
+	if token == "%" {
+		return "", true
+	}
+	if token == "" || strings.Contains(token, ".") {
+		return "", false
+	}
+	name, err := url.PathUnescape(token)
+	if err != nil {
+		return "", false
+	}
+	return name, true
 }
\ No newline at end of file
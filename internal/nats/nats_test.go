@@ -0,0 +1,428 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/types"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs an embedded NATS server on the given port (-1 picks a random one)
+func startServer(t *testing.T, port int) *natsserver.Server {
+	t.Helper()
+
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second), "NATS server did not start")
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// newTestClient connects a client that retries quickly after disconnects
+func newTestClient(t *testing.T, url string) *Client {
+	t.Helper()
+
+	c, err := NewClient(Config{URL: url, MaxReconnects: 100, ReconnectWait: 50 * time.Millisecond, Timeout: time.Second})
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+	return c
+}
+
+// receive collects messages delivered to a subscription handler
+func receive(ch chan<- types.Message) func(types.Message) {
+	return func(msg types.Message) { ch <- msg }
+}
+
+func TestNewClientConnects(t *testing.T) {
+	srv := startServer(t, -1)
+
+	c := newTestClient(t, srv.ClientURL())
+	assert.True(t, c.IsConnected())
+	assert.NotEmpty(t, c.GetServerID())
+}
+
+func TestNewClientFailsWhenServerDown(t *testing.T) {
+	// Reserve a port, then free it so nothing is listening
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	url := "nats://" + listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	c, err := NewClient(Config{URL: url, Timeout: 500 * time.Millisecond})
+	assert.Error(t, err)
+	assert.Nil(t, c)
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	srv := startServer(t, -1)
+	publisher := newTestClient(t, srv.ClientURL())
+	subscriber := newTestClient(t, srv.ClientURL())
+
+	received := make(chan types.Message, 1)
+	_, err := subscriber.Subscribe(RoomSubject("lobby"), receive(received))
+	require.NoError(t, err)
+	require.NoError(t, subscriber.GetConn().Flush())
+
+	require.NoError(t, publisher.Publish(RoomSubject("lobby"), types.Message{
+		Content:      []byte("hello"),
+		Type:         types.MsgTypeRoomMessage,
+		SenderID:     "user-alice",
+		SenderConnID: "conn-1",
+		SenderName:   "alice",
+	}))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "hello", string(msg.Content))
+		assert.Equal(t, types.MsgTypeRoomMessage, msg.Type)
+		assert.Equal(t, "user-alice", msg.SenderID)
+		assert.Equal(t, "conn-1", msg.SenderConnID)
+		assert.Equal(t, "alice", msg.SenderName)
+		assert.Equal(t, "lobby", msg.RoomName, "room name taken from the subject")
+		assert.Equal(t, publisher.GetServerID(), msg.ServerID)
+		assert.NotEmpty(t, msg.MessageID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not delivered")
+	}
+}
+
+func TestSubscribeQueueDeliversOneCopy(t *testing.T) {
+	srv := startServer(t, -1)
+	publisher := newTestClient(t, srv.ClientURL())
+
+	var delivered atomic.Int32
+	for i := 0; i < 2; i++ {
+		worker := newTestClient(t, srv.ClientURL())
+		_, err := worker.SubscribeQueue("jobs", "workers", func(types.Message) { delivered.Add(1) })
+		require.NoError(t, err)
+		require.NoError(t, worker.GetConn().Flush())
+	}
+
+	const published = 10
+	for i := 0; i < published; i++ {
+		require.NoError(t, publisher.Publish("jobs", types.Message{Content: []byte(fmt.Sprintf("job-%d", i))}))
+	}
+	require.NoError(t, publisher.GetConn().Flush())
+
+	require.Eventually(t, func() bool { return delivered.Load() == published }, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(published), delivered.Load(), "each message goes to exactly one queue member")
+}
+
+func TestRequestReply(t *testing.T) {
+	srv := startServer(t, -1)
+	requester := newTestClient(t, srv.ClientURL())
+	silent := newTestClient(t, srv.ClientURL())
+	responder := newTestClient(t, srv.ClientURL())
+
+	// Servers whose handler returns nil leave the answer to another one
+	_, err := silent.SubscribeReplies("lookup", func(types.Message) *types.Message { return nil })
+	require.NoError(t, err)
+	_, err = responder.SubscribeReplies("lookup", func(msg types.Message) *types.Message {
+		return &types.Message{Content: append([]byte("re: "), msg.Content...), Type: msg.Type}
+	})
+	require.NoError(t, err)
+	require.NoError(t, silent.GetConn().Flush())
+	require.NoError(t, responder.GetConn().Flush())
+
+	reply, err := requester.RequestReply(context.Background(), "lookup", types.Message{Content: []byte("ping"), Type: "ping"}, 2*time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, reply)
+	assert.Equal(t, "re: ping", string(reply.Content))
+	assert.Equal(t, "ping", reply.Type)
+	assert.Equal(t, responder.GetServerID(), reply.ServerID)
+
+	// No responders and timeouts both mean nobody answered
+	reply, err = requester.RequestReply(context.Background(), "nobody", types.Message{Content: []byte("ping")}, time.Second)
+	require.NoError(t, err)
+	assert.Nil(t, reply)
+
+	_, err = silent.SubscribeReplies("quiet", func(types.Message) *types.Message { return nil })
+	require.NoError(t, err)
+	require.NoError(t, silent.GetConn().Flush())
+	start := time.Now()
+	reply, err = requester.RequestReply(context.Background(), "quiet", types.Message{Content: []byte("ping")}, 200*time.Millisecond)
+	require.NoError(t, err)
+	assert.Nil(t, reply)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestIsConnectedFalseAfterClose(t *testing.T) {
+	srv := startServer(t, -1)
+
+	c := newTestClient(t, srv.ClientURL())
+	require.True(t, c.IsConnected())
+	c.Close()
+	assert.False(t, c.IsConnected())
+	assert.Error(t, c.Publish("after.close", types.Message{Content: []byte("x")}))
+}
+
+func TestDrainAndCloseDeliversPending(t *testing.T) {
+	srv := startServer(t, -1)
+	publisher := newTestClient(t, srv.ClientURL())
+	subscriber := newTestClient(t, srv.ClientURL())
+
+	// A slow handler leaves messages waiting when the drain starts
+	var handled atomic.Int32
+	_, err := subscriber.Subscribe("drain", func(types.Message) {
+		time.Sleep(20 * time.Millisecond)
+		handled.Add(1)
+	})
+	require.NoError(t, err)
+	require.NoError(t, subscriber.GetConn().Flush())
+
+	const published = 10
+	for i := 0; i < published; i++ {
+		require.NoError(t, publisher.Publish("drain", types.Message{Content: []byte(fmt.Sprintf("message-%d", i))}))
+	}
+	require.NoError(t, publisher.GetConn().Flush())
+
+	require.NoError(t, subscriber.DrainAndClose(5*time.Second))
+	assert.Equal(t, int32(published), handled.Load(), "every delivered message is handled before closing")
+	assert.False(t, subscriber.IsConnected())
+	assert.Nil(t, subscriber.GetConn())
+
+	assert.NoError(t, subscriber.DrainAndClose(time.Second), "draining a closed client does nothing")
+}
+
+func TestReconnectAfterServerRestart(t *testing.T) {
+	srv := startServer(t, -1)
+	port := srv.Addr().(*net.TCPAddr).Port
+	c := newTestClient(t, srv.ClientURL())
+
+	srv.Shutdown()
+	require.Eventually(t, func() bool { return !c.IsConnected() }, 5*time.Second, 10*time.Millisecond)
+
+	// Publishing while disconnected fails instead of buffering silently
+	assert.Error(t, c.Publish("while.down", types.Message{Content: []byte("x")}))
+
+	startServer(t, port)
+	require.Eventually(t, c.IsConnected, 5*time.Second, 10*time.Millisecond)
+	select {
+	case <-c.ReconnectChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("reconnect was not signalled")
+	}
+	assert.NoError(t, c.Publish("after.reconnect", types.Message{Content: []byte("x")}))
+}
+
+func TestConcurrentPublishSubscribe(t *testing.T) {
+	srv := startServer(t, -1)
+	c := newTestClient(t, srv.ClientURL())
+
+	var received atomic.Int32
+	_, err := c.Subscribe("load.>", func(types.Message) { received.Add(1) })
+	require.NoError(t, err)
+	require.NoError(t, c.GetConn().Flush())
+
+	const workers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*2)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			subject := fmt.Sprintf("load.%d", i)
+			if _, err := c.Subscribe(subject, func(types.Message) {}); err != nil {
+				errs <- err
+			}
+			if err := c.Publish(subject, types.Message{Content: []byte(subject)}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("concurrent publish and subscribe deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return received.Load() == workers }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNewClientTokenAuth(t *testing.T) {
+	srv, err := natsserver.NewServer(&natsserver.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true, Authorization: "s3cret"})
+	require.NoError(t, err)
+	go srv.Start()
+	require.True(t, srv.ReadyForConnections(5*time.Second), "NATS server did not start")
+	t.Cleanup(srv.Shutdown)
+
+	c, err := NewClient(Config{URL: srv.ClientURL(), Token: "s3cret", Timeout: time.Second})
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+	assert.True(t, c.IsConnected())
+
+	_, err = NewClient(Config{URL: srv.ClientURL(), Token: "wrong", MaxReconnects: -1, Timeout: time.Second})
+	assert.Error(t, err)
+	_, err = NewClient(Config{URL: srv.ClientURL(), MaxReconnects: -1, Timeout: time.Second})
+	assert.Error(t, err)
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{name: "no auth", cfg: Config{}},
+		{name: "token", cfg: Config{Token: "t"}},
+		{name: "user and password", cfg: Config{User: "u", Password: "p"}},
+		{name: "creds with TLS", cfg: Config{CredsFile: "c.creds", TLSCAFile: "ca.pem", TLSCertFile: "c.pem", TLSKeyFile: "k.pem"}},
+		{name: "token and creds", cfg: Config{Token: "t", CredsFile: "c.creds"}, wantErr: "mutually exclusive, got token and creds file"},
+		{name: "user and nkey", cfg: Config{User: "u", Password: "p", NKeySeedFile: "seed"}, wantErr: "mutually exclusive, got user/password and nkey seed file"},
+		{name: "user without password", cfg: Config{User: "u"}, wantErr: "user and password must be set together"},
+		{name: "password without user", cfg: Config{Password: "p"}, wantErr: "user and password must be set together"},
+		{name: "cert without key", cfg: Config{TLSCertFile: "c.pem"}, wantErr: "TLS certificate and key must be set together"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+
+			// NewClient refuses the same settings before dialling
+			cfg := tt.cfg
+			cfg.URL = "nats://127.0.0.1:1"
+			_, err = NewClient(cfg)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestRoomSubjectEscapesHostileNames(t *testing.T) {
+	names := []string{
+		"lobby", "my room", "my_room", "my%20room", "a.b", "a_b", "a", "b",
+		"*", ">", "a.*", "a.>", "chat.room.x", "tab\there", "new\nline",
+		"ünïcödé", "%", "%41", "A", "", "a b", "a+b",
+	}
+
+	seen := make(map[string]string)
+	for _, name := range names {
+		subject := RoomSubject(name)
+
+		// Exactly one token after the prefix, free of separators and wildcards
+		token, ok := strings.CutPrefix(subject, SubjectRoomPrefix+".")
+		if assert.True(t, ok, "%q: %s", name, subject) {
+			assert.NotEmpty(t, token, "%q", name)
+			assert.False(t, strings.ContainsAny(token, ".*> \t\r\n"), "%q: %s", name, subject)
+		}
+		presenceToken := strings.TrimPrefix(PresenceSubject(name), SubjectPresencePrefix+".")
+		assert.Equal(t, token, presenceToken, "%q", name)
+
+		if other, dup := seen[subject]; dup {
+			t.Errorf("%q and %q share subject %s", name, other, subject)
+		}
+		seen[subject] = name
+
+		decoded, ok := RoomNameFromSubject(subject)
+		assert.True(t, ok, "%q", name)
+		assert.Equal(t, name, decoded)
+	}
+
+	// Plain names keep readable subjects
+	assert.Equal(t, "chat.room.lobby", RoomSubject("lobby"))
+	assert.Equal(t, "chat.room.my%20room", RoomSubject("my room"))
+
+	_, ok := RoomNameFromSubject(SubjectGlobalChat)
+	assert.False(t, ok)
+	_, ok = RoomNameFromSubject("chat.room.a.b")
+	assert.False(t, ok)
+}
+
+func TestDecodeV1Fixtures(t *testing.T) {
+	// Payloads as published before schema_version existed; they must keep decoding
+	data, err := os.ReadFile("testdata/v1_room_message.json")
+	require.NoError(t, err)
+	msg, err := decodeNATSMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, NATSMessage{
+		SchemaVersion: CurrentSchemaVersion,
+		MessageID:     "1718000000000000000-room_message",
+		Content:       []byte("[lobby] alice: hi"),
+		Type:          types.MsgTypeRoomMessage,
+		SenderID:      "3f8a2c1e-5b7d-4e9a-8c6f-1d2e3f4a5b6c",
+		SenderName:    "alice",
+		RoomName:      "lobby",
+		ServerID:      "server-a",
+		Timestamp:     time.Date(2024, 6, 10, 6, 13, 20, 0, time.UTC),
+	}, msg)
+
+	// Missing fields get defaults
+	data, err = os.ReadFile("testdata/v1_private_message.json")
+	require.NoError(t, err)
+	msg, err = decodeNATSMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("[PM from alice] hey"), msg.Content)
+	assert.Empty(t, msg.RoomName)
+	assert.WithinDuration(t, time.Now(), msg.Timestamp, time.Minute)
+}
+
+func TestDecodeSchemaVersions(t *testing.T) {
+	current := NATSMessage{SchemaVersion: CurrentSchemaVersion, MessageID: "m1", Type: types.MsgTypeRoomMessage, Timestamp: time.Now().UTC()}
+	data, err := json.Marshal(current)
+	require.NoError(t, err)
+	msg, err := decodeNATSMessage(data)
+	require.NoError(t, err)
+	assert.Equal(t, current, msg)
+
+	explicitV1 := `{"schema_version":1,"message_id":"m2","type":"room_message","timestamp":"2024-06-10T06:13:20Z"}`
+	msg, err = decodeNATSMessage([]byte(explicitV1))
+	require.NoError(t, err)
+	assert.Equal(t, CurrentSchemaVersion, msg.SchemaVersion)
+
+	for _, version := range []int{CurrentSchemaVersion + 1, -1} {
+		_, err = decodeNATSMessage([]byte(fmt.Sprintf(`{"schema_version":%d,"message_id":"m3"}`, version)))
+		assert.ErrorIs(t, err, ErrUnsupportedSchemaVersion)
+	}
+}
+
+func TestSubscribeRejectsFutureSchemaVersion(t *testing.T) {
+	srv := startServer(t, -1)
+	c := newTestClient(t, srv.ClientURL())
+
+	received := make(chan types.Message, 10)
+	_, err := c.Subscribe("test.schema", receive(received))
+	require.NoError(t, err)
+
+	// A newer server's payload is dropped and counted; the next message still arrives
+	future := fmt.Sprintf(`{"schema_version":%d,"message_id":"future","content":"aGk=","type":"room_message"}`, CurrentSchemaVersion+1)
+	require.NoError(t, c.GetConn().Publish("test.schema", []byte(future)))
+	require.NoError(t, c.Publish("test.schema", types.Message{Type: types.MsgTypeRoomMessage, Content: []byte("current")}))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, []byte("current"), msg.Content)
+	case <-time.After(2 * time.Second):
+		t.Fatal("current message not delivered")
+	}
+	assert.Empty(t, received)
+	assert.Equal(t, uint64(1), c.Stat().Rejected)
+}
@@ -0,0 +1,78 @@
+package nats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"websocket-demo/internal/types"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// SchemaVersionV1 is the original NATSMessage layout, published without a
+	// schema_version field
+	SchemaVersionV1 = 1
+	// CurrentSchemaVersion is the NATSMessage layout this server publishes
+	CurrentSchemaVersion = 2
+)
+
+// ErrUnsupportedSchemaVersion is returned for messages published by a newer
+// server whose layout this one does not know
+var ErrUnsupportedSchemaVersion = errors.New("unsupported NATS message schema version")
+
+// decodeNATSMessage unmarshals a NATSMessage of the current or previous
+// schema version, upgrading older payloads to the current layout. Messages
+// from future versions are rejected rather than guessed at.
+func decodeNATSMessage(data []byte) (NATSMessage, error) {
+	var natsMsg NATSMessage
+	if err := json.Unmarshal(data, &natsMsg); err != nil {
+		return NATSMessage{}, err
+	}
+
+	switch natsMsg.SchemaVersion {
+	case 0, SchemaVersionV1:
+		upgradeV1(&natsMsg)
+	case CurrentSchemaVersion:
+	default:
+		return NATSMessage{}, fmt.Errorf("%w: %d", ErrUnsupportedSchemaVersion, natsMsg.SchemaVersion)
+	}
+	return natsMsg, nil
+}
+
+// upgradeV1 fills the fields a v1 publisher may have left out
+func upgradeV1(m *NATSMessage) {
+	m.SchemaVersion = CurrentSchemaVersion
+	if m.Timestamp.IsZero() {
+		m.Timestamp = time.Now()
+	}
+}
+
+// decodeMessage unmarshals a NATS message, taking the room name from the
+// subject when the publisher left it out
+func (c *Client) decodeMessage(m *nats.Msg) (types.Message, error) {
+	natsMsg, err := c.decodeNATSMessage(m.Data)
+	if err != nil {
+		return types.Message{}, err
+	}
+	if natsMsg.RoomName == "" {
+		if roomName, ok := RoomNameFromSubject(m.Subject); ok {
+			natsMsg.RoomName = roomName
+		}
+	}
+	return natsMsg.toMessage(), nil
+}
+
+// decodeNATSMessage decodes data, counting and logging messages rejected
+// for their schema version. Other errors are left to the caller to log.
+func (c *Client) decodeNATSMessage(data []byte) (NATSMessage, error) {
+	natsMsg, err := decodeNATSMessage(data)
+	if errors.Is(err, ErrUnsupportedSchemaVersion) {
+		c.rejectedMessages.Add(1)
+		log.Printf("WARNING: dropping NATS message from a newer server: %v", err)
+	}
+	return natsMsg, err
+}
@@ -0,0 +1,73 @@
+package nats
+
+import (
+	"context"
+
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/types"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span names for relayed messages
+const (
+	spanPublish = "nats.publish"
+	spanReceive = "nats.receive"
+)
+
+// messagingSystem identifies NATS in span attributes
+var messagingSystem = semconv.MessagingSystemKey.String("nats")
+
+// startPublishSpan starts a producer span for a message sent under a trace
+// and stores its context in natsMsg for the receiving server. Messages sent
+// outside a trace, such as heartbeats, get a no-op span.
+func startPublishSpan(subject string, natsMsg *NATSMessage, carrier map[string]string) trace.Span {
+	ctx := tracing.Extract(context.Background(), carrier)
+	if !tracing.Traced(ctx) {
+		return trace.SpanFromContext(context.Background())
+	}
+	ctx, span := tracing.Tracer().Start(ctx, spanPublish,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			messagingSystem,
+			semconv.MessagingOperationTypeSend,
+			semconv.MessagingDestinationName(subject),
+			semconv.MessagingMessageID(natsMsg.MessageID),
+		),
+	)
+	natsMsg.TraceContext = tracing.Inject(ctx)
+	return span
+}
+
+// startReceiveSpan starts a consumer span continuing the trace of a received
+// message and points msg's trace context at it, so the handler's spans nest
+// under it. Messages without a trace, and this server's own messages coming
+// back, get a no-op span.
+func (c *Client) startReceiveSpan(subject string, msg *types.Message) trace.Span {
+	ctx := tracing.Extract(context.Background(), msg.TraceContext)
+	if !tracing.Traced(ctx) || msg.ServerID != "" && msg.ServerID == c.GetServerID() {
+		return trace.SpanFromContext(context.Background())
+	}
+	ctx, span := tracing.Tracer().Start(ctx, spanReceive,
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			messagingSystem,
+			semconv.MessagingOperationTypeReceive,
+			semconv.MessagingDestinationName(subject),
+			semconv.MessagingMessageID(msg.MessageID),
+		),
+	)
+	msg.TraceContext = tracing.Inject(ctx)
+	return span
+}
+
+// endSpan records err, if any, on span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,240 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// snapshot is the JSON file a Store is saved to. Rows are sorted so saving
+// unchanged data writes the same bytes.
+type snapshot struct {
+	Users    []db.User                   `json:"users"`
+	Rooms    []db.Room                   `json:"rooms"`
+	Members  []snapshotMember            `json:"members"`
+	Messages []db.Message                `json:"messages"`
+	Pins     []db.PinnedMessage          `json:"pins"`
+	Polls    []db.Poll                   `json:"polls"`
+	Votes    []db.PollVote               `json:"votes"`
+	Flagged  []db.FlaggedMessage         `json:"flagged"`
+	Samples  []db.StatsSample            `json:"samples"`
+	Outbox   []db.MessageOutbox          `json:"outbox"`
+	OutboxID int64                       `json:"outbox_id"`
+	Invites  []db.RoomInvite             `json:"invites"`
+	Tokens   []db.EmailVerificationToken `json:"email_verification_tokens"`
+}
+
+// snapshotMember is a room membership in a snapshot
+type snapshotMember struct {
+	RoomID     pgtype.UUID `json:"room_id"`
+	UserID     pgtype.UUID `json:"user_id"`
+	JoinedAt   time.Time   `json:"joined_at"`
+	LastReadAt time.Time   `json:"last_read_at"`
+	Role       string      `json:"role,omitempty"` // Missing from snapshots saved before roles existed
+}
+
+// Open returns a store saved to path, loaded with the file's contents if it
+// exists. An empty path keeps everything in memory.
+func Open(path string) (*Store, error) {
+	s := New()
+	s.path = path
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	s.restore(snap)
+	s.lastSaved = data
+	return s, nil
+}
+
+// restore replaces the store's contents with snap
+func (s *Store) restore(snap snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range snap.Users {
+		s.users[u.ID] = u
+	}
+	for _, r := range snap.Rooms {
+		s.rooms[r.ID] = r
+	}
+	for _, m := range snap.Members {
+		if s.members[m.RoomID] == nil {
+			s.members[m.RoomID] = make(map[pgtype.UUID]member)
+		}
+		role := m.Role
+		if role == "" {
+			role = repository.RoomRoleMember
+		}
+		s.members[m.RoomID][m.UserID] = member{joinedAt: m.JoinedAt, lastReadAt: m.LastReadAt, role: role}
+	}
+	s.messages = snap.Messages
+	for _, p := range snap.Pins {
+		s.pins[p.RoomID] = append(s.pins[p.RoomID], p)
+	}
+	for _, p := range snap.Polls {
+		s.polls[p.ID] = p
+	}
+	for _, v := range snap.Votes {
+		if s.votes[v.PollID] == nil {
+			s.votes[v.PollID] = make(map[pgtype.UUID]int32)
+		}
+		s.votes[v.PollID][v.UserID] = v.OptionIndex
+	}
+	s.flagged = snap.Flagged
+	s.samples = snap.Samples
+	s.outbox = snap.Outbox
+	s.outboxID = snap.OutboxID
+	s.invites = snap.Invites
+	for _, t := range snap.Tokens {
+		s.tokens[t.Token] = t
+	}
+}
+
+// snapshot copies the store's contents
+func (s *Store) snapshot() snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := snapshot{
+		Messages: append([]db.Message(nil), s.messages...),
+		Flagged:  append([]db.FlaggedMessage(nil), s.flagged...),
+		Samples:  append([]db.StatsSample(nil), s.samples...),
+		Outbox:   append([]db.MessageOutbox(nil), s.outbox...),
+		OutboxID: s.outboxID,
+		Invites:  append([]db.RoomInvite(nil), s.invites...),
+	}
+	for _, u := range s.users {
+		snap.Users = append(snap.Users, u)
+	}
+	sortByID(snap.Users, func(u db.User) pgtype.UUID { return u.ID })
+	for _, r := range s.rooms {
+		snap.Rooms = append(snap.Rooms, r)
+	}
+	sortByID(snap.Rooms, func(r db.Room) pgtype.UUID { return r.ID })
+	for roomID, members := range s.members {
+		for userID, m := range members {
+			snap.Members = append(snap.Members, snapshotMember{RoomID: roomID, UserID: userID, JoinedAt: m.joinedAt, LastReadAt: m.lastReadAt, Role: m.role})
+		}
+	}
+	sort.Slice(snap.Members, func(i, j int) bool {
+		a, b := snap.Members[i], snap.Members[j]
+		if c := bytes.Compare(a.RoomID.Bytes[:], b.RoomID.Bytes[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(a.UserID.Bytes[:], b.UserID.Bytes[:]) < 0
+	})
+	for _, pins := range s.pins {
+		snap.Pins = append(snap.Pins, pins...)
+	}
+	// Pins keep their pin order within each room
+	sort.SliceStable(snap.Pins, func(i, j int) bool {
+		return bytes.Compare(snap.Pins[i].RoomID.Bytes[:], snap.Pins[j].RoomID.Bytes[:]) < 0
+	})
+	for _, p := range s.polls {
+		snap.Polls = append(snap.Polls, p)
+	}
+	sortByID(snap.Polls, func(p db.Poll) pgtype.UUID { return p.ID })
+	for pollID, votes := range s.votes {
+		for userID, option := range votes {
+			snap.Votes = append(snap.Votes, db.PollVote{PollID: pollID, UserID: userID, OptionIndex: option})
+		}
+	}
+	sort.Slice(snap.Votes, func(i, j int) bool {
+		a, b := snap.Votes[i], snap.Votes[j]
+		if c := bytes.Compare(a.PollID.Bytes[:], b.PollID.Bytes[:]); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(a.UserID.Bytes[:], b.UserID.Bytes[:]) < 0
+	})
+	for _, t := range s.tokens {
+		snap.Tokens = append(snap.Tokens, t)
+	}
+	sort.Slice(snap.Tokens, func(i, j int) bool { return snap.Tokens[i].Token < snap.Tokens[j].Token })
+	return snap
+}
+
+// sortByID sorts rows by the ID id returns
+func sortByID[T any](rows []T, id func(T) pgtype.UUID) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := id(rows[i]), id(rows[j])
+		return bytes.Compare(a.Bytes[:], b.Bytes[:]) < 0
+	})
+}
+
+// Save writes the store to its file, replacing it atomically. Nothing is
+// written when the store has no file or hasn't changed since the last save.
+func (s *Store) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	data, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return fmt.Errorf("failed to encode store: %w", err)
+	}
+	if bytes.Equal(data, s.lastSaved) {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to save store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to save store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to save store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to save store: %w", err)
+	}
+	s.lastSaved = data
+	return nil
+}
+
+// SaveEvery saves the store every interval until ctx is done. Callers save
+// once more after their last write, e.g. when shutdown has finished.
+func (s *Store) SaveEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.Printf("Failed to save in-memory store: %v", err)
+			}
+		}
+	}
+}
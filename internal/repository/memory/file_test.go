@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreSaveAndOpen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "chatx.json")
+
+	s, err := Open(path)
+	require.NoError(t, err)
+	alice, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	lounge, err := s.CreateRoomWithCreator(ctx, "lounge", pgtype.Bool{Bool: true, Valid: true}, pgtype.Text{String: "secret", Valid: true}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	require.NoError(t, s.AddRoomMember(ctx, lounge.ID, bob.ID, repository.RoomRoleMember))
+	require.NoError(t, s.SetRoomMemberRole(ctx, lounge.ID, bob.ID, repository.RoomRoleModerator))
+	msg, err := s.CreateMessage(ctx, lounge.ID, alice.ID, "hello")
+	require.NoError(t, err)
+	_, err = s.CreateReplyMessage(ctx, lounge.ID, bob.ID, msg.ID, "hi alice")
+	require.NoError(t, err)
+	require.NoError(t, s.PinMessage(ctx, lounge.ID, msg.ID, alice.ID))
+	poll, err := s.CreatePoll(ctx, lounge.ID, alice.ID, "Lunch?", []string{"yes", "no"}, timestamp(time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	require.NoError(t, s.UpsertPollVote(ctx, poll.ID, bob.ID, 1))
+	require.NoError(t, s.CreateFlaggedMessage(ctx, lounge.ID, bob.ID, "bob", "darn", []string{"darn"}))
+	require.NoError(t, s.CreateStatsSample(ctx, "server-1", 3))
+	_, err = s.CreateMessageWithOutbox(ctx, db.CreateMessageParams{RoomID: lounge.ID, UserID: bob.ID, Content: "relayed"}, "chat.room.lounge",
+		func(db.Message) ([]byte, error) { return []byte(`{"relayed":true}`), nil })
+	require.NoError(t, err)
+
+	require.NoError(t, s.Save())
+	saved, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	reopened, err := Open(path)
+	require.NoError(t, err)
+	again, err := json.Marshal(reopened.snapshot())
+	require.NoError(t, err)
+	assert.JSONEq(t, string(saved), string(again), "everything survives a restart")
+
+	members, err := reopened.GetRoomMemberCount(ctx, lounge.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), members)
+	role, err := reopened.GetRoomMemberRole(ctx, lounge.ID, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, repository.RoomRoleModerator, role)
+	recent, err := reopened.ListRecentMessagesByRoom(ctx, lounge.ID, 10)
+	require.NoError(t, err)
+	assert.Len(t, recent, 3)
+	counts, err := reopened.GetPollVoteCounts(ctx, poll.ID)
+	require.NoError(t, err)
+	require.Len(t, counts, 1)
+	assert.Equal(t, int32(1), counts[0].OptionIndex)
+	_, err = reopened.CreateUser(ctx, "alice", "other@example.com", "hash")
+	assert.Error(t, err, "constraints still apply to loaded rows")
+
+	// Saving unchanged data leaves the file alone
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, reopened.Save())
+	assert.NoFileExists(t, path)
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	assert.Empty(t, s.Messages(), "a missing file starts empty")
+
+	memoryOnly, err := Open("")
+	require.NoError(t, err)
+	assert.NoError(t, memoryOnly.Save())
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	require.NoError(t, os.WriteFile(corrupt, []byte("{not json"), 0o600))
+	_, err = Open(corrupt)
+	assert.Error(t, err)
+}
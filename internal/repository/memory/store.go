@@ -0,0 +1,1548 @@
+// Package memory provides an in-memory repository.Store for development and
+// tests, selected with STORAGE=memory. Data can be kept across restarts in a
+// JSON file; see Open.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/validator"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint violation
+const uniqueViolation = "23505"
+
+// Store is an in-memory repository.Store. It follows the schema's
+// constraints and cascades closely enough to stand in for Postgres: names and
+// emails are unique, missing rows return pgx.ErrNoRows, and deleting a user
+// or room removes what the foreign keys would.
+type Store struct {
+	mu       sync.Mutex
+	users    map[pgtype.UUID]db.User
+	rooms    map[pgtype.UUID]db.Room
+	messages []db.Message // Insertion order
+	members  map[pgtype.UUID]map[pgtype.UUID]member
+	pins     map[pgtype.UUID][]db.PinnedMessage // Pin order
+	polls    map[pgtype.UUID]db.Poll
+	votes    map[pgtype.UUID]map[pgtype.UUID]int32
+	flagged  []db.FlaggedMessage // Flag order
+	samples  []db.StatsSample
+	outbox   []db.MessageOutbox
+	outboxID int64
+	invites  []db.RoomInvite // Invite order
+	tokens   map[string]db.EmailVerificationToken
+
+	// JSON file the store is saved to; empty keeps everything in memory
+	path      string
+	saveMu    sync.Mutex // Serializes Save
+	lastSaved []byte     // Contents last written to path
+}
+
+var _ repository.Store = (*Store)(nil)
+
+// member is a room membership
+type member struct {
+	joinedAt   time.Time
+	lastReadAt time.Time
+	role       string
+}
+
+// New returns an empty store
+func New() *Store {
+	return &Store{
+		users:   make(map[pgtype.UUID]db.User),
+		rooms:   make(map[pgtype.UUID]db.Room),
+		members: make(map[pgtype.UUID]map[pgtype.UUID]member),
+		pins:    make(map[pgtype.UUID][]db.PinnedMessage),
+		polls:   make(map[pgtype.UUID]db.Poll),
+		votes:   make(map[pgtype.UUID]map[pgtype.UUID]int32),
+		tokens:  make(map[string]db.EmailVerificationToken),
+	}
+}
+
+func newID() pgtype.UUID {
+	return pgtype.UUID{Bytes: uuid.New(), Valid: true}
+}
+
+func timestamp(t time.Time) pgtype.Timestamptz {
+	return pgtype.Timestamptz{Time: t, Valid: true}
+}
+
+// Messages returns every stored message in insertion order
+func (s *Store) Messages() []db.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]db.Message(nil), s.messages...)
+}
+
+// Outbox returns every outbox entry in insertion order
+func (s *Store) Outbox() []db.MessageOutbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]db.MessageOutbox(nil), s.outbox...)
+}
+
+// User operations
+
+func (s *Store) CreateUser(ctx context.Context, username, email, passwordHash string) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	skeleton := validator.Skeleton(username)
+	for _, u := range s.users {
+		// Compare skeletons so names differing in case or by look-alike
+		// letters collide, as with the users table's unique index
+		if validator.Skeleton(u.Username) == skeleton {
+			return db.User{}, repository.ErrUsernameTaken
+		}
+		if u.Email == email {
+			return db.User{}, &pgconn.PgError{Code: uniqueViolation, Message: "duplicate user"}
+		}
+	}
+	now := timestamp(time.Now())
+	user := db.User{ID: newID(), Username: username, Email: email, PasswordHash: passwordHash, CreatedAt: now, UpdatedAt: now, UsernameSkeleton: skeleton}
+	s.users[user.ID] = user
+	return user, nil
+}
+
+func (s *Store) GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return db.User{}, pgx.ErrNoRows
+	}
+	return user, nil
+}
+
+func (s *Store) GetUserByUsername(ctx context.Context, username string) (db.User, error) {
+	return s.findUser(func(u db.User) bool { return strings.ToLower(u.Username) == strings.ToLower(username) })
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (db.User, error) {
+	return s.findUser(func(u db.User) bool { return u.Email == email })
+}
+
+// SearchUsers returns up to limit users whose name contains query, ignoring
+// case, in name order
+func (s *Store) SearchUsers(ctx context.Context, query string, limit int) ([]db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	query = strings.ToLower(query)
+	var users []db.User
+	for _, u := range s.users {
+		if strings.Contains(strings.ToLower(u.Username), query) {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (s *Store) findUser(match func(db.User) bool) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if match(u) {
+			return u, nil
+		}
+	}
+	return db.User{}, pgx.ErrNoRows
+}
+
+func (s *Store) UpdateUserLastLogin(ctx context.Context, id pgtype.UUID, lastLogin pgtype.Timestamptz) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return db.User{}, pgx.ErrNoRows
+	}
+	user.LastLogin = lastLogin
+	s.users[id] = user
+	return user, nil
+}
+
+func (s *Store) UpdateUserPassword(ctx context.Context, id pgtype.UUID, passwordHash string) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return db.User{}, pgx.ErrNoRows
+	}
+	user.PasswordHash = passwordHash
+	user.UpdatedAt = timestamp(time.Now())
+	s.users[id] = user
+	return user, nil
+}
+
+// DeleteUser removes a user with their messages, memberships and votes, and
+// clears them as creator of rooms and polls and as pinner of pins
+func (s *Store) DeleteUser(ctx context.Context, id pgtype.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return false, nil
+	}
+	delete(s.users, id)
+
+	s.deleteMessagesLocked(func(m db.Message) bool { return m.UserID == id })
+	for _, members := range s.members {
+		delete(members, id)
+	}
+	for _, votes := range s.votes {
+		delete(votes, id)
+	}
+	for roomID, room := range s.rooms {
+		if room.CreatorID == id {
+			room.CreatorID = pgtype.UUID{}
+			s.rooms[roomID] = room
+		}
+	}
+	for pollID, poll := range s.polls {
+		if poll.CreatorID == id {
+			poll.CreatorID = pgtype.UUID{}
+			s.polls[pollID] = poll
+		}
+	}
+	for _, pins := range s.pins {
+		for i := range pins {
+			if pins[i].PinnedBy == id {
+				pins[i].PinnedBy = pgtype.UUID{}
+			}
+		}
+	}
+	for i := range s.flagged {
+		if s.flagged[i].UserID == id {
+			s.flagged[i].UserID = pgtype.UUID{}
+		}
+	}
+	s.deleteInvitesLocked(func(inv db.RoomInvite) bool { return inv.InviterID == id || inv.InviteeID == id })
+	for token, t := range s.tokens {
+		if t.UserID == id {
+			delete(s.tokens, token)
+		}
+	}
+	return true, nil
+}
+
+// CreateEmailVerificationToken stores a token that verifies the user's email
+// until expiresAt
+func (s *Store) CreateEmailVerificationToken(ctx context.Context, userID pgtype.UUID, token string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[userID]; !ok {
+		return &pgconn.PgError{Code: "23503", Message: "user does not exist"}
+	}
+	if _, ok := s.tokens[token]; ok {
+		return &pgconn.PgError{Code: uniqueViolation, Message: "duplicate verification token"}
+	}
+	s.tokens[token] = db.EmailVerificationToken{Token: token, UserID: userID, ExpiresAt: timestamp(expiresAt)}
+	return nil
+}
+
+// VerifyEmail marks the email of the token's user verified and deletes the
+// token, returning repository.ErrVerificationTokenInvalid for an unknown or
+// expired token
+func (s *Store) VerifyEmail(ctx context.Context, token string) (db.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[token]
+	if !ok {
+		return db.User{}, repository.ErrVerificationTokenInvalid
+	}
+	delete(s.tokens, token)
+	user, ok := s.users[t.UserID]
+	if !ok || !t.ExpiresAt.Time.After(time.Now()) {
+		return db.User{}, repository.ErrVerificationTokenInvalid
+	}
+	user.EmailVerified = true
+	s.users[user.ID] = user
+	return user, nil
+}
+
+// Room operations
+
+func (s *Store) CreateRoom(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rooms {
+		if r.Name == name && !r.DeletedAt.Valid {
+			return db.Room{}, repository.ErrRoomExists
+		}
+	}
+	room := db.Room{
+		ID:                newID(),
+		Name:              name,
+		Private:           private,
+		PasswordHash:      passwordHash,
+		CreatorID:         creatorID,
+		CreatedAt:         timestamp(time.Now()),
+		SuppressJoinLeave: suppressJoinLeave,
+		MaxClients:        maxClients,
+	}
+	s.rooms[room.ID] = room
+	return room, nil
+}
+
+// CreateRoomWithCreator inserts the room and its creator's membership, or
+// neither if the creator doesn't exist
+func (s *Store) CreateRoomWithCreator(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error) {
+	s.mu.Lock()
+	_, creatorExists := s.users[creatorID]
+	s.mu.Unlock()
+	if !creatorExists {
+		return db.Room{}, &pgconn.PgError{Code: "23503", Message: "user does not exist"}
+	}
+	room, err := s.CreateRoom(ctx, name, private, passwordHash, creatorID, suppressJoinLeave, maxClients)
+	if err != nil {
+		return db.Room{}, err
+	}
+	return room, s.AddRoomMember(ctx, room.ID, creatorID, repository.RoomRoleMember)
+}
+
+// GetRoomByID returns a room that isn't deleted
+func (s *Store) GetRoomByID(ctx context.Context, id pgtype.UUID) (db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, exists := s.rooms[id]; exists && !r.DeletedAt.Valid {
+		return r, nil
+	}
+	return db.Room{}, pgx.ErrNoRows
+}
+
+func (s *Store) GetRoomByName(ctx context.Context, name string) (db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rooms {
+		if r.Name == name && !r.DeletedAt.Valid {
+			return r, nil
+		}
+	}
+	return db.Room{}, pgx.ErrNoRows
+}
+
+// GetAllRooms returns the rooms that aren't deleted, newest first
+func (s *Store) GetAllRooms(ctx context.Context) ([]db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rooms := make([]db.Room, 0, len(s.rooms))
+	for _, r := range s.rooms {
+		if !r.DeletedAt.Valid {
+			rooms = append(rooms, r)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].CreatedAt.Time.After(rooms[j].CreatedAt.Time) })
+	return rooms, nil
+}
+
+// GetRoomsByNames returns the rooms with any of the given names that aren't deleted
+func (s *Store) GetRoomsByNames(ctx context.Context, names []string) ([]db.Room, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rooms []db.Room
+	for _, r := range s.rooms {
+		if wanted[r.Name] && !r.DeletedAt.Valid {
+			rooms = append(rooms, r)
+		}
+	}
+	return rooms, nil
+}
+
+// CountRooms counts the rooms not deleted, leaving out the room named excludedName
+func (s *Store) CountRooms(ctx context.Context, excludedName string) (int64, error) {
+	return s.countRooms(func(r db.Room) bool { return r.Name != excludedName }), nil
+}
+
+// CountRoomsByCreator counts the rooms not deleted that creatorID created
+func (s *Store) CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error) {
+	return s.countRooms(func(r db.Room) bool { return r.CreatorID == creatorID }), nil
+}
+
+func (s *Store) countRooms(match func(db.Room) bool) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	for _, r := range s.rooms {
+		if !r.DeletedAt.Valid && match(r) {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Store) UpdateRoomSuppressJoinLeave(ctx context.Context, id pgtype.UUID, suppress bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room, ok := s.rooms[id]; ok {
+		room.SuppressJoinLeave = suppress
+		s.rooms[id] = room
+	}
+	return nil
+}
+
+func (s *Store) UpdateRoomRetentionDays(ctx context.Context, id pgtype.UUID, days pgtype.Int4) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room, ok := s.rooms[id]; ok {
+		room.RetentionDays = days
+		s.rooms[id] = room
+	}
+	return nil
+}
+
+func (s *Store) UpdateRoomPassword(ctx context.Context, id pgtype.UUID, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if room, ok := s.rooms[id]; ok {
+		room.PasswordHash = pgtype.Text{String: passwordHash, Valid: true}
+		s.rooms[id] = room
+	}
+	return nil
+}
+
+// DeleteRoom permanently removes a room with its messages, members, pins and polls
+func (s *Store) DeleteRoom(ctx context.Context, id pgtype.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteRoomLocked(id)
+	return nil
+}
+
+// SoftDeleteRoom marks a room deleted, keeping everything else
+func (s *Store) SoftDeleteRoom(ctx context.Context, id pgtype.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[id]
+	if !ok || room.DeletedAt.Valid {
+		return false, nil
+	}
+	room.DeletedAt = timestamp(time.Now())
+	s.rooms[id] = room
+	return true, nil
+}
+
+// GetDeletedRoomByName returns the most recently deleted room with the name
+func (s *Store) GetDeletedRoomByName(ctx context.Context, name string) (db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found db.Room
+	for _, r := range s.rooms {
+		if r.Name == name && r.DeletedAt.Valid && (!found.ID.Valid || r.DeletedAt.Time.After(found.DeletedAt.Time)) {
+			found = r
+		}
+	}
+	if !found.ID.Valid {
+		return db.Room{}, pgx.ErrNoRows
+	}
+	return found, nil
+}
+
+// ListDeletedRooms returns the deleted rooms, most recently deleted first
+func (s *Store) ListDeletedRooms(ctx context.Context) ([]db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rooms []db.Room
+	for _, r := range s.rooms {
+		if r.DeletedAt.Valid {
+			rooms = append(rooms, r)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].DeletedAt.Time.After(rooms[j].DeletedAt.Time) })
+	return rooms, nil
+}
+
+// RestoreRoom clears a room's deletion, failing like the unique index would
+// when a live room has the same name
+func (s *Store) RestoreRoom(ctx context.Context, id pgtype.UUID) (db.Room, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	room, ok := s.rooms[id]
+	if !ok || !room.DeletedAt.Valid {
+		return db.Room{}, pgx.ErrNoRows
+	}
+	for _, r := range s.rooms {
+		if r.Name == room.Name && !r.DeletedAt.Valid {
+			return db.Room{}, repository.ErrRoomExists
+		}
+	}
+	room.DeletedAt = pgtype.Timestamptz{}
+	s.rooms[id] = room
+	return room, nil
+}
+
+// PurgeDeletedRooms permanently removes rooms deleted before cutoff
+func (s *Store) PurgeDeletedRooms(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var purged int64
+	for id, r := range s.rooms {
+		if r.DeletedAt.Valid && r.DeletedAt.Time.Before(cutoff) {
+			s.deleteRoomLocked(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// deleteRoomLocked removes a room and what its foreign keys cascade to;
+// callers must hold s.mu
+func (s *Store) deleteRoomLocked(id pgtype.UUID) {
+	delete(s.rooms, id)
+	delete(s.members, id)
+	delete(s.pins, id)
+	s.deleteMessagesLocked(func(m db.Message) bool { return m.RoomID == id })
+	for pollID, poll := range s.polls {
+		if poll.RoomID == id {
+			delete(s.polls, pollID)
+			delete(s.votes, pollID)
+		}
+	}
+	flagged := s.flagged[:0]
+	for _, flag := range s.flagged {
+		if flag.RoomID != id {
+			flagged = append(flagged, flag)
+		}
+	}
+	s.flagged = flagged
+	s.deleteInvitesLocked(func(inv db.RoomInvite) bool { return inv.RoomID == id })
+}
+
+// Room member operations
+
+// AddRoomMember adds a member with role, or refreshes the join time of an
+// existing one, who keeps their current role
+func (s *Store) AddRoomMember(ctx context.Context, roomID, userID pgtype.UUID, role string) error {
+	if !repository.ValidRoomRole(role) {
+		return repository.ErrInvalidRoomRole
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return &pgconn.PgError{Code: "23503", Message: "room does not exist"}
+	}
+	if _, ok := s.users[userID]; !ok {
+		return &pgconn.PgError{Code: "23503", Message: "user does not exist"}
+	}
+	if s.members[roomID] == nil {
+		s.members[roomID] = make(map[pgtype.UUID]member)
+	}
+	if existing, ok := s.members[roomID][userID]; ok {
+		role = existing.role
+	}
+	now := time.Now()
+	s.members[roomID][userID] = member{joinedAt: now, lastReadAt: now, role: role}
+	return nil
+}
+
+// SetRoomMemberRole changes a member's role
+func (s *Store) SetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID, role string) error {
+	if !repository.ValidRoomRole(role) {
+		return repository.ErrInvalidRoomRole
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[roomID][userID]
+	if !ok {
+		return pgx.ErrNoRows
+	}
+	m.role = role
+	s.members[roomID][userID] = m
+	return nil
+}
+
+// GetRoomMemberRole returns a member's role
+func (s *Store) GetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.members[roomID][userID]
+	if !ok {
+		return "", pgx.ErrNoRows
+	}
+	return m.role, nil
+}
+
+func (s *Store) RemoveRoomMember(ctx context.Context, roomID, userID pgtype.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members[roomID], userID)
+	return nil
+}
+
+// GetRoomMembers returns the members in join order
+func (s *Store) GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]db.GetRoomMembersRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make([]db.GetRoomMembersRow, 0, len(s.members[roomID]))
+	for userID, m := range s.members[roomID] {
+		u := s.users[userID]
+		rows = append(rows, db.GetRoomMembersRow{
+			ID:           u.ID,
+			Username:     u.Username,
+			Email:        u.Email,
+			PasswordHash: u.PasswordHash,
+			CreatedAt:    u.CreatedAt,
+			UpdatedAt:    u.UpdatedAt,
+			LastLogin:    u.LastLogin,
+			JoinedAt:     timestamp(m.joinedAt),
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].JoinedAt.Time.Before(rows[j].JoinedAt.Time) })
+	return rows, nil
+}
+
+// GetRoomMembersWithRoles returns the members in join order with their roles
+func (s *Store) GetRoomMembersWithRoles(ctx context.Context, roomID pgtype.UUID) ([]db.GetRoomMembersWithRolesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make([]db.GetRoomMembersWithRolesRow, 0, len(s.members[roomID]))
+	for userID, m := range s.members[roomID] {
+		u := s.users[userID]
+		rows = append(rows, db.GetRoomMembersWithRolesRow{
+			ID:           u.ID,
+			Username:     u.Username,
+			Email:        u.Email,
+			PasswordHash: u.PasswordHash,
+			CreatedAt:    u.CreatedAt,
+			UpdatedAt:    u.UpdatedAt,
+			LastLogin:    u.LastLogin,
+			JoinedAt:     timestamp(m.joinedAt),
+			Role:         m.role,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].JoinedAt.Time.Before(rows[j].JoinedAt.Time) })
+	return rows, nil
+}
+
+func (s *Store) GetRoomMemberCount(ctx context.Context, roomID pgtype.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.members[roomID])), nil
+}
+
+// GetRoomMemberCounts returns the member counts of the given rooms, leaving
+// out rooms without members
+func (s *Store) GetRoomMemberCounts(ctx context.Context, roomIDs []pgtype.UUID) ([]db.GetRoomMemberCountsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []db.GetRoomMemberCountsRow
+	for _, id := range roomIDs {
+		if count := len(s.members[id]); count > 0 {
+			rows = append(rows, db.GetRoomMemberCountsRow{RoomID: id, Count: int64(count)})
+		}
+	}
+	return rows, nil
+}
+
+// MarkRoomRead records that the user has caught up with the room
+func (s *Store) MarkRoomRead(ctx context.Context, roomID, userID pgtype.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.members[roomID][userID]; ok {
+		m.lastReadAt = time.Now()
+		s.members[roomID][userID] = m
+	}
+	return nil
+}
+
+// CreateRoomInvite records an invite, replacing any earlier invite of the
+// invitee to the room
+func (s *Store) CreateRoomInvite(ctx context.Context, roomID, inviterID, inviteeID pgtype.UUID) (db.RoomInvite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rooms[roomID]; !ok {
+		return db.RoomInvite{}, &pgconn.PgError{Code: "23503", Message: "room does not exist"}
+	}
+	for _, userID := range []pgtype.UUID{inviterID, inviteeID} {
+		if _, ok := s.users[userID]; !ok {
+			return db.RoomInvite{}, &pgconn.PgError{Code: "23503", Message: "user does not exist"}
+		}
+	}
+	s.deleteInvitesLocked(func(inv db.RoomInvite) bool { return inv.RoomID == roomID && inv.InviteeID == inviteeID })
+	invite := db.RoomInvite{
+		ID:        newID(),
+		RoomID:    roomID,
+		InviterID: inviterID,
+		InviteeID: inviteeID,
+		CreatedAt: timestamp(time.Now()),
+	}
+	s.invites = append(s.invites, invite)
+	return invite, nil
+}
+
+// deleteInvitesLocked removes the invites match selects; callers must hold s.mu
+func (s *Store) deleteInvitesLocked(match func(db.RoomInvite) bool) {
+	invites := s.invites[:0]
+	for _, inv := range s.invites {
+		if !match(inv) {
+			invites = append(invites, inv)
+		}
+	}
+	s.invites = invites
+}
+
+// ListUserRoomSummaries returns the user's rooms, most recently active first
+func (s *Store) ListUserRoomSummaries(ctx context.Context, userID pgtype.UUID) ([]db.ListUserRoomSummariesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []db.ListUserRoomSummariesRow
+	activity := make(map[pgtype.UUID]time.Time)
+	for roomID, members := range s.members {
+		m, ok := members[userID]
+		if !ok {
+			continue
+		}
+		r := s.rooms[roomID]
+		if r.DeletedAt.Valid {
+			continue
+		}
+		row := db.ListUserRoomSummariesRow{
+			ID:          r.ID,
+			Name:        r.Name,
+			Private:     r.Private,
+			LastReadAt:  timestamp(m.lastReadAt),
+			MemberCount: int64(len(members)),
+		}
+		activity[roomID] = m.joinedAt
+		for _, msg := range s.messages {
+			if msg.RoomID != roomID {
+				continue
+			}
+			if msg.CreatedAt.Time.After(m.lastReadAt) && msg.UserID != userID {
+				row.UnreadCount++
+			}
+			if !row.LastMessageAt.Valid || !msg.CreatedAt.Time.Before(row.LastMessageAt.Time) {
+				row.LastMessageID = msg.ID
+				row.LastMessageContent = pgtype.Text{String: msg.Content, Valid: true}
+				row.LastMessageAt = msg.CreatedAt
+				row.LastMessageSender = pgtype.Text{String: s.users[msg.UserID].Username, Valid: true}
+				activity[roomID] = msg.CreatedAt.Time
+			}
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return activity[rows[i].ID].After(activity[rows[j].ID]) })
+	return rows, nil
+}
+
+// Message operations
+
+func (s *Store) CreateMessage(ctx context.Context, roomID, userID pgtype.UUID, content string) (db.Message, error) {
+	return s.createMessage(db.CreateMessageParams{RoomID: roomID, UserID: userID, Content: content})
+}
+
+func (s *Store) CreateReplyMessage(ctx context.Context, roomID, userID, parentID pgtype.UUID, content string) (db.Message, error) {
+	return s.createMessage(db.CreateMessageParams{RoomID: roomID, UserID: userID, Content: content, ParentMessageID: parentID})
+}
+
+func (s *Store) createMessage(params db.CreateMessageParams) (db.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createMessageLocked(params)
+}
+
+// createMessageLocked checks the message's foreign keys and stores it;
+// callers must hold s.mu
+func (s *Store) createMessageLocked(params db.CreateMessageParams) (db.Message, error) {
+	if _, ok := s.rooms[params.RoomID]; !ok {
+		return db.Message{}, &pgconn.PgError{Code: "23503", Message: "room does not exist"}
+	}
+	if _, ok := s.users[params.UserID]; !ok {
+		return db.Message{}, &pgconn.PgError{Code: "23503", Message: "user does not exist"}
+	}
+	msg := db.Message{
+		ID:              newID(),
+		RoomID:          params.RoomID,
+		UserID:          params.UserID,
+		Content:         params.Content,
+		CreatedAt:       timestamp(time.Now()),
+		ParentMessageID: params.ParentMessageID,
+	}
+	s.messages = append(s.messages, msg)
+	return msg, nil
+}
+
+// CreateMessageWithOutbox stores a message and its outbox entry together
+func (s *Store) CreateMessageWithOutbox(ctx context.Context, params db.CreateMessageParams, subject string, payload func(db.Message) ([]byte, error)) (db.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, err := s.createMessageLocked(params)
+	if err != nil {
+		return db.Message{}, err
+	}
+	body, err := payload(msg)
+	if err != nil {
+		s.messages = s.messages[:len(s.messages)-1]
+		return db.Message{}, err
+	}
+	s.outboxID++
+	s.outbox = append(s.outbox, db.MessageOutbox{
+		ID:            s.outboxID,
+		MessageID:     msg.ID,
+		Subject:       subject,
+		Payload:       body,
+		CreatedAt:     msg.CreatedAt,
+		NextAttemptAt: msg.CreatedAt,
+	})
+	return msg, nil
+}
+
+// BulkCreateMessages stores imported messages, filling in missing IDs and times
+func (s *Store) BulkCreateMessages(ctx context.Context, params []db.BulkCreateMessagesParams) ([]db.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	messages := make([]db.Message, len(params))
+	for i, p := range params {
+		if !p.ID.Valid {
+			p.ID = newID()
+		}
+		if !p.CreatedAt.Valid {
+			p.CreatedAt = timestamp(now)
+		}
+		messages[i] = db.Message{
+			ID:              p.ID,
+			RoomID:          p.RoomID,
+			UserID:          p.UserID,
+			Content:         p.Content,
+			CreatedAt:       p.CreatedAt,
+			ParentMessageID: p.ParentMessageID,
+		}
+	}
+	s.messages = append(s.messages, messages...)
+	return messages, nil
+}
+
+// CreateMessages stores a batch of messages, filling in missing IDs and
+// times in msgs; like the COPY it stores none of them if any refers to a
+// missing room or user
+func (s *Store) CreateMessages(ctx context.Context, msgs []repository.NewMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range msgs {
+		if _, ok := s.rooms[m.RoomID]; !ok {
+			return &pgconn.PgError{Code: "23503", Message: "room does not exist"}
+		}
+		if _, ok := s.users[m.UserID]; !ok {
+			return &pgconn.PgError{Code: "23503", Message: "user does not exist"}
+		}
+	}
+	now := time.Now()
+	for i := range msgs {
+		if !msgs[i].ID.Valid {
+			msgs[i].ID = newID()
+		}
+		if !msgs[i].CreatedAt.Valid {
+			msgs[i].CreatedAt = timestamp(now)
+		}
+		s.messages = append(s.messages, db.Message{
+			ID:              msgs[i].ID,
+			RoomID:          msgs[i].RoomID,
+			UserID:          msgs[i].UserID,
+			Content:         msgs[i].Content,
+			CreatedAt:       msgs[i].CreatedAt,
+			ParentMessageID: msgs[i].ParentMessageID,
+		})
+	}
+	return nil
+}
+
+func (s *Store) GetMessageByID(ctx context.Context, id pgtype.UUID) (db.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range s.messages {
+		if m.ID == id {
+			return m, nil
+		}
+	}
+	return db.Message{}, pgx.ErrNoRows
+}
+
+func (s *Store) UpdateMessageContent(ctx context.Context, id pgtype.UUID, content string) (db.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.messages {
+		if s.messages[i].ID == id {
+			s.messages[i].Content = content
+			return s.messages[i], nil
+		}
+	}
+	return db.Message{}, pgx.ErrNoRows
+}
+
+// DeleteMessage removes a message with its pins and outbox entries
+func (s *Store) DeleteMessage(ctx context.Context, id pgtype.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.messages)
+	s.deleteMessagesLocked(func(m db.Message) bool { return m.ID == id })
+	return len(s.messages) < before, nil
+}
+
+// DeleteExpiredMessages deletes messages older than cutoff from rooms without
+// their own retention; the fake has no other servers, so it always runs
+func (s *Store) DeleteExpiredMessages(ctx context.Context, cutoff time.Time, batchSize int32) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := len(s.messages)
+	s.deleteMessagesLocked(func(m db.Message) bool {
+		return m.CreatedAt.Time.Before(cutoff) && !s.rooms[m.RoomID].RetentionDays.Valid
+	})
+	return int64(before - len(s.messages)), true, nil
+}
+
+// CountMessagesByRoom returns how many messages a room has
+func (s *Store) CountMessagesByRoom(ctx context.Context, roomID pgtype.UUID) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var count int64
+	for _, m := range s.messages {
+		if m.RoomID == roomID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ListMessagesByRoom returns a page of a room's messages, newest first
+func (s *Store) ListMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit, offset int32) ([]db.ListMessagesByRoomRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []db.ListMessagesByRoomRow
+	for _, m := range s.newestFirstLocked(roomID, limit, offset) {
+		rows = append(rows, db.ListMessagesByRoomRow{
+			ID:              m.ID,
+			RoomID:          m.RoomID,
+			UserID:          m.UserID,
+			Content:         m.Content,
+			CreatedAt:       m.CreatedAt,
+			ParentMessageID: m.ParentMessageID,
+			Username:        s.users[m.UserID].Username,
+			RoomName:        s.rooms[m.RoomID].Name,
+		})
+	}
+	return rows, nil
+}
+
+// ListLatestMessagesByRooms returns the newest message of each room that has
+// one, leaving out private rooms viewerID isn't a member of
+func (s *Store) ListLatestMessagesByRooms(ctx context.Context, roomIDs []pgtype.UUID, viewerID pgtype.UUID) ([]db.ListLatestMessagesByRoomsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []db.ListLatestMessagesByRoomsRow
+	for _, roomID := range roomIDs {
+		room, exists := s.rooms[roomID]
+		if !exists {
+			continue
+		}
+		if _, isMember := s.members[roomID][viewerID]; room.Private.Bool && !isMember {
+			continue
+		}
+		for _, m := range s.newestFirstLocked(roomID, 1, 0) {
+			rows = append(rows, db.ListLatestMessagesByRoomsRow{
+				RoomID:    m.RoomID,
+				MessageID: m.ID,
+				Content:   m.Content,
+				CreatedAt: m.CreatedAt,
+				Username:  s.users[m.UserID].Username,
+			})
+		}
+	}
+	return rows, nil
+}
+
+// ListRecentMessagesByRoom returns a room's latest messages, newest first
+func (s *Store) ListRecentMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit int32) ([]db.ListRecentMessagesByRoomRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []db.ListRecentMessagesByRoomRow
+	for _, m := range s.newestFirstLocked(roomID, limit, 0) {
+		rows = append(rows, db.ListRecentMessagesByRoomRow{
+			ID:              m.ID,
+			RoomID:          m.RoomID,
+			UserID:          m.UserID,
+			Content:         m.Content,
+			CreatedAt:       m.CreatedAt,
+			ParentMessageID: m.ParentMessageID,
+			Username:        s.users[m.UserID].Username,
+			RoomName:        s.rooms[m.RoomID].Name,
+		})
+	}
+	return rows, nil
+}
+
+// ListRoomExportPage returns up to pageSize of a room's messages newest
+// first, before the message at (beforeCreatedAt, beforeID); a zero
+// beforeCreatedAt starts from the newest message
+func (s *Store) ListRoomExportPage(ctx context.Context, roomID pgtype.UUID, beforeCreatedAt time.Time, beforeID pgtype.UUID, pageSize int32) ([]db.ListRoomExportPageRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []db.Message
+	for _, m := range s.messages {
+		if m.RoomID != roomID {
+			continue
+		}
+		if !beforeCreatedAt.IsZero() && !messageBefore(m, beforeCreatedAt, beforeID) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messageBefore(messages[j], messages[i].CreatedAt.Time, messages[i].ID)
+	})
+	if int(pageSize) < len(messages) {
+		messages = messages[:pageSize]
+	}
+
+	rows := make([]db.ListRoomExportPageRow, 0, len(messages))
+	for _, m := range messages {
+		rows = append(rows, db.ListRoomExportPageRow{
+			ID:        m.ID,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+			Username:  s.users[m.UserID].Username,
+		})
+	}
+	return rows, nil
+}
+
+// ListRoomTranscript returns up to pageSize of a room's messages oldest
+// first, after the message at (afterCreatedAt, afterID); a zero
+// afterCreatedAt starts from the first message
+func (s *Store) ListRoomTranscript(ctx context.Context, roomID pgtype.UUID, afterCreatedAt time.Time, afterID pgtype.UUID, pageSize int32) ([]db.ListRoomTranscriptRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var messages []db.Message
+	for _, m := range s.messages {
+		if m.RoomID != roomID {
+			continue
+		}
+		if !afterCreatedAt.IsZero() && !messageAfter(m, afterCreatedAt, afterID) {
+			continue
+		}
+		messages = append(messages, m)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messageAfter(messages[j], messages[i].CreatedAt.Time, messages[i].ID)
+	})
+	if int(pageSize) < len(messages) {
+		messages = messages[:pageSize]
+	}
+
+	rows := make([]db.ListRoomTranscriptRow, 0, len(messages))
+	for _, m := range messages {
+		rows = append(rows, db.ListRoomTranscriptRow{
+			ID:        m.ID,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt,
+			Username:  s.users[m.UserID].Username,
+		})
+	}
+	return rows, nil
+}
+
+// messageAfter reports whether m sorts after (createdAt, id), ordering by
+// time and then ID as Postgres compares the row values
+func messageAfter(m db.Message, createdAt time.Time, id pgtype.UUID) bool {
+	if !m.CreatedAt.Time.Equal(createdAt) {
+		return m.CreatedAt.Time.After(createdAt)
+	}
+	return bytes.Compare(m.ID.Bytes[:], id.Bytes[:]) > 0
+}
+
+// messageBefore reports whether m sorts before (createdAt, id), the reverse
+// of messageAfter
+func messageBefore(m db.Message, createdAt time.Time, id pgtype.UUID) bool {
+	if !m.CreatedAt.Time.Equal(createdAt) {
+		return m.CreatedAt.Time.Before(createdAt)
+	}
+	return bytes.Compare(m.ID.Bytes[:], id.Bytes[:]) < 0
+}
+
+// ListThreadMessages returns up to limit replies to a message, oldest first
+func (s *Store) ListThreadMessages(ctx context.Context, parentID pgtype.UUID, limit int32) ([]db.ListThreadMessagesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var replies []db.Message
+	for _, m := range s.messages {
+		if m.ParentMessageID.Valid && m.ParentMessageID == parentID {
+			replies = append(replies, m)
+		}
+	}
+	sort.SliceStable(replies, func(i, j int) bool { return replies[i].CreatedAt.Time.Before(replies[j].CreatedAt.Time) })
+	if int(limit) < len(replies) {
+		replies = replies[:limit]
+	}
+
+	var rows []db.ListThreadMessagesRow
+	for _, m := range replies {
+		rows = append(rows, db.ListThreadMessagesRow{
+			ID:              m.ID,
+			RoomID:          m.RoomID,
+			UserID:          m.UserID,
+			Content:         m.Content,
+			CreatedAt:       m.CreatedAt,
+			ParentMessageID: m.ParentMessageID,
+			Username:        s.users[m.UserID].Username,
+		})
+	}
+	return rows, nil
+}
+
+// newestFirstLocked pages through a room's messages by creation time, newest
+// first, with later inserts first on ties; callers must hold s.mu
+func (s *Store) newestFirstLocked(roomID pgtype.UUID, limit, offset int32) []db.Message {
+	var messages []db.Message
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].RoomID == roomID {
+			messages = append(messages, s.messages[i])
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool { return messages[i].CreatedAt.Time.After(messages[j].CreatedAt.Time) })
+
+	if int(offset) >= len(messages) {
+		return nil
+	}
+	messages = messages[offset:]
+	if int(limit) < len(messages) {
+		messages = messages[:limit]
+	}
+	return messages
+}
+
+// deleteMessagesLocked removes matching messages with their pins and outbox
+// entries, and clears replies' parents; callers must hold s.mu
+func (s *Store) deleteMessagesLocked(match func(db.Message) bool) {
+	deleted := make(map[pgtype.UUID]bool)
+	kept := s.messages[:0]
+	for _, m := range s.messages {
+		if match(m) {
+			deleted[m.ID] = true
+			continue
+		}
+		kept = append(kept, m)
+	}
+	s.messages = kept
+
+	for i := range s.messages {
+		if deleted[s.messages[i].ParentMessageID] {
+			s.messages[i].ParentMessageID = pgtype.UUID{}
+		}
+	}
+	for roomID, pins := range s.pins {
+		keptPins := pins[:0]
+		for _, p := range pins {
+			if !deleted[p.MessageID] {
+				keptPins = append(keptPins, p)
+			}
+		}
+		s.pins[roomID] = keptPins
+	}
+	keptOutbox := s.outbox[:0]
+	for _, e := range s.outbox {
+		if !deleted[e.MessageID] {
+			keptOutbox = append(keptOutbox, e)
+		}
+	}
+	s.outbox = keptOutbox
+}
+
+// Pinned message operations
+
+// PinMessage pins a message, returning repository.ErrPinLimitReached when the
+// room already has repository.MaxPinnedMessages pins
+func (s *Store) PinMessage(ctx context.Context, roomID, messageID, userID pgtype.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.pins[roomID] {
+		if p.MessageID == messageID {
+			return nil
+		}
+	}
+	if len(s.pins[roomID]) >= repository.MaxPinnedMessages {
+		return repository.ErrPinLimitReached
+	}
+	s.pins[roomID] = append(s.pins[roomID], db.PinnedMessage{
+		RoomID:    roomID,
+		MessageID: messageID,
+		PinnedBy:  userID,
+		PinnedAt:  timestamp(time.Now()),
+	})
+	return nil
+}
+
+func (s *Store) UnpinMessage(ctx context.Context, roomID, messageID pgtype.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pins := s.pins[roomID]
+	for i, p := range pins {
+		if p.MessageID == messageID {
+			s.pins[roomID] = append(pins[:i], pins[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// ListPinnedMessages returns a room's pins in pin order
+func (s *Store) ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]db.ListPinnedMessagesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rows := make([]db.ListPinnedMessagesRow, 0, len(s.pins[roomID]))
+	for _, p := range s.pins[roomID] {
+		row := db.ListPinnedMessagesRow{MessageID: p.MessageID, PinnedBy: p.PinnedBy, PinnedAt: p.PinnedAt}
+		for _, m := range s.messages {
+			if m.ID == p.MessageID {
+				row.Content = m.Content
+				row.CreatedAt = m.CreatedAt
+				row.Username = s.users[m.UserID].Username
+				break
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Poll operations
+
+func (s *Store) CreatePoll(ctx context.Context, roomID, creatorID pgtype.UUID, question string, options []string, endsAt pgtype.Timestamptz) (db.Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	poll := db.Poll{
+		ID:        newID(),
+		RoomID:    roomID,
+		CreatorID: creatorID,
+		Question:  question,
+		Options:   append([]string(nil), options...),
+		EndsAt:    endsAt,
+		CreatedAt: timestamp(time.Now()),
+	}
+	s.polls[poll.ID] = poll
+	return poll, nil
+}
+
+func (s *Store) GetPollByID(ctx context.Context, id pgtype.UUID) (db.Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	poll, ok := s.polls[id]
+	if !ok {
+		return db.Poll{}, pgx.ErrNoRows
+	}
+	return poll, nil
+}
+
+// UpsertPollVote records or changes a vote, returning repository.ErrPollClosed
+// once the poll is closed or has ended
+func (s *Store) UpsertPollVote(ctx context.Context, pollID, userID pgtype.UUID, optionIndex int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	poll, ok := s.polls[pollID]
+	if !ok || poll.Closed || !poll.EndsAt.Time.After(time.Now()) {
+		return repository.ErrPollClosed
+	}
+	if s.votes[pollID] == nil {
+		s.votes[pollID] = make(map[pgtype.UUID]int32)
+	}
+	s.votes[pollID][userID] = optionIndex
+	return nil
+}
+
+// GetPollVoteCounts returns the votes per option that has any, by option
+func (s *Store) GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]db.GetPollVoteCountsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[int32]int64)
+	for _, option := range s.votes[pollID] {
+		counts[option]++
+	}
+	rows := make([]db.GetPollVoteCountsRow, 0, len(counts))
+	for option, votes := range counts {
+		rows = append(rows, db.GetPollVoteCountsRow{OptionIndex: option, Votes: votes})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].OptionIndex < rows[j].OptionIndex })
+	return rows, nil
+}
+
+// ListEndedPolls returns open polls past their end time, earliest first
+func (s *Store) ListEndedPolls(ctx context.Context) ([]db.Poll, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var polls []db.Poll
+	for _, p := range s.polls {
+		if !p.Closed && !p.EndsAt.Time.After(now) {
+			polls = append(polls, p)
+		}
+	}
+	sort.Slice(polls, func(i, j int) bool { return polls[i].EndsAt.Time.Before(polls[j].EndsAt.Time) })
+	return polls, nil
+}
+
+func (s *Store) ClosePoll(ctx context.Context, id pgtype.UUID) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	poll, ok := s.polls[id]
+	if !ok || poll.Closed {
+		return false, nil
+	}
+	poll.Closed = true
+	s.polls[id] = poll
+	return true, nil
+}
+
+// Flagged message operations
+
+func (s *Store) CreateFlaggedMessage(ctx context.Context, roomID, userID pgtype.UUID, username, content string, matchedWords []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flagged = append(s.flagged, db.FlaggedMessage{
+		ID:           newID(),
+		RoomID:       roomID,
+		UserID:       userID,
+		Username:     username,
+		Content:      content,
+		MatchedWords: append([]string(nil), matchedWords...),
+		FlaggedAt:    timestamp(time.Now()),
+	})
+	return nil
+}
+
+// ListFlaggedMessages returns up to limit flagged messages, newest first
+func (s *Store) ListFlaggedMessages(ctx context.Context, limit int32) ([]db.ListFlaggedMessagesRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rows []db.ListFlaggedMessagesRow
+	for i := len(s.flagged) - 1; i >= 0 && len(rows) < int(limit); i-- {
+		flag := s.flagged[i]
+		rows = append(rows, db.ListFlaggedMessagesRow{
+			ID:           flag.ID,
+			UserID:       flag.UserID,
+			Username:     flag.Username,
+			Content:      flag.Content,
+			MatchedWords: flag.MatchedWords,
+			FlaggedAt:    flag.FlaggedAt,
+			RoomName:     s.rooms[flag.RoomID].Name,
+		})
+	}
+	return rows, nil
+}
+
+// Analytics operations
+
+// inRange reports whether ts falls in [from, to)
+func inRange(ts pgtype.Timestamptz, from, to time.Time) bool {
+	return !ts.Time.Before(from) && ts.Time.Before(to)
+}
+
+// utcDate returns the UTC date of t
+func utcDate(t time.Time) pgtype.Date {
+	y, m, d := t.UTC().Date()
+	return pgtype.Date{Time: time.Date(y, m, d, 0, 0, 0, 0, time.UTC), Valid: true}
+}
+
+// sortedDates returns the keys of a per-day map in order
+func sortedDates[V any](byDay map[pgtype.Date]V) []pgtype.Date {
+	days := make([]pgtype.Date, 0, len(byDay))
+	for day := range byDay {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Time.Before(days[j].Time) })
+	return days
+}
+
+func (s *Store) CountMessagesPerDay(ctx context.Context, from, to time.Time) ([]db.CountMessagesPerDayRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[pgtype.Date]int64)
+	for _, m := range s.messages {
+		if inRange(m.CreatedAt, from, to) {
+			counts[utcDate(m.CreatedAt.Time)]++
+		}
+	}
+	var rows []db.CountMessagesPerDayRow
+	for _, day := range sortedDates(counts) {
+		rows = append(rows, db.CountMessagesPerDayRow{Day: day, Messages: counts[day]})
+	}
+	return rows, nil
+}
+
+func (s *Store) CountNewUsersPerDay(ctx context.Context, from, to time.Time) ([]db.CountNewUsersPerDayRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[pgtype.Date]int64)
+	for _, u := range s.users {
+		if inRange(u.CreatedAt, from, to) {
+			counts[utcDate(u.CreatedAt.Time)]++
+		}
+	}
+	var rows []db.CountNewUsersPerDayRow
+	for _, day := range sortedDates(counts) {
+		rows = append(rows, db.CountNewUsersPerDayRow{Day: day, Users: counts[day]})
+	}
+	return rows, nil
+}
+
+// ListMostActiveRooms returns up to limit rooms by messages in the range,
+// busiest first and then by name
+func (s *Store) ListMostActiveRooms(ctx context.Context, from, to time.Time, limit int32) ([]db.ListMostActiveRoomsRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[pgtype.UUID]int64)
+	for _, m := range s.messages {
+		if _, ok := s.rooms[m.RoomID]; ok && inRange(m.CreatedAt, from, to) {
+			counts[m.RoomID]++
+		}
+	}
+	rows := make([]db.ListMostActiveRoomsRow, 0, len(counts))
+	for roomID, messages := range counts {
+		rows = append(rows, db.ListMostActiveRoomsRow{Name: s.rooms[roomID].Name, Messages: messages})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Messages != rows[j].Messages {
+			return rows[i].Messages > rows[j].Messages
+		}
+		return rows[i].Name < rows[j].Name
+	})
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+// PeakConnectionsPerDay adds up the samples of each minute and returns the
+// highest total of each day
+func (s *Store) PeakConnectionsPerDay(ctx context.Context, from, to time.Time) ([]db.PeakConnectionsPerDayRow, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	perMinute := make(map[time.Time]int64)
+	for _, sample := range s.samples {
+		if inRange(sample.SampledAt, from, to) {
+			perMinute[sample.SampledAt.Time.Truncate(time.Minute)] += int64(sample.PeakConnections)
+		}
+	}
+	peaks := make(map[pgtype.Date]int64)
+	for minute, connections := range perMinute {
+		day := utcDate(minute)
+		peaks[day] = max(peaks[day], connections)
+	}
+	var rows []db.PeakConnectionsPerDayRow
+	for _, day := range sortedDates(peaks) {
+		rows = append(rows, db.PeakConnectionsPerDayRow{Day: day, PeakConnections: peaks[day]})
+	}
+	return rows, nil
+}
+
+func (s *Store) CreateStatsSample(ctx context.Context, serverID string, peakConnections int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, db.StatsSample{ServerID: serverID, SampledAt: timestamp(time.Now()), PeakConnections: peakConnections})
+	return nil
+}
+
+// AddStatsSample records a sample taken at sampledAt
+func (s *Store) AddStatsSample(serverID string, sampledAt time.Time, peakConnections int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples = append(s.samples, db.StatsSample{ServerID: serverID, SampledAt: timestamp(sampledAt), PeakConnections: peakConnections})
+}
+
+// StatsSamples returns every stored sample in insertion order
+func (s *Store) StatsSamples() []db.StatsSample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]db.StatsSample(nil), s.samples...)
+}
+
+func (s *Store) DeleteStatsSamplesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.samples[:0]
+	for _, sample := range s.samples {
+		if !sample.SampledAt.Time.Before(cutoff) {
+			kept = append(kept, sample)
+		}
+	}
+	deleted := int64(len(s.samples) - len(kept))
+	s.samples = kept
+	return deleted, nil
+}
+
+// Message outbox operations
+
+// ClaimOutboxEntries leases up to limit unsent, due entries, oldest first
+func (s *Store) ClaimOutboxEntries(ctx context.Context, limit int32, lease time.Duration) ([]db.MessageOutbox, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var claimed []db.MessageOutbox
+	for i := range s.outbox {
+		if int32(len(claimed)) >= limit {
+			break
+		}
+		e := &s.outbox[i]
+		if e.SentAt.Valid || e.NextAttemptAt.Time.After(now) {
+			continue
+		}
+		e.NextAttemptAt = timestamp(now.Add(lease))
+		claimed = append(claimed, *e)
+	}
+	return claimed, nil
+}
+
+func (s *Store) MarkOutboxSent(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.outbox {
+		if s.outbox[i].ID == id {
+			s.outbox[i].SentAt = timestamp(time.Now())
+		}
+	}
+	return nil
+}
+
+func (s *Store) MarkOutboxFailed(ctx context.Context, id int64, errText string, retry time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.outbox {
+		if s.outbox[i].ID == id {
+			s.outbox[i].Attempts++
+			s.outbox[i].LastError = pgtype.Text{String: errText, Valid: true}
+			s.outbox[i].NextAttemptAt = timestamp(time.Now().Add(retry))
+		}
+	}
+	return nil
+}
+
+// DeleteSentOutboxEntriesBefore deletes outbox entries published before cutoff
+func (s *Store) DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.outbox[:0]
+	for _, e := range s.outbox {
+		if !e.SentAt.Valid || !e.SentAt.Time.Before(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	deleted := int64(len(s.outbox) - len(kept))
+	s.outbox = kept
+	return deleted, nil
+}
@@ -0,0 +1,367 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/repository"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreConstraints(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	_, err = s.CreateUser(ctx, "alice", "other@example.com", "hash")
+	assert.Error(t, err, "usernames are unique")
+	_, err = s.CreateUser(ctx, "Alice", "other@example.com", "hash")
+	assert.ErrorIs(t, err, repository.ErrUsernameTaken, "in any casing")
+	_, err = s.CreateUser(ctx, "аlice", "other@example.com", "hash")
+	assert.ErrorIs(t, err, repository.ErrUsernameTaken, "or with look-alike letters")
+	found, err := s.GetUserByUsername(ctx, "ALICE")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	room, err := s.CreateRoom(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, user.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	_, err = s.CreateRoom(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, user.ID, false, pgtype.Int4{})
+	assert.ErrorIs(t, err, repository.ErrRoomExists)
+
+	_, err = s.GetRoomByName(ctx, "missing")
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	for i := 0; i < repository.MaxPinnedMessages; i++ {
+		msg, err := s.CreateMessage(ctx, room.ID, user.ID, "pin me")
+		require.NoError(t, err)
+		require.NoError(t, s.PinMessage(ctx, room.ID, msg.ID, user.ID))
+	}
+	extra, err := s.CreateMessage(ctx, room.ID, user.ID, "one too many")
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.PinMessage(ctx, room.ID, extra.ID, user.ID), repository.ErrPinLimitReached)
+}
+
+func TestStoreCreateRoomWithCreator(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	room, err := s.CreateRoomWithCreator(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, user.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	count, err := s.GetRoomMemberCount(ctx, room.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// An unknown creator stores nothing
+	_, err = s.CreateRoomWithCreator(ctx, "empty", pgtype.Bool{}, pgtype.Text{}, pgtype.UUID{Bytes: [16]byte{9}, Valid: true}, false, pgtype.Int4{})
+	require.Error(t, err)
+	_, err = s.GetRoomByName(ctx, "empty")
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestStoreDeleteUserCascades(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	alice, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	room, err := s.CreateRoom(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	require.NoError(t, s.AddRoomMember(ctx, room.ID, alice.ID, repository.RoomRoleMember))
+	require.NoError(t, s.AddRoomMember(ctx, room.ID, bob.ID, repository.RoomRoleMember))
+
+	parent, err := s.CreateMessage(ctx, room.ID, alice.ID, "from alice")
+	require.NoError(t, err)
+	_, err = s.CreateReplyMessage(ctx, room.ID, bob.ID, parent.ID, "reply from bob")
+	require.NoError(t, err)
+
+	deleted, err := s.DeleteUser(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	rows, err := s.ListRecentMessagesByRoom(ctx, room.ID, 10)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "reply from bob", rows[0].Content)
+	assert.False(t, rows[0].ParentMessageID.Valid)
+
+	count, err := s.GetRoomMemberCount(ctx, room.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	stored, err := s.GetRoomByName(ctx, "lounge")
+	require.NoError(t, err)
+	assert.False(t, stored.CreatorID.Valid)
+}
+
+func TestStoreSearchUsers(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	for _, name := range []string{"Alice2", "alice1", "malice", "bob"} {
+		_, err := s.CreateUser(ctx, name, name+"@example.com", "hash")
+		require.NoError(t, err)
+	}
+
+	users, err := s.SearchUsers(ctx, "ALIC", 10)
+	require.NoError(t, err)
+	require.Len(t, users, 3)
+	assert.Equal(t, "Alice2", users[0].Username)
+	assert.Equal(t, "malice", users[2].Username)
+
+	users, err = s.SearchUsers(ctx, "alic", 2)
+	require.NoError(t, err)
+	assert.Len(t, users, 2)
+}
+
+func TestStoreRoomInvites(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	alice, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	room, err := s.CreateRoomWithCreator(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+
+	first, err := s.CreateRoomInvite(ctx, room.ID, alice.ID, bob.ID)
+	require.NoError(t, err)
+	second, err := s.CreateRoomInvite(ctx, room.ID, alice.ID, bob.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Len(t, s.invites, 1, "inviting again replaces the invite")
+
+	_, err = s.CreateRoomInvite(ctx, room.ID, alice.ID, pgtype.UUID{Bytes: [16]byte{9}, Valid: true})
+	assert.Error(t, err)
+
+	_, err = s.DeleteUser(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Empty(t, s.invites, "deleting the invitee deletes their invites")
+}
+
+func TestStoreVerifyEmail(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	alice, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	assert.False(t, alice.EmailVerified)
+	require.NoError(t, s.CreateEmailVerificationToken(ctx, alice.ID, "fresh", time.Now().Add(time.Hour)))
+	require.NoError(t, s.CreateEmailVerificationToken(ctx, alice.ID, "stale", time.Now().Add(-time.Minute)))
+	assert.Error(t, s.CreateEmailVerificationToken(ctx, pgtype.UUID{Bytes: [16]byte{9}, Valid: true}, "orphan", time.Now().Add(time.Hour)))
+
+	_, err = s.VerifyEmail(ctx, "stale")
+	assert.ErrorIs(t, err, repository.ErrVerificationTokenInvalid, "expired")
+	verified, err := s.VerifyEmail(ctx, "fresh")
+	require.NoError(t, err)
+	assert.True(t, verified.EmailVerified)
+	found, err := s.GetUserByID(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.True(t, found.EmailVerified)
+	_, err = s.VerifyEmail(ctx, "fresh")
+	assert.ErrorIs(t, err, repository.ErrVerificationTokenInvalid, "tokens work once")
+
+	require.NoError(t, s.CreateEmailVerificationToken(ctx, alice.ID, "unused", time.Now().Add(time.Hour)))
+	_, err = s.DeleteUser(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, s.tokens, "deleting the user deletes their tokens")
+}
+
+func TestStoreRoomMemberRoles(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	alice, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := s.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	room, err := s.CreateRoomWithCreator(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	require.NoError(t, s.AddRoomMember(ctx, room.ID, bob.ID, repository.RoomRoleMember))
+	assert.ErrorIs(t, s.AddRoomMember(ctx, room.ID, bob.ID, "owner"), repository.ErrInvalidRoomRole)
+
+	role, err := s.GetRoomMemberRole(ctx, room.ID, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, repository.RoomRoleMember, role)
+
+	// Promoting and rejoining keep one row, and rejoining keeps the role
+	require.NoError(t, s.SetRoomMemberRole(ctx, room.ID, bob.ID, repository.RoomRoleModerator))
+	require.NoError(t, s.AddRoomMember(ctx, room.ID, bob.ID, repository.RoomRoleMember))
+	members, err := s.GetRoomMembersWithRoles(ctx, room.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 2)
+	assert.Equal(t, "bob", members[1].Username)
+	assert.Equal(t, repository.RoomRoleModerator, members[1].Role)
+
+	require.NoError(t, s.SetRoomMemberRole(ctx, room.ID, bob.ID, repository.RoomRoleMember))
+	role, err = s.GetRoomMemberRole(ctx, room.ID, bob.ID)
+	require.NoError(t, err)
+	assert.Equal(t, repository.RoomRoleMember, role)
+
+	assert.ErrorIs(t, s.SetRoomMemberRole(ctx, room.ID, bob.ID, "owner"), repository.ErrInvalidRoomRole)
+	require.NoError(t, s.RemoveRoomMember(ctx, room.ID, bob.ID))
+	assert.ErrorIs(t, s.SetRoomMemberRole(ctx, room.ID, bob.ID, repository.RoomRoleModerator), pgx.ErrNoRows)
+	_, err = s.GetRoomMemberRole(ctx, room.ID, bob.ID)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestStoreSoftDeleteRoom(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	alice, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	room, err := s.CreateRoomWithCreator(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	_, err = s.CreateMessage(ctx, room.ID, alice.ID, "kept")
+	require.NoError(t, err)
+	_, err = s.CreateRoom(ctx, "default", pgtype.Bool{}, pgtype.Text{}, pgtype.UUID{}, false, pgtype.Int4{})
+	require.NoError(t, err)
+	count, err := s.CountRooms(ctx, "default")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+	count, err = s.CountRoomsByCreator(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	deleted, err := s.SoftDeleteRoom(ctx, room.ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	deleted, err = s.SoftDeleteRoom(ctx, room.ID)
+	require.NoError(t, err)
+	assert.False(t, deleted, "already deleted")
+
+	// Gone from lookups and listings, but its messages are kept
+	_, err = s.GetRoomByName(ctx, "lounge")
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+	rooms, err := s.GetAllRooms(ctx)
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, "default", rooms[0].Name)
+	count, err = s.CountRooms(ctx, "default")
+	require.NoError(t, err)
+	assert.Zero(t, count, "deleted rooms don't count")
+	count, err = s.CountRoomsByCreator(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	summaries, err := s.ListUserRoomSummaries(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Empty(t, summaries)
+	messages, err := s.ListMessagesByRoom(ctx, room.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Len(t, messages, 1)
+
+	// The name is free again, so restoring conflicts until it is released
+	reused, err := s.CreateRoom(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	found, err := s.GetDeletedRoomByName(ctx, "lounge")
+	require.NoError(t, err)
+	assert.Equal(t, room.ID, found.ID)
+	_, err = s.RestoreRoom(ctx, room.ID)
+	assert.ErrorIs(t, err, repository.ErrRoomExists)
+	require.NoError(t, s.DeleteRoom(ctx, reused.ID))
+
+	restored, err := s.RestoreRoom(ctx, room.ID)
+	require.NoError(t, err)
+	assert.False(t, restored.DeletedAt.Valid)
+	_, err = s.GetRoomByName(ctx, "lounge")
+	assert.NoError(t, err)
+
+	// Purging only removes rooms deleted before the cutoff
+	_, err = s.SoftDeleteRoom(ctx, room.ID)
+	require.NoError(t, err)
+	purged, err := s.PurgeDeletedRooms(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = s.PurgeDeletedRooms(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	deletedRooms, err := s.ListDeletedRooms(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, deletedRooms)
+	assert.Empty(t, s.Messages())
+}
+
+func TestStorePolls(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	voter := newID()
+	open, err := s.CreatePoll(ctx, newID(), voter, "Lunch?", []string{"yes", "no"}, timestamp(time.Now().Add(time.Hour)))
+	require.NoError(t, err)
+	ended, err := s.CreatePoll(ctx, newID(), voter, "Breakfast?", []string{"yes", "no"}, timestamp(time.Now().Add(-time.Minute)))
+	require.NoError(t, err)
+
+	require.NoError(t, s.UpsertPollVote(ctx, open.ID, voter, 0))
+	require.NoError(t, s.UpsertPollVote(ctx, open.ID, voter, 1))
+	assert.ErrorIs(t, s.UpsertPollVote(ctx, ended.ID, voter, 0), repository.ErrPollClosed)
+
+	counts, err := s.GetPollVoteCounts(ctx, open.ID)
+	require.NoError(t, err)
+	require.Len(t, counts, 1)
+	assert.Equal(t, int32(1), counts[0].OptionIndex)
+
+	due, err := s.ListEndedPolls(ctx)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	assert.Equal(t, ended.ID, due[0].ID)
+
+	closed, err := s.ClosePoll(ctx, ended.ID)
+	require.NoError(t, err)
+	assert.True(t, closed)
+	closed, err = s.ClosePoll(ctx, ended.ID)
+	require.NoError(t, err)
+	assert.False(t, closed)
+}
+
+func TestStoreListRoomExportPage(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+
+	user, err := s.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	room, err := s.CreateRoom(ctx, "lounge", pgtype.Bool{}, pgtype.Text{}, user.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err := s.CreateMessage(ctx, room.ID, user.ID, "hello")
+		require.NoError(t, err)
+	}
+	// Messages sharing a timestamp are told apart by ID
+	sent := pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	for i := range s.messages {
+		s.messages[i].CreatedAt = sent
+	}
+
+	var pages [][]pgtype.UUID
+	seen := map[pgtype.UUID]bool{}
+	var beforeCreatedAt time.Time
+	var beforeID pgtype.UUID
+	for {
+		rows, err := s.ListRoomExportPage(ctx, room.ID, beforeCreatedAt, beforeID, 2)
+		require.NoError(t, err)
+		if len(rows) == 0 {
+			break
+		}
+		var page []pgtype.UUID
+		for _, row := range rows {
+			assert.False(t, seen[row.ID], "no message is sent twice")
+			seen[row.ID] = true
+			page = append(page, row.ID)
+		}
+		pages = append(pages, page)
+		last := rows[len(rows)-1]
+		beforeCreatedAt, beforeID = last.CreatedAt.Time, last.ID
+	}
+	assert.Len(t, pages, 3)
+	assert.Len(t, seen, 5)
+}
@@ -2,34 +2,104 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	"websocket-demo/internal/db"
+	"websocket-demo/internal/validator"
 )
 
+// TxBeginner starts database transactions; *pgxpool.Pool satisfies it
+type TxBeginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
 type Repository struct {
-	queries *db.Queries
+	queries db.Querier
+	txs     TxBeginner
+	retry   RetryPolicy // How hot-path writes are retried; see SetRetryPolicy
 }
 
-func NewRepository(queries *db.Queries) *Repository {
+// NewRepository wraps queries; txs may be nil, in which case operations that
+// need a transaction return ErrNoTransactions
+func NewRepository(queries db.Querier, txs TxBeginner) *Repository {
 	return &Repository{
 		queries: queries,
+		txs:     txs,
+		retry:   DefaultRetryPolicy,
+	}
+}
+
+// ErrNoTransactions is returned by operations that need a transaction when the
+// repository was created without a TxBeginner
+var ErrNoTransactions = errors.New("repository has no transaction support")
+
+// WithTx runs fn in a transaction, passing it a repository whose operations
+// run on that transaction. The transaction commits if fn returns nil and is
+// rolled back otherwise, so a failure part way leaves no partial rows.
+// Writes inside fn aren't retried, as a failed statement aborts the whole
+// transaction; WithTx called again inside fn uses a savepoint.
+func (r *Repository) WithTx(ctx context.Context, fn func(r *Repository) error) error {
+	if r.txs == nil {
+		return ErrNoTransactions
+	}
+	tx, err := r.txs.Begin(ctx)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(&Repository{queries: db.New(tx), txs: tx, retry: RetryPolicy{Attempts: 1}}); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 // User operations
+
+// ErrUsernameTaken is returned when creating a user whose username matches or
+// looks like an existing one, ignoring case
+var ErrUsernameTaken = errors.New("username is already taken")
+
+// usernameConstraints are the unique indexes that keep usernames unique
+var usernameConstraints = map[string]bool{
+	"users_username_key":          true,
+	"idx_users_username_lower":    true,
+	"idx_users_username_skeleton": true,
+}
+
+// CreateUser inserts a user, returning ErrUsernameTaken if the username is
+// taken in any casing or by a look-alike name (see validator.Skeleton)
 func (r *Repository) CreateUser(ctx context.Context, username, email, passwordHash string) (db.User, error) {
-	return r.queries.CreateUser(ctx, db.CreateUserParams{
-		Username:     username,
-		Email:        email,
-		PasswordHash: passwordHash,
+	user, err := r.queries.CreateUser(ctx, db.CreateUserParams{
+		Username:         username,
+		Email:            email,
+		PasswordHash:     passwordHash,
+		UsernameSkeleton: validator.Skeleton(username),
 	})
+	return user, usernameError(err)
+}
+
+// usernameError maps a unique violation on a username index to ErrUsernameTaken
+func usernameError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && usernameConstraints[pgErr.ConstraintName] {
+		return ErrUsernameTaken
+	}
+	return err
 }
 
 func (r *Repository) GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error) {
 	return r.queries.GetUserByID(ctx, id)
 }
 
+// GetUserByUsername looks a user up by username, ignoring case
 func (r *Repository) GetUserByUsername(ctx context.Context, username string) (db.User, error) {
 	return r.queries.GetUserByUsername(ctx, username)
 }
@@ -38,14 +108,59 @@ func (r *Repository) GetUserByEmail(ctx context.Context, email string) (db.User,
 	return r.queries.GetUserByEmail(ctx, email)
 }
 
+// likeEscaper escapes the characters ILIKE treats as wildcards
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchUsers returns up to limit users whose name contains query, ignoring
+// case, in name order
+func (r *Repository) SearchUsers(ctx context.Context, query string, limit int) ([]db.User, error) {
+	return r.queries.SearchUsers(ctx, db.SearchUsersParams{
+		Username: "%" + likeEscaper.Replace(query) + "%",
+		Limit:    int32(limit),
+	})
+}
+
 // Room operations
-func (r *Repository) CreateRoom(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID) (db.Room, error) {
-	return r.queries.CreateRoom(ctx, db.CreateRoomParams{
-		Name:         name,
-		Private:      private,
-		PasswordHash: passwordHash,
-		CreatorID:    creatorID,
+
+// ErrRoomExists is returned when creating a room whose name is already taken
+var ErrRoomExists = errors.New("room already exists")
+
+// uniqueViolation is the Postgres error code for a unique constraint violation
+const uniqueViolation = "23505"
+
+// CreateRoom inserts a room, returning ErrRoomExists if another writer took the name first
+func (r *Repository) CreateRoom(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error) {
+	room, err := r.queries.CreateRoom(ctx, db.CreateRoomParams{
+		Name:              name,
+		Private:           private,
+		PasswordHash:      passwordHash,
+		CreatorID:         creatorID,
+		SuppressJoinLeave: suppressJoinLeave,
+		MaxClients:        maxClients,
+	})
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return db.Room{}, ErrRoomExists
+	}
+	return room, err
+}
+
+// CreateRoomWithCreator inserts a room and makes creatorID a member of it in
+// one transaction, so the room is never stored without its creator
+func (r *Repository) CreateRoomWithCreator(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error) {
+	var room db.Room
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		var err error
+		room, err = tx.CreateRoom(ctx, name, private, passwordHash, creatorID, suppressJoinLeave, maxClients)
+		if err != nil {
+			return err
+		}
+		return tx.AddRoomMember(ctx, room.ID, creatorID, RoomRoleMember)
 	})
+	if err != nil {
+		return db.Room{}, err
+	}
+	return room, nil
 }
 
 func (r *Repository) GetRoomByID(ctx context.Context, id pgtype.UUID) (db.Room, error) {
@@ -56,6 +171,17 @@ func (r *Repository) GetRoomByName(ctx context.Context, name string) (db.Room, e
 	return r.queries.GetRoomByName(ctx, name)
 }
 
+// CountRooms counts the rooms not deleted, leaving out the room named
+// excludedName such as the default room
+func (r *Repository) CountRooms(ctx context.Context, excludedName string) (int64, error) {
+	return r.queries.CountRooms(ctx, excludedName)
+}
+
+// CountRoomsByCreator counts the rooms not deleted that creatorID created
+func (r *Repository) CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error) {
+	return r.queries.CountRoomsByCreator(ctx, creatorID)
+}
+
 func (r *Repository) ListRooms(ctx context.Context, limit, offset int32) ([]db.Room, error) {
 	return r.queries.ListRooms(ctx, db.ListRoomsParams{
 		Limit:  limit,
@@ -70,19 +196,283 @@ func (r *Repository) GetAllRooms(ctx context.Context) ([]db.Room, error) {
 	})
 }
 
+// GetRoomsByNames returns the rooms with any of the given names that aren't deleted
+func (r *Repository) GetRoomsByNames(ctx context.Context, names []string) ([]db.Room, error) {
+	return r.queries.GetRoomsByNames(ctx, names)
+}
+
+func (r *Repository) UpdateRoomSuppressJoinLeave(ctx context.Context, id pgtype.UUID, suppress bool) error {
+	return r.queries.UpdateRoomSuppressJoinLeave(ctx, db.UpdateRoomSuppressJoinLeaveParams{
+		ID:                id,
+		SuppressJoinLeave: suppress,
+	})
+}
+
+// UpdateRoomRetentionDays sets how many days a room keeps its messages; an
+// invalid days clears the override so the room follows the global retention
+func (r *Repository) UpdateRoomRetentionDays(ctx context.Context, id pgtype.UUID, days pgtype.Int4) error {
+	return r.queries.UpdateRoomRetentionDays(ctx, db.UpdateRoomRetentionDaysParams{
+		ID:            id,
+		RetentionDays: days,
+	})
+}
+
+// UpdateRoomPassword replaces a private room's bcrypt password hash
+func (r *Repository) UpdateRoomPassword(ctx context.Context, id pgtype.UUID, passwordHash string) error {
+	return r.queries.UpdateRoomPassword(ctx, db.UpdateRoomPasswordParams{
+		ID:           id,
+		PasswordHash: pgtype.Text{String: passwordHash, Valid: true},
+	})
+}
+
+// DeleteRoom permanently deletes a room with its messages, members, pins and polls
 func (r *Repository) DeleteRoom(ctx context.Context, id pgtype.UUID) error {
 	return r.queries.DeleteRoom(ctx, id)
 }
 
-// Message operations
+// SoftDeleteRoom hides a room from listings and joins, keeping its messages
+// until PurgeDeletedRooms removes it. It reports false when the room was
+// missing or already deleted.
+func (r *Repository) SoftDeleteRoom(ctx context.Context, id pgtype.UUID) (bool, error) {
+	rows, err := r.queries.SoftDeleteRoom(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// GetDeletedRoomByName returns the most recently deleted room with the name
+func (r *Repository) GetDeletedRoomByName(ctx context.Context, name string) (db.Room, error) {
+	return r.queries.GetDeletedRoomByName(ctx, name)
+}
+
+// ListDeletedRooms returns the soft-deleted rooms, most recently deleted first
+func (r *Repository) ListDeletedRooms(ctx context.Context) ([]db.Room, error) {
+	return r.queries.ListDeletedRooms(ctx)
+}
+
+// RestoreRoom undoes SoftDeleteRoom. It returns ErrRoomExists when a live
+// room has taken the name in the meantime.
+func (r *Repository) RestoreRoom(ctx context.Context, id pgtype.UUID) (db.Room, error) {
+	room, err := r.queries.RestoreRoom(ctx, id)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return db.Room{}, ErrRoomExists
+	}
+	return room, err
+}
+
+// PurgeDeletedRooms permanently deletes rooms soft-deleted before cutoff
+func (r *Repository) PurgeDeletedRooms(ctx context.Context, cutoff time.Time) (int64, error) {
+	return r.queries.PurgeDeletedRooms(ctx, pgtype.Timestamptz{Time: cutoff, Valid: true})
+}
+
+// Message operations; writes are retried after transient errors
 func (r *Repository) CreateMessage(ctx context.Context, roomID, userID pgtype.UUID, content string) (db.Message, error) {
-	return r.queries.CreateMessage(ctx, db.CreateMessageParams{
-		RoomID:  roomID,
-		UserID:  userID,
+	return withRetry(ctx, r.retry, func() (db.Message, error) {
+		return r.queries.CreateMessage(ctx, db.CreateMessageParams{
+			RoomID:  roomID,
+			UserID:  userID,
+			Content: content,
+		})
+	})
+}
+
+// CreateReplyMessage creates a message that replies to parentID
+func (r *Repository) CreateReplyMessage(ctx context.Context, roomID, userID, parentID pgtype.UUID, content string) (db.Message, error) {
+	return withRetry(ctx, r.retry, func() (db.Message, error) {
+		return r.queries.CreateMessage(ctx, db.CreateMessageParams{
+			RoomID:          roomID,
+			UserID:          userID,
+			Content:         content,
+			ParentMessageID: parentID,
+		})
+	})
+}
+
+// BulkCreateMessages inserts many messages with a single COPY, for imports.
+// Messages without an ID or timestamp get a fresh ID or the current time, and
+// the inserted messages are returned in order.
+func (r *Repository) BulkCreateMessages(ctx context.Context, params []db.BulkCreateMessagesParams) ([]db.Message, error) {
+	rows := make([]db.BulkCreateMessagesParams, len(params))
+	copy(rows, params)
+	fillNewMessages(rows)
+
+	if _, err := r.queries.BulkCreateMessages(ctx, rows); err != nil {
+		return nil, err
+	}
+
+	messages := make([]db.Message, len(rows))
+	for i, p := range rows {
+		messages[i] = db.Message{
+			ID:              p.ID,
+			RoomID:          p.RoomID,
+			UserID:          p.UserID,
+			Content:         p.Content,
+			CreatedAt:       p.CreatedAt,
+			ParentMessageID: p.ParentMessageID,
+		}
+	}
+	return messages, nil
+}
+
+// NewMessage is a message to insert with CreateMessages
+type NewMessage = db.BulkCreateMessagesParams
+
+// copyFromMinRows is the smallest batch CreateMessages sends with COPY;
+// below it a multi-row INSERT is cheaper than setting up the copy
+const copyFromMinRows = 10
+
+// CreateMessages inserts a batch of messages in one round trip. Messages
+// without an ID or timestamp get a fresh ID or the current time in msgs
+// itself, so callers know every ID before the insert.
+func (r *Repository) CreateMessages(ctx context.Context, msgs []NewMessage) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	fillNewMessages(msgs)
+
+	if len(msgs) >= copyFromMinRows {
+		return execWithRetry(ctx, r.retry, func() error {
+			_, err := r.queries.BulkCreateMessages(ctx, msgs)
+			return err
+		})
+	}
+
+	params := db.InsertMessagesParams{
+		Ids:              make([]pgtype.UUID, len(msgs)),
+		RoomIds:          make([]pgtype.UUID, len(msgs)),
+		UserIds:          make([]pgtype.UUID, len(msgs)),
+		Contents:         make([]string, len(msgs)),
+		CreatedAts:       make([]pgtype.Timestamptz, len(msgs)),
+		ParentMessageIds: make([]pgtype.UUID, len(msgs)),
+	}
+	for i, m := range msgs {
+		params.Ids[i] = m.ID
+		params.RoomIds[i] = m.RoomID
+		params.UserIds[i] = m.UserID
+		params.Contents[i] = m.Content
+		params.CreatedAts[i] = m.CreatedAt
+		params.ParentMessageIds[i] = m.ParentMessageID
+	}
+	return execWithRetry(ctx, r.retry, func() error {
+		return r.queries.InsertMessages(ctx, params)
+	})
+}
+
+// fillNewMessages gives messages without an ID or timestamp a fresh ID or
+// the current time
+func fillNewMessages(msgs []NewMessage) {
+	now := time.Now()
+	for i := range msgs {
+		if !msgs[i].ID.Valid {
+			msgs[i].ID = pgtype.UUID{Bytes: uuid.New(), Valid: true}
+		}
+		if !msgs[i].CreatedAt.Valid {
+			msgs[i].CreatedAt = pgtype.Timestamptz{Time: now, Valid: true}
+		}
+	}
+}
+
+// CreateMessageWithOutbox inserts a message and its outbox entry in one
+// transaction, so the message is published if and only if it was stored.
+// payload builds the published body from the inserted message.
+func (r *Repository) CreateMessageWithOutbox(ctx context.Context, params db.CreateMessageParams, subject string, payload func(db.Message) ([]byte, error)) (db.Message, error) {
+	if r.txs == nil {
+		return db.Message{}, ErrNoTransactions
+	}
+	return withRetry(ctx, r.retry, func() (db.Message, error) {
+		return r.createMessageWithOutbox(ctx, params, subject, payload)
+	})
+}
+
+// createMessageWithOutbox runs one attempt of CreateMessageWithOutbox
+func (r *Repository) createMessageWithOutbox(ctx context.Context, params db.CreateMessageParams, subject string, payload func(db.Message) ([]byte, error)) (db.Message, error) {
+	var msg db.Message
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		var err error
+		msg, err = tx.queries.CreateMessage(ctx, params)
+		if err != nil {
+			return err
+		}
+		body, err := payload(msg)
+		if err != nil {
+			return err
+		}
+		_, err = tx.queries.CreateOutboxEntry(ctx, db.CreateOutboxEntryParams{
+			MessageID: msg.ID,
+			Subject:   subject,
+			Payload:   body,
+		})
+		return err
+	})
+	if err != nil {
+		return db.Message{}, err
+	}
+	return msg, nil
+}
+
+func (r *Repository) GetMessageByID(ctx context.Context, id pgtype.UUID) (db.Message, error) {
+	return r.queries.GetMessageByID(ctx, id)
+}
+
+// UpdateMessageContent replaces the text of a message
+func (r *Repository) UpdateMessageContent(ctx context.Context, id pgtype.UUID, content string) (db.Message, error) {
+	return r.queries.UpdateMessageContent(ctx, db.UpdateMessageContentParams{
+		ID:      id,
 		Content: content,
 	})
 }
 
+// DeleteMessage removes a message, reporting whether it existed. Its pins
+// and outbox entries go with it, and replies to it lose their parent.
+func (r *Repository) DeleteMessage(ctx context.Context, id pgtype.UUID) (bool, error) {
+	rows, err := r.queries.DeleteMessage(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// retentionLockID is the advisory lock key held while deleting expired
+// messages, so only one server in the cluster runs the cleanup at a time
+const retentionLockID int64 = 0x6368617478726574 // "chatxret"
+
+// DeleteExpiredMessages deletes messages older than cutoff from rooms without
+// their own retention, batchSize at a time so no statement holds locks on
+// many rows. The lock is held by a transaction that is rolled back at the end;
+// when another server holds it nothing is deleted and ran is false.
+func (r *Repository) DeleteExpiredMessages(ctx context.Context, cutoff time.Time, batchSize int32) (deleted int64, ran bool, err error) {
+	if r.txs == nil {
+		return 0, false, ErrNoTransactions
+	}
+	lockTx, err := r.txs.Begin(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+	defer lockTx.Rollback(ctx)
+
+	locked, err := db.New(lockTx).TryAdvisoryXactLock(ctx, retentionLockID)
+	if err != nil || !locked {
+		return 0, false, err
+	}
+
+	for {
+		n, err := r.queries.DeleteExpiredMessages(ctx, db.DeleteExpiredMessagesParams{
+			Cutoff:    pgtype.Timestamptz{Time: cutoff, Valid: true},
+			BatchSize: batchSize,
+		})
+		deleted += n
+		if err != nil || n < int64(batchSize) {
+			return deleted, true, err
+		}
+	}
+}
+
+func (r *Repository) CountMessagesByRoom(ctx context.Context, roomID pgtype.UUID) (int64, error) {
+	return r.queries.CountMessagesByRoom(ctx, roomID)
+}
+
 func (r *Repository) ListMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit, offset int32) ([]db.ListMessagesByRoomRow, error) {
 	return r.queries.ListMessagesByRoom(ctx, db.ListMessagesByRoomParams{
 		RoomID: roomID,
@@ -91,6 +481,47 @@ func (r *Repository) ListMessagesByRoom(ctx context.Context, roomID pgtype.UUID,
 	})
 }
 
+// ListRoomExportPage returns up to pageSize of a room's messages newest
+// first, before the message at (beforeCreatedAt, beforeID). A zero
+// beforeCreatedAt starts from the newest message.
+func (r *Repository) ListRoomExportPage(ctx context.Context, roomID pgtype.UUID, beforeCreatedAt time.Time, beforeID pgtype.UUID, pageSize int32) ([]db.ListRoomExportPageRow, error) {
+	before := pgtype.Timestamptz{Time: beforeCreatedAt, Valid: true}
+	if beforeCreatedAt.IsZero() {
+		before = pgtype.Timestamptz{InfinityModifier: pgtype.Infinity, Valid: true}
+	}
+	return r.queries.ListRoomExportPage(ctx, db.ListRoomExportPageParams{
+		RoomID:          roomID,
+		BeforeCreatedAt: before,
+		BeforeID:        beforeID,
+		PageSize:        pageSize,
+	})
+}
+
+// ListRoomTranscript returns up to pageSize of a room's messages oldest
+// first, after the message at (afterCreatedAt, afterID). A zero
+// afterCreatedAt starts from the first message.
+func (r *Repository) ListRoomTranscript(ctx context.Context, roomID pgtype.UUID, afterCreatedAt time.Time, afterID pgtype.UUID, pageSize int32) ([]db.ListRoomTranscriptRow, error) {
+	after := pgtype.Timestamptz{Time: afterCreatedAt, Valid: true}
+	if afterCreatedAt.IsZero() {
+		after = pgtype.Timestamptz{InfinityModifier: pgtype.NegativeInfinity, Valid: true}
+	}
+	return r.queries.ListRoomTranscript(ctx, db.ListRoomTranscriptParams{
+		RoomID:         roomID,
+		AfterCreatedAt: after,
+		AfterID:        afterID,
+		PageSize:       pageSize,
+	})
+}
+
+// ListLatestMessagesByRooms returns the newest message of each room, leaving
+// out private rooms viewerID isn't a member of
+func (r *Repository) ListLatestMessagesByRooms(ctx context.Context, roomIDs []pgtype.UUID, viewerID pgtype.UUID) ([]db.ListLatestMessagesByRoomsRow, error) {
+	return r.queries.ListLatestMessagesByRooms(ctx, db.ListLatestMessagesByRoomsParams{
+		RoomIds:  roomIDs,
+		ViewerID: viewerID,
+	})
+}
+
 func (r *Repository) ListRecentMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit int32) ([]db.ListRecentMessagesByRoomRow, error) {
 	return r.queries.ListRecentMessagesByRoom(ctx, db.ListRecentMessagesByRoomParams{
 		RoomID: roomID,
@@ -98,26 +529,103 @@ func (r *Repository) ListRecentMessagesByRoom(ctx context.Context, roomID pgtype
 	})
 }
 
+// ListThreadMessages returns up to limit replies to a message, oldest first
+func (r *Repository) ListThreadMessages(ctx context.Context, parentID pgtype.UUID, limit int32) ([]db.ListThreadMessagesRow, error) {
+	return r.queries.ListThreadMessages(ctx, db.ListThreadMessagesParams{
+		ParentMessageID: parentID,
+		Limit:           limit,
+	})
+}
+
 // Room member operations
-func (r *Repository) AddRoomMember(ctx context.Context, roomID, userID pgtype.UUID) error {
-	_, err := r.queries.AddRoomMember(ctx, db.AddRoomMemberParams{
+
+// Roles a room member can have
+const (
+	RoomRoleMember    = "member"
+	RoomRoleModerator = "moderator"
+)
+
+// ErrInvalidRoomRole is returned for a role other than RoomRoleMember and RoomRoleModerator
+var ErrInvalidRoomRole = errors.New("invalid room role")
+
+// ValidRoomRole reports whether role is a room member role
+func ValidRoomRole(role string) bool {
+	return role == RoomRoleMember || role == RoomRoleModerator
+}
+
+// AddRoomMember adds a member with role, or refreshes the join time of an
+// existing one, who keeps their current role
+func (r *Repository) AddRoomMember(ctx context.Context, roomID, userID pgtype.UUID, role string) error {
+	if !ValidRoomRole(role) {
+		return ErrInvalidRoomRole
+	}
+	return execWithRetry(ctx, r.retry, func() error {
+		_, err := r.queries.AddRoomMember(ctx, db.AddRoomMemberParams{
+			RoomID: roomID,
+			UserID: userID,
+			Role:   role,
+		})
+		return err
+	})
+}
+
+// SetRoomMemberRole changes a member's role, returning pgx.ErrNoRows if the
+// user isn't a member of the room
+func (r *Repository) SetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID, role string) error {
+	if !ValidRoomRole(role) {
+		return ErrInvalidRoomRole
+	}
+	rows, err := r.queries.SetRoomMemberRole(ctx, db.SetRoomMemberRoleParams{
 		RoomID: roomID,
 		UserID: userID,
+		Role:   role,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
 }
 
-func (r *Repository) RemoveRoomMember(ctx context.Context, roomID, userID pgtype.UUID) error {
-	return r.queries.RemoveRoomMember(ctx, db.RemoveRoomMemberParams{
+// GetRoomMemberRole returns a member's role, or pgx.ErrNoRows if the user
+// isn't a member of the room
+func (r *Repository) GetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID) (string, error) {
+	return r.queries.GetRoomMemberRole(ctx, db.GetRoomMemberRoleParams{
 		RoomID: roomID,
 		UserID: userID,
 	})
 }
 
+func (r *Repository) RemoveRoomMember(ctx context.Context, roomID, userID pgtype.UUID) error {
+	return execWithRetry(ctx, r.retry, func() error {
+		return r.queries.RemoveRoomMember(ctx, db.RemoveRoomMemberParams{
+			RoomID: roomID,
+			UserID: userID,
+		})
+	})
+}
+
 func (r *Repository) GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]db.GetRoomMembersRow, error) {
 	return r.queries.GetRoomMembers(ctx, roomID)
 }
 
+// CreateRoomInvite records that inviterID invited inviteeID to a room,
+// replacing any earlier invite of inviteeID to the room
+func (r *Repository) CreateRoomInvite(ctx context.Context, roomID, inviterID, inviteeID pgtype.UUID) (db.RoomInvite, error) {
+	return r.queries.CreateRoomInvite(ctx, db.CreateRoomInviteParams{
+		RoomID:    roomID,
+		InviterID: inviterID,
+		InviteeID: inviteeID,
+	})
+}
+
+// GetRoomMembersWithRoles returns the members in join order with their roles
+func (r *Repository) GetRoomMembersWithRoles(ctx context.Context, roomID pgtype.UUID) ([]db.GetRoomMembersWithRolesRow, error) {
+	return r.queries.GetRoomMembersWithRoles(ctx, roomID)
+}
+
 func (r *Repository) IsRoomMember(ctx context.Context, roomID, userID pgtype.UUID) (bool, error) {
 	return r.queries.IsRoomMember(ctx, db.IsRoomMemberParams{
 		RoomID: roomID,
@@ -133,17 +641,238 @@ func (r *Repository) GetRoomMemberCount(ctx context.Context, roomID pgtype.UUID)
 	return count, nil
 }
 
+// GetRoomMemberCounts returns the member counts of the given rooms in one
+// query; rooms without members are left out
+func (r *Repository) GetRoomMemberCounts(ctx context.Context, roomIDs []pgtype.UUID) ([]db.GetRoomMemberCountsRow, error) {
+	return r.queries.GetRoomMemberCounts(ctx, roomIDs)
+}
+
+// MarkRoomRead records that the user has caught up with the room's messages
+func (r *Repository) MarkRoomRead(ctx context.Context, roomID, userID pgtype.UUID) error {
+	return r.queries.MarkRoomRead(ctx, db.MarkRoomReadParams{
+		RoomID: roomID,
+		UserID: userID,
+	})
+}
+
+// ListUserRoomSummaries returns the rooms a user is a member of with their
+// member and unread counts and latest message, most recently active first
+func (r *Repository) ListUserRoomSummaries(ctx context.Context, userID pgtype.UUID) ([]db.ListUserRoomSummariesRow, error) {
+	return r.queries.ListUserRoomSummaries(ctx, userID)
+}
+
+// Pinned message operations
+
+// MaxPinnedMessages is the maximum number of pinned messages per room
+const MaxPinnedMessages = 5
+
+// ErrPinLimitReached is returned when a room already has MaxPinnedMessages pins
+var ErrPinLimitReached = errors.New("pin limit reached")
+
+// PinMessage pins a message in a room; pinning an already pinned message is a no-op
+func (r *Repository) PinMessage(ctx context.Context, roomID, messageID, userID pgtype.UUID) error {
+	_, err := r.queries.PinMessage(ctx, db.PinMessageParams{
+		RoomID:    roomID,
+		MessageID: messageID,
+		PinnedBy:  userID,
+		MaxPins:   MaxPinnedMessages,
+	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Nothing inserted: either already pinned or the room is full
+		pinned, err := r.queries.IsMessagePinned(ctx, db.IsMessagePinnedParams{
+			RoomID:    roomID,
+			MessageID: messageID,
+		})
+		if err != nil {
+			return err
+		}
+		if !pinned {
+			return ErrPinLimitReached
+		}
+		return nil
+	}
+	return err
+}
+
+// UnpinMessage unpins a message, reporting whether it was pinned
+func (r *Repository) UnpinMessage(ctx context.Context, roomID, messageID pgtype.UUID) (bool, error) {
+	rows, err := r.queries.UnpinMessage(ctx, db.UnpinMessageParams{
+		RoomID:    roomID,
+		MessageID: messageID,
+	})
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *Repository) ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]db.ListPinnedMessagesRow, error) {
+	return r.queries.ListPinnedMessages(ctx, roomID)
+}
+
+// Poll operations
+
+// ErrPollClosed is returned when voting on a poll that has ended
+var ErrPollClosed = errors.New("poll has ended")
+
+func (r *Repository) CreatePoll(ctx context.Context, roomID, creatorID pgtype.UUID, question string, options []string, endsAt pgtype.Timestamptz) (db.Poll, error) {
+	return r.queries.CreatePoll(ctx, db.CreatePollParams{
+		RoomID:    roomID,
+		CreatorID: creatorID,
+		Question:  question,
+		Options:   options,
+		EndsAt:    endsAt,
+	})
+}
+
+func (r *Repository) GetPollByID(ctx context.Context, id pgtype.UUID) (db.Poll, error) {
+	return r.queries.GetPollByID(ctx, id)
+}
+
+// UpsertPollVote records or changes a user's vote while the poll is open
+func (r *Repository) UpsertPollVote(ctx context.Context, pollID, userID pgtype.UUID, optionIndex int32) error {
+	rows, err := r.queries.UpsertPollVote(ctx, db.UpsertPollVoteParams{
+		UserID:      userID,
+		OptionIndex: optionIndex,
+		PollID:      pollID,
+	})
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrPollClosed
+	}
+	return nil
+}
+
+func (r *Repository) GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]db.GetPollVoteCountsRow, error) {
+	return r.queries.GetPollVoteCounts(ctx, pollID)
+}
+
+func (r *Repository) ListEndedPolls(ctx context.Context) ([]db.Poll, error) {
+	return r.queries.ListEndedPolls(ctx)
+}
+
+// ClosePoll marks a poll closed, reporting whether this call closed it
+func (r *Repository) ClosePoll(ctx context.Context, id pgtype.UUID) (bool, error) {
+	rows, err := r.queries.ClosePoll(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// Flagged message operations
+
+// CreateFlaggedMessage records a message flagged for moderator review; roomID
+// and userID are null for messages sent outside a room and anonymous senders
+func (r *Repository) CreateFlaggedMessage(ctx context.Context, roomID, userID pgtype.UUID, username, content string, matchedWords []string) error {
+	return r.queries.CreateFlaggedMessage(ctx, db.CreateFlaggedMessageParams{
+		RoomID:       roomID,
+		UserID:       userID,
+		Username:     username,
+		Content:      content,
+		MatchedWords: matchedWords,
+	})
+}
+
+func (r *Repository) ListFlaggedMessages(ctx context.Context, limit int32) ([]db.ListFlaggedMessagesRow, error) {
+	return r.queries.ListFlaggedMessages(ctx, limit)
+}
+
+// Analytics operations. Ranges include from and exclude to, and days are UTC dates.
+
+func (r *Repository) CountMessagesPerDay(ctx context.Context, from, to time.Time) ([]db.CountMessagesPerDayRow, error) {
+	return r.queries.CountMessagesPerDay(ctx, db.CountMessagesPerDayParams{
+		FromTime: pgtype.Timestamptz{Time: from, Valid: true},
+		ToTime:   pgtype.Timestamptz{Time: to, Valid: true},
+	})
+}
+
+func (r *Repository) CountNewUsersPerDay(ctx context.Context, from, to time.Time) ([]db.CountNewUsersPerDayRow, error) {
+	return r.queries.CountNewUsersPerDay(ctx, db.CountNewUsersPerDayParams{
+		FromTime: pgtype.Timestamptz{Time: from, Valid: true},
+		ToTime:   pgtype.Timestamptz{Time: to, Valid: true},
+	})
+}
+
+// ListMostActiveRooms returns up to limit rooms with the most messages in the range, busiest first
+func (r *Repository) ListMostActiveRooms(ctx context.Context, from, to time.Time, limit int32) ([]db.ListMostActiveRoomsRow, error) {
+	return r.queries.ListMostActiveRooms(ctx, db.ListMostActiveRoomsParams{
+		FromTime: pgtype.Timestamptz{Time: from, Valid: true},
+		ToTime:   pgtype.Timestamptz{Time: to, Valid: true},
+		RowLimit: limit,
+	})
+}
+
+func (r *Repository) PeakConnectionsPerDay(ctx context.Context, from, to time.Time) ([]db.PeakConnectionsPerDayRow, error) {
+	return r.queries.PeakConnectionsPerDay(ctx, db.PeakConnectionsPerDayParams{
+		FromTime: pgtype.Timestamptz{Time: from, Valid: true},
+		ToTime:   pgtype.Timestamptz{Time: to, Valid: true},
+	})
+}
+
+// CreateStatsSample records a server's peak connection count since its previous sample
+func (r *Repository) CreateStatsSample(ctx context.Context, serverID string, peakConnections int32) error {
+	return r.queries.CreateStatsSample(ctx, db.CreateStatsSampleParams{
+		ServerID:        serverID,
+		PeakConnections: peakConnections,
+	})
+}
+
+func (r *Repository) DeleteStatsSamplesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	return r.queries.DeleteStatsSamplesBefore(ctx, pgtype.Timestamptz{Time: cutoff, Valid: true})
+}
+
+// Message outbox operations
+
+// ClaimOutboxEntries leases up to limit unsent outbox entries that are due,
+// oldest first. A claimed entry is not handed out again until lease passes,
+// so an entry whose publisher dies before MarkOutboxSent is retried later.
+func (r *Repository) ClaimOutboxEntries(ctx context.Context, limit int32, lease time.Duration) ([]db.MessageOutbox, error) {
+	entries, err := r.queries.ClaimOutboxEntries(ctx, db.ClaimOutboxEntriesParams{
+		LeaseSeconds: lease.Seconds(),
+		BatchSize:    limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	// UPDATE ... RETURNING doesn't keep the subquery's order
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	return entries, nil
+}
+
+func (r *Repository) MarkOutboxSent(ctx context.Context, id int64) error {
+	return r.queries.MarkOutboxSent(ctx, id)
+}
+
+// MarkOutboxFailed records a failed publish and schedules the next attempt after retry
+func (r *Repository) MarkOutboxFailed(ctx context.Context, id int64, errText string, retry time.Duration) error {
+	return r.queries.MarkOutboxFailed(ctx, db.MarkOutboxFailedParams{
+		LastError:    pgtype.Text{String: errText, Valid: true},
+		RetrySeconds: retry.Seconds(),
+		ID:           id,
+	})
+}
+
+// DeleteSentOutboxEntriesBefore deletes outbox entries published before cutoff
+func (r *Repository) DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	return r.queries.DeleteSentOutboxEntriesBefore(ctx, pgtype.Timestamptz{Time: cutoff, Valid: true})
+}
+
 // GetQueries returns the underlying queries object
-func (r *Repository) GetQueries() *db.Queries {
+func (r *Repository) GetQueries() db.Querier {
 	return r.queries
 }
 
 // User profile management
 func (r *Repository) UpdateUserUsername(ctx context.Context, id pgtype.UUID, username string) (db.User, error) {
-	return r.queries.UpdateUserUsername(ctx, db.UpdateUserUsernameParams{
-		ID:       id,
-		Username: username,
+	user, err := r.queries.UpdateUserUsername(ctx, db.UpdateUserUsernameParams{
+		ID:               id,
+		Username:         username,
+		UsernameSkeleton: validator.Skeleton(username),
 	})
+	return user, usernameError(err)
 }
 
 func (r *Repository) UpdateUserPassword(ctx context.Context, id pgtype.UUID, passwordHash string) (db.User, error) {
@@ -159,3 +888,56 @@ func (r *Repository) UpdateUserLastLogin(ctx context.Context, id pgtype.UUID, la
 		LastLogin: lastLogin,
 	})
 }
+
+// DeleteUser permanently removes a user along with their messages, room
+// memberships and poll votes, reporting whether the user existed
+func (r *Repository) DeleteUser(ctx context.Context, id pgtype.UUID) (bool, error) {
+	rows, err := r.queries.DeleteUser(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// Email verification
+
+// ErrVerificationTokenInvalid is returned by VerifyEmail for a token that
+// doesn't exist, was already used or has expired
+var ErrVerificationTokenInvalid = errors.New("verification token is invalid or has expired")
+
+// CreateEmailVerificationToken stores a token that verifies the user's email
+// until expiresAt
+func (r *Repository) CreateEmailVerificationToken(ctx context.Context, userID pgtype.UUID, token string, expiresAt time.Time) error {
+	return r.queries.CreateEmailVerificationToken(ctx, db.CreateEmailVerificationTokenParams{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: pgtype.Timestamptz{Time: expiresAt, Valid: true},
+	})
+}
+
+// VerifyEmail marks the email of the token's user verified and deletes the
+// token, so each token works once. An expired token is deleted as well, and
+// ErrVerificationTokenInvalid returned.
+func (r *Repository) VerifyEmail(ctx context.Context, token string) (db.User, error) {
+	var user db.User
+	expired := false
+	err := r.WithTx(ctx, func(tx *Repository) error {
+		t, err := tx.queries.DeleteEmailVerificationToken(ctx, token)
+		if err != nil {
+			return err
+		}
+		if !t.ExpiresAt.Time.After(time.Now()) {
+			expired = true
+			return nil
+		}
+		user, err = tx.queries.SetUserEmailVerified(ctx, t.UserID)
+		return err
+	})
+	if errors.Is(err, pgx.ErrNoRows) || err == nil && expired {
+		return db.User{}, ErrVerificationTokenInvalid
+	}
+	if err != nil {
+		return db.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,537 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepository connects to the database in TEST_DATABASE_URL, skipping
+// when it is unset, applies any pending migrations and creates a room with two users that are
+// removed again when the test ends
+func newTestRepository(tb testing.TB) (*Repository, db.Room, []db.User) {
+	tb.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		tb.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, url)
+	require.NoError(tb, err)
+	tb.Cleanup(pool.Close)
+	_, err = db.Migrate(ctx, pool)
+	require.NoError(tb, err)
+	repo := NewRepository(db.New(pool), pool)
+
+	suffix := uuid.New().String()[:8]
+	users := make([]db.User, 2)
+	for i := range users {
+		name := fmt.Sprintf("import-%d-%s", i, suffix)
+		users[i], err = repo.CreateUser(ctx, name, name+"@example.com", "hash")
+		require.NoError(tb, err)
+	}
+	room, err := repo.CreateRoom(ctx, "import-"+suffix, pgtype.Bool{Valid: true}, pgtype.Text{}, pgtype.UUID{}, false, pgtype.Int4{})
+	require.NoError(tb, err)
+
+	tb.Cleanup(func() {
+		repo.DeleteRoom(ctx, room.ID)
+		for _, user := range users {
+			pool.Exec(ctx, "DELETE FROM users WHERE id = $1", user.ID)
+		}
+	})
+	return repo, room, users
+}
+
+func TestBulkCreateMessages(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	params := make([]db.BulkCreateMessagesParams, 500)
+	for i := range params {
+		params[i] = db.BulkCreateMessagesParams{
+			RoomID:    room.ID,
+			UserID:    users[i%2].ID,
+			Content:   fmt.Sprintf("message %d", i),
+			CreatedAt: pgtype.Timestamptz{Time: start.Add(time.Duration(i) * time.Minute), Valid: true},
+		}
+	}
+
+	messages, err := repo.BulkCreateMessages(ctx, params)
+	require.NoError(t, err)
+	require.Len(t, messages, 500)
+	for _, msg := range messages {
+		assert.True(t, msg.ID.Valid)
+	}
+
+	rows, err := repo.ListMessagesByRoom(ctx, room.ID, 1000, 0)
+	require.NoError(t, err)
+	require.Len(t, rows, 500)
+	for i, row := range rows {
+		n := 499 - i // Newest first
+		assert.Equal(t, fmt.Sprintf("message %d", n), row.Content)
+		assert.True(t, start.Add(time.Duration(n)*time.Minute).Equal(row.CreatedAt.Time), "timestamp of message %d", n)
+		assert.Equal(t, users[n%2].Username, row.Username)
+		assert.Equal(t, messages[n].ID, row.ID)
+	}
+}
+
+func TestCreateMessages(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	// Below copyFromMinRows the batch goes through InsertMessages, above it COPY
+	for _, n := range []int{copyFromMinRows - 1, copyFromMinRows} {
+		msgs := make([]NewMessage, n)
+		for i := range msgs {
+			msgs[i] = NewMessage{RoomID: room.ID, UserID: users[i%2].ID, Content: fmt.Sprintf("batch %d message %d", n, i)}
+		}
+		require.NoError(t, repo.CreateMessages(ctx, msgs))
+
+		for _, msg := range msgs {
+			require.True(t, msg.ID.Valid, "IDs are filled in before the insert")
+			require.True(t, msg.CreatedAt.Valid)
+			stored, err := repo.GetMessageByID(ctx, msg.ID)
+			require.NoError(t, err)
+			assert.Equal(t, msg.Content, stored.Content)
+			assert.Equal(t, msg.UserID, stored.UserID)
+		}
+	}
+
+	// A reply keeps its parent, and a bad row stores nothing
+	parent := []NewMessage{{RoomID: room.ID, UserID: users[0].ID, Content: "parent"}}
+	require.NoError(t, repo.CreateMessages(ctx, parent))
+	reply := []NewMessage{{RoomID: room.ID, UserID: users[1].ID, Content: "reply", ParentMessageID: parent[0].ID}}
+	require.NoError(t, repo.CreateMessages(ctx, reply))
+	stored, err := repo.GetMessageByID(ctx, reply[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, parent[0].ID, stored.ParentMessageID)
+
+	err = repo.CreateMessages(ctx, []NewMessage{
+		{RoomID: room.ID, UserID: users[0].ID, Content: "fine"},
+		{RoomID: room.ID, UserID: pgtype.UUID{Bytes: uuid.New(), Valid: true}, Content: "no such user"},
+	})
+	require.Error(t, err)
+	rows, err := repo.ListMessagesByRoom(ctx, room.ID, 100, 0)
+	require.NoError(t, err)
+	assert.Len(t, rows, 2*copyFromMinRows-1+2)
+}
+
+func TestMessageOutbox(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+	params := db.CreateMessageParams{RoomID: room.ID, UserID: users[0].ID, Content: "hello"}
+
+	// A failed payload rolls back the message too
+	_, err := repo.CreateMessageWithOutbox(ctx, params, "room.outbox", func(db.Message) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	require.Error(t, err)
+	rows, err := repo.ListMessagesByRoom(ctx, room.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, rows)
+
+	msg, err := repo.CreateMessageWithOutbox(ctx, params, "room.outbox", func(m db.Message) ([]byte, error) {
+		return []byte(m.Content), nil
+	})
+	require.NoError(t, err)
+
+	// claim returns the entry for msg among any others pending in the database
+	claim := func() *db.MessageOutbox {
+		entries, err := repo.ClaimOutboxEntries(ctx, 1000, time.Minute)
+		require.NoError(t, err)
+		for _, entry := range entries {
+			if entry.MessageID == msg.ID {
+				return &entry
+			}
+		}
+		return nil
+	}
+
+	entry := claim()
+	require.NotNil(t, entry)
+	assert.Equal(t, "room.outbox", entry.Subject)
+	assert.Equal(t, []byte("hello"), entry.Payload)
+	assert.Nil(t, claim(), "a leased entry is not claimed again")
+
+	// A failure schedules a retry
+	require.NoError(t, repo.MarkOutboxFailed(ctx, entry.ID, "nats down", 0))
+	retried := claim()
+	require.NotNil(t, retried)
+	assert.Equal(t, int32(1), retried.Attempts)
+	assert.Equal(t, "nats down", retried.LastError.String)
+
+	require.NoError(t, repo.MarkOutboxSent(ctx, entry.ID))
+	require.NoError(t, repo.MarkOutboxFailed(ctx, entry.ID, "late", 0))
+	assert.Nil(t, claim(), "a sent entry is never claimed")
+}
+
+// Both benchmarks insert 1000 messages per iteration so they compare directly
+func BenchmarkBulkCreateMessages(b *testing.B) {
+	repo, room, users := newTestRepository(b)
+	ctx := context.Background()
+
+	params := make([]db.BulkCreateMessagesParams, 1000)
+	for i := range params {
+		params[i] = db.BulkCreateMessagesParams{RoomID: room.ID, UserID: users[0].ID, Content: "benchmark"}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := repo.BulkCreateMessages(ctx, params); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateMessage(b *testing.B) {
+	repo, room, users := newTestRepository(b)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			if _, err := repo.CreateMessage(ctx, room.ID, users[0].ID, "benchmark"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	repo, room, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	suffix := uuid.UUID(room.ID.Bytes).String()[:8]
+	for i := 1; i <= 5; i++ {
+		name := fmt.Sprintf("alice%d-%s", i, suffix)
+		user, err := repo.CreateUser(ctx, name, name+"@example.com", "hash")
+		require.NoError(t, err)
+		t.Cleanup(func() { repo.DeleteUser(ctx, user.ID) })
+	}
+
+	// Other tests' users may match too, so only count this test's
+	users, err := repo.SearchUsers(ctx, "alic", 1000)
+	require.NoError(t, err)
+	var found []string
+	for _, u := range users {
+		if strings.HasSuffix(u.Username, suffix) {
+			found = append(found, u.Username)
+		}
+	}
+	require.Len(t, found, 5)
+	assert.Equal(t, "alice1-"+suffix, found[0], "in name order")
+
+	users, err = repo.SearchUsers(ctx, "ALICE3-"+suffix, 10)
+	require.NoError(t, err)
+	require.Len(t, users, 1, "case is ignored")
+
+	// LIKE wildcards in the query match literally
+	users, err = repo.SearchUsers(ctx, "alice_-"+suffix, 10)
+	require.NoError(t, err)
+	assert.Empty(t, users)
+}
+
+func TestRoomMemberRoles(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddRoomMember(ctx, room.ID, users[0].ID, RoomRoleMember))
+	role, err := repo.GetRoomMemberRole(ctx, room.ID, users[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, RoomRoleMember, role)
+
+	// Role changes update the existing row, and rejoining keeps the role
+	require.NoError(t, repo.SetRoomMemberRole(ctx, room.ID, users[0].ID, RoomRoleModerator))
+	require.NoError(t, repo.AddRoomMember(ctx, room.ID, users[0].ID, RoomRoleMember))
+	members, err := repo.GetRoomMembersWithRoles(ctx, room.ID)
+	require.NoError(t, err)
+	require.Len(t, members, 1)
+	assert.Equal(t, RoomRoleModerator, members[0].Role)
+
+	require.NoError(t, repo.SetRoomMemberRole(ctx, room.ID, users[0].ID, RoomRoleMember))
+	role, err = repo.GetRoomMemberRole(ctx, room.ID, users[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, RoomRoleMember, role)
+
+	assert.ErrorIs(t, repo.SetRoomMemberRole(ctx, room.ID, users[0].ID, "owner"), ErrInvalidRoomRole)
+	assert.ErrorIs(t, repo.SetRoomMemberRole(ctx, room.ID, users[1].ID, RoomRoleModerator), pgx.ErrNoRows)
+	_, err = repo.GetRoomMemberRole(ctx, room.ID, users[1].ID)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestListLatestMessagesByRooms(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	vault, err := repo.CreateRoom(ctx, "vault-"+uuid.New().String()[:8], pgtype.Bool{Bool: true, Valid: true}, pgtype.Text{}, users[1].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.DeleteRoom(ctx, vault.ID) })
+	require.NoError(t, repo.AddRoomMember(ctx, vault.ID, users[1].ID, RoomRoleMember))
+
+	_, err = repo.CreateMessage(ctx, room.ID, users[0].ID, "older")
+	require.NoError(t, err)
+	latest, err := repo.CreateMessage(ctx, room.ID, users[1].ID, "newer")
+	require.NoError(t, err)
+	_, err = repo.CreateMessage(ctx, vault.ID, users[1].ID, "members only")
+	require.NoError(t, err)
+
+	rows, err := repo.ListLatestMessagesByRooms(ctx, []pgtype.UUID{room.ID, vault.ID}, users[0].ID)
+	require.NoError(t, err)
+	require.Len(t, rows, 1, "the private room is hidden from non-members")
+	assert.Equal(t, latest.ID, rows[0].MessageID)
+	assert.Equal(t, users[1].Username, rows[0].Username)
+
+	rows, err = repo.ListLatestMessagesByRooms(ctx, []pgtype.UUID{room.ID, vault.ID}, users[1].ID)
+	require.NoError(t, err)
+	assert.Len(t, rows, 2)
+}
+
+func TestDeleteExpiredMessages(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	archive, err := repo.CreateRoom(ctx, "archive-"+uuid.New().String()[:8], pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.DeleteRoom(ctx, archive.ID) })
+	require.NoError(t, repo.UpdateRoomRetentionDays(ctx, archive.ID, pgtype.Int4{Int32: 3650, Valid: true}))
+
+	// Dates well before any other test data, so the cutoff only reaches these
+	old := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	cutoff := old.AddDate(0, 1, 0)
+	var params []db.BulkCreateMessagesParams
+	for i := 0; i < 5; i++ {
+		params = append(params, db.BulkCreateMessagesParams{RoomID: room.ID, UserID: users[0].ID, Content: "old", CreatedAt: pgtype.Timestamptz{Time: old.Add(time.Duration(i) * time.Hour), Valid: true}})
+	}
+	params = append(params,
+		db.BulkCreateMessagesParams{RoomID: room.ID, UserID: users[0].ID, Content: "new", CreatedAt: pgtype.Timestamptz{Time: cutoff.Add(time.Hour), Valid: true}},
+		db.BulkCreateMessagesParams{RoomID: archive.ID, UserID: users[0].ID, Content: "kept", CreatedAt: pgtype.Timestamptz{Time: old, Valid: true}},
+	)
+	_, err = repo.BulkCreateMessages(ctx, params)
+	require.NoError(t, err)
+
+	// Another server running the cleanup holds the lock
+	err = repo.WithTx(ctx, func(tx *Repository) error {
+		locked, err := db.New(tx.txs.(pgx.Tx)).TryAdvisoryXactLock(ctx, retentionLockID)
+		require.NoError(t, err)
+		require.True(t, locked)
+
+		deleted, ran, err := repo.DeleteExpiredMessages(ctx, cutoff, 2)
+		require.NoError(t, err)
+		assert.False(t, ran)
+		assert.Zero(t, deleted)
+		return nil
+	})
+	require.NoError(t, err)
+
+	deleted, ran, err := repo.DeleteExpiredMessages(ctx, cutoff, 2)
+	require.NoError(t, err)
+	assert.True(t, ran)
+	assert.Equal(t, int64(5), deleted, "deleted in batches of 2")
+
+	rows, err := repo.ListMessagesByRoom(ctx, room.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "new", rows[0].Content)
+	count, err := repo.CountMessagesByRoom(ctx, archive.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "rooms with their own retention are skipped")
+}
+
+func TestSoftDeleteRoom(t *testing.T) {
+	repo, _, users := newTestRepository(t)
+	ctx := context.Background()
+
+	name := "attic-" + uuid.New().String()[:8]
+	attic, err := repo.CreateRoom(ctx, name, pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.DeleteRoom(ctx, attic.ID) })
+	_, err = repo.CreateMessage(ctx, attic.ID, users[0].ID, "kept")
+	require.NoError(t, err)
+
+	deleted, err := repo.SoftDeleteRoom(ctx, attic.ID)
+	require.NoError(t, err)
+	assert.True(t, deleted)
+	_, err = repo.GetRoomByName(ctx, name)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+	count, err := repo.CountMessagesByRoom(ctx, attic.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "messages outlive the soft delete")
+
+	// A new room may take the name; restoring the old one then conflicts
+	reused, err := repo.CreateRoom(ctx, name, pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	_, err = repo.RestoreRoom(ctx, attic.ID)
+	assert.ErrorIs(t, err, ErrRoomExists)
+	require.NoError(t, repo.DeleteRoom(ctx, reused.ID))
+
+	found, err := repo.GetDeletedRoomByName(ctx, name)
+	require.NoError(t, err)
+	assert.Equal(t, attic.ID, found.ID)
+	restored, err := repo.RestoreRoom(ctx, attic.ID)
+	require.NoError(t, err)
+	assert.False(t, restored.DeletedAt.Valid)
+
+	// Only rooms deleted before the cutoff are purged
+	_, err = repo.SoftDeleteRoom(ctx, attic.ID)
+	require.NoError(t, err)
+	_, err = repo.PurgeDeletedRooms(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	_, err = repo.GetDeletedRoomByName(ctx, name)
+	require.NoError(t, err, "recently deleted rooms are kept")
+	_, err = repo.PurgeDeletedRooms(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	_, err = repo.GetDeletedRoomByName(ctx, name)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+	count, err = repo.CountMessagesByRoom(ctx, attic.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func TestUsernamesIgnoreCase(t *testing.T) {
+	repo, _, users := newTestRepository(t)
+	ctx := context.Background()
+
+	upper := strings.ToUpper(users[0].Username)
+	found, err := repo.GetUserByUsername(ctx, upper)
+	require.NoError(t, err)
+	assert.Equal(t, users[0].ID, found.ID)
+	assert.Equal(t, users[0].Username, found.Username, "the registered casing is kept")
+
+	_, err = repo.CreateUser(ctx, upper, "upper-"+users[0].Email, "hash")
+	assert.ErrorIs(t, err, ErrUsernameTaken)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	repo, _, users := newTestRepository(t)
+	ctx := context.Background()
+	suffix := uuid.New().String()
+
+	assert.False(t, users[0].EmailVerified, "new users start unverified")
+	require.NoError(t, repo.CreateEmailVerificationToken(ctx, users[0].ID, "fresh-"+suffix, time.Now().Add(time.Hour)))
+	require.NoError(t, repo.CreateEmailVerificationToken(ctx, users[0].ID, "stale-"+suffix, time.Now().Add(-time.Minute)))
+
+	_, err := repo.VerifyEmail(ctx, "stale-"+suffix)
+	assert.ErrorIs(t, err, ErrVerificationTokenInvalid, "expired")
+	_, err = repo.VerifyEmail(ctx, "stale-"+suffix)
+	assert.ErrorIs(t, err, ErrVerificationTokenInvalid, "expired tokens are deleted")
+
+	user, err := repo.VerifyEmail(ctx, "fresh-"+suffix)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+	_, err = repo.VerifyEmail(ctx, "fresh-"+suffix)
+	assert.ErrorIs(t, err, ErrVerificationTokenInvalid, "tokens work once")
+}
+
+func TestFlaggedMessages(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	require.NoError(t, repo.CreateFlaggedMessage(ctx, room.ID, users[0].ID, users[0].Username, "darn it", []string{"darn"}))
+	rows, err := repo.ListFlaggedMessages(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Equal(t, room.Name, rows[0].RoomName)
+	assert.Equal(t, users[0].ID, rows[0].UserID)
+	assert.Equal(t, []string{"darn"}, rows[0].MatchedWords)
+
+	// Messages sent outside a room by anonymous users have neither
+	require.NoError(t, repo.CreateFlaggedMessage(ctx, pgtype.UUID{}, pgtype.UUID{}, "guest", "heck", []string{"heck"}))
+	rows, err = repo.ListFlaggedMessages(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	assert.Empty(t, rows[0].RoomName)
+	assert.False(t, rows[0].UserID.Valid)
+}
+
+func TestAnalyticsQueries(t *testing.T) {
+	repo, room, users := newTestRepository(t)
+	ctx := context.Background()
+
+	quiet, err := repo.CreateRoom(ctx, "quiet-"+uuid.New().String()[:8], pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.DeleteRoom(ctx, quiet.ID) })
+
+	// Dates well before any other test data, so the range only holds these
+	day := time.Date(2002, 6, 1, 23, 30, 0, 0, time.UTC)
+	at := func(t time.Time) pgtype.Timestamptz { return pgtype.Timestamptz{Time: t, Valid: true} }
+	_, err = repo.BulkCreateMessages(ctx, []db.BulkCreateMessagesParams{
+		{RoomID: room.ID, UserID: users[0].ID, Content: "one", CreatedAt: at(day)},
+		{RoomID: room.ID, UserID: users[0].ID, Content: "two", CreatedAt: at(day.Add(time.Hour))},
+		{RoomID: quiet.ID, UserID: users[1].ID, Content: "three", CreatedAt: at(day.Add(time.Hour))},
+	})
+	require.NoError(t, err)
+	from, to := day.Truncate(24*time.Hour), day.Truncate(24*time.Hour).AddDate(0, 0, 2)
+
+	perDay, err := repo.CountMessagesPerDay(ctx, from, to)
+	require.NoError(t, err)
+	require.Len(t, perDay, 2, "grouped by UTC date")
+	assert.Equal(t, int64(1), perDay[0].Messages)
+	assert.Equal(t, int64(2), perDay[1].Messages)
+
+	rooms, err := repo.ListMostActiveRooms(ctx, from, to, 1)
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, room.Name, rooms[0].Name)
+	assert.Equal(t, int64(2), rooms[0].Messages)
+
+	now := time.Now()
+	newUsers, err := repo.CountNewUsersPerDay(ctx, now.Add(-time.Minute), now.Add(time.Minute))
+	require.NoError(t, err)
+	require.NotEmpty(t, newUsers)
+	assert.GreaterOrEqual(t, newUsers[len(newUsers)-1].Users, int64(2))
+
+	suffix := uuid.New().String()[:8]
+	require.NoError(t, repo.CreateStatsSample(ctx, "a-"+suffix, 1000000))
+	require.NoError(t, repo.CreateStatsSample(ctx, "b-"+suffix, 2000000))
+	peaks, err := repo.PeakConnectionsPerDay(ctx, now.Add(-time.Minute), time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.NotEmpty(t, peaks)
+	assert.GreaterOrEqual(t, peaks[len(peaks)-1].PeakConnections, int64(2000000))
+}
+
+// BenchmarkCreateMessages compares storing a batch with one INSERT per
+// message against a single CreateMessages call, which uses COPY for batches
+// this size
+func BenchmarkCreateMessages(b *testing.B) {
+	repo, room, users := newTestRepository(b)
+	ctx := context.Background()
+
+	for _, size := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("per-row/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < size; j++ {
+					if _, err := repo.CreateMessage(ctx, room.ID, users[0].ID, "benchmark"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+			msgs := make([]NewMessage, size)
+			for i := 0; i < b.N; i++ {
+				for j := range msgs {
+					msgs[j] = NewMessage{RoomID: room.ID, UserID: users[0].ID, Content: "benchmark"}
+				}
+				if err := repo.CreateMessages(ctx, msgs); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
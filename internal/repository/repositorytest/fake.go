@@ -0,0 +1,14 @@
+// Package repositorytest provides an in-memory repository.Store for tests
+// that exercise persistence without Postgres
+package repositorytest
+
+import "websocket-demo/internal/repository/memory"
+
+// Fake is the in-memory store; tests use its helpers such as Messages to
+// check what was persisted
+type Fake = memory.Store
+
+// NewFake returns an empty store
+func NewFake() *Fake {
+	return memory.New()
+}
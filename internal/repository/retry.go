@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// RetryPolicy controls how hot-path writes are retried after transient
+// database errors such as a dropped connection during a failover
+type RetryPolicy struct {
+	Attempts int           // Total tries including the first; 1 turns retries off
+	Backoff  time.Duration // Delay before the first retry, doubled for each further one
+	MaxDelay time.Duration // Cap on the delay between tries
+	OnRetry  func()        // Called before each retry, e.g. to count retries in metrics
+}
+
+// DefaultRetryPolicy is used until SetRetryPolicy is called
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: 100 * time.Millisecond, MaxDelay: 2 * time.Second}
+
+// retryableCodes are the Postgres errors that mean the statement did not
+// take effect and may succeed when tried again. Class 08 (connection
+// exceptions) is matched separately.
+var retryableCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// SetRetryPolicy replaces the policy used to retry writes
+func (r *Repository) SetRetryPolicy(policy RetryPolicy) {
+	if policy.Attempts < 1 {
+		policy.Attempts = 1
+	}
+	r.retry = policy
+}
+
+// IsRetryable reports whether err is a transient database error after which
+// the statement can safely be tried again. Constraint violations and other
+// errors about the data itself never are.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return retryableCodes[pgErr.Code] || strings.HasPrefix(pgErr.Code, "08")
+	}
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	// The query failed before anything was sent to the server
+	return pgconn.SafeToRetry(err)
+}
+
+// withRetry runs op, trying again with exponential backoff while it fails
+// with a retryable error, until the policy's attempts run out or ctx is done
+func withRetry[T any](ctx context.Context, policy RetryPolicy, op func() (T, error)) (T, error) {
+	delay := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		result, err := op()
+		if err == nil || attempt >= policy.Attempts || !IsRetryable(err) {
+			return result, err
+		}
+
+		if policy.OnRetry != nil {
+			policy.OnRetry()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+		delay *= 2
+		if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// execWithRetry is withRetry for operations that only return an error
+func execWithRetry(ctx context.Context, policy RetryPolicy, op func() error) error {
+	_, err := withRetry(ctx, policy, func() (struct{}, error) {
+		return struct{}{}, op()
+	})
+	return err
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyQuerier fails its first `failures` calls with err, then succeeds.
+// Queries it doesn't override panic through the nil embedded Querier.
+type flakyQuerier struct {
+	db.Querier
+	failures int
+	err      error
+	calls    int
+}
+
+func (q *flakyQuerier) attempt() error {
+	q.calls++
+	if q.calls <= q.failures {
+		return q.err
+	}
+	return nil
+}
+
+func (q *flakyQuerier) CreateMessage(ctx context.Context, arg db.CreateMessageParams) (db.Message, error) {
+	if err := q.attempt(); err != nil {
+		return db.Message{}, err
+	}
+	return db.Message{RoomID: arg.RoomID, UserID: arg.UserID, Content: arg.Content}, nil
+}
+
+func (q *flakyQuerier) AddRoomMember(ctx context.Context, arg db.AddRoomMemberParams) (db.RoomMember, error) {
+	if err := q.attempt(); err != nil {
+		return db.RoomMember{}, err
+	}
+	return db.RoomMember{RoomID: arg.RoomID, UserID: arg.UserID}, nil
+}
+
+// newFlakyRepository returns a repository over a querier failing its first
+// `failures` calls with err, and a count of the retries it made
+func newFlakyRepository(failures int, err error) (*Repository, *flakyQuerier, *int) {
+	q := &flakyQuerier{failures: failures, err: err}
+	repo := NewRepository(q, nil)
+	retries := new(int)
+	repo.SetRetryPolicy(RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxDelay: 5 * time.Millisecond, OnRetry: func() { *retries++ }})
+	return repo, q, retries
+}
+
+var connectionFailure = &pgconn.PgError{Code: "08006", Message: "connection failure"}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection failure", connectionFailure, true},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, true},
+		{"deadlock", &pgconn.PgError{Code: "40P01"}, true},
+		{"server shutting down", &pgconn.PgError{Code: "57P01"}, true},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"foreign key violation", &pgconn.PgError{Code: "23503"}, false},
+		{"wrapped", errors.Join(errors.New("saving"), connectionFailure), true},
+		{"canceled", context.Canceled, false},
+		{"other", errors.New("boom"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestWritesRetryTransientErrors(t *testing.T) {
+	ctx := context.Background()
+	roomID := pgtype.UUID{Bytes: [16]byte{1}, Valid: true}
+	userID := pgtype.UUID{Bytes: [16]byte{2}, Valid: true}
+
+	repo, q, retries := newFlakyRepository(2, connectionFailure)
+	msg, err := repo.CreateMessage(ctx, roomID, userID, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", msg.Content)
+	assert.Equal(t, 3, q.calls)
+	assert.Equal(t, 2, *retries)
+
+	repo, q, retries = newFlakyRepository(1, connectionFailure)
+	require.NoError(t, repo.AddRoomMember(ctx, roomID, userID, RoomRoleMember))
+	assert.Equal(t, 2, q.calls)
+	assert.Equal(t, 1, *retries)
+
+	// Giving up returns the last error
+	repo, q, _ = newFlakyRepository(5, connectionFailure)
+	_, err = repo.CreateMessage(ctx, roomID, userID, "hello")
+	assert.ErrorIs(t, err, connectionFailure)
+	assert.Equal(t, 3, q.calls)
+}
+
+func TestWritesDoNotRetryConstraintViolations(t *testing.T) {
+	repo, q, retries := newFlakyRepository(1, &pgconn.PgError{Code: "23503", Message: "room does not exist"})
+	err := repo.AddRoomMember(context.Background(), pgtype.UUID{}, pgtype.UUID{}, RoomRoleMember)
+	require.Error(t, err)
+	assert.Equal(t, 1, q.calls)
+	assert.Zero(t, *retries)
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	repo, q, _ := newFlakyRepository(5, connectionFailure)
+	repo.SetRetryPolicy(RetryPolicy{Attempts: 5, Backoff: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := repo.CreateMessage(ctx, pgtype.UUID{}, pgtype.UUID{}, "hello")
+	assert.ErrorIs(t, err, connectionFailure)
+	assert.Equal(t, 1, q.calls)
+}
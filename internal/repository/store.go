@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"websocket-demo/internal/db"
+)
+
+// Store is the persistence the hub and server use. Repository implements it
+// on Postgres and memory.Store in memory, for development with STORAGE=memory
+// and for tests. Lookups of missing rows return pgx.ErrNoRows.
+type Store interface {
+	// Users
+	CreateUser(ctx context.Context, username, email, passwordHash string) (db.User, error)
+	GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error)
+	GetUserByUsername(ctx context.Context, username string) (db.User, error)
+	GetUserByEmail(ctx context.Context, email string) (db.User, error)
+	SearchUsers(ctx context.Context, query string, limit int) ([]db.User, error)
+	UpdateUserLastLogin(ctx context.Context, id pgtype.UUID, lastLogin pgtype.Timestamptz) (db.User, error)
+	UpdateUserPassword(ctx context.Context, id pgtype.UUID, passwordHash string) (db.User, error)
+	DeleteUser(ctx context.Context, id pgtype.UUID) (bool, error)
+	CreateEmailVerificationToken(ctx context.Context, userID pgtype.UUID, token string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, token string) (db.User, error)
+
+	// Rooms and members
+	CreateRoom(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error)
+	CreateRoomWithCreator(ctx context.Context, name string, private pgtype.Bool, passwordHash pgtype.Text, creatorID pgtype.UUID, suppressJoinLeave bool, maxClients pgtype.Int4) (db.Room, error)
+	GetRoomByID(ctx context.Context, id pgtype.UUID) (db.Room, error)
+	GetRoomByName(ctx context.Context, name string) (db.Room, error)
+	GetAllRooms(ctx context.Context) ([]db.Room, error)
+	GetRoomsByNames(ctx context.Context, names []string) ([]db.Room, error)
+	CountRooms(ctx context.Context, excludedName string) (int64, error)
+	CountRoomsByCreator(ctx context.Context, creatorID pgtype.UUID) (int64, error)
+	UpdateRoomSuppressJoinLeave(ctx context.Context, id pgtype.UUID, suppress bool) error
+	UpdateRoomRetentionDays(ctx context.Context, id pgtype.UUID, days pgtype.Int4) error
+	UpdateRoomPassword(ctx context.Context, id pgtype.UUID, passwordHash string) error
+	DeleteRoom(ctx context.Context, id pgtype.UUID) error
+	SoftDeleteRoom(ctx context.Context, id pgtype.UUID) (bool, error)
+	GetDeletedRoomByName(ctx context.Context, name string) (db.Room, error)
+	ListDeletedRooms(ctx context.Context) ([]db.Room, error)
+	RestoreRoom(ctx context.Context, id pgtype.UUID) (db.Room, error)
+	PurgeDeletedRooms(ctx context.Context, cutoff time.Time) (int64, error)
+	AddRoomMember(ctx context.Context, roomID, userID pgtype.UUID, role string) error
+	SetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID, role string) error
+	GetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID) (string, error)
+	RemoveRoomMember(ctx context.Context, roomID, userID pgtype.UUID) error
+	GetRoomMembers(ctx context.Context, roomID pgtype.UUID) ([]db.GetRoomMembersRow, error)
+	GetRoomMembersWithRoles(ctx context.Context, roomID pgtype.UUID) ([]db.GetRoomMembersWithRolesRow, error)
+	GetRoomMemberCount(ctx context.Context, roomID pgtype.UUID) (int64, error)
+	GetRoomMemberCounts(ctx context.Context, roomIDs []pgtype.UUID) ([]db.GetRoomMemberCountsRow, error)
+	MarkRoomRead(ctx context.Context, roomID, userID pgtype.UUID) error
+	CreateRoomInvite(ctx context.Context, roomID, inviterID, inviteeID pgtype.UUID) (db.RoomInvite, error)
+	ListUserRoomSummaries(ctx context.Context, userID pgtype.UUID) ([]db.ListUserRoomSummariesRow, error)
+
+	// Messages
+	CreateMessage(ctx context.Context, roomID, userID pgtype.UUID, content string) (db.Message, error)
+	CreateReplyMessage(ctx context.Context, roomID, userID, parentID pgtype.UUID, content string) (db.Message, error)
+	CreateMessageWithOutbox(ctx context.Context, params db.CreateMessageParams, subject string, payload func(db.Message) ([]byte, error)) (db.Message, error)
+	BulkCreateMessages(ctx context.Context, params []db.BulkCreateMessagesParams) ([]db.Message, error)
+	CreateMessages(ctx context.Context, msgs []NewMessage) error
+	GetMessageByID(ctx context.Context, id pgtype.UUID) (db.Message, error)
+	UpdateMessageContent(ctx context.Context, id pgtype.UUID, content string) (db.Message, error)
+	DeleteMessage(ctx context.Context, id pgtype.UUID) (bool, error)
+	DeleteExpiredMessages(ctx context.Context, cutoff time.Time, batchSize int32) (deleted int64, ran bool, err error)
+	CountMessagesByRoom(ctx context.Context, roomID pgtype.UUID) (int64, error)
+	ListMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit, offset int32) ([]db.ListMessagesByRoomRow, error)
+	ListLatestMessagesByRooms(ctx context.Context, roomIDs []pgtype.UUID, viewerID pgtype.UUID) ([]db.ListLatestMessagesByRoomsRow, error)
+	ListRecentMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit int32) ([]db.ListRecentMessagesByRoomRow, error)
+	ListRoomExportPage(ctx context.Context, roomID pgtype.UUID, beforeCreatedAt time.Time, beforeID pgtype.UUID, pageSize int32) ([]db.ListRoomExportPageRow, error)
+	ListRoomTranscript(ctx context.Context, roomID pgtype.UUID, afterCreatedAt time.Time, afterID pgtype.UUID, pageSize int32) ([]db.ListRoomTranscriptRow, error)
+	ListThreadMessages(ctx context.Context, parentID pgtype.UUID, limit int32) ([]db.ListThreadMessagesRow, error)
+
+	// Pins
+	PinMessage(ctx context.Context, roomID, messageID, userID pgtype.UUID) error
+	UnpinMessage(ctx context.Context, roomID, messageID pgtype.UUID) (bool, error)
+	ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]db.ListPinnedMessagesRow, error)
+
+	// Polls
+	CreatePoll(ctx context.Context, roomID, creatorID pgtype.UUID, question string, options []string, endsAt pgtype.Timestamptz) (db.Poll, error)
+	GetPollByID(ctx context.Context, id pgtype.UUID) (db.Poll, error)
+	UpsertPollVote(ctx context.Context, pollID, userID pgtype.UUID, optionIndex int32) error
+	GetPollVoteCounts(ctx context.Context, pollID pgtype.UUID) ([]db.GetPollVoteCountsRow, error)
+	ListEndedPolls(ctx context.Context) ([]db.Poll, error)
+	ClosePoll(ctx context.Context, id pgtype.UUID) (bool, error)
+
+	// Flagged messages
+	CreateFlaggedMessage(ctx context.Context, roomID, userID pgtype.UUID, username, content string, matchedWords []string) error
+	ListFlaggedMessages(ctx context.Context, limit int32) ([]db.ListFlaggedMessagesRow, error)
+
+	// Analytics
+	CountMessagesPerDay(ctx context.Context, from, to time.Time) ([]db.CountMessagesPerDayRow, error)
+	CountNewUsersPerDay(ctx context.Context, from, to time.Time) ([]db.CountNewUsersPerDayRow, error)
+	ListMostActiveRooms(ctx context.Context, from, to time.Time, limit int32) ([]db.ListMostActiveRoomsRow, error)
+	PeakConnectionsPerDay(ctx context.Context, from, to time.Time) ([]db.PeakConnectionsPerDayRow, error)
+	CreateStatsSample(ctx context.Context, serverID string, peakConnections int32) error
+	DeleteStatsSamplesBefore(ctx context.Context, cutoff time.Time) (int64, error)
+
+	// Message outbox
+	ClaimOutboxEntries(ctx context.Context, limit int32, lease time.Duration) ([]db.MessageOutbox, error)
+	MarkOutboxSent(ctx context.Context, id int64) error
+	MarkOutboxFailed(ctx context.Context, id int64, errText string, retry time.Duration) error
+	DeleteSentOutboxEntriesBefore(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+var _ Store = (*Repository)(nil)
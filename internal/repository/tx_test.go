@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTx records how a transaction ended. Queries panic through the nil
+// embedded Tx.
+type fakeTx struct {
+	pgx.Tx
+	committed  bool
+	rolledBack bool
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if !tx.committed {
+		tx.rolledBack = true
+	}
+	return nil
+}
+
+// fakeTxBeginner hands out fakeTxs, keeping the last one
+type fakeTxBeginner struct {
+	last *fakeTx
+}
+
+func (b *fakeTxBeginner) Begin(ctx context.Context) (pgx.Tx, error) {
+	b.last = &fakeTx{}
+	return b.last, nil
+}
+
+func TestWithTxCommitsOrRollsBack(t *testing.T) {
+	ctx := context.Background()
+	txs := &fakeTxBeginner{}
+	repo := NewRepository(nil, txs)
+
+	require.NoError(t, repo.WithTx(ctx, func(tx *Repository) error {
+		assert.NotSame(t, repo, tx)
+		return nil
+	}))
+	assert.True(t, txs.last.committed)
+	assert.False(t, txs.last.rolledBack)
+
+	boom := errors.New("boom")
+	assert.ErrorIs(t, repo.WithTx(ctx, func(tx *Repository) error { return boom }), boom)
+	assert.False(t, txs.last.committed)
+	assert.True(t, txs.last.rolledBack)
+
+	noTxs := NewRepository(nil, nil)
+	assert.ErrorIs(t, noTxs.WithTx(ctx, func(tx *Repository) error { return nil }), ErrNoTransactions)
+}
+
+func TestWithTxRollbackLeavesNoPartialRows(t *testing.T) {
+	repo, _, users := newTestRepository(t)
+	ctx := context.Background()
+	name := "tx-" + uuid.New().String()[:8]
+
+	boom := errors.New("boom")
+	err := repo.WithTx(ctx, func(tx *Repository) error {
+		room, err := tx.CreateRoom(ctx, name, pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+		require.NoError(t, err)
+		require.NoError(t, tx.AddRoomMember(ctx, room.ID, users[0].ID, RoomRoleMember))
+		return boom
+	})
+	assert.ErrorIs(t, err, boom)
+
+	_, err = repo.GetRoomByName(ctx, name)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	// A failing statement after the room insert rolls the room back too
+	err = repo.WithTx(ctx, func(tx *Repository) error {
+		room, err := tx.CreateRoom(ctx, name, pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+		require.NoError(t, err)
+		return tx.AddRoomMember(ctx, room.ID, pgtype.UUID{Bytes: uuid.New(), Valid: true}, RoomRoleMember)
+	})
+	require.Error(t, err)
+	_, err = repo.GetRoomByName(ctx, name)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+}
+
+func TestCreateRoomWithCreator(t *testing.T) {
+	repo, _, users := newTestRepository(t)
+	ctx := context.Background()
+
+	room, err := repo.CreateRoomWithCreator(ctx, "owned-"+uuid.New().String()[:8], pgtype.Bool{Valid: true}, pgtype.Text{}, users[0].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	t.Cleanup(func() { repo.DeleteRoom(ctx, room.ID) })
+	isMember, err := repo.IsRoomMember(ctx, room.ID, users[0].ID)
+	require.NoError(t, err)
+	assert.True(t, isMember)
+}
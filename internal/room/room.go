@@ -5,11 +5,13 @@ import (
 	"time"
 
 	"websocket-demo/internal/client"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
 )
 
 // Room represents a chat room
 type Room struct {
-	ID         string // Database ID
+	ID         string // Database ID; set with SetID and read with GetID once the room is shared
 	Name       string
 	Clients    map[*client.Client]bool
 	Mutex      sync.RWMutex
@@ -19,23 +21,45 @@ type Room struct {
 	MaxClients int
 	Active     bool
 	Creator    *client.Client
+
+	SuppressJoinLeaveMessages bool // Skip "has joined/left" notifications
+
+	alert      *Alert                // Moderation banner; nil when there is none
+	roles      map[string]string     // Member roles by user ID, as stored in room_members
+	linkPolicy *validator.LinkPolicy // Links allowed on top of the server's policy; nil when the room has none
+	emptySince time.Time             // When the last client left; zero while the room has clients
+}
+
+// Alert is a moderation notice members see as a banner until it is cleared
+type Alert struct {
+	Text  string    `json:"text"`
+	SetBy string    `json:"set_by"`
+	SetAt time.Time `json:"set_at"`
 }
 
 // NewRoom creates a new room instance
 func NewRoom(name string, private bool, password string, maxClients int) *Room {
+	now := time.Now()
 	return &Room{
 		Name:       name,
 		Clients:    make(map[*client.Client]bool),
-		Created:    time.Now(),
+		Created:    now,
 		Private:    private,
 		Password:   password,
 		MaxClients: maxClients,
 		Active:     true,
+		emptySince: now,
 	}
 }
 
-// AddClient adds a client to the room
+// AddClient adds a client to the room, joining now
 func (r *Room) AddClient(client *client.Client) bool {
+	return r.AddClientWithTimestamp(client, time.Now())
+}
+
+// AddClientWithTimestamp adds a client to the room with the given join time,
+// so a restored session keeps its original one
+func (r *Room) AddClientWithTimestamp(client *client.Client, joinedAt time.Time) bool {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
 
@@ -43,7 +67,9 @@ func (r *Room) AddClient(client *client.Client) bool {
 		return false
 	}
 
+	client.SetJoinedAt(joinedAt)
 	r.Clients[client] = true
+	r.emptySince = time.Time{}
 	return true
 }
 
@@ -51,7 +77,24 @@ func (r *Room) AddClient(client *client.Client) bool {
 func (r *Room) RemoveClient(client *client.Client) {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
+	if !r.Clients[client] {
+		return
+	}
 	delete(r.Clients, client)
+	if len(r.Clients) == 0 {
+		r.emptySince = time.Now()
+	}
+}
+
+// EmptySince returns when the last client left the room, or when it was
+// created if nobody joined since, and false while it has clients
+func (r *Room) EmptySince() (time.Time, bool) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	if len(r.Clients) > 0 {
+		return time.Time{}, false
+	}
+	return r.emptySince, true
 }
 
 // GetClientCount returns the number of clients in the room
@@ -61,6 +104,13 @@ func (r *Room) GetClientCount() int {
 	return len(r.Clients)
 }
 
+// HasClient reports whether a client is in the room
+func (r *Room) HasClient(client *client.Client) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Clients[client]
+}
+
 // GetClients returns a copy of the clients map
 func (r *Room) GetClients() []*client.Client {
 	r.Mutex.RLock()
@@ -73,6 +123,82 @@ func (r *Room) GetClients() []*client.Client {
 	return clients
 }
 
+// SetSuppressJoinLeave toggles join/leave notifications for the room
+func (r *Room) SetSuppressJoinLeave(suppress bool) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	r.SuppressJoinLeaveMessages = suppress
+}
+
+// SuppressesJoinLeave reports whether join/leave notifications are disabled
+func (r *Room) SuppressesJoinLeave() bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.SuppressJoinLeaveMessages
+}
+
+// SetAlert replaces the room's alert; nil clears it
+func (r *Room) SetAlert(alert *Alert) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if alert != nil {
+		copied := *alert
+		alert = &copied
+	}
+	r.alert = alert
+}
+
+// GetAlert returns a copy of the room's alert, or nil if there is none
+func (r *Room) GetAlert() *Alert {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	if r.alert == nil {
+		return nil
+	}
+	copied := *r.alert
+	return &copied
+}
+
+// SetLinkPolicy replaces the room's own link policy; nil removes it
+func (r *Room) SetLinkPolicy(policy *validator.LinkPolicy) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if policy != nil {
+		copied := *policy
+		policy = &copied
+	}
+	r.linkPolicy = policy
+}
+
+// GetLinkPolicy returns a copy of the room's own link policy, or nil if it
+// has none
+func (r *Room) GetLinkPolicy() *validator.LinkPolicy {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	if r.linkPolicy == nil {
+		return nil
+	}
+	copied := *r.linkPolicy
+	return &copied
+}
+
+// SetMemberRole records the role of the member with userID
+func (r *Room) SetMemberRole(userID, role string) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if r.roles == nil {
+		r.roles = make(map[string]string)
+	}
+	r.roles[userID] = role
+}
+
+// MemberRole returns the role of the member with userID, or "" if it isn't known
+func (r *Room) MemberRole(userID string) string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.roles[userID]
+}
+
 // IsCreator checks if the given client is the creator of the room
 func (r *Room) IsCreator(client *client.Client) bool {
 	r.Mutex.RLock()
@@ -80,6 +206,13 @@ func (r *Room) IsCreator(client *client.Client) bool {
 	return r.Creator == client
 }
 
+// IsCreatedBy reports whether the room's creator is a session of userID
+func (r *Room) IsCreatedBy(userID string) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.Creator != nil && userID != "" && r.Creator.UserID == userID
+}
+
 // SetCreator sets the creator of the room
 func (r *Room) SetCreator(client *client.Client) {
 	r.Mutex.Lock()
@@ -87,7 +220,25 @@ func (r *Room) SetCreator(client *client.Client) {
 	r.Creator = client
 }
 
+var _ types.Roomer = (*Room)(nil)
+
 // GetName returns the name of the room
 func (r *Room) GetName() string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
 	return r.Name
 }
+
+// GetID returns the room's database ID, empty while it has none
+func (r *Room) GetID() string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+	return r.ID
+}
+
+// SetID records the room's database ID once it has been stored
+func (r *Room) SetID(id string) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	r.ID = id
+}
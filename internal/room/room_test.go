@@ -3,9 +3,12 @@ package room
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"websocket-demo/internal/client"
+	"websocket-demo/internal/types"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -43,6 +46,33 @@ func TestAddClient(t *testing.T) {
 	assert.Equal(t, 2, room.GetClientCount())
 }
 
+func TestAddClientWithTimestamp(t *testing.T) {
+	room := NewRoom("test-room", false, "", 2)
+
+	restored := &client.Client{Name: "Restored"}
+	fresh := &client.Client{Name: "Fresh"}
+	late := &client.Client{Name: "Late"}
+
+	// A restored session keeps its original join time
+	joinedAt := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	assert.True(t, room.AddClientWithTimestamp(restored, joinedAt))
+
+	// AddClient joins now
+	before := time.Now()
+	assert.True(t, room.AddClient(fresh))
+	assert.False(t, fresh.GetJoinedAt().Before(before))
+
+	for _, c := range room.GetClients() {
+		if c == restored {
+			assert.Equal(t, joinedAt, c.GetJoinedAt())
+		}
+	}
+
+	// A client turned away from a full room keeps no join time
+	assert.False(t, room.AddClientWithTimestamp(late, joinedAt))
+	assert.True(t, late.GetJoinedAt().IsZero())
+}
+
 func TestRemoveClient(t *testing.T) {
 	room := NewRoom("test-room", false, "", 100)
 
@@ -63,6 +93,35 @@ func TestRemoveClient(t *testing.T) {
 	assert.Equal(t, 1, room.GetClientCount())
 }
 
+func TestEmptySince(t *testing.T) {
+	room := NewRoom("test-room", false, "", 100)
+	since, empty := room.EmptySince()
+	assert.True(t, empty)
+	assert.Equal(t, room.Created, since, "a new room is empty from its creation")
+
+	client1 := &client.Client{Name: "Client1"}
+	client2 := &client.Client{Name: "Client2"}
+	room.AddClient(client1)
+	room.AddClient(client2)
+	_, empty = room.EmptySince()
+	assert.False(t, empty)
+
+	room.RemoveClient(client1)
+	_, empty = room.EmptySince()
+	assert.False(t, empty)
+
+	before := time.Now()
+	room.RemoveClient(client2)
+	since, empty = room.EmptySince()
+	assert.True(t, empty)
+	assert.False(t, since.Before(before))
+
+	// Removing a client that already left doesn't move the time
+	room.RemoveClient(client2)
+	again, _ := room.EmptySince()
+	assert.Equal(t, since, again)
+}
+
 func TestGetClients(t *testing.T) {
 	room := NewRoom("test-room", false, "", 100)
 
@@ -116,4 +175,125 @@ func TestConcurrentAccess(t *testing.T) {
 
 	wg.Wait()
 	assert.Equal(t, 0, room.GetClientCount())
-}
\ No newline at end of file
+}
+
+func TestAddClientAtCapacity(t *testing.T) {
+	room := NewRoom("test-room", false, "", 10)
+
+	var wg sync.WaitGroup
+	var added, refused atomic.Int32
+	const numGoroutines = 100
+
+	// Only MaxClients of the concurrent joins may succeed
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if room.AddClient(&client.Client{Name: fmt.Sprintf("Client%d", id)}) {
+				added.Add(1)
+			} else {
+				refused.Add(1)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Equal(t, int32(10), added.Load())
+	assert.Equal(t, int32(90), refused.Load())
+	assert.Equal(t, 10, room.GetClientCount())
+}
+
+func TestRemoveAndAddRace(t *testing.T) {
+	room := NewRoom("test-room", false, "", 10)
+	shared := &client.Client{Name: "Shared"}
+
+	var wg sync.WaitGroup
+	var badCount atomic.Int32
+	const numGoroutines = 50
+	deadline := time.Now().Add(500 * time.Millisecond)
+
+	// Every goroutine joins and leaves with the same client, so the room
+	// holds it at most once
+	for i := 0; i < numGoroutines; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for n := 0; time.Now().Before(deadline); n++ {
+				if (id+n)%2 == 0 {
+					room.AddClient(shared)
+				} else {
+					room.RemoveClient(shared)
+				}
+				if count := room.GetClientCount(); count < 0 || count > 1 {
+					badCount.Store(int32(count))
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	assert.Zero(t, badCount.Load(), "client count left 0..1")
+	room.RemoveClient(shared)
+	assert.Equal(t, 0, room.GetClientCount())
+}
+
+func TestGetClientsIsACopy(t *testing.T) {
+	room := NewRoom("test-room", false, "", 100)
+
+	client1 := &client.Client{Name: "Client1"}
+	client2 := &client.Client{Name: "Client2"}
+	room.AddClient(client1)
+	room.AddClient(client2)
+
+	// Overwriting and truncating the returned slice leaves the room alone
+	clients := room.GetClients()
+	clients[0] = &client.Client{Name: "Intruder"}
+	clients = clients[:0]
+	assert.Empty(t, clients)
+
+	assert.Equal(t, 2, room.GetClientCount())
+	assert.True(t, room.HasClient(client1))
+	assert.True(t, room.HasClient(client2))
+	assert.ElementsMatch(t, []*client.Client{client1, client2}, room.GetClients())
+}
+
+func TestAlert(t *testing.T) {
+	room := NewRoom("test-room", false, "", 100)
+	assert.Nil(t, room.GetAlert())
+
+	alert := &Alert{Text: "Be kind", SetBy: "mod", SetAt: time.Now()}
+	room.SetAlert(alert)
+	alert.Text = "changed"
+	got := room.GetAlert()
+	assert.Equal(t, "Be kind", got.Text, "the room keeps its own copy")
+	got.Text = "changed again"
+	assert.Equal(t, "Be kind", room.GetAlert().Text)
+
+	room.SetAlert(nil)
+	assert.Nil(t, room.GetAlert())
+}
+
+// Run with -race: GetName and GetID share the room lock with SetID
+func TestIdentityAccessorsConcurrent(t *testing.T) {
+	room := NewRoom("test-room", false, "", 100)
+	var roomer types.Roomer = room
+
+	var wg sync.WaitGroup
+	for i := range 100 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%2 == 0 {
+				room.SetID(fmt.Sprintf("room-%d", i))
+				return
+			}
+			assert.Equal(t, "test-room", roomer.GetName())
+			_ = roomer.GetID()
+		}()
+	}
+	wg.Wait()
+
+	assert.Regexp(t, `^room-\d+$`, room.GetID())
+	room.SetID("")
+	assert.Empty(t, room.GetID())
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/validator"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accountDeletedReason is the close reason sent to a deleted user's connections
+const accountDeletedReason = "account deleted"
+
+// accountStore is the subset of the repository used by the account endpoints
+type accountStore interface {
+	GetUserByID(ctx context.Context, id pgtype.UUID) (db.User, error)
+	UpdateUserPassword(ctx context.Context, id pgtype.UUID, passwordHash string) (db.User, error)
+	DeleteUser(ctx context.Context, id pgtype.UUID) (bool, error)
+}
+
+// ChangePasswordRequest replaces the user's password, confirmed with the
+// current one
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// ChangePassword handles PUT /api/profile/password. The new password must
+// pass the same strength check as at registration.
+func (s *Server) ChangePassword(c echo.Context) error {
+	if s.accounts == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Password change is not available"})
+	}
+
+	userID := GetUserID(c)
+	var id pgtype.UUID
+	if err := id.Scan(userID); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+	}
+
+	var req ChangePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.CurrentPassword == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Current password is required"})
+	}
+
+	ctx := c.Request().Context()
+	user, err := s.accounts.GetUserByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if err != nil {
+		log.Printf("Failed to load user %s for password change: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to change password"})
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid password"})
+	}
+	if err := s.validator.ValidateUserPassword(req.NewPassword, user.Username, user.Email); err != nil {
+		return validationFailed(c, []validator.ValidationError{err.(validator.ValidationError)})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+	}
+	if _, err := s.accounts.UpdateUserPassword(ctx, id, string(hashedPassword)); err != nil {
+		log.Printf("Failed to update password of user %s: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to change password"})
+	}
+
+	s.audit.LogPasswordChange(ctx, userID, user.Username, GetClientIP(c), GetUserAgent(c))
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password changed"})
+}
+
+// DeleteAccountRequest confirms an account deletion with the user's password
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccount handles DELETE /api/profile. The user's messages, room
+// memberships and poll votes are deleted with the account, and their open
+// connections on every server are closed.
+func (s *Server) DeleteAccount(c echo.Context) error {
+	if s.accounts == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Account deletion is not available"})
+	}
+
+	userID := GetUserID(c)
+	var id pgtype.UUID
+	if err := id.Scan(userID); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+	}
+
+	var req DeleteAccountRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.Password == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Password is required"})
+	}
+
+	ctx := c.Request().Context()
+	user, err := s.accounts.GetUserByID(ctx, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+	if err != nil {
+		log.Printf("Failed to load user %s for deletion: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete account"})
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid password"})
+	}
+
+	deleted, err := s.accounts.DeleteUser(ctx, id)
+	if err != nil {
+		log.Printf("Failed to delete user %s: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete account"})
+	}
+	if !deleted {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	s.csrf.RevokeUserTokens(userID)
+	closed := s.hub.DisconnectUser(userID, accountDeletedReason)
+	s.audit.LogAccountDelete(ctx, userID, user.Username, closed, GetClientIP(c), GetUserAgent(c))
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Account deleted"})
+}
+
+// accountExists reports whether the user behind a token still exists, so a
+// deleted user's unexpired token can't open new connections
+func (s *Server) accountExists(ctx context.Context, userID string) bool {
+	if s.accounts == nil {
+		return true
+	}
+	var id pgtype.UUID
+	if err := id.Scan(userID); err != nil {
+		return false
+	}
+	_, err := s.accounts.GetUserByID(ctx, id)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		// Don't lock everyone out while the database is unavailable
+		log.Printf("Failed to look up user %s: %v", userID, err)
+		return true
+	}
+	return err == nil
+}
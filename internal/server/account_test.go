@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository/repositorytest"
+	"websocket-demo/internal/validator"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	store := repositorytest.NewFake()
+	user, err := store.CreateUser(ctx, "leaver", "leaver@example.com", string(hash))
+	require.NoError(t, err)
+	userID := uuid.UUID(user.ID.Bytes)
+
+	server := newTestServer(h)
+	server.accounts = store
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	token := generateTestJWTFor(t, userID.String(), "leaver")
+	dialWS := func() (*websocket.Conn, *http.Response, error) {
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+token)
+		return websocket.Dial(context.Background(), "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws",
+			&websocket.DialOptions{HTTPHeader: header})
+	}
+	conn, _, err := dialWS()
+	require.NoError(t, err)
+	defer conn.CloseNow()
+
+	csrfToken := func() string {
+		req, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/csrf-token", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		require.NotEmpty(t, body["csrf_token"])
+		return body["csrf_token"]
+	}
+	deleteAccount := func(csrf, body string) int {
+		req, _ := http.NewRequest(http.MethodDelete, testServer.URL+"/api/profile", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		if csrf != "" {
+			req.Header.Set("X-CSRF-Token", csrf)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	csrf := csrfToken()
+	assert.Equal(t, http.StatusForbidden, deleteAccount("", `{"password":"correct horse"}`), "missing CSRF token")
+	assert.Equal(t, http.StatusForbidden, deleteAccount("bogus", `{"password":"correct horse"}`), "unknown CSRF token")
+	assert.Equal(t, http.StatusBadRequest, deleteAccount(csrf, `{}`), "missing password")
+	assert.Equal(t, http.StatusUnauthorized, deleteAccount(csrf, `{"password":"wrong"}`), "wrong password")
+
+	// A CSRF token is bound to the user it was issued to
+	otherCSRF := server.csrf.GenerateToken(uuid.New().String(), "", "")
+	assert.Equal(t, http.StatusForbidden, deleteAccount(otherCSRF, `{"password":"correct horse"}`))
+
+	assert.Equal(t, http.StatusOK, deleteAccount(csrf, `{"password":"correct horse"}`))
+	_, err = store.GetUserByID(context.Background(), user.ID)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	// The open connection is closed
+	readCtx, readCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readCancel()
+	var readErr error
+	for readErr == nil {
+		_, _, readErr = conn.Read(readCtx)
+	}
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(readErr))
+
+	// The CSRF token was revoked, and the still-valid JWT can't reconnect
+	assert.Equal(t, http.StatusForbidden, deleteAccount(csrf, `{"password":"correct horse"}`))
+	assert.Equal(t, http.StatusNotFound, deleteAccount(csrfToken(), `{"password":"correct horse"}`))
+	_, resp, err := dialWS()
+	require.Error(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestChangePassword(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	store := repositorytest.NewFake()
+	user, err := store.CreateUser(ctx, "marigold", "gardener@example.com", string(hash))
+	require.NoError(t, err)
+	userID := uuid.UUID(user.ID.Bytes)
+
+	server := newTestServer(h)
+	server.accounts = store
+	server.SetupRoutes()
+	token := generateTestJWTFor(t, userID.String(), "marigold")
+
+	csrfReq := httptest.NewRequest(http.MethodGet, "/api/csrf-token", nil)
+	csrfReq.Header.Set("Authorization", "Bearer "+token)
+	csrfRec := httptest.NewRecorder()
+	server.echo.ServeHTTP(csrfRec, csrfReq)
+	require.Equal(t, http.StatusOK, csrfRec.Code)
+	var csrf map[string]string
+	require.NoError(t, json.NewDecoder(csrfRec.Body).Decode(&csrf))
+
+	changePassword := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/profile/password", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-CSRF-Token", csrf["csrf_token"])
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		return rec
+	}
+	refusedFor := func(rec *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+		var body ValidationFailedResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		require.Len(t, body.Errors, 1)
+		return body.Errors[0].Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, changePassword(`{"new_password":"zq8r7mvp"}`).Code, "missing current password")
+	assert.Equal(t, http.StatusUnauthorized, changePassword(`{"current_password":"wrong","new_password":"zq8r7mvp"}`).Code)
+	assert.Equal(t, validator.PasswordReasonSimilarToUsername,
+		refusedFor(changePassword(`{"current_password":"correct horse","new_password":"Marigold2024"}`)))
+	assert.Equal(t, validator.PasswordReasonSimilarToUsername,
+		refusedFor(changePassword(`{"current_password":"correct horse","new_password":"Gardener99"}`)), "the email counts too")
+	assert.Equal(t, validator.PasswordReasonCommon,
+		refusedFor(changePassword(`{"current_password":"correct horse","new_password":"P@ssw0rd1"}`)))
+
+	rec := changePassword(`{"current_password":"correct horse","new_password":"violet meadow lantern"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	user, err = store.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("violet meadow lantern")))
+	assert.Equal(t, http.StatusUnauthorized, changePassword(`{"current_password":"correct horse","new_password":"zq8r7mvp"}`).Code,
+		"the old password no longer works")
+}
+
+// recordingEmailSender keeps the emails it is given
+type recordingEmailSender struct {
+	mu   sync.Mutex
+	sent []Email
+}
+
+func (s *recordingEmailSender) Send(ctx context.Context, email Email) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, email)
+	return nil
+}
+
+func (s *recordingEmailSender) Sent() []Email {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Email(nil), s.sent...)
+}
+
+func TestLogEmailSenderRedactsTokens(t *testing.T) {
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	token := strings.Repeat("ab", 32)
+	require.NoError(t, NewLogEmailSender().Send(context.Background(), Email{
+		To:      "marigold@example.com",
+		Subject: "Verify your email address",
+		Body:    "https://api.example.com/api/auth/verify-email?token=" + token + "\n",
+	}))
+	assert.Contains(t, logs.String(), "marigold@example.com")
+	assert.Contains(t, logs.String(), "verify-email?token=REDACTED")
+	assert.NotContains(t, logs.String(), token)
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	store := repositorytest.NewFake()
+	sender := &recordingEmailSender{}
+	server := newTestServer(h)
+	server.repo = store
+	server.verifications = store
+	server.SetEmailSender(sender)
+	server.frontendURL = "https://chat.example.com"
+	server.publicURL = "https://api.example.com"
+	server.echo.GET("/api/whoami", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]bool{"email_verified": GetEmailVerified(c)})
+	}, server.JWTMiddleware)
+	server.SetupRoutes()
+
+	serve := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		return rec
+	}
+	login := func() AuthResponse {
+		rec := serve(http.MethodPost, "/api/login", `{"email":"marigold@example.com","password":"correct horse battery"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var auth AuthResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&auth))
+		claims, err := server.jwtService.ValidateToken(auth.Token)
+		require.NoError(t, err)
+		assert.Equal(t, auth.EmailVerified, claims.EmailVerified)
+		return auth
+	}
+	whoami := func(token string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		var body map[string]bool
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&body))
+		return body["email_verified"]
+	}
+
+	rec := serve(http.MethodPost, "/api/register", `{"username":"marigold","email":"marigold@example.com","password":"correct horse battery"}`)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	sent := sender.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "marigold@example.com", sent[0].To)
+	match := regexp.MustCompile(`https://api\.example\.com/api/auth/verify-email\?token=([0-9a-f]{64})`).FindStringSubmatch(sent[0].Body)
+	require.NotNil(t, match, sent[0].Body)
+	token := match[1]
+
+	// Unverified users can log in, flagged in their token
+	auth := login()
+	assert.False(t, auth.EmailVerified)
+	assert.False(t, whoami(auth.Token))
+
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/auth/verify-email?token=nonsense", "").Code)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/auth/verify-email?token="+strings.Repeat("0", 64), "").Code)
+
+	rec = serve(http.MethodGet, "/api/auth/verify-email?token="+token, "")
+	require.Equal(t, http.StatusFound, rec.Code, rec.Body.String())
+	assert.Equal(t, "https://chat.example.com/verified", rec.Header().Get("Location"))
+	user, err := store.GetUserByEmail(ctx, "marigold@example.com")
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+	assert.Equal(t, http.StatusBadRequest, serve(http.MethodGet, "/api/auth/verify-email?token="+token, "").Code,
+		"the link works once")
+
+	auth = login()
+	assert.True(t, auth.EmailVerified)
+	assert.True(t, whoami(auth.Token))
+}
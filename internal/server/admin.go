@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/hub"
+	natsclient "websocket-demo/internal/nats"
+
+	"github.com/labstack/echo/v4"
+)
+
+// adminStatsTTL is how long an admin stats snapshot is served from cache
+const adminStatsTTL = time.Second
+
+// AdminStatsResponse is the combined view returned by GET /api/admin/stats
+type AdminStatsResponse struct {
+	Hub           hub.Stats              `json:"hub"`
+	Metrics       map[string]interface{} `json:"metrics"`
+	DBPool        *DBPoolStats           `json:"db_pool,omitempty"`
+	NATS          *natsclient.Stats      `json:"nats,omitempty"`
+	Cluster       *hub.ClusterStats      `json:"cluster,omitempty"`
+	UptimeSeconds float64                `json:"uptime_seconds"`
+	GeneratedAt   time.Time              `json:"generated_at"`
+}
+
+// DBPoolStats is db.PoolStats with the acquire time in milliseconds
+type DBPoolStats struct {
+	TotalConns        int32 `json:"total_conns"`
+	IdleConns         int32 `json:"idle_conns"`
+	AcquiredConns     int32 `json:"acquired_conns"`
+	MaxConns          int32 `json:"max_conns"`
+	AcquireCount      int64 `json:"acquire_count"`
+	AcquireDurationMs int64 `json:"acquire_duration_ms"`
+	EmptyAcquireCount int64 `json:"empty_acquire_count"`
+}
+
+// adminStatsCache holds the last stats snapshot so aggressive dashboard
+// polling doesn't repeatedly walk the hub
+type adminStatsCache struct {
+	mu        sync.Mutex
+	snapshot  *AdminStatsResponse
+	expiresAt time.Time
+}
+
+// AdminStats returns hub, metrics, database pool and NATS state in one document
+func (s *Server) AdminStats(c echo.Context) error {
+	s.statsCache.mu.Lock()
+	defer s.statsCache.mu.Unlock()
+
+	now := time.Now()
+	if s.statsCache.snapshot == nil || now.After(s.statsCache.expiresAt) {
+		s.statsCache.snapshot = s.collectAdminStats()
+		s.statsCache.expiresAt = now.Add(adminStatsTTL)
+	}
+
+	return c.JSON(http.StatusOK, s.statsCache.snapshot)
+}
+
+// collectAdminStats gathers a fresh stats snapshot
+func (s *Server) collectAdminStats() *AdminStatsResponse {
+	resp := &AdminStatsResponse{
+		Hub:           s.hub.Stats(),
+		Metrics:       s.hub.Metrics.GetSummary(),
+		UptimeSeconds: s.hub.Metrics.GetUptime().Seconds(),
+		GeneratedAt:   time.Now(),
+	}
+
+	if s.pool != nil {
+		stats := db.GetPoolStats(s.pool)
+		resp.DBPool = &DBPoolStats{
+			TotalConns:        stats.TotalConns,
+			IdleConns:         stats.IdleConns,
+			AcquiredConns:     stats.AcquiredConns,
+			MaxConns:          stats.MaxConns,
+			AcquireCount:      stats.AcquireCount,
+			AcquireDurationMs: stats.AcquireDuration.Milliseconds(),
+			EmptyAcquireCount: stats.EmptyAcquireCount,
+		}
+	}
+
+	if s.hub.NATS != nil {
+		natsStats := s.hub.NATS.Stat()
+		resp.NATS = &natsStats
+	}
+	resp.Cluster = s.hub.ClusterStats()
+
+	return resp
+}
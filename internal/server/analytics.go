@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"websocket-demo/internal/db"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// analyticsMaxDays is the longest range GET /api/admin/analytics accepts
+	analyticsMaxDays = 90
+	// analyticsDefaultDays is the range returned without a from parameter
+	analyticsDefaultDays = 30
+	// analyticsCacheTTL is how long a range's results are served from cache
+	analyticsCacheTTL = 5 * time.Minute
+	// analyticsTopRooms is how many of the most active rooms are listed
+	analyticsTopRooms = 10
+	// analyticsDateLayout is the format of the from and to parameters and of the series dates
+	analyticsDateLayout = "2006-01-02"
+)
+
+// analyticsStore is the subset of the repository used by the analytics endpoint
+type analyticsStore interface {
+	CountMessagesPerDay(ctx context.Context, from, to time.Time) ([]db.CountMessagesPerDayRow, error)
+	CountNewUsersPerDay(ctx context.Context, from, to time.Time) ([]db.CountNewUsersPerDayRow, error)
+	ListMostActiveRooms(ctx context.Context, from, to time.Time, limit int32) ([]db.ListMostActiveRoomsRow, error)
+	PeakConnectionsPerDay(ctx context.Context, from, to time.Time) ([]db.PeakConnectionsPerDayRow, error)
+}
+
+// DailyCount is one day of an analytics series; days without activity are omitted
+type DailyCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+// RoomActivity is a room and the messages sent in it over the range
+type RoomActivity struct {
+	Room     string `json:"room"`
+	Messages int64  `json:"messages"`
+}
+
+// AnalyticsResponse is returned by GET /api/admin/analytics
+type AnalyticsResponse struct {
+	From            string         `json:"from"`
+	To              string         `json:"to"`
+	MessagesPerDay  []DailyCount   `json:"messages_per_day"`
+	NewUsersPerDay  []DailyCount   `json:"new_users_per_day"`
+	PeakConnections []DailyCount   `json:"peak_connections_per_day"`
+	MostActiveRooms []RoomActivity `json:"most_active_rooms"`
+	GeneratedAt     time.Time      `json:"generated_at"`
+}
+
+// analyticsCache holds results per range so dashboards refreshing the same
+// range don't rerun the aggregates
+type analyticsCache struct {
+	mu      sync.Mutex
+	entries map[string]analyticsCacheEntry
+}
+
+type analyticsCacheEntry struct {
+	resp      *AnalyticsResponse
+	expiresAt time.Time
+}
+
+// Analytics handles GET /api/admin/analytics?from=YYYY-MM-DD&to=YYYY-MM-DD.
+// Both dates are UTC and inclusive; to defaults to today and from to
+// analyticsDefaultDays before it, and ranges over analyticsMaxDays are refused.
+func (s *Server) Analytics(c echo.Context) error {
+	if s.analytics == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Analytics are not available"})
+	}
+
+	to := time.Now().UTC().Truncate(24 * time.Hour)
+	if raw := c.QueryParam("to"); raw != "" {
+		parsed, err := time.Parse(analyticsDateLayout, raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "to must be a date like 2006-01-02"})
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -(analyticsDefaultDays - 1))
+	if raw := c.QueryParam("from"); raw != "" {
+		parsed, err := time.Parse(analyticsDateLayout, raw)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must be a date like 2006-01-02"})
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "from must not be after to"})
+	}
+	if to.Sub(from) >= analyticsMaxDays*24*time.Hour {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("The range can be at most %d days", analyticsMaxDays)})
+	}
+
+	s.analyticsCache.mu.Lock()
+	defer s.analyticsCache.mu.Unlock()
+
+	key := from.Format(analyticsDateLayout) + "/" + to.Format(analyticsDateLayout)
+	now := time.Now()
+	if entry, ok := s.analyticsCache.entries[key]; ok && now.Before(entry.expiresAt) {
+		return c.JSON(http.StatusOK, entry.resp)
+	}
+
+	resp, err := s.collectAnalytics(c.Request().Context(), from, to)
+	if err != nil {
+		log.Printf("Failed to collect analytics: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to collect analytics"})
+	}
+	if s.analyticsCache.entries == nil {
+		s.analyticsCache.entries = make(map[string]analyticsCacheEntry)
+	}
+	for cached, entry := range s.analyticsCache.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.analyticsCache.entries, cached)
+		}
+	}
+	s.analyticsCache.entries[key] = analyticsCacheEntry{resp: resp, expiresAt: now.Add(analyticsCacheTTL)}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// collectAnalytics runs the aggregates for the days from through to
+func (s *Server) collectAnalytics(ctx context.Context, from, to time.Time) (*AnalyticsResponse, error) {
+	end := to.AddDate(0, 0, 1)
+	resp := &AnalyticsResponse{
+		From:            from.Format(analyticsDateLayout),
+		To:              to.Format(analyticsDateLayout),
+		MessagesPerDay:  []DailyCount{},
+		NewUsersPerDay:  []DailyCount{},
+		PeakConnections: []DailyCount{},
+		MostActiveRooms: []RoomActivity{},
+		GeneratedAt:     time.Now(),
+	}
+
+	messages, err := s.analytics.CountMessagesPerDay(ctx, from, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range messages {
+		resp.MessagesPerDay = append(resp.MessagesPerDay, dailyCount(row.Day, row.Messages))
+	}
+
+	users, err := s.analytics.CountNewUsersPerDay(ctx, from, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range users {
+		resp.NewUsersPerDay = append(resp.NewUsersPerDay, dailyCount(row.Day, row.Users))
+	}
+
+	peaks, err := s.analytics.PeakConnectionsPerDay(ctx, from, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range peaks {
+		resp.PeakConnections = append(resp.PeakConnections, dailyCount(row.Day, row.PeakConnections))
+	}
+
+	rooms, err := s.analytics.ListMostActiveRooms(ctx, from, end, analyticsTopRooms)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rooms {
+		resp.MostActiveRooms = append(resp.MostActiveRooms, RoomActivity{Room: row.Name, Messages: row.Messages})
+	}
+	return resp, nil
+}
+
+func dailyCount(day pgtype.Date, count int64) DailyCount {
+	return DailyCount{Date: day.Time.Format(analyticsDateLayout), Count: count}
+}
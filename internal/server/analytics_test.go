@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalytics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	store := repositorytest.NewFake()
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	var roomIDs []pgtype.UUID
+	for _, name := range []string{"busy", "quiet"} {
+		room, err := store.CreateRoom(ctx, name, pgtype.Bool{Valid: true}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+		require.NoError(t, err)
+		roomIDs = append(roomIDs, room.ID)
+	}
+
+	day1 := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	at := func(t time.Time) pgtype.Timestamptz { return pgtype.Timestamptz{Time: t, Valid: true} }
+	_, err = store.BulkCreateMessages(ctx, []db.BulkCreateMessagesParams{
+		{RoomID: roomIDs[0], UserID: alice.ID, Content: "one", CreatedAt: at(day1)},
+		{RoomID: roomIDs[0], UserID: alice.ID, Content: "two", CreatedAt: at(day2)},
+		{RoomID: roomIDs[0], UserID: alice.ID, Content: "three", CreatedAt: at(day2)},
+		{RoomID: roomIDs[1], UserID: alice.ID, Content: "four", CreatedAt: at(day2)},
+		{RoomID: roomIDs[1], UserID: alice.ID, Content: "outside", CreatedAt: at(day2.AddDate(0, 0, 1))},
+	})
+	require.NoError(t, err)
+	// Two servers sampled in the same minute add up
+	store.AddStatsSample("server-a", day1, 5)
+	store.AddStatsSample("server-b", day1.Add(10*time.Second), 7)
+	store.AddStatsSample("server-a", day1.Add(time.Hour), 9)
+
+	server := newTestServer(h)
+	server.analytics = store
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	get := func(query string) (*http.Response, AnalyticsResponse) {
+		req, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/admin/analytics"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+generateTestJWT(t))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var analytics AnalyticsResponse
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&analytics))
+		}
+		return resp, analytics
+	}
+
+	resp, analytics := get("?from=2026-03-01&to=2026-03-02")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "2026-03-01", analytics.From)
+	assert.Equal(t, "2026-03-02", analytics.To)
+	assert.Equal(t, []DailyCount{{Date: "2026-03-01", Count: 1}, {Date: "2026-03-02", Count: 3}}, analytics.MessagesPerDay)
+	assert.Equal(t, []DailyCount{{Date: "2026-03-01", Count: 12}}, analytics.PeakConnections)
+	assert.Equal(t, []RoomActivity{{Room: "busy", Messages: 3}, {Room: "quiet", Messages: 1}}, analytics.MostActiveRooms)
+	assert.Empty(t, analytics.NewUsersPerDay, "alice signed up today")
+
+	// Results are cached per range
+	_, err = store.CreateMessage(ctx, roomIDs[1], alice.ID, "late")
+	require.NoError(t, err)
+	_, cached := get("?from=2026-03-01&to=2026-03-02")
+	assert.Equal(t, analytics.GeneratedAt, cached.GeneratedAt)
+
+	// Without parameters the last 30 days up to today are returned
+	resp, analytics = get("")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	today := time.Now().UTC().Format(analyticsDateLayout)
+	assert.Equal(t, today, analytics.To)
+	assert.Equal(t, time.Now().UTC().AddDate(0, 0, -29).Format(analyticsDateLayout), analytics.From)
+	assert.Equal(t, []DailyCount{{Date: today, Count: 1}}, analytics.NewUsersPerDay)
+
+	for _, query := range []string{"?from=2026-01-01&to=2026-04-01", "?from=2026-03-02&to=2026-03-01", "?from=yesterday"} {
+		resp, _ = get(query)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+	resp, _ = get("?from=2026-01-01&to=2026-03-31")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "90 days is allowed")
+}
@@ -35,6 +35,12 @@ const (
 
 	AuditEventTokenRefresh   AuditEventType = "token_refresh"
 
+	AuditEventConfigChange   AuditEventType = "config_change"
+
+	AuditEventAccountDelete  AuditEventType = "account_delete"
+
+	AuditEventRoomPasswordLockout AuditEventType = "room_password_lockout"
+
 )
 
 // AuditEvent represents an audit log entry
@@ -51,11 +57,11 @@ type AuditEvent struct {
 
 // AuditLogger handles audit logging
 type AuditLogger struct {
-	pool *db.Queries
+	pool db.Querier
 }
 
 // NewAuditLogger creates a new audit logger
-func NewAuditLogger(pool *db.Queries) *AuditLogger {
+func NewAuditLogger(pool db.Querier) *AuditLogger {
 	return &AuditLogger{
 		pool: pool,
 	}
@@ -194,6 +200,52 @@ func (a *AuditLogger) LogTokenRefresh(ctx context.Context, userID, username, ipA
 	})
 }
 
+// LogConfigChange logs a hub configuration field changed by a user, or by a signal when userID is empty
+func (a *AuditLogger) LogConfigChange(ctx context.Context, userID, username, field string, oldValue, newValue interface{}, applied bool, ipAddress, userAgent string) {
+	a.LogEvent(ctx, AuditEvent{
+		UserID:    userID,
+		Username:  username,
+		EventType: AuditEventConfigChange,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details: map[string]interface{}{
+			"field":   field,
+			"old":     oldValue,
+			"new":     newValue,
+			"applied": applied,
+		},
+		Timestamp: time.Now(),
+	})
+}
+
+// LogAccountDelete logs a user deleting their own account and how many of
+// their connections on this server were closed
+func (a *AuditLogger) LogAccountDelete(ctx context.Context, userID, username string, sessionsClosed int, ipAddress, userAgent string) {
+	a.LogEvent(ctx, AuditEvent{
+		UserID:    userID,
+		Username:  username,
+		EventType: AuditEventAccountDelete,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   map[string]interface{}{"sessions_closed": sessionsClosed},
+		Timestamp: time.Now(),
+	})
+}
+
+// LogRoomPasswordLockout logs a user blocked from joining a private room
+// after too many wrong passwords
+func (a *AuditLogger) LogRoomPasswordLockout(ctx context.Context, userID, username, roomName string, failures int, ipAddress, userAgent string) {
+	a.LogEvent(ctx, AuditEvent{
+		UserID:    userID,
+		Username:  username,
+		EventType: AuditEventRoomPasswordLockout,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		Details:   map[string]interface{}{"room_name": roomName, "failures": failures},
+		Timestamp: time.Now(),
+	})
+}
+
 // Helper function to get client IP address
 func GetClientIP(c echo.Context) string {
 	ip := c.RealIP()
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// bootstrapStore is the subset of the repository used by the bootstrap endpoint
+type bootstrapStore interface {
+	ListUserRoomSummaries(ctx context.Context, userID pgtype.UUID) ([]db.ListUserRoomSummariesRow, error)
+}
+
+// LastMessageDTO previews the latest message in a room
+type LastMessageDTO struct {
+	MessageID string    `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RoomSummaryDTO is one of the user's rooms as returned by the bootstrap endpoint
+type RoomSummaryDTO struct {
+	Name        string          `json:"name"`
+	Private     bool            `json:"private"`
+	Members     int64           `json:"members"`
+	Unread      int64           `json:"unread"` // Messages from others since the user was last in the room
+	LastMessage *LastMessageDTO `json:"last_message,omitempty"`
+}
+
+// BootstrapResponse is everything a client needs to draw its first screen
+type BootstrapResponse struct {
+	Rooms    []RoomSummaryDTO        `json:"rooms"`
+	Presence []types.UserPresenceDTO `json:"presence"`
+}
+
+// Bootstrap handles GET /api/bootstrap, returning the user's rooms with
+// unread counts and last message previews, and who is online, in one call
+func (s *Server) Bootstrap(c echo.Context) error {
+	if s.bootstrap == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Bootstrap is not available"})
+	}
+
+	userID := GetUserID(c)
+	var id pgtype.UUID
+	if err := id.Scan(userID); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token"})
+	}
+
+	rows, err := s.bootstrap.ListUserRoomSummaries(c.Request().Context(), id)
+	if err != nil {
+		log.Printf("Failed to list room summaries for user %s: %v", userID, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load rooms"})
+	}
+
+	rooms := make([]RoomSummaryDTO, len(rows))
+	for i, row := range rows {
+		rooms[i] = RoomSummaryDTO{
+			Name:    row.Name,
+			Private: row.Private.Bool,
+			Members: row.MemberCount,
+			Unread:  row.UnreadCount,
+		}
+		if row.LastMessageID.Valid {
+			rooms[i].LastMessage = &LastMessageDTO{
+				MessageID: uuid.UUID(row.LastMessageID.Bytes).String(),
+				Sender:    row.LastMessageSender.String,
+				Content:   row.LastMessageContent.String,
+				CreatedAt: row.LastMessageAt.Time,
+			}
+		}
+	}
+
+	return c.JSON(http.StatusOK, BootstrapResponse{
+		Rooms:    rooms,
+		Presence: s.hub.GetPresence(),
+	})
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBootstrap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	store := repositorytest.NewFake()
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	busy, err := store.CreateRoom(ctx, "busy", pgtype.Bool{Valid: true}, pgtype.Text{}, bob.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	quiet, err := store.CreateRoom(ctx, "quiet", pgtype.Bool{Bool: true, Valid: true}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	_, err = store.CreateRoom(ctx, "elsewhere", pgtype.Bool{Valid: true}, pgtype.Text{}, bob.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	for _, roomID := range []pgtype.UUID{busy.ID, quiet.ID} {
+		require.NoError(t, store.AddRoomMember(ctx, roomID, alice.ID, repository.RoomRoleMember))
+	}
+	require.NoError(t, store.AddRoomMember(ctx, busy.ID, bob.ID, repository.RoomRoleMember))
+
+	_, err = store.CreateMessage(ctx, quiet.ID, alice.ID, "note to self")
+	require.NoError(t, err)
+	time.Sleep(time.Millisecond)
+	_, err = store.CreateMessage(ctx, busy.ID, bob.ID, "are you there?")
+	require.NoError(t, err)
+	latest, err := store.CreateMessage(ctx, busy.ID, bob.ID, "hello?")
+	require.NoError(t, err)
+
+	server := newTestServer(h)
+	server.bootstrap = store
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	token := generateTestJWTFor(t, uuid.UUID(alice.ID.Bytes).String(), "alice")
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws", &websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer conn.CloseNow()
+	_, _, err = conn.Read(ctx) // The connected frame follows registration
+	require.NoError(t, err)
+
+	bootstrap := func() BootstrapResponse {
+		req, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/bootstrap", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body BootstrapResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body
+	}
+
+	body := bootstrap()
+	require.Len(t, body.Rooms, 2, "only rooms alice is a member of")
+	assert.Equal(t, "busy", body.Rooms[0].Name, "most recently active first")
+	assert.Equal(t, int64(2), body.Rooms[0].Members)
+	assert.Equal(t, int64(2), body.Rooms[0].Unread)
+	require.NotNil(t, body.Rooms[0].LastMessage)
+	assert.Equal(t, uuid.UUID(latest.ID.Bytes).String(), body.Rooms[0].LastMessage.MessageID)
+	assert.Equal(t, "bob", body.Rooms[0].LastMessage.Sender)
+	assert.Equal(t, "hello?", body.Rooms[0].LastMessage.Content)
+
+	assert.Equal(t, "quiet", body.Rooms[1].Name)
+	assert.True(t, body.Rooms[1].Private)
+	assert.Zero(t, body.Rooms[1].Unread, "a user's own messages aren't unread")
+
+	require.Len(t, body.Presence, 1)
+	assert.Equal(t, "alice", body.Presence[0].Name)
+
+	// Catching up clears the unread count
+	require.NoError(t, store.MarkRoomRead(ctx, busy.ID, alice.ID))
+	assert.Zero(t, bootstrap().Rooms[0].Unread)
+
+	// Without a token the endpoint is refused
+	resp, err := http.Get(testServer.URL + "/api/bootstrap")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
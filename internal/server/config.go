@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"websocket-demo/internal/hub"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConfigUpdateResponse is returned by POST /api/admin/config
+type ConfigUpdateResponse struct {
+	Config  hub.HubConfig      `json:"config"`
+	Changes []hub.ConfigChange `json:"changes"`
+}
+
+// UpdateHubConfig handles POST /api/admin/config. The body is a JSON subset of
+// hub.HubConfig; fields left out keep their current values.
+func (s *Server) UpdateHubConfig(c echo.Context) error {
+	cfg := s.hub.Config()
+	if err := json.NewDecoder(c.Request().Body).Decode(&cfg); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid config: " + err.Error()})
+	}
+
+	changes, err := s.ReloadHubConfig(c.Request().Context(), cfg, GetUserID(c), GetUsername(c), GetClientIP(c), GetUserAgent(c))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if changes == nil {
+		changes = []hub.ConfigChange{}
+	}
+	return c.JSON(http.StatusOK, ConfigUpdateResponse{Config: s.hub.Config(), Changes: changes})
+}
+
+// ReloadHubConfig applies cfg to the hub and records every changed field as an
+// audit event. userID is empty for reloads that no user asked for, e.g. SIGHUP.
+func (s *Server) ReloadHubConfig(ctx context.Context, cfg hub.HubConfig, userID, username, ipAddress, userAgent string) ([]hub.ConfigChange, error) {
+	changes, err := s.hub.ReloadConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		s.audit.LogConfigChange(ctx, userID, username, change.Field, change.Old, change.New, change.Applied, ipAddress, userAgent)
+	}
+	return changes, nil
+}
@@ -4,11 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"log"
+	"net/http"
 	"sync"
 	"time"
 
-	"websocket-demo/internal/auth"
-
 	"github.com/labstack/echo/v4"
 )
 
@@ -156,19 +155,12 @@ func (s *Server) CSRFMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 			return next(c)
 		}
 
-		// Get user from context
-		claims := c.Get("user")
-		if claims == nil {
+		// Get user from context (set by JWTMiddleware)
+		authClaims := GetClaims(c)
+		if authClaims == nil {
 			return c.JSON(401, map[string]string{"error": "Not authenticated"})
 		}
 
-		// Type assertion with safety check
-		authClaims, ok := claims.(*auth.Claims)
-		if !ok {
-			log.Printf("CSRF middleware: invalid claims type")
-			return c.JSON(401, map[string]string{"error": "Invalid authentication"})
-		}
-
 		// Get CSRF token from header
 		csrfToken := c.Request().Header.Get("X-CSRF-Token")
 		if csrfToken == "" {
@@ -182,4 +174,11 @@ func (s *Server) CSRFMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 
 		return next(c)
 	}
+}
+
+// GetCSRFToken handles GET /api/csrf-token, issuing a token to send in the
+// X-CSRF-Token header of requests guarded by CSRFMiddleware
+func (s *Server) GetCSRFToken(c echo.Context) error {
+	token := s.csrf.GenerateToken(GetUserID(c), c.RealIP(), c.Request().UserAgent())
+	return c.JSON(http.StatusOK, map[string]string{"csrf_token": token})
 }
\ No newline at end of file
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultDeletedRoomMessagesLimit is how many messages are listed without a limit parameter
+	defaultDeletedRoomMessagesLimit = 50
+	// maxDeletedRoomMessagesLimit caps the limit parameter
+	maxDeletedRoomMessagesLimit = 500
+)
+
+// deletedRoomStore is the subset of the repository used by the deleted room endpoints
+type deletedRoomStore interface {
+	ListDeletedRooms(ctx context.Context) ([]db.Room, error)
+	ListMessagesByRoom(ctx context.Context, roomID pgtype.UUID, limit, offset int32) ([]db.ListMessagesByRoomRow, error)
+}
+
+// DeletedRoomDTO is a soft-deleted room that an admin can still restore
+type DeletedRoomDTO struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Private   bool       `json:"private"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"` // Omitted when ROOM_RESTORE_WINDOW is 0
+}
+
+// ListDeletedRooms handles GET /api/admin/deleted-rooms, returning the
+// soft-deleted rooms, most recently deleted first
+func (s *Server) ListDeletedRooms(c echo.Context) error {
+	if s.deletedRooms == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Deleted rooms are not available"})
+	}
+
+	rows, err := s.deletedRooms.ListDeletedRooms(c.Request().Context())
+	if err != nil {
+		log.Printf("Failed to list deleted rooms: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list deleted rooms"})
+	}
+
+	window := s.hub.RoomRestoreWindow()
+	rooms := make([]DeletedRoomDTO, len(rows))
+	for i, row := range rows {
+		rooms[i] = DeletedRoomDTO{
+			ID:        uuid.UUID(row.ID.Bytes).String(),
+			Name:      row.Name,
+			Private:   row.Private.Bool,
+			DeletedAt: row.DeletedAt.Time,
+		}
+		if window > 0 {
+			purgeAt := row.DeletedAt.Time.Add(window)
+			rooms[i].PurgeAt = &purgeAt
+		}
+	}
+	return c.JSON(http.StatusOK, rooms)
+}
+
+// ListDeletedRoomMessages handles GET /api/admin/deleted-rooms/:id/messages,
+// returning a deleted room's messages newest first. The optional limit and
+// offset query parameters page through them.
+func (s *Server) ListDeletedRoomMessages(c echo.Context) error {
+	if s.deletedRooms == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Deleted rooms are not available"})
+	}
+
+	var roomID pgtype.UUID
+	if err := roomID.Scan(c.Param("id")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid room ID"})
+	}
+	limit := defaultDeletedRoomMessagesLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxDeletedRoomMessagesLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxDeletedRoomMessagesLimit)})
+		}
+		limit = n
+	}
+	offset := 0
+	if raw := c.QueryParam("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "offset must not be negative"})
+		}
+		offset = n
+	}
+
+	ctx := c.Request().Context()
+	deleted, err := s.deletedRooms.ListDeletedRooms(ctx)
+	if err != nil {
+		log.Printf("Failed to list deleted rooms: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list messages"})
+	}
+	found := false
+	for _, room := range deleted {
+		if room.ID == roomID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Deleted room not found"})
+	}
+
+	rows, err := s.deletedRooms.ListMessagesByRoom(ctx, roomID, int32(limit), int32(offset))
+	if err != nil {
+		log.Printf("Failed to list messages of deleted room %s: %v", c.Param("id"), err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list messages"})
+	}
+	messages := make([]types.ExportMessageDTO, len(rows))
+	for i, row := range rows {
+		messages[i] = types.ExportMessageDTO{
+			MessageID: uuid.UUID(row.ID.Bytes).String(),
+			Username:  row.Username,
+			Content:   row.Content,
+			Timestamp: row.CreatedAt.Time.Format(time.RFC3339),
+		}
+	}
+	return c.JSON(http.StatusOK, messages)
+}
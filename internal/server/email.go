@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// EmailVerificationTTL is how long the link sent at registration works
+const EmailVerificationTTL = 24 * time.Hour
+
+// verificationTokenBytes is the size of a verification token before hex encoding
+const verificationTokenBytes = 32
+
+// verificationTokenPattern is what a token from newVerificationToken looks like
+var verificationTokenPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// errEmailVerificationUnavailable is returned when the server has no store
+// or sender for verification emails
+var errEmailVerificationUnavailable = errors.New("email verification is not available")
+
+// Email is a message sent to a user
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// EmailSender delivers emails; SetEmailSender replaces the LogEmailSender a
+// server starts with
+type EmailSender interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// LogEmailSender writes emails to the log instead of sending them, for
+// development. Link tokens are redacted so the log can't be used to take
+// over an account.
+type LogEmailSender struct{}
+
+// NewLogEmailSender returns a sender that logs emails
+func NewLogEmailSender() LogEmailSender {
+	return LogEmailSender{}
+}
+
+// emailTokenPattern matches the token query parameter of links in emails
+var emailTokenPattern = regexp.MustCompile(`([?&]token=)[^&\s]+`)
+
+// Send logs email with any link tokens redacted
+func (LogEmailSender) Send(ctx context.Context, email Email) error {
+	body := emailTokenPattern.ReplaceAllString(email.Body, "${1}REDACTED")
+	log.Printf("Email to %s: %s\n%s", email.To, email.Subject, body)
+	return nil
+}
+
+// verificationStore is the subset of the repository used by email verification
+type verificationStore interface {
+	CreateEmailVerificationToken(ctx context.Context, userID pgtype.UUID, token string, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, token string) (db.User, error)
+}
+
+// SetEmailSender replaces how verification emails are sent
+func (s *Server) SetEmailSender(sender EmailSender) {
+	s.emailSender = sender
+}
+
+// newVerificationToken returns a random hex token
+func newVerificationToken() (string, error) {
+	b := make([]byte, verificationTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// sendVerificationEmail stores a verification token for a newly registered
+// user and emails them a link to GET /api/auth/verify-email under PUBLIC_URL
+func (s *Server) sendVerificationEmail(c echo.Context, user db.User) error {
+	if s.verifications == nil || s.emailSender == nil {
+		return errEmailVerificationUnavailable
+	}
+	token, err := newVerificationToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	ctx := c.Request().Context()
+	if err := s.verifications.CreateEmailVerificationToken(ctx, user.ID, token, time.Now().Add(EmailVerificationTTL)); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+
+	link := s.publicURL + "/api/auth/verify-email?token=" + url.QueryEscape(token)
+	return s.emailSender.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nFollow this link within %s to verify your email address:\n\n%s\n",
+			user.Username, EmailVerificationTTL, link),
+	})
+}
+
+// VerifyEmail handles GET /api/auth/verify-email?token=, the link emailed at
+// registration. It marks the user's email verified, uses up the token and
+// redirects to FRONTEND_URL/verified.
+func (s *Server) VerifyEmail(c echo.Context) error {
+	if s.verifications == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Email verification is not available"})
+	}
+
+	token := c.QueryParam("token")
+	if !verificationTokenPattern.MatchString(token) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired verification token"})
+	}
+	user, err := s.verifications.VerifyEmail(c.Request().Context(), token)
+	if errors.Is(err, repository.ErrVerificationTokenInvalid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid or expired verification token"})
+	}
+	if err != nil {
+		log.Printf("Failed to verify email: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify email"})
+	}
+
+	log.Printf("User %s verified their email", uuid.UUID(user.ID.Bytes))
+	return c.Redirect(http.StatusFound, s.frontendURL+"/verified")
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"websocket-demo/internal/db"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultFlaggedMessagesLimit is how many flagged messages are listed without a limit parameter
+	defaultFlaggedMessagesLimit = 50
+	// maxFlaggedMessagesLimit caps the limit parameter
+	maxFlaggedMessagesLimit = 500
+)
+
+// flagStore is the subset of the repository used by the flagged message endpoint
+type flagStore interface {
+	ListFlaggedMessages(ctx context.Context, limit int32) ([]db.ListFlaggedMessagesRow, error)
+}
+
+// FlaggedMessageDTO is a message flagged for moderator review
+type FlaggedMessageDTO struct {
+	ID           string    `json:"id"`
+	Room         string    `json:"room,omitempty"` // Empty for global chat and direct messages
+	UserID       string    `json:"user_id,omitempty"`
+	Username     string    `json:"username"`
+	Content      string    `json:"content"`
+	MatchedWords []string  `json:"matched_words"`
+	FlaggedAt    time.Time `json:"flagged_at"`
+}
+
+// ListFlaggedMessages handles GET /api/admin/flagged-messages, returning the
+// newest messages flagged by PROFANITY_ACTION=flag. The optional limit query
+// parameter defaults to defaultFlaggedMessagesLimit.
+func (s *Server) ListFlaggedMessages(c echo.Context) error {
+	if s.flags == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Flagged messages are not available"})
+	}
+
+	limit := defaultFlaggedMessagesLimit
+	if raw := c.QueryParam("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxFlaggedMessagesLimit {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and " + strconv.Itoa(maxFlaggedMessagesLimit)})
+		}
+		limit = n
+	}
+
+	rows, err := s.flags.ListFlaggedMessages(c.Request().Context(), int32(limit))
+	if err != nil {
+		log.Printf("Failed to list flagged messages: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list flagged messages"})
+	}
+
+	flagged := make([]FlaggedMessageDTO, len(rows))
+	for i, row := range rows {
+		flagged[i] = FlaggedMessageDTO{
+			ID:           uuid.UUID(row.ID.Bytes).String(),
+			Room:         row.RoomName,
+			Username:     row.Username,
+			Content:      row.Content,
+			MatchedWords: row.MatchedWords,
+			FlaggedAt:    row.FlaggedAt.Time,
+		}
+		if row.UserID.Valid {
+			flagged[i].UserID = uuid.UUID(row.UserID.Bytes).String()
+		}
+	}
+	return c.JSON(http.StatusOK, flagged)
+}
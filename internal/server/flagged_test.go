@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListFlaggedMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	store := repositorytest.NewFake()
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	lounge, err := store.CreateRoom(ctx, "lounge", pgtype.Bool{Valid: true}, pgtype.Text{}, alice.ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	require.NoError(t, store.CreateFlaggedMessage(ctx, lounge.ID, alice.ID, "alice", "darn it", []string{"darn"}))
+	require.NoError(t, store.CreateFlaggedMessage(ctx, pgtype.UUID{}, pgtype.UUID{}, "guest", "heck", []string{"heck"}))
+
+	server := newTestServer(h)
+	server.flags = store
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	list := func(query string) (*http.Response, []FlaggedMessageDTO) {
+		req, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/admin/flagged-messages"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+generateTestJWT(t))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var flagged []FlaggedMessageDTO
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&flagged))
+		}
+		return resp, flagged
+	}
+
+	resp, flagged := list("")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, flagged, 2)
+	assert.Equal(t, "guest", flagged[0].Username, "newest first")
+	assert.Empty(t, flagged[0].Room)
+	assert.Empty(t, flagged[0].UserID)
+	assert.Equal(t, "lounge", flagged[1].Room)
+	assert.Equal(t, []string{"darn"}, flagged[1].MatchedWords)
+
+	resp, flagged = list("?limit=1")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, flagged, 1)
+
+	resp, _ = list("?limit=0")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -3,101 +3,169 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	"websocket-demo/internal/client"
-	"websocket-demo/internal/hub"
+	hubpkg "websocket-demo/internal/hub"
 	"websocket-demo/internal/room"
+	"websocket-demo/internal/tracing"
 	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
 
-	"github.com/coder/websocket"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-// HandleWebSocketMessage processes WebSocket messages and routes them appropriately
-func HandleWebSocketMessage(hub *hub.Hub, client *client.Client, wsMsg *types.WebSocketMessage) error {
+// HandleWebSocketMessage processes WebSocket messages and routes them
+// appropriately. ctx carries the message's span, which chat messages take
+// along to the database and NATS.
+func HandleWebSocketMessage(ctx context.Context, hub *hubpkg.Hub, client *client.Client, wsMsg *types.WebSocketMessage) error {
+	// Refuse messages missing a field their type needs before dispatching them
+	if result := hub.Validator().ValidateWebSocketMessage(wsMsg); !result.Valid {
+		errorMsg := []byte(fmt.Sprintf("Message rejected: %s", validator.FormatValidationErrors(result.Errors)))
+		client.WriteMessage(context.Background(), errorMsg)
+		return nil
+	}
+
 	switch wsMsg.Type {
 	case types.MsgTypeChat:
 		// Handle regular chat message
+		content, err := hub.FilterMessage(client, nil, wsMsg.Data.Content)
+		if err != nil {
+			writeFilterError(client, err)
+			return nil
+		}
+		// A resend of the same message, e.g. after network lag, is dropped
+		if hub.IsDuplicateMessage(client, "", content) {
+			return nil
+		}
 		timestamp := time.Now().Format("15:04:05")
-		formattedMsg := []byte(fmt.Sprintf("[%s] %s: %s", timestamp, client.Name, wsMsg.Data.Content))
-		hub.Broadcast <- types.Message{Content: formattedMsg, Sender: client, Type: types.MsgTypeChat}
+		formattedMsg := []byte(fmt.Sprintf("[%s] %s: %s", timestamp, client.Name, content))
+		hub.Broadcast <- types.Message{Content: formattedMsg, Sender: client, Type: types.MsgTypeChat, TraceContext: tracing.Inject(ctx)}
 
 	case types.MsgTypeRoomMessage:
 		// Handle room-specific message
 		currentRoom := client.GetCurrentRoom()
 
 		if currentRoom != nil {
-			// Save message to database if client is authenticated
-			if client.Authenticated && client.UserID != "" && hub.Repo != nil {
-				if room, ok := currentRoom.(*room.Room); ok && room.ID != "" {
-					ctx := context.Background()
-					var senderUUID pgtype.UUID
-					var roomUUID pgtype.UUID
-					if err := senderUUID.Scan(client.UserID); err == nil {
-						if err := roomUUID.Scan(room.ID); err == nil {
-							_, err := hub.Repo.CreateMessage(ctx, roomUUID, senderUUID, wsMsg.Data.Content)
-							if err != nil {
-								log.Printf("Failed to save room message to database: %v", err)
-							}
-						}
-					}
+			targetRoom, ok := currentRoom.(*room.Room)
+			if !ok {
+				return fmt.Errorf("invalid room type")
+			}
+			// Mask, reject or flag blocked words before anything is stored or sent
+			content, err := hub.FilterMessage(client, targetRoom, wsMsg.Data.Content)
+			if err != nil {
+				writeFilterError(client, err)
+				return nil
+			}
+			// A resend of the same message, e.g. after network lag, gets the
+			// original's ack without being stored or broadcast again
+			if hub.IsDuplicateMessage(client, targetRoom.Name, content) {
+				client.WriteMessage(context.Background(), []byte("Message sent to room"))
+				return nil
+			}
+
+			// Resolve the quoted parent message for replies
+			var parentUUID pgtype.UUID
+			var replyPreview *types.ReplyPreview
+			if wsMsg.Data.ReplyTo != "" {
+				parentUUID, replyPreview, err = hub.ResolveReply(targetRoom, wsMsg.Data.ReplyTo)
+				if err != nil {
+					errorMsg := []byte(fmt.Sprintf("Error: %v", err))
+					client.WriteMessage(context.Background(), errorMsg)
+					return nil
 				}
 			}
 
 			timestamp := time.Now().Format("15:04:05")
-			formattedMsg := []byte(fmt.Sprintf("[%s] %s: %s", timestamp, client.Name, wsMsg.Data.Content))
-			hub.Broadcast <- types.Message{Content: formattedMsg, Sender: client, Type: types.MsgTypeRoomMessage, Room: currentRoom}
+			formattedMsg := []byte(fmt.Sprintf("[%s] %s: %s", timestamp, client.Name, content))
+			if replyPreview != nil {
+				// Replies carry the quoted parent so clients can render it without a fetch
+				replyMsg, err := json.Marshal(types.ChatMessage{
+					Type:      types.MsgTypeRoomMessage,
+					Timestamp: timestamp,
+					Sender:    client.Name,
+					Content:   content,
+					ReplyTo:   replyPreview,
+				})
+				if err != nil {
+					return fmt.Errorf("failed to marshal reply message: %w", err)
+				}
+				formattedMsg = replyMsg
+			}
+
+			// Save message to database if client is authenticated. A returned
+			// message ID means the outbox relays it to other servers.
+			messageID, err := hub.SaveRoomMessage(ctx, client, targetRoom, parentUUID, content, formattedMsg)
+			if err != nil {
+				log.Printf("Failed to save room message to database: %v", err)
+			}
+			hub.Broadcast <- types.Message{MessageID: messageID, Content: formattedMsg, Sender: client, Type: types.MsgTypeRoomMessage, Room: currentRoom, TraceContext: tracing.Inject(ctx)}
 			// Send success message to sender
 			successMsg := []byte("Message sent to room")
-			client.Conn.Write(context.Background(), websocket.MessageText, successMsg)
+			client.WriteMessage(context.Background(), successMsg)
 		} else {
 			// Send error message if not in a room
 			errorMsg := []byte("You are not in a room")
-			client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+			client.WriteMessage(context.Background(), errorMsg)
 		}
 
 	case types.MsgTypeCreateRoom:
 		// Handle room creation
-		newRoom, err := hub.CreateRoom(wsMsg.Data.Name, wsMsg.Data.Private, wsMsg.Data.Password, 100)
+		if errMsg := validateRoomCreation(hub.Validator(), wsMsg.Data.Name, wsMsg.Data.Password, wsMsg.Data.Private); errMsg != "" {
+			client.WriteMessage(context.Background(), []byte(fmt.Sprintf("Error creating room: %s", errMsg)))
+			return nil
+		}
+		maxClients, errMsg := roomCapacity(hub.Config(), wsMsg.Data.MaxClients)
+		if errMsg != "" {
+			client.WriteMessage(context.Background(), []byte(fmt.Sprintf("Error creating room: %s", errMsg)))
+			return nil
+		}
+		_, err := hub.CreateRoomAs(client, wsMsg.Data.Name, wsMsg.Data.Private, wsMsg.Data.Password, maxClients)
 		if err != nil {
 			// Send error message to client
 			errorMsg := []byte(fmt.Sprintf("Error creating room: %v", err))
-			client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+			client.WriteMessage(context.Background(), errorMsg)
 		} else {
-			// Set the creator
-			newRoom.SetCreator(client)
 			// Send success message
 			successMsg := []byte(fmt.Sprintf("Room '%s' created successfully", wsMsg.Data.Name))
-			client.Conn.Write(context.Background(), websocket.MessageText, successMsg)
+			client.WriteMessage(context.Background(), successMsg)
 		}
 
 	case types.MsgTypeJoinRoom:
-		// Handle room joining
-		targetRoom, exists := hub.GetRoom(wsMsg.Data.Name)
-
-		if exists {
-			err := hub.JoinRoom(client, targetRoom, wsMsg.Data.Password)
-			if err != nil {
-				// Send error message to client
-				errorMsg := []byte(fmt.Sprintf("Error joining room: %v", err))
-				client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+		// Handle room joining; the lookup and join happen under one lock so
+		// the room can't be deleted in between
+		if joinCreatesRoom(hub, wsMsg.Data.Name) {
+			if errMsg := validateRoomCreation(hub.Validator(), wsMsg.Data.Name, "", false); errMsg != "" {
+				client.WriteMessage(context.Background(), []byte(fmt.Sprintf("Error joining room: %s", errMsg)))
+				return nil
 			}
-		} else {
+		}
+		err := hub.LookupAndJoinRoom(client, wsMsg.Data.Name, wsMsg.Data.Password)
+		if errors.Is(err, hubpkg.ErrRoomNotFound) {
 			// Send error message to client
 			errorMsg := []byte(fmt.Sprintf("Room '%s' does not exist", wsMsg.Data.Name))
-			client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+			client.WriteMessage(context.Background(), errorMsg)
+		} else if err != nil {
+			// Send error message to client
+			errorMsg := []byte(fmt.Sprintf("Error joining room: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
 		}
 
 	case types.MsgTypeLeaveRoom:
-		// Handle room leaving
-		hub.LeaveRoom(client)
+		// Handle room leaving; a room name picks the room to leave
+		if err := hub.LeaveNamedRoom(client, wsMsg.Data.Name); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error leaving room: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
 
 		// Send leave confirmation response
 		leaveResponse := []byte("ROOM_LEAVE_SUCCESS:You have successfully left the room")
-		if err := client.Conn.Write(context.Background(), websocket.MessageText, leaveResponse); err != nil {
+		if err := client.WriteMessage(context.Background(), leaveResponse); err != nil {
 			log.Printf("Failed to send leave response to client %s: %v", client.Name, err)
 		}
 
@@ -106,7 +174,7 @@ func HandleWebSocketMessage(hub *hub.Hub, client *client.Client, wsMsg *types.We
 		roomList := hub.GetRoomList(client)
 		roomListJSON, _ := json.Marshal(roomList)
 		listMsg := []byte(fmt.Sprintf("ROOMS_LIST:%s", string(roomListJSON)))
-		client.Conn.Write(context.Background(), websocket.MessageText, listMsg)
+		client.WriteMessage(context.Background(), listMsg)
 
 	case types.MsgTypeDeleteRoom:
 		// Handle room deletion
@@ -114,12 +182,211 @@ func HandleWebSocketMessage(hub *hub.Hub, client *client.Client, wsMsg *types.We
 		if err != nil {
 			// Send error message to client
 			errorMsg := []byte(fmt.Sprintf("Error deleting room: %v", err))
-			client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+			client.WriteMessage(context.Background(), errorMsg)
 		} else {
 			// Send success message
 			successMsg := []byte(fmt.Sprintf("Room '%s' deleted successfully", wsMsg.Data.Name))
-			client.Conn.Write(context.Background(), websocket.MessageText, successMsg)
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeRestoreRoom:
+		// Handle restoring a deleted room (admin only)
+		_, err := hub.RestoreRoom(client, wsMsg.Data.Name)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error restoring room: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Room '%s' restored successfully", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeSetRoomSettings:
+		// Handle room settings update (creator only)
+		var err error
+		if wsMsg.Data.SuppressJoinLeave != nil {
+			err = hub.SetRoomSuppressJoinLeave(client, wsMsg.Data.Name, *wsMsg.Data.SuppressJoinLeave)
+		}
+		if err == nil && wsMsg.Data.LinkPolicy != nil {
+			err = hub.SetRoomLinkPolicy(client, wsMsg.Data.Name, wsMsg.Data.LinkPolicy)
+		}
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error updating room settings: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Room '%s' settings updated", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeRoomAlert:
+		// Handle setting a room's moderation banner (admins, the creator and moderators)
+		if err := hub.SetRoomAlert(client, wsMsg.Data.Name, wsMsg.Data.Content); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error setting room alert: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Room '%s' alert set", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeClearRoomAlert:
+		// Handle clearing a room's moderation banner (admins, the creator and moderators)
+		if err := hub.ClearRoomAlert(client, wsMsg.Data.Name); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error clearing room alert: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Room '%s' alert cleared", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeChangeRoomPassword:
+		// Handle a private room password change (creator only); the room
+		// receives room_password_changed
+		err := hub.ChangeRoomPassword(client, wsMsg.Data.Name, wsMsg.Data.OldPassword, wsMsg.Data.Password)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error changing room password: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Room '%s' password changed", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeGetRoomPolicy:
+		// Handle room policy lookup
+		policy, err := hub.GetRoomPolicy(wsMsg.Data.Name)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error getting room policy: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
 		}
+		policyJSON, _ := json.Marshal(policy)
+		policyMsg := []byte(fmt.Sprintf("ROOM_POLICY:%s", string(policyJSON)))
+		client.WriteMessage(context.Background(), policyMsg)
+
+	case types.MsgTypeMySessions:
+		// Handle listing the user's active connections
+		sessionsJSON, _ := json.Marshal(hub.ListSessions(client))
+		sessionsMsg := []byte(fmt.Sprintf("SESSIONS:%s", string(sessionsJSON)))
+		client.WriteMessage(context.Background(), sessionsMsg)
+
+	case types.MsgTypeGetPresence:
+		// Handle listing users online anywhere in the cluster
+		presenceJSON, _ := json.Marshal(hub.GetPresence())
+		presenceMsg := []byte(fmt.Sprintf("PRESENCE:%s", string(presenceJSON)))
+		client.WriteMessage(context.Background(), presenceMsg)
+
+	case types.MsgTypeListMembers:
+		// Handle listing a room's members with online flags
+		members, err := hub.ListMembers(wsMsg.Data.Name)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error listing members: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
+		membersJSON, _ := json.Marshal(members)
+		membersMsg := []byte(fmt.Sprintf("MEMBERS:%s", string(membersJSON)))
+		client.WriteMessage(context.Background(), membersMsg)
+
+	case types.MsgTypePollCreate:
+		// Handle starting a poll in the current room; the room receives poll_created
+		duration := time.Duration(wsMsg.Data.Duration) * time.Second
+		if _, err := hub.CreatePoll(client, wsMsg.Data.Question, wsMsg.Data.Options, duration); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error creating poll: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypePollVote:
+		// Handle voting; the room receives the updated tallies
+		if _, err := hub.VotePoll(client, wsMsg.Data.PollID, *wsMsg.Data.OptionIndex); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error voting: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypePollResults:
+		// Handle fetching a poll's vote counts
+		results, err := hub.GetPollResults(client, wsMsg.Data.PollID)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error getting poll results: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
+		results.Type = types.MsgTypePollResults
+		resultsJSON, _ := json.Marshal(results)
+		client.WriteMessage(context.Background(), resultsJSON)
+
+	case types.MsgTypeTerminateSession:
+		// Handle terminating another of the user's connections
+		if err := hub.TerminateSession(client, wsMsg.Data.SessionID); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error terminating session: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Session '%s' terminated", wsMsg.Data.SessionID))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeMoveUser:
+		// Handle moving a user's connections into a room (admins and the room's creator)
+		moved, err := hub.MoveUser(client, wsMsg.Data.To, wsMsg.Data.Name)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error moving user: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Moved %d connection(s) to room '%s'", moved, wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		}
+
+	case types.MsgTypeEditMessage:
+		// Handle editing a stored message; the room receives message_edited
+		if _, err := hub.EditMessage(client, wsMsg.Data.MessageID, wsMsg.Data.Content); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error editing message: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypeDeleteMessage:
+		// Handle deleting a stored message; the room receives message_deleted
+		if err := hub.DeleteMessage(client, wsMsg.Data.MessageID); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error deleting message: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypeGetUser:
+		// Handle looking up a user's public profile by username or user ID
+		profile, err := hub.GetUserProfile(client, wsMsg.Data.Name)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error getting user: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
+		profileJSON, _ := json.Marshal(profile)
+		profileMsg := []byte(fmt.Sprintf("USER:%s", string(profileJSON)))
+		client.WriteMessage(context.Background(), profileMsg)
+
+	case types.MsgTypeExportRoom:
+		// Handle exporting a room's messages as gzipped binary chunks (admins and the room's creator)
+		if err := hub.ExportRoom(client, wsMsg.Data.Name); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error exporting room: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypeDirectMessage:
+		// Handle direct message to a user on this or any other server, given
+		// by user ID or, looking them up across servers, by username
+		to := wsMsg.Data.To
+		send := hub.SendDirectMessage
+		if to == "" && wsMsg.Data.ToUsername != "" {
+			to = wsMsg.Data.ToUsername
+			send = hub.SendDirectMessageToUsername
+		}
+		delivered, err := send(client, to, wsMsg.Data.Content)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error sending direct message: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
+		statusJSON, _ := json.Marshal(types.DirectMessageStatus{
+			Type:      types.MsgTypeDirectMessageStatus,
+			To:        to,
+			Delivered: delivered,
+		})
+		client.WriteMessage(context.Background(), statusJSON)
 
 	case types.MsgTypeGetMessages:
 		// Handle getting messages for a room
@@ -133,9 +400,9 @@ func HandleWebSocketMessage(hub *hub.Hub, client *client.Client, wsMsg *types.We
 
 				// Get room ID as pgtype.UUID
 				var roomUUID pgtype.UUID
-				if err := roomUUID.Scan(currentRoom.ID); err != nil {
+				if err := roomUUID.Scan(currentRoom.GetID()); err != nil {
 					errorMsg := []byte(fmt.Sprintf("Error parsing room ID: %v", err))
-					client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+					client.WriteMessage(context.Background(), errorMsg)
 					break
 				}
 
@@ -153,50 +420,113 @@ func HandleWebSocketMessage(hub *hub.Hub, client *client.Client, wsMsg *types.We
 				messages, err := hub.Repo.ListMessagesByRoom(ctx, roomUUID, limit, offset)
 				if err != nil {
 					errorMsg := []byte(fmt.Sprintf("Error fetching messages: %v", err))
-					client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+					client.WriteMessage(context.Background(), errorMsg)
 					break
 				}
 
 				// Format messages as JSON
 				type MessageResponse struct {
+					ID        string `json:"id"`
 					Username  string `json:"username"`
 					Content   string `json:"content"`
 					Timestamp string `json:"timestamp"`
+					ReplyTo   string `json:"reply_to,omitempty"`
 				}
 
 				var messageResponses []MessageResponse
 				for _, msg := range messages {
-					messageResponses = append(messageResponses, MessageResponse{
+					response := MessageResponse{
+						ID:        uuid.UUID(msg.ID.Bytes).String(),
 						Username:  msg.Username,
 						Content:   msg.Content,
 						Timestamp: msg.CreatedAt.Time.Format(time.RFC3339),
-					})
+					}
+					if msg.ParentMessageID.Valid {
+						response.ReplyTo = uuid.UUID(msg.ParentMessageID.Bytes).String()
+					}
+					messageResponses = append(messageResponses, response)
 				}
 
 				// Send messages back to client
 				messagesJSON, _ := json.Marshal(messageResponses)
 				responseMsg := []byte(fmt.Sprintf("MESSAGES:%s", string(messagesJSON)))
-				client.Conn.Write(context.Background(), websocket.MessageText, responseMsg)
+				client.WriteMessage(context.Background(), responseMsg)
 			} else {
 				// User is in a different room
 				errorMsg := []byte("You can only get messages from the room you have joined")
-				client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+				client.WriteMessage(context.Background(), errorMsg)
 			}
 		} else {
 			// User is not in any room
 			errorMsg := []byte("You must join a room first to get messages")
-			client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypeTypingStart, types.MsgTypeTypingStop:
+		// Handle typing indicators for the current room
+		if err := hub.SetTyping(client, wsMsg.Type == types.MsgTypeTypingStart); err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		}
+
+	case types.MsgTypeThreadMessages:
+		// Handle fetching the replies to a message in the current room
+		thread, err := hub.ThreadMessages(client, wsMsg.Data.MessageID, wsMsg.Data.Limit)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
+		threadJSON, _ := json.Marshal(thread)
+		client.WriteMessage(context.Background(), threadJSON)
+
+	case types.MsgTypeSearchUsers:
+		// Handle searching users by part of their name, e.g. to pick someone to invite
+		results, err := hub.SearchUsers(client, wsMsg.Data.Query, wsMsg.Data.Limit)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error searching users: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+			break
+		}
+		resultsJSON, _ := json.Marshal(results)
+		client.WriteMessage(context.Background(), resultsJSON)
+
+	case types.MsgTypeSendInvite:
+		// Handle inviting a user to a room the sender is in
+		delivered, err := hub.SendInvite(client, wsMsg.Data.Name, wsMsg.Data.To)
+		if err != nil {
+			errorMsg := []byte(fmt.Sprintf("Error sending invite: %v", err))
+			client.WriteMessage(context.Background(), errorMsg)
+		} else if delivered {
+			successMsg := []byte(fmt.Sprintf("Invite to room '%s' sent", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
+		} else {
+			successMsg := []byte(fmt.Sprintf("Invite to room '%s' saved; the user is not online", wsMsg.Data.Name))
+			client.WriteMessage(context.Background(), successMsg)
 		}
 
 	default:
 		// Unknown message type
 		errorMsg := []byte(fmt.Sprintf("Unknown message type: %s", wsMsg.Type))
-		client.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+		client.WriteMessage(context.Background(), errorMsg)
 	}
 
 	return nil
 }
 
+// writeFilterError tells client why FilterMessage refused its message: as an
+// error frame when the reason has a code, such as links_not_allowed, otherwise
+// as text
+func writeFilterError(client *client.Client, err error) {
+	var validationErr validator.ValidationError
+	if errors.As(err, &validationErr) && validationErr.Code != "" {
+		frame, _ := json.Marshal(types.ErrorDTO{Type: types.MsgTypeError, Code: validationErr.Code, Message: validationErr.Message})
+		client.WriteMessage(context.Background(), frame)
+		return
+	}
+	client.WriteMessage(context.Background(), []byte(fmt.Sprintf("Error: %v", err)))
+}
+
 // ParseWebSocketMessage parses a WebSocket message from JSON
 func ParseWebSocketMessage(message []byte) (*types.WebSocketMessage, error) {
 	var wsMsg types.WebSocketMessage
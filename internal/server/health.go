@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"websocket-demo/internal/db"
+
+	"github.com/labstack/echo/v4"
+)
+
+// readinessTimeout bounds the database ping in GET /health/ready
+const readinessTimeout = 2 * time.Second
+
+// ReadinessResponse is returned by GET /health/ready
+type ReadinessResponse struct {
+	Status string        `json:"status"` // "ready" or "unavailable"
+	DB     string        `json:"db,omitempty"`
+	DBPool *db.PoolStats `json:"db_pool,omitempty"`
+}
+
+// Ready handles GET /health/ready, reporting whether the server can take
+// traffic. With a database it pings it and includes the pool statistics;
+// the in-memory store is always ready.
+func (s *Server) Ready(c echo.Context) error {
+	resp := ReadinessResponse{Status: "ready"}
+	if s.pool == nil {
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+	status := http.StatusOK
+	resp.DB = "ok"
+	if err := s.pool.Ping(ctx); err != nil {
+		status = http.StatusServiceUnavailable
+		resp.Status = "unavailable"
+		resp.DB = err.Error()
+	}
+	stats := db.GetPoolStats(s.pool)
+	resp.DBPool = &stats
+	return c.JSON(status, resp)
+}
+
+// DBStats handles GET /api/admin/db/stats, returning the database
+// connection pool statistics
+func (s *Server) DBStats(c echo.Context) error {
+	if s.pool == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "No database pool; the server uses in-memory storage"})
+	}
+	return c.JSON(http.StatusOK, db.GetPoolStats(s.pool))
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/hub"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newHealthTestServer starts a server using pool, with the test user as admin
+func newHealthTestServer(t *testing.T, pool *pgxpool.Pool) *httptest.Server {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+	server := newTestServer(h)
+	server.pool = pool
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func getJSON(t *testing.T, url, token string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(out))
+	return resp.StatusCode
+}
+
+func TestReadyWithoutDatabase(t *testing.T) {
+	testServer := newHealthTestServer(t, nil)
+
+	var ready ReadinessResponse
+	assert.Equal(t, http.StatusOK, getJSON(t, testServer.URL+"/health/ready", "", &ready))
+	assert.Equal(t, ReadinessResponse{Status: "ready"}, ready)
+
+	var body map[string]string
+	assert.Equal(t, http.StatusNotFound, getJSON(t, testServer.URL+"/api/admin/db/stats", generateTestJWT(t), &body))
+}
+
+func TestReadyDatabaseDown(t *testing.T) {
+	// The pool connects lazily, so it can be created against a closed port
+	cfg, err := pgxpool.ParseConfig("postgres://chatx@127.0.0.1:1/chatx?connect_timeout=1")
+	require.NoError(t, err)
+	cfg.MaxConns = 4
+	pool, err := pgxpool.NewWithConfig(context.Background(), cfg)
+	require.NoError(t, err)
+	defer pool.Close()
+	testServer := newHealthTestServer(t, pool)
+
+	var ready ReadinessResponse
+	assert.Equal(t, http.StatusServiceUnavailable, getJSON(t, testServer.URL+"/health/ready", "", &ready))
+	assert.Equal(t, "unavailable", ready.Status)
+	assert.NotEqual(t, "ok", ready.DB)
+	require.NotNil(t, ready.DBPool)
+	assert.Equal(t, int32(4), ready.DBPool.MaxConns)
+
+	var stats db.PoolStats
+	assert.Equal(t, http.StatusOK, getJSON(t, testServer.URL+"/api/admin/db/stats", generateTestJWT(t), &stats))
+	assert.Equal(t, int32(4), stats.MaxConns)
+	assert.Zero(t, stats.TotalConns)
+
+	var body map[string]string
+	assert.Equal(t, http.StatusUnauthorized, getJSON(t, testServer.URL+"/api/admin/db/stats", "", &body))
+}
+
+func TestReadyDatabaseStats(t *testing.T) {
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	pool, err := db.NewPool(context.Background(), url, db.DefaultPoolConfig())
+	require.NoError(t, err)
+	defer pool.Close()
+	testServer := newHealthTestServer(t, pool)
+
+	var ready ReadinessResponse
+	assert.Equal(t, http.StatusOK, getJSON(t, testServer.URL+"/health/ready", "", &ready))
+	assert.Equal(t, "ok", ready.DB)
+	require.NotNil(t, ready.DBPool)
+	assert.Positive(t, ready.DBPool.TotalConns)
+	assert.Positive(t, ready.DBPool.AcquireCount)
+
+	var stats db.PoolStats
+	assert.Equal(t, http.StatusOK, getJSON(t, testServer.URL+"/api/admin/db/stats", generateTestJWT(t), &stats))
+	assert.Positive(t, stats.TotalConns)
+	assert.Positive(t, stats.AcquireCount)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/validator"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// MaxImportMessages caps the messages accepted by one import request
+	MaxImportMessages = 10000
+	// importMaxLineBytes caps one JSON line, leaving room for escaping a maximum size message
+	importMaxLineBytes = 2 * validator.MaxMessageSize
+)
+
+// importStore is the subset of the repository used by the message import endpoint
+type importStore interface {
+	GetRoomByName(ctx context.Context, name string) (db.Room, error)
+	GetUserByUsername(ctx context.Context, username string) (db.User, error)
+	BulkCreateMessages(ctx context.Context, params []db.BulkCreateMessagesParams) ([]db.Message, error)
+}
+
+// ImportMessage is one line of a messages.jsonl import file
+type ImportMessage struct {
+	Username  string    `json:"username"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"` // Optional; the import time when omitted
+}
+
+// ImportResponse summarises an import; each error names the line it came from
+type ImportResponse struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Errors   []string `json:"errors"`
+}
+
+// ImportRoomMessages handles POST /api/admin/rooms/:name/import. The request is
+// multipart/form-data with a JSON Lines file in the "messages" field.
+func (s *Server) ImportRoomMessages(c echo.Context) error {
+	if s.imports == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Message import is not available"})
+	}
+
+	ctx := c.Request().Context()
+	roomName := c.Param("name")
+	dbRoom, err := s.imports.GetRoomByName(ctx, roomName)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Room not found"})
+	}
+
+	header, err := c.FormFile("messages")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A messages file is required"})
+	}
+	file, err := header.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read messages file"})
+	}
+	defer file.Close()
+
+	lines, err := readImportLines(bufio.NewScanner(file))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errTooManyImportMessages) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		return c.JSON(status, map[string]string{"error": err.Error()})
+	}
+
+	resp := ImportResponse{Errors: []string{}}
+	params := make([]db.BulkCreateMessagesParams, 0, len(lines))
+	users := make(map[string]pgtype.UUID) // username -> ID, invalid when the user doesn't exist
+	maxSize := s.validator.MaxMessageSize()
+	for _, line := range lines {
+		param, err := s.parseImportLine(ctx, line.text, dbRoom.ID, users, maxSize)
+		if err != nil {
+			resp.Skipped++
+			resp.Errors = append(resp.Errors, fmt.Sprintf("line %d: %v", line.number, err))
+			continue
+		}
+		params = append(params, param)
+	}
+
+	if len(params) > 0 {
+		messages, err := s.imports.BulkCreateMessages(ctx, params)
+		if err != nil {
+			log.Printf("Failed to import messages into room %s: %v", roomName, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to import messages"})
+		}
+		resp.Imported = len(messages)
+	}
+
+	log.Printf("Imported %d messages into room %s (%d skipped)", resp.Imported, roomName, resp.Skipped)
+	return c.JSON(http.StatusOK, resp)
+}
+
+var errTooManyImportMessages = fmt.Errorf("import exceeds %d messages", MaxImportMessages)
+
+// importLine is a non-empty line of an import file and its 1-based line number
+type importLine struct {
+	number int
+	text   string
+}
+
+// readImportLines reads the non-empty lines of an import file
+func readImportLines(scanner *bufio.Scanner) ([]importLine, error) {
+	scanner.Buffer(make([]byte, 0, 64*1024), importMaxLineBytes)
+
+	var lines []importLine
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if len(lines) == MaxImportMessages {
+			return nil, errTooManyImportMessages
+		}
+		lines = append(lines, importLine{number: number, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("line %d is too long", number+1)
+		}
+		return nil, fmt.Errorf("failed to read messages file: %w", err)
+	}
+	return lines, nil
+}
+
+// parseImportLine validates one import line and resolves its sender
+func (s *Server) parseImportLine(ctx context.Context, text string, roomID pgtype.UUID, users map[string]pgtype.UUID, maxSize int) (db.BulkCreateMessagesParams, error) {
+	var msg ImportMessage
+	if err := json.Unmarshal([]byte(text), &msg); err != nil {
+		return db.BulkCreateMessagesParams{}, errors.New("invalid JSON")
+	}
+	if msg.Username == "" {
+		return db.BulkCreateMessagesParams{}, errors.New("username is required")
+	}
+	if err := validator.ValidateMessageSize(len(msg.Content), maxSize); err != nil {
+		return db.BulkCreateMessagesParams{}, err
+	}
+
+	userID, cached := users[msg.Username]
+	if !cached {
+		if user, err := s.imports.GetUserByUsername(ctx, msg.Username); err == nil {
+			userID = user.ID
+		}
+		users[msg.Username] = userID
+	}
+	if !userID.Valid {
+		return db.BulkCreateMessagesParams{}, fmt.Errorf("unknown user %q", msg.Username)
+	}
+
+	param := db.BulkCreateMessagesParams{RoomID: roomID, UserID: userID, Content: msg.Content}
+	if !msg.CreatedAt.IsZero() {
+		param.CreatedAt = pgtype.Timestamptz{Time: msg.CreatedAt, Valid: true}
+	}
+	return param, nil
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/hub"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeImportStore is an in-memory importStore that can list what was imported
+type fakeImportStore struct {
+	mu       sync.Mutex
+	rooms    map[string]db.Room
+	users    map[string]db.User
+	messages []db.Message
+}
+
+func newFakeImportStore() *fakeImportStore {
+	return &fakeImportStore{rooms: make(map[string]db.Room), users: make(map[string]db.User)}
+}
+
+func (f *fakeImportStore) addUser(username string) db.User {
+	user := db.User{ID: newUUID(), Username: username}
+	f.users[username] = user
+	return user
+}
+
+func (f *fakeImportStore) GetRoomByName(ctx context.Context, name string) (db.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[name]
+	if !ok {
+		return db.Room{}, errors.New("no rows")
+	}
+	return room, nil
+}
+
+func (f *fakeImportStore) GetUserByUsername(ctx context.Context, username string) (db.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	user, ok := f.users[username]
+	if !ok {
+		return db.User{}, errors.New("no rows")
+	}
+	return user, nil
+}
+
+func (f *fakeImportStore) BulkCreateMessages(ctx context.Context, params []db.BulkCreateMessagesParams) ([]db.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	messages := make([]db.Message, len(params))
+	for i, p := range params {
+		if !p.CreatedAt.Valid {
+			p.CreatedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+		}
+		messages[i] = db.Message{ID: newUUID(), RoomID: p.RoomID, UserID: p.UserID, Content: p.Content, CreatedAt: p.CreatedAt}
+	}
+	f.messages = append(f.messages, messages...)
+	return messages, nil
+}
+
+// ListMessagesByRoom mirrors the repository query: newest first, with usernames
+func (f *fakeImportStore) ListMessagesByRoom(roomID pgtype.UUID) []db.ListMessagesByRoomRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	usernames := make(map[pgtype.UUID]string, len(f.users))
+	for _, user := range f.users {
+		usernames[user.ID] = user.Username
+	}
+
+	var rows []db.ListMessagesByRoomRow
+	for _, msg := range f.messages {
+		if msg.RoomID == roomID {
+			rows = append(rows, db.ListMessagesByRoomRow{
+				ID:        msg.ID,
+				RoomID:    msg.RoomID,
+				UserID:    msg.UserID,
+				Content:   msg.Content,
+				CreatedAt: msg.CreatedAt,
+				Username:  usernames[msg.UserID],
+			})
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].CreatedAt.Time.After(rows[j].CreatedAt.Time) })
+	return rows
+}
+
+// importRequest posts a JSON Lines file to the import endpoint
+func importRequest(t *testing.T, url, token string, body string) *http.Response {
+	t.Helper()
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, err := writer.CreateFormFile("messages", "messages.jsonl")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	req, _ := http.NewRequest(http.MethodPost, url, &form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestImportRoomMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	store := newFakeImportStore()
+	room := db.Room{ID: newUUID(), Name: "archive"}
+	store.rooms[room.Name] = room
+	alice := store.addUser("alice")
+	store.addUser("bob")
+
+	server := newTestServer(h)
+	server.imports = store
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+	url := testServer.URL + "/api/admin/rooms/archive/import"
+
+	// 500 messages from two users, one minute apart
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var lines []string
+	for i := 0; i < 500; i++ {
+		username := "alice"
+		if i%2 == 1 {
+			username = "bob"
+		}
+		line, _ := json.Marshal(ImportMessage{
+			Username:  username,
+			Content:   fmt.Sprintf("message %d", i),
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		})
+		lines = append(lines, string(line))
+	}
+
+	resp := importRequest(t, url, generateTestJWT(t), strings.Join(lines, "\n")+"\n")
+	var result ImportResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ImportResponse{Imported: 500, Errors: []string{}}, result)
+
+	rows := store.ListMessagesByRoom(room.ID)
+	require.Len(t, rows, 500)
+	for i, row := range rows {
+		n := 499 - i // Newest first
+		assert.Equal(t, fmt.Sprintf("message %d", n), row.Content)
+		assert.True(t, start.Add(time.Duration(n)*time.Minute).Equal(row.CreatedAt.Time), "timestamp of message %d", n)
+		if n%2 == 0 {
+			assert.Equal(t, "alice", row.Username)
+			assert.Equal(t, alice.ID, row.UserID)
+		} else {
+			assert.Equal(t, "bob", row.Username)
+		}
+	}
+
+	// Invalid lines are skipped and reported by line number
+	resp = importRequest(t, url, generateTestJWT(t), strings.Join([]string{
+		`{"username":"alice","content":"kept"}`,
+		`not json`,
+		``,
+		`{"username":"mallory","content":"who?"}`,
+		`{"username":"bob","content":""}`,
+		`{"content":"anonymous"}`,
+	}, "\n"))
+	result = ImportResponse{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, result.Imported)
+	assert.Equal(t, 4, result.Skipped)
+	require.Len(t, result.Errors, 4)
+	assert.Equal(t, "line 2: invalid JSON", result.Errors[0])
+	assert.Equal(t, `line 4: unknown user "mallory"`, result.Errors[1])
+	assert.True(t, strings.HasPrefix(result.Errors[2], "line 5: "))
+	assert.Equal(t, "line 6: username is required", result.Errors[3])
+	assert.Len(t, store.ListMessagesByRoom(room.ID), 501)
+
+	// Too many messages are rejected outright
+	big := strings.Repeat(`{"username":"alice","content":"x"}`+"\n", MaxImportMessages+1)
+	resp = importRequest(t, url, generateTestJWT(t), big)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Len(t, store.ListMessagesByRoom(room.ID), 501)
+
+	// Unknown rooms and non-admins are refused
+	resp = importRequest(t, testServer.URL+"/api/admin/rooms/missing/import", generateTestJWT(t), lines[0])
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp = importRequest(t, url, generateTestJWTFor(t, uuid.New().String(), "someone"), lines[0])
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
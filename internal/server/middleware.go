@@ -2,13 +2,65 @@ package server
 
 import (
 	"net/http"
+	"regexp"
 	"strings"
 
 	"websocket-demo/internal/auth"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
+var (
+	// requestIDPattern is what an incoming X-Request-ID must look like to be
+	// kept; anything else is replaced so it can't break up log lines
+	requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+	// traceParentPattern is a W3C Trace Context traceparent header:
+	// version-traceid-parentid-flags in lowercase hex
+	traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+)
+
+// RequestIDMiddleware gives every request an ID, reusing a well-formed
+// X-Request-ID from the client or generating a UUID. The ID is sent back in
+// X-Request-ID and stored in the context for GetRequestID.
+func RequestIDMiddleware() echo.MiddlewareFunc {
+	return middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		Generator: uuid.NewString,
+		RequestIDHandler: func(c echo.Context, requestID string) {
+			if !requestIDPattern.MatchString(requestID) {
+				requestID = uuid.NewString()
+				c.Request().Header.Set(echo.HeaderXRequestID, requestID)
+				c.Response().Header().Set(echo.HeaderXRequestID, requestID)
+			}
+			c.Set("request_id", requestID)
+		},
+	})
+}
+
+// GetRequestID retrieves the request ID from context (must be used after RequestIDMiddleware)
+func GetRequestID(c echo.Context) string {
+	if requestID, ok := c.Get("request_id").(string); ok {
+		return requestID
+	}
+	return ""
+}
+
+// ParseTraceParent returns a W3C traceparent header if it is well formed, or
+// an empty string. Version ff and all-zero trace or parent IDs are invalid.
+func ParseTraceParent(header string) string {
+	header = strings.TrimSpace(header)
+	if !traceParentPattern.MatchString(header) || strings.HasPrefix(header, "ff-") {
+		return ""
+	}
+	parts := strings.Split(header, "-")
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return ""
+	}
+	return header
+}
+
 // JWTMiddleware validates JWT tokens and adds user claims to context
 func (s *Server) JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
@@ -36,12 +88,23 @@ func (s *Server) JWTMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 		// Add user claims to context
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("email_verified", claims.EmailVerified)
 		c.Set("claims", claims)
 
 		return next(c)
 	}
 }
 
+// AdminMiddleware restricts access to users listed in ADMIN_USER_IDS (must be used after JWTMiddleware)
+func (s *Server) AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !s.adminIDs[GetUserID(c)] {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Admin access required"})
+		}
+		return next(c)
+	}
+}
+
 // GetUserID retrieves user ID from context (must be used after JWTMiddleware)
 func GetUserID(c echo.Context) string {
 	if userID, ok := c.Get("user_id").(string); ok {
@@ -58,6 +121,13 @@ func GetUsername(c echo.Context) string {
 	return ""
 }
 
+// GetEmailVerified reports whether the user has verified their email (must be
+// used after JWTMiddleware)
+func GetEmailVerified(c echo.Context) bool {
+	verified, _ := c.Get("email_verified").(bool)
+	return verified
+}
+
 // GetClaims retrieves claims from context (must be used after JWTMiddleware)
 func GetClaims(c echo.Context) *auth.Claims {
 	if claims, ok := c.Get("claims").(*auth.Claims); ok {
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// logBuffer collects log output from any goroutine
+type logBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the logged lines containing want
+func (b *logBuffer) lines(want string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		if strings.Contains(line, want) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// captureLogs sends the standard logger's output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	logs := &logBuffer{}
+	log.SetOutput(logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return logs
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(RequestIDMiddleware())
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, GetRequestID(c))
+	})
+
+	get := func(requestID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if requestID != "" {
+			req.Header.Set(echo.HeaderXRequestID, requestID)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("")
+	_, err := uuid.Parse(rec.Body.String())
+	assert.NoError(t, err, "a missing ID is generated")
+	assert.Equal(t, rec.Body.String(), rec.Header().Get(echo.HeaderXRequestID))
+
+	rec = get("upstream-42")
+	assert.Equal(t, "upstream-42", rec.Body.String(), "a well-formed ID is kept")
+	assert.Equal(t, "upstream-42", rec.Header().Get(echo.HeaderXRequestID))
+
+	rec = get("bad id\tinjected=1")
+	_, err = uuid.Parse(rec.Body.String())
+	assert.NoError(t, err, "a malformed ID is replaced")
+	assert.Equal(t, rec.Body.String(), rec.Header().Get(echo.HeaderXRequestID))
+}
+
+func TestParseTraceParent(t *testing.T) {
+	valid := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	assert.Equal(t, valid, ParseTraceParent(valid))
+	assert.Equal(t, valid, ParseTraceParent(" "+valid+" "))
+
+	for _, header := range []string{
+		"",
+		"garbage",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00F067AA0BA902B7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		assert.Empty(t, ParseTraceParent(header), header)
+	}
+}
+
+func TestWebSocketLogsRequestID(t *testing.T) {
+	logs := captureLogs(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+generateTestJWT(t))
+	header.Set(echo.HeaderXRequestID, "session-trace-1")
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	conn, resp, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws",
+		&websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	assert.Equal(t, "session-trace-1", resp.Header.Get(HeaderConnectionID))
+	assert.Equal(t, "session-trace-1", resp.Header.Get(echo.HeaderXRequestID))
+
+	readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer readCancel()
+	_, frame, err := conn.Read(readCtx)
+	require.NoError(t, err)
+	var connected types.ConnectedDTO
+	require.NoError(t, json.Unmarshal(frame, &connected))
+	require.NotEmpty(t, connected.MyConnID)
+
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`{"type":"chat","data":{"content":"hello"}}`)))
+	require.Eventually(t, func() bool {
+		return len(logs.lines("Parsed WebSocket message type: chat conn_id="+connected.MyConnID)) > 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Every line about this connection, from upgrade to message handling, carries the request ID
+	lines := logs.lines("conn_id=" + connected.MyConnID)
+	assert.GreaterOrEqual(t, len(lines), 5)
+	for _, line := range lines {
+		assert.Contains(t, line, "request_id=session-trace-1")
+	}
+}
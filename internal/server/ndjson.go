@@ -0,0 +1,37 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CapabilityNDJSON lets a client batch newline-delimited JSON messages in one frame
+const CapabilityNDJSON = "ndjson"
+
+// ParseCapabilities parses the comma-separated capabilities a client requested at handshake
+func ParseCapabilities(value string) map[string]bool {
+	capabilities := make(map[string]bool)
+	for _, capability := range strings.Split(value, ",") {
+		if capability = strings.ToLower(strings.TrimSpace(capability)); capability != "" {
+			capabilities[capability] = true
+		}
+	}
+	return capabilities
+}
+
+// SplitNDJSON splits a frame into its non-empty lines, rejecting frames with more than maxLines
+func SplitNDJSON(frame []byte, maxLines int) ([][]byte, error) {
+	lines := make([][]byte, 0, 1)
+	for _, line := range bytes.Split(frame, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if len(lines) == maxLines {
+			return nil, fmt.Errorf("batch exceeds %d messages", maxLines)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
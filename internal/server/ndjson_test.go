@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/hub"
+
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitNDJSON(t *testing.T) {
+	lines, err := SplitNDJSON([]byte("{\"type\":\"a\"}\r\n\n  {\"type\":\"b\"}\n"), 5)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(`{"type":"a"}`), []byte(`{"type":"b"}`)}, lines)
+
+	_, err = SplitNDJSON([]byte("{}\n{}\n{}"), 2)
+	assert.Error(t, err)
+
+	assert.Equal(t, map[string]bool{"ndjson": true, "other": true}, ParseCapabilities(" NDJSON, other,,"))
+}
+
+func TestWebSocketNDJSONBatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	server := newTestServer(h)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	batch := "{\"type\":\"create_room\",\"data\":{\"name\":\"batch-a\"}}\n{\"type\":\"create_room\",\"data\":{\"name\":\"batch-b\"}}"
+
+	// readReplies collects replies until both rooms are confirmed or the timeout passes
+	readReplies := func(conn *websocket.Conn) string {
+		readCtx, readCancel := context.WithTimeout(context.Background(), time.Second)
+		defer readCancel()
+		var replies strings.Builder
+		for !strings.Contains(replies.String(), "'batch-b' created") {
+			_, msg, err := conn.Read(readCtx)
+			if err != nil {
+				break
+			}
+			replies.Write(msg)
+			replies.WriteString("\n")
+		}
+		return replies.String()
+	}
+
+	// Without the capability a batch is a single malformed document
+	conn := createWebSocketConnection(t, testServer)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	requestRoomList(t, conn)
+	require.NoError(t, conn.Write(context.Background(), websocket.MessageText, []byte(batch)))
+	replies := readReplies(conn)
+	assert.Contains(t, replies, "Error parsing message")
+	assert.NotContains(t, replies, "created successfully")
+
+	// With ndjson negotiated every line is handled
+	u, _ := url.Parse(testServer.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+	u.RawQuery = "capabilities=ndjson"
+	header := map[string][]string{"Authorization": {"Bearer " + generateTestJWT(t)}}
+	batchConn, _, err := websocket.Dial(context.Background(), u.String(), &websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer batchConn.Close(websocket.StatusNormalClosure, "")
+	requestRoomList(t, batchConn)
+
+	require.NoError(t, batchConn.Write(context.Background(), websocket.MessageText, []byte(batch)))
+	replies = readReplies(batchConn)
+	assert.Contains(t, replies, "Room 'batch-a' created successfully")
+	assert.Contains(t, replies, "Room 'batch-b' created successfully")
+}
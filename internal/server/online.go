@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	hubpkg "websocket-demo/internal/hub"
+	"websocket-demo/internal/types"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// memberStore is the subset of the repository used to check room membership
+type memberStore interface {
+	GetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID) (string, error)
+}
+
+// ListOnlineMembers handles GET /api/rooms/:name/online, returning the users
+// connected to the room on this server. Only the room's members, stored or
+// connected, may ask. The ETag changes when users join or leave, and a
+// matching If-None-Match gets 304 Not Modified.
+func (s *Server) ListOnlineMembers(c echo.Context) error {
+	roomName := c.Param("name")
+	members, err := s.hub.ListClients(roomName)
+	if errors.Is(err, hubpkg.ErrRoomNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Room not found"})
+	}
+	if err != nil {
+		log.Printf("Failed to list clients of room %s: %v", roomName, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list online members"})
+	}
+
+	isMember, err := s.isRoomMember(c.Request().Context(), roomName, GetUserID(c), members)
+	if err != nil {
+		log.Printf("Failed to check membership of room %s: %v", roomName, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list online members"})
+	}
+	if !isMember {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only members of the room can see who is online"})
+	}
+
+	etag := onlineMembersETag(members)
+	c.Response().Header().Set("ETag", etag)
+	if etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSON(http.StatusOK, members)
+}
+
+// isRoomMember reports whether userID is connected to the room or, with a
+// store, a stored member of it
+func (s *Server) isRoomMember(ctx context.Context, roomName, userID string, online []types.RoomMemberDTO) (bool, error) {
+	if userID == "" {
+		return false, nil
+	}
+	for _, member := range online {
+		if member.UserID == userID {
+			return true, nil
+		}
+	}
+
+	targetRoom, exists := s.hub.GetRoom(roomName)
+	if s.members == nil || !exists || targetRoom.GetID() == "" {
+		return false, nil
+	}
+	var roomID, id pgtype.UUID
+	if roomID.Scan(targetRoom.GetID()) != nil || id.Scan(userID) != nil {
+		return false, nil
+	}
+	_, err := s.members.GetRoomMemberRole(ctx, roomID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// onlineMembersETag is a CRC32 of the members' sorted user IDs, falling
+// back to the name for clients without one
+func onlineMembersETag(members []types.RoomMemberDTO) string {
+	ids := make([]string, len(members))
+	for i, member := range members {
+		ids[i] = member.UserID
+		if ids[i] == "" {
+			ids[i] = member.Name
+		}
+	}
+	sort.Strings(ids)
+	return fmt.Sprintf(`"%08x"`, crc32.ChecksumIEEE([]byte(strings.Join(ids, ","))))
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks for GET
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// legacyOriginHeader is the origin header sent by pre-RFC 6455 WebSocket clients
+const legacyOriginHeader = "Sec-WebSocket-Origin"
+
+// SetOriginPatterns sets the origins allowed to open WebSocket connections, as
+// parsed by config.ParseOriginPatterns. With none set only same-host origins
+// are accepted.
+func (s *Server) SetOriginPatterns(patterns []string) {
+	s.originPatterns = patterns
+}
+
+// originValidator returns a check for the legacy Sec-WebSocket-Origin header.
+// websocket.Accept only checks Origin, so a handshake carrying a disallowed
+// legacy origin would otherwise slip through.
+func originValidator(patterns []string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		origin := r.Header.Get(legacyOriginHeader)
+		if origin == "" {
+			return nil
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid %s header %q", legacyOriginHeader, origin)
+		}
+		if strings.EqualFold(u.Host, r.Host) {
+			return nil
+		}
+
+		for _, pattern := range patterns {
+			target := u.Host
+			if strings.Contains(pattern, "://") {
+				target = u.Scheme + "://" + u.Host
+			}
+			if matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(target)); matched {
+				return nil
+			}
+		}
+		return fmt.Errorf("origin %q is not allowed", origin)
+	}
+}
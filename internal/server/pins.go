@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/types"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// pinStore is the subset of the repository used by the pin endpoints
+type pinStore interface {
+	GetRoomByName(ctx context.Context, name string) (db.Room, error)
+	GetMessageByID(ctx context.Context, id pgtype.UUID) (db.Message, error)
+	PinMessage(ctx context.Context, roomID, messageID, userID pgtype.UUID) error
+	UnpinMessage(ctx context.Context, roomID, messageID pgtype.UUID) (bool, error)
+	ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]db.ListPinnedMessagesRow, error)
+}
+
+// PinnedMessageDTO is a pinned message as returned to clients
+type PinnedMessageDTO struct {
+	MessageID string    `json:"message_id"`
+	Sender    string    `json:"sender"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	PinnedBy  string    `json:"pinned_by,omitempty"`
+	PinnedAt  time.Time `json:"pinned_at"`
+}
+
+// PinListResponse is the current pin list of a room
+type PinListResponse struct {
+	Type string             `json:"type,omitempty"`
+	Room string             `json:"room"`
+	Pins []PinnedMessageDTO `json:"pins"`
+}
+
+// PinMessage handles POST /api/rooms/:name/pin/:messageID
+func (s *Server) PinMessage(c echo.Context) error {
+	return s.updatePin(c, true)
+}
+
+// UnpinMessage handles DELETE /api/rooms/:name/pin/:messageID
+func (s *Server) UnpinMessage(c echo.Context) error {
+	return s.updatePin(c, false)
+}
+
+// updatePin pins or unpins a message and returns the room's current pin list
+func (s *Server) updatePin(c echo.Context, pin bool) error {
+	if s.pins == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Pinning is not available"})
+	}
+
+	var messageID pgtype.UUID
+	if err := messageID.Scan(c.Param("messageID")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid message ID"})
+	}
+
+	ctx := c.Request().Context()
+	roomName := c.Param("name")
+	dbRoom, err := s.pins.GetRoomByName(ctx, roomName)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Room not found"})
+	}
+
+	message, err := s.pins.GetMessageByID(ctx, messageID)
+	if err != nil || message.RoomID != dbRoom.ID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Message not found"})
+	}
+
+	userID := GetUserID(c)
+	if !s.canModerateRoom(userID, dbRoom) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the room creator or a moderator can pin messages"})
+	}
+
+	if pin {
+		var pinnedBy pgtype.UUID
+		pinnedBy.Scan(userID)
+		if err := s.pins.PinMessage(ctx, dbRoom.ID, messageID, pinnedBy); err != nil {
+			if errors.Is(err, repository.ErrPinLimitReached) {
+				return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Pin limit reached"})
+			}
+			log.Printf("Failed to pin message in room %s: %v", roomName, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to pin message"})
+		}
+	} else {
+		unpinned, err := s.pins.UnpinMessage(ctx, dbRoom.ID, messageID)
+		if err != nil {
+			log.Printf("Failed to unpin message in room %s: %v", roomName, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to unpin message"})
+		}
+		if !unpinned {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Message is not pinned"})
+		}
+	}
+
+	pins, err := s.listPins(ctx, dbRoom)
+	if err != nil {
+		log.Printf("Failed to list pinned messages for room %s: %v", roomName, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list pinned messages"})
+	}
+
+	s.broadcastPinUpdate(pins)
+	return c.JSON(http.StatusOK, pins)
+}
+
+// canModerateRoom reports whether a user may manage a room: its creator or an admin
+func (s *Server) canModerateRoom(userID string, dbRoom db.Room) bool {
+	if userID == "" {
+		return false
+	}
+	if s.adminIDs[userID] {
+		return true
+	}
+	return dbRoom.CreatorID.Valid && uuid.UUID(dbRoom.CreatorID.Bytes).String() == userID
+}
+
+// listPins loads the current pin list of a room
+func (s *Server) listPins(ctx context.Context, dbRoom db.Room) (PinListResponse, error) {
+	rows, err := s.pins.ListPinnedMessages(ctx, dbRoom.ID)
+	if err != nil {
+		return PinListResponse{}, err
+	}
+
+	pins := make([]PinnedMessageDTO, 0, len(rows))
+	for _, row := range rows {
+		pin := PinnedMessageDTO{
+			MessageID: uuid.UUID(row.MessageID.Bytes).String(),
+			Sender:    row.Username,
+			Content:   row.Content,
+			CreatedAt: row.CreatedAt.Time,
+			PinnedAt:  row.PinnedAt.Time,
+		}
+		if row.PinnedBy.Valid {
+			pin.PinnedBy = uuid.UUID(row.PinnedBy.Bytes).String()
+		}
+		pins = append(pins, pin)
+	}
+	return PinListResponse{Room: dbRoom.Name, Pins: pins}, nil
+}
+
+// broadcastPinUpdate pushes the new pin list to online members of the room
+func (s *Server) broadcastPinUpdate(pins PinListResponse) {
+	targetRoom, exists := s.hub.GetRoom(pins.Room)
+	if !exists {
+		return
+	}
+
+	pins.Type = types.MsgTypePinnedMessageUpdated
+	content, err := json.Marshal(pins)
+	if err != nil {
+		log.Printf("Failed to marshal pin update for room %s: %v", pins.Room, err)
+		return
+	}
+	s.hub.Broadcast <- types.Message{Content: content, Type: types.MsgTypePinnedMessageUpdated, Room: targetRoom}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePinStore is an in-memory pinStore
+type fakePinStore struct {
+	mu       sync.Mutex
+	rooms    map[string]db.Room
+	messages map[pgtype.UUID]db.Message
+	pins     map[pgtype.UUID][]pgtype.UUID // room ID -> pinned message IDs in pin order
+}
+
+func newFakePinStore() *fakePinStore {
+	return &fakePinStore{
+		rooms:    make(map[string]db.Room),
+		messages: make(map[pgtype.UUID]db.Message),
+		pins:     make(map[pgtype.UUID][]pgtype.UUID),
+	}
+}
+
+func newUUID() pgtype.UUID {
+	return pgtype.UUID{Bytes: uuid.New(), Valid: true}
+}
+
+func (f *fakePinStore) addRoom(name string, creatorID pgtype.UUID) db.Room {
+	room := db.Room{ID: newUUID(), Name: name, CreatorID: creatorID}
+	f.rooms[name] = room
+	return room
+}
+
+func (f *fakePinStore) addMessage(roomID pgtype.UUID, content string) db.Message {
+	msg := db.Message{ID: newUUID(), RoomID: roomID, UserID: newUUID(), Content: content}
+	f.messages[msg.ID] = msg
+	return msg
+}
+
+func (f *fakePinStore) GetRoomByName(ctx context.Context, name string) (db.Room, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	room, ok := f.rooms[name]
+	if !ok {
+		return db.Room{}, errors.New("no rows")
+	}
+	return room, nil
+}
+
+func (f *fakePinStore) GetMessageByID(ctx context.Context, id pgtype.UUID) (db.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msg, ok := f.messages[id]
+	if !ok {
+		return db.Message{}, errors.New("no rows")
+	}
+	return msg, nil
+}
+
+func (f *fakePinStore) PinMessage(ctx context.Context, roomID, messageID, userID pgtype.UUID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, id := range f.pins[roomID] {
+		if id == messageID {
+			return nil
+		}
+	}
+	if len(f.pins[roomID]) >= repository.MaxPinnedMessages {
+		return repository.ErrPinLimitReached
+	}
+	f.pins[roomID] = append(f.pins[roomID], messageID)
+	return nil
+}
+
+func (f *fakePinStore) UnpinMessage(ctx context.Context, roomID, messageID pgtype.UUID) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, id := range f.pins[roomID] {
+		if id == messageID {
+			f.pins[roomID] = append(f.pins[roomID][:i], f.pins[roomID][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakePinStore) ListPinnedMessages(ctx context.Context, roomID pgtype.UUID) ([]db.ListPinnedMessagesRow, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rows := make([]db.ListPinnedMessagesRow, 0, len(f.pins[roomID]))
+	for _, id := range f.pins[roomID] {
+		msg := f.messages[id]
+		rows = append(rows, db.ListPinnedMessagesRow{MessageID: id, Content: msg.Content, Username: "author"})
+	}
+	return rows, nil
+}
+
+func TestPinMessageEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	creatorID := uuid.New()
+	store := newFakePinStore()
+	room := store.addRoom("pins", pgtype.UUID{Bytes: creatorID, Valid: true})
+	messages := make([]db.Message, 6)
+	for i := range messages {
+		messages[i] = store.addMessage(room.ID, "message")
+	}
+	otherRoom := store.addRoom("other", newUUID())
+	foreign := store.addMessage(otherRoom.ID, "elsewhere")
+
+	server := newTestServer(h)
+	server.pins = store
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	creatorToken := generateTestJWTFor(t, creatorID.String(), "creator")
+	pinRequest := func(method, roomName, messageID, token string) (*http.Response, PinListResponse) {
+		req, _ := http.NewRequest(method, testServer.URL+"/api/rooms/"+roomName+"/pin/"+messageID, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var pins PinListResponse
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&pins))
+		}
+		return resp, pins
+	}
+	messageID := func(msg db.Message) string {
+		return uuid.UUID(msg.ID.Bytes).String()
+	}
+
+	// Pin five messages
+	for i := 0; i < repository.MaxPinnedMessages; i++ {
+		resp, pins := pinRequest(http.MethodPost, "pins", messageID(messages[i]), creatorToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Len(t, pins.Pins, i+1)
+	}
+
+	// A sixth pin exceeds the limit
+	resp, _ := pinRequest(http.MethodPost, "pins", messageID(messages[5]), creatorToken)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+
+	// Unpin one, then the sixth fits
+	resp, pins := pinRequest(http.MethodDelete, "pins", messageID(messages[0]), creatorToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, pins.Pins, repository.MaxPinnedMessages-1)
+
+	resp, pins = pinRequest(http.MethodPost, "pins", messageID(messages[5]), creatorToken)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, pins.Pins, repository.MaxPinnedMessages)
+	assert.Equal(t, "pins", pins.Room)
+	assert.Equal(t, messageID(messages[5]), pins.Pins[len(pins.Pins)-1].MessageID)
+
+	// Error cases
+	resp, _ = pinRequest(http.MethodPost, "pins", messageID(messages[1]), generateTestJWT(t))
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode, "non-creator")
+	resp, _ = pinRequest(http.MethodPost, "pins", "not-a-uuid", creatorToken)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "invalid message ID")
+	resp, _ = pinRequest(http.MethodPost, "missing", messageID(messages[1]), creatorToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "unknown room")
+	resp, _ = pinRequest(http.MethodPost, "pins", uuid.New().String(), creatorToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "unknown message")
+	resp, _ = pinRequest(http.MethodPost, "pins", messageID(foreign), creatorToken)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "message from another room")
+
+	// Admins can moderate any room
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	resp, _ = pinRequest(http.MethodDelete, "pins", messageID(messages[1]), generateTestJWT(t))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"websocket-demo/internal/client"
+	hubpkg "websocket-demo/internal/hub"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateRoomRequest is the body of POST /api/rooms
+type CreateRoomRequest struct {
+	Name       string `json:"name"`
+	Private    bool   `json:"private"`
+	Password   string `json:"password"`
+	MaxClients *int   `json:"max_clients"` // Omitted means the hub's MaxClientsPerRoom
+}
+
+// ListRooms handles GET /api/rooms, returning every room on this server
+// sorted by name as the WebSocket list_rooms message would for the user
+func (s *Server) ListRooms(c echo.Context) error {
+	roomList := s.hub.GetRoomList(s.userClient(c))
+	sort.Slice(roomList, func(i, j int) bool { return roomList[i].Name < roomList[j].Name })
+	return c.JSON(http.StatusOK, roomList)
+}
+
+// CreateRoom handles POST /api/rooms, creating a room owned by the
+// authenticated user the same way the WebSocket create_room message does
+func (s *Server) CreateRoom(c echo.Context) error {
+	var req CreateRoomRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	if errMsg := validateRoomCreation(s.validator, req.Name, req.Password, req.Private); errMsg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Validation failed", "details": errMsg})
+	}
+	maxClients, errMsg := roomCapacity(s.hub.Config(), req.MaxClients)
+	if errMsg != "" {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": "Validation failed", "details": errMsg})
+	}
+
+	// The user's own connection becomes the creator when they are online;
+	// otherwise a stand-in holds the room until they join it
+	userID, username := GetUserID(c), GetUsername(c)
+	creator := s.userClient(c)
+
+	if s.roomLimiter.CheckRateLimit(creator) {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Too many rooms created, try again shortly"})
+	}
+
+	newRoom, err := s.hub.CreateRoomAs(creator, req.Name, req.Private, req.Password, maxClients)
+	switch {
+	case errors.Is(err, repository.ErrRoomExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Room already exists"})
+	case errors.Is(err, hubpkg.ErrRoomNameConfusable):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, hubpkg.ErrMaxRoomsReached):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Room limit reached"})
+	case errors.Is(err, hubpkg.ErrRoomQuotaReached):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Room quota reached"})
+	case err != nil:
+		log.Printf("Failed to create room %s for user %s: %v", req.Name, userID, err)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	s.audit.LogRoomCreate(c.Request().Context(), userID, username, newRoom.Name, GetClientIP(c), GetUserAgent(c))
+
+	// An owned room is stored together with its creator's membership
+	memberCount := 0
+	if newRoom.GetID() != "" {
+		memberCount = 1
+	}
+	return c.JSON(http.StatusCreated, types.RoomDTO{
+		Name:              newRoom.Name,
+		Private:           newRoom.Private,
+		ClientCount:       newRoom.GetClientCount(),
+		MemberCount:       memberCount,
+		OnlineCount:       newRoom.GetClientCount(),
+		MaxClients:        newRoom.MaxClients,
+		IsCreator:         true,
+		SuppressJoinLeave: newRoom.SuppressesJoinLeave(),
+	})
+}
+
+// userClient returns the authenticated user's connection, or a stand-in
+// client acting for them when they are not connected to this server
+func (s *Server) userClient(c echo.Context) *client.Client {
+	userID := GetUserID(c)
+	if online := s.hub.UserClient(userID); online != nil {
+		return online
+	}
+	standIn := client.NewClient(nil, GetUsername(c))
+	standIn.UserID = userID
+	standIn.Authenticated = true
+	standIn.Admin = s.adminIDs[userID]
+	return standIn
+}
+
+// roomCapacity resolves the requested capacity of a new room: the hub's
+// MaxClientsPerRoom when omitted, otherwise a value from 1 to
+// MaxRoomCapacity. It returns the problem found, or "" when there is none.
+func roomCapacity(cfg hubpkg.HubConfig, requested *int) (int, string) {
+	if requested == nil {
+		return cfg.MaxClientsPerRoom, ""
+	}
+	if *requested < 1 || *requested > cfg.MaxRoomCapacity {
+		return 0, fmt.Sprintf("max_clients must be between 1 and %d", cfg.MaxRoomCapacity)
+	}
+	return *requested, ""
+}
+
+// joinCreatesRoom reports whether joining roomName would create it, which
+// happens with AutoCreateRooms when no such room exists
+func joinCreatesRoom(hub *hubpkg.Hub, roomName string) bool {
+	if !hub.Config().AutoCreateRooms {
+		return false
+	}
+	_, exists := hub.GetRoom(roomName)
+	return !exists
+}
+
+// validateRoomCreation checks a new room's name and password with v,
+// returning the problems found or "" when there are none
+func validateRoomCreation(v *validator.Validator, name, password string, private bool) string {
+	result := v.ValidateRoomCreation(name, password, private)
+	if result.Valid {
+		return ""
+	}
+	return validator.FormatValidationErrors(result.Errors)
+}
@@ -0,0 +1,389 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository/repositorytest"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateRoomREST(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	h := hub.NewHub(ctx, store, nil)
+	go h.Run()
+
+	server := newTestServer(h)
+	server.repo = store
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	alice, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+	aliceID := uuid.UUID(alice.ID.Bytes).String()
+	aliceToken := generateTestJWTFor(t, aliceID, "alice")
+
+	createRoom := func(token, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/rooms", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// Alice creates a room without a WebSocket connection
+	resp := createRoom(aliceToken, `{"name":"lounge","max_clients":5}`)
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	var dto types.RoomDTO
+	require.NoError(t, json.Unmarshal(body, &dto))
+	assert.Equal(t, "lounge", dto.Name)
+	assert.Contains(t, string(body), `"suppressJoinLeave":false`, "room fields are camelCase")
+	assert.Equal(t, 5, dto.MaxClients)
+	assert.True(t, dto.IsCreator)
+	assert.Equal(t, 1, dto.MemberCount)
+
+	lounge, ok := h.GetRoom("lounge")
+	require.True(t, ok)
+	assert.Equal(t, 5, lounge.MaxClients)
+	assert.Equal(t, aliceID, lounge.Creator.UserID)
+	stored, err := store.GetRoomByName(ctx, "lounge")
+	require.NoError(t, err)
+	assert.Equal(t, alice.ID, stored.CreatorID, "the creator is stored though they weren't connected")
+
+	assert.Equal(t, http.StatusUnauthorized, createRoom("", `{"name":"other"}`).StatusCode)
+	assert.Equal(t, http.StatusConflict, createRoom(aliceToken, `{"name":"lounge"}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, createRoom(aliceToken, `{"name":"bad/name"}`).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, createRoom(aliceToken, `{"name":"vault","private":true,"password":"abc"}`).StatusCode)
+
+	// Bob joins the REST-created room over WebSocket
+	dial := func(userID, name string) *websocket.Conn {
+		t.Helper()
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+generateTestJWTFor(t, userID, name))
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws", &websocket.DialOptions{HTTPHeader: header})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.CloseNow() })
+		requestRoomList(t, conn)
+		return conn
+	}
+	send := func(conn *websocket.Conn, msg, want string) {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			if strings.Contains(string(reply), want) {
+				return
+			}
+		}
+	}
+	bobConn := dial(uuid.UUID(bob.ID.Bytes).String(), "bob")
+	send(bobConn, `{"type":"join_room","data":{"name":"lounge"}}`, "Welcome to room 'lounge'")
+	assert.Equal(t, aliceID, lounge.Creator.UserID, "joining first doesn't make bob the creator")
+
+	// Alice's connection takes over as creator when she joins
+	aliceConn := dial(aliceID, "alice")
+	send(aliceConn, `{"type":"join_room","data":{"name":"lounge"}}`, "Welcome to room 'lounge'")
+	assert.True(t, lounge.IsCreator(h.UserClient(aliceID)))
+}
+
+func TestCreateRoomMaxClients(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+	capacity := h.Config().MaxRoomCapacity
+
+	server := newTestServer(h)
+	server.roomLimiter = NewWebSocketRateLimiterWithLimit(10) // Rejected attempts count too
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	token := generateTestJWTFor(t, uuid.NewString(), "alice")
+	createRoom := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/rooms", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnprocessableEntity, createRoom(`{"name":"empty","max_clients":0}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, createRoom(`{"name":"huge","max_clients":`+strconv.Itoa(capacity+1)+`}`))
+	assert.Equal(t, http.StatusCreated, createRoom(`{"name":"roomy","max_clients":`+strconv.Itoa(capacity)+`}`))
+	for _, name := range []string{"empty", "huge"} {
+		_, exists := h.GetRoom(name)
+		assert.False(t, exists, name)
+	}
+
+	// The WebSocket create_room message is held to the same range
+	conn := createWebSocketConnection(t, testServer)
+	require.NotNil(t, conn)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+	send := func(msg, want string) {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			if strings.Contains(string(reply), want) {
+				return
+			}
+		}
+	}
+	send(`{"type":"create_room","data":{"name":"empty","max_clients":0}}`, "Error creating room: max_clients must be between 1 and "+strconv.Itoa(capacity))
+	send(`{"type":"create_room","data":{"name":"huge","max_clients":`+strconv.Itoa(capacity+1)+`}}`, "Error creating room: max_clients must be between 1 and "+strconv.Itoa(capacity))
+	send(`{"type":"create_room","data":{"name":"small","max_clients":3}}`, "Room 'small' created successfully")
+	send(`{"type":"create_room","data":{"name":"plain"}}`, "Room 'plain' created successfully")
+
+	// Both listings report each room's capacity
+	req := httptest.NewRequest(http.MethodGet, "/api/rooms", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	server.echo.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var rooms []types.RoomDTO
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rooms))
+	capacities := make(map[string]int)
+	for _, dto := range rooms {
+		capacities[dto.Name] = dto.MaxClients
+	}
+	assert.Equal(t, capacity, capacities["roomy"])
+	assert.Equal(t, 3, capacities["small"])
+	assert.Equal(t, h.Config().MaxClientsPerRoom, capacities["plain"])
+	assert.NotContains(t, capacities, "empty")
+	assert.True(t, sort.SliceIsSorted(rooms, func(i, j int) bool { return rooms[i].Name < rooms[j].Name }))
+
+	send(`{"type":"list_rooms"}`, `"max_clients":3,`)
+}
+
+func TestJoinRoomAutoCreatesOverWebSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+	cfg := h.Config()
+	cfg.AutoCreateRooms = true
+	_, err := h.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	server := newTestServer(h)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	conn := createWebSocketConnection(t, testServer)
+	require.NotNil(t, conn)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+
+	send := func(msg, want string) {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			if strings.Contains(string(reply), want) {
+				return
+			}
+		}
+	}
+	send(`{"type":"join_room","data":{"name":"bad/name"}}`, "Error joining room: room name can only contain")
+	send(`{"type":"join_room","data":{"name":"ad-hoc"}}`, "Welcome to room 'ad-hoc'")
+
+	adHoc, exists := h.GetRoom("ad-hoc")
+	require.True(t, exists)
+	assert.Equal(t, 1, adHoc.GetClientCount())
+	_, exists = h.GetRoom("bad/name")
+	assert.False(t, exists)
+}
+
+func TestCreateRoomRESTRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+
+	server := newTestServer(h)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	token := generateTestJWTFor(t, uuid.NewString(), "alice")
+	statuses := make([]int, 0, MaxRoomCreationsPerSecond+1)
+	for _, name := range []string{"one", "two", "three"} {
+		req, err := http.NewRequest(http.MethodPost, testServer.URL+"/api/rooms", strings.NewReader(`{"name":"`+name+`"}`))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		statuses = append(statuses, resp.StatusCode)
+	}
+
+	// Rooms beyond MaxRoomCreationsPerSecond within a second are refused
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests}, statuses)
+	_, exists := h.GetRoom("three")
+	assert.False(t, exists)
+}
+
+func TestCreateRoomRESTQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	go h.Run()
+	cfg := h.Config()
+	cfg.MaxRoomsPerUser = 1
+	_, err := h.ReloadConfig(cfg)
+	require.NoError(t, err)
+
+	server := newTestServer(h)
+	server.SetupRoutes()
+	token := generateTestJWTFor(t, uuid.NewString(), "alice")
+	createRoom := func(name string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/rooms", strings.NewReader(`{"name":"`+name+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusCreated, createRoom("one"))
+	assert.Equal(t, http.StatusForbidden, createRoom("two"))
+	_, exists := h.GetRoom("two")
+	assert.False(t, exists)
+}
+
+func TestListOnlineMembers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	h := hub.NewHub(ctx, store, nil)
+	go h.Run()
+	_, err := h.CreateRoom("ops", false, "", 10)
+	require.NoError(t, err)
+
+	server := newTestServer(h)
+	server.repo = store
+	server.members = store
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	ids := make(map[string]string)
+	conns := make(map[string]*websocket.Conn)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := store.CreateUser(ctx, name, name+"@example.com", "hash")
+		require.NoError(t, err)
+		ids[name] = uuid.UUID(user.ID.Bytes).String()
+		header := http.Header{}
+		header.Set("Authorization", "Bearer "+generateTestJWTFor(t, ids[name], name))
+		conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws", &websocket.DialOptions{HTTPHeader: header})
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.CloseNow() })
+		requestRoomList(t, conn)
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`{"type":"join_room","data":{"name":"ops"}}`)))
+		conns[name] = conn
+	}
+	ops, ok := h.GetRoom("ops")
+	require.True(t, ok)
+	require.Eventually(t, func() bool { return ops.GetClientCount() == 3 }, 2*time.Second, 10*time.Millisecond)
+
+	list := func(userID, name, etag string) (*http.Response, []types.RoomMemberDTO) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/rooms/ops/online", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+generateTestJWTFor(t, userID, name))
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var members []types.RoomMemberDTO
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&members))
+		}
+		return resp, members
+	}
+
+	resp, members := list(ids["alice"], "alice", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, members, 3)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, []string{members[0].Name, members[1].Name, members[2].Name})
+	assert.Equal(t, types.UserStatusOnline, members[0].Status)
+	assert.False(t, members[0].JoinedAt.IsZero())
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// An unchanged list isn't sent again
+	resp, _ = list(ids["bob"], "bob", etag)
+	assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+
+	// Carol disconnects
+	conns["carol"].Close(websocket.StatusNormalClosure, "")
+	require.Eventually(t, func() bool { return ops.GetClientCount() == 2 }, 2*time.Second, 10*time.Millisecond)
+	resp, members = list(ids["alice"], "alice", etag)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, members, 2)
+	assert.NotEqual(t, etag, resp.Header.Get("ETag"))
+
+	// Carol is still a stored member; a stranger isn't
+	resp, _ = list(ids["carol"], "carol", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = list(uuid.NewString(), "mallory", "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/rooms/missing/online", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+generateTestJWTFor(t, ids["alice"], "alice"))
+	missing, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	missing.Body.Close()
+	assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+}
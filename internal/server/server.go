@@ -2,24 +2,29 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"websocket-demo/internal/auth"
 	"websocket-demo/internal/client"
+	"websocket-demo/internal/config"
 	"websocket-demo/internal/hub"
+	"websocket-demo/internal/metrics"
 	"websocket-demo/internal/repository"
+	"websocket-demo/internal/tracing"
 	"websocket-demo/internal/types"
 	"websocket-demo/internal/validator"
 
 	"github.com/coder/websocket"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"golang.org/x/crypto/bcrypt"
@@ -29,41 +34,140 @@ type Server struct {
 	hub        *hub.Hub
 	echo       *echo.Echo
 	csrf       *CSRFProtection
-	repo       *repository.Repository
+	repo       repository.Store
 	jwtService *auth.JWTService
+	pool       *pgxpool.Pool
+	adminIDs   map[string]bool
+	statsCache adminStatsCache
+	pins       pinStore
+	imports    importStore
+	accounts   accountStore
+	bootstrap  bootstrapStore
+	flags      flagStore
+	analytics  analyticsStore
+	audit      *AuditLogger
+	validator  *validator.Validator // Shared with the hub; see hub.Validator
+
+	verifications verificationStore
+	emailSender   EmailSender
+	frontendURL   string // Where VerifyEmail sends users once verified
+	publicURL     string // Base of links to this server in emails
+
+	deletedRooms deletedRoomStore
+	members      memberStore
+	transcripts  transcriptStore
+
+	searchLimiter *WebSocketRateLimiter // search_users requests per user
+	roomLimiter   *WebSocketRateLimiter // Rooms created per user, over WebSocket or REST
+
+	maxBatchLines  int      // Messages allowed in one NDJSON frame
+	originPatterns []string // Extra origins allowed to open WebSocket connections
+	analyticsCache analyticsCache
 }
 
-func NewServer(hub *hub.Hub, repo *repository.Repository) *Server {
+// NewServer creates a server using the settings in cfg, which config.Load
+// has already validated
+func NewServer(hub *hub.Hub, repo repository.Store, pool *pgxpool.Pool, cfg *config.Config) *Server {
 	e := echo.New()
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET environment variable is required")
+	jwtService, err := auth.NewJWTService(cfg.JWTSecret, cfg.JWTExpiry)
+	if err != nil {
+		log.Fatalf("Failed to create JWT service: %v", err)
 	}
+	jwtService.SetLeeway(cfg.JWTLeeway)
+
+	s := &Server{
+		hub:            hub,
+		echo:           e,
+		csrf:           NewCSRFProtection(),
+		repo:           repo,
+		jwtService:     jwtService,
+		pool:           pool,
+		adminIDs:       adminIDSet(cfg.AdminUserIDs),
+		audit:          NewAuditLogger(nil),
+		validator:      hub.Validator(),
+		maxBatchLines:  cfg.MaxBatchLines,
+		searchLimiter:  NewWebSocketRateLimiterWithLimit(MaxSearchesPerSecond),
+		roomLimiter:    NewWebSocketRateLimiterWithLimit(MaxRoomCreationsPerSecond),
+		originPatterns: cfg.WSAllowedOrigins,
+		emailSender:    NewLogEmailSender(),
+		frontendURL:    cfg.FrontendURL,
+		publicURL:      cfg.PublicURL,
+	}
+	if repo != nil {
+		s.pins = repo
+		s.imports = repo
+		s.accounts = repo
+		s.bootstrap = repo
+		s.flags = repo
+		s.analytics = repo
+		s.deletedRooms = repo
+		s.verifications = repo
+		s.members = repo
+		s.transcripts = repo
+	}
+	if pgRepo, ok := repo.(*repository.Repository); ok {
+		s.audit = NewAuditLogger(pgRepo.GetQueries())
+	}
+	hub.SetPasswordLockoutHandler(func(c *client.Client, roomName string, failures int) {
+		s.audit.LogRoomPasswordLockout(context.Background(), c.UserID, c.Name, roomName, failures, c.RemoteAddr, c.UserAgent)
+	})
+	return s
+}
 
-	jwtService, _ := auth.NewJWTService(jwtSecret, "24h")
-
-	return &Server{
-		hub:        hub,
-		echo:       e,
-		csrf:       NewCSRFProtection(),
-		repo:       repo,
-		jwtService: jwtService,
+// adminIDSet turns the configured admin user IDs into a lookup set
+func adminIDSet(ids []string) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
 	}
+	return set
 }
 
+// HeaderConnectionID carries the request ID in WebSocket upgrade responses
+const HeaderConnectionID = "X-ChatX-Connection-ID"
+
 func (s *Server) SetupRoutes() {
+	s.echo.Use(RequestIDMiddleware())
 	s.echo.Use(middleware.Logger())
 	s.echo.Use(middleware.Recover())
-	s.echo.Use(middleware.CORS())
+	s.echo.Use(middleware.CORSWithConfig(middleware.CORSConfig{
+		ExposeHeaders: []string{echo.HeaderXRequestID, HeaderConnectionID},
+	}))
 
 	s.echo.GET("/", func(c echo.Context) error {
 		return c.String(http.StatusOK, "WebSocket Server is running")
 	})
 
+	s.echo.GET("/metrics", echo.WrapHandler(metrics.NewPrometheusExporter(s.hub.Metrics)))
+	s.echo.GET("/health/ready", s.Ready)
+
 	api := s.echo.Group("/api")
 	api.POST("/register", s.Register)
 	api.POST("/login", s.Login)
+	api.GET("/auth/verify-email", s.VerifyEmail)
+	api.GET("/csrf-token", s.GetCSRFToken, s.JWTMiddleware)
+	api.PUT("/profile/password", s.ChangePassword, s.JWTMiddleware, s.CSRFMiddleware)
+	api.DELETE("/profile", s.DeleteAccount, s.JWTMiddleware, s.CSRFMiddleware)
+	api.GET("/bootstrap", s.Bootstrap, s.JWTMiddleware)
+
+	rooms := api.Group("/rooms", s.JWTMiddleware)
+	rooms.GET("", s.ListRooms)
+	rooms.POST("", s.CreateRoom)
+	rooms.GET("/:name/online", s.ListOnlineMembers)
+	rooms.GET("/:id/export", s.ExportTranscript)
+	rooms.POST("/:name/pin/:messageID", s.PinMessage)
+	rooms.DELETE("/:name/pin/:messageID", s.UnpinMessage)
+
+	admin := api.Group("/admin", s.JWTMiddleware, s.AdminMiddleware)
+	admin.GET("/stats", s.AdminStats)
+	admin.GET("/db/stats", s.DBStats)
+	admin.POST("/rooms/:name/import", s.ImportRoomMessages)
+	admin.POST("/config", s.UpdateHubConfig)
+	admin.GET("/flagged-messages", s.ListFlaggedMessages)
+	admin.GET("/analytics", s.Analytics)
+	admin.GET("/deleted-rooms", s.ListDeletedRooms)
+	admin.GET("/deleted-rooms/:id/messages", s.ListDeletedRoomMessages)
 
 	s.echo.GET("/ws", s.HandleWebSocket)
 }
@@ -80,9 +184,10 @@ type LoginRequest struct {
 }
 
 type AuthResponse struct {
-	Token    string `json:"token"`
-	Username string `json:"username"`
-	UserID   string `json:"user_id"`
+	Token         string `json:"token"`
+	Username      string `json:"username"`
+	UserID        string `json:"user_id"`
+	EmailVerified bool   `json:"email_verified"`
 }
 
 func (s *Server) Register(c echo.Context) error {
@@ -91,13 +196,12 @@ func (s *Server) Register(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 
-	// Validate input
-	validationResult := validator.ValidateRegistration(req.Username, req.Email, req.Password)
+	// Validate input; fullwidth letters and invisible characters are folded
+	// away first so the stored name is the one others see
+	req.Username = validator.NormalizeName(req.Username)
+	validationResult := s.validator.ValidateRegistration(req.Username, req.Email, req.Password)
 	if !validationResult.Valid {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":   "Validation failed",
-			"details": validator.FormatValidationErrors(validationResult.Errors),
-		})
+		return validationFailed(c, validationResult.Errors)
 	}
 
 	// Check if user already exists
@@ -106,6 +210,11 @@ func (s *Server) Register(c echo.Context) error {
 	if err == nil {
 		return c.JSON(http.StatusConflict, map[string]string{"error": "User already exists"})
 	}
+	// Usernames differing only in case would be mixed up by lookups; look-alike
+	// names are refused by CreateUser
+	if _, err := s.repo.GetUserByUsername(ctx, req.Username); err == nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Username is already taken"})
+	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
@@ -114,14 +223,39 @@ func (s *Server) Register(c echo.Context) error {
 	}
 
 	// Create user
-	_, err = s.repo.CreateUser(ctx, req.Username, req.Email, string(hashedPassword))
+	user, err := s.repo.CreateUser(ctx, req.Username, req.Email, string(hashedPassword))
+	if errors.Is(err, repository.ErrUsernameTaken) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Username is already taken"})
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
 	}
 
+	// The account works without verifying; its tokens say it isn't verified
+	if err := s.sendVerificationEmail(c, user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", uuid.UUID(user.ID.Bytes), err)
+	}
+
 	return c.JSON(http.StatusCreated, map[string]string{"message": "User registered successfully"})
 }
 
+// ValidationFailedResponse lists why a request's input was refused; Errors
+// carries a code per field for clients to explain, such as a password being
+// too similar to the username
+type ValidationFailedResponse struct {
+	Error   string                      `json:"error"`
+	Details string                      `json:"details"`
+	Errors  []validator.ValidationError `json:"errors"`
+}
+
+func validationFailed(c echo.Context, errs []validator.ValidationError) error {
+	return c.JSON(http.StatusBadRequest, ValidationFailedResponse{
+		Error:   "Validation failed",
+		Details: validator.FormatValidationErrors(errs),
+		Errors:  errs,
+	})
+}
+
 func (s *Server) Login(c echo.Context) error {
 	var req LoginRequest
 	if err := c.Bind(&req); err != nil {
@@ -152,16 +286,17 @@ func (s *Server) Login(c echo.Context) error {
 	// Update last login
 	s.repo.UpdateUserLastLogin(ctx, user.ID, pgtype.Timestamptz{Time: time.Now(), Valid: true})
 
-	// Generate token
-	token, err := s.jwtService.GenerateToken(uuid.UUID(user.ID.Bytes).String(), user.Username)
+	// Generate token; unverified users can log in, flagged in its claims
+	token, err := s.jwtService.GenerateToken(uuid.UUID(user.ID.Bytes).String(), user.Username, user.EmailVerified)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate token"})
 	}
 
 	return c.JSON(http.StatusOK, AuthResponse{
-		Token:    token,
-		Username: user.Username,
-		UserID:   uuid.UUID(user.ID.Bytes).String(),
+		Token:         token,
+		Username:      user.Username,
+		UserID:        uuid.UUID(user.ID.Bytes).String(),
+		EmailVerified: user.EmailVerified,
 	})
 }
 
@@ -169,13 +304,25 @@ func (s *Server) Start(addr string) error {
 	return s.echo.Start(addr)
 }
 
+// Shutdown stops the HTTP server and drains the hub's NATS connection, in
+// case the hub didn't get to it before the drain timeout in main
 func (s *Server) Shutdown() error {
-	return s.echo.Close()
+	err := s.echo.Close()
+	if s.hub != nil && s.hub.NATS != nil {
+		if drainErr := s.hub.NATS.DrainAndClose(s.hub.NATS.DrainTimeout()); drainErr != nil && err == nil {
+			err = drainErr
+		}
+	}
+	return err
 }
 
 // HandleWebSocket handles individual WebSocket client connections with JWT authentication
 func (s *Server) HandleWebSocket(c echo.Context) error {
-	log.Printf("New WebSocket connection attempt from %s", c.RealIP())
+	// Every log line for this connection is tagged with its client's ID and
+	// the ID of the request that opened it
+	connID := uuid.NewString()
+	requestID := GetRequestID(c)
+	log.Printf("New WebSocket connection attempt from %s conn_id=%s request_id=%s", c.RealIP(), connID, requestID)
 
 	// Extract and validate JWT token from Authorization header
 	authHeader := c.Request().Header.Get("Authorization")
@@ -186,20 +333,30 @@ func (s *Server) HandleWebSocket(c echo.Context) error {
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	claims, err := s.jwtService.ValidateToken(token)
 	if err != nil {
-		log.Printf("JWT validation failed: %v", err)
+		log.Printf("JWT validation failed: %v conn_id=%s request_id=%s", err, connID, requestID)
 		return echo.NewHTTPError(401, "Invalid token")
 	}
+	if !s.accountExists(c.Request().Context(), claims.UserID) {
+		return echo.NewHTTPError(401, "Account no longer exists")
+	}
 
+	if err := originValidator(s.originPatterns)(c.Request()); err != nil {
+		log.Printf("WebSocket origin rejected: %v conn_id=%s request_id=%s", err, connID, requestID)
+		return echo.NewHTTPError(403, "Origin not allowed")
+	}
+
+	// Let the client correlate its connection with the server's logs
+	c.Response().Header().Set(HeaderConnectionID, requestID)
 	opts := &websocket.AcceptOptions{
-		OriginPatterns: []string{"*"},
+		OriginPatterns: s.originPatterns,
 	}
 
 	conn, err := websocket.Accept(c.Response(), c.Request(), opts)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		log.Printf("WebSocket upgrade error: %v conn_id=%s request_id=%s", err, connID, requestID)
 		return echo.NewHTTPError(400, "WebSocket upgrade failed")
 	}
-	log.Printf("WebSocket connection established successfully")
+	log.Printf("WebSocket connection established successfully conn_id=%s request_id=%s", connID, requestID)
 
 	defer func() {
 		if conn != nil {
@@ -216,27 +373,33 @@ func (s *Server) HandleWebSocket(c echo.Context) error {
 		userName = claims.Username
 		userID = claims.UserID
 		authenticated = true
-		log.Printf("Authenticated client: %s (ID: %s)", userName, userID)
+		log.Printf("Authenticated client: %s (ID: %s) conn_id=%s request_id=%s", userName, userID, connID, requestID)
 	} else {
 		// Fallback for unauthenticated connections
 		userName = fmt.Sprintf("User%d", rand.Intn(9000)+1000)
 	}
 
 	newClient := client.NewClient(conn, userName)
+	newClient.ID = connID
+	newClient.RequestID = requestID
+	newClient.TraceParent = ParseTraceParent(c.Request().Header.Get("traceparent"))
+	newClient.RemoteAddr = c.RealIP()
+	newClient.UserAgent = c.Request().UserAgent()
 	if authenticated {
 		newClient.Authenticated = true
 		newClient.UserID = userID
+		newClient.Admin = s.adminIDs[userID]
 	} else {
 		// Create anonymous user for database persistence
 		ctx := context.Background()
 		email := fmt.Sprintf("%s@anonymous.local", userName)
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(""), bcrypt.DefaultCost)
 		if err != nil {
-			log.Printf("Failed to hash empty password for anonymous user: %v", err)
+			log.Printf("Failed to hash empty password for anonymous user: %v conn_id=%s request_id=%s", err, connID, requestID)
 		} else {
 			user, err := s.repo.CreateUser(ctx, userName, email, string(hashedPassword))
 			if err != nil {
-				log.Printf("Failed to create anonymous user: %v", err)
+				log.Printf("Failed to create anonymous user: %v conn_id=%s request_id=%s", err, connID, requestID)
 			} else {
 				newClient.UserID = uuid.UUID(user.ID.Bytes).String()
 			}
@@ -245,77 +408,146 @@ func (s *Server) HandleWebSocket(c echo.Context) error {
 
 	// Register the client
 	s.hub.Register <- newClient
-	log.Printf("Client %s queued for registration", userName)
+	log.Printf("Client %s queued for registration conn_id=%s request_id=%s", userName, connID, requestID)
 
 	// Wait for this client's registration to complete with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(s.hub.Ctx, 5*time.Second)
 	defer cancel()
 
 	select {
 	case <-newClient.Registered:
-		log.Printf("Registration confirmed for %s", userName)
+		// Accounts already at their connection limit are refused on
+		// registration; the socket is accepted first so the client sees why
+		// it was closed
+		if newClient.Refused {
+			log.Printf("Registration refused for %s by the connection limit conn_id=%s request_id=%s", userName, connID, requestID)
+			conn.Close(websocket.StatusPolicyViolation, hub.ConnectionLimitReason)
+			conn = nil
+			return nil
+		}
+		log.Printf("Registration confirmed for %s conn_id=%s request_id=%s", userName, connID, requestID)
 	case <-ctx.Done():
-		log.Printf("Registration timeout for %s", userName)
+		log.Printf("Registration timeout for %s conn_id=%s request_id=%s", userName, connID, requestID)
 		return echo.NewHTTPError(408, "Registration timeout")
 	}
 
-	// Welcome message removed
+	// Tell the client its connection ID so it can tag its own logs
+	if connected, err := json.Marshal(types.ConnectedDTO{Type: types.MsgTypeConnected, MyConnID: connID}); err == nil {
+		newClient.WriteMessage(context.Background(), connected)
+	}
 
 	// Get max message size limit
-	maxMessageSize := validator.GetMaxMessageSize()
-	log.Printf("WebSocket message size limit set to: %d bytes", maxMessageSize)
+	maxMessageSize := s.validator.MaxMessageSize()
+	log.Printf("WebSocket message size limit set to: %d bytes conn_id=%s request_id=%s", maxMessageSize, connID, requestID)
+
+	// Optional protocol features requested at handshake, e.g. ?capabilities=ndjson
+	capabilities := ParseCapabilities(c.QueryParam("capabilities"))
+	maxBatchLines := s.maxBatchLines
 
+	// Reads stop as soon as the hub shuts down, rather than when it gets
+	// around to closing this connection
+	readCtx := s.hub.Ctx
 	for {
-		_, message, err := conn.Read(context.Background())
+		_, message, err := conn.Read(readCtx)
 		if err != nil {
-			log.Printf("Read message error from %s: %v", userName, err)
+			if readCtx.Err() != nil {
+				// The hub has stopped and closes every connection itself, so
+				// there is no one left to unregister from
+				log.Printf("Read loop for %s stopped by shutdown conn_id=%s request_id=%s", userName, connID, requestID)
+				break
+			}
+			log.Printf("Read message error from %s: %v conn_id=%s request_id=%s", userName, err, connID, requestID)
 			s.hub.Unregister <- newClient
+			s.searchLimiter.RemoveClient(newClient.UserID)
+			s.roomLimiter.RemoveClient(newClient.UserID)
 			break
 		}
 
 		// Validate message size
 		if err := validator.ValidateMessageSize(len(message), maxMessageSize); err != nil {
-			log.Printf("Message size validation failed from %s: %v (size: %d)", userName, err, len(message))
+			log.Printf("Message size validation failed from %s: %v (size: %d) conn_id=%s request_id=%s", userName, err, len(message), connID, requestID)
 			errorMsg := []byte(fmt.Sprintf("Message rejected: %v", err))
-			newClient.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
+			newClient.WriteMessage(context.Background(), errorMsg)
 			continue // Skip processing this message
 		}
 
-		log.Printf("Received message from %s: %s (size: %d bytes)", userName, string(message), len(message))
+		log.Printf("Received message from %s: %s (size: %d bytes) conn_id=%s request_id=%s", userName, string(message), len(message), connID, requestID)
 
-		// Parse WebSocket message
-		wsMsg, err := ParseWebSocketMessage(message)
-		if err != nil {
-			log.Printf("Error parsing WebSocket message from %s: %v", userName, err)
-			errorMsg := []byte(fmt.Sprintf("Error parsing message: %v", err))
-			newClient.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
-		} else if wsMsg != nil {
-			log.Printf("Parsed WebSocket message type: %s", wsMsg.Type)
-			err := HandleWebSocketMessage(s.hub, newClient, wsMsg)
+		// Clients that negotiated ndjson may batch several messages per frame
+		frames := [][]byte{message}
+		if capabilities[CapabilityNDJSON] {
+			frames, err = SplitNDJSON(message, maxBatchLines)
 			if err != nil {
-				log.Printf("Error handling WebSocket message from %s: %v", userName, err)
-				errorMsg := []byte(fmt.Sprintf("Error: %v", err))
-				newClient.Conn.Write(context.Background(), websocket.MessageText, errorMsg)
-			}
-		} else {
-			// Handle legacy chat messages
-			timestamp := time.Now().Format("15:04:05")
-			formattedMsg := []byte(fmt.Sprintf("[%s] %s: %s", timestamp, userName, string(message)))
-			log.Printf("Attempting to send message from %s to broadcast channel", userName)
-
-			// Send to broadcast channel with timeout
-			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-			defer cancel()
-
-			select {
-			case s.hub.Broadcast <- types.Message{Content: formattedMsg, Sender: newClient, Type: types.MsgTypeChat}:
-				log.Printf("Message from %s queued for broadcast", userName)
-			case <-ctx.Done():
-				log.Printf("Broadcast timeout for %s", userName)
-				// Continue processing other messages
+				errorMsg := []byte(fmt.Sprintf("Message rejected: %v", err))
+				newClient.WriteMessage(context.Background(), errorMsg)
+				continue
 			}
 		}
+		for _, frame := range frames {
+			s.handleFrame(newClient, frame)
+		}
 	}
 
 	return nil
 }
+
+// handleFrame parses and dispatches a single JSON message from a client
+func (s *Server) handleFrame(c *client.Client, message []byte) {
+	// Reject deeply nested or oversized JSON before decoding it; malformed
+	// JSON is left for the parser to report
+	var validationErr validator.ValidationError
+	err := validator.ValidateJSONPayload(message, validator.MaxJSONDepthDefault, validator.MaxJSONKeyLengthDefault, validator.MaxJSONArrayLengthDefault)
+	if errors.As(err, &validationErr) {
+		log.Printf("JSON payload validation failed from %s: %v conn_id=%s request_id=%s", c.Name, err, c.ID, c.RequestID)
+		errorMsg := []byte(fmt.Sprintf("Message rejected: %v", err))
+		c.WriteMessage(context.Background(), errorMsg)
+		return
+	}
+
+	// Parse WebSocket message
+	wsMsg, err := ParseWebSocketMessage(message)
+	if err != nil {
+		log.Printf("Error parsing WebSocket message from %s: %v conn_id=%s request_id=%s", c.Name, err, c.ID, c.RequestID)
+		errorMsg := []byte(fmt.Sprintf("Error parsing message: %v", err))
+		c.WriteMessage(context.Background(), errorMsg)
+	} else if wsMsg != nil {
+		log.Printf("Parsed WebSocket message type: %s conn_id=%s request_id=%s", wsMsg.Type, c.ID, c.RequestID)
+		// User searches query the database, so they get a tighter limit of their own
+		if wsMsg.Type == types.MsgTypeSearchUsers && s.searchLimiter.CheckRateLimit(c) {
+			c.WriteMessage(context.Background(), []byte("Error searching users: too many searches, try again shortly"))
+			return
+		}
+		createsRoom := wsMsg.Type == types.MsgTypeCreateRoom || wsMsg.Type == types.MsgTypeJoinRoom && joinCreatesRoom(s.hub, wsMsg.Data.Name)
+		if createsRoom && s.roomLimiter.CheckRateLimit(c) {
+			c.WriteMessage(context.Background(), []byte("Error creating room: too many rooms created, try again shortly"))
+			return
+		}
+		ctx, span := startMessageSpan(c, wsMsg.Type, wsMsg.Data.Name)
+		err := HandleWebSocketMessage(ctx, s.hub, c, wsMsg)
+		endMessageSpan(span, err)
+		if err != nil {
+			log.Printf("Error handling WebSocket message from %s: %v conn_id=%s request_id=%s", c.Name, err, c.ID, c.RequestID)
+			errorMsg := []byte(fmt.Sprintf("Error: %v", err))
+			c.WriteMessage(context.Background(), errorMsg)
+		}
+	} else {
+		// Handle legacy chat messages
+		timestamp := time.Now().Format("15:04:05")
+		formattedMsg := []byte(fmt.Sprintf("[%s] %s: %s", timestamp, c.Name, string(message)))
+		log.Printf("Attempting to send message from %s to broadcast channel conn_id=%s request_id=%s", c.Name, c.ID, c.RequestID)
+		spanCtx, span := startMessageSpan(c, types.MsgTypeChat, "")
+		defer span.End()
+
+		// Send to broadcast channel with timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+		defer cancel()
+
+		select {
+		case s.hub.Broadcast <- types.Message{Content: formattedMsg, Sender: c, Type: types.MsgTypeChat, TraceContext: tracing.Inject(spanCtx)}:
+			log.Printf("Message from %s queued for broadcast conn_id=%s request_id=%s", c.Name, c.ID, c.RequestID)
+		case <-ctx.Done():
+			log.Printf("Broadcast timeout for %s conn_id=%s request_id=%s", c.Name, c.ID, c.RequestID)
+			// Continue processing other messages
+		}
+	}
+}
@@ -2,18 +2,25 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"websocket-demo/internal/auth"
+	"websocket-demo/internal/config"
 	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository/repositorytest"
+	"websocket-demo/internal/types"
+	"websocket-demo/internal/validator"
 
 	"github.com/coder/websocket"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,10 +28,15 @@ import (
 
 // generateTestJWT creates a JWT token for testing
 func generateTestJWT(t *testing.T) string {
+	return generateTestJWTFor(t, "test-user-id", "testuser")
+}
+
+// generateTestJWTFor creates a JWT token for a specific test user
+func generateTestJWTFor(t *testing.T, userID, username string) string {
 	jwtService, err := auth.NewJWTService("test-secret-key-that-is-at-least-32-characters-long", "24h")
 	require.NoError(t, err)
 
-	token, err := jwtService.GenerateToken("test-user-id", "testuser")
+	token, err := jwtService.GenerateToken(userID, username, true)
 	require.NoError(t, err)
 	return token
 }
@@ -42,6 +54,12 @@ func newTestServer(h *hub.Hub) *Server {
 		csrf:       NewCSRFProtection(),
 		repo:       nil,
 		jwtService: jwtService,
+		audit:      NewAuditLogger(nil),
+		validator:  h.Validator(),
+
+		maxBatchLines: 50,
+		searchLimiter: NewWebSocketRateLimiterWithLimit(MaxSearchesPerSecond),
+		roomLimiter:   NewWebSocketRateLimiterWithLimit(MaxRoomCreationsPerSecond),
 	}
 }
 
@@ -67,18 +85,32 @@ func createWebSocketConnection(t *testing.T, testServer *httptest.Server) *webso
 
 func TestNewServer(t *testing.T) {
 	ctx := context.Background()
-	hub := hub.NewHub(ctx, nil) // No repository needed for this test
+	hub := hub.NewHub(ctx, nil, nil) // No repository needed for this test
 
-	server := newTestServer(hub)
+	server := NewServer(hub, nil, nil, &config.Config{
+		JWTSecret:        "test-secret-key-that-is-at-least-32-characters-long",
+		JWTExpiry:        "24h",
+		AdminUserIDs:     []string{"admin-1", "admin-2"},
+		MaxBatchLines:    10,
+		WSAllowedOrigins: []string{"https://app.example.com"},
+	})
 
 	assert.NotNil(t, server)
 	assert.NotNil(t, server.echo)
 	assert.Equal(t, hub, server.hub)
+	assert.Equal(t, map[string]bool{"admin-1": true, "admin-2": true}, server.adminIDs)
+	assert.Equal(t, 10, server.maxBatchLines)
+	assert.Equal(t, []string{"https://app.example.com"}, server.originPatterns)
+
+	// Tokens from the configured secret are accepted
+	claims, err := server.jwtService.ValidateToken(generateTestJWT(t))
+	require.NoError(t, err)
+	assert.Equal(t, "test-user-id", claims.UserID)
 }
 
 func TestSetupRoutes(t *testing.T) {
 	ctx := context.Background()
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	server := newTestServer(hub)
 
 	server.SetupRoutes()
@@ -107,7 +139,7 @@ func TestServerStart(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -129,7 +161,7 @@ func TestWebSocketConnection(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -143,20 +175,16 @@ func TestWebSocketConnection(t *testing.T) {
 	conn := createWebSocketConnection(t, testServer)
 	defer conn.Close(websocket.StatusNormalClosure, "")
 
-	// Verify we can read at least one message (welcome message)
-	readCtx, readCancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer readCancel()
-
-	_, msg, err := conn.Read(readCtx)
-	require.NoError(t, err, "Should be able to read welcome message")
-	assert.NotEmpty(t, msg, "Welcome message should not be empty")
+	// Verify the connection round-trips a request
+	msg := requestRoomList(t, conn)
+	assert.NotEmpty(t, msg, "Room list response should not be empty")
 }
 
 func TestMultipleWebSocketConnections(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -178,9 +206,8 @@ func TestMultipleWebSocketConnections(t *testing.T) {
 			conn := createWebSocketConnection(t, testServer)
 			connections[id] = conn
 
-			// Read welcome message
-			_, _, err := conn.Read(context.Background())
-			assert.NoError(t, err)
+			// Verify the connection round-trips a request
+			assert.NoError(t, roundTrip(conn))
 		}(i)
 	}
 
@@ -198,7 +225,7 @@ func TestWebSocketMessageSend(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -212,24 +239,135 @@ func TestWebSocketMessageSend(t *testing.T) {
 	conn := createWebSocketConnection(t, testServer)
 	defer conn.Close(websocket.StatusNormalClosure, "")
 
-	// Read welcome message
-	_, _, err := conn.Read(context.Background())
-	require.NoError(t, err)
+	// Wait until the client is registered
+	requestRoomList(t, conn)
 
 	// Send a chat message
 	testMsg := `{"type":"chat","data":{"content":"Hello, World!"}}`
-	err = conn.Write(context.Background(), websocket.MessageText, []byte(testMsg))
+	err := conn.Write(context.Background(), websocket.MessageText, []byte(testMsg))
 	require.NoError(t, err)
 
 	// Give time for message to be processed
 	time.Sleep(100 * time.Millisecond)
 }
 
+func TestWebSocketSendsConnectionID(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	// connID reads the connected frame that opens every connection
+	connID := func(conn *websocket.Conn) string {
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		_, frame, err := conn.Read(readCtx)
+		require.NoError(t, err)
+		var connected types.ConnectedDTO
+		require.NoError(t, json.Unmarshal(frame, &connected))
+		assert.Equal(t, types.MsgTypeConnected, connected.Type)
+		return connected.MyConnID
+	}
+
+	first := createWebSocketConnection(t, testServer)
+	defer first.Close(websocket.StatusNormalClosure, "")
+	second := createWebSocketConnection(t, testServer)
+	defer second.Close(websocket.StatusNormalClosure, "")
+
+	firstID, secondID := connID(first), connID(second)
+	assert.NotEmpty(t, firstID)
+	assert.NotEqual(t, firstID, secondID, "the same user's connections have their own IDs")
+}
+
+func TestWebSocketConnectionLimitPerUser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	cfg := h.Config()
+	cfg.MaxConnectionsPerUser = 2
+	_, err := h.ReloadConfig(cfg)
+	require.NoError(t, err)
+	go h.Run()
+
+	server := newTestServer(h)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	// read returns the next frame or the error closing the connection
+	read := func(conn *websocket.Conn) error {
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		_, _, err := conn.Read(readCtx)
+		return err
+	}
+
+	first := createWebSocketConnection(t, testServer)
+	defer first.CloseNow()
+	require.NoError(t, read(first))
+	second := createWebSocketConnection(t, testServer)
+	defer second.CloseNow()
+	require.NoError(t, read(second))
+
+	third := createWebSocketConnection(t, testServer)
+	defer third.CloseNow()
+	err = read(third)
+	assert.Equal(t, websocket.StatusPolicyViolation, websocket.CloseStatus(err))
+	var closeErr websocket.CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, hub.ConnectionLimitReason, closeErr.Reason)
+
+	// Closing one frees a slot
+	first.Close(websocket.StatusNormalClosure, "")
+	require.Eventually(t, func() bool {
+		return h.UserConnectionCount("test-user-id") == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	fourth := createWebSocketConnection(t, testServer)
+	defer fourth.CloseNow()
+	assert.NoError(t, read(fourth))
+}
+
+func TestWebSocketRejectsNestedJSON(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	conn := createWebSocketConnection(t, testServer)
+	defer conn.Close(websocket.StatusNormalClosure, "")
+	requestRoomList(t, conn)
+
+	deep := `{"type":"create_room","data":` + strings.Repeat(`{"a":`, 10) + `{}` + strings.Repeat(`}`, 10) + `}`
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(deep)))
+
+	readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+	defer readCancel()
+	_, reply, err := conn.Read(readCtx)
+	require.NoError(t, err)
+	assert.Contains(t, string(reply), "Message rejected: message: JSON must be nested at most 10 levels deep")
+
+	// The connection keeps working
+	requestRoomList(t, conn)
+}
+
 func TestWebSocketRoomOperations(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -243,11 +381,8 @@ func TestWebSocketRoomOperations(t *testing.T) {
 	conn := createWebSocketConnection(t, testServer)
 	defer conn.Close(websocket.StatusNormalClosure, "")
 
-	// Read welcome message and join notification
-	for i := 0; i < 2; i++ {
-		_, _, err := conn.Read(context.Background())
-		require.NoError(t, err)
-	}
+	// Wait until the client is registered
+	requestRoomList(t, conn)
 
 	// Create a room
 	createRoomMsg := `{"type":"create_room","data":{"name":"test-room","private":false,"password":""}}`
@@ -279,6 +414,286 @@ func TestWebSocketRoomOperations(t *testing.T) {
 	assert.True(t, found, "Should receive ROOMS_LIST response")
 }
 
+func TestWebSocketGetMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := hub.NewHub(ctx, store, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.repo = store
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	token := generateTestJWTFor(t, uuid.UUID(user.ID.Bytes).String(), "alice")
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws",
+		&websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+
+	send := func(msg, want string) {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			if strings.Contains(string(reply), want) {
+				return
+			}
+		}
+	}
+	send(`{"type":"get_messages","data":{"name":"history"}}`, "You must join a room first")
+	send(`{"type":"create_room","data":{"name":"history"}}`, "created successfully")
+	send(`{"type":"join_room","data":{"name":"history"}}`, "history")
+	send(`{"type":"room_message","data":{"content":"first"}}`, "Message sent to room")
+	send(`{"type":"room_message","data":{"content":"second"}}`, "Message sent to room")
+
+	readMessages := func(data string) []map[string]string {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(`{"type":"get_messages","data":`+data+`}`)))
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err)
+			if body, ok := strings.CutPrefix(string(reply), "MESSAGES:"); ok {
+				var messages []map[string]string
+				require.NoError(t, json.Unmarshal([]byte(body), &messages))
+				return messages
+			}
+		}
+	}
+
+	// Newest first, paged by limit and offset
+	messages := readMessages(`{"name":"history"}`)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "second", messages[0]["content"])
+	assert.Equal(t, "first", messages[1]["content"])
+	assert.Equal(t, "alice", messages[0]["username"])
+
+	messages = readMessages(`{"name":"history","limit":1,"offset":1}`)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "first", messages[0]["content"])
+
+	send(`{"type":"get_messages","data":{"name":"elsewhere"}}`, "only get messages from the room you have joined")
+}
+
+func TestWebSocketSuppressesDuplicateMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	hub := hub.NewHub(ctx, store, nil)
+	cfg := hub.Config()
+	cfg.MessageDedupWindow = time.Minute
+	_, err := hub.ReloadConfig(cfg)
+	require.NoError(t, err)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.repo = store
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	user, err := store.CreateUser(ctx, "alice", "alice@example.com", "hash")
+	require.NoError(t, err)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+generateTestJWTFor(t, uuid.UUID(user.ID.Bytes).String(), "alice"))
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws",
+		&websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+
+	// send writes msg and returns the frames read up to the first containing want
+	send := func(msg, want string) []string {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 2*time.Second)
+		defer readCancel()
+		var frames []string
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			frames = append(frames, string(reply))
+			if strings.Contains(string(reply), want) {
+				return frames
+			}
+		}
+	}
+	send(`{"type":"create_room","data":{"name":"lagged"}}`, "created successfully")
+	send(`{"type":"join_room","data":{"name":"lagged"}}`, "lagged")
+	send(`{"type":"room_message","data":{"content":"hello there"}}`, "Message sent to room")
+
+	// The resend is acked like the original but not broadcast again
+	frames := send(`{"type":"room_message","data":{"content":"  Hello   THERE "}}`, "Message sent to room")
+	assert.Equal(t, []string{"Message sent to room"}, frames)
+	send(`{"type":"room_message","data":{"content":"something else"}}`, "Message sent to room")
+
+	var contents []string
+	for _, m := range store.Messages() {
+		contents = append(contents, m.Content)
+	}
+	assert.ElementsMatch(t, []string{"hello there", "something else"}, contents)
+}
+
+func TestWebSocketChangeRoomPassword(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	conn := createWebSocketConnection(t, testServer)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+
+	send := func(msg, want string) {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			if strings.Contains(string(reply), want) {
+				return
+			}
+		}
+	}
+	send(`{"type":"create_room","data":{"name":"vault","private":true,"password":"old-secret"}}`, "created successfully")
+	send(`{"type":"join_room","data":{"name":"vault","password":"old-secret"}}`, "vault")
+	send(`{"type":"change_room_password","data":{"name":"vault","old_password":"wrong","password":"new-secret"}}`,
+		"Error changing room password: invalid password")
+	send(`{"type":"change_room_password","data":{"name":"vault","old_password":"old-secret","password":"new-secret"}}`,
+		`{"type":"room_password_changed","room":"vault","changed_by":"testuser"}`)
+}
+
+func TestWebSocketRejectsIncompleteMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	conn := createWebSocketConnection(t, testServer)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+
+	send := func(msg string) string {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer readCancel()
+		_, reply, err := conn.Read(readCtx)
+		require.NoError(t, err)
+		return string(reply)
+	}
+	assert.Equal(t, "Message rejected: name is required", send(`{"type":"create_room","data":{"name":"  "}}`))
+	assert.Equal(t, "Message rejected: message type is required", send(`{"data":{"name":"lobby"}}`))
+	assert.Equal(t, "Message rejected: message_id is required; content is required", send(`{"type":"edit_message"}`))
+	assert.Equal(t, "Message rejected: no option selected", send(`{"type":"vote_poll","data":{"poll_id":"p1"}}`))
+	assert.Equal(t, "Unknown message type: shout", send(`{"type":"shout"}`))
+
+	hub.Mutex.RLock()
+	defer hub.Mutex.RUnlock()
+	assert.NotContains(t, hub.Rooms, "  ", "no room was created")
+}
+
+func TestWebSocketLinkPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+	v, err := validator.New(validator.Config{LinkPolicy: validator.LinkPolicy{Mode: validator.LinkModeNone}})
+	require.NoError(t, err)
+	hub.SetValidator(v)
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	conn := createWebSocketConnection(t, testServer)
+	defer conn.CloseNow()
+	requestRoomList(t, conn)
+
+	send := func(msg, want string) {
+		t.Helper()
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, []byte(msg)))
+		readCtx, readCancel := context.WithTimeout(ctx, 5*time.Second)
+		defer readCancel()
+		for {
+			_, reply, err := conn.Read(readCtx)
+			require.NoError(t, err, "waiting for %q", want)
+			if strings.Contains(string(reply), want) {
+				return
+			}
+		}
+	}
+	send(`{"type":"chat","data":{"content":"see hxxp://example[.]com"}}`,
+		`{"type":"error","code":"links_not_allowed","message":"links are not allowed"}`)
+	send(`{"type":"create_room","data":{"name":"wiki"}}`, "created successfully")
+	send(`{"type":"set_room_settings","data":{"name":"wiki","link_policy":{"mode":"allowlist","allowed_domains":["wiki.example.com"]}}}`,
+		"Room 'wiki' settings updated")
+	send(`{"type":"get_room_policy","data":{"name":"wiki"}}`, `"link_policy":{"mode":"allowlist","allowed_domains":["wiki.example.com"]}`)
+}
+
+// roundTrip sends a list_rooms request and waits for the ROOMS_LIST reply,
+// which proves the connection has been registered with the hub
+func roundTrip(conn *websocket.Conn) error {
+	_, err := readRoomList(conn)
+	return err
+}
+
+// requestRoomList is roundTrip for the test goroutine, returning the reply
+func requestRoomList(t *testing.T, conn *websocket.Conn) []byte {
+	msg, err := readRoomList(conn)
+	require.NoError(t, err, "Should receive ROOMS_LIST response")
+	return msg
+}
+
+func readRoomList(conn *websocket.Conn) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := conn.Write(ctx, websocket.MessageText, []byte(`{"type":"list_rooms"}`)); err != nil {
+		return nil, err
+	}
+	for {
+		_, msg, err := conn.Read(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if contains(string(msg), "ROOMS_LIST") {
+			return msg, nil
+		}
+	}
+}
+
 // Helper function to check if string contains substring
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > len(substr) && findSubstring(s, substr))
@@ -301,7 +716,7 @@ func TestWebSocketConnectionRaceConditions(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -341,33 +756,58 @@ func TestWebSocketConnectionRaceConditions(t *testing.T) {
 func TestServerGracefulShutdown(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
 	server.SetupRoutes()
 
-	// Start test server
-	testServer := httptest.NewServer(server.echo)
+	// Start test server, noting when the WebSocket handler returns
+	handlerDone := make(chan struct{})
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.echo.ServeHTTP(w, r)
+		if r.URL.Path == "/ws" {
+			close(handlerDone)
+		}
+	}))
+	defer testServer.Close()
 
 	// Connect a client
 	conn := createWebSocketConnection(t, testServer)
 	require.NotNil(t, conn)
+	defer conn.Close(websocket.StatusNormalClosure, "")
 
-	// Read welcome message
-	_, _, err := conn.Read(context.Background())
-	require.NoError(t, err)
+	// Wait until the client is registered
+	requestRoomList(t, conn)
 
 	// Trigger graceful shutdown
 	cancel()
 	server.Shutdown()
-	testServer.Close()
 
-	// Close client connection
-	conn.Close(websocket.StatusNormalClosure, "")
+	// The read loop exits on cancellation instead of waiting for the
+	// connection to drop
+	select {
+	case <-handlerDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("WebSocket handler did not return after shutdown")
+	}
+	select {
+	case <-hub.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("hub did not stop after shutdown")
+	}
 
-	// Give time for cleanup
-	time.Sleep(100 * time.Millisecond)
+	// Nothing was left queued for the stopped hub and the client sees the
+	// connection end
+	assert.Empty(t, hub.Unregister)
+	readCtx, readCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer readCancel()
+	for {
+		if _, _, err := conn.Read(readCtx); err != nil {
+			assert.NoError(t, readCtx.Err())
+			break
+		}
+	}
 }
 
 func TestConcurrentServerOperations(t *testing.T) {
@@ -378,7 +818,7 @@ func TestConcurrentServerOperations(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	hub := hub.NewHub(ctx, nil)
+	hub := hub.NewHub(ctx, nil, nil)
 	go hub.Run()
 
 	server := newTestServer(hub)
@@ -402,8 +842,8 @@ func TestConcurrentServerOperations(t *testing.T) {
 			}
 			defer conn.Close(websocket.StatusNormalClosure, "")
 
-			// Read welcome
-			conn.Read(ctx)
+			// Wait until the client is registered
+			roundTrip(conn)
 
 			// Perform room operations
 			operations := []string{
@@ -428,3 +868,184 @@ func TestConcurrentServerOperations(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestAdminStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+	_, err := hub.CreateRoom("stats-room", false, "", 10)
+	require.NoError(t, err)
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	getStats := func() *http.Response {
+		req, _ := http.NewRequest("GET", testServer.URL+"/api/admin/stats", nil)
+		req.Header.Set("Authorization", "Bearer "+generateTestJWT(t))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Non-admin users are rejected
+	resp := getStats()
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// Admin users get the combined stats document
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	resp = getStats()
+	var first AdminStatsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&first))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, first.Hub.Rooms)
+	assert.Contains(t, first.Hub.RoomOccupancy, "stats-room")
+	assert.Nil(t, first.DBPool)
+	assert.Nil(t, first.NATS)
+	assert.Nil(t, first.Cluster)
+
+	// Responses within the cache window are served from the cached snapshot
+	resp = getStats()
+	var second AdminStatsResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&second))
+	resp.Body.Close()
+	assert.True(t, first.GeneratedAt.Equal(second.GeneratedAt))
+}
+
+func TestWebSocketOriginAllowList(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetOriginPatterns([]string{"https://app.example.com"})
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	u, _ := url.Parse(testServer.URL)
+	u.Scheme = "ws"
+	u.Path = "/ws"
+
+	dial := func(header, origin string) (*http.Response, error) {
+		h := http.Header{}
+		h.Set("Authorization", "Bearer "+generateTestJWT(t))
+		h.Set(header, origin)
+		conn, resp, err := websocket.Dial(context.Background(), u.String(), &websocket.DialOptions{HTTPHeader: h})
+		if err == nil {
+			conn.Close(websocket.StatusNormalClosure, "")
+		}
+		return resp, err
+	}
+
+	t.Run("rejects origin outside the list", func(t *testing.T) {
+		resp, err := dial("Origin", "https://evil.example.com")
+		require.Error(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("rejects legacy origin outside the list", func(t *testing.T) {
+		resp, err := dial(legacyOriginHeader, "https://evil.example.com")
+		require.Error(t, err)
+		require.NotNil(t, resp)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("accepts listed origin", func(t *testing.T) {
+		_, err := dial("Origin", "https://app.example.com")
+		assert.NoError(t, err)
+	})
+
+	t.Run("accepts same host origin", func(t *testing.T) {
+		_, err := dial("Origin", testServer.URL)
+		assert.NoError(t, err)
+	})
+}
+
+func TestUpdateHubConfig(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := hub.NewHub(ctx, nil, nil)
+	go hub.Run()
+
+	server := newTestServer(hub)
+	server.SetupRoutes()
+
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	postConfig := func(body string) *http.Response {
+		req, _ := http.NewRequest("POST", testServer.URL+"/api/admin/config", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+generateTestJWT(t))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	// Non-admin users are rejected
+	resp := postConfig(`{"max_clients_per_room": 5}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	server.adminIDs = map[string]bool{"test-user-id": true}
+	resp = postConfig(`{"max_clients_per_room": 5, "room_op_timeout": "1s"}`)
+	var updated ConfigUpdateResponse
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&updated))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 5, updated.Config.MaxClientsPerRoom)
+	assert.Equal(t, time.Second, updated.Config.RoomOpTimeout)
+	assert.Len(t, updated.Changes, 2)
+	assert.Equal(t, 5, hub.Config().MaxClientsPerRoom)
+
+	// Invalid values leave the config alone
+	resp = postConfig(`{"max_clients_per_room": -1}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = postConfig(`{"room_op_timeout": 5}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 5, hub.Config().MaxClientsPerRoom)
+}
+
+func TestRegisterUsernameIgnoresCase(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := hub.NewHub(ctx, nil, nil)
+	store := repositorytest.NewFake()
+	server := newTestServer(h)
+	server.repo = store
+	server.SetupRoutes()
+
+	register := func(username, email string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"username": %q, "email": %q, "password": "correct horse battery"}`, username, email)
+		req := httptest.NewRequest(http.MethodPost, "/api/register", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		return rec
+	}
+
+	require.Equal(t, http.StatusCreated, register("Alice", "alice@example.com").Code)
+	rec := register("alice", "other@example.com")
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Username is already taken")
+
+	// Lookups ignore case but keep the registered casing
+	user, err := store.GetUserByUsername(ctx, "ALICE")
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", user.Username)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/room"
+	"websocket-demo/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// spanWSMessage names the span an inbound WebSocket message is handled in
+const spanWSMessage = "ws.message"
+
+// Attributes of the WebSocket message span
+const (
+	attrMessageType = attribute.Key("chat.message.type")
+	attrRoom        = attribute.Key("chat.room")
+)
+
+// startMessageSpan starts the root span of an inbound WebSocket message. The
+// room is the one named by the message, or else the client's current room.
+func startMessageSpan(c *client.Client, msgType, roomName string) (context.Context, trace.Span) {
+	if roomName == "" {
+		if currentRoom, ok := c.GetCurrentRoom().(*room.Room); ok && currentRoom != nil {
+			roomName = currentRoom.Name
+		}
+	}
+	return tracing.Tracer().Start(context.Background(), spanWSMessage,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attrMessageType.String(msgType),
+			attrRoom.String(roomName),
+			semconv.UserID(c.UserID),
+		),
+	)
+}
+
+// endMessageSpan records err, if any, on span and ends it
+func endMessageSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
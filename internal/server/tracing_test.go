@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/tracing"
+	"websocket-demo/internal/tracing/tracingtest"
+	"websocket-demo/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWebSocketMessageSpan(t *testing.T) {
+	recorder := tracingtest.Record(t)
+	h := hub.NewHub(context.Background(), nil, nil)
+	server := newTestServer(h)
+
+	traced, err := h.CreateRoom("traced", false, "", 10)
+	require.NoError(t, err)
+	alice := client.NewClient(nil, "alice")
+	alice.UserID = "user-alice"
+	require.NoError(t, h.JoinRoom(alice, traced, ""))
+
+	server.handleFrame(alice, []byte(`{"type":"room_message","data":{"content":"hello"}}`))
+
+	spans := tracingtest.Find(recorder, spanWSMessage)
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	assert.False(t, span.Parent().IsValid(), "each inbound message starts a trace")
+	assert.Subset(t, span.Attributes(), []attribute.KeyValue{
+		attrMessageType.String(types.MsgTypeRoomMessage),
+		attrRoom.String("traced"),
+		semconv.UserID("user-alice"),
+	})
+
+	// The broadcast carries the span on to NATS and the database
+	select {
+	case msg := <-h.Broadcast:
+		carried := trace.SpanContextFromContext(tracing.Extract(context.Background(), msg.TraceContext))
+		assert.Equal(t, span.SpanContext().TraceID(), carried.TraceID())
+		assert.Equal(t, span.SpanContext().SpanID(), carried.SpanID())
+	case <-time.After(time.Second):
+		t.Fatal("room message was not broadcast")
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/labstack/echo/v4"
+)
+
+// transcriptPageSize is how many messages are loaded at a time while a
+// transcript streams, bounding memory whatever the room's size
+const transcriptPageSize = 500
+
+// transcriptStore is the subset of the repository used to export transcripts
+type transcriptStore interface {
+	GetRoomByID(ctx context.Context, id pgtype.UUID) (db.Room, error)
+	GetRoomMemberRole(ctx context.Context, roomID, userID pgtype.UUID) (string, error)
+	ListRoomTranscript(ctx context.Context, roomID pgtype.UUID, afterCreatedAt time.Time, afterID pgtype.UUID, pageSize int32) ([]db.ListRoomTranscriptRow, error)
+}
+
+// TranscriptMessageDTO is one message of a JSON transcript
+type TranscriptMessageDTO struct {
+	ID        string    `json:"id"`
+	Sender    string    `json:"sender"`
+	Timestamp time.Time `json:"timestamp"`
+	Content   string    `json:"content"`
+}
+
+// transcriptEncoder writes the messages of a transcript in one format
+type transcriptEncoder interface {
+	Encode(msg TranscriptMessageDTO) error
+	// Flush writes out what the encoder buffers
+	Flush() error
+	// Close writes whatever ends the transcript and flushes
+	Close() error
+}
+
+// transcriptFormats maps the format parameter to a content type
+var transcriptFormats = map[string]string{
+	"txt":  "text/plain; charset=utf-8",
+	"json": echo.MIMEApplicationJSON,
+	"csv":  "text/csv; charset=utf-8",
+}
+
+func newTranscriptEncoder(format string, w io.Writer) transcriptEncoder {
+	switch format {
+	case "json":
+		return &jsonTranscript{w: w}
+	case "csv":
+		return newCSVTranscript(w)
+	}
+	return &textTranscript{w: w}
+}
+
+// ExportTranscript handles GET /api/rooms/:id/export?format=txt|json|csv,
+// streaming every message of the room oldest first as a download; txt is
+// the default. Only the room's creator, its moderators and admins may export
+// it. Messages are read a page at a time, so the transcript is never held in
+// memory, and a failure part way through leaves it cut short.
+func (s *Server) ExportTranscript(c echo.Context) error {
+	if s.transcripts == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Transcripts are not available"})
+	}
+
+	var roomID pgtype.UUID
+	if err := roomID.Scan(c.Param("id")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid room ID"})
+	}
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "txt"
+	}
+	contentType, ok := transcriptFormats[format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be txt, json or csv"})
+	}
+
+	ctx := c.Request().Context()
+	dbRoom, err := s.transcripts.GetRoomByID(ctx, roomID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Room not found"})
+	}
+	if err != nil {
+		log.Printf("Failed to load room %s for export: %v", c.Param("id"), err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to export transcript"})
+	}
+	allowed, err := s.canExportTranscript(ctx, GetUserID(c), dbRoom)
+	if err != nil {
+		log.Printf("Failed to check the role of user %s in room %s: %v", GetUserID(c), dbRoom.Name, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to export transcript"})
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the room creator or a moderator can export the transcript"})
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-transcript.%s"`, transcriptFileName(dbRoom.Name), format))
+	res.WriteHeader(http.StatusOK)
+
+	exported, err := s.streamTranscript(ctx, res, dbRoom.ID, newTranscriptEncoder(format, res))
+	if err != nil {
+		log.Printf("Transcript of room %s cut short after %d messages: %v", dbRoom.Name, exported, err)
+		return nil
+	}
+	log.Printf("User %s exported %d messages of room %s as %s", GetUserID(c), exported, dbRoom.Name, format)
+	return nil
+}
+
+// canExportTranscript reports whether userID is an admin, the room's creator
+// or one of its moderators
+func (s *Server) canExportTranscript(ctx context.Context, userID string, dbRoom db.Room) (bool, error) {
+	if s.canModerateRoom(userID, dbRoom) {
+		return true, nil
+	}
+	var id pgtype.UUID
+	if err := id.Scan(userID); err != nil {
+		return false, nil
+	}
+	role, err := s.transcripts.GetRoomMemberRole(ctx, dbRoom.ID, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	return role == repository.RoomRoleModerator, err
+}
+
+// streamTranscript pages through a room's messages, encoding and flushing
+// each page, and returns how many messages it wrote
+func (s *Server) streamTranscript(ctx context.Context, res *echo.Response, roomID pgtype.UUID, enc transcriptEncoder) (int, error) {
+	exported := 0
+	var afterCreatedAt time.Time
+	var afterID pgtype.UUID
+	for {
+		rows, err := s.transcripts.ListRoomTranscript(ctx, roomID, afterCreatedAt, afterID, transcriptPageSize)
+		if err != nil {
+			return exported, fmt.Errorf("failed to load messages: %w", err)
+		}
+		for _, row := range rows {
+			err := enc.Encode(TranscriptMessageDTO{
+				ID:        uuid.UUID(row.ID.Bytes).String(),
+				Sender:    row.Username,
+				Timestamp: row.CreatedAt.Time.UTC(),
+				Content:   row.Content,
+			})
+			if err != nil {
+				return exported, err
+			}
+			exported++
+		}
+		if len(rows) < transcriptPageSize {
+			return exported, enc.Close()
+		}
+		if err := enc.Flush(); err != nil {
+			return exported, err
+		}
+		res.Flush()
+		last := rows[len(rows)-1]
+		afterCreatedAt, afterID = last.CreatedAt.Time, last.ID
+	}
+}
+
+// unsafeFileNameChars are replaced in the room name of a download's file name
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+func transcriptFileName(roomName string) string {
+	if name := unsafeFileNameChars.ReplaceAllString(roomName, "_"); strings.Trim(name, "_.") != "" {
+		return name
+	}
+	return "room"
+}
+
+// textTranscript writes one "[timestamp] sender: content" line per message,
+// indenting the continuation lines of multi-line messages
+type textTranscript struct {
+	w io.Writer
+}
+
+func (t *textTranscript) Encode(msg TranscriptMessageDTO) error {
+	content := strings.ReplaceAll(msg.Content, "\n", "\n    ")
+	_, err := fmt.Fprintf(t.w, "[%s] %s: %s\n", msg.Timestamp.Format(time.RFC3339), msg.Sender, content)
+	return err
+}
+
+func (t *textTranscript) Flush() error {
+	return nil
+}
+
+func (t *textTranscript) Close() error {
+	return nil
+}
+
+// jsonTranscript writes a JSON array of TranscriptMessageDTO
+type jsonTranscript struct {
+	w       io.Writer
+	started bool
+}
+
+func (t *jsonTranscript) Encode(msg TranscriptMessageDTO) error {
+	separator := ",\n"
+	if !t.started {
+		separator = "[\n"
+		t.started = true
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(t.w, "%s%s", separator, data)
+	return err
+}
+
+func (t *jsonTranscript) Flush() error {
+	return nil
+}
+
+func (t *jsonTranscript) Close() error {
+	end := "\n]\n"
+	if !t.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(t.w, end)
+	return err
+}
+
+// csvTranscript writes an id,timestamp,sender,content header and one row per message
+type csvTranscript struct {
+	w   *csv.Writer
+	err error
+}
+
+func newCSVTranscript(w io.Writer) *csvTranscript {
+	t := &csvTranscript{w: csv.NewWriter(w)}
+	t.err = t.w.Write([]string{"id", "timestamp", "sender", "content"})
+	return t
+}
+
+func (t *csvTranscript) Encode(msg TranscriptMessageDTO) error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Write([]string{msg.ID, msg.Timestamp.Format(time.RFC3339), msg.Sender, msg.Content})
+}
+
+func (t *csvTranscript) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	t.w.Flush()
+	return t.w.Error()
+}
+
+func (t *csvTranscript) Close() error {
+	return t.Flush()
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/db"
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository"
+	"websocket-demo/internal/repository/repositorytest"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportTranscript(t *testing.T) {
+	ctx := context.Background()
+	store := repositorytest.NewFake()
+
+	users := make(map[string]db.User)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		user, err := store.CreateUser(ctx, name, name+"@example.com", "hash")
+		require.NoError(t, err)
+		users[name] = user
+	}
+	room, err := store.CreateRoomWithCreator(ctx, "support desk", pgtype.Bool{}, pgtype.Text{}, users["alice"].ID, false, pgtype.Int4{})
+	require.NoError(t, err)
+	require.NoError(t, store.AddRoomMember(ctx, room.ID, users["bob"].ID, repository.RoomRoleModerator))
+	require.NoError(t, store.AddRoomMember(ctx, room.ID, users["carol"].ID, repository.RoomRoleMember))
+
+	// More than two pages, a hundred per second so paging also relies on the ID order
+	const total = 2*transcriptPageSize + 7
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	params := make([]db.BulkCreateMessagesParams, total)
+	for i := range params {
+		params[i] = db.BulkCreateMessagesParams{
+			ID:        newUUID(),
+			RoomID:    room.ID,
+			UserID:    users["carol"].ID,
+			Content:   fmt.Sprintf("message %d", i),
+			CreatedAt: pgtype.Timestamptz{Time: start.Add(time.Duration(i/100) * time.Second), Valid: true},
+		}
+	}
+	params[0].Content = "first line\nsecond line, with a comma"
+	params[0].UserID = users["alice"].ID
+	params[0].CreatedAt.Time = start.Add(-time.Minute)
+	params[total-1].CreatedAt.Time = start.Add(time.Minute)
+	_, err = store.BulkCreateMessages(ctx, params)
+	require.NoError(t, err)
+
+	server := newTestServer(hub.NewHub(ctx, nil, nil))
+	server.transcripts = store
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	export := func(user, query string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/rooms/"+uuid.UUID(room.ID.Bytes).String()+"/export"+query, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+generateTestJWTFor(t, uuid.UUID(users[user].ID.Bytes).String(), user))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("txt", func(t *testing.T) {
+		resp := export("alice", "")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="support_desk-transcript.txt"`, resp.Header.Get("Content-Disposition"))
+
+		scanner := bufio.NewScanner(resp.Body)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		require.Len(t, lines, total+1, "the first message continues on an indented line")
+		assert.Equal(t, "[2026-03-01T08:59:00Z] alice: first line", lines[0])
+		assert.Equal(t, "    second line, with a comma", lines[1])
+		assert.Equal(t, "[2026-03-01T09:01:00Z] carol: message 1006", lines[total])
+	})
+
+	t.Run("json", func(t *testing.T) {
+		resp := export("bob", "?format=json")
+		require.Equal(t, http.StatusOK, resp.StatusCode, "moderators may export")
+		var messages []TranscriptMessageDTO
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&messages))
+		require.Len(t, messages, total)
+		seen := make(map[string]bool)
+		for i, msg := range messages {
+			assert.False(t, seen[msg.ID], "message %d exported twice", i)
+			seen[msg.ID] = true
+			if i > 0 {
+				assert.False(t, msg.Timestamp.Before(messages[i-1].Timestamp), "oldest first")
+			}
+		}
+		assert.Equal(t, "alice", messages[0].Sender)
+		assert.Equal(t, start.Add(-time.Minute), messages[0].Timestamp)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		resp := export("alice", "?format=csv")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		records, err := csv.NewReader(resp.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, total+1)
+		assert.Equal(t, []string{"id", "timestamp", "sender", "content"}, records[0])
+		assert.Equal(t, []string{"2026-03-01T08:59:00Z", "alice", "first line\nsecond line, with a comma"}, records[1][1:])
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		resp := export("carol", "")
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, "members who don't moderate can't export")
+		resp = export("alice", "?format=pdf")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/rooms/"+uuid.NewString()+"/export", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+generateTestJWTFor(t, uuid.UUID(users["alice"].ID.Bytes).String(), "alice"))
+		missing, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		io.Copy(io.Discard, missing.Body)
+		missing.Body.Close()
+		assert.Equal(t, http.StatusNotFound, missing.StatusCode)
+	})
+}
+
+func TestTranscriptFileName(t *testing.T) {
+	assert.Equal(t, "general", transcriptFileName("general"))
+	assert.Equal(t, "a_b_c", transcriptFileName(`a"b/c`))
+	assert.Equal(t, "room", transcriptFileName(".."))
+	assert.True(t, strings.HasPrefix(transcriptFileName("日本"), "room"))
+}
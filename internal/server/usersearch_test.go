@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"websocket-demo/internal/hub"
+	"websocket-demo/internal/repository/repositorytest"
+	"websocket-demo/internal/types"
+
+	"github.com/coder/websocket"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchUsersOverWebSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store := repositorytest.NewFake()
+	h := hub.NewHub(ctx, store, nil)
+	go h.Run()
+
+	for i := 1; i <= 5; i++ {
+		_, err := store.CreateUser(ctx, fmt.Sprintf("alice%d", i), fmt.Sprintf("alice%d@example.com", i), "hash")
+		require.NoError(t, err)
+	}
+	bob, err := store.CreateUser(ctx, "bob", "bob@example.com", "hash")
+	require.NoError(t, err)
+
+	server := newTestServer(h)
+	server.SetupRoutes()
+	testServer := httptest.NewServer(server.echo)
+	defer testServer.Close()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+generateTestJWTFor(t, uuid.UUID(bob.ID.Bytes).String(), "bob"))
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(testServer.URL, "http")+"/ws", &websocket.DialOptions{HTTPHeader: header})
+	require.NoError(t, err)
+	defer conn.CloseNow()
+	_, _, err = conn.Read(ctx) // The connected frame follows registration
+	require.NoError(t, err)
+
+	search := []byte(`{"type":"search_users","data":{"query":"alic"}}`)
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, search))
+	_, data, err := conn.Read(ctx)
+	require.NoError(t, err)
+	var results types.UserSearchResultsDTO
+	require.NoError(t, json.Unmarshal(data, &results))
+	require.Len(t, results.Users, 5)
+	for i, u := range results.Users {
+		assert.Equal(t, fmt.Sprintf("alice%d", i+1), u.Username)
+	}
+
+	// Searches beyond MaxSearchesPerSecond within a second are refused
+	for i := 1; i < MaxSearchesPerSecond; i++ {
+		require.NoError(t, conn.Write(ctx, websocket.MessageText, search))
+		_, data, err = conn.Read(ctx)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"type":"user_search_results"`)
+	}
+	require.NoError(t, conn.Write(ctx, websocket.MessageText, search))
+	_, data, err = conn.Read(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "too many searches")
+}
@@ -11,6 +11,7 @@ import (
 type WebSocketRateLimiter struct {
 	clients map[string]*clientRateLimit
 	mu      sync.RWMutex
+	limit   int // Messages allowed per RateLimitWindow
 }
 
 // clientRateLimit tracks message rate for a specific client
@@ -25,12 +26,24 @@ const (
 	MaxMessagesPerSecond = 10
 	// RateLimitWindow is the time window for rate limiting (1 second)
 	RateLimitWindow = time.Second
+	// MaxSearchesPerSecond is the maximum number of search_users requests allowed per second
+	MaxSearchesPerSecond = 5
+	// MaxRoomCreationsPerSecond is the maximum number of rooms a user may create per second
+	MaxRoomCreationsPerSecond = 2
 )
 
-// NewWebSocketRateLimiter creates a new WebSocket rate limiter
+// NewWebSocketRateLimiter creates a new WebSocket rate limiter allowing
+// MaxMessagesPerSecond messages per client
 func NewWebSocketRateLimiter() *WebSocketRateLimiter {
+	return NewWebSocketRateLimiterWithLimit(MaxMessagesPerSecond)
+}
+
+// NewWebSocketRateLimiterWithLimit creates a WebSocket rate limiter allowing
+// limit messages per client each RateLimitWindow
+func NewWebSocketRateLimiterWithLimit(limit int) *WebSocketRateLimiter {
 	return &WebSocketRateLimiter{
 		clients: make(map[string]*clientRateLimit),
+		limit:   limit,
 	}
 }
 
@@ -45,11 +58,14 @@ func (w *WebSocketRateLimiter) CheckRateLimit(client *client.Client) bool {
 
 	if !exists {
 		w.mu.Lock()
-		limiter = &clientRateLimit{
-			messages:    make([]time.Time, 0),
-			windowStart: time.Now(),
+		// Another goroutine may have created the entry since the read lock was released
+		if limiter, exists = w.clients[clientID]; !exists {
+			limiter = &clientRateLimit{
+				messages:    make([]time.Time, 0),
+				windowStart: time.Now(),
+			}
+			w.clients[clientID] = limiter
 		}
-		w.clients[clientID] = limiter
 		w.mu.Unlock()
 	}
 
@@ -65,7 +81,7 @@ func (w *WebSocketRateLimiter) CheckRateLimit(client *client.Client) bool {
 	}
 
 	// Check if rate limit exceeded
-	if len(limiter.messages) >= MaxMessagesPerSecond {
+	if len(limiter.messages) >= w.limit {
 		return true
 	}
 
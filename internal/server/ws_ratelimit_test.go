@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"websocket-demo/internal/client"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rateLimitedClient returns a client identified by userID for rate limiting
+func rateLimitedClient(userID string) *client.Client {
+	c := client.NewClient(nil, userID)
+	c.UserID = userID
+	return c
+}
+
+func TestWebSocketRateLimiterAllowsUpToLimit(t *testing.T) {
+	t.Parallel()
+	limiter := NewWebSocketRateLimiter()
+	c := rateLimitedClient("user-1")
+
+	for i := 0; i < MaxMessagesPerSecond; i++ {
+		assert.False(t, limiter.CheckRateLimit(c), "message %d should be allowed", i+1)
+	}
+	assert.True(t, limiter.CheckRateLimit(c), "message %d should be blocked", MaxMessagesPerSecond+1)
+
+	// Other clients have their own budget
+	assert.False(t, limiter.CheckRateLimit(rateLimitedClient("user-2")))
+}
+
+func TestWebSocketRateLimiterWindowResets(t *testing.T) {
+	t.Parallel()
+	limiter := NewWebSocketRateLimiter()
+	c := rateLimitedClient("user-1")
+
+	for i := 0; i <= MaxMessagesPerSecond; i++ {
+		limiter.CheckRateLimit(c)
+	}
+	assert.True(t, limiter.CheckRateLimit(c))
+
+	time.Sleep(RateLimitWindow + 100*time.Millisecond)
+	assert.False(t, limiter.CheckRateLimit(c), "a new window should allow messages again")
+}
+
+func TestWebSocketRateLimiterConcurrentAccess(t *testing.T) {
+	t.Parallel()
+	limiter := NewWebSocketRateLimiter()
+	c := rateLimitedClient("user-1")
+
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if !limiter.CheckRateLimit(c) {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(MaxMessagesPerSecond), allowed.Load())
+	assert.Equal(t, MaxMessagesPerSecond, limiter.GetMessageCount("user-1"))
+}
+
+func TestWebSocketRateLimiterRemoveClient(t *testing.T) {
+	t.Parallel()
+	limiter := NewWebSocketRateLimiter()
+	limiter.CheckRateLimit(rateLimitedClient("user-1"))
+
+	limiter.RemoveClient("user-1")
+
+	limiter.mu.RLock()
+	_, exists := limiter.clients["user-1"]
+	limiter.mu.RUnlock()
+	assert.False(t, exists)
+	assert.Equal(t, 0, limiter.GetMessageCount("user-1"))
+}
+
+func TestWebSocketRateLimiterGetMessageCount(t *testing.T) {
+	t.Parallel()
+	limiter := NewWebSocketRateLimiter()
+	c := rateLimitedClient("user-1")
+
+	assert.Equal(t, 0, limiter.GetMessageCount("user-1"))
+	for i := 1; i <= 3; i++ {
+		limiter.CheckRateLimit(c)
+		assert.Equal(t, i, limiter.GetMessageCount("user-1"))
+	}
+}
+
+func TestWebSocketRateLimiterCleanupExpiredClients(t *testing.T) {
+	t.Parallel()
+	limiter := NewWebSocketRateLimiter()
+	for i := 0; i < 3; i++ {
+		limiter.CheckRateLimit(rateLimitedClient(fmt.Sprintf("user-%d", i)))
+	}
+
+	// Age one client past the cleanup threshold
+	limiter.mu.RLock()
+	stale := limiter.clients["user-0"]
+	limiter.mu.RUnlock()
+	stale.mu.Lock()
+	stale.windowStart = time.Now().Add(-6 * time.Minute)
+	stale.mu.Unlock()
+
+	limiter.CleanupExpiredClients()
+
+	limiter.mu.RLock()
+	defer limiter.mu.RUnlock()
+	assert.NotContains(t, limiter.clients, "user-0")
+	assert.Contains(t, limiter.clients, "user-1")
+	assert.Contains(t, limiter.clients, "user-2")
+}
+
+func TestWebSocketRateLimiterWithLimit(t *testing.T) {
+	limiter := NewWebSocketRateLimiterWithLimit(MaxSearchesPerSecond)
+	c := rateLimitedClient("user-1")
+
+	for i := 0; i < MaxSearchesPerSecond; i++ {
+		assert.False(t, limiter.CheckRateLimit(c), "search %d should be allowed", i+1)
+	}
+	assert.True(t, limiter.CheckRateLimit(c))
+}
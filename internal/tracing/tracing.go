@@ -0,0 +1,100 @@
+// Package tracing sets up optional OpenTelemetry tracing and carries trace
+// context across the hub and NATS. Until Setup enables it every span is a
+// no-op, so instrumented code needs no checks of its own.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer spans are created with
+const instrumentationName = "websocket-demo"
+
+// defaultServiceName is the service name reported when OTEL_SERVICE_NAME is unset
+const defaultServiceName = "chatx"
+
+// Config holds the tracing settings; see config.Config for the environment
+// variables they come from. The exporter itself is configured through the
+// standard OTEL_EXPORTER_OTLP_* variables, sampling through OTEL_TRACES_SAMPLER
+// and the service name through OTEL_SERVICE_NAME.
+type Config struct {
+	Enabled bool
+}
+
+// Setup installs a tracer provider exporting spans over OTLP/HTTP and the
+// W3C trace context propagator. The returned function flushes and stops the
+// exporter; with tracing disabled nothing is installed and it does nothing.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES, detected last, override the name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(defaultServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	Install(provider)
+	log.Println("Tracing enabled, exporting spans over OTLP")
+	return provider.Shutdown, nil
+}
+
+// Install makes provider the source of every span and turns on W3C trace
+// context propagation; tests use it with an in-memory span recorder
+func Install(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+}
+
+// Tracer returns the tracer of the installed provider
+func Tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(instrumentationName)
+}
+
+// Inject returns the trace context of ctx's span as a carrier to send along
+// with a message, or nil when there's no span or tracing is off
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the remote span context from a carrier made by
+// Inject, so spans started from it continue the sender's trace
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// Traced reports whether ctx carries a span to parent new spans on
+func Traced(ctx context.Context) bool {
+	return trace.SpanContextFromContext(ctx).IsValid()
+}
@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetupDisabledIsNoop(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	ctx, span := Tracer().Start(context.Background(), "untraced")
+	defer span.End()
+	assert.False(t, span.IsRecording())
+	assert.Nil(t, Inject(ctx))
+}
+
+func TestInjectExtractContinuesTrace(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	Install(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer Install(previous)
+
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	carrier := Inject(ctx)
+	require.Contains(t, carrier, "traceparent")
+	parent.End()
+
+	remote := Extract(context.Background(), carrier)
+	require.True(t, Traced(remote))
+	_, child := Tracer().Start(remote, "child")
+	child.End()
+
+	ended := recorder.Ended()
+	require.Len(t, ended, 2)
+	recorded := ended[1]
+	assert.Equal(t, "child", recorded.Name())
+	assert.Equal(t, parent.SpanContext().TraceID(), recorded.SpanContext().TraceID())
+	assert.Equal(t, parent.SpanContext().SpanID(), recorded.Parent().SpanID())
+	assert.True(t, recorded.Parent().IsRemote())
+
+	assert.False(t, Traced(Extract(context.Background(), nil)))
+	assert.Equal(t, trace.SpanContext{}, trace.SpanContextFromContext(Extract(context.Background(), map[string]string{})))
+}
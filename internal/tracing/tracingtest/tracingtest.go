@@ -0,0 +1,39 @@
+// Package tracingtest records spans in memory for tests.
+package tracingtest
+
+import (
+	"testing"
+
+	"websocket-demo/internal/tracing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// Record installs a tracer provider recording every span until the test
+// ends, when the previous provider is put back
+func Record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	previous := otel.GetTracerProvider()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracing.Install(provider)
+	t.Cleanup(func() {
+		tracing.Install(previous)
+		provider.Shutdown(t.Context())
+	})
+	return recorder
+}
+
+// Find returns the ended spans named name, oldest first
+func Find(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
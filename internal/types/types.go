@@ -10,9 +10,27 @@ type Message struct {
 	Content   []byte
 	Sender    interface{} // Can be *client.Client
 	Type      string      // "chat", "join", "leave"
-	Room      interface{} // Can be *room.Room
+	Room      interface{} // Can be *room.Room, or any Roomer
 	ServerID  string      // ID of the server that sent the message (for NATS)
 	Timestamp time.Time
+
+	// Plain sender and room identity; set on messages relayed over NATS where
+	// the Sender and Room pointers are not available
+	SenderID     string
+	SenderConnID string // Connection the message was sent from, so only that connection is skipped
+	SenderName   string
+	RoomName     string
+
+	// W3C trace context of the span the message was sent under, carried
+	// through the hub and over NATS; nil when tracing is off
+	TraceContext map[string]string
+}
+
+// Roomer is the room identity other packages read from Message.Room without
+// importing the room package; *room.Room implements it
+type Roomer interface {
+	GetName() string
+	GetID() string
 }
 
 // WebSocketMessage represents a WebSocket message structure
@@ -25,24 +43,300 @@ type WebSocketMessage struct {
 		Private  bool   `json:"private,omitempty"`
 		Limit    int    `json:"limit,omitempty"`
 		Offset   int    `json:"offset,omitempty"`
+		ReplyTo  string `json:"reply_to,omitempty"`
+
+		MaxClients *int `json:"max_clients,omitempty"` // Capacity for create_room; omitted means the server default
+
+		SuppressJoinLeave *bool  `json:"suppress_join_leave,omitempty"`
+		SessionID         string `json:"session_id,omitempty"`
+		To                string `json:"to,omitempty"`          // Recipient user ID for direct messages and invites
+		ToUsername        string `json:"to_username,omitempty"` // Recipient username for direct messages, when To is empty
+
+		Question    string   `json:"question,omitempty"`
+		Options     []string `json:"options,omitempty"`
+		Duration    int      `json:"duration,omitempty"` // Poll duration in seconds
+		PollID      string   `json:"poll_id,omitempty"`
+		OptionIndex *int     `json:"option_index,omitempty"`
+
+		MessageID string `json:"message_id,omitempty"` // Stored message to edit or delete
+
+		OldPassword string `json:"old_password,omitempty"` // Current room password, confirming a change_room_password
+
+		Query string `json:"query,omitempty"` // Part of a username to search_users for
+
+		LinkPolicy *LinkPolicyDTO `json:"link_policy,omitempty"` // A room's own link policy for set_room_settings; {} removes it
 	} `json:"data,omitempty"`
 }
 
 // ChatMessage represents a chat message in JSON format
 type ChatMessage struct {
+	Type      string        `json:"type"`
+	Timestamp string        `json:"timestamp"`
+	Sender    string        `json:"sender"`
+	Content   string        `json:"content"`
+	Room      string        `json:"room,omitempty"`
+	ReplyTo   *ReplyPreview `json:"reply_to,omitempty"`
+	SenderID  string        `json:"sender_id,omitempty"` // Set on direct messages so recipients can answer
+}
+
+// DirectMessageStatus tells the sender whether a direct message reached the recipient
+type DirectMessageStatus struct {
 	Type      string `json:"type"`
-	Timestamp string `json:"timestamp"`
-	Sender    string `json:"sender"`
-	Content   string `json:"content"`
-	Room      string `json:"room,omitempty"`
+	To        string `json:"to"`
+	Delivered bool   `json:"delivered"`
+}
+
+// ReplyPreview is a quoted summary of the message being replied to
+type ReplyPreview struct {
+	ID             string `json:"id"`
+	Sender         string `json:"sender"`
+	ContentPreview string `json:"content_preview"`
+	Timestamp      string `json:"timestamp"`
+}
+
+// MessagePreviewDTO summarizes a room's latest message in room lists
+type MessagePreviewDTO struct {
+	ID             string `json:"id"`
+	Sender         string `json:"sender"`
+	ContentPreview string `json:"content_preview"`
+	Timestamp      string `json:"timestamp"`
 }
 
 // RoomDTO represents a room information sent to clients
 type RoomDTO struct {
 	Name        string `json:"name"`
 	Private     bool   `json:"private"`
-	ClientCount int    `json:"clientCount"`
+	ClientCount int    `json:"clientCount"` // Members connected to this server
+	MemberCount int    `json:"memberCount"` // Members recorded in the database
+	OnlineCount int    `json:"onlineCount"` // Members connected across all servers
+	MaxClients  int    `json:"max_clients"` // Room capacity
 	IsCreator   bool   `json:"isCreator"`
+
+	SuppressJoinLeave bool `json:"suppressJoinLeave"` // Join/leave notifications are off
+
+	LastMessage *MessagePreviewDTO `json:"lastMessage,omitempty"` // Omitted for rooms without stored messages
+}
+
+// SessionDTO describes one of a user's active connections
+type SessionDTO struct {
+	ID          string `json:"id"`
+	ConnID      string `json:"connId"` // The connection's ID in server logs
+	IP          string `json:"ip"`
+	UserAgent   string `json:"userAgent"`
+	Room        string `json:"room,omitempty"`
+	ConnectedAt string `json:"connectedAt"`
+	Current     bool   `json:"current"` // The connection the request came from
+}
+
+// PollDTO is a poll with its current vote tallies
+type PollDTO struct {
+	Type       string    `json:"type,omitempty"`
+	ID         string    `json:"id"`
+	Room       string    `json:"room"`
+	CreatorID  string    `json:"creator_id,omitempty"`
+	Question   string    `json:"question"`
+	Options    []string  `json:"options"`
+	Votes      []int64   `json:"votes"` // Votes per option, in option order
+	TotalVotes int64     `json:"total_votes"`
+	EndsAt     time.Time `json:"ends_at"`
+	Closed     bool      `json:"closed"`
+}
+
+// MessageUpdateDTO tells a room that one of its messages was edited or deleted
+type MessageUpdateDTO struct {
+	Type      string `json:"type"`
+	MessageID string `json:"message_id"`
+	Room      string `json:"room"`
+	Content   string `json:"content,omitempty"` // The new text of an edited message
+}
+
+// RoomPasswordChangedDTO tells a room its password was changed; the password
+// itself is never sent
+type RoomPasswordChangedDTO struct {
+	Type      string `json:"type"`
+	Room      string `json:"room"`
+	ChangedBy string `json:"changed_by"`
+}
+
+// ConnectedDTO is the first frame sent on a new connection
+type ConnectedDTO struct {
+	Type     string `json:"type"`
+	MyConnID string `json:"my_conn_id"` // Tagged conn_id in server logs, for correlating client logs
+}
+
+// ExportMessageDTO is a stored room message in an export
+type ExportMessageDTO struct {
+	MessageID string `json:"message_id"`
+	Username  string `json:"username"`
+	Content   string `json:"content"`
+	Timestamp string `json:"timestamp"`
+}
+
+// ExportChunkDTO is one page of a room export, sent gzipped in a binary frame
+type ExportChunkDTO struct {
+	Type        string             `json:"type"`
+	Room        string             `json:"room"`
+	ChunkIndex  int                `json:"chunk_index"`
+	TotalChunks int                `json:"total_chunks"`
+	Messages    []ExportMessageDTO `json:"messages"` // Newest first
+}
+
+// ExportCompleteDTO follows the last chunk of a room export
+type ExportCompleteDTO struct {
+	Type          string `json:"type"`
+	Room          string `json:"room"`
+	TotalChunks   int    `json:"total_chunks"`   // Chunks actually sent
+	TotalMessages int    `json:"total_messages"` // Messages across all chunks
+}
+
+// UserPresenceDTO describes a user connected somewhere in the cluster
+type UserPresenceDTO struct {
+	UserID      string `json:"userId"`
+	Name        string `json:"name"`
+	Connections int    `json:"connections"` // Open connections across all servers
+	Servers     int    `json:"servers"`     // Servers holding at least one connection
+}
+
+// UserLookupRequest asks the servers which of them a user is connected to
+type UserLookupRequest struct {
+	Username string `json:"username"`
+}
+
+// UserLookupReply answers a UserLookupRequest
+type UserLookupReply struct {
+	ServerID string `json:"serverId"`
+	UserID   string `json:"userId"`
+	Present  bool   `json:"present"`
+}
+
+// MemberDTO describes a room member
+type MemberDTO struct {
+	UserID   string `json:"userId"`
+	Name     string `json:"name"`
+	Online   bool   `json:"online"`             // Connected to any server
+	JoinedAt string `json:"joinedAt,omitempty"` // RFC 3339; stored membership time, or when a connected client joined
+}
+
+// RoomMemberDTO describes a user connected to a room, returned by
+// GET /api/rooms/:name/online
+type RoomMemberDTO struct {
+	UserID      string    `json:"userId"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`   // UserStatusOnline; only connected users are listed
+	JoinedAt    time.Time `json:"joinedAt"` // When the user's earliest session in the room joined it
+	IsModerator bool      `json:"isModerator"`
+	IsCreator   bool      `json:"isCreator"`
+}
+
+// HistoryMessageDTO is a stored room message sent as history
+type HistoryMessageDTO struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Content   string `json:"content"`
+	Timestamp string `json:"timestamp"`
+	ReplyTo   string `json:"reply_to,omitempty"` // ID of the message this one replies to
+}
+
+// RoomHistoryDTO is one frame of the history sent after joining a room
+type RoomHistoryDTO struct {
+	Type     string              `json:"type"`
+	Room     string              `json:"room"`
+	Messages []HistoryMessageDTO `json:"messages"` // Oldest first
+	Done     bool                `json:"done"`     // Last frame of this join's history
+}
+
+// RoomAlertDTO carries a room's moderation banner, sent when it changes and
+// after joining a room that has one
+type RoomAlertDTO struct {
+	Type  string    `json:"type"`
+	Room  string    `json:"room"`
+	Alert *AlertDTO `json:"alert"` // Null once the banner is cleared
+}
+
+// AlertDTO is a room's moderation banner
+type AlertDTO struct {
+	Text  string `json:"text"`
+	SetBy string `json:"set_by"`
+	SetAt string `json:"set_at"`
+}
+
+// TypingDTO tells a room that a member started or stopped typing
+type TypingDTO struct {
+	Type   string `json:"type"`
+	Room   string `json:"room"`
+	Sender string `json:"sender"`
+}
+
+// ThreadMessagesDTO lists the replies to a message
+type ThreadMessagesDTO struct {
+	Type     string              `json:"type"`
+	Room     string              `json:"room"`
+	Parent   *ReplyPreview       `json:"parent"`
+	Messages []HistoryMessageDTO `json:"messages"` // Oldest first
+}
+
+// UserSearchResultsDTO answers a search_users request
+type UserSearchResultsDTO struct {
+	Type  string           `json:"type"`
+	Query string           `json:"query"`
+	Users []UserSummaryDTO `json:"users"` // In name order
+}
+
+// UserSummaryDTO identifies a user in search results
+type UserSummaryDTO struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+// InviteReceivedDTO tells a user they were invited to a room
+type InviteReceivedDTO struct {
+	Type      string `json:"type"`
+	InviteID  string `json:"invite_id"`
+	Room      string `json:"room"`
+	Private   bool   `json:"private"`
+	From      string `json:"from"`    // Inviter's username
+	FromID    string `json:"from_id"` // Inviter's user ID
+	InvitedAt string `json:"invited_at"`
+}
+
+// User statuses reported in PublicUserDTO
+const (
+	UserStatusOnline  = "online"
+	UserStatusOffline = "offline"
+)
+
+// PublicUserDTO is another user's profile, returned by get_user
+type PublicUserDTO struct {
+	UserID        string    `json:"userId"`
+	Username      string    `json:"username"`
+	Status        string    `json:"status"`        // UserStatusOnline when connected to any server
+	RoomsInCommon []string  `json:"roomsInCommon"` // Rooms both users are in on this server
+	MemberSince   time.Time `json:"memberSince"`
+}
+
+// RoomPolicy describes a room's settings, returned by get_room_policy
+type RoomPolicy struct {
+	Name              string `json:"name"`
+	Private           bool   `json:"private"`
+	MaxClients        int    `json:"maxClients"`
+	SuppressJoinLeave bool   `json:"suppress_join_leave"`
+
+	LinkPolicy *LinkPolicyDTO `json:"link_policy,omitempty"` // The room's own link policy, on top of the server's
+}
+
+// LinkPolicyDTO is a room's link policy: mode is any, allowlist or none
+type LinkPolicyDTO struct {
+	Mode           string   `json:"mode,omitempty"`
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	DeniedDomains  []string `json:"denied_domains,omitempty"`
+}
+
+// ErrorDTO reports why a message was refused when clients may act on the
+// reason, such as links_not_allowed
+type ErrorDTO struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 // Message type constants
@@ -60,4 +354,51 @@ const (
 	MsgTypeDeleteRoom  = "delete_room"
 	MsgTypeGetMessages = "get_messages"
 	MsgTypeRoomSync    = "room_sync"  // Room synchronization across servers
+	MsgTypePresence    = "presence"   // Per-server room occupancy across servers
+
+	MsgTypePinnedMessageUpdated = "pinned_message_updated" // A room's pin list changed
+	MsgTypeSetRoomSettings      = "set_room_settings"      // Creator-only room settings update
+	MsgTypeGetRoomPolicy        = "get_room_policy"        // Read a room's settings
+	MsgTypeMySessions           = "my_sessions"            // List the user's active connections
+	MsgTypeTerminateSession     = "terminate_session"      // Close one of the user's other connections
+	MsgTypeDirectMessage        = "direct_message"         // Message to a single user on any server
+	MsgTypeDirectMessageStatus  = "direct_message_status"  // Delivery confirmation for a direct message
+	MsgTypeSystem               = "system"                 // Server notice sent to every client
+	MsgTypeUserOnline           = "user_online"            // A user's connections on a server changed
+	MsgTypeUserOffline          = "user_offline"           // A user has no connections left on a server
+	MsgTypeGetPresence          = "get_presence"           // List users connected anywhere in the cluster
+	MsgTypeListMembers          = "list_members"           // List a room's members with online flags
+	MsgTypePollCreate           = "create_poll"            // Start a poll in the current room
+	MsgTypePollVote             = "vote_poll"              // Cast or change a vote
+	MsgTypePollResults          = "get_poll_results"       // Fetch a poll's vote counts
+	MsgTypePollCreated          = "poll_created"           // A poll was started in the room
+	MsgTypePollUpdated          = "poll_updated"           // A poll's tallies changed
+	MsgTypePollEnded            = "poll_ended"             // A poll closed with its final results
+	MsgTypeClusterHeartbeat     = "cluster_heartbeat"      // A server's liveness and load across servers
+	MsgTypeMoveUser             = "move_user"              // Admin or room creator moves a user into a room
+	MsgTypeGetUser              = "get_user"               // Look up a user's public profile
+	MsgTypeRoomHistory          = "room_history"           // Recent messages sent after joining a room
+	MsgTypeDisconnectUser       = "disconnect_user"        // Close a user's connections on every server
+	MsgTypeEditMessage          = "edit_message"           // Change the text of a stored room message
+	MsgTypeDeleteMessage        = "delete_message"         // Remove a stored room message
+	MsgTypeMessageEdited        = "message_edited"         // A room message's text changed
+	MsgTypeMessageDeleted       = "message_deleted"        // A room message was removed
+	MsgTypeConnected            = "connected"              // Sent once a new connection is registered
+	MsgTypeExportRoom           = "export_room"            // Room creator or admin downloads a room's messages
+	MsgTypeExportChunk          = "export_chunk"           // Gzipped binary frame with one page of an export
+	MsgTypeExportComplete       = "export_complete"        // Sent after an export's last chunk
+	MsgTypeChangeRoomPassword   = "change_room_password"   // Creator-only change of a private room's password
+	MsgTypeRoomPasswordChanged  = "room_password_changed"  // A room's password was changed
+	MsgTypeRestoreRoom          = "restore_room"           // Admin brings back a deleted room
+	MsgTypeThreadMessages       = "thread_messages"        // Fetch the replies to a message
+	MsgTypeTypingStart          = "typing_start"           // The sender started typing in its room
+	MsgTypeTypingStop           = "typing_stop"            // The sender stopped typing, left or disconnected
+	MsgTypeRoomAlert            = "room_alert"             // Set a room's moderation banner; also sent when it changes
+	MsgTypeClearRoomAlert       = "clear_room_alert"       // Remove a room's moderation banner
+	MsgTypeSearchUsers          = "search_users"           // Find users by part of their name
+	MsgTypeUserSearchResults    = "user_search_results"    // Users matching a search_users query
+	MsgTypeSendInvite           = "send_invite"            // Invite a user to a room
+	MsgTypeInviteReceived       = "invite_received"        // Sent to a user invited to a room
+	MsgTypeUserLookup           = "user_lookup"            // Find the server a user is connected to
+	MsgTypeError                = "error"                  // A message was refused; see ErrorDTO
 )
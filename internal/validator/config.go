@@ -0,0 +1,191 @@
+package validator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// Default limits used when Config leaves them unset
+const (
+	DefaultMinPasswordLength = 8
+	DefaultMinPasswordScore  = PasswordScoreSafe
+	DefaultMinUsernameLength = 3
+	DefaultMaxUsernameLength = 30
+	DefaultMaxRoomNameLength = 50
+)
+
+// DefaultReservedUsernames are protected when RESERVED_USERNAMES is unset
+var DefaultReservedUsernames = []string{"admin", "root", "system", "api", "www", "mail", "support", "info", "about"}
+
+// Config holds the tunable validation limits, read once at startup by
+// config.Load
+type Config struct {
+	MaxMessageSize    int // Largest accepted WebSocket message, up to MaxMessageSize
+	MinPasswordLength int
+	MinPasswordScore  int // 1 to MaxPasswordScore; see EstimatePasswordStrength
+	MinUsernameLength int
+	MaxUsernameLength int
+	ReservedUsernames []string
+	MaxRoomNameLength int
+	ReservedRoomNames []string
+	WeakPasswordsFile string     // One password per line; empty uses the bundled common password list
+	LinkPolicy        LinkPolicy // Links allowed in messages; the zero policy allows all
+}
+
+// DefaultConfig returns the limits used when nothing is configured
+func DefaultConfig() Config {
+	return Config{
+		MaxMessageSize:    MaxMessageSizeDefault,
+		MinPasswordLength: DefaultMinPasswordLength,
+		MinPasswordScore:  DefaultMinPasswordScore,
+		MinUsernameLength: DefaultMinUsernameLength,
+		MaxUsernameLength: DefaultMaxUsernameLength,
+		ReservedUsernames: DefaultReservedUsernames,
+		MaxRoomNameLength: DefaultMaxRoomNameLength,
+		ReservedRoomNames: DefaultReservedRoomNames,
+		LinkPolicy:        LinkPolicy{Mode: DefaultLinkMode},
+	}
+}
+
+// Validator checks user input against a fixed Config. It is safe for
+// concurrent use and never changes after New, so hot paths read its limits
+// without locking.
+type Validator struct {
+	cfg           Config
+	weakPasswords map[string]bool // Nil uses the bundled list
+}
+
+// New returns a Validator for cfg, loading cfg.WeakPasswordsFile if set.
+// Zero limits fall back to DefaultConfig.
+func New(cfg Config) (*Validator, error) {
+	defaults := DefaultConfig()
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = defaults.MaxMessageSize
+	}
+	cfg.MaxMessageSize = min(cfg.MaxMessageSize, MaxMessageSize)
+	if cfg.MinPasswordLength <= 0 {
+		cfg.MinPasswordLength = defaults.MinPasswordLength
+	}
+	if cfg.MinPasswordScore <= 0 {
+		cfg.MinPasswordScore = defaults.MinPasswordScore
+	}
+	if cfg.MinPasswordScore > MaxPasswordScore {
+		return nil, fmt.Errorf("minimum password score %d is above %d", cfg.MinPasswordScore, MaxPasswordScore)
+	}
+	if cfg.MinUsernameLength <= 0 {
+		cfg.MinUsernameLength = defaults.MinUsernameLength
+	}
+	if cfg.MaxUsernameLength <= 0 {
+		cfg.MaxUsernameLength = defaults.MaxUsernameLength
+	}
+	if cfg.MaxRoomNameLength <= 0 {
+		cfg.MaxRoomNameLength = defaults.MaxRoomNameLength
+	}
+	if cfg.MinUsernameLength > cfg.MaxUsernameLength {
+		return nil, fmt.Errorf("minimum username length %d is above the maximum %d", cfg.MinUsernameLength, cfg.MaxUsernameLength)
+	}
+	if cfg.ReservedUsernames == nil {
+		cfg.ReservedUsernames = defaults.ReservedUsernames
+	}
+	if cfg.ReservedRoomNames == nil {
+		cfg.ReservedRoomNames = defaults.ReservedRoomNames
+	}
+	linkPolicy, err := ParseLinkPolicy(string(cfg.LinkPolicy.Mode), cfg.LinkPolicy.AllowedDomains, cfg.LinkPolicy.DeniedDomains)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link policy: %w", err)
+	}
+	cfg.LinkPolicy = linkPolicy
+
+	var weak []string
+	if cfg.WeakPasswordsFile != "" {
+		if weak, err = readWeakPasswords(cfg.WeakPasswordsFile); err != nil {
+			return nil, err
+		}
+	}
+	return newValidator(cfg, weak), nil
+}
+
+// newValidator builds a Validator without checking cfg; nil weak uses the
+// bundled common password list
+func newValidator(cfg Config, weak []string) *Validator {
+	v := &Validator{cfg: cfg}
+	if weak == nil {
+		return v
+	}
+	v.weakPasswords = make(map[string]bool, len(weak))
+	for _, password := range weak {
+		v.weakPasswords[strings.ToLower(password)] = true
+	}
+	return v
+}
+
+// readWeakPasswords reads one password per line, skipping blank lines and
+// lines starting with #
+func readWeakPasswords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open weak passwords file: %w", err)
+	}
+	defer f.Close()
+
+	passwords := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords = append(passwords, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read weak passwords file: %w", err)
+	}
+	return passwords, nil
+}
+
+// Config returns the limits v checks against
+func (v *Validator) Config() Config {
+	return v.cfg
+}
+
+// MaxMessageSize returns the largest accepted WebSocket message
+func (v *Validator) MaxMessageSize() int {
+	return v.cfg.MaxMessageSize
+}
+
+// with returns a copy of v using cfg and v's weak passwords
+func (v *Validator) with(cfg Config) *Validator {
+	return &Validator{cfg: cfg, weakPasswords: v.weakPasswords}
+}
+
+// defaultValidator backs the package-level functions
+var defaultValidator atomic.Pointer[Validator]
+
+func init() {
+	defaultValidator.Store(newValidator(DefaultConfig(), nil))
+}
+
+// Default returns the Validator used by the package-level functions
+func Default() *Validator {
+	return defaultValidator.Load()
+}
+
+// SetDefault replaces the Validator used by the package-level functions
+func SetDefault(v *Validator) {
+	defaultValidator.Store(v)
+}
+
+// updateDefault applies change to a copy of the default Validator's Config
+func updateDefault(change func(*Config)) {
+	for {
+		old := defaultValidator.Load()
+		cfg := old.cfg
+		change(&cfg)
+		if defaultValidator.CompareAndSwap(old, old.with(cfg)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDefaults(t *testing.T) {
+	v, err := New(Config{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultConfig(), v.Config())
+
+	v, err = New(Config{MaxMessageSize: 2 * MaxMessageSize})
+	require.NoError(t, err)
+	assert.Equal(t, MaxMessageSize, v.MaxMessageSize(), "capped at MaxMessageSize")
+
+	_, err = New(Config{MinUsernameLength: 10, MaxUsernameLength: 5})
+	assert.Error(t, err)
+}
+
+func TestValidatorConfig(t *testing.T) {
+	v, err := New(Config{
+		MinPasswordLength: 12,
+		MinUsernameLength: 2,
+		MaxUsernameLength: 5,
+		ReservedUsernames: []string{"ops"},
+		MaxRoomNameLength: 6,
+		ReservedRoomNames: []string{"staff"},
+	})
+	require.NoError(t, err)
+
+	assert.NoError(t, v.ValidateUsername("jo"))
+	assert.ErrorContains(t, v.ValidateUsername("joanna"), "less than 5")
+	assert.ErrorContains(t, v.ValidateUsername("OPS"), "reserved")
+	assert.NoError(t, v.ValidateUsername("admin"), "only the configured names are reserved")
+
+	assert.ErrorContains(t, v.ValidatePassword("eleven-char"), "at least 12")
+	assert.NoError(t, v.ValidatePassword("twelve-chars"))
+
+	assert.ErrorContains(t, v.ValidateRoomName("lounges"), "less than 6")
+	assert.ErrorContains(t, v.ValidateRoomName("staff"), "reserved")
+	assert.NoError(t, v.ValidateRoomName("admin"))
+
+	// The package-level functions keep using the default limits
+	assert.ErrorContains(t, ValidateUsername("jo"), "at least 3")
+}
+
+func TestWeakPasswordsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "weak.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# common passwords\nletmein123\n\n  Sunshine99  \n"), 0o600))
+
+	v, err := New(Config{WeakPasswordsFile: path})
+	require.NoError(t, err)
+	assert.ErrorContains(t, v.ValidatePassword("LetMeIn123"), "too common")
+	assert.ErrorContains(t, v.ValidatePassword("sunshine99"), "too common")
+	assert.NoError(t, v.ValidatePassword("password123"), "the file replaces the built-in list")
+
+	_, err = New(Config{WeakPasswordsFile: filepath.Join(t.TempDir(), "missing.txt")})
+	assert.Error(t, err)
+}
+
+func TestDefaultSetters(t *testing.T) {
+	original := Default()
+	t.Cleanup(func() { SetDefault(original) })
+
+	v, err := New(Config{MinPasswordLength: 10})
+	require.NoError(t, err)
+	SetDefault(v)
+	SetMaxMessageSize(2048)
+	SetReservedRoomNames([]string{"staff"})
+
+	assert.Equal(t, 2048, GetMaxMessageSize())
+	assert.True(t, IsReservedRoomName("Staff"))
+	assert.False(t, IsReservedRoomName("admin"))
+	assert.ErrorContains(t, ValidatePassword("nine-char"), "at least 10", "setters keep the rest of the config")
+	assert.Equal(t, DefaultConfig().MaxMessageSize, v.MaxMessageSize(), "setters don't change existing validators")
+}
+
+// The per-message size lookup is a field read; "env" is what parsing
+// WS_MAX_MESSAGE_SIZE on every message would cost
+func BenchmarkMaxMessageSize(b *testing.B) {
+	b.Run("validator", func(b *testing.B) {
+		v := Default()
+		for b.Loop() {
+			_ = ValidateMessageSize(512, v.MaxMessageSize())
+		}
+	})
+	b.Run("default", func(b *testing.B) {
+		for b.Loop() {
+			_ = ValidateMessageSize(512, GetMaxMessageSize())
+		}
+	})
+	b.Run("env", func(b *testing.B) {
+		b.Setenv("WS_MAX_MESSAGE_SIZE", "65536")
+		for b.Loop() {
+			size, _ := strconv.Atoi(os.Getenv("WS_MAX_MESSAGE_SIZE"))
+			_ = ValidateMessageSize(512, size)
+		}
+	})
+}
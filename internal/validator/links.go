@@ -0,0 +1,208 @@
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// LinkMode is which links messages may contain
+type LinkMode string
+
+const (
+	// LinkModeAny allows links except to denied domains
+	LinkModeAny LinkMode = "any"
+	// LinkModeAllowlist allows only links to allowed domains
+	LinkModeAllowlist LinkMode = "allowlist"
+	// LinkModeNone refuses every link
+	LinkModeNone LinkMode = "none"
+)
+
+// DefaultLinkMode is used when LINK_POLICY is unset
+const DefaultLinkMode = LinkModeAny
+
+// LinkReasonNotAllowed is the ValidationError.Code of a message refused for its links
+const LinkReasonNotAllowed = "links_not_allowed"
+
+// maxPolicyDomains caps each domain list of a room's link policy
+const maxPolicyDomains = 50
+
+// LinkPolicy decides which links messages may contain. Domains match
+// themselves and their subdomains, and the deny list wins over the allow list.
+type LinkPolicy struct {
+	Mode           LinkMode
+	AllowedDomains []string // With LinkModeAllowlist, the domains links may point to
+	DeniedDomains  []string
+}
+
+// ParseLinkMode parses a LINK_POLICY value, ignoring case
+func ParseLinkMode(value string) (LinkMode, error) {
+	switch mode := LinkMode(strings.ToLower(strings.TrimSpace(value))); mode {
+	case LinkModeAny, LinkModeAllowlist, LinkModeNone:
+		return mode, nil
+	}
+	return "", fmt.Errorf("must be one of %s, %s or %s", LinkModeAny, LinkModeAllowlist, LinkModeNone)
+}
+
+// ParseLinkPolicy builds a LinkPolicy, lowercasing the domains and dropping a
+// leading "*." or "."; an empty mode is LinkModeAny. Allowlist mode needs at
+// least one allowed domain.
+func ParseLinkPolicy(mode string, allowed, denied []string) (LinkPolicy, error) {
+	policy := LinkPolicy{Mode: DefaultLinkMode}
+	if strings.TrimSpace(mode) != "" {
+		var err error
+		if policy.Mode, err = ParseLinkMode(mode); err != nil {
+			return LinkPolicy{}, err
+		}
+	}
+	var err error
+	if policy.AllowedDomains, err = parseDomains(allowed); err != nil {
+		return LinkPolicy{}, err
+	}
+	if policy.DeniedDomains, err = parseDomains(denied); err != nil {
+		return LinkPolicy{}, err
+	}
+	if policy.Mode == LinkModeAllowlist && len(policy.AllowedDomains) == 0 {
+		return LinkPolicy{}, fmt.Errorf("%s needs at least one allowed domain", LinkModeAllowlist)
+	}
+	return policy, nil
+}
+
+// domainPattern matches a host name such as wiki.example.com
+var domainPattern = regexp.MustCompile(`^(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)*[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func parseDomains(domains []string) ([]string, error) {
+	if len(domains) > maxPolicyDomains {
+		return nil, fmt.Errorf("at most %d domains may be listed", maxPolicyDomains)
+	}
+	var parsed []string
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		domain = strings.TrimPrefix(strings.TrimPrefix(domain, "*"), ".")
+		if domain == "" {
+			continue
+		}
+		if !domainPattern.MatchString(domain) {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+		if !slices.Contains(parsed, domain) {
+			parsed = append(parsed, domain)
+		}
+	}
+	return parsed, nil
+}
+
+// IsZero reports whether p allows every link, as a room without its own
+// policy does
+func (p LinkPolicy) IsZero() bool {
+	return (p.Mode == "" || p.Mode == LinkModeAny) && len(p.DeniedDomains) == 0
+}
+
+// Allows reports whether p lets a message link to host
+func (p LinkPolicy) Allows(host string) bool {
+	if matchesDomain(host, p.DeniedDomains) {
+		return false
+	}
+	switch p.Mode {
+	case LinkModeNone:
+		return false
+	case LinkModeAllowlist:
+		return matchesDomain(host, p.AllowedDomains)
+	}
+	return true
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Spellings people use to keep a link from being recognised
+var (
+	obfuscatedScheme = regexp.MustCompile(`(?i)\bh(?:xx|\*\*|__)p(s?)(\[?:\]?)//`)
+	obfuscatedDot    = regexp.MustCompile(`(?i)\s*[\[({]\s*(?:\.|dot)\s*[\])}]\s*`)
+	unicodeDots      = strings.NewReplacer("。", ".", "｡", ".", "․", ".", "﹒", ".")
+)
+
+// linkPattern finds a host after a scheme, any host at all, or, without a
+// scheme, a domain ending in a schemelessTLDs entry so that file names such as
+// main.go aren't taken for links
+var linkPattern = regexp.MustCompile(`(?i)\b[a-z][a-z0-9+.-]*://([^\s/?#<>"']+)|\b((?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+([a-z]{2,63}))\b`)
+
+// schemelessTLDs are the top-level domains recognised without a scheme
+var schemelessTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "edu": true, "gov": true, "mil": true, "int": true,
+	"info": true, "biz": true, "io": true, "co": true, "ai": true, "app": true, "dev": true,
+	"me": true, "us": true, "uk": true, "de": true, "fr": true, "ru": true, "cn": true,
+	"jp": true, "in": true, "br": true, "au": true, "ca": true, "nl": true, "es": true,
+	"it": true, "pl": true, "se": true, "ch": true, "eu": true, "tv": true, "xyz": true,
+	"site": true, "online": true, "tech": true, "store": true, "blog": true, "cloud": true,
+	"page": true, "link": true, "ly": true, "gg": true, "to": true, "be": true, "top": true,
+}
+
+// ExtractLinkHosts returns the lowercased hosts of the links in content,
+// without duplicates, in the order they first appear. It sees through
+// spellings such as hxxp://, example[.]com, ideographic or fullwidth dots and
+// links without a scheme.
+func ExtractLinkHosts(content string) []string {
+	content = norm.NFKC.String(StripInvisible(content))
+	content = unicodeDots.Replace(content)
+	content = obfuscatedScheme.ReplaceAllString(content, "http$1://")
+	content = obfuscatedDot.ReplaceAllString(content, ".")
+
+	var hosts []string
+	for _, match := range linkPattern.FindAllStringSubmatch(content, -1) {
+		host := match[1]
+		if host == "" {
+			if !schemelessTLDs[strings.ToLower(match[3])] {
+				continue
+			}
+			host = match[2]
+		}
+		// Drop user info and port: http://wiki.example.com@evil.test:8080
+		if i := strings.LastIndex(host, "@"); i >= 0 {
+			host = host[i+1:]
+		}
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+			host = host[:i]
+		}
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		if host != "" && !slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// CheckLinks checks the links in content with the default Validator
+func CheckLinks(content string, roomPolicy *LinkPolicy) error {
+	return Default().CheckLinks(content, roomPolicy)
+}
+
+// CheckLinks refuses content linking anywhere the configured policy or
+// roomPolicy, when not nil, doesn't allow. A link must pass both, so a room's
+// policy can only be stricter. The error is a ValidationError with Code
+// LinkReasonNotAllowed.
+func (v *Validator) CheckLinks(content string, roomPolicy *LinkPolicy) error {
+	if v.cfg.LinkPolicy.IsZero() && (roomPolicy == nil || roomPolicy.IsZero()) {
+		return nil
+	}
+	for _, host := range ExtractLinkHosts(content) {
+		if v.cfg.LinkPolicy.Allows(host) && (roomPolicy == nil || roomPolicy.Allows(host)) {
+			continue
+		}
+		message := fmt.Sprintf("links to %s are not allowed", host)
+		if v.cfg.LinkPolicy.Mode == LinkModeNone || roomPolicy != nil && roomPolicy.Mode == LinkModeNone {
+			message = "links are not allowed"
+		}
+		return ValidationError{Field: "content", Message: message, Code: LinkReasonNotAllowed}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package validator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractLinkHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		hosts   []string
+	}{
+		{"plain", "see https://Wiki.Example.com/page?id=1 now", []string{"wiki.example.com"}},
+		{"several", "http://a.example.com and ftp://files.example.net/x http://a.example.com", []string{"a.example.com", "files.example.net"}},
+		{"missing scheme", "go to example.com/docs or www.example.org", []string{"example.com", "www.example.org"}},
+		{"hxxp", "hxxps://evil.test/payload", []string{"evil.test"}},
+		{"starred scheme", "h**p[:]//evil.test", []string{"evil.test"}},
+		{"bracketed dots", "evil[.]com and evil(dot)net and evil { . } org", []string{"evil.com", "evil.net", "evil.org"}},
+		{"ideographic dot", "evil。com", []string{"evil.com"}},
+		{"fullwidth dot", "evil．com", []string{"evil.com"}},
+		{"halfwidth ideographic dot", "evil｡com", []string{"evil.com"}},
+		{"fullwidth letters", "ｅｖｉｌ．ｃｏｍ", []string{"evil.com"}},
+		{"zero-width space", "evil\u200b.com", []string{"evil.com"}},
+		{"user info and port", "http://wiki.example.com@evil.test:8080/", []string{"evil.test"}},
+		{"ip address", "http://10.0.0.1:8080/admin", []string{"10.0.0.1"}},
+		{"file names", "edit main.go and README.md, e.g. v1.2", nil},
+		{"no links", "see you at 5.30", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.hosts, ExtractLinkHosts(tt.content))
+		})
+	}
+}
+
+func TestParseLinkPolicy(t *testing.T) {
+	policy, err := ParseLinkPolicy("", nil, []string{" Evil.COM ", "*.tracker.net", ".ads.org", "evil.com"})
+	require.NoError(t, err)
+	assert.Equal(t, LinkPolicy{Mode: LinkModeAny, DeniedDomains: []string{"evil.com", "tracker.net", "ads.org"}}, policy)
+
+	_, err = ParseLinkPolicy("allowlist", nil, nil)
+	assert.ErrorContains(t, err, "at least one allowed domain")
+	_, err = ParseLinkPolicy("some", nil, nil)
+	assert.Error(t, err)
+	_, err = ParseLinkPolicy("any", nil, []string{"http://evil.com"})
+	assert.ErrorContains(t, err, "invalid domain")
+}
+
+func TestCheckLinks(t *testing.T) {
+	linkCode := func(err error) string {
+		var validationErr ValidationError
+		require.True(t, errors.As(err, &validationErr), "%v", err)
+		return validationErr.Code
+	}
+	newValidator := func(mode LinkMode, allowed, denied []string) *Validator {
+		v, err := New(Config{LinkPolicy: LinkPolicy{Mode: mode, AllowedDomains: allowed, DeniedDomains: denied}})
+		require.NoError(t, err)
+		return v
+	}
+
+	t.Run("default allows links", func(t *testing.T) {
+		assert.NoError(t, CheckLinks("https://anywhere.example.com", nil))
+	})
+
+	t.Run("deny list", func(t *testing.T) {
+		v := newValidator(LinkModeAny, nil, []string{"evil.com"})
+		assert.NoError(t, v.CheckLinks("https://good.example.com", nil))
+		err := v.CheckLinks("look: www.evil[.]com", nil)
+		assert.Equal(t, LinkReasonNotAllowed, linkCode(err))
+		assert.ErrorContains(t, err, "links to www.evil.com are not allowed")
+		assert.Error(t, v.CheckLinks("hxxp://cdn.EVIL.com", nil), "subdomains are denied too")
+		assert.NoError(t, v.CheckLinks("notevil.com", nil), "only whole labels match")
+	})
+
+	t.Run("allow list", func(t *testing.T) {
+		v := newValidator(LinkModeAllowlist, []string{"wiki.corp.example"}, []string{"old.wiki.corp.example"})
+		assert.NoError(t, v.CheckLinks("https://wiki.corp.example/page and no other link", nil))
+		assert.NoError(t, v.CheckLinks("https://team.wiki.corp.example", nil))
+		assert.Error(t, v.CheckLinks("https://wiki.corp.example@evil.com", nil))
+		assert.Error(t, v.CheckLinks("https://old.wiki.corp.example", nil), "the deny list wins")
+		assert.NoError(t, v.CheckLinks("plain text", nil))
+	})
+
+	t.Run("no links", func(t *testing.T) {
+		v := newValidator(LinkModeNone, nil, nil)
+		err := v.CheckLinks("evil｡com", nil)
+		assert.Equal(t, LinkReasonNotAllowed, linkCode(err))
+		assert.ErrorContains(t, err, "links are not allowed")
+		assert.NoError(t, v.CheckLinks("edit main.go", nil))
+	})
+
+	t.Run("room policy is only stricter", func(t *testing.T) {
+		v := newValidator(LinkModeAny, nil, []string{"evil.com"})
+		room := &LinkPolicy{Mode: LinkModeAllowlist, AllowedDomains: []string{"evil.com", "docs.example.com"}}
+		assert.NoError(t, v.CheckLinks("https://docs.example.com", room))
+		assert.Error(t, v.CheckLinks("https://other.example.com", room), "the room's allow list applies")
+		assert.Error(t, v.CheckLinks("https://evil.com", room), "the room can't allow what the server denies")
+
+		none := &LinkPolicy{Mode: LinkModeNone}
+		assert.ErrorContains(t, CheckLinks("https://docs.example.com", none), "links are not allowed")
+	})
+}
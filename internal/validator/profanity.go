@@ -0,0 +1,101 @@
+package validator
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ProfanityAction is what happens to a message containing a blocked word
+type ProfanityAction string
+
+const (
+	// ProfanityActionMask replaces each blocked word with asterisks
+	ProfanityActionMask ProfanityAction = "mask"
+	// ProfanityActionReject refuses the whole message
+	ProfanityActionReject ProfanityAction = "reject"
+	// ProfanityActionFlag delivers the message unchanged and records it for moderators
+	ProfanityActionFlag ProfanityAction = "flag"
+)
+
+// DefaultProfanityAction is used when PROFANITY_ACTION is unset
+const DefaultProfanityAction = ProfanityActionMask
+
+// Profanity filter configured at startup from config.Config
+var (
+	profanityAction  = DefaultProfanityAction
+	profanityPattern *regexp.Regexp // nil when no words are blocked
+)
+
+// ParseProfanityAction parses a PROFANITY_ACTION value, ignoring case
+func ParseProfanityAction(value string) (ProfanityAction, error) {
+	switch action := ProfanityAction(strings.ToLower(strings.TrimSpace(value))); action {
+	case ProfanityActionMask, ProfanityActionReject, ProfanityActionFlag:
+		return action, nil
+	}
+	return "", fmt.Errorf("must be one of %s, %s or %s", ProfanityActionMask, ProfanityActionReject, ProfanityActionFlag)
+}
+
+// SetProfanityFilter replaces the blocked words and the action taken on
+// them; config.Load reads them from PROFANITY_WORDS and PROFANITY_ACTION.
+// Words match whole words, ignoring case; no words turns the filter off.
+func SetProfanityFilter(words []string, action ProfanityAction) {
+	var pattern *regexp.Regexp
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) > 0 {
+		pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	profanityPattern = pattern
+	profanityAction = action
+}
+
+// GetProfanityAction returns the action set with SetProfanityFilter, or
+// DefaultProfanityAction
+func GetProfanityAction() ProfanityAction {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return profanityAction
+}
+
+// FindProfanity returns the blocked words in content, lowercased and
+// without duplicates, in the order they first appear
+func FindProfanity(content string) []string {
+	settingsMu.RLock()
+	pattern := profanityPattern
+	settingsMu.RUnlock()
+	if pattern == nil {
+		return nil
+	}
+
+	var found []string
+	seen := make(map[string]bool)
+	for _, match := range pattern.FindAllString(content, -1) {
+		word := strings.ToLower(match)
+		if !seen[word] {
+			seen[word] = true
+			found = append(found, word)
+		}
+	}
+	return found
+}
+
+// MaskProfanity replaces every blocked word in content with one asterisk per character
+func MaskProfanity(content string) string {
+	settingsMu.RLock()
+	pattern := profanityPattern
+	settingsMu.RUnlock()
+	if pattern == nil {
+		return content
+	}
+	return pattern.ReplaceAllStringFunc(content, func(match string) string {
+		return strings.Repeat("*", len([]rune(match)))
+	})
+}
@@ -0,0 +1,37 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProfanityAction(t *testing.T) {
+	for value, want := range map[string]ProfanityAction{
+		"mask":     ProfanityActionMask,
+		" Reject ": ProfanityActionReject,
+		"FLAG":     ProfanityActionFlag,
+	} {
+		action, err := ParseProfanityAction(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, action)
+	}
+
+	_, err := ParseProfanityAction("delete")
+	assert.Error(t, err)
+}
+
+func TestProfanityFilter(t *testing.T) {
+	t.Cleanup(func() { SetProfanityFilter(nil, DefaultProfanityAction) })
+
+	assert.Nil(t, FindProfanity("darn it"), "no words configured")
+	assert.Equal(t, "darn it", MaskProfanity("darn it"))
+
+	SetProfanityFilter([]string{"darn", " heck ", ""}, ProfanityActionFlag)
+	assert.Equal(t, ProfanityActionFlag, GetProfanityAction())
+
+	assert.Equal(t, []string{"darn", "heck"}, FindProfanity("Darn, what the HECK, darn"))
+	assert.Nil(t, FindProfanity("darned checks"), "only whole words match")
+	assert.Equal(t, "****, what the ****", MaskProfanity("Darn, what the HECK"))
+}
@@ -0,0 +1,241 @@
+package validator
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ContentType says how message content is written and so which Policy cleans it
+type ContentType string
+
+const (
+	// ContentTypePlain is plain text; no markup survives
+	ContentTypePlain ContentType = "plain"
+	// ContentTypeMarkdown is HTML rendered from markdown; simple formatting survives
+	ContentTypeMarkdown ContentType = "markdown"
+)
+
+// DefaultContentType is used when MESSAGE_CONTENT_TYPE is unset
+const DefaultContentType = ContentTypePlain
+
+// Policy cleans untrusted content so it is safe to render as HTML
+type Policy interface {
+	Sanitize(input string) string
+}
+
+// Sanitizer settings configured at startup from config.Config
+var (
+	messageContentType = DefaultContentType
+	policies           = map[ContentType]Policy{
+		ContentTypePlain:    StrictPolicy(),
+		ContentTypeMarkdown: MarkdownPolicy(),
+	}
+)
+
+// dropContentElements are removed together with their text unless allowed;
+// the tokenizer reads their contents as raw text
+var dropContentElements = map[string]bool{
+	"iframe": true, "noembed": true, "noframes": true, "noscript": true, "plaintext": true,
+	"script": true, "style": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// urlAttributes hold links whose scheme is checked against AllowURLSchemes
+var urlAttributes = map[string]bool{"href": true, "src": true}
+
+// AllowlistPolicy keeps only the elements, attributes and URL schemes it was
+// told to allow. Other known HTML elements are dropped, keeping their text,
+// and anything that only looks like a tag, such as <Enter>, stays as text.
+// Text is HTML-escaped, so entities can't smuggle markup through.
+type AllowlistPolicy struct {
+	elements   map[string]map[string]bool // element -> allowed attributes
+	urlSchemes map[string]bool
+}
+
+// NewAllowlistPolicy returns a policy that allows no markup at all
+func NewAllowlistPolicy() *AllowlistPolicy {
+	return &AllowlistPolicy{elements: make(map[string]map[string]bool), urlSchemes: make(map[string]bool)}
+}
+
+// AllowElements allows the named elements without attributes
+func (p *AllowlistPolicy) AllowElements(names ...string) *AllowlistPolicy {
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if p.elements[name] == nil {
+			p.elements[name] = make(map[string]bool)
+		}
+	}
+	return p
+}
+
+// AllowAttrs allows attrs on element, allowing the element too
+func (p *AllowlistPolicy) AllowAttrs(element string, attrs ...string) *AllowlistPolicy {
+	p.AllowElements(element)
+	for _, attr := range attrs {
+		p.elements[strings.ToLower(element)][strings.ToLower(attr)] = true
+	}
+	return p
+}
+
+// AllowURLSchemes allows links with the given schemes. Links without a
+// scheme or with any other one, such as javascript:, are removed.
+func (p *AllowlistPolicy) AllowURLSchemes(schemes ...string) *AllowlistPolicy {
+	for _, scheme := range schemes {
+		p.urlSchemes[strings.ToLower(scheme)] = true
+	}
+	return p
+}
+
+// Sanitize returns input with everything the policy doesn't allow removed
+// or escaped
+func (p *AllowlistPolicy) Sanitize(input string) string {
+	var out strings.Builder
+	z := html.NewTokenizer(strings.NewReader(input))
+	skipping := "" // element whose contents are being dropped
+
+	for {
+		tokenType := z.Next()
+		if tokenType == html.ErrorToken {
+			// An unfinished tag at the end of input is kept as text
+			out.WriteString(escapeText(string(z.Raw())))
+			return out.String()
+		}
+		raw := string(z.Raw())
+		token := z.Token()
+
+		if skipping != "" {
+			if tokenType == html.EndTagToken && token.Data == skipping {
+				skipping = ""
+			}
+			continue
+		}
+
+		switch tokenType {
+		case html.TextToken:
+			out.WriteString(escapeText(token.Data))
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			attrs, allowed := p.elements[token.Data]
+			switch {
+			case allowed && tokenType == html.EndTagToken:
+				out.WriteString("</" + token.Data + ">")
+			case allowed:
+				out.WriteString(p.startTag(token, attrs))
+			case token.DataAtom == 0:
+				// Not an HTML element, so the brackets are part of the text
+				out.WriteString(escapeText(raw))
+			case tokenType == html.StartTagToken && dropContentElements[token.Data]:
+				skipping = token.Data
+			}
+		}
+		// Comments and doctypes are dropped
+	}
+}
+
+// startTag rebuilds token keeping only the allowed attributes with safe values
+func (p *AllowlistPolicy) startTag(token html.Token, allowedAttrs map[string]bool) string {
+	var tag strings.Builder
+	tag.WriteString("<" + token.Data)
+	for _, attr := range token.Attr {
+		key := strings.ToLower(attr.Key)
+		if attr.Namespace != "" || !allowedAttrs[key] {
+			continue
+		}
+		if urlAttributes[key] && !p.allowedURL(attr.Val) {
+			continue
+		}
+		fmt.Fprintf(&tag, ` %s="%s"`, key, html.EscapeString(attr.Val))
+	}
+	tag.WriteString(">")
+	return tag.String()
+}
+
+// allowedURL reports whether link is absolute with an allowed scheme.
+// Browsers ignore whitespace and control characters inside a scheme, so
+// they are removed before checking.
+func (p *AllowlistPolicy) allowedURL(link string) bool {
+	link = strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, link)
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" {
+		return false
+	}
+	return p.urlSchemes[strings.ToLower(u.Scheme)]
+}
+
+// escapeText escapes the characters that start markup or entities in text
+func escapeText(text string) string {
+	return textEscaper.Replace(text)
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// StrictPolicy allows no markup, for plain text
+func StrictPolicy() *AllowlistPolicy {
+	return NewAllowlistPolicy()
+}
+
+// MarkdownPolicy allows the small set of elements markdown renders to,
+// with links limited to http, https and mailto
+func MarkdownPolicy() *AllowlistPolicy {
+	return NewAllowlistPolicy().
+		AllowElements("p", "br", "hr", "strong", "b", "em", "i", "del", "s", "code", "pre",
+			"blockquote", "ul", "ol", "li", "h1", "h2", "h3", "h4", "h5", "h6").
+		AllowAttrs("a", "href", "title").
+		AllowURLSchemes("http", "https", "mailto")
+}
+
+// ParseContentType parses a MESSAGE_CONTENT_TYPE value, ignoring case
+func ParseContentType(value string) (ContentType, error) {
+	switch contentType := ContentType(strings.ToLower(strings.TrimSpace(value))); contentType {
+	case ContentTypePlain, ContentTypeMarkdown:
+		return contentType, nil
+	}
+	return "", fmt.Errorf("must be %s or %s", ContentTypePlain, ContentTypeMarkdown)
+}
+
+// SetPolicy replaces the policy used for contentType
+func SetPolicy(contentType ContentType, policy Policy) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	policies[contentType] = policy
+}
+
+// Sanitize cleans input with the policy for contentType; content types
+// without a policy get StrictPolicy
+func Sanitize(contentType ContentType, input string) string {
+	settingsMu.RLock()
+	policy, ok := policies[contentType]
+	settingsMu.RUnlock()
+	if !ok {
+		policy = StrictPolicy()
+	}
+	return policy.Sanitize(input)
+}
+
+// SetMessageContentType sets how chat messages are written; config.Load
+// reads it from MESSAGE_CONTENT_TYPE
+func SetMessageContentType(contentType ContentType) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	messageContentType = contentType
+}
+
+// GetMessageContentType returns the content type set with
+// SetMessageContentType, or DefaultContentType
+func GetMessageContentType() ContentType {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return messageContentType
+}
+
+// SanitizeMessage cleans chat message content for the configured content
+// type, after CleanText removes bidi overrides and zero-width spaces
+func SanitizeMessage(content string) string {
+	return Sanitize(GetMessageContentType(), CleanText(content))
+}
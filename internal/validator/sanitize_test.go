@@ -0,0 +1,147 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+)
+
+// xssPayloads must come out of every policy without live markup
+var xssPayloads = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=//evil.example/x.js></SCRIPT>`,
+	`<img src=x onerror=alert(1)>`,
+	`<svg onload=alert(1)>`,
+	`<body onload=alert(1)>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<a href="javascript:alert(1)">click</a>`,
+	`<a href="JaVaScRiPt:alert(1)">click</a>`,
+	`<a href="java&#x09;script:alert(1)">click</a>`,
+	`<a href="&#106;avascript:alert(1)">click</a>`,
+	`<a href=" javascript:alert(1)">click</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">click</a>`,
+	`<a href="vbscript:msgbox(1)">click</a>`,
+	`<a href="https://ok.example" onclick="alert(1)">click</a>`,
+	`&lt;script&gt;alert(1)&lt;/script&gt;`,
+	`&#60;img src=x onerror=alert(1)&#62;`,
+	`<scr<script>ipt>alert(1)</script>`,
+	`<style>body{background:url("javascript:alert(1)")}</style>`,
+	`<div style="background:url(javascript:alert(1))">x</div>`,
+	`<math><mtext><table><mglyph><style><img src=x onerror=alert(1)>`,
+	`<!--<img src=x onerror=alert(1)>-->`,
+	`<input autofocus onfocus=alert(1)>`,
+	`<details open ontoggle=alert(1)>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<custom-tag onmouseover=alert(1)>hover</custom-tag>`,
+	`"><script>alert(1)</script>`,
+	`<img src=x onerror=alert(1)`,
+}
+
+func TestSanitizeXSSPayloads(t *testing.T) {
+	markdown := MarkdownPolicy()
+	for _, contentType := range []ContentType{ContentTypePlain, ContentTypeMarkdown} {
+		for _, payload := range xssPayloads {
+			out := Sanitize(contentType, payload)
+
+			// Reading the output back as HTML finds only allowed markup
+			z := html.NewTokenizer(strings.NewReader(out))
+			for tokenType := z.Next(); tokenType != html.ErrorToken; tokenType = z.Next() {
+				token := z.Token()
+				switch tokenType {
+				case html.TextToken:
+					continue
+				case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+				default:
+					t.Errorf("%s: %q left a %v token in %q", contentType, payload, tokenType, out)
+					continue
+				}
+				attrs, allowed := markdown.elements[token.Data]
+				if contentType == ContentTypePlain || !allowed {
+					t.Errorf("%s: %q left <%s> in %q", contentType, payload, token.Data, out)
+					continue
+				}
+				for _, attr := range token.Attr {
+					assert.True(t, attrs[attr.Key], "%s: %q left %s in %q", contentType, payload, attr.Key, out)
+					if attr.Key == "href" {
+						assert.Regexp(t, `^(https?|mailto):`, attr.Val, "%s: %q", contentType, payload)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSanitizePlain(t *testing.T) {
+	for input, want := range map[string]string{
+		"hello world":                          "hello world",
+		"use <Enter> to send":                  "use &lt;Enter&gt; to send",
+		"if a < b && c > d":                    "if a &lt; b &amp;&amp; c &gt; d",
+		"<3 you":                               "&lt;3 you",
+		"x<y":                                  "x&lt;y",
+		"see <T> in List<T>":                   "see &lt;T&gt; in List&lt;T&gt;",
+		"press </Enter>":                       "press &lt;/Enter&gt;",
+		"it's \"quoted\"":                      "it's \"quoted\"",
+		"<b>bold</b> and <i>italic</i>":        "bold and italic",
+		"<script>alert(1)</script>hi":          "hi",
+		"a<br/>b":                              "ab",
+		"<!-- hidden -->shown":                 "shown",
+		"&lt;script&gt;":                       "&lt;script&gt;",
+		"<a href=\"https://ok.example\">x</a>": "x",
+	} {
+		assert.Equal(t, want, Sanitize(ContentTypePlain, input), input)
+	}
+}
+
+func TestSanitizeMarkdown(t *testing.T) {
+	for input, want := range map[string]string{
+		"<p><strong>bold</strong> and <em>em</em></p>":              "<p><strong>bold</strong> and <em>em</em></p>",
+		"<ul><li>one</li></ul><pre><code>x &lt; y</code></pre>":     "<ul><li>one</li></ul><pre><code>x &lt; y</code></pre>",
+		`<a href="https://ok.example/?a=1&amp;b=2" title="t">x</a>`: `<a href="https://ok.example/?a=1&amp;b=2" title="t">x</a>`,
+		`<a href="mailto:me@example.com">mail</a>`:                  `<a href="mailto:me@example.com">mail</a>`,
+		`<a href="javascript:alert(1)">x</a>`:                       `<a>x</a>`,
+		`<a href="/relative">x</a>`:                                 `<a>x</a>`,
+		`<p class="big" onclick="x()">hi</p>`:                       `<p>hi</p>`,
+		"line<br/>break":                                            "line<br>break",
+		"<div>not allowed</div>":                                    "not allowed",
+		"use <Enter> to send":                                       "use &lt;Enter&gt; to send",
+	} {
+		assert.Equal(t, want, Sanitize(ContentTypeMarkdown, input), input)
+	}
+}
+
+func TestSanitizePolicies(t *testing.T) {
+	t.Cleanup(func() {
+		SetPolicy(ContentTypeMarkdown, MarkdownPolicy())
+		SetMessageContentType(DefaultContentType)
+	})
+
+	// Unknown content types fall back to the strict policy
+	assert.Equal(t, "bold", Sanitize("rich", "<b>bold</b>"))
+
+	SetPolicy(ContentTypeMarkdown, NewAllowlistPolicy().AllowElements("B"))
+	assert.Equal(t, "<b>bold</b> it", Sanitize(ContentTypeMarkdown, "<b>bold</b> <i>it</i>"))
+
+	assert.Equal(t, ContentTypePlain, GetMessageContentType())
+	assert.Equal(t, "bold", SanitizeMessage("<b>bold</b>"))
+	SetMessageContentType(ContentTypeMarkdown)
+	assert.Equal(t, "<b>bold</b>", SanitizeMessage("<b>bold</b>"))
+
+	assert.Equal(t, "use &lt;Enter&gt;", SanitizeInput("  use <Enter>  "))
+}
+
+func TestParseContentType(t *testing.T) {
+	for value, want := range map[string]ContentType{
+		"plain":      ContentTypePlain,
+		" Markdown ": ContentTypeMarkdown,
+	} {
+		contentType, err := ParseContentType(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, contentType)
+	}
+
+	_, err := ParseContentType("html")
+	assert.Error(t, err)
+}
@@ -0,0 +1,268 @@
+package validator
+
+import (
+	_ "embed"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Password scores, after zxcvbn: each step is roughly a hundredfold more
+// guesses
+const (
+	PasswordScoreTooGuessable    = 0 // Under ~10 bits: guessed at once
+	PasswordScoreVeryGuessable   = 1 // Under ~20 bits: an online attack with no throttling
+	PasswordScoreGuessable       = 2 // Under ~27 bits: an online attack with throttling
+	PasswordScoreSafe            = 3 // Under ~34 bits: a slow offline hash is needed
+	PasswordScoreVeryUnguessable = 4
+	MaxPasswordScore             = PasswordScoreVeryUnguessable
+)
+
+// passwordScoreBits are the estimated bits needed to reach scores 1 to 4
+var passwordScoreBits = [...]float64{10, 20, 27, 34}
+
+// Reasons reported in ValidationError.Code when a password is refused
+const (
+	PasswordReasonTooShort          = "password_too_short"
+	PasswordReasonCommon            = "password_common"
+	PasswordReasonSimilarToUsername = "password_similar_to_username"
+	PasswordReasonTooGuessable      = "password_too_guessable"
+)
+
+//go:embed common_passwords.txt
+var bundledPasswordsFile string
+
+var (
+	bundledPasswordsOnce sync.Once
+	bundledPasswords     map[string]bool
+)
+
+// commonPasswords returns the bundled common password list, parsed on
+// first use
+func commonPasswords() map[string]bool {
+	bundledPasswordsOnce.Do(func() {
+		bundledPasswords = make(map[string]bool)
+		for _, line := range strings.Split(bundledPasswordsFile, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "#") {
+				bundledPasswords[strings.ToLower(line)] = true
+			}
+		}
+	})
+	return bundledPasswords
+}
+
+// PasswordStrength is an estimate of how hard a password is to guess
+type PasswordStrength struct {
+	Score             int     `json:"score"`               // 0 to MaxPasswordScore
+	Bits              float64 `json:"bits"`                // log2 of the estimated guesses
+	Common            bool    `json:"common"`              // Contains a common password
+	SimilarToUsername bool    `json:"similar_to_username"` // Contains one of the user's own inputs
+}
+
+// leetSubstitutions undoes common character swaps such as "P@ssw0rd"
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i', '!': 'i',
+	'|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+// keyboardRows are checked for runs such as "qwer" or "7654"
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm"}
+
+// Shortest common password and user input matched inside a password;
+// usernames may be as short as 3 characters
+const (
+	minPasswordMatchLength  = 4
+	minUserInputMatchLength = 3
+)
+
+// passwordMatch is a span of a password that is cheaper to guess than its
+// characters suggest
+type passwordMatch struct {
+	start, end int
+	bits       float64
+}
+
+// EstimatePasswordStrength scores password with the default Validator's
+// common password list
+func EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	return Default().EstimatePasswordStrength(password, userInputs...)
+}
+
+// EstimatePasswordStrength scores password in the spirit of zxcvbn: each
+// character costs bits for the character classes used, except inside
+// repeats, sequences and keyboard runs, common passwords and userInputs
+// such as the username or email, which cost about as much as picking them
+// from a list. Matching ignores case and leetspeak.
+func (v *Validator) EstimatePasswordStrength(password string, userInputs ...string) PasswordStrength {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return PasswordStrength{}
+	}
+	lower := make([]rune, len(runes))
+	unleet := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+		unleet[i] = lower[i]
+		if plain, ok := leetSubstitutions[lower[i]]; ok {
+			unleet[i] = plain
+		}
+	}
+
+	charBits := math.Log2(float64(characterPool(runes)))
+	bits := make([]float64, len(runes))
+	for i := range runes {
+		bits[i] = charBits
+		if i >= 2 && continuesPattern(lower[i-2], lower[i-1], lower[i]) {
+			bits[i] = 1
+		}
+	}
+
+	strength := PasswordStrength{}
+	var matches []passwordMatch
+	dictionary := v.commonPasswords()
+	dictionaryBits := math.Log2(float64(max(len(dictionary), 2)))
+	for start := range runes {
+		for end := start + minPasswordMatchLength; end <= len(runes); end++ {
+			for _, form := range [][]rune{lower, unleet} {
+				if dictionary[string(form[start:end])] {
+					matches = append(matches, passwordMatch{start, end, dictionaryBits + variantBits(runes[start:end], lower[start:end], unleet[start:end])})
+					strength.Common = true
+				}
+			}
+		}
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		// An email is matched by its local part
+		input, _, _ = strings.Cut(input, "@")
+		if len([]rune(input)) < minUserInputMatchLength {
+			continue
+		}
+		for _, candidate := range []string{input, reverse(input)} {
+			for _, form := range [][]rune{lower, unleet} {
+				if start := strings.Index(string(form), candidate); start >= 0 {
+					start = len([]rune(string(form)[:start]))
+					end := start + len([]rune(candidate))
+					matches = append(matches, passwordMatch{start, end, 1 + variantBits(runes[start:end], lower[start:end], unleet[start:end])})
+					strength.SimilarToUsername = true
+				}
+			}
+		}
+	}
+
+	// Longer matches replace the bits of the characters they cover
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].end-matches[i].start > matches[j].end-matches[j].start
+	})
+	covered := make([]bool, len(runes))
+	for _, m := range matches {
+		if overlaps(covered, m.start, m.end) {
+			continue
+		}
+		for i := m.start; i < m.end; i++ {
+			covered[i] = true
+			bits[i] = 0
+		}
+		bits[m.start] = m.bits
+	}
+
+	for _, b := range bits {
+		strength.Bits += b
+	}
+	for _, threshold := range passwordScoreBits {
+		if strength.Bits >= threshold {
+			strength.Score++
+		}
+	}
+	return strength
+}
+
+// commonPasswords returns the weak password list loaded from
+// Config.WeakPasswordsFile, or the bundled list
+func (v *Validator) commonPasswords() map[string]bool {
+	if v.weakPasswords != nil {
+		return v.weakPasswords
+	}
+	return commonPasswords()
+}
+
+// characterPool is the number of characters an attacker must try per
+// position given the classes password uses
+func characterPool(password []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+		}
+	}
+	return pool
+}
+
+// continuesPattern reports whether c extends a repeat ("aaa"), an alphabet
+// or digit sequence ("abc", "321") or a keyboard run ("qwe") started by a, b
+func continuesPattern(a, b, c rune) bool {
+	if a == b && b == c {
+		return true
+	}
+	if step := b - a; (step == 1 || step == -1) && c-b == step {
+		return true
+	}
+	for _, row := range keyboardRows {
+		i, j, k := strings.IndexRune(row, a), strings.IndexRune(row, b), strings.IndexRune(row, c)
+		if i >= 0 && j >= 0 && k >= 0 && (j-i == 1 || j-i == -1) && k-j == j-i {
+			return true
+		}
+	}
+	return false
+}
+
+// variantBits is the extra guessing a matched word needs for its
+// capitalization and leetspeak
+func variantBits(original, lower, unleet []rune) float64 {
+	bits := 0.0
+	if string(original) != string(lower) {
+		bits++
+	}
+	if string(lower) != string(unleet) {
+		bits++
+	}
+	return bits
+}
+
+func overlaps(covered []bool, start, end int) bool {
+	for i := start; i < end; i++ {
+		if covered[i] {
+			return true
+		}
+	}
+	return false
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
@@ -0,0 +1,103 @@
+package validator
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimatePasswordStrengthScores(t *testing.T) {
+	tests := []struct {
+		password string
+		score    int
+	}{
+		{"password", PasswordScoreTooGuessable},
+		{"qwertyuiop", PasswordScoreTooGuessable},
+		{"P@ssw0rd1", PasswordScoreVeryGuessable},
+		{"aaaaaaaaaa", PasswordScoreVeryGuessable},
+		{"abcdefgh12", PasswordScoreGuessable},
+		{"mypassword99", PasswordScoreSafe},
+		{"zq8r7mvp", PasswordScoreVeryUnguessable},
+		{"correct horse battery", PasswordScoreVeryUnguessable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			assert.Equal(t, tt.score, EstimatePasswordStrength(tt.password).Score)
+		})
+	}
+}
+
+func TestEstimatePasswordStrengthBoundaries(t *testing.T) {
+	// A pool of 10 digits costs log2(10) ≈ 3.32 bits per character, so the
+	// thresholds of 10, 20, 27 and 34 bits fall between these lengths
+	tests := []struct {
+		password string
+		score    int
+	}{
+		{"", PasswordScoreTooGuessable},
+		{"915", PasswordScoreTooGuessable},
+		{"9150", PasswordScoreVeryGuessable},
+		{"915082", PasswordScoreVeryGuessable},
+		{"9150827", PasswordScoreGuessable},
+		{"91508274", PasswordScoreGuessable},
+		{"915082746", PasswordScoreSafe},
+		{"9150827460", PasswordScoreSafe},
+		{"91508274603", PasswordScoreVeryUnguessable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			assert.Equal(t, tt.score, EstimatePasswordStrength(tt.password).Score)
+		})
+	}
+}
+
+func TestEstimatePasswordStrengthUserInputs(t *testing.T) {
+	for _, password := range []string{"marigold2024", "dlogiram2024", "M4r1g0ld2024"} {
+		strength := EstimatePasswordStrength(password, "marigold")
+		assert.True(t, strength.SimilarToUsername, password)
+		assert.Less(t, strength.Score, DefaultMinPasswordScore, password)
+
+		alone := EstimatePasswordStrength(password)
+		assert.False(t, alone.SimilarToUsername, password)
+		assert.Greater(t, alone.Bits, strength.Bits, password)
+	}
+
+	// Inputs shorter than a username can be aren't matched
+	assert.False(t, EstimatePasswordStrength("zq8r7mvp", "zq").SimilarToUsername)
+}
+
+func TestValidateUserPassword(t *testing.T) {
+	v, err := New(Config{})
+	require.NoError(t, err)
+
+	code := func(err error) string {
+		var validationErr ValidationError
+		require.True(t, errors.As(err, &validationErr), "%v", err)
+		return validationErr.Code
+	}
+
+	assert.Equal(t, PasswordReasonTooShort, code(v.ValidateUserPassword("zq8r7mv")))
+	assert.Equal(t, PasswordReasonCommon, code(v.ValidateUserPassword("Password1")), "listed passwords are refused whatever their score")
+	assert.Equal(t, PasswordReasonCommon, code(v.ValidateUserPassword("P@ssw0rd12")))
+	assert.Equal(t, PasswordReasonSimilarToUsername, code(v.ValidateUserPassword("marigold2024", "marigold")))
+	assert.Equal(t, PasswordReasonTooGuessable, code(v.ValidateUserPassword("abcdefgh12")))
+	assert.NoError(t, v.ValidateUserPassword("marigold2024"))
+	assert.NoError(t, v.ValidateUserPassword("correct horse battery", "marigold"))
+
+	// The minimum score is configurable
+	lenient, err := New(Config{MinPasswordScore: PasswordScoreGuessable})
+	require.NoError(t, err)
+	assert.NoError(t, lenient.ValidateUserPassword("abcdefgh12"))
+	_, err = New(Config{MinPasswordScore: MaxPasswordScore + 1})
+	assert.Error(t, err)
+
+	// Registration checks the username and the email's local part
+	result := v.ValidateRegistration("marigold", "gardener@example.com", "marigold2024")
+	require.False(t, result.Valid)
+	assert.Equal(t, PasswordReasonSimilarToUsername, result.Errors[0].Code)
+	result = v.ValidateRegistration("someone", "marigold@example.com", "marigold2024")
+	require.False(t, result.Valid)
+	assert.Equal(t, PasswordReasonSimilarToUsername, result.Errors[0].Code)
+}
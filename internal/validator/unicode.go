@@ -0,0 +1,124 @@
+package validator
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// invisibleFillers are letters that render as blank space, which Cf doesn't cover
+var invisibleFillers = map[rune]bool{
+	'\u115F': true, '\u1160': true, '\u3164': true, '\uFFA0': true, // Hangul fillers
+	'\u2800': true, // Braille blank
+}
+
+// messageStrippedRunes are removed from message content: bidi overrides,
+// embeddings and isolates that reorder the text around them, and zero-width
+// spaces. Joiners and the LRM/RLM marks are kept since Arabic, Persian, Indic
+// scripts and emoji sequences rely on them.
+var messageStrippedRunes = map[rune]bool{
+	'\u202A': true, '\u202B': true, '\u202C': true, '\u202D': true, '\u202E': true,
+	'\u2066': true, '\u2067': true, '\u2068': true, '\u2069': true,
+	'\u200B': true, '\u2060': true, '\uFEFF': true,
+}
+
+// confusables maps lowercase letters from other scripts to the Latin letters
+// they are drawn like; NFKC already folds fullwidth and styled Latin letters
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k',
+	'м': 'm', 'н': 'h', 'о': 'o', 'п': 'n', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x',
+	'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l', 'ү': 'y', 'ɡ': 'g',
+	// Greek
+	'α': 'a', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't',
+	'υ': 'u', 'χ': 'x', 'γ': 'y', 'ϲ': 'c', 'ϳ': 'j',
+	// Armenian
+	'օ': 'o', 'ս': 'u', 'հ': 'h', 'ո': 'n', 'ց': 'g', 'զ': 'q',
+	// Latin look-alikes
+	'ı': 'i', 'ɑ': 'a', 'ɩ': 'i', 'ʋ': 'u', 'ℓ': 'l',
+}
+
+// scriptGroups are the scripts checked for mixing; a name's letters must all
+// come from one group. The CJK group holds scripts that are written together.
+var scriptGroups = []struct {
+	name    string
+	scripts []*unicode.RangeTable
+}{
+	{"Latin", []*unicode.RangeTable{unicode.Latin}},
+	{"Cyrillic", []*unicode.RangeTable{unicode.Cyrillic}},
+	{"Greek", []*unicode.RangeTable{unicode.Greek}},
+	{"Armenian", []*unicode.RangeTable{unicode.Armenian}},
+	{"Arabic", []*unicode.RangeTable{unicode.Arabic}},
+	{"Hebrew", []*unicode.RangeTable{unicode.Hebrew}},
+	{"CJK", []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Bopomofo}},
+}
+
+// StripInvisible removes format characters, such as zero-width joiners and
+// bidi controls, and blank filler letters from s
+func StripInvisible(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Cf, r) || invisibleFillers[r] {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// NormalizeName puts a username or room name in canonical form: invisible
+// characters removed, NFKC normalized and trimmed. NFKC folds compatibility
+// forms such as fullwidth or mathematical letters into plain ones.
+func NormalizeName(name string) string {
+	return strings.TrimSpace(norm.NFKC.String(StripInvisible(name)))
+}
+
+// Skeleton returns the form two names share when they look alike: normalized,
+// lowercased and with confusable letters replaced by their Latin look-alike.
+// The skeleton of an ASCII name is the name in lowercase.
+func Skeleton(name string) string {
+	return strings.Map(func(r rune) rune {
+		if latin, ok := confusables[r]; ok {
+			return latin
+		}
+		return r
+	}, strings.ToLower(NormalizeName(name)))
+}
+
+// Confusable reports whether two names look alike
+func Confusable(a, b string) bool {
+	return Skeleton(a) == Skeleton(b)
+}
+
+// mixesScripts reports whether the letters of name come from more than one
+// script group, such as Latin with Cyrillic. Latin may be mixed with CJK,
+// which commonly includes romanized words.
+func mixesScripts(name string) bool {
+	seen := make(map[string]bool)
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for _, group := range scriptGroups {
+			if unicode.In(r, group.scripts...) {
+				seen[group.name] = true
+				break
+			}
+		}
+	}
+	if seen["Latin"] && seen["CJK"] {
+		delete(seen, "Latin")
+	}
+	return len(seen) > 1
+}
+
+// CleanText prepares message content: NFC normalized, with bidi overrides and
+// zero-width spaces removed. Unlike NormalizeName it keeps compatibility
+// forms and joiners, so text in any script reads as the sender wrote it.
+func CleanText(text string) string {
+	return norm.NFC.String(strings.Map(func(r rune) rune {
+		if messageStrippedRunes[r] {
+			return -1
+		}
+		return r
+	}, text))
+}
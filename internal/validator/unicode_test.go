@@ -0,0 +1,141 @@
+package validator
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUsernameUnicode(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		want     string // part of the error, or "" when valid
+	}{
+		{"ascii", "alice_01", ""},
+		{"chinese", "李小龙", ""},
+		{"japanese", "山田たろう", ""},
+		{"korean", "김민준", ""},
+		{"arabic", "محمد", ""},
+		{"hebrew", "דוד_כהן", ""},
+		{"cyrillic", "наташа", ""},
+		{"greek", "Σωκράτης", ""},
+		{"accented latin", "José-María", ""},
+		{"latin with han", "dev李", ""},
+		{"cyrillic a in admin", "аdmin", "different scripts"},
+		{"greek o in root", "rοot", "different scripts"},
+		{"all-cyrillic system", "ѕуѕтем", "reserved"},
+		{"latin mixed with cyrillic", "pаypal", "different scripts"},
+		{"latin mixed with greek", "alicε", "different scripts"},
+		{"zero width space", "ali\u200Bce", "invisible"},
+		{"zero width joiner", "ali\u200Dce", "invisible"},
+		{"right-to-left override", "\u202Eecila", "invisible"},
+		{"hangul filler", "bob\u3164", "invisible"},
+		{"fullwidth letters", "ａｌｉｃｅ", "non-standard"},
+		{"decomposed accent", "Jose\u0301", "non-standard"},
+		{"too short in runes", "李龙", "at least 3"},
+		{"punctuation", "bob!", "can only contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if tt.want == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRoomNameUnicode(t *testing.T) {
+	tests := []struct {
+		name string
+		room string
+		want string
+	}{
+		{"ascii", "general chat", ""},
+		{"chinese", "闲聊", ""},
+		{"arabic", "غرفة عامة", ""},
+		{"japanese", "雑談 ルーム", ""},
+		{"cyrillic look-alike of lobby", "lоbby", "different scripts"},
+		{"bidi isolate", "\u2067lounge", "invisible"},
+		{"fullwidth digits", "room１", "non-standard"},
+		{"symbols", "room$", "can only contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRoomName(tt.room)
+			if tt.want == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestSkeleton(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		confusing bool
+	}{
+		{"admin", "ADMIN", true},
+		{"admin", "аdmin", true},         // Cyrillic а
+		{"paypal", "раураl", true},       // Cyrillic р, а, у
+		{"apple", "аррӏе", true},         // all Cyrillic
+		{"scope", "ѕсоре", true},         // all Cyrillic
+		{"hello", "һеllо", true},         // Cyrillic һ, е, о
+		{"root", "rοοt", true},           // Greek ο
+		{"alice", "ａｌｉｃｅ", true},         // fullwidth
+		{"alice", "al\u200Bice", true},   // zero width space
+		{"lounge", "\u202Elounge", true}, // right-to-left override
+		{"李小龙", "李小龙", true},
+		{"alice", "alicia", false},
+		{"محمد", "احمد", false},
+		{"李小龙", "李小虎", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.confusing, Confusable(tt.a, tt.b), "%q and %q", tt.a, tt.b)
+	}
+	assert.Equal(t, "bob_smith", Skeleton("  Bob_Smith "))
+}
+
+func TestCleanText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "hello", "hello"},
+		{"arabic", "مرحبا بالعالم", "مرحبا بالعالم"},
+		{"persian joiner", "می\u200Cخواهم", "می\u200Cخواهم"},
+		{"chinese", "你好，世界！", "你好，世界！"},
+		{"fullwidth kept", "ｈｅｌｌｏ", "ｈｅｌｌｏ"},
+		{"emoji sequence", "👩\u200D💻", "👩\u200D💻"},
+		{"right-to-left mark", "abc\u200F", "abc\u200F"},
+		{"right-to-left override", "invoice_\u202Efdp.exe", "invoice_fdp.exe"},
+		{"isolates", "\u2066x\u2069", "x"},
+		{"zero width space", "spl\u200Bit", "split"},
+		{"composed", "Jose\u0301", "José"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CleanText(tt.in))
+		})
+	}
+}
+
+func TestIsReservedRoomNameLookAlike(t *testing.T) {
+	previous := GetReservedRoomNames()
+	SetReservedRoomNames([]string{"admin"})
+	t.Cleanup(func() { SetReservedRoomNames(previous) })
+
+	assert.True(t, IsReservedRoomName(" Admin "))
+	assert.True(t, IsReservedRoomName("аdmin"))
+	assert.False(t, IsReservedRoomName("admins"))
+}
@@ -1,12 +1,15 @@
 package validator
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"io"
 	"regexp"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -15,17 +18,21 @@ var (
 	// Email regex pattern
 	emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
 
-	// Username regex - alphanumeric, underscores, hyphens, 3-30 characters
-	usernameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,30}$`)
+	// Username regex - letters and numbers in any script, underscores, hyphens
+	usernameRegex = regexp.MustCompile(`^[\p{L}\p{M}\p{N}_-]+$`)
 
-	// Password requirements
-	minPasswordLength = 8
+	// Room name regex - as usernames, plus spaces
+	roomNameRegex = regexp.MustCompile(`^[\p{L}\p{M}\p{N}\s_-]+$`)
 )
 
+// settingsMu guards the sanitizer and profanity settings configured at startup
+var settingsMu sync.RWMutex
+
 // ValidationError represents a validation error
 type ValidationError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"` // Why, for errors a client may explain, such as PasswordReasonCommon
 }
 
 func (e ValidationError) Error() string {
@@ -57,30 +64,45 @@ func ValidateEmail(email string) error {
 	return nil
 }
 
-// ValidateUsername validates username format
+// ValidateUsername validates username format with the default Validator
 func ValidateUsername(username string) error {
+	return Default().ValidateUsername(username)
+}
+
+// ValidateUsername validates username format
+func (v *Validator) ValidateUsername(username string) error {
 	username = strings.TrimSpace(username)
 
 	if username == "" {
 		return ValidationError{Field: "username", Message: "username is required"}
 	}
 
-	if len(username) < 3 {
-		return ValidationError{Field: "username", Message: "username must be at least 3 characters"}
+	// Callers normalize with NormalizeName first; what's left would let two
+	// different names look the same
+	if NormalizeName(username) != username {
+		return ValidationError{Field: "username", Message: "username contains invisible or non-standard characters"}
+	}
+
+	if utf8.RuneCountInString(username) < v.cfg.MinUsernameLength {
+		return ValidationError{Field: "username", Message: fmt.Sprintf("username must be at least %d characters", v.cfg.MinUsernameLength)}
 	}
 
-	if len(username) > 30 {
-		return ValidationError{Field: "username", Message: "username must be less than 30 characters"}
+	if utf8.RuneCountInString(username) > v.cfg.MaxUsernameLength {
+		return ValidationError{Field: "username", Message: fmt.Sprintf("username must be less than %d characters", v.cfg.MaxUsernameLength)}
 	}
 
 	if !usernameRegex.MatchString(username) {
 		return ValidationError{Field: "username", Message: "username can only contain letters, numbers, underscores, and hyphens"}
 	}
 
-	// Check for reserved names
-	reservedNames := []string{"admin", "root", "system", "api", "www", "mail", "support", "info", "about"}
-	for _, reserved := range reservedNames {
-		if strings.ToLower(username) == reserved {
+	if mixesScripts(username) {
+		return ValidationError{Field: "username", Message: "username cannot mix letters from different scripts"}
+	}
+
+	// Check for reserved names, including look-alikes such as Cyrillic "аdmin"
+	skeleton := Skeleton(username)
+	for _, reserved := range v.cfg.ReservedUsernames {
+		if skeleton == Skeleton(reserved) {
 			return ValidationError{Field: "username", Message: "username is reserved"}
 		}
 	}
@@ -88,56 +110,125 @@ func ValidateUsername(username string) error {
 	return nil
 }
 
-// ValidatePassword validates password strength
+// ValidatePassword validates password strength with the default Validator
 func ValidatePassword(password string) error {
+	return Default().ValidatePassword(password)
+}
+
+// ValidatePassword validates password strength
+func (v *Validator) ValidatePassword(password string) error {
+	return v.ValidateUserPassword(password)
+}
+
+// ValidateUserPassword validates password strength with the default
+// Validator, refusing passwords built from userInputs
+func ValidateUserPassword(password string, userInputs ...string) error {
+	return Default().ValidateUserPassword(password, userInputs...)
+}
+
+// ValidateUserPassword validates password length and strength. userInputs,
+// such as the username and email, count as easily guessed parts of it.
+func (v *Validator) ValidateUserPassword(password string, userInputs ...string) error {
 	if password == "" {
 		return ValidationError{Field: "password", Message: "password is required"}
 	}
 
-	if len(password) < minPasswordLength {
-		return ValidationError{Field: "password", Message: fmt.Sprintf("password must be at least %d characters", minPasswordLength)}
+	if len(password) < v.cfg.MinPasswordLength {
+		return ValidationError{Field: "password", Message: fmt.Sprintf("password must be at least %d characters", v.cfg.MinPasswordLength), Code: PasswordReasonTooShort}
 	}
 
 	if len(password) > 128 {
 		return ValidationError{Field: "password", Message: "password must be less than 128 characters"}
 	}
 
-	// Check for common weak passwords
-	weakPasswords := []string{"password", "123456", "qwerty", "abc123", "password123", "admin123"}
-	for _, weak := range weakPasswords {
-		if strings.ToLower(password) == weak {
-			return ValidationError{Field: "password", Message: "password is too common"}
-		}
+	// A listed password is refused whatever its score
+	if v.commonPasswords()[strings.ToLower(password)] {
+		return ValidationError{Field: "password", Message: "password is too common", Code: PasswordReasonCommon}
 	}
 
-	return nil
+	strength := v.EstimatePasswordStrength(password, userInputs...)
+	switch {
+	case strength.Score >= v.cfg.MinPasswordScore:
+		return nil
+	case strength.SimilarToUsername:
+		return ValidationError{Field: "password", Message: "password is too similar to your username or email", Code: PasswordReasonSimilarToUsername}
+	case strength.Common:
+		return ValidationError{Field: "password", Message: "password is too close to a common password", Code: PasswordReasonCommon}
+	}
+	return ValidationError{Field: "password", Message: "password is too easy to guess; try a longer one or a few unrelated words", Code: PasswordReasonTooGuessable}
 }
 
-// ValidateRoomName validates room name
+// ValidateRoomName validates room name with the default Validator
 func ValidateRoomName(name string) error {
+	return Default().ValidateRoomName(name)
+}
+
+// ValidateRoomName validates room name
+func (v *Validator) ValidateRoomName(name string) error {
 	name = strings.TrimSpace(name)
 
 	if name == "" {
 		return ValidationError{Field: "name", Message: "room name is required"}
 	}
 
-	if len(name) < 2 {
+	if NormalizeName(name) != name {
+		return ValidationError{Field: "name", Message: "room name contains invisible or non-standard characters"}
+	}
+
+	if utf8.RuneCountInString(name) < 2 {
 		return ValidationError{Field: "name", Message: "room name must be at least 2 characters"}
 	}
 
-	if len(name) > 50 {
-		return ValidationError{Field: "name", Message: "room name must be less than 50 characters"}
+	if utf8.RuneCountInString(name) > v.cfg.MaxRoomNameLength {
+		return ValidationError{Field: "name", Message: fmt.Sprintf("room name must be less than %d characters", v.cfg.MaxRoomNameLength)}
 	}
 
 	// Allow letters, numbers, spaces, hyphens, underscores
-	roomNameRegex := regexp.MustCompile(`^[a-zA-Z0-9\s_-]{2,50}$`)
 	if !roomNameRegex.MatchString(name) {
 		return ValidationError{Field: "name", Message: "room name can only contain letters, numbers, spaces, hyphens, and underscores"}
 	}
 
+	if mixesScripts(name) {
+		return ValidationError{Field: "name", Message: "room name cannot mix letters from different scripts"}
+	}
+
+	if v.IsReservedRoomName(name) {
+		return ValidationError{Field: "name", Message: "room name is reserved"}
+	}
+
 	return nil
 }
 
+// DefaultReservedRoomNames are protected when RESERVED_ROOM_NAMES is unset
+var DefaultReservedRoomNames = []string{"default", "admin", "system", "server", "moderator", "root"}
+
+// GetReservedRoomNames returns the default Validator's reserved room names
+func GetReservedRoomNames() []string {
+	return Default().cfg.ReservedRoomNames
+}
+
+// SetReservedRoomNames replaces the default Validator's reserved room names
+func SetReservedRoomNames(names []string) {
+	updateDefault(func(cfg *Config) { cfg.ReservedRoomNames = names })
+}
+
+// IsReservedRoomName reports whether a room name is reserved by the default
+// Validator
+func IsReservedRoomName(name string) bool {
+	return Default().IsReservedRoomName(name)
+}
+
+// IsReservedRoomName reports whether a room name is reserved or looks like
+// one, ignoring case and surrounding spaces
+func (v *Validator) IsReservedRoomName(name string) bool {
+	for _, reserved := range v.cfg.ReservedRoomNames {
+		if Confusable(name, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
 // ValidateRoomPassword validates room password
 func ValidateRoomPassword(password string) error {
 	if password == "" {
@@ -155,23 +246,22 @@ func ValidateRoomPassword(password string) error {
 	return nil
 }
 
-// SanitizeInput removes potentially dangerous characters
+// SanitizeInput cleans plain text input with StrictPolicy and trims it
 func SanitizeInput(input string) string {
-	// Remove HTML tags and scripts
-	htmlTagRegex := regexp.MustCompile(`<[^>]*>`)
-	sanitized := htmlTagRegex.ReplaceAllString(input, "")
-
-	// Trim whitespace
-	sanitized = strings.TrimSpace(sanitized)
+	return strings.TrimSpace(Sanitize(ContentTypePlain, input))
+}
 
-	return sanitized
+// ValidateRegistration validates user registration data with the default
+// Validator
+func ValidateRegistration(username, email, password string) *ValidationResult {
+	return Default().ValidateRegistration(username, email, password)
 }
 
 // ValidateRegistration validates user registration data
-func ValidateRegistration(username, email, password string) *ValidationResult {
+func (v *Validator) ValidateRegistration(username, email, password string) *ValidationResult {
 	result := &ValidationResult{Valid: true}
 
-	if err := ValidateUsername(username); err != nil {
+	if err := v.ValidateUsername(username); err != nil {
 		result.Errors = append(result.Errors, err.(ValidationError))
 		result.Valid = false
 	}
@@ -181,7 +271,7 @@ func ValidateRegistration(username, email, password string) *ValidationResult {
 		result.Valid = false
 	}
 
-	if err := ValidatePassword(password); err != nil {
+	if err := v.ValidateUserPassword(password, username, email); err != nil {
 		result.Errors = append(result.Errors, err.(ValidationError))
 		result.Valid = false
 	}
@@ -189,11 +279,16 @@ func ValidateRegistration(username, email, password string) *ValidationResult {
 	return result
 }
 
-// ValidateRoomCreation validates room creation data
+// ValidateRoomCreation validates room creation data with the default Validator
 func ValidateRoomCreation(name, password string, isPrivate bool) *ValidationResult {
+	return Default().ValidateRoomCreation(name, password, isPrivate)
+}
+
+// ValidateRoomCreation validates room creation data
+func (v *Validator) ValidateRoomCreation(name, password string, isPrivate bool) *ValidationResult {
 	result := &ValidationResult{Valid: true}
 
-	if err := ValidateRoomName(name); err != nil {
+	if err := v.ValidateRoomName(name); err != nil {
 		result.Errors = append(result.Errors, err.(ValidationError))
 		result.Valid = false
 	}
@@ -255,19 +350,132 @@ func ValidateMessageSize(size int, maxSize int) error {
 	return nil
 }
 
-// GetMaxMessageSize reads message size limit from environment or returns default
+// GetMaxMessageSize returns the default Validator's message size limit.
+// Hot paths should hold a *Validator and call its MaxMessageSize instead.
 func GetMaxMessageSize() int {
-	if value := os.Getenv("WS_MAX_MESSAGE_SIZE"); value != "" {
-		if size, err := strconv.Atoi(value); err == nil && size > 0 {
-			if size > MaxMessageSize {
-				log.Printf("WS_MAX_MESSAGE_SIZE (%d) exceeds maximum, using %d", size, MaxMessageSize)
-				return MaxMessageSize
+	return Default().cfg.MaxMessageSize
+}
+
+// SetMaxMessageSize replaces the default Validator's message size limit,
+// capped at MaxMessageSize
+func SetMaxMessageSize(size int) {
+	updateDefault(func(cfg *Config) { cfg.MaxMessageSize = min(size, MaxMessageSize) })
+}
+
+// JSON structure limits for client messages
+const (
+	MaxJSONDepthDefault       = 10
+	MaxJSONKeyLengthDefault   = 64
+	MaxJSONArrayLengthDefault = 1000
+)
+
+// jsonContainer is an object or array being walked by ValidateJSONPayload
+type jsonContainer struct {
+	object    bool
+	expectKey bool // The next token in an object is a key
+	elements  int  // Array elements seen so far
+}
+
+// ValidateJSONPayload walks a JSON document without decoding it and rejects
+// nesting deeper than maxDepth, object keys longer than maxKeyLen characters
+// and arrays with more than maxArrayLen elements. Malformed JSON returns the
+// decoder's error.
+func ValidateJSONPayload(data []byte, maxDepth, maxKeyLen, maxArrayLen int) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var stack []*jsonContainer
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(stack) > 0 {
+			return io.ErrUnexpectedEOF
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var parent *jsonContainer
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+
+		if delim, ok := tok.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+
+		if parent != nil && parent.object && parent.expectKey {
+			key, _ := tok.(string)
+			if utf8.RuneCountInString(key) > maxKeyLen {
+				return ValidationError{Field: "message", Message: fmt.Sprintf("JSON keys must be at most %d characters", maxKeyLen)}
 			}
-			return size
+			parent.expectKey = false
+			continue
 		}
-		log.Printf("Invalid WS_MAX_MESSAGE_SIZE, using default: %d", MaxMessageSizeDefault)
+
+		// tok starts a value
+		if parent != nil {
+			if parent.object {
+				parent.expectKey = true
+			} else {
+				parent.elements++
+				if parent.elements > maxArrayLen {
+					return ValidationError{Field: "message", Message: fmt.Sprintf("JSON arrays must have at most %d elements", maxArrayLen)}
+				}
+			}
+		}
+		if delim, ok := tok.(json.Delim); ok {
+			if len(stack) == maxDepth {
+				return ValidationError{Field: "message", Message: fmt.Sprintf("JSON must be nested at most %d levels deep", maxDepth)}
+			}
+			stack = append(stack, &jsonContainer{object: delim == '{', expectKey: delim == '{'})
+		}
+	}
+}
+
+// Poll limits
+const (
+	MaxPollQuestionLength = 200
+	MinPollOptions        = 2
+	MaxPollOptions        = 10
+	MaxPollOptionLength   = 100
+	MaxPollDuration       = 24 * time.Hour
+)
+
+// ValidatePoll checks a poll's question, options and duration
+func ValidatePoll(question string, options []string, duration time.Duration) error {
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return ValidationError{Field: "question", Message: "poll question is required"}
 	}
-	return MaxMessageSizeDefault
+	if utf8.RuneCountInString(question) > MaxPollQuestionLength {
+		return ValidationError{Field: "question", Message: fmt.Sprintf("poll question must be at most %d characters", MaxPollQuestionLength)}
+	}
+
+	if len(options) < MinPollOptions || len(options) > MaxPollOptions {
+		return ValidationError{Field: "options", Message: fmt.Sprintf("poll must have between %d and %d options", MinPollOptions, MaxPollOptions)}
+	}
+	for _, option := range options {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			return ValidationError{Field: "options", Message: "poll options cannot be empty"}
+		}
+		if utf8.RuneCountInString(option) > MaxPollOptionLength {
+			return ValidationError{Field: "options", Message: fmt.Sprintf("poll options must be at most %d characters", MaxPollOptionLength)}
+		}
+	}
+
+	if duration <= 0 {
+		return ValidationError{Field: "duration", Message: "poll duration is required"}
+	}
+	if duration > MaxPollDuration {
+		return ValidationError{Field: "duration", Message: fmt.Sprintf("poll duration must be at most %s", MaxPollDuration)}
+	}
+
+	return nil
 }
 
 // FormatValidationErrors converts validation errors to a user-friendly message
@@ -0,0 +1,63 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validateJSONDefaults runs ValidateJSONPayload with the WebSocket limits
+func validateJSONDefaults(data string) error {
+	return ValidateJSONPayload([]byte(data), MaxJSONDepthDefault, MaxJSONKeyLengthDefault, MaxJSONArrayLengthDefault)
+}
+
+// nested returns depth objects nested inside each other
+func nested(depth int) string {
+	return strings.Repeat(`{"a":`, depth-1) + `{}` + strings.Repeat(`}`, depth-1)
+}
+
+func TestValidateJSONPayloadAcceptsMessages(t *testing.T) {
+	for _, msg := range []string{
+		`{"type":"room_message","data":{"content":"hello","reply_to":""}}`,
+		`{"type":"create_poll","data":{"question":"Lunch?","options":["yes","no"],"duration":60}}`,
+		`[1, "two", null, true, {"three": [3.0]}]`,
+		`"just a string"`,
+		nested(MaxJSONDepthDefault),
+		`{"` + strings.Repeat("k", MaxJSONKeyLengthDefault) + `":1}`,
+		`[` + strings.Repeat(`0,`, MaxJSONArrayLengthDefault-1) + `0]`,
+	} {
+		assert.NoError(t, validateJSONDefaults(msg), msg)
+	}
+}
+
+func TestValidateJSONPayloadRejectsLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"depth 11", nested(MaxJSONDepthDefault + 1), "nested at most 10 levels"},
+		{"deep arrays", strings.Repeat(`[`, 11) + strings.Repeat(`]`, 11), "nested at most 10 levels"},
+		{"65 character key", `{"` + strings.Repeat("k", 65) + `":1}`, "keys must be at most 64 characters"},
+		{"long nested key", `{"data":{"` + strings.Repeat("k", 65) + `":"v"}}`, "keys must be at most 64 characters"},
+		{"1001 elements", `[` + strings.Repeat(`0,`, 1000) + `0]`, "arrays must have at most 1000 elements"},
+		{"1001 objects", `{"data":[` + strings.Repeat(`{},`, 1000) + `{}]}`, "arrays must have at most 1000 elements"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateJSONDefaults(tt.data)
+			var validationErr ValidationError
+			require.ErrorAs(t, err, &validationErr)
+			assert.Equal(t, "message", validationErr.Field)
+			assert.Contains(t, validationErr.Message, tt.want)
+		})
+	}
+}
+
+func TestValidateJSONPayloadMalformed(t *testing.T) {
+	err := validateJSONDefaults(`{"type":`)
+	require.Error(t, err)
+	assert.NotErrorAs(t, err, new(ValidationError))
+}
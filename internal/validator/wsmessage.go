@@ -0,0 +1,129 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"websocket-demo/internal/types"
+)
+
+// Limits on WebSocket message fields referring to existing rooms, users and
+// messages; names of new rooms are checked in full when the room is created
+const (
+	MaxNameFieldLength     = 50  // Room names and usernames, the width of their columns
+	MaxIDFieldLength       = 64  // Message, poll, session and user IDs
+	MaxPasswordFieldLength = 128 // Room passwords given to join or change them
+)
+
+// ValidateWebSocketMessage checks a client message with the default Validator
+func ValidateWebSocketMessage(wsMsg *types.WebSocketMessage) *ValidationResult {
+	return Default().ValidateWebSocketMessage(wsMsg)
+}
+
+// ValidateWebSocketMessage checks that a client message has the fields its
+// type needs and that none is too long, before it is dispatched. Unknown
+// types pass, for the dispatcher to report.
+func (v *Validator) ValidateWebSocketMessage(wsMsg *types.WebSocketMessage) *ValidationResult {
+	c := &fieldChecker{}
+	d := &wsMsg.Data
+
+	switch wsMsg.Type {
+	case "":
+		c.fail("type", "message type is required")
+	case types.MsgTypeChat, types.MsgTypeRoomMessage:
+		c.required("content", d.Content)
+	case types.MsgTypeCreateRoom, types.MsgTypeJoinRoom, types.MsgTypeDeleteRoom, types.MsgTypeRestoreRoom,
+		types.MsgTypeClearRoomAlert, types.MsgTypeGetRoomPolicy, types.MsgTypeListMembers,
+		types.MsgTypeExportRoom, types.MsgTypeGetMessages, types.MsgTypeGetUser:
+		c.required("name", d.Name)
+	case types.MsgTypeSetRoomSettings:
+		c.required("name", d.Name)
+		if d.SuppressJoinLeave == nil && d.LinkPolicy == nil {
+			c.fail("suppress_join_leave", "no room settings provided")
+		}
+	case types.MsgTypeRoomAlert:
+		c.required("name", d.Name)
+		c.required("content", d.Content)
+	case types.MsgTypeChangeRoomPassword:
+		c.required("name", d.Name)
+		c.required("password", d.Password)
+	case types.MsgTypePollCreate:
+		if err := ValidatePoll(d.Question, d.Options, time.Duration(d.Duration)*time.Second); err != nil {
+			c.errs = append(c.errs, err.(ValidationError))
+		}
+	case types.MsgTypePollVote:
+		c.required("poll_id", d.PollID)
+		if d.OptionIndex == nil {
+			c.fail("option_index", "no option selected")
+		} else if *d.OptionIndex < 0 {
+			c.fail("option_index", "option_index cannot be negative")
+		}
+	case types.MsgTypePollResults:
+		c.required("poll_id", d.PollID)
+	case types.MsgTypeTerminateSession:
+		c.required("session_id", d.SessionID)
+	case types.MsgTypeMoveUser, types.MsgTypeSendInvite:
+		c.required("name", d.Name)
+		c.required("to", d.To)
+	case types.MsgTypeEditMessage:
+		c.required("message_id", d.MessageID)
+		c.required("content", d.Content)
+	case types.MsgTypeDeleteMessage, types.MsgTypeThreadMessages:
+		c.required("message_id", d.MessageID)
+	case types.MsgTypeDirectMessage:
+		if strings.TrimSpace(d.To) == "" && strings.TrimSpace(d.ToUsername) == "" {
+			c.fail("to", "to or to_username is required")
+		}
+		c.required("content", d.Content)
+	case types.MsgTypeSearchUsers:
+		c.required("query", d.Query)
+	}
+
+	// Whatever the type, no field may be longer than anything it could name
+	c.maxLength("name", d.Name, MaxNameFieldLength)
+	c.maxLength("to_username", d.ToUsername, MaxNameFieldLength)
+	c.maxLength("query", d.Query, MaxNameFieldLength)
+	c.maxLength("password", d.Password, MaxPasswordFieldLength)
+	c.maxLength("old_password", d.OldPassword, MaxPasswordFieldLength)
+	c.maxLength("to", d.To, MaxIDFieldLength)
+	c.maxLength("reply_to", d.ReplyTo, MaxIDFieldLength)
+	c.maxLength("session_id", d.SessionID, MaxIDFieldLength)
+	c.maxLength("poll_id", d.PollID, MaxIDFieldLength)
+	c.maxLength("message_id", d.MessageID, MaxIDFieldLength)
+	if d.Limit < 0 {
+		c.fail("limit", "limit cannot be negative")
+	}
+	if d.Offset < 0 {
+		c.fail("offset", "offset cannot be negative")
+	}
+
+	return &ValidationResult{Valid: len(c.errs) == 0, Errors: c.errs}
+}
+
+// fieldChecker collects the errors found in a message's fields, one per field
+type fieldChecker struct {
+	errs []ValidationError
+}
+
+func (c *fieldChecker) fail(field, message string) {
+	for _, err := range c.errs {
+		if err.Field == field {
+			return
+		}
+	}
+	c.errs = append(c.errs, ValidationError{Field: field, Message: message})
+}
+
+func (c *fieldChecker) required(field, value string) {
+	if strings.TrimSpace(value) == "" {
+		c.fail(field, field+" is required")
+	}
+}
+
+func (c *fieldChecker) maxLength(field, value string, limit int) {
+	if utf8.RuneCountInString(value) > limit {
+		c.fail(field, fmt.Sprintf("%s must be at most %d characters", field, limit))
+	}
+}
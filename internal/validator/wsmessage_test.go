@@ -0,0 +1,61 @@
+package validator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"websocket-demo/internal/types"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateWebSocketMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		fields  []string // Fields reported, in order; none means valid
+	}{
+		{"chat", `{"type":"chat","data":{"content":"hi"}}`, nil},
+		{"blank chat", `{"type":"chat","data":{"content":"   "}}`, []string{"content"}},
+		{"missing type", `{"data":{"content":"hi"}}`, []string{"type"}},
+		{"unknown type", `{"type":"shout"}`, nil},
+		{"no fields needed", `{"type":"list_rooms"}`, nil},
+		{"leave current room", `{"type":"leave_room"}`, nil},
+		{"create room without name", `{"type":"create_room","data":{"private":true}}`, []string{"name"}},
+		{"join room", `{"type":"join_room","data":{"name":"lobby","password":"secret"}}`, nil},
+		{"settings missing", `{"type":"set_room_settings","data":{"name":"lobby"}}`, []string{"suppress_join_leave"}},
+		{"settings", `{"type":"set_room_settings","data":{"name":"lobby","suppress_join_leave":false}}`, nil},
+		{"room alert", `{"type":"room_alert","data":{"name":"lobby"}}`, []string{"content"}},
+		{"change room password", `{"type":"change_room_password","data":{"name":"vault"}}`, []string{"password"}},
+		{"poll", `{"type":"create_poll","data":{"question":"Lunch?","options":["yes","no"],"duration":60}}`, nil},
+		{"poll without options", `{"type":"create_poll","data":{"question":"Lunch?","duration":60}}`, []string{"options"}},
+		{"vote without option", `{"type":"vote_poll","data":{"poll_id":"p1"}}`, []string{"option_index"}},
+		{"vote for option 0", `{"type":"vote_poll","data":{"poll_id":"p1","option_index":0}}`, nil},
+		{"negative vote", `{"type":"vote_poll","data":{"poll_id":"p1","option_index":-1}}`, []string{"option_index"}},
+		{"invite", `{"type":"send_invite","data":{"name":"lobby"}}`, []string{"to"}},
+		{"edit message", `{"type":"edit_message"}`, []string{"message_id", "content"}},
+		{"direct message by username", `{"type":"direct_message","data":{"to_username":"bob","content":"hi"}}`, nil},
+		{"direct message without recipient", `{"type":"direct_message","data":{"content":"hi"}}`, []string{"to"}},
+		{"search", `{"type":"search_users","data":{"query":" "}}`, []string{"query"}},
+		{"negative paging", `{"type":"get_messages","data":{"name":"lobby","limit":-1,"offset":-5}}`, []string{"limit", "offset"}},
+		{"long name", `{"type":"join_room","data":{"name":"` + strings.Repeat("n", MaxNameFieldLength+1) + `"}}`, []string{"name"}},
+		{"long ID", `{"type":"delete_message","data":{"message_id":"` + strings.Repeat("1", MaxIDFieldLength+1) + `"}}`, []string{"message_id"}},
+		{"long password", `{"type":"chat","data":{"content":"hi","password":"` + strings.Repeat("p", MaxPasswordFieldLength+1) + `"}}`, []string{"password"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wsMsg types.WebSocketMessage
+			require.NoError(t, json.Unmarshal([]byte(tt.message), &wsMsg))
+
+			result := ValidateWebSocketMessage(&wsMsg)
+			var fields []string
+			for _, err := range result.Errors {
+				fields = append(fields, err.Field)
+			}
+			assert.Equal(t, tt.fields, fields)
+			assert.Equal(t, len(tt.fields) == 0, result.Valid)
+		})
+	}
+}
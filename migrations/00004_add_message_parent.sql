@@ -0,0 +1,10 @@
+-- +goose Up
+-- Add parent message reference for replies/threads
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS parent_message_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+-- Create index for looking up replies to a message
+CREATE INDEX IF NOT EXISTS idx_messages_parent_message_id ON messages(parent_message_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_messages_parent_message_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS parent_message_id;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- Pinned messages per room
+CREATE TABLE IF NOT EXISTS pinned_messages (
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    pinned_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    pinned_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, message_id)
+);
+
+-- Create index for listing a room's pins
+CREATE INDEX IF NOT EXISTS idx_pinned_messages_room_id ON pinned_messages(room_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS pinned_messages CASCADE;
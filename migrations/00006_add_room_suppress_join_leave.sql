@@ -0,0 +1,6 @@
+-- +goose Up
+-- Per-room toggle for join/leave notifications
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS suppress_join_leave BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN IF EXISTS suppress_join_leave;
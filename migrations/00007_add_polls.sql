@@ -0,0 +1,29 @@
+-- +goose Up
+-- In-room polls
+CREATE TABLE IF NOT EXISTS polls (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    creator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    question TEXT NOT NULL,
+    options TEXT[] NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    closed BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- One vote per user per poll
+CREATE TABLE IF NOT EXISTS poll_votes (
+    poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    option_index INTEGER NOT NULL,
+    voted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (poll_id, user_id)
+);
+
+-- Create indexes for room lookups and the poll closer
+CREATE INDEX IF NOT EXISTS idx_polls_room_id ON polls(room_id);
+CREATE INDEX IF NOT EXISTS idx_polls_open_ends_at ON polls(ends_at) WHERE NOT closed;
+
+-- +goose Down
+DROP TABLE IF EXISTS poll_votes CASCADE;
+DROP TABLE IF EXISTS polls CASCADE;
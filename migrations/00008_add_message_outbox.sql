@@ -0,0 +1,19 @@
+-- +goose Up
+-- Transactional outbox: a row is written with each room message and drained to NATS
+CREATE TABLE IF NOT EXISTS message_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    subject TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create index for the publisher's pending scan
+CREATE INDEX IF NOT EXISTS idx_message_outbox_pending ON message_outbox(next_attempt_at) WHERE sent_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS message_outbox CASCADE;
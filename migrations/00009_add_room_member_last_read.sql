@@ -0,0 +1,10 @@
+-- +goose Up
+-- When each member last caught up with a room, for unread counts
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS last_read_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+-- Create index for counting and previewing a room's latest messages
+CREATE INDEX IF NOT EXISTS idx_messages_room_created_at ON messages(room_id, created_at DESC);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_messages_room_created_at;
+ALTER TABLE room_members DROP COLUMN IF EXISTS last_read_at;
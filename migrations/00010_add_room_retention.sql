@@ -0,0 +1,7 @@
+-- +goose Up
+-- Per-room message retention in days; NULL follows MESSAGE_RETENTION_DAYS and
+-- rooms with a value are skipped by the global cleanup
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS retention_days INTEGER CHECK (retention_days >= 0);
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN IF EXISTS retention_days;
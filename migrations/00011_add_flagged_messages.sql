@@ -0,0 +1,18 @@
+-- +goose Up
+-- Messages delivered with PROFANITY_ACTION=flag, kept for moderator review.
+-- The sender's name is copied so anonymous senders and deleted users still show.
+CREATE TABLE IF NOT EXISTS flagged_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id UUID REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    username TEXT NOT NULL,
+    content TEXT NOT NULL,
+    matched_words TEXT[] NOT NULL,
+    flagged_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create index for listing the newest flags first
+CREATE INDEX IF NOT EXISTS idx_flagged_messages_flagged_at ON flagged_messages(flagged_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS flagged_messages CASCADE;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Peak WebSocket connections per server, sampled every minute for analytics
+CREATE TABLE IF NOT EXISTS stats_samples (
+    server_id TEXT NOT NULL,
+    sampled_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    peak_connections INTEGER NOT NULL,
+    PRIMARY KEY (server_id, sampled_at)
+);
+
+-- Create indexes for the per-day analytics queries
+CREATE INDEX IF NOT EXISTS idx_stats_samples_sampled_at ON stats_samples(sampled_at);
+CREATE INDEX IF NOT EXISTS idx_users_created_at ON users(created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_created_at;
+DROP TABLE IF EXISTS stats_samples CASCADE;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Deleted rooms keep their messages until the janitor purges them. Only live
+-- rooms need unique names, so a deleted room's name can be taken again.
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE rooms DROP CONSTRAINT IF EXISTS rooms_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rooms_name_live ON rooms(name) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_rooms_deleted_at ON rooms(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +goose Down
+DELETE FROM rooms WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_rooms_deleted_at;
+DROP INDEX IF EXISTS idx_rooms_name_live;
+ALTER TABLE rooms ADD CONSTRAINT rooms_name_key UNIQUE (name);
+ALTER TABLE rooms DROP COLUMN IF EXISTS deleted_at;
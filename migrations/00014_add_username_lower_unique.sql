@@ -0,0 +1,19 @@
+-- +goose Up
+-- Usernames are unique regardless of case. Existing accounts that differ only
+-- in case from an older one are renamed to <username>_<first 8 hex digits of
+-- their id>; the oldest account keeps its name. Run the query documented in
+-- the README before migrating to see which accounts will be renamed.
+UPDATE users u
+SET username = left(u.username, 41) || '_' || left(replace(u.id::text, '-', ''), 8),
+    updated_at = CURRENT_TIMESTAMP
+WHERE EXISTS (
+    SELECT 1 FROM users o
+    WHERE lower(o.username) = lower(u.username)
+      AND (COALESCE(o.created_at, 'epoch'), o.id) < (COALESCE(u.created_at, 'epoch'), u.id)
+);
+DROP INDEX IF EXISTS idx_users_username;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower ON users(lower(username));
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_username_lower;
+CREATE INDEX IF NOT EXISTS idx_users_username ON users(username);
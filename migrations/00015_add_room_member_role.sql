@@ -0,0 +1,8 @@
+-- +goose Up
+-- A member's role in a room; moderators share the creator's moderation rights
+ALTER TABLE room_members ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'member';
+ALTER TABLE room_members ADD CONSTRAINT room_members_role_check CHECK (role IN ('member', 'moderator'));
+
+-- +goose Down
+ALTER TABLE room_members DROP CONSTRAINT IF EXISTS room_members_role_check;
+ALTER TABLE room_members DROP COLUMN IF EXISTS role;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- Trigram index so user search can match any part of a username
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING GIN (username gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_username_trgm;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- A user invited to a room; inviting them again replaces the invite
+CREATE TABLE IF NOT EXISTS room_invites (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    room_id UUID NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    inviter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    invitee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (room_id, invitee_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_invites_invitee_id ON room_invites(invitee_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS room_invites;
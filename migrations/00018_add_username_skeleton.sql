@@ -0,0 +1,13 @@
+-- +goose Up
+-- The skeleton of a username is its lowercase form with look-alike letters
+-- from other scripts replaced by Latin ones (validator.Skeleton), so "admin"
+-- and "аdmin" with a Cyrillic "а" share one. Usernames were ASCII before this
+-- migration, so the skeleton of an existing name is its lowercase form.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_skeleton TEXT;
+UPDATE users SET username_skeleton = lower(username) WHERE username_skeleton IS NULL;
+ALTER TABLE users ALTER COLUMN username_skeleton SET NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_skeleton ON users(username_skeleton);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_users_username_skeleton;
+ALTER TABLE users DROP COLUMN IF EXISTS username_skeleton;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- The most members a room allows, set when it is created. Rooms made before
+-- this migration have none and use the server's MAX_CLIENTS_PER_ROOM.
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS max_clients INTEGER;
+
+-- +goose Down
+ALTER TABLE rooms DROP COLUMN IF EXISTS max_clients;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- Lets the outbox publisher find published entries to delete without a scan
+CREATE INDEX IF NOT EXISTS idx_message_outbox_sent_at ON message_outbox(sent_at) WHERE sent_at IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_message_outbox_sent_at;
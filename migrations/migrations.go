@@ -0,0 +1,10 @@
+// Package migrations embeds the numbered SQL migrations so the server binary
+// can apply them without the files on disk
+package migrations
+
+import "embed"
+
+// FS holds every NNNNN_name.sql file in this directory
+//
+//go:embed *.sql
+var FS embed.FS
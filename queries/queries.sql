@@ -1,6 +1,6 @@
 -- name: CreateUser :one
-INSERT INTO users (username, email, password_hash)
-VALUES ($1, $2, $3)
+INSERT INTO users (username, email, password_hash, username_skeleton)
+VALUES ($1, $2, $3, $4)
 RETURNING *;
 
 -- name: GetUserByID :one
@@ -8,8 +8,9 @@ SELECT * FROM users
 WHERE id = $1;
 
 -- name: GetUserByUsername :one
+-- Usernames are matched regardless of case.
 SELECT * FROM users
-WHERE username = $1;
+WHERE lower(username) = lower(sqlc.arg(username));
 
 -- name: GetUserByEmail :one
 SELECT * FROM users
@@ -20,49 +21,163 @@ SELECT * FROM users
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2;
 
+-- name: SearchUsers :many
+-- Users whose name matches an ILIKE pattern, in name order
+SELECT * FROM users
+WHERE username ILIKE $1
+ORDER BY username
+LIMIT $2;
+
 -- name: CreateRoom :one
-INSERT INTO rooms (name, private, password_hash, creator_id)
-VALUES ($1, $2, $3, $4)
+INSERT INTO rooms (name, private, password_hash, creator_id, suppress_join_leave, max_clients)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING *;
 
 -- name: GetRoomByID :one
 SELECT * FROM rooms
-WHERE id = $1;
+WHERE id = $1 AND deleted_at IS NULL;
 
 -- name: GetRoomByName :one
 SELECT * FROM rooms
-WHERE name = $1;
+WHERE name = $1 AND deleted_at IS NULL;
+
+-- name: GetRoomsByNames :many
+-- The rooms with any of the given names that aren't deleted
+SELECT * FROM rooms
+WHERE name = ANY(sqlc.arg(names)::text[]) AND deleted_at IS NULL;
 
 -- name: ListRooms :many
 SELECT * FROM rooms
+WHERE deleted_at IS NULL
 ORDER BY created_at DESC
 LIMIT $1 OFFSET $2;
 
 -- name: ListRoomsByCreator :many
 SELECT * FROM rooms
-WHERE creator_id = $1
+WHERE creator_id = $1 AND deleted_at IS NULL
 ORDER BY created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- name: CountRooms :one
+-- Rooms not deleted, leaving out the named room such as the default room
+SELECT COUNT(*) AS count FROM rooms
+WHERE deleted_at IS NULL AND name <> sqlc.arg(excluded_name);
+
+-- name: CountRoomsByCreator :one
+SELECT COUNT(*) AS count FROM rooms
+WHERE creator_id = $1 AND deleted_at IS NULL;
+
 -- name: UpdateRoom :one
 UPDATE rooms
 SET name = $2, private = $3, password_hash = $4
 WHERE id = $1
 RETURNING *;
 
+-- name: UpdateRoomSuppressJoinLeave :exec
+UPDATE rooms
+SET suppress_join_leave = $2
+WHERE id = $1;
+
+-- name: UpdateRoomRetentionDays :exec
+UPDATE rooms
+SET retention_days = $2
+WHERE id = $1;
+
+-- name: UpdateRoomPassword :exec
+UPDATE rooms
+SET password_hash = $2
+WHERE id = $1;
+
 -- name: DeleteRoom :exec
+-- Permanently deletes a room with its messages, members, pins and polls
 DELETE FROM rooms
 WHERE id = $1;
 
+-- name: SoftDeleteRoom :execrows
+-- Hides a room from listings and joins while keeping its messages until
+-- PurgeDeletedRooms removes it
+UPDATE rooms
+SET deleted_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetDeletedRoomByName :one
+-- The most recently deleted room with the name, as several may share it
+SELECT * FROM rooms
+WHERE name = $1 AND deleted_at IS NOT NULL
+ORDER BY deleted_at DESC
+LIMIT 1;
+
+-- name: ListDeletedRooms :many
+SELECT * FROM rooms
+WHERE deleted_at IS NOT NULL
+ORDER BY deleted_at DESC;
+
+-- name: RestoreRoom :one
+UPDATE rooms
+SET deleted_at = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL
+RETURNING *;
+
+-- name: PurgeDeletedRooms :execrows
+-- Permanently deletes rooms soft-deleted before cutoff
+DELETE FROM rooms
+WHERE deleted_at < sqlc.arg(cutoff);
+
 -- name: CreateMessage :one
-INSERT INTO messages (room_id, user_id, content)
-VALUES ($1, $2, $3)
+INSERT INTO messages (room_id, user_id, content, parent_message_id)
+VALUES ($1, $2, $3, $4)
 RETURNING *;
 
+-- name: BulkCreateMessages :copyfrom
+INSERT INTO messages (id, room_id, user_id, content, created_at, parent_message_id)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: InsertMessages :exec
+-- Inserts a small batch of messages in one statement; larger batches use
+-- BulkCreateMessages
+INSERT INTO messages (id, room_id, user_id, content, created_at, parent_message_id)
+SELECT id, room_id, user_id, content, created_at, parent_message_id FROM unnest(
+    sqlc.arg(ids)::uuid[],
+    sqlc.arg(room_ids)::uuid[],
+    sqlc.arg(user_ids)::uuid[],
+    sqlc.arg(contents)::text[],
+    sqlc.arg(created_ats)::timestamptz[],
+    sqlc.arg(parent_message_ids)::uuid[]
+) AS m(id, room_id, user_id, content, created_at, parent_message_id);
+
 -- name: GetMessageByID :one
 SELECT * FROM messages
 WHERE id = $1;
 
+-- name: UpdateMessageContent :one
+UPDATE messages
+SET content = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteMessage :execrows
+-- Deleting a message unpins it and detaches its replies
+DELETE FROM messages
+WHERE id = $1;
+
+-- name: DeleteExpiredMessages :execrows
+-- Deletes up to batch_size messages older than cutoff, oldest first, from
+-- rooms without their own retention_days
+DELETE FROM messages
+WHERE id IN (
+    SELECT m.id
+    FROM messages m
+    JOIN rooms r ON m.room_id = r.id
+    WHERE m.created_at < sqlc.arg(cutoff) AND r.retention_days IS NULL
+    ORDER BY m.created_at
+    LIMIT sqlc.arg(batch_size)
+);
+
+-- name: CountMessagesByRoom :one
+SELECT COUNT(*) as count
+FROM messages
+WHERE room_id = $1;
+
 -- name: ListMessagesByRoom :many
 SELECT m.*, u.username, r.name as room_name
 FROM messages m
@@ -72,6 +187,26 @@ WHERE m.room_id = $1
 ORDER BY m.created_at DESC
 LIMIT $2 OFFSET $3;
 
+-- name: ListLatestMessagesByRooms :many
+-- The newest message of each of the given rooms, for room list previews.
+-- Rooms without messages, and private rooms the viewer isn't a member of, are
+-- left out.
+SELECT r.id AS room_id, last.id AS message_id, last.content, last.created_at, last.username
+FROM unnest(sqlc.arg(room_ids)::uuid[]) AS r(id)
+JOIN rooms ON rooms.id = r.id
+JOIN LATERAL (
+    SELECT m.id, m.content, m.created_at, u.username
+    FROM messages m
+    JOIN users u ON m.user_id = u.id
+    WHERE m.room_id = r.id
+    ORDER BY m.created_at DESC
+    LIMIT 1
+) last ON TRUE
+WHERE rooms.private IS NOT TRUE OR EXISTS(
+    SELECT 1 FROM room_members rm
+    WHERE rm.room_id = r.id AND rm.user_id = sqlc.arg(viewer_id)
+);
+
 -- name: ListRecentMessagesByRoom :many
 SELECT m.*, u.username, r.name as room_name
 FROM messages m
@@ -81,10 +216,42 @@ WHERE m.room_id = $1
 ORDER BY m.created_at DESC
 LIMIT $2;
 
+-- name: ListRoomExportPage :many
+-- A page of a room's messages newest first, before the message at
+-- (before_created_at, before_id); 'infinity' starts from the newest one.
+SELECT m.id, m.content, m.created_at, u.username
+FROM messages m
+JOIN users u ON m.user_id = u.id
+WHERE m.room_id = sqlc.arg(room_id)
+    AND (m.created_at, m.id) < (sqlc.arg(before_created_at)::timestamptz, sqlc.arg(before_id)::uuid)
+ORDER BY m.created_at DESC, m.id DESC
+LIMIT sqlc.arg(page_size);
+
+-- name: ListRoomTranscript :many
+-- A page of a room's messages oldest first, after the message at
+-- (after_created_at, after_id); '-infinity' starts from the first one.
+SELECT m.id, m.content, m.created_at, u.username
+FROM messages m
+JOIN users u ON m.user_id = u.id
+WHERE m.room_id = sqlc.arg(room_id)
+    AND (m.created_at, m.id) > (sqlc.arg(after_created_at)::timestamptz, sqlc.arg(after_id)::uuid)
+ORDER BY m.created_at, m.id
+LIMIT sqlc.arg(page_size);
+
+-- name: ListThreadMessages :many
+-- Replies to a message, oldest first.
+SELECT m.*, u.username
+FROM messages m
+JOIN users u ON m.user_id = u.id
+WHERE m.parent_message_id = $1
+ORDER BY m.created_at ASC
+LIMIT $2;
+
 -- name: AddRoomMember :one
-INSERT INTO room_members (room_id, user_id)
-VALUES ($1, $2)
-ON CONFLICT (room_id, user_id) DO UPDATE SET joined_at = EXCLUDED.joined_at
+-- Existing members keep their role; SetRoomMemberRole changes it
+INSERT INTO room_members (room_id, user_id, role)
+VALUES ($1, $2, $3)
+ON CONFLICT (room_id, user_id) DO UPDATE SET joined_at = EXCLUDED.joined_at, last_read_at = EXCLUDED.last_read_at
 RETURNING *;
 
 -- name: RemoveRoomMember :exec
@@ -98,6 +265,29 @@ JOIN users u ON rm.user_id = u.id
 WHERE rm.room_id = $1
 ORDER BY rm.joined_at ASC;
 
+-- name: GetRoomMembersWithRoles :many
+SELECT u.*, rm.joined_at, rm.role
+FROM room_members rm
+JOIN users u ON rm.user_id = u.id
+WHERE rm.room_id = $1
+ORDER BY rm.joined_at ASC;
+
+-- name: GetRoomMemberRole :one
+SELECT role FROM room_members
+WHERE room_id = $1 AND user_id = $2;
+
+-- name: SetRoomMemberRole :execrows
+UPDATE room_members
+SET role = $3
+WHERE room_id = $1 AND user_id = $2;
+
+-- name: CreateRoomInvite :one
+-- Inviting a user to a room again replaces their invite
+INSERT INTO room_invites (room_id, inviter_id, invitee_id)
+VALUES ($1, $2, $3)
+ON CONFLICT (room_id, invitee_id) DO UPDATE SET inviter_id = EXCLUDED.inviter_id, created_at = CURRENT_TIMESTAMP
+RETURNING *;
+
 -- name: IsRoomMember :one
 SELECT EXISTS(
     SELECT 1 FROM room_members
@@ -109,15 +299,209 @@ SELECT COUNT(*) as count
 FROM room_members
 WHERE room_id = $1;
 
+-- name: GetRoomMemberCounts :many
+-- Member counts of the given rooms; rooms without members are left out
+SELECT room_id, COUNT(*) as count
+FROM room_members
+WHERE room_id = ANY(sqlc.arg(room_ids)::uuid[])
+GROUP BY room_id;
+
+-- name: MarkRoomRead :exec
+-- Records that the user has caught up with the room's messages
+UPDATE room_members
+SET last_read_at = CURRENT_TIMESTAMP
+WHERE room_id = $1 AND user_id = $2;
+
+-- name: ListUserRoomSummaries :many
+-- The rooms a user is a member of with their member count, messages from
+-- others since the user last read the room and latest message, most recently
+-- active first
+SELECT r.id, r.name, r.private, rm.last_read_at,
+    (SELECT COUNT(*) FROM room_members c WHERE c.room_id = r.id) AS member_count,
+    (SELECT COUNT(*) FROM messages m
+        WHERE m.room_id = r.id AND m.created_at > rm.last_read_at AND m.user_id <> rm.user_id) AS unread_count,
+    last.id AS last_message_id, last.content AS last_message_content,
+    last.created_at AS last_message_at, last.username AS last_message_sender
+FROM room_members rm
+JOIN rooms r ON rm.room_id = r.id
+LEFT JOIN LATERAL (
+    SELECT m.id, m.content, m.created_at, u.username
+    FROM messages m
+    JOIN users u ON m.user_id = u.id
+    WHERE m.room_id = r.id
+    ORDER BY m.created_at DESC
+    LIMIT 1
+) last ON TRUE
+WHERE rm.user_id = $1 AND r.deleted_at IS NULL
+ORDER BY COALESCE(last.created_at, rm.joined_at) DESC;
+
 -- name: DeleteMessagesByRoom :exec
 DELETE FROM messages
 WHERE room_id = $1;
 
+-- name: PinMessage :one
+INSERT INTO pinned_messages (room_id, message_id, pinned_by)
+SELECT sqlc.arg(room_id)::uuid, sqlc.arg(message_id)::uuid, sqlc.arg(pinned_by)::uuid
+WHERE (SELECT COUNT(*) FROM pinned_messages WHERE room_id = sqlc.arg(room_id)::uuid) < sqlc.arg(max_pins)::bigint
+ON CONFLICT (room_id, message_id) DO NOTHING
+RETURNING *;
+
+-- name: UnpinMessage :execrows
+DELETE FROM pinned_messages
+WHERE room_id = $1 AND message_id = $2;
+
+-- name: IsMessagePinned :one
+SELECT EXISTS(
+    SELECT 1 FROM pinned_messages
+    WHERE room_id = $1 AND message_id = $2
+);
+
+-- name: ListPinnedMessages :many
+SELECT pm.message_id, pm.pinned_by, pm.pinned_at, m.content, m.created_at, u.username
+FROM pinned_messages pm
+JOIN messages m ON pm.message_id = m.id
+JOIN users u ON m.user_id = u.id
+WHERE pm.room_id = $1
+ORDER BY pm.pinned_at ASC;
+
+-- name: CreatePoll :one
+INSERT INTO polls (room_id, creator_id, question, options, ends_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetPollByID :one
+SELECT * FROM polls
+WHERE id = $1;
+
+-- name: UpsertPollVote :execrows
+INSERT INTO poll_votes (poll_id, user_id, option_index)
+SELECT p.id, sqlc.arg(user_id)::uuid, sqlc.arg(option_index)::int
+FROM polls p
+WHERE p.id = sqlc.arg(poll_id)::uuid AND NOT p.closed AND p.ends_at > NOW()
+ON CONFLICT (poll_id, user_id) DO UPDATE
+SET option_index = EXCLUDED.option_index, voted_at = CURRENT_TIMESTAMP;
+
+-- name: GetPollVoteCounts :many
+SELECT option_index, COUNT(*) as votes
+FROM poll_votes
+WHERE poll_id = $1
+GROUP BY option_index
+ORDER BY option_index;
+
+-- name: ListEndedPolls :many
+SELECT * FROM polls
+WHERE NOT closed AND ends_at <= NOW()
+ORDER BY ends_at ASC;
+
+-- name: ClosePoll :execrows
+UPDATE polls
+SET closed = TRUE
+WHERE id = $1 AND NOT closed;
+
+-- Flagged message queries
+
+-- name: CreateFlaggedMessage :exec
+INSERT INTO flagged_messages (room_id, user_id, username, content, matched_words)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ListFlaggedMessages :many
+-- The newest flagged messages first; room_name is empty for messages sent
+-- outside a room
+SELECT f.id, f.user_id, f.username, f.content, f.matched_words, f.flagged_at, COALESCE(r.name, '')::text AS room_name
+FROM flagged_messages f
+LEFT JOIN rooms r ON f.room_id = r.id
+ORDER BY f.flagged_at DESC
+LIMIT $1;
+
+-- Analytics queries. Days are UTC dates; from_time is inclusive and to_time exclusive.
+
+-- name: CountMessagesPerDay :many
+SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS messages
+FROM messages
+WHERE created_at >= sqlc.arg(from_time) AND created_at < sqlc.arg(to_time)
+GROUP BY day
+ORDER BY day;
+
+-- name: CountNewUsersPerDay :many
+SELECT (created_at AT TIME ZONE 'UTC')::date AS day, COUNT(*) AS users
+FROM users
+WHERE created_at >= sqlc.arg(from_time) AND created_at < sqlc.arg(to_time)
+GROUP BY day
+ORDER BY day;
+
+-- name: ListMostActiveRooms :many
+SELECT r.name, COUNT(*) AS messages
+FROM messages m
+JOIN rooms r ON m.room_id = r.id
+WHERE m.created_at >= sqlc.arg(from_time) AND m.created_at < sqlc.arg(to_time)
+GROUP BY r.id, r.name
+ORDER BY messages DESC, r.name
+LIMIT sqlc.arg(row_limit);
+
+-- name: CreateStatsSample :exec
+INSERT INTO stats_samples (server_id, peak_connections)
+VALUES ($1, $2)
+ON CONFLICT (server_id, sampled_at) DO UPDATE
+SET peak_connections = GREATEST(stats_samples.peak_connections, EXCLUDED.peak_connections);
+
+-- name: PeakConnectionsPerDay :many
+-- The highest cluster-wide connection count of each day, adding up the
+-- servers' samples taken in the same minute
+SELECT (minute AT TIME ZONE 'UTC')::date AS day, MAX(connections)::bigint AS peak_connections
+FROM (
+    SELECT date_trunc('minute', sampled_at) AS minute, SUM(peak_connections) AS connections
+    FROM stats_samples
+    WHERE sampled_at >= sqlc.arg(from_time) AND sampled_at < sqlc.arg(to_time)
+    GROUP BY minute
+) per_minute
+GROUP BY day
+ORDER BY day;
+
+-- name: DeleteStatsSamplesBefore :execrows
+DELETE FROM stats_samples
+WHERE sampled_at < $1;
+
+-- Message outbox queries
+
+-- name: CreateOutboxEntry :one
+INSERT INTO message_outbox (message_id, subject, payload)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: ClaimOutboxEntries :many
+UPDATE message_outbox
+SET next_attempt_at = NOW() + make_interval(secs => sqlc.arg(lease_seconds)::float8)
+WHERE id IN (
+    SELECT id FROM message_outbox
+    WHERE sent_at IS NULL AND next_attempt_at <= NOW()
+    ORDER BY id
+    LIMIT sqlc.arg(batch_size)
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxSent :exec
+UPDATE message_outbox
+SET sent_at = NOW()
+WHERE id = $1;
+
+-- name: MarkOutboxFailed :exec
+UPDATE message_outbox
+SET attempts = attempts + 1,
+    last_error = sqlc.arg(last_error),
+    next_attempt_at = NOW() + make_interval(secs => sqlc.arg(retry_seconds)::float8)
+WHERE id = sqlc.arg(id);
+
+-- name: DeleteSentOutboxEntriesBefore :execrows
+-- Removes entries published before cutoff
+DELETE FROM message_outbox
+WHERE sent_at < sqlc.arg(cutoff);
+
 -- User profile management queries
 
 -- name: UpdateUserUsername :one
 UPDATE users
-SET username = $2
+SET username = $2, username_skeleton = $3
 WHERE id = $1
 RETURNING *;
 
@@ -131,4 +515,33 @@ RETURNING *;
 UPDATE users
 SET last_login = $2
 WHERE id = $1
-RETURNING *;
\ No newline at end of file
+RETURNING *;
+
+-- name: DeleteUser :execrows
+-- Deleting a user cascades to their messages, room memberships and poll
+-- votes; rooms, pins and polls they created are kept with no owner
+DELETE FROM users
+WHERE id = $1;
+
+-- Email verification queries
+
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (token, user_id, expires_at)
+VALUES ($1, $2, $3);
+
+-- name: DeleteEmailVerificationToken :one
+-- Deleting the token as it is read means a link can only be used once
+DELETE FROM email_verification_tokens
+WHERE token = $1
+RETURNING *;
+
+-- name: SetUserEmailVerified :one
+UPDATE users
+SET email_verified = true
+WHERE id = $1
+RETURNING *;
+
+-- name: TryAdvisoryXactLock :one
+-- Takes an advisory lock held until the current transaction ends, without
+-- waiting if another session holds it
+SELECT pg_try_advisory_xact_lock(sqlc.arg(lock_id)::bigint) AS locked;